@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/rmonnet/glox/interp"
+)
+
+// runBench runs the "glox bench" subcommand. It runs a script
+// several times, each time in a new interpreter with its output
+// discarded, and reports the wall time and the allocations of
+// the runs (the warmup runs are not measured).
+func runBench(args []string) {
+
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	runs := flags.Int("n", 10, "number of measured runs")
+	warmup := flags.Int("warmup", 1, "number of runs before measuring")
+	backendName := flags.String("backend", "tree",
+		"execute the script with the tree-walker (tree) or the bytecode VM (vm)")
+	flags.Parse(args)
+	backend, validBackend := parseBackend(*backendName)
+
+	if flags.NArg() != 1 || *runs <= 0 || *warmup < 0 || !validBackend {
+		fmt.Println("Usage glox bench [-n runs] [-warmup runs] [-backend tree|vm] script")
+		os.Exit(exUsage)
+	}
+
+	filename := flags.Arg(0)
+	script, err := ioutil.ReadFile(filename)
+	if err != nil {
+		fmt.Println("unable to read ", filename)
+		os.Exit(exDataErr)
+	}
+
+	for n := 0; n < *warmup; n++ {
+		benchRun(string(script), backend)
+	}
+
+	var total, min, max time.Duration
+	var allocs, bytes uint64
+	for n := 0; n < *runs; n++ {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		elapsed := benchRun(string(script), backend)
+		runtime.ReadMemStats(&after)
+
+		total += elapsed
+		if n == 0 || elapsed < min {
+			min = elapsed
+		}
+		if elapsed > max {
+			max = elapsed
+		}
+		allocs += after.Mallocs - before.Mallocs
+		bytes += after.TotalAlloc - before.TotalAlloc
+	}
+
+	count := uint64(*runs)
+	fmt.Printf("%s: %d runs\n", filename, *runs)
+	fmt.Printf("  min %v, mean %v, max %v\n", min, total/time.Duration(*runs), max)
+	fmt.Printf("  %d allocs/run, %d bytes/run\n", allocs/count, bytes/count)
+}
+
+// benchRun runs the script once in a new interpreter with the
+// backend and returns its wall time. The benchmark stops if the
+// script fails.
+func benchRun(script string, backend interp.Backend) time.Duration {
+
+	lox := interp.New(ioutil.Discard, os.Stderr)
+	lox.SetBackend(backend)
+	start := time.Now()
+	lox.Run(script, false)
+	elapsed := time.Since(start)
+	if lox.HadCompileError() {
+		os.Exit(exDataErr)
+	}
+	if lox.HadRuntimeError() {
+		os.Exit(exSwErr)
+	}
+	return elapsed
+}
@@ -0,0 +1,168 @@
+// Package bytecode compiles the lox AST to bytecode chunks
+// run by the virtual machine of the interp package, like clox
+// in the second part of the original text.
+package bytecode
+
+import "github.com/rmonnet/glox/lang"
+
+// OpCode is the first byte of an instruction, the operands
+// (if any) follow it in the chunk.
+type OpCode byte
+
+// Operands are one byte (local slots, upvalue indexes, argument
+// counts) or two bytes (constant indexes, jump offsets), stored
+// high byte first.
+const (
+	// OpConstant pushes the constant at the two byte index.
+	OpConstant OpCode = iota
+	// OpNil pushes nil.
+	OpNil
+	// OpTrue pushes true.
+	OpTrue
+	// OpFalse pushes false.
+	OpFalse
+	// OpUnassigned pushes the value of a variable declared without
+	// initializer, nil unless the interpreter checks for reads
+	// before assignment.
+	OpUnassigned
+	// OpPop discards the top of the stack.
+	OpPop
+	// OpGetLocal pushes the local variable at the one byte slot.
+	OpGetLocal
+	// OpSetLocal assigns the top of the stack to the local variable
+	// at the one byte slot, the value is left on the stack.
+	OpSetLocal
+	// OpGetGlobal pushes the global variable named by the constant.
+	OpGetGlobal
+	// OpDefineGlobal pops the top of the stack into a new global
+	// variable named by the constant.
+	OpDefineGlobal
+	// OpSetGlobal assigns the top of the stack to the existing
+	// global variable named by the constant.
+	OpSetGlobal
+	// OpGetUpvalue pushes the variable captured at the one byte index.
+	OpGetUpvalue
+	// OpSetUpvalue assigns the top of the stack to the variable
+	// captured at the one byte index.
+	OpSetUpvalue
+	// OpGetProperty replaces the instance on top of the stack with
+	// its field or method named by the constant.
+	OpGetProperty
+	// OpSetProperty assigns the top of the stack to the field named
+	// by the constant of the instance below it, leaving the value.
+	OpSetProperty
+	// OpGetSuper pops the superclass and replaces the instance below
+	// it with the superclass method named by the constant.
+	OpGetSuper
+	// OpEqual pops two values and pushes whether they are equal.
+	OpEqual
+	// OpGreater compares the two numbers on top of the stack.
+	OpGreater
+	// OpGreaterEqual compares the two numbers on top of the stack.
+	OpGreaterEqual
+	// OpLess compares the two numbers on top of the stack.
+	OpLess
+	// OpLessEqual compares the two numbers on top of the stack.
+	OpLessEqual
+	// OpAdd adds two numbers or concatenates two strings.
+	OpAdd
+	// OpSubtract subtracts the two numbers on top of the stack.
+	OpSubtract
+	// OpMultiply multiplies the two numbers on top of the stack.
+	OpMultiply
+	// OpDivide divides the two numbers on top of the stack.
+	OpDivide
+	// OpNot replaces the top of the stack with its negation.
+	OpNot
+	// OpNegate replaces the number on top of the stack with
+	// its opposite.
+	OpNegate
+	// OpPrint pops the top of the stack and prints it.
+	OpPrint
+	// OpJump jumps forward by the two byte offset.
+	OpJump
+	// OpJumpIfFalse jumps forward by the two byte offset if the
+	// top of the stack is false, which is left on the stack.
+	OpJumpIfFalse
+	// OpLoop jumps backward by the two byte offset.
+	OpLoop
+	// OpCall calls the value below the arguments, the operand
+	// is the number of arguments.
+	OpCall
+	// OpInvoke calls the method named by the constant on the
+	// instance below the arguments, the second operand is the
+	// number of arguments.
+	OpInvoke
+	// OpSuperInvoke pops the superclass and calls its method named
+	// by the constant on the instance below the arguments, the
+	// second operand is the number of arguments.
+	OpSuperInvoke
+	// OpClosure pushes a closure of the function constant. It is
+	// followed by two bytes for each upvalue: 1 if it captures
+	// a local variable of the enclosing function (0 if it captures
+	// one of its upvalues) and the slot or upvalue index.
+	OpClosure
+	// OpCloseUpvalue moves the local variable on top of the stack
+	// to the heap since it is captured by a closure, and pops it.
+	OpCloseUpvalue
+	// OpReturn returns the top of the stack from the function.
+	OpReturn
+	// OpClass pushes a new class named by the constant.
+	OpClass
+	// OpInherit copies the methods of the superclass below the
+	// class on top of the stack and pops the class.
+	OpInherit
+	// OpMethod pops the closure on top of the stack and adds it to
+	// the class below it as the method named by the constant.
+	OpMethod
+)
+
+// Chunk is a sequence of instructions with the constants
+// they reference: numbers (float64), strings and functions
+// (*Function).
+// Tokens holds the token each byte of code was compiled from,
+// which locates the runtime errors in the source.
+type Chunk struct {
+	Code      []byte
+	Constants []interface{}
+	Tokens    []*lang.Token
+}
+
+// Function is a compiled lox function. The script itself is
+// compiled to a function without name.
+// Cache is owned by the virtual machine, which keeps the constants
+// converted to its own values there.
+type Function struct {
+	Name         string
+	Line         int
+	Arity        int
+	UpvalueCount int
+	Chunk        Chunk
+	Cache        interface{}
+}
+
+// String returns a printable representation of the function.
+func (f *Function) String() string {
+
+	if f.Name == "" {
+		return "<script>"
+	}
+	return "<fun " + f.Name + ">"
+}
+
+// Line returns the line of the code at the offset, 0 if it
+// is not known.
+func (c *Chunk) Line(offset int) int {
+
+	if token := c.Tokens[offset]; token != nil {
+		return token.Line
+	}
+	return 0
+}
+
+// write appends a byte compiled from the token to the chunk.
+func (c *Chunk) write(b byte, token *lang.Token) {
+
+	c.Code = append(c.Code, b)
+	c.Tokens = append(c.Tokens, token)
+}
@@ -0,0 +1,770 @@
+package bytecode
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/rmonnet/glox/lang"
+)
+
+// limits of the bytecode operands.
+const (
+	maxLocals    = 256
+	maxUpvalues  = 256
+	maxConstants = 65536
+	maxJump      = 65535
+)
+
+// The codes of the diagnostics reported by the compiler when
+// the limits are exceeded.
+const (
+	CodeTooManyLocals    = "compile/too-many-locals"
+	CodeTooManyUpvalues  = "compile/too-many-upvalues"
+	CodeJumpTooFar       = "compile/jump-too-far"
+	CodeLoopTooLarge     = "compile/loop-too-large"
+	CodeTooManyConstants = "compile/too-many-constants"
+)
+
+// functionType tells the compiler what kind of code
+// it is compiling.
+type functionType int
+
+const (
+	typeScript functionType = iota
+	typeFunction
+	typeMethod
+	typeInitializer
+)
+
+// local is a local variable of the function being compiled.
+// Its slot is its index in the locals of the function.
+// depth is the depth of the scope where it is declared,
+// -1 until it is initialized.
+type local struct {
+	name     string
+	depth    int
+	captured bool
+}
+
+// upvalue is a variable captured by the function being compiled,
+// a local variable of the enclosing function or one of its upvalues.
+type upvalue struct {
+	index int
+	local bool
+}
+
+// constantKey identifies a number or string constant so it is only
+// added once to a chunk. Numbers are compared by bits so 0 and -0
+// remain two different constants.
+type constantKey struct {
+	number uint64
+	str    string
+	isStr  bool
+}
+
+// classCompiler tracks the class whose methods are being compiled.
+type classCompiler struct {
+	enclosing     *classCompiler
+	hasSuperclass bool
+}
+
+// loopCompiler tracks the loop whose body is being compiled.
+// scopeDepth is the depth of the scope enclosing the loop, breaks
+// are the jumps of its break statements, patched at its end.
+type loopCompiler struct {
+	enclosing  *loopCompiler
+	scopeDepth int
+	breaks     []int
+}
+
+// compiler compiles the statements of a function (or the script)
+// into its chunk.
+type compiler struct {
+	enclosing  *compiler
+	function   *Function
+	kind       functionType
+	locals     []local
+	upvalues   []upvalue
+	scopeDepth int
+	constants  map[constantKey]int
+	class      *classCompiler
+	loop       *loopCompiler
+	// token is the token the code being emitted is compiled from.
+	token *lang.Token
+}
+
+// compileError is used to stop the compiler on the first error.
+type compileError struct {
+	diagnostic lang.Diagnostic
+}
+
+// Compile compiles a resolved script to bytecode. The script must
+// have been checked by the resolver, the compiler only reports the
+// errors due to the limits of the bytecode (like the number of local
+// variables in a function), it stops at the first one.
+func Compile(statements []lang.Stmt) (function *Function, diagnostics []lang.Diagnostic) {
+
+	defer func() {
+		if e := recover(); e != nil {
+			err, ok := e.(compileError)
+			if !ok {
+				panic(e)
+			}
+			function, diagnostics = nil, []lang.Diagnostic{err.diagnostic}
+		}
+	}()
+
+	c := newCompiler(nil, typeScript, "", nil)
+	c.statements(statements)
+	return c.end(), nil
+}
+
+// newCompiler creates a compiler for a function nested in the
+// function compiled by enclosing (nil for the script).
+func newCompiler(enclosing *compiler, kind functionType, name string,
+	token *lang.Token) *compiler {
+
+	c := &compiler{
+		enclosing: enclosing,
+		function:  &Function{Name: name},
+		kind:      kind,
+		constants: make(map[constantKey]int),
+		token:     token,
+	}
+	if token != nil {
+		c.function.Line = token.Line
+	}
+	if enclosing != nil {
+		c.class = enclosing.class
+	}
+	// the first slot holds the function being called,
+	// or the instance for a method.
+	slotName := ""
+	if kind == typeMethod || kind == typeInitializer {
+		slotName = "this"
+	}
+	c.locals = append(c.locals, local{slotName, 0, false})
+	return c
+}
+
+// end terminates the function and returns it.
+func (c *compiler) end() *Function {
+
+	c.emitReturn()
+	c.function.UpvalueCount = len(c.upvalues)
+	return c.function
+}
+
+// ------------
+// Statements
+// ------------
+
+// statements compiles a list of statements.
+func (c *compiler) statements(statements []lang.Stmt) {
+
+	for _, stmt := range statements {
+		c.statement(stmt)
+	}
+}
+
+// statement compiles a statement.
+func (c *compiler) statement(stmt lang.Stmt) {
+
+	if token := lang.StmtStart(stmt); token != nil {
+		c.token = token
+	}
+
+	switch s := stmt.(type) {
+	case *lang.BlockStmt:
+		c.beginScope()
+		c.statements(s.Statements)
+		c.endScope()
+	case *lang.BreakStmt:
+		c.breakStmt()
+	case *lang.ClassDeclStmt:
+		c.classDecl(s)
+	case *lang.ExprStmt:
+		c.expression(s.Expression)
+		c.emitOp(OpPop)
+	case *lang.FunDeclStmt:
+		c.declareVariable(s.Name.Lexeme)
+		c.markInitialized()
+		c.functionDecl(s, typeFunction)
+		c.defineVariable(s.Name.Lexeme)
+	case *lang.IfStmt:
+		c.ifStmt(s)
+	case *lang.PrintStmt:
+		c.expression(s.Expression)
+		c.token = s.Keyword
+		c.emitOp(OpPrint)
+	case *lang.ReturnStmt:
+		if s.Value == nil {
+			c.emitReturn()
+		} else {
+			c.expression(s.Value)
+			c.emitOp(OpReturn)
+		}
+	case *lang.VarDeclStmt:
+		c.declareVariable(s.Name.Lexeme)
+		if s.Initializer != nil {
+			c.expression(s.Initializer)
+		} else {
+			c.token = s.Name
+			c.emitOp(OpUnassigned)
+		}
+		c.defineVariable(s.Name.Lexeme)
+	case *lang.WhileStmt:
+		c.whileStmt(s)
+	default:
+		panic(fmt.Sprintf("Unknown Statement Type: %T", stmt))
+	}
+}
+
+// ifStmt compiles an if statement.
+func (c *compiler) ifStmt(stmt *lang.IfStmt) {
+
+	c.expression(stmt.Condition)
+	thenJump := c.emitJump(OpJumpIfFalse)
+	c.emitOp(OpPop)
+	c.statement(stmt.ThenBranch)
+	elseJump := c.emitJump(OpJump)
+	c.patchJump(thenJump)
+	c.emitOp(OpPop)
+	if stmt.ElseBranch != nil {
+		c.statement(stmt.ElseBranch)
+	}
+	c.patchJump(elseJump)
+}
+
+// whileStmt compiles a while statement.
+func (c *compiler) whileStmt(stmt *lang.WhileStmt) {
+
+	c.loop = &loopCompiler{enclosing: c.loop, scopeDepth: c.scopeDepth}
+	defer func() { c.loop = c.loop.enclosing }()
+
+	loopStart := len(c.function.Chunk.Code)
+	c.expression(stmt.Condition)
+	exitJump := c.emitJump(OpJumpIfFalse)
+	c.emitOp(OpPop)
+	c.statement(stmt.Body)
+	c.token = stmt.Keyword
+	c.emitLoop(loopStart)
+	c.patchJump(exitJump)
+	c.emitOp(OpPop)
+	// the condition was popped before the body,
+	// the breaks jump after the pop.
+	for _, jump := range c.loop.breaks {
+		c.patchJump(jump)
+	}
+}
+
+// breakStmt compiles a break statement: the local variables
+// declared in the loop are discarded, like at the end of their
+// scopes, and the execution jumps after the loop.
+func (c *compiler) breakStmt() {
+
+	for n := len(c.locals) - 1; n >= 0 && c.locals[n].depth > c.loop.scopeDepth; n-- {
+		if c.locals[n].captured {
+			c.emitOp(OpCloseUpvalue)
+		} else {
+			c.emitOp(OpPop)
+		}
+	}
+	c.loop.breaks = append(c.loop.breaks, c.emitJump(OpJump))
+}
+
+// functionDecl compiles the declaration of a function or a method
+// and emits the creation of its closure.
+func (c *compiler) functionDecl(decl *lang.FunDeclStmt, kind functionType) {
+
+	fc := newCompiler(c, kind, decl.Name.Lexeme, decl.Name)
+	fc.beginScope()
+	for _, param := range decl.Params {
+		fc.declareVariable(param.Lexeme)
+		fc.markInitialized()
+	}
+	fc.function.Arity = len(decl.Params)
+	fc.statements(decl.Body)
+	function := fc.end()
+
+	c.token = decl.Name
+	c.emitOp(OpClosure)
+	c.emitShort(c.addConstant(function))
+	for _, u := range fc.upvalues {
+		if u.local {
+			c.emitByte(1)
+		} else {
+			c.emitByte(0)
+		}
+		c.emitByte(byte(u.index))
+	}
+}
+
+// classDecl compiles a class declaration. The superclass is kept
+// in a local variable named "super" for the duration of the class
+// body, which the methods capture.
+func (c *compiler) classDecl(stmt *lang.ClassDeclStmt) {
+
+	name := stmt.Name.Lexeme
+	nameConstant := c.addConstant(name)
+	c.declareVariable(name)
+	c.emitOp(OpClass)
+	c.emitShort(nameConstant)
+	c.defineVariable(name)
+
+	c.class = &classCompiler{enclosing: c.class}
+	defer func() { c.class = c.class.enclosing }()
+
+	if stmt.Superclass != nil {
+		c.namedVariable(stmt.Superclass.Name, false)
+		c.beginScope()
+		c.addLocal("super")
+		c.markInitialized()
+		c.namedVariable(stmt.Name, false)
+		c.token = stmt.Superclass.Name
+		c.emitOp(OpInherit)
+		c.class.hasSuperclass = true
+	}
+
+	c.namedVariable(stmt.Name, false)
+	for _, method := range stmt.Methods {
+		kind := typeMethod
+		if method.Name.Lexeme == "init" {
+			kind = typeInitializer
+		}
+		c.functionDecl(method, kind)
+		c.emitOp(OpMethod)
+		c.emitShort(c.addConstant(method.Name.Lexeme))
+	}
+	c.emitOp(OpPop)
+
+	if c.class.hasSuperclass {
+		c.endScope()
+	}
+}
+
+// -------------
+// Expressions
+// -------------
+
+// expression compiles an expression, its value is left
+// on the stack.
+func (c *compiler) expression(expr lang.Expr) {
+
+	switch e := expr.(type) {
+	case *lang.AssignExpr:
+		c.expression(e.Value)
+		c.namedVariable(e.Name, true)
+	case *lang.BinaryExpr:
+		c.binary(e)
+	case *lang.CallExpr:
+		c.call(e)
+	case *lang.GetExpr:
+		c.expression(e.Object)
+		c.token = e.Name
+		c.emitOp(OpGetProperty)
+		c.emitShort(c.addConstant(e.Name.Lexeme))
+	case *lang.GroupingExpr:
+		c.expression(e.Expression)
+	case *lang.Lit:
+		c.literal(e)
+	case *lang.LogicalExpr:
+		c.logical(e)
+	case *lang.SetExpr:
+		c.expression(e.Object)
+		c.expression(e.Value)
+		c.token = e.Name
+		c.emitOp(OpSetProperty)
+		c.emitShort(c.addConstant(e.Name.Lexeme))
+	case *lang.SuperExpr:
+		c.namedVariable(thisToken(e.Keyword), false)
+		c.namedVariable(e.Keyword, false)
+		c.token = e.Method
+		c.emitOp(OpGetSuper)
+		c.emitShort(c.addConstant(e.Method.Lexeme))
+	case *lang.ThisExpr:
+		c.namedVariable(e.Keyword, false)
+	case *lang.UnaryExpr:
+		c.expression(e.Expression)
+		c.token = e.Operator
+		if e.Operator.Type == lang.MinusToken {
+			c.emitOp(OpNegate)
+		} else {
+			c.emitOp(OpNot)
+		}
+	case *lang.VarExpr:
+		c.namedVariable(e.Name, false)
+	default:
+		panic(fmt.Sprintf("Unknown Expression Type: %T", expr))
+	}
+}
+
+// literal compiles a literal.
+func (c *compiler) literal(lit *lang.Lit) {
+
+	if lit.Token != nil {
+		c.token = lit.Token
+	}
+	switch value := lit.Value.(type) {
+	case nil:
+		c.emitOp(OpNil)
+	case bool:
+		if value {
+			c.emitOp(OpTrue)
+		} else {
+			c.emitOp(OpFalse)
+		}
+	default:
+		c.emitOp(OpConstant)
+		c.emitShort(c.addConstant(value))
+	}
+}
+
+// binary compiles a binary expression.
+func (c *compiler) binary(expr *lang.BinaryExpr) {
+
+	c.expression(expr.LeftExpression)
+	c.expression(expr.RightExpression)
+	c.token = expr.Operator
+	switch expr.Operator.Type {
+	case lang.PlusToken:
+		c.emitOp(OpAdd)
+	case lang.MinusToken:
+		c.emitOp(OpSubtract)
+	case lang.StarToken:
+		c.emitOp(OpMultiply)
+	case lang.SlashToken:
+		c.emitOp(OpDivide)
+	case lang.GreaterToken:
+		c.emitOp(OpGreater)
+	case lang.GreaterEqualToken:
+		c.emitOp(OpGreaterEqual)
+	case lang.LessToken:
+		c.emitOp(OpLess)
+	case lang.LessEqualToken:
+		c.emitOp(OpLessEqual)
+	case lang.EqualEqualToken:
+		c.emitOp(OpEqual)
+	case lang.BangEqualToken:
+		c.emitOp(OpEqual)
+		c.emitOp(OpNot)
+	default:
+		panic(fmt.Sprintf("Unknown Binary Operator %v", expr.Operator))
+	}
+}
+
+// logical compiles a logical expression, the right operand is
+// skipped if the left one determines the result.
+func (c *compiler) logical(expr *lang.LogicalExpr) {
+
+	c.expression(expr.LeftExpression)
+	c.token = expr.Operator
+	if expr.Operator.Type == lang.AndToken {
+		endJump := c.emitJump(OpJumpIfFalse)
+		c.emitOp(OpPop)
+		c.expression(expr.RightExpression)
+		c.patchJump(endJump)
+		return
+	}
+	elseJump := c.emitJump(OpJumpIfFalse)
+	endJump := c.emitJump(OpJump)
+	c.patchJump(elseJump)
+	c.emitOp(OpPop)
+	c.expression(expr.RightExpression)
+	c.patchJump(endJump)
+}
+
+// call compiles a call. Method calls are compiled to a single
+// instruction which doesn't create the bound method.
+// The name of the method is compiled from its token, the
+// instruction from the parenthesis so errors are reported
+// at the right place.
+func (c *compiler) call(expr *lang.CallExpr) {
+
+	var op OpCode
+	var name *lang.Token
+	switch callee := expr.Callee.(type) {
+	case *lang.GetExpr:
+		c.expression(callee.Object)
+		op, name = OpInvoke, callee.Name
+	case *lang.SuperExpr:
+		c.namedVariable(thisToken(callee.Keyword), false)
+		op, name = OpSuperInvoke, callee.Method
+	default:
+		c.expression(expr.Callee)
+		op = OpCall
+	}
+
+	for _, argument := range expr.Arguments {
+		c.expression(argument)
+	}
+	if op == OpSuperInvoke {
+		c.namedVariable(expr.Callee.(*lang.SuperExpr).Keyword, false)
+	}
+
+	c.token = expr.Paren
+	c.emitOp(op)
+	if name != nil {
+		c.token = name
+		c.emitShort(c.addConstant(name.Lexeme))
+		c.token = expr.Paren
+	}
+	c.emitByte(byte(len(expr.Arguments)))
+}
+
+// -----------
+// Variables
+// -----------
+
+// namedVariable emits the code reading or assigning a variable
+// (the value is on the stack).
+func (c *compiler) namedVariable(name *lang.Token, assign bool) {
+
+	c.token = name
+	getOp, setOp := OpGetLocal, OpSetLocal
+	arg := c.resolveLocal(name.Lexeme)
+	if arg < 0 {
+		arg = c.resolveUpvalue(name.Lexeme)
+		getOp, setOp = OpGetUpvalue, OpSetUpvalue
+	}
+	if arg < 0 {
+		getOp, setOp = OpGetGlobal, OpSetGlobal
+		arg = c.addConstant(name.Lexeme)
+	}
+
+	op := getOp
+	if assign {
+		op = setOp
+	}
+	c.emitOp(op)
+	if op == OpGetGlobal || op == OpSetGlobal {
+		c.emitShort(arg)
+	} else {
+		c.emitByte(byte(arg))
+	}
+}
+
+// declareVariable declares a local variable, globals are
+// late bound and don't need to be declared.
+func (c *compiler) declareVariable(name string) {
+
+	if c.scopeDepth == 0 {
+		return
+	}
+	c.addLocal(name)
+}
+
+// addLocal adds a local variable, not initialized yet.
+func (c *compiler) addLocal(name string) {
+
+	if len(c.locals) == maxLocals {
+		c.error(CodeTooManyLocals, "Too many local variables in function.")
+	}
+	c.locals = append(c.locals, local{name, -1, false})
+}
+
+// markInitialized marks the last local variable as initialized.
+func (c *compiler) markInitialized() {
+
+	if c.scopeDepth == 0 {
+		return
+	}
+	c.locals[len(c.locals)-1].depth = c.scopeDepth
+}
+
+// defineVariable defines the variable declared last with the
+// value on top of the stack. A local variable is already in
+// its slot.
+func (c *compiler) defineVariable(name string) {
+
+	if c.scopeDepth > 0 {
+		c.markInitialized()
+		return
+	}
+	c.emitOp(OpDefineGlobal)
+	c.emitShort(c.addConstant(name))
+}
+
+// resolveLocal returns the slot of a local variable of the function,
+// or -1 if it is not found.
+func (c *compiler) resolveLocal(name string) int {
+
+	for slot := len(c.locals) - 1; slot >= 0; slot-- {
+		if c.locals[slot].name == name {
+			return slot
+		}
+	}
+	return -1
+}
+
+// resolveUpvalue returns the index of a variable captured from
+// the enclosing functions, or -1 if it is not found (a global).
+func (c *compiler) resolveUpvalue(name string) int {
+
+	if c.enclosing == nil {
+		return -1
+	}
+	if slot := c.enclosing.resolveLocal(name); slot >= 0 {
+		c.enclosing.locals[slot].captured = true
+		return c.addUpvalue(slot, true)
+	}
+	if index := c.enclosing.resolveUpvalue(name); index >= 0 {
+		return c.addUpvalue(index, false)
+	}
+	return -1
+}
+
+// addUpvalue adds a captured variable to the function, if it is not
+// already captured, and returns its index.
+func (c *compiler) addUpvalue(index int, isLocal bool) int {
+
+	for n, u := range c.upvalues {
+		if u.index == index && u.local == isLocal {
+			return n
+		}
+	}
+	if len(c.upvalues) == maxUpvalues {
+		c.error(CodeTooManyUpvalues, "Too many closure variables in function.")
+	}
+	c.upvalues = append(c.upvalues, upvalue{index, isLocal})
+	return len(c.upvalues) - 1
+}
+
+// beginScope enters a block.
+func (c *compiler) beginScope() {
+
+	c.scopeDepth++
+}
+
+// endScope leaves a block, discarding its local variables.
+// The variables captured by closures are moved to the heap.
+func (c *compiler) endScope() {
+
+	c.scopeDepth--
+	for len(c.locals) > 0 && c.locals[len(c.locals)-1].depth > c.scopeDepth {
+		if c.locals[len(c.locals)-1].captured {
+			c.emitOp(OpCloseUpvalue)
+		} else {
+			c.emitOp(OpPop)
+		}
+		c.locals = c.locals[:len(c.locals)-1]
+	}
+}
+
+// ------------------
+// Helper functions
+// ------------------
+
+// emitByte appends a byte to the chunk of the function.
+func (c *compiler) emitByte(b byte) {
+
+	c.function.Chunk.write(b, c.token)
+}
+
+// emitOp appends an opcode to the chunk of the function.
+func (c *compiler) emitOp(op OpCode) {
+
+	c.emitByte(byte(op))
+}
+
+// emitShort appends a two bytes operand.
+func (c *compiler) emitShort(operand int) {
+
+	c.emitByte(byte(operand >> 8))
+	c.emitByte(byte(operand))
+}
+
+// emitReturn emits the return at the end of a function, which
+// returns the instance for an initializer and nil otherwise.
+func (c *compiler) emitReturn() {
+
+	if c.kind == typeInitializer {
+		c.emitOp(OpGetLocal)
+		c.emitByte(0)
+	} else {
+		c.emitOp(OpNil)
+	}
+	c.emitOp(OpReturn)
+}
+
+// emitJump emits a forward jump and returns the offset of its
+// operand, patched once the target is known.
+func (c *compiler) emitJump(op OpCode) int {
+
+	c.emitOp(op)
+	c.emitShort(0xffff)
+	return len(c.function.Chunk.Code) - 2
+}
+
+// patchJump makes the jump at offset go to the end of the chunk.
+func (c *compiler) patchJump(offset int) {
+
+	jump := len(c.function.Chunk.Code) - offset - 2
+	if jump > maxJump {
+		c.error(CodeJumpTooFar, "Too much code to jump over.")
+	}
+	c.function.Chunk.Code[offset] = byte(jump >> 8)
+	c.function.Chunk.Code[offset+1] = byte(jump)
+}
+
+// emitLoop emits a backward jump to loopStart.
+func (c *compiler) emitLoop(loopStart int) {
+
+	c.emitOp(OpLoop)
+	jump := len(c.function.Chunk.Code) - loopStart + 2
+	if jump > maxJump {
+		c.error(CodeLoopTooLarge, "Loop body too large.")
+	}
+	c.emitShort(jump)
+}
+
+// addConstant adds a constant to the chunk of the function and
+// returns its index. Numbers and strings are only added once.
+func (c *compiler) addConstant(value interface{}) int {
+
+	var key constantKey
+	switch v := value.(type) {
+	case float64:
+		key = constantKey{number: math.Float64bits(v)}
+	case string:
+		key = constantKey{str: v, isStr: true}
+	default:
+		return c.appendConstant(value)
+	}
+	if index, ok := c.constants[key]; ok {
+		return index
+	}
+	index := c.appendConstant(value)
+	c.constants[key] = index
+	return index
+}
+
+// appendConstant appends a constant to the chunk of the function
+// and returns its index.
+func (c *compiler) appendConstant(value interface{}) int {
+
+	chunk := &c.function.Chunk
+	if len(chunk.Constants) == maxConstants {
+		c.error(CodeTooManyConstants, "Too many constants in one chunk.")
+	}
+	chunk.Constants = append(chunk.Constants, value)
+	return len(chunk.Constants) - 1
+}
+
+// thisToken returns a token for the 'this' implicitly used
+// by the 'super' keyword.
+func thisToken(super *lang.Token) *lang.Token {
+
+	return &lang.Token{Type: lang.ThisToken, Lexeme: "this",
+		Line: super.Line, Column: super.Column}
+}
+
+// error stops the compilation with an error at the current token.
+func (c *compiler) error(code string, msg string) {
+
+	token := c.token
+	if token == nil {
+		token = &lang.Token{Type: lang.EndToken}
+	}
+	panic(compileError{lang.NewDiagnostic(lang.ErrorSeverity, token, code, msg)})
+}
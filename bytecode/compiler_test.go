@@ -0,0 +1,118 @@
+package bytecode
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/rmonnet/glox/lang"
+)
+
+func TestCompile(t *testing.T) {
+
+	t.Run("compile expression statement", func(t *testing.T) {
+		function := compile(t, "print 1 + 2;")
+		expect := []byte{
+			byte(OpConstant), 0, 0,
+			byte(OpConstant), 0, 1,
+			byte(OpAdd),
+			byte(OpPrint),
+			byte(OpNil),
+			byte(OpReturn)}
+		if string(function.Chunk.Code) != string(expect) {
+			t.Errorf("expected code %v, got %v", expect, function.Chunk.Code)
+		}
+		if function.Chunk.Line(6) != 1 || function.Chunk.Tokens[6].Lexeme != "+" {
+			t.Errorf("expected OpAdd compiled from '+' on line 1")
+		}
+	})
+
+	t.Run("share constants", func(t *testing.T) {
+		function := compile(t, `var a = 1; var b = 1; print "a" + "a"; print -0 + 0;`)
+		expect := []interface{}{1.0, "a", "b", 0.0}
+		if fmt.Sprint(function.Chunk.Constants) != fmt.Sprint(expect) {
+			t.Errorf("expected constants %v, got %v", expect, function.Chunk.Constants)
+		}
+	})
+
+	t.Run("compile functions", func(t *testing.T) {
+		function := compile(t, `
+			fun outer(a, b) {
+				fun inner() { return a; }
+				return inner;
+			}`)
+		outer := function.Chunk.Constants[0].(*Function)
+		inner := outer.Chunk.Constants[0].(*Function)
+		if outer.Name != "outer" || outer.Arity != 2 || outer.Line != 2 ||
+			outer.UpvalueCount != 0 {
+			t.Errorf("unexpected outer function %+v", outer)
+		}
+		if inner.Name != "inner" || inner.Arity != 0 || inner.UpvalueCount != 1 {
+			t.Errorf("unexpected inner function %+v", inner)
+		}
+	})
+
+	t.Run("keep negative zero", func(t *testing.T) {
+		c := newCompiler(nil, typeScript, "", nil)
+		zero := c.addConstant(0.0)
+		negativeZero := c.addConstant(math.Copysign(0, -1))
+		if zero == negativeZero {
+			t.Errorf("expected 0 and -0 to be different constants")
+		}
+	})
+}
+
+func TestCompileErrors(t *testing.T) {
+
+	var locals strings.Builder
+	locals.WriteString("{\n")
+	for n := 0; n < 256; n++ {
+		fmt.Fprintf(&locals, "var v%d = %d;\n", n, n)
+	}
+	locals.WriteString("}")
+
+	var constants strings.Builder
+	for n := 0; n < 65537; n++ {
+		fmt.Fprintf(&constants, "%d;\n", n)
+	}
+
+	tests := []struct {
+		script string
+		err    string
+	}{
+		{locals.String(), "[line 257] Error at 'v255': Too many local variables in function."},
+		{constants.String(), "[line 65537] Error at '65536': Too many constants in one chunk."},
+	}
+
+	for _, test := range tests {
+		_, diagnostics := Compile(parse(t, test.script))
+		if len(diagnostics) != 1 || diagnostics[0].String() != test.err {
+			t.Errorf("expected error %q, got %v", test.err, diagnostics)
+		}
+	}
+}
+
+// compile compiles a script, failing the test on errors.
+func compile(t *testing.T, script string) *Function {
+
+	t.Helper()
+	function, diagnostics := Compile(parse(t, script))
+	if len(diagnostics) > 0 {
+		t.Fatalf("unexpected errors %v", diagnostics)
+	}
+	return function
+}
+
+// parse parses a script, failing the test on syntax errors.
+func parse(t *testing.T, script string) []lang.Stmt {
+
+	t.Helper()
+	scanner := &lang.Scanner{}
+	parser := &lang.Parser{}
+	statements := parser.Parse(scanner.ScanTokens(script))
+	if scanner.HadError() || parser.HadError() {
+		t.Fatalf("unexpected syntax errors in %s", script)
+	}
+	return statements
+}
@@ -0,0 +1,158 @@
+package bytecode
+
+import (
+	"fmt"
+	"io"
+)
+
+// opNames are the names of the opcodes, like in clox.
+var opNames = [...]string{
+	OpConstant:     "OP_CONSTANT",
+	OpNil:          "OP_NIL",
+	OpTrue:         "OP_TRUE",
+	OpFalse:        "OP_FALSE",
+	OpUnassigned:   "OP_UNASSIGNED",
+	OpPop:          "OP_POP",
+	OpGetLocal:     "OP_GET_LOCAL",
+	OpSetLocal:     "OP_SET_LOCAL",
+	OpGetGlobal:    "OP_GET_GLOBAL",
+	OpDefineGlobal: "OP_DEFINE_GLOBAL",
+	OpSetGlobal:    "OP_SET_GLOBAL",
+	OpGetUpvalue:   "OP_GET_UPVALUE",
+	OpSetUpvalue:   "OP_SET_UPVALUE",
+	OpGetProperty:  "OP_GET_PROPERTY",
+	OpSetProperty:  "OP_SET_PROPERTY",
+	OpGetSuper:     "OP_GET_SUPER",
+	OpEqual:        "OP_EQUAL",
+	OpGreater:      "OP_GREATER",
+	OpGreaterEqual: "OP_GREATER_EQUAL",
+	OpLess:         "OP_LESS",
+	OpLessEqual:    "OP_LESS_EQUAL",
+	OpAdd:          "OP_ADD",
+	OpSubtract:     "OP_SUBTRACT",
+	OpMultiply:     "OP_MULTIPLY",
+	OpDivide:       "OP_DIVIDE",
+	OpNot:          "OP_NOT",
+	OpNegate:       "OP_NEGATE",
+	OpPrint:        "OP_PRINT",
+	OpJump:         "OP_JUMP",
+	OpJumpIfFalse:  "OP_JUMP_IF_FALSE",
+	OpLoop:         "OP_LOOP",
+	OpCall:         "OP_CALL",
+	OpInvoke:       "OP_INVOKE",
+	OpSuperInvoke:  "OP_SUPER_INVOKE",
+	OpClosure:      "OP_CLOSURE",
+	OpCloseUpvalue: "OP_CLOSE_UPVALUE",
+	OpReturn:       "OP_RETURN",
+	OpClass:        "OP_CLASS",
+	OpInherit:      "OP_INHERIT",
+	OpMethod:       "OP_METHOD",
+}
+
+// String returns the name of the opcode, like OP_CONSTANT.
+func (op OpCode) String() string {
+
+	if int(op) < len(opNames) {
+		return opNames[op]
+	}
+	return fmt.Sprintf("OP_UNKNOWN(%d)", op)
+}
+
+// Disassemble writes the instructions and the constants of the
+// function to out, followed by the functions it declares.
+func Disassemble(out io.Writer, function *Function) {
+
+	function.Chunk.Disassemble(out, function.String())
+	for _, constant := range function.Chunk.Constants {
+		if f, ok := constant.(*Function); ok {
+			fmt.Fprintln(out)
+			Disassemble(out, f)
+		}
+	}
+}
+
+// Disassemble writes the instructions of the chunk to out, one per
+// line, followed by its constants. Each instruction is preceded by
+// its offset and its line ("|" when it is on the same line as
+// the previous instruction).
+func (c *Chunk) Disassemble(out io.Writer, name string) {
+
+	fmt.Fprintf(out, "== %s ==\n", name)
+	for offset := 0; offset < len(c.Code); {
+		offset = c.DisassembleInstruction(out, offset)
+	}
+	if len(c.Constants) > 0 {
+		fmt.Fprintln(out, "constants:")
+		for index, constant := range c.Constants {
+			fmt.Fprintf(out, "%4d %s\n", index, formatConstant(constant))
+		}
+	}
+}
+
+// DisassembleInstruction writes the instruction at offset to out
+// and returns the offset of the next instruction.
+func (c *Chunk) DisassembleInstruction(out io.Writer, offset int) int {
+
+	fmt.Fprintf(out, "%04d ", offset)
+	if offset > 0 && c.Line(offset) == c.Line(offset-1) {
+		fmt.Fprint(out, "   | ")
+	} else {
+		fmt.Fprintf(out, "%4d ", c.Line(offset))
+	}
+
+	op := OpCode(c.Code[offset])
+	switch op {
+	case OpConstant, OpGetGlobal, OpDefineGlobal, OpSetGlobal,
+		OpGetProperty, OpSetProperty, OpGetSuper, OpClass, OpMethod:
+		index := c.short(offset + 1)
+		fmt.Fprintf(out, "%-16s %4d %s\n", op, index, formatConstant(c.Constants[index]))
+		return offset + 3
+	case OpGetLocal, OpSetLocal, OpGetUpvalue, OpSetUpvalue, OpCall:
+		fmt.Fprintf(out, "%-16s %4d\n", op, c.Code[offset+1])
+		return offset + 2
+	case OpJump, OpJumpIfFalse:
+		fmt.Fprintf(out, "%-16s %4d -> %d\n", op, offset, offset+3+c.short(offset+1))
+		return offset + 3
+	case OpLoop:
+		fmt.Fprintf(out, "%-16s %4d -> %d\n", op, offset, offset+3-c.short(offset+1))
+		return offset + 3
+	case OpInvoke, OpSuperInvoke:
+		index := c.short(offset + 1)
+		fmt.Fprintf(out, "%-16s (%d args) %4d %s\n", op, c.Code[offset+3], index,
+			formatConstant(c.Constants[index]))
+		return offset + 4
+	case OpClosure:
+		index := c.short(offset + 1)
+		fmt.Fprintf(out, "%-16s %4d %s\n", op, index, formatConstant(c.Constants[index]))
+		offset += 3
+		function := c.Constants[index].(*Function)
+		for n := 0; n < function.UpvalueCount; n++ {
+			kind := "upvalue"
+			if c.Code[offset] == 1 {
+				kind = "local"
+			}
+			fmt.Fprintf(out, "%04d      |                     %s %d\n", offset, kind, c.Code[offset+1])
+			offset += 2
+		}
+		return offset
+	default:
+		fmt.Fprintf(out, "%s\n", op)
+		return offset + 1
+	}
+}
+
+// short returns the two bytes operand at offset.
+func (c *Chunk) short(offset int) int {
+
+	return int(c.Code[offset])<<8 | int(c.Code[offset+1])
+}
+
+// formatConstant returns the printable representation of
+// a constant, strings are quoted.
+func formatConstant(constant interface{}) string {
+
+	if s, ok := constant.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	return fmt.Sprintf("%v", constant)
+}
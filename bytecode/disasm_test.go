@@ -0,0 +1,71 @@
+package bytecode
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDisassemble(t *testing.T) {
+
+	function := compile(t, `var a = "one";
+fun f(x) {
+  fun g() { return x; }
+  while (x > 0) x = x - 1;
+  return g;
+}`)
+	expected := `== <script> ==
+0000    1 OP_CONSTANT         0 "one"
+0003    | OP_DEFINE_GLOBAL    1 "a"
+0006    2 OP_CLOSURE          2 <fun f>
+0009    | OP_DEFINE_GLOBAL    3 "f"
+0012    | OP_NIL
+0013    | OP_RETURN
+constants:
+   0 "one"
+   1 "a"
+   2 <fun f>
+   3 "f"
+
+== <fun f> ==
+0000    3 OP_CLOSURE          0 <fun g>
+0003      |                     local 1
+0005    4 OP_GET_LOCAL        1
+0007    | OP_CONSTANT         1 0
+0010    | OP_GREATER
+0011    | OP_JUMP_IF_FALSE   11 -> 27
+0014    | OP_POP
+0015    | OP_GET_LOCAL        1
+0017    | OP_CONSTANT         2 1
+0020    | OP_SUBTRACT
+0021    | OP_SET_LOCAL        1
+0023    | OP_POP
+0024    | OP_LOOP            24 -> 5
+0027    | OP_POP
+0028    5 OP_GET_LOCAL        2
+0030    | OP_RETURN
+0031    | OP_NIL
+0032    | OP_RETURN
+constants:
+   0 <fun g>
+   1 0
+   2 1
+
+== <fun g> ==
+0000    3 OP_GET_UPVALUE      0
+0002    | OP_RETURN
+0003    | OP_NIL
+0004    | OP_RETURN
+`
+	out := &strings.Builder{}
+	Disassemble(out, function)
+	if out.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out)
+	}
+}
+
+func TestOpCodeString(t *testing.T) {
+
+	if OpMethod.String() != "OP_METHOD" || OpCode(255).String() != "OP_UNKNOWN(255)" {
+		t.Errorf("unexpected opcode names %s, %s", OpMethod, OpCode(255))
+	}
+}
@@ -0,0 +1,317 @@
+package bytecode
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math"
+
+	"github.com/rmonnet/glox/lang"
+)
+
+// FormatVersion is the version of the .loxc format written by Save.
+// It changes with the instruction set and the token types, Load
+// rejects the files written with another version.
+const FormatVersion = 4
+
+// magic starts every .loxc file.
+const magic = "LOXC"
+
+// tags of the constants in the constant pool.
+const (
+	numberConstant byte = iota
+	stringConstant
+	functionConstant
+)
+
+// ErrVersion is returned by Load for a file written with another
+// version of the format, which must be compiled again.
+var ErrVersion = errors.New("unsupported bytecode version")
+
+// Save writes the compiled script to out: the header (magic, format
+// version and compilation options) followed by the functions and the
+// CRC-32 checksum of the file. Each function holds its code, its
+// constant pool (where the nested functions are written recursively)
+// and the tokens the code was compiled from, so the runtime errors
+// are reported like with the source.
+// The script is saved without compilation options, see SaveOptions.
+func Save(out io.Writer, function *Function) error {
+
+	return SaveOptions(out, function, "")
+}
+
+// SaveOptions writes the compiled script to out like Save, with
+// the options it was compiled with (like the options of
+// Interp.CompileOptions), returned by LoadOptions.
+func SaveOptions(out io.Writer, function *Function, options string) error {
+
+	checksum := crc32.NewIEEE()
+	w := &writer{out: bufio.NewWriter(io.MultiWriter(out, checksum))}
+	w.out.WriteString(magic)
+	w.uint(FormatVersion)
+	w.string(options)
+	w.function(function)
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.out.Flush(); err != nil {
+		return err
+	}
+	var sum [4]byte
+	binary.LittleEndian.PutUint32(sum[:], checksum.Sum32())
+	_, err := out.Write(sum[:])
+	return err
+}
+
+// Load reads a compiled script written by Save or SaveOptions.
+func Load(in io.Reader) (*Function, error) {
+
+	function, _, err := LoadOptions(in)
+	return function, err
+}
+
+// LoadOptions reads a compiled script written by Save or
+// SaveOptions and returns it with its compilation options.
+// The file is rejected, with an "invalid compiled lox script" error,
+// if its checksum doesn't match or if its code can't be run safely
+// by the virtual machine (see verify).
+func LoadOptions(in io.Reader) (*Function, string, error) {
+
+	data, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, "", err
+	}
+	if !bytes.HasPrefix(data, []byte(magic)) {
+		return nil, "", errors.New("not a compiled lox script")
+	}
+	if len(data) < len(magic)+4 {
+		return nil, "", errors.New("invalid compiled lox script: unexpected EOF")
+	}
+	content, sum := data[:len(data)-4], data[len(data)-4:]
+	r := &reader{in: bufio.NewReader(bytes.NewReader(content[len(magic):]))}
+	// the files written with another version may not have a checksum.
+	if version := r.uint(); r.err == nil && version != FormatVersion {
+		return nil, "", ErrVersion
+	}
+	if crc32.ChecksumIEEE(content) != binary.LittleEndian.Uint32(sum) {
+		return nil, "", errors.New("invalid compiled lox script: checksum mismatch")
+	}
+	options := r.string()
+	function := r.function()
+	if r.err == nil {
+		if _, err := r.in.ReadByte(); err != io.EOF {
+			r.fail(errors.New("unexpected data after the script"))
+		}
+	}
+	if r.err == nil {
+		r.err = verify(function)
+	}
+	if r.err != nil {
+		return nil, "", fmt.Errorf("invalid compiled lox script: %v", r.err)
+	}
+	return function, options, nil
+}
+
+// writer encodes the functions, keeping the first error.
+type writer struct {
+	out *bufio.Writer
+	err error
+}
+
+// uint writes a non negative integer as a varint.
+func (w *writer) uint(n int) {
+
+	var buf [binary.MaxVarintLen64]byte
+	w.bytes(buf[:binary.PutUvarint(buf[:], uint64(n))])
+}
+
+// string writes a string preceded by its length.
+func (w *writer) string(s string) {
+
+	w.uint(len(s))
+	w.bytes([]byte(s))
+}
+
+// bytes writes raw bytes.
+func (w *writer) bytes(b []byte) {
+
+	if w.err == nil {
+		_, w.err = w.out.Write(b)
+	}
+}
+
+// function writes a function and its nested functions.
+func (w *writer) function(f *Function) {
+
+	w.string(f.Name)
+	w.uint(f.Line)
+	w.uint(f.Arity)
+	w.uint(f.UpvalueCount)
+	w.uint(len(f.Chunk.Code))
+	w.bytes(f.Chunk.Code)
+
+	w.uint(len(f.Chunk.Constants))
+	for _, constant := range f.Chunk.Constants {
+		switch value := constant.(type) {
+		case float64:
+			w.bytes([]byte{numberConstant})
+			var buf [8]byte
+			binary.LittleEndian.PutUint64(buf[:], math.Float64bits(value))
+			w.bytes(buf[:])
+		case string:
+			w.bytes([]byte{stringConstant})
+			w.string(value)
+		case *Function:
+			w.bytes([]byte{functionConstant})
+			w.function(value)
+		default:
+			if w.err == nil {
+				w.err = fmt.Errorf("unexpected constant %v", constant)
+			}
+		}
+	}
+
+	// most instructions span several bytes compiled from the same
+	// token, the tokens are written once and referenced by index
+	// (0 for no token).
+	index := map[*lang.Token]int{}
+	var tokens []*lang.Token
+	refs := make([]int, len(f.Chunk.Tokens))
+	for n, token := range f.Chunk.Tokens {
+		if token == nil {
+			continue
+		}
+		if _, ok := index[token]; !ok {
+			tokens = append(tokens, token)
+			index[token] = len(tokens)
+		}
+		refs[n] = index[token]
+	}
+	w.uint(len(tokens))
+	for _, token := range tokens {
+		w.uint(int(token.Type))
+		w.string(token.Lexeme)
+		w.uint(token.Line)
+		w.uint(token.Column)
+	}
+	for _, ref := range refs {
+		w.uint(ref)
+	}
+}
+
+// reader decodes the functions, keeping the first error. The values
+// read after an error are zero.
+type reader struct {
+	in  *bufio.Reader
+	err error
+}
+
+// maxLength bounds the lengths read from the file so a corrupted
+// file fails instead of allocating gigabytes.
+const maxLength = 1 << 28
+
+// uint reads a varint written by writer.uint.
+func (r *reader) uint() int {
+
+	if r.err != nil {
+		return 0
+	}
+	n, err := binary.ReadUvarint(r.in)
+	if err != nil {
+		r.fail(err)
+		return 0
+	}
+	if n > maxLength {
+		r.fail(errors.New("length out of range"))
+		return 0
+	}
+	return int(n)
+}
+
+// string reads a string written by writer.string.
+func (r *reader) string() string {
+
+	return string(r.bytes(r.uint()))
+}
+
+// bytes reads n raw bytes.
+func (r *reader) bytes(n int) []byte {
+
+	if r.err != nil {
+		return nil
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.in, b); err != nil {
+		r.fail(err)
+		return nil
+	}
+	return b
+}
+
+// fail records the first error, a truncated file
+// is reported as such.
+func (r *reader) fail(err error) {
+
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// function reads a function written by writer.function.
+func (r *reader) function() *Function {
+
+	f := &Function{}
+	f.Name = r.string()
+	f.Line = r.uint()
+	f.Arity = r.uint()
+	f.UpvalueCount = r.uint()
+	f.Chunk.Code = r.bytes(r.uint())
+
+	count := r.uint()
+	for n := 0; n < count && r.err == nil; n++ {
+		switch tag := r.bytes(1); {
+		case r.err != nil:
+		case tag[0] == numberConstant:
+			if b := r.bytes(8); r.err == nil {
+				f.Chunk.Constants = append(f.Chunk.Constants,
+					math.Float64frombits(binary.LittleEndian.Uint64(b)))
+			}
+		case tag[0] == stringConstant:
+			f.Chunk.Constants = append(f.Chunk.Constants, r.string())
+		case tag[0] == functionConstant:
+			f.Chunk.Constants = append(f.Chunk.Constants, r.function())
+		default:
+			r.fail(fmt.Errorf("unknown constant tag %d", tag[0]))
+		}
+	}
+
+	tokens := make([]*lang.Token, r.uint())
+	for n := range tokens {
+		tokens[n] = &lang.Token{
+			Type:   lang.TokenType(r.uint()),
+			Lexeme: r.string(),
+			Line:   r.uint(),
+			Column: r.uint(),
+		}
+	}
+	f.Chunk.Tokens = make([]*lang.Token, len(f.Chunk.Code))
+	for n := range f.Chunk.Tokens {
+		ref := r.uint()
+		if ref > len(tokens) {
+			r.fail(errors.New("token out of range"))
+			break
+		}
+		if ref > 0 {
+			f.Chunk.Tokens[n] = tokens[ref-1]
+		}
+	}
+	return f
+}
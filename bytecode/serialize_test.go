@@ -0,0 +1,159 @@
+package bytecode
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/rmonnet/glox/lang"
+)
+
+func TestSaveLoad(t *testing.T) {
+
+	function := compile(t, `var a = "one";
+fun f(x) {
+  fun g() { return x; }
+  while (x > 0) x = x - 1;
+  return g;
+}
+class A < B { init() { super.init(); } }
+print 1.5;`)
+
+	var buf bytes.Buffer
+	if err := Save(&buf, function); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	loaded, err := Load(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	expected, actual := &strings.Builder{}, &strings.Builder{}
+	Disassemble(expected, function)
+	Disassemble(actual, loaded)
+	if actual.String() != expected.String() {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, actual)
+	}
+	for n, token := range function.Chunk.Tokens {
+		if *loaded.Chunk.Tokens[n] != *token {
+			t.Errorf("expected token %v at %d, got %v", token, n, loaded.Chunk.Tokens[n])
+		}
+	}
+
+	buf.Reset()
+	Save(&buf, &Function{Chunk: Chunk{
+		Code:      []byte{byte(OpConstant), 0, 0, byte(OpReturn)},
+		Constants: []interface{}{math.Copysign(0, -1)},
+		Tokens:    make([]*lang.Token, 4),
+	}})
+	loaded, err = Load(&buf)
+	if zero := loaded.Chunk.Constants[0].(float64); err != nil || !math.Signbit(zero) {
+		t.Errorf("expected -0, got %v (%v)", zero, err)
+	}
+
+	buf.Reset()
+	SaveOptions(&buf, function, "strict")
+	if _, options, err := LoadOptions(&buf); err != nil || options != "strict" {
+		t.Errorf("expected the options %q, got %q (%v)", "strict", options, err)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+
+	var buf bytes.Buffer
+	Save(&buf, compile(t, `print "hello";`))
+	saved := buf.Bytes()
+
+	tests := []struct {
+		data []byte
+		err  string
+	}{
+		{[]byte("print 1;"), "not a compiled lox script"},
+		{append([]byte("LOXC\x02"), saved[5:]...), ErrVersion.Error()},
+		{saved[:len(saved)-3], "invalid compiled lox script: checksum mismatch"},
+		{saved[:6], "invalid compiled lox script: unexpected EOF"},
+	}
+
+	for _, test := range tests {
+		_, err := Load(bytes.NewReader(test.data))
+		if err == nil || err.Error() != test.err {
+			t.Errorf("expected error %q, got %v", test.err, err)
+		}
+	}
+}
+
+func TestLoadCorrupted(t *testing.T) {
+
+	var buf bytes.Buffer
+	Save(&buf, compile(t, `fun f(n) { if (n < 2) return n; return f(n - 1) + f(n - 2); }
+class A { init(x) { this.x = x; } get() { return this.x; } }
+print f(10) + A(1).get();`))
+	saved := buf.Bytes()
+
+	random := rand.New(rand.NewSource(1))
+	for n := 0; n < 300; n++ {
+		data := append([]byte(nil), saved...)
+		offset := random.Intn(len(data))
+		data[offset] ^= byte(1 + random.Intn(255))
+		if _, err := Load(bytes.NewReader(data)); err == nil {
+			t.Errorf("expected an error for the byte %d changed to %d", offset, data[offset])
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+
+	token := &lang.Token{Type: lang.PlusToken, Lexeme: "+", Line: 1}
+	tokens := func(n int) []*lang.Token {
+		tokens := make([]*lang.Token, n)
+		for i := range tokens {
+			tokens[i] = token
+		}
+		return tokens
+	}
+	function := func(code ...OpCode) *Function {
+		f := &Function{Chunk: Chunk{Constants: []interface{}{1.0, "a"}}}
+		for _, b := range code {
+			f.Chunk.Code = append(f.Chunk.Code, byte(b))
+		}
+		f.Chunk.Tokens = tokens(len(f.Chunk.Code))
+		return f
+	}
+
+	tests := []struct {
+		function *Function
+		err      string
+	}{
+		{function(OpNil, OpReturn), ""},
+		{function(OpConstant, 0, 1, OpJumpIfFalse, 0, 4, OpPop, OpJump, 0, 1, OpPop, OpNil, OpReturn), ""},
+		{function(), "<script>: no code"},
+		{function(OpNil, 224), "<script>: unknown opcode 224 at 1"},
+		{function(OpNil, OpConstant, 0), "<script>: truncated OP_CONSTANT at 1"},
+		{function(OpConstant, 0, 2, OpReturn), "<script>: OP_CONSTANT at 0: not a number or a string constant"},
+		{function(OpGetGlobal, 0, 0, OpReturn), "<script>: OP_GET_GLOBAL at 0: not a name constant"},
+		{function(OpGetLocal, 1, OpReturn), "<script>: OP_GET_LOCAL at 0: local slot 1 out of range"},
+		{function(OpGetUpvalue, 0, OpReturn), "<script>: OP_GET_UPVALUE at 0: upvalue 0 out of range"},
+		{function(OpJump, 0, 9, OpNil, OpReturn), "<script>: OP_JUMP at 0: jump to 12 out of the code"},
+		{function(OpJump, 0, 1, OpConstant, 0, 0, OpReturn), "<script>: OP_JUMP at 0: jump inside an instruction"},
+		{function(OpNil, OpLoop, 0, 9, OpReturn), "<script>: OP_LOOP at 1: loop to -5 out of the code"},
+		{function(OpPop, OpPop, OpNil, OpReturn), "<script>: OP_POP at 1: stack underflow"},
+		{function(OpNil, OpPrint), "<script>: OP_PRINT at 1: no return at the end of the code"},
+		{function(OpTrue, OpJumpIfFalse, 0, 1, OpNil, OpNil, OpReturn),
+			"<script>: OP_NIL at 4: inconsistent stack height"},
+		{function(OpClass, 0, 1, OpNil, OpMethod, 0, 1, OpReturn),
+			"<script>: OP_NIL at 3: no closure for the method"},
+		{&Function{Chunk: Chunk{Code: []byte{byte(OpAdd)}, Tokens: make([]*lang.Token, 1)}},
+			"<script>: OP_ADD at 0: missing token"},
+		{&Function{UpvalueCount: 1, Chunk: Chunk{Code: []byte{byte(OpNil), byte(OpReturn)}, Tokens: tokens(2)}},
+			"the script has parameters or upvalues"},
+	}
+
+	for _, test := range tests {
+		err := verify(test.function)
+		if (err == nil && test.err != "") || (err != nil && err.Error() != test.err) {
+			t.Errorf("expected error %q, got %v", test.err, err)
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package bytecode
+
+import (
+	"errors"
+	"fmt"
+)
+
+// verify checks that the script loaded from a .loxc file can be run
+// by the virtual machine, which trusts the code it runs: the opcodes
+// are known, the operands are in the code, the constants, the local
+// slots and the upvalues they reference exist, the jumps land on
+// instructions, the stack never underflows and has the same height
+// wherever the paths merge, and the execution ends with OpReturn.
+func verify(script *Function) error {
+
+	if script.Arity != 0 || script.UpvalueCount != 0 {
+		return errors.New("the script has parameters or upvalues")
+	}
+	return verifyFunction(script)
+}
+
+// verifyFunction verifies the function and the functions it declares.
+func verifyFunction(f *Function) error {
+
+	v := &verifier{function: f, chunk: &f.Chunk}
+	if err := v.decode(); err != nil {
+		return fmt.Errorf("%v: %v", f, err)
+	}
+	if err := v.run(); err != nil {
+		return fmt.Errorf("%v: %v", f, err)
+	}
+	for _, constant := range f.Chunk.Constants {
+		if nested, ok := constant.(*Function); ok {
+			if err := verifyFunction(nested); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// verifier verifies the code of a function.
+type verifier struct {
+	function *Function
+	chunk    *Chunk
+	// size of the instruction starting at each offset,
+	// 0 inside the instructions.
+	sizes []int
+	// stack height before the instruction at each offset,
+	// -1 until a path reaches it.
+	heights []int
+	// local slots captured by the closures before the instruction
+	// at each offset.
+	captured []slotSet
+}
+
+// decode checks the instructions one after the other and records
+// their sizes.
+func (v *verifier) decode() error {
+
+	code := v.chunk.Code
+	v.sizes = make([]int, len(code))
+	for offset := 0; offset < len(code); {
+		op := OpCode(code[offset])
+		size := v.size(offset)
+		if size == 0 {
+			return fmt.Errorf("unknown opcode %d at %d", op, offset)
+		}
+		if offset+size > len(code) {
+			return fmt.Errorf("truncated %v at %d", op, offset)
+		}
+		if err := v.operands(offset); err != nil {
+			return fmt.Errorf("%v at %d: %v", op, offset, err)
+		}
+		if err := v.tokens(offset); err != nil {
+			return fmt.Errorf("%v at %d: %v", op, offset, err)
+		}
+		v.sizes[offset] = size
+		offset += size
+	}
+	return nil
+}
+
+// size returns the size of the instruction at offset with its
+// operands, 0 for an unknown opcode.
+func (v *verifier) size(offset int) int {
+
+	switch OpCode(v.chunk.Code[offset]) {
+	case OpNil, OpTrue, OpFalse, OpUnassigned, OpPop, OpEqual,
+		OpGreater, OpGreaterEqual, OpLess, OpLessEqual, OpAdd,
+		OpSubtract, OpMultiply, OpDivide, OpNot, OpNegate, OpPrint,
+		OpCloseUpvalue, OpReturn, OpInherit:
+		return 1
+	case OpGetLocal, OpSetLocal, OpGetUpvalue, OpSetUpvalue, OpCall:
+		return 2
+	case OpConstant, OpGetGlobal, OpDefineGlobal, OpSetGlobal,
+		OpGetProperty, OpSetProperty, OpGetSuper, OpJump,
+		OpJumpIfFalse, OpLoop, OpClass, OpMethod:
+		return 3
+	case OpInvoke, OpSuperInvoke:
+		return 4
+	case OpClosure:
+		if offset+3 > len(v.chunk.Code) {
+			return 3
+		}
+		if f, ok := v.constant(offset).(*Function); ok {
+			return 3 + 2*f.UpvalueCount
+		}
+		return 3
+	}
+	return 0
+}
+
+// constant returns the constant referenced by the instruction at
+// offset, nil if there is none.
+func (v *verifier) constant(offset int) interface{} {
+
+	if index := v.chunk.short(offset + 1); index < len(v.chunk.Constants) {
+		return v.chunk.Constants[index]
+	}
+	return nil
+}
+
+// operands checks the operands which don't depend on the stack.
+func (v *verifier) operands(offset int) error {
+
+	code := v.chunk.Code
+	switch op := OpCode(code[offset]); op {
+	case OpConstant:
+		switch v.constant(offset).(type) {
+		case float64, string:
+		default:
+			return errors.New("not a number or a string constant")
+		}
+	case OpGetGlobal, OpDefineGlobal, OpSetGlobal, OpGetProperty,
+		OpSetProperty, OpGetSuper, OpInvoke, OpSuperInvoke, OpClass,
+		OpMethod:
+		if _, ok := v.constant(offset).(string); !ok {
+			return errors.New("not a name constant")
+		}
+	case OpClosure:
+		if _, ok := v.constant(offset).(*Function); !ok {
+			return errors.New("not a function constant")
+		}
+		for n := offset + 3; n < offset+v.size(offset); n += 2 {
+			if isLocal := code[n]; isLocal > 1 {
+				return fmt.Errorf("invalid upvalue kind %d", isLocal)
+			}
+			if code[n] == 0 && int(code[n+1]) >= v.function.UpvalueCount {
+				return fmt.Errorf("upvalue %d out of range", code[n+1])
+			}
+		}
+	case OpGetUpvalue, OpSetUpvalue:
+		if int(code[offset+1]) >= v.function.UpvalueCount {
+			return fmt.Errorf("upvalue %d out of range", code[offset+1])
+		}
+	case OpJump, OpJumpIfFalse:
+		if target := offset + 3 + v.chunk.short(offset+1); target >= len(code) {
+			return fmt.Errorf("jump to %d out of the code", target)
+		}
+	case OpLoop:
+		if target := offset + 3 - v.chunk.short(offset+1); target < 0 {
+			return fmt.Errorf("loop to %d out of the code", target)
+		}
+	}
+	return nil
+}
+
+// tokens checks that the instructions which may fail have the token
+// locating the runtime error.
+func (v *verifier) tokens(offset int) error {
+
+	var count int
+	switch OpCode(v.chunk.Code[offset]) {
+	case OpGetLocal, OpGetGlobal, OpSetGlobal, OpGetUpvalue,
+		OpGetProperty, OpSetProperty, OpGetSuper, OpGreater,
+		OpGreaterEqual, OpLess, OpLessEqual, OpAdd, OpSubtract,
+		OpMultiply, OpDivide, OpNegate, OpLoop, OpCall, OpInherit,
+		OpMethod:
+		count = 1
+	case OpInvoke, OpSuperInvoke:
+		// the method name is the token of the operand.
+		count = 2
+	}
+	for n := offset; n < offset+count; n++ {
+		if v.chunk.Tokens[n] == nil {
+			return errors.New("missing token")
+		}
+	}
+	return nil
+}
+
+// run follows the paths of the execution from the start of the
+// function, checking the stack before each instruction: its height
+// and the local slots captured by the closures, which must be closed
+// with OpCloseUpvalue before they are popped (OpReturn closes the
+// slots under the returned value). The local slots start at the
+// bottom of the stack of the call: the function itself (or the
+// instance for the methods) followed by the parameters.
+func (v *verifier) run() error {
+
+	code := v.chunk.Code
+	if len(code) == 0 {
+		return errors.New("no code")
+	}
+	v.heights = make([]int, len(code))
+	for n := range v.heights {
+		v.heights[n] = -1
+	}
+	v.captured = make([]slotSet, len(code))
+	v.heights[0] = 1 + v.function.Arity
+	pending := []int{0}
+	for len(pending) > 0 {
+		offset := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		op := OpCode(code[offset])
+		height, captured := v.heights[offset], v.captured[offset]
+		pop, push := v.stackEffect(offset)
+		if height < pop {
+			return fmt.Errorf("%v at %d: stack underflow", op, offset)
+		}
+		if err := v.slots(offset, height); err != nil {
+			return fmt.Errorf("%v at %d: %v", op, offset, err)
+		}
+		height += push - pop
+		switch op {
+		case OpClosure:
+			captured = v.capture(offset, captured)
+		case OpCloseUpvalue:
+			captured = captured.below(height)
+		default:
+			if captured != captured.below(height) {
+				return fmt.Errorf("%v at %d: captured variable popped", op, offset)
+			}
+		}
+		next := offset + v.sizes[offset]
+
+		var successors []int
+		switch op {
+		case OpReturn:
+		case OpJump:
+			successors = []int{next + v.chunk.short(offset+1)}
+		case OpJumpIfFalse:
+			successors = []int{next, next + v.chunk.short(offset+1)}
+		case OpLoop:
+			successors = []int{next - v.chunk.short(offset+1)}
+		default:
+			successors = []int{next}
+		}
+		for _, successor := range successors {
+			switch {
+			case successor == len(code):
+				return fmt.Errorf("%v at %d: no return at the end of the code", op, offset)
+			case v.sizes[successor] == 0:
+				return fmt.Errorf("%v at %d: jump inside an instruction", op, offset)
+			case OpCode(code[successor]) == OpMethod && op != OpClosure:
+				// the method is the closure created just before.
+				return fmt.Errorf("%v at %d: no closure for the method", op, offset)
+			case v.heights[successor] == -1:
+				v.heights[successor] = height
+				v.captured[successor] = captured
+				pending = append(pending, successor)
+			case v.heights[successor] != height:
+				return fmt.Errorf("%v at %d: inconsistent stack height", op, offset)
+			case v.captured[successor].union(captured) != v.captured[successor]:
+				// a variable may be captured on one path only,
+				// the paths following the merge are checked again.
+				v.captured[successor] = v.captured[successor].union(captured)
+				pending = append(pending, successor)
+			}
+		}
+	}
+	return nil
+}
+
+// capture adds the local slots captured by the closure created at
+// offset to the captured slots.
+func (v *verifier) capture(offset int, captured slotSet) slotSet {
+
+	code := v.chunk.Code
+	for n := offset + 3; n < offset+v.sizes[offset]; n += 2 {
+		if code[n] == 1 {
+			captured[code[n+1]/64] |= 1 << (code[n+1] % 64)
+		}
+	}
+	return captured
+}
+
+// slotSet is a set of local slots, which are numbered by a byte.
+type slotSet [4]uint64
+
+// below returns the slots of the set below the stack height.
+func (s slotSet) below(height int) slotSet {
+
+	var below slotSet
+	for n := range s {
+		switch {
+		case height >= (n+1)*64:
+			below[n] = s[n]
+		case height > n*64:
+			below[n] = s[n] & (1<<(height-n*64) - 1)
+		}
+	}
+	return below
+}
+
+// union returns the slots in either set.
+func (s slotSet) union(other slotSet) slotSet {
+
+	for n := range s {
+		s[n] |= other[n]
+	}
+	return s
+}
+
+// stackEffect returns the number of values popped and pushed by the
+// instruction at offset. The instructions replacing the top of the
+// stack pop and push it.
+func (v *verifier) stackEffect(offset int) (int, int) {
+
+	switch op := OpCode(v.chunk.Code[offset]); op {
+	case OpConstant, OpNil, OpTrue, OpFalse, OpUnassigned, OpGetLocal,
+		OpGetGlobal, OpGetUpvalue, OpClosure, OpClass:
+		return 0, 1
+	case OpPop, OpDefineGlobal, OpPrint, OpCloseUpvalue:
+		return 1, 0
+	case OpSetLocal, OpSetGlobal, OpSetUpvalue, OpGetProperty,
+		OpNot, OpNegate, OpJumpIfFalse:
+		return 1, 1
+	case OpSetProperty, OpGetSuper, OpEqual, OpGreater, OpGreaterEqual,
+		OpLess, OpLessEqual, OpAdd, OpSubtract, OpMultiply, OpDivide,
+		OpInherit, OpMethod:
+		return 2, 1
+	case OpCall:
+		// the callee and the arguments are replaced by the result.
+		return int(v.chunk.Code[offset+1]) + 1, 1
+	case OpInvoke:
+		return int(v.chunk.Code[offset+3]) + 1, 1
+	case OpSuperInvoke:
+		// the superclass is popped too.
+		return int(v.chunk.Code[offset+3]) + 2, 1
+	case OpReturn:
+		return 1, 0
+	}
+	return 0, 0
+}
+
+// slots checks the local slots used by the instruction at offset
+// are on the stack of the call.
+func (v *verifier) slots(offset int, height int) error {
+
+	code := v.chunk.Code
+	switch OpCode(code[offset]) {
+	case OpGetLocal, OpSetLocal:
+		if int(code[offset+1]) >= height {
+			return fmt.Errorf("local slot %d out of range", code[offset+1])
+		}
+	case OpClosure:
+		for n := offset + 3; n < offset+v.sizes[offset]; n += 2 {
+			if code[n] == 1 && int(code[n+1]) >= height {
+				return fmt.Errorf("local slot %d out of range", code[n+1])
+			}
+		}
+	}
+	return nil
+}
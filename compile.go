@@ -0,0 +1,157 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/rmonnet/glox/bytecode"
+	"github.com/rmonnet/glox/interp"
+)
+
+// runCompile runs the "glox compile" subcommand. It compiles each
+// script to bytecode and saves it next to the script, with the .loxc
+// extension (or to the file given with -o for a single script).
+// The compiled scripts are run on the virtual machine, skipping the
+// scanner, the parser, the resolver and the compiler, when they are
+// run with the options they were compiled with (-strict, -Werror,
+// -Wshadow... which are the same as the options of a run).
+func runCompile(args []string) {
+
+	flags := flag.NewFlagSet("compile", flag.ExitOnError)
+	output := flags.String("o", "", "write the compiled script to the file (single script only)")
+	fold := flags.Bool("fold", false, "fold constant expressions before compilation")
+	optLevel := flags.Int("O", 0, "optimization level (see glox -O)")
+	warnShadowing := flags.Bool("Wshadow", false,
+		"warn about local declarations shadowing an enclosing variable")
+	warningsAsErrors := flags.Bool("Werror", false, "treat warnings as errors")
+	noWarnings := flags.Bool("no-warn", false, "don't report warnings")
+	strict := flags.Bool("strict", false,
+		"report references to undefined globals as compile errors")
+	strictRedeclare := flags.Bool("strictRedeclare", false,
+		"report declaring a global twice in a script as an error")
+	implicitGlobals := flags.Bool("implicitGlobals", false,
+		"create a global when assigning to an undeclared variable")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 || (*output != "" && flags.NArg() > 1) {
+		fmt.Println("Usage glox compile [-o file.loxc] script...")
+		os.Exit(exUsage)
+	}
+
+	for _, filename := range flags.Args() {
+		script, err := ioutil.ReadFile(filename)
+		if err != nil {
+			fmt.Println("unable to read ", filename)
+			os.Exit(exDataErr)
+		}
+		compiler := interp.New(os.Stdout, os.Stderr)
+		compiler.SetPasses(optimizationPasses(*optLevel, *fold)...)
+		compiler.SetWarnShadowing(*warnShadowing)
+		compiler.SetStrictGlobals(*strict)
+		compiler.SetStrictRedeclaration(*strictRedeclare)
+		compiler.SetImplicitGlobals(*implicitGlobals)
+		if *warningsAsErrors {
+			compiler.SetWarningMode(interp.WarningsAsErrors)
+		} else if *noWarnings {
+			compiler.SetWarningMode(interp.IgnoreWarnings)
+		}
+		compiler.SetScriptName(filename)
+		function, ok := compiler.Compile(string(script))
+		if !ok {
+			os.Exit(exDataErr)
+		}
+		target := *output
+		if target == "" {
+			target = compiledName(filename)
+		}
+		if err := saveCompiled(target, function, compiler.CompileOptions()); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to write %s: %v\n", target, err)
+			os.Exit(exCantCreat)
+		}
+	}
+}
+
+// compiledName returns the name of the compiled script,
+// script.loxc for script.lox.
+func compiledName(filename string) string {
+
+	return strings.TrimSuffix(filename, ".lox") + ".loxc"
+}
+
+// saveCompiled writes the compiled script and its compilation
+// options to the file.
+func saveCompiled(filename string, function *bytecode.Function, options string) error {
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := bytecode.SaveOptions(f, function, options); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// loadCompiled reads a script compiled by "glox compile" and its
+// compilation options.
+func loadCompiled(filename string) (*bytecode.Function, string, error) {
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+	return bytecode.LoadOptions(f)
+}
+
+// compiledScript returns the compiled script to run instead of
+// the file: the file itself if it is a .loxc file, or its compiled
+// version if it is at least as recent as the source, was compiled
+// with the same options (so the diagnostics of -strict or -Werror
+// are not skipped) and the scripts run on the virtual machine (the
+// tree-walker remains the reference and the dumps need the source).
+// Otherwise it returns the source file to run, which is the source
+// of a .loxc file that can't be loaded (it exits if there is none).
+func compiledScript(lox *interp.Interp, filename string, dump string) (*bytecode.Function, string, bool) {
+
+	if strings.HasSuffix(filename, ".loxc") {
+		function, _, err := loadCompiled(filename)
+		if err == nil {
+			return function, "", true
+		}
+		fmt.Fprintf(os.Stderr, "unable to load %s: %v\n", filename, err)
+		source := strings.TrimSuffix(filename, ".loxc") + ".lox"
+		if _, err := os.Stat(source); err != nil {
+			os.Exit(exDataErr)
+		}
+		fmt.Fprintf(os.Stderr, "running %s instead\n", source)
+		lox.SetScriptName(source)
+		return nil, source, false
+	}
+	if lox.Backend() != interp.VM || dump != "" {
+		return nil, filename, false
+	}
+
+	source, err := os.Stat(filename)
+	if err != nil {
+		return nil, filename, false
+	}
+	compiled, err := os.Stat(compiledName(filename))
+	if err != nil || compiled.ModTime().Before(source.ModTime()) {
+		return nil, filename, false
+	}
+	// a script compiled with another version or other
+	// options runs from source, like a corrupted one.
+	function, options, err := loadCompiled(compiledName(filename))
+	if err != nil && err != bytecode.ErrVersion {
+		fmt.Fprintf(os.Stderr, "ignoring %s: %v\n", compiledName(filename), err)
+	}
+	if err != nil || options != lox.CompileOptions() {
+		return nil, filename, false
+	}
+	return function, "", true
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/rmonnet/glox/conformance"
+)
+
+// runConformance runs the "glox conformance" subcommand. It runs the
+// test suite of Crafting Interpreters from a test directory of the
+// repository of the book, or downloads it first with -download, and
+// reports the percentage of the tests passed per chapter.
+func runConformance(args []string) {
+
+	flags := flag.NewFlagSet("conformance", flag.ExitOnError)
+	verbose := flags.Bool("v", false, "report the failing tests")
+	download := flags.Bool("download", false,
+		"download the test suite of the book into the directory first")
+	backendName := flags.String("backend", "tree",
+		"run the tests with the tree-walker (tree) or the bytecode VM (vm)")
+	flags.Parse(args)
+	backend, validBackend := parseBackend(*backendName)
+
+	if flags.NArg() != 1 || !validBackend {
+		fmt.Println("Usage glox conformance [-v] [-download] [-backend tree|vm] test_directory")
+		os.Exit(exUsage)
+	}
+	dir := flags.Arg(0)
+
+	if *download {
+		if err := conformance.Download(conformance.ArchiveURL, dir); err != nil {
+			fmt.Fprintln(os.Stderr, "glox conformance:", err)
+			os.Exit(exSwErr)
+		}
+	}
+	results, err := conformance.Run(dir, backend)
+	if err != nil {
+		fmt.Println("unable to read ", dir)
+		os.Exit(exDataErr)
+	}
+
+	if *verbose {
+		for _, result := range results {
+			if len(result.Failures) > 0 {
+				fmt.Printf("FAIL %s\n", result.Path)
+				for _, failure := range result.Failures {
+					fmt.Printf("    %s\n", failure)
+				}
+			}
+		}
+	}
+	for _, chapter := range conformance.Summarize(results) {
+		fmt.Printf("%-28s %4d/%-4d %6.1f%%\n", chapter.Name, chapter.Passed, chapter.Total,
+			chapter.Percent())
+	}
+}
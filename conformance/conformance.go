@@ -0,0 +1,251 @@
+// Package conformance runs the test suite of Crafting Interpreters
+// (the test directory of github.com/munificent/craftinginterpreters)
+// and reports the tests passed by glox, per chapter of the book, so
+// its compatibility with the reference implementation is verifiable.
+//
+// Each test is a lox script with its expectations in comments:
+//   - "// expect: value" for a line printed on stdout
+//   - "// expect runtime error: message" for a runtime error
+//     on this line
+//   - "// Error at 'x': message" for a compile error on this line,
+//     or "// [line 3] Error at 'x': message" for another line
+//     ("// [java line 3]" is also accepted, the errors specific
+//     to the C implementation, "// [c line 3]", are ignored)
+package conformance
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rmonnet/glox/interp"
+)
+
+var (
+	expectOutput       = regexp.MustCompile(`// expect: ?(.*)`)
+	expectRuntimeError = regexp.MustCompile(`// expect runtime error: (.+)`)
+	expectDiagnostic   = regexp.MustCompile(`// (\[(?:java )?line (\d+)\] )?((Error|Warning).*)`)
+)
+
+// Expectations are the output and the errors a test script
+// should produce, in order.
+type Expectations struct {
+	Output []string
+	Errors []string
+}
+
+// ParseExpectations extracts the expected output and errors
+// from the comments of a test script.
+func ParseExpectations(script string) Expectations {
+
+	var expected Expectations
+	for n, line := range strings.Split(script, "\n") {
+		if m := expectOutput.FindStringSubmatch(line); m != nil {
+			expected.Output = append(expected.Output, m[1])
+		} else if m := expectRuntimeError.FindStringSubmatch(line); m != nil {
+			expected.Errors = append(expected.Errors,
+				fmt.Sprintf("[line %d] %s", n+1, m[1]))
+		} else if m := expectDiagnostic.FindStringSubmatch(line); m != nil {
+			location := fmt.Sprintf("[line %d] ", n+1)
+			if m[2] != "" {
+				location = fmt.Sprintf("[line %s] ", m[2])
+			}
+			expected.Errors = append(expected.Errors, location+m[3])
+		}
+	}
+	return expected
+}
+
+// Compare compares the output and the errors produced by a test
+// script with its expectations and returns the differences, nil
+// if the test passed.
+func Compare(script, output, errors string) []string {
+
+	expected := ParseExpectations(script)
+	failures := compareLines("output", expected.Output, output)
+	return append(failures, compareLines("error", expected.Errors, errors)...)
+}
+
+// compareLines compares the expected lines with the text produced
+// by the script and returns the differences.
+func compareLines(kind string, expected []string, text string) []string {
+
+	got := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	if text == "" {
+		got = nil
+	}
+
+	var failures []string
+	for n := 0; n < len(expected) || n < len(got); n++ {
+		switch {
+		case n >= len(got):
+			failures = append(failures, fmt.Sprintf("missing %s %q", kind, expected[n]))
+		case n >= len(expected):
+			failures = append(failures, fmt.Sprintf("unexpected %s %q", kind, got[n]))
+		case expected[n] != strings.TrimRight(got[n], " \t\r"):
+			failures = append(failures,
+				fmt.Sprintf("expected %s %q but got %q", kind, expected[n], got[n]))
+		}
+	}
+	return failures
+}
+
+// RunTest runs a test script in a new interpreter with the semantics
+// of the reference implementation (the numbers are printed like in
+// java, "+" doesn't convert its operands to strings, a division by
+// zero gives an infinity or NaN and there are no warnings) and
+// returns the differences with its expectations.
+func RunTest(script string, backend interp.Backend) []string {
+
+	var out, errOut bytes.Buffer
+	lox := interp.New(&out, &errOut)
+	lox.SetBackend(backend)
+	lox.SetJloxNumberFormat(true)
+	lox.SetStringCoercion(false)
+	lox.SetAllowDivisionByZero(true)
+	lox.SetWarningMode(interp.IgnoreWarnings)
+	lox.Run(script, false)
+	return Compare(script, out.String(), errOut.String())
+}
+
+// chapters are the chapters of the tree-walker in the book, with
+// the directories of the tests of the features they introduce.
+// The scanner and parser tests (which need a special mode of the
+// reference implementation), the benchmarks and the limits of the
+// C implementation are not run.
+var chapters = []struct {
+	name        string
+	directories []string
+}{
+	{"8. Statements and State", []string{"", "assignment", "block", "bool", "comments",
+		"nil", "number", "operator", "print", "string", "variable"}},
+	{"9. Control Flow", []string{"for", "if", "logical_operator", "while"}},
+	{"10. Functions", []string{"call", "function", "return"}},
+	{"11. Resolving and Binding", []string{"closure", "regression"}},
+	{"12. Classes", []string{"class", "constructor", "field", "method", "this"}},
+	{"13. Inheritance", []string{"inheritance", "super"}},
+}
+
+// skipped are the directories of the tests not run.
+var skipped = map[string]bool{
+	"benchmark": true, "expressions": true, "limit": true, "scanning": true,
+}
+
+// otherChapter is the chapter of the directories
+// not listed in chapters.
+const otherChapter = "Other"
+
+// chapterOf returns the chapter of a test directory.
+func chapterOf(directory string) string {
+
+	for _, chapter := range chapters {
+		for _, d := range chapter.directories {
+			if d == directory {
+				return chapter.name
+			}
+		}
+	}
+	return otherChapter
+}
+
+// Result is the result of a test script.
+type Result struct {
+	// Path is the path of the script in the test directory.
+	Path    string
+	Chapter string
+	// Failures are the differences with the expectations,
+	// the test passed if there are none.
+	Failures []string
+}
+
+// Run runs the tests of a test directory (the test directory of the
+// repository of the book) and returns their results, sorted by path.
+func Run(dir string, backend interp.Backend) ([]Result, error) {
+
+	var results []Result
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if skipped[filepath.Base(rel)] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(path) != ".lox" {
+			return nil
+		}
+		script, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		directory := filepath.ToSlash(filepath.Dir(rel))
+		if directory == "." {
+			directory = ""
+		}
+		directory = strings.SplitN(directory, "/", 2)[0]
+		results = append(results, Result{filepath.ToSlash(rel), chapterOf(directory),
+			RunTest(string(script), backend)})
+		return nil
+	})
+	sort.Slice(results, func(i, j int) bool { return results[i].Path < results[j].Path })
+	return results, err
+}
+
+// Chapter is the conformance of a chapter.
+type Chapter struct {
+	Name   string
+	Passed int
+	Total  int
+}
+
+// Percent returns the percentage of the tests passed.
+func (c Chapter) Percent() float64 {
+
+	if c.Total == 0 {
+		return 100
+	}
+	return 100 * float64(c.Passed) / float64(c.Total)
+}
+
+// Summarize counts the tests passed per chapter, in the order of the
+// book. The last chapter is the total.
+func Summarize(results []Result) []Chapter {
+
+	counts := make(map[string]*Chapter)
+	total := Chapter{Name: "Total"}
+	for _, result := range results {
+		chapter, ok := counts[result.Chapter]
+		if !ok {
+			chapter = &Chapter{Name: result.Chapter}
+			counts[result.Chapter] = chapter
+		}
+		chapter.Total++
+		total.Total++
+		if len(result.Failures) == 0 {
+			chapter.Passed++
+			total.Passed++
+		}
+	}
+
+	var summary []Chapter
+	for _, chapter := range chapters {
+		if c, ok := counts[chapter.name]; ok {
+			summary = append(summary, *c)
+		}
+	}
+	if c, ok := counts[otherChapter]; ok {
+		summary = append(summary, *c)
+	}
+	return append(summary, total)
+}
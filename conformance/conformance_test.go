@@ -0,0 +1,160 @@
+package conformance
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/rmonnet/glox/interp"
+)
+
+func TestParseExpectations(t *testing.T) {
+
+	script := `print 1; // expect: 1
+print "";  // expect:
+x.y;       // expect runtime error: Undefined variable 'x'.
+var 1;     // Error at '1': Expect variable name.
+// [line 7] Error: Unexpected character.
+// [java line 7] Error at 'b': Expect ')' after arguments.
+// [c line 7] Error at 'b': Expect ')' after arguments.
+`
+	got := ParseExpectations(script)
+	want := Expectations{
+		Output: []string{"1", ""},
+		Errors: []string{
+			"[line 3] Undefined variable 'x'.",
+			"[line 4] Error at '1': Expect variable name.",
+			"[line 7] Error: Unexpected character.",
+			"[line 7] Error at 'b': Expect ')' after arguments.",
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestCompare(t *testing.T) {
+
+	script := "print 1; // expect: 1\nprint 2; // expect: 2\n"
+	if failures := Compare(script, "1\n2\n", ""); failures != nil {
+		t.Errorf("unexpected failures %q", failures)
+	}
+	want := []string{`expected output "2" but got "3"`, `unexpected error "[line 9] Oops."`}
+	if got := Compare(script, "1\n3\n", "[line 9] Oops.\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestRun(t *testing.T) {
+
+	for _, backend := range []interp.Backend{interp.TreeWalker, interp.VM} {
+		results, err := Run("testdata/test", backend)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var paths []string
+		for _, result := range results {
+			paths = append(paths, result.Path)
+			if len(result.Failures) > 0 {
+				t.Errorf("%s failed: %q", result.Path, result.Failures)
+			}
+		}
+		// the benchmarks are not run.
+		want := []string{"bool/equality.lox", "inheritance/inherit_from_nil.lox",
+			"number/literals.lox", "number/nan_equality.lox",
+			"operator/add_bool_string.lox", "regression/40.lox",
+			"unexpected_character.lox", "variable/use_local_in_initializer.lox"}
+		if !reflect.DeepEqual(paths, want) {
+			t.Errorf("expected %q, got %q", want, paths)
+		}
+	}
+}
+
+// TestRunBook runs the whole test suite of the book, downloaded with
+// "glox conformance -download dir", when GLOX_BOOK_TESTS is set to
+// its directory.
+func TestRunBook(t *testing.T) {
+
+	dir := os.Getenv("GLOX_BOOK_TESTS")
+	if dir == "" {
+		t.Skip("GLOX_BOOK_TESTS is not set")
+	}
+	for _, backend := range []interp.Backend{interp.TreeWalker, interp.VM} {
+		results, err := Run(dir, backend)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, result := range results {
+			if len(result.Failures) > 0 {
+				t.Errorf("%s failed: %q", result.Path, result.Failures)
+			}
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+
+	results := []Result{
+		{"super/a.lox", "13. Inheritance", nil},
+		{"bool/a.lox", "8. Statements and State", nil},
+		{"bool/b.lox", "8. Statements and State", []string{"failed"}},
+		{"new/a.lox", otherChapter, nil},
+	}
+	want := []Chapter{
+		{"8. Statements and State", 1, 2},
+		{"13. Inheritance", 1, 1},
+		{otherChapter, 1, 1},
+		{"Total", 3, 4},
+	}
+	got := Summarize(results)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if got[0].Percent() != 50 {
+		t.Errorf("expected 50%%, got %v", got[0].Percent())
+	}
+}
+
+func TestExtractTests(t *testing.T) {
+
+	var archive bytes.Buffer
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	files := map[string]string{
+		"craftinginterpreters-master/test/bool/equality.lox": "print true; // expect: true\n",
+		"craftinginterpreters-master/README.md":              "not a test\n",
+		"craftinginterpreters-master/test/../escape.lox":     "outside\n",
+	}
+	for name, content := range files {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)),
+			Typeflag: tar.TypeReg})
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	gz.Close()
+
+	dir, err := ioutil.TempDir("", "glox-conformance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := extractTests(&archive, dir); err != nil {
+		t.Fatal(err)
+	}
+	var extracted []string
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			extracted = append(extracted, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	if want := []string{"bool/equality.lox"}; !reflect.DeepEqual(extracted, want) {
+		t.Errorf("expected %q, got %q", want, extracted)
+	}
+}
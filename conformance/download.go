@@ -0,0 +1,73 @@
+package conformance
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ArchiveURL is the archive of the repository of the book.
+const ArchiveURL = "https://github.com/munificent/craftinginterpreters/archive/refs/heads/master.tar.gz"
+
+// Download downloads the test directory of the repository of the
+// book into dir (which is created if needed), from the archive at
+// url, usually ArchiveURL.
+func Download(url, dir string) error {
+
+	response, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to download %s: %s", url, response.Status)
+	}
+	return extractTests(response.Body, dir)
+}
+
+// extractTests extracts the files of the test directory from
+// a gzipped tar archive of the repository.
+func extractTests(archive io.Reader, dir string) error {
+
+	gz, err := gzip.NewReader(archive)
+	if err != nil {
+		return err
+	}
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// the entries are under the directory of the archive,
+		// like craftinginterpreters-master/test/bool/equality.lox.
+		parts := strings.SplitN(filepath.ToSlash(header.Name), "/", 3)
+		if len(parts) < 3 || parts[1] != "test" || header.Typeflag != tar.TypeReg ||
+			strings.Contains(parts[2], "..") {
+			continue
+		}
+		path := filepath.Join(dir, filepath.FromSlash(parts[2]))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(file, reader)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/rmonnet/glox/dap"
+)
+
+// runDAP runs the "glox dap" subcommand, a Debug Adapter Protocol
+// server speaking to the editor over stdin and stdout. The script
+// to debug is passed as argument or by the launch request.
+func runDAP(args []string) {
+
+	flags := flag.NewFlagSet("dap", flag.ExitOnError)
+	flags.Parse(args)
+
+	if flags.NArg() > 1 {
+		fmt.Println("Usage glox dap [script]")
+		os.Exit(exUsage)
+	}
+
+	if err := dap.Serve(os.Stdin, os.Stdout, flags.Arg(0)); err != nil {
+		fmt.Fprintln(os.Stderr, "glox dap:", err)
+		os.Exit(exSwErr)
+	}
+}
@@ -0,0 +1,483 @@
+// Package dap implements a Debug Adapter Protocol server for lox, so
+// the editors supporting the protocol (like VS Code) can debug the
+// scripts. It runs a script on the tree-walker with a hook stopping
+// it on the breakpoints and after the steps (over, into and out),
+// and shows the call stack and the variables of its scopes, the
+// fields of the instances included.
+//
+// The script runs in its own goroutine, which waits in the hook while
+// the client inspects it. There is a single thread, with the id 1.
+package dap
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+
+	"github.com/rmonnet/glox/interp"
+	"github.com/rmonnet/glox/lang"
+)
+
+// threadID is the id of the thread running the script.
+const threadID = 1
+
+// stepMode tells where a running script stops next, in addition
+// to the breakpoints.
+type stepMode int
+
+const (
+	// run only stops at the breakpoints.
+	run stepMode = iota
+	// stepOver stops at the next statement of the same call
+	// or of a caller.
+	stepOver
+	// stepIn stops at the next statement.
+	stepIn
+	// stepOut stops at the next statement of a caller.
+	stepOut
+	// pause stops as soon as possible.
+	pause
+)
+
+// server holds the state of a debugging session. The mutex protects
+// the members used by both the goroutine reading the requests and
+// the goroutine running the script.
+type server struct {
+	mu  sync.Mutex
+	out io.Writer
+	seq int
+
+	program string
+	script  string
+	// lines holds the lines where a statement starts,
+	// the breakpoints must be set on them.
+	lines  map[int]bool
+	lox    *interp.Interp
+	cancel context.CancelFunc
+	// started is set once the script runs,
+	// done is closed when it is over.
+	started bool
+	done    chan struct{}
+
+	breakpoints map[int]bool
+	mode        stepMode
+	stopOnEntry bool
+	// stepDepth is the number of calls when the step started.
+	stepDepth int
+	// lastLine and lastDepth locate the previous statement, the
+	// statements following it on the same line don't stop
+	// at its breakpoint again.
+	lastLine, lastDepth int
+
+	// stopped is set while the script waits in the hook for resume.
+	// frames and references describe it: references lists the
+	// variables of the scopes and of the instances shown to the
+	// client, the reference n is at index n-1.
+	stopped    bool
+	resume     chan struct{}
+	frames     []interp.Frame
+	references []func() []interp.Variable
+}
+
+// Serve runs a debugging session reading the requests of the client
+// from in and writing its responses and events to out, usually the
+// standard input and output. The script to debug is the program
+// passed to the launch request, or program if there is none.
+// Serve returns when the client disconnects or closes its input.
+func Serve(in io.Reader, out io.Writer, program string) error {
+
+	s := &server{out: out, program: program, breakpoints: map[int]bool{},
+		done: make(chan struct{}), resume: make(chan struct{}, 1)}
+	defer s.stop()
+	reader := bufio.NewReader(in)
+	for {
+		body, err := readMessage(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var request message
+		if err := json.Unmarshal(body, &request); err != nil {
+			return fmt.Errorf("invalid message: %v", err)
+		}
+		if request.Type != "request" {
+			continue
+		}
+		disconnect, err := s.handle(&request)
+		if err != nil || disconnect {
+			return err
+		}
+	}
+}
+
+// handle answers a request. It reports if the client disconnected.
+// It only fails if the output can't be written.
+func (s *server) handle(request *message) (bool, error) {
+
+	var body interface{}
+	var err error
+	switch request.Command {
+	case "initialize":
+		body = map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsTerminateRequest":         true,
+		}
+	case "launch":
+		var args launchArguments
+		if err = decode(request, &args); err == nil {
+			err = s.launch(args)
+		}
+		if err == nil {
+			// the breakpoints are set once the script is known.
+			if err := s.respond(request, nil, nil); err != nil {
+				return false, err
+			}
+			return false, s.event("initialized", nil)
+		}
+	case "setBreakpoints":
+		var args setBreakpointsArguments
+		if err = decode(request, &args); err == nil {
+			body = map[string]interface{}{"breakpoints": s.setBreakpoints(args)}
+		}
+	case "configurationDone":
+		s.start()
+	case "threads":
+		body = map[string]interface{}{"threads": []thread{{threadID, "main"}}}
+	case "stackTrace":
+		body = s.stackTrace()
+	case "scopes":
+		var args frameArguments
+		if err = decode(request, &args); err == nil {
+			body, err = s.scopes(args.FrameID)
+		}
+	case "variables":
+		var args variablesArguments
+		if err = decode(request, &args); err == nil {
+			body, err = s.variables(args.VariablesReference)
+		}
+	case "continue":
+		s.continueWith(run)
+		body = map[string]interface{}{"allThreadsContinued": true}
+	case "next":
+		s.continueWith(stepOver)
+	case "stepIn":
+		s.continueWith(stepIn)
+	case "stepOut":
+		s.continueWith(stepOut)
+	case "pause":
+		s.mu.Lock()
+		s.mode = pause
+		s.mu.Unlock()
+	case "terminate":
+		s.stop()
+	case "disconnect":
+		s.stop()
+		return true, s.respond(request, nil, nil)
+	default:
+		err = fmt.Errorf("unsupported request %s", request.Command)
+	}
+	return false, s.respond(request, body, err)
+}
+
+// decode decodes the arguments of a request.
+func decode(request *message, args interface{}) error {
+
+	if len(request.Arguments) == 0 {
+		return nil
+	}
+	return json.Unmarshal(request.Arguments, args)
+}
+
+// respond answers a request, with an error message if err is not nil.
+func (s *server) respond(request *message, body interface{}, err error) error {
+
+	success := err == nil
+	response := &message{Type: "response", Command: request.Command,
+		RequestSeq: request.Seq, Success: &success, Body: body}
+	if err != nil {
+		response.Message = err.Error()
+	}
+	return s.send(response)
+}
+
+// event sends an event to the client.
+func (s *server) event(event string, body interface{}) error {
+
+	return s.send(&message{Type: "event", Event: event, Body: body})
+}
+
+// send numbers and writes a message. The events are sent
+// by both goroutines.
+func (s *server) send(msg *message) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	msg.Seq = s.seq
+	return writeMessage(s.out, msg)
+}
+
+// output sends what the script writes to out or errOut
+// as output events.
+type output struct {
+	s        *server
+	category string
+}
+
+// Write sends an output event.
+func (o output) Write(p []byte) (int, error) {
+
+	err := o.s.event("output", map[string]string{"category": o.category, "output": string(p)})
+	return len(p), err
+}
+
+// launch reads and parses the script, which runs once the client
+// is done with the configuration.
+func (s *server) launch(args launchArguments) error {
+
+	if args.Program != "" {
+		s.program = args.Program
+	}
+	if s.program == "" {
+		return fmt.Errorf("no program to debug")
+	}
+	script, err := ioutil.ReadFile(s.program)
+	if err != nil {
+		return err
+	}
+	s.script = string(script)
+
+	s.lines = map[int]bool{}
+	statements, _ := interp.New(ioutil.Discard, ioutil.Discard).Parse(s.script)
+	lang.Walk(statements, func(node lang.Node, depth int) {
+		if stmt, ok := node.(lang.Stmt); ok {
+			if _, isBlock := stmt.(*lang.BlockStmt); !isBlock {
+				if token := lang.StmtStart(stmt); token != nil {
+					s.lines[token.Line] = true
+				}
+			}
+		}
+	})
+
+	s.lox = interp.New(output{s, "stdout"}, output{s, "stderr"})
+	s.lox.SetScriptName(s.program)
+	ctx, cancel := context.WithCancel(context.Background())
+	s.lox.SetContext(ctx)
+	s.cancel = cancel
+	if !args.NoDebug {
+		s.lox.SetHook(s.hook)
+	}
+	s.stopOnEntry = args.StopOnEntry
+	return nil
+}
+
+// setBreakpoints replaces the breakpoints of the script. The lines
+// where no statement starts (like a comment) are not verified.
+func (s *server) setBreakpoints(args setBreakpointsArguments) []breakpoint {
+
+	lines := args.Lines
+	if args.Breakpoints != nil {
+		lines = lines[:0]
+		for _, b := range args.Breakpoints {
+			lines = append(lines, b.Line)
+		}
+	}
+
+	same := s.lox != nil && samePath(args.Source.Path, s.program)
+	breakpoints := []breakpoint{}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if same {
+		s.breakpoints = map[int]bool{}
+	}
+	for _, line := range lines {
+		b := breakpoint{Verified: same && s.lines[line], Line: line}
+		if b.Verified {
+			s.breakpoints[line] = true
+		} else if same {
+			b.Message = "No statement on this line."
+		} else {
+			b.Message = "Only the breakpoints of the program are supported."
+		}
+		breakpoints = append(breakpoints, b)
+	}
+	return breakpoints
+}
+
+// samePath checks two paths designate the same file.
+func samePath(a, b string) bool {
+
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+// start runs the script in its own goroutine, the exited and
+// terminated events are sent when it is over.
+func (s *server) start() {
+
+	s.mu.Lock()
+	if s.lox == nil || s.started {
+		s.mu.Unlock()
+		return
+	}
+	s.started = true
+	if s.stopOnEntry {
+		s.mode = pause
+	}
+	s.mu.Unlock()
+
+	go func() {
+		defer close(s.done)
+		s.lox.Run(s.script, false)
+		exitCode := 0
+		if s.lox.HadCompileError() {
+			exitCode = 65
+		} else if s.lox.HadRuntimeError() {
+			exitCode = 70
+		}
+		s.event("exited", map[string]int{"exitCode": exitCode})
+		s.event("terminated", nil)
+	}()
+}
+
+// stop cancels the script and waits for its end.
+func (s *server) stop() {
+
+	s.mu.Lock()
+	started := s.started
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.breakpoints = map[int]bool{}
+	s.resumeLocked(run)
+	s.mu.Unlock()
+	if started {
+		<-s.done
+	}
+}
+
+// hook is called before each statement of the script, it waits
+// for the client to resume the script if it has to stop there.
+func (s *server) hook(stmt lang.Stmt) {
+
+	token := lang.StmtStart(stmt)
+	depth := s.lox.Depth()
+
+	s.mu.Lock()
+	reason := ""
+	switch {
+	case s.mode == pause && s.stopOnEntry:
+		reason = "entry"
+	case s.mode == pause:
+		reason = "pause"
+	case s.mode == stepIn,
+		s.mode == stepOver && depth <= s.stepDepth,
+		s.mode == stepOut && depth < s.stepDepth:
+		reason = "step"
+	case s.breakpoints[token.Line] && (token.Line != s.lastLine || depth != s.lastDepth):
+		reason = "breakpoint"
+	}
+	s.lastLine, s.lastDepth = token.Line, depth
+	s.stopOnEntry = false
+	if reason == "" {
+		s.mu.Unlock()
+		return
+	}
+	s.stopped = true
+	s.frames = s.lox.Frames()
+	s.references = nil
+	s.mu.Unlock()
+
+	s.event("stopped", map[string]interface{}{"reason": reason,
+		"threadId": threadID, "allThreadsStopped": true})
+	<-s.resume
+}
+
+// continueWith resumes the script stopped in the hook.
+func (s *server) continueWith(mode stepMode) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resumeLocked(mode)
+}
+
+// resumeLocked resumes the script, if it is stopped, with the mutex
+// held. The steps start from the number of calls where it stopped.
+func (s *server) resumeLocked(mode stepMode) {
+
+	if !s.stopped {
+		return
+	}
+	s.mode = mode
+	s.stepDepth = len(s.frames)
+	s.stopped = false
+	s.frames = nil
+	s.references = nil
+	s.resume <- struct{}{}
+}
+
+// stackTrace returns the calls of the stopped script,
+// the innermost first. The id of a frame is its index plus 1.
+func (s *server) stackTrace() interface{} {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	frames := []stackFrame{}
+	for n, f := range s.frames {
+		frames = append(frames, stackFrame{n + 1, f.Function,
+			source{filepath.Base(s.program), s.program}, f.Token.Line, f.Token.Column})
+	}
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}
+}
+
+// scopes returns the scopes of a frame: its local variables
+// and the globals.
+func (s *server) scopes(frameID int) (interface{}, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if frameID < 1 || frameID > len(s.frames) {
+		return nil, fmt.Errorf("unknown frame %d", frameID)
+	}
+	frame := s.frames[frameID-1]
+	return map[string]interface{}{"scopes": []scope{
+		{"Locals", s.reference(frame.Locals), false},
+		{"Globals", s.reference(s.lox.Globals), false},
+	}}, nil
+}
+
+// reference records variables shown to the client
+// and returns their reference.
+func (s *server) reference(variables func() []interp.Variable) int {
+
+	s.references = append(s.references, variables)
+	return len(s.references)
+}
+
+// variables returns the variables of a scope or the fields of an
+// instance. The instances having fields can be expanded.
+func (s *server) variables(reference int) (interface{}, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if reference < 1 || reference > len(s.references) {
+		return nil, fmt.Errorf("unknown variables reference %d", reference)
+	}
+	variables := []variable{}
+	for _, v := range s.references[reference-1]() {
+		fieldsReference := 0
+		if len(v.Fields()) > 0 {
+			fieldsReference = s.reference(v.Fields)
+		}
+		variables = append(variables, variable{v.Name, v.Value, fieldsReference})
+	}
+	return map[string]interface{}{"variables": variables}, nil
+}
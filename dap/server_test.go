@@ -0,0 +1,274 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// client drives a server from a test.
+type client struct {
+	t        *testing.T
+	in       *io.PipeWriter
+	seq      int
+	messages chan map[string]interface{}
+	done     chan error
+}
+
+// newClient starts a server debugging the script.
+func newClient(t *testing.T, script string) (*client, string) {
+
+	dir, err := ioutil.TempDir("", "glox-dap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	program := filepath.Join(dir, "test.lox")
+	if err := ioutil.WriteFile(program, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	inReader, inWriter := io.Pipe()
+	outReader, outWriter := io.Pipe()
+	c := &client{t: t, in: inWriter, messages: make(chan map[string]interface{}, 100),
+		done: make(chan error, 1)}
+	go func() {
+		c.done <- Serve(inReader, outWriter, program)
+		outWriter.Close()
+	}()
+	go func() {
+		reader := bufio.NewReader(outReader)
+		for {
+			body, err := readMessage(reader)
+			if err != nil {
+				close(c.messages)
+				return
+			}
+			var msg map[string]interface{}
+			json.Unmarshal(body, &msg)
+			c.messages <- msg
+		}
+	}()
+	return c, program
+}
+
+// send sends a request.
+func (c *client) send(command string, arguments string) {
+
+	c.seq++
+	if arguments == "" {
+		arguments = "{}"
+	}
+	msg := fmt.Sprintf(`{"seq":%d,"type":"request","command":%q,"arguments":%s}`,
+		c.seq, command, arguments)
+	fmt.Fprintf(c.in, "Content-Length: %d\r\n\r\n%s", len(msg), msg)
+}
+
+// expect waits for the response to a command or an event
+// and returns its body. The output events are collected.
+func (c *client) expect(kind, name string, output *strings.Builder) map[string]interface{} {
+
+	c.t.Helper()
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case msg, ok := <-c.messages:
+			if !ok {
+				c.t.Fatalf("connection closed while waiting for %s %s", kind, name)
+			}
+			if msg["event"] == "output" && output != nil {
+				output.WriteString(msg["body"].(map[string]interface{})["output"].(string))
+			}
+			if msg["type"] == kind && (msg["command"] == name || msg["event"] == name) {
+				if msg["success"] == false {
+					c.t.Fatalf("%s failed: %v", name, msg["message"])
+				}
+				body, _ := msg["body"].(map[string]interface{})
+				return body
+			}
+		case <-timeout:
+			c.t.Fatalf("timeout waiting for %s %s", kind, name)
+		}
+	}
+}
+
+// request sends a request and returns the body of its response.
+func (c *client) request(command, arguments string) map[string]interface{} {
+
+	c.t.Helper()
+	c.send(command, arguments)
+	return c.expect("response", command, nil)
+}
+
+// launch starts the debugging session with breakpoints
+// on the lines.
+func (c *client) launch(program string, stopOnEntry bool, lines ...int) []interface{} {
+
+	c.t.Helper()
+	c.request("initialize", `{"adapterID":"glox"}`)
+	c.request("launch", fmt.Sprintf(`{"stopOnEntry":%v}`, stopOnEntry))
+	c.expect("event", "initialized", nil)
+	source, _ := json.Marshal(program)
+	lineList, _ := json.Marshal(lines)
+	body := c.request("setBreakpoints", fmt.Sprintf(
+		`{"source":{"path":%s},"lines":%s}`, source, lineList))
+	c.request("configurationDone", "")
+	return body["breakpoints"].([]interface{})
+}
+
+// stack returns the function and the line of the frames
+// of the stopped script.
+func (c *client) stack() string {
+
+	c.t.Helper()
+	var frames []string
+	for _, f := range c.request("stackTrace", `{"threadId":1}`)["stackFrames"].([]interface{}) {
+		frame := f.(map[string]interface{})
+		frames = append(frames, fmt.Sprintf("%s:%v", frame["name"], frame["line"]))
+	}
+	return strings.Join(frames, " ")
+}
+
+// variables returns the variables of a reference as name=value,
+// the fields of the instances are listed in braces.
+func (c *client) variables(reference float64) string {
+
+	c.t.Helper()
+	var variables []string
+	body := c.request("variables", fmt.Sprintf(`{"variablesReference":%v}`, reference))
+	for _, v := range body["variables"].([]interface{}) {
+		variable := v.(map[string]interface{})
+		text := fmt.Sprintf("%s=%s", variable["name"], variable["value"])
+		if fields := variable["variablesReference"].(float64); fields > 0 {
+			text += "{" + c.variables(fields) + "}"
+		}
+		variables = append(variables, text)
+	}
+	return strings.Join(variables, " ")
+}
+
+// scopes returns the variables of the scopes of a frame.
+func (c *client) scopes(frameID int) []string {
+
+	c.t.Helper()
+	var scopes []string
+	body := c.request("scopes", fmt.Sprintf(`{"frameId":%d}`, frameID))
+	for _, s := range body["scopes"].([]interface{}) {
+		scope := s.(map[string]interface{})
+		scopes = append(scopes, c.variables(scope["variablesReference"].(float64)))
+	}
+	return scopes
+}
+
+// script is the script debugged by the tests.
+const script = `class Point {
+  init(x) { this.x = x; }
+}
+fun add(a, b) {
+  var sum = a + b;
+  return sum;
+}
+var p = Point(1);
+var total = add(p.x, 2);
+print total;
+print "done";
+`
+
+func TestBreakpointsAndVariables(t *testing.T) {
+
+	c, program := newClient(t, script)
+	breakpoints := c.launch(program, false, 3, 5)
+	verified := []interface{}{}
+	for _, b := range breakpoints {
+		verified = append(verified, b.(map[string]interface{})["verified"])
+	}
+	if fmt.Sprint(verified) != "[false true]" {
+		t.Errorf("unexpected breakpoints %v", breakpoints)
+	}
+
+	if reason := c.expect("event", "stopped", nil)["reason"]; reason != "breakpoint" {
+		t.Errorf("expected a breakpoint, got %v", reason)
+	}
+	if stack := c.stack(); stack != "add:5 script:9" {
+		t.Errorf("unexpected stack %s", stack)
+	}
+	scopes := c.scopes(1)
+	if scopes[0] != "b=2 a=1" {
+		t.Errorf("unexpected locals %s", scopes[0])
+	}
+	if !strings.Contains(scopes[1], "p=<instance Point>{x=1}") {
+		t.Errorf("unexpected globals %s", scopes[1])
+	}
+
+	output := &strings.Builder{}
+	c.request("continue", `{"threadId":1}`)
+	if code := c.expect("event", "exited", output)["exitCode"]; code != 0.0 {
+		t.Errorf("unexpected exit code %v", code)
+	}
+	if output.String() != "3\ndone\n" {
+		t.Errorf("unexpected output %q", output.String())
+	}
+	c.expect("event", "terminated", nil)
+	c.request("disconnect", "")
+	if err := <-c.done; err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestSteps(t *testing.T) {
+
+	c, program := newClient(t, script)
+	c.launch(program, true)
+
+	steps := []struct {
+		command string
+		stack   string
+	}{
+		{"", "script:1"},
+		{"next", "script:4"},
+		{"next", "script:8"},
+		{"stepIn", "init:2 script:8"},
+		{"stepOut", "script:9"},
+		{"stepIn", "add:5 script:9"},
+		{"next", "add:6 script:9"},
+		{"next", "script:10"},
+	}
+	for _, step := range steps {
+		if step.command != "" {
+			c.request(step.command, `{"threadId":1}`)
+		}
+		c.expect("event", "stopped", nil)
+		if stack := c.stack(); stack != step.stack {
+			t.Errorf("after %s, expected %s, got %s", step.command, step.stack, stack)
+		}
+	}
+	c.request("disconnect", "")
+	if err := <-c.done; err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestRuntimeError(t *testing.T) {
+
+	c, program := newClient(t, `print 1;
+print -"a";`)
+	c.launch(program, false)
+	output := &strings.Builder{}
+	if code := c.expect("event", "exited", output)["exitCode"]; code != 70.0 {
+		t.Errorf("unexpected exit code %v", code)
+	}
+	if output.String() != "1\n[line 2] Operand must be a number.\n" {
+		t.Errorf("unexpected output %q", output.String())
+	}
+	c.in.Close()
+	if err := <-c.done; err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/rmonnet/glox/debugger"
+)
+
+// runDebug runs the "glox debug" subcommand, an interactive
+// debugger for a script with a gdb-style prompt.
+func runDebug(args []string) {
+
+	flags := flag.NewFlagSet("debug", flag.ExitOnError)
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Println("Usage glox debug script")
+		os.Exit(exUsage)
+	}
+
+	filename := flags.Arg(0)
+	script, err := ioutil.ReadFile(filename)
+	if err != nil {
+		fmt.Println("unable to read ", filename)
+		os.Exit(exDataErr)
+	}
+	debugger.Run(os.Stdin, os.Stdout, os.Stderr, filename, string(script))
+}
@@ -0,0 +1,355 @@
+// Package debugger implements the interactive debugger of
+// "glox debug", with a prompt and commands modeled after gdb:
+//
+//	break [file:]line   stop before the statements of the line
+//	delete [line]       remove a breakpoint (all without line)
+//	run                 run the script from the start
+//	continue            resume until the next breakpoint
+//	step                stop at the next statement
+//	next                stop at the next statement of the same call
+//	finish              stop at the next statement of the caller
+//	print expr          evaluate an expression in the current frame
+//	backtrace           list the active calls
+//	locals              list the local variables of the current frame
+//	quit                stop the script and leave
+//
+// The debugger is built on the execution hook of the tree-walker
+// (see interp.Interp.SetHook): the commands are read from the hook
+// while the script is stopped.
+package debugger
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rmonnet/glox/interp"
+	"github.com/rmonnet/glox/lang"
+)
+
+// stepMode tells where a running script stops next, in addition
+// to the breakpoints.
+type stepMode int
+
+const (
+	// run only stops at the breakpoints.
+	run stepMode = iota
+	// stepIn stops at the next statement.
+	stepIn
+	// stepOver stops at the next statement of the same call
+	// or of a caller.
+	stepOver
+	// stepOut stops at the next statement of a caller.
+	stepOut
+)
+
+// debugger holds the state of a debugging session.
+type debugger struct {
+	in      *bufio.Scanner
+	out     io.Writer
+	errOut  *mutable
+	program string
+	script  string
+	// lines holds the lines where a statement starts,
+	// the breakpoints must be set on them.
+	lines       map[int]bool
+	breakpoints map[int]bool
+
+	lox     *interp.Interp
+	cancel  context.CancelFunc
+	running bool
+	// quit is set once the user asked to leave.
+	quit bool
+
+	mode stepMode
+	// stepDepth is the number of calls when the step started.
+	stepDepth int
+	// lastLine and lastDepth locate the previous statement, the
+	// statements following it on the same line don't stop
+	// at its breakpoint again.
+	lastLine, lastDepth int
+	// frames are the active calls while the script is stopped.
+	frames []interp.Frame
+}
+
+// mutable is the error output of the script, muted when the user
+// quits so the cancellation of the script is not reported.
+type mutable struct {
+	w     io.Writer
+	muted bool
+}
+
+// Write writes to the error output, the writes are dropped (but
+// reported as done) once the output is muted.
+func (m *mutable) Write(p []byte) (int, error) {
+
+	if m.muted {
+		return len(p), nil
+	}
+	return m.w.Write(p)
+}
+
+// Run runs a debugging session of a script, read from the file
+// program, with the commands read from in. The script and the
+// debugger write to out and errOut. Run returns when the user
+// quits or in is closed.
+func Run(in io.Reader, out, errOut io.Writer, program string, script string) {
+
+	d := &debugger{
+		in:          bufio.NewScanner(in),
+		out:         out,
+		errOut:      &mutable{w: errOut},
+		program:     program,
+		script:      script,
+		lines:       map[int]bool{},
+		breakpoints: map[int]bool{},
+	}
+	statements, _ := interp.New(ioutil.Discard, ioutil.Discard).Parse(script)
+	lang.Walk(statements, func(node lang.Node, depth int) {
+		if stmt, ok := node.(lang.Stmt); ok {
+			if _, isBlock := stmt.(*lang.BlockStmt); !isBlock {
+				if token := lang.StmtStart(stmt); token != nil {
+					d.lines[token.Line] = true
+				}
+			}
+		}
+	})
+
+	for !d.quit && d.prompt() {
+	}
+}
+
+// prompt reads and executes a command. It returns false if
+// there are no more commands or if the script must resume.
+func (d *debugger) prompt() bool {
+
+	fmt.Fprint(d.out, "(glox) ")
+	if !d.in.Scan() {
+		fmt.Fprintln(d.out)
+		d.leave()
+		return false
+	}
+	fields := strings.Fields(d.in.Text())
+	if len(fields) == 0 {
+		return true
+	}
+	command, arg := fields[0], strings.TrimSpace(strings.TrimPrefix(d.in.Text(), fields[0]))
+	switch command {
+	case "break", "b":
+		d.setBreakpoint(arg)
+	case "delete", "d":
+		d.deleteBreakpoint(arg)
+	case "run", "r":
+		if d.running {
+			fmt.Fprintln(d.out, "The script is already running.")
+			return true
+		}
+		d.start()
+	case "continue", "c":
+		return d.resume(run)
+	case "step", "s":
+		return d.resume(stepIn)
+	case "next", "n":
+		return d.resume(stepOver)
+	case "finish":
+		return d.resume(stepOut)
+	case "print", "p":
+		d.print(arg)
+	case "backtrace", "bt":
+		d.backtrace()
+	case "locals":
+		d.locals()
+	case "quit", "q":
+		d.leave()
+		return false
+	case "help", "h":
+		fmt.Fprintln(d.out, "Commands: break [file:]line, delete [line], run, continue, step, "+
+			"next, finish, print expr, backtrace, locals, quit.")
+	default:
+		fmt.Fprintf(d.out, "Unknown command '%s', try help.\n", command)
+	}
+	return true
+}
+
+// leave ends the session, the running script is cancelled
+// before its next statement.
+func (d *debugger) leave() {
+
+	d.quit = true
+	if d.running {
+		d.errOut.muted = true
+		d.cancel()
+	}
+}
+
+// setBreakpoint adds a breakpoint at "line" or "file:line".
+func (d *debugger) setBreakpoint(location string) {
+
+	if i := strings.LastIndex(location, ":"); i >= 0 {
+		if !samePath(location[:i], d.program) {
+			fmt.Fprintf(d.out, "Only the breakpoints of %s are supported.\n", d.program)
+			return
+		}
+		location = location[i+1:]
+	}
+	line, err := strconv.Atoi(location)
+	if err != nil {
+		fmt.Fprintln(d.out, "Usage: break [file:]line")
+		return
+	}
+	if !d.lines[line] {
+		fmt.Fprintf(d.out, "No statement on line %d.\n", line)
+		return
+	}
+	d.breakpoints[line] = true
+	fmt.Fprintf(d.out, "Breakpoint at %s:%d.\n", d.program, line)
+}
+
+// deleteBreakpoint removes the breakpoint of a line,
+// or all of them if the line is empty.
+func (d *debugger) deleteBreakpoint(location string) {
+
+	if location == "" {
+		d.breakpoints = map[int]bool{}
+		return
+	}
+	line, err := strconv.Atoi(location)
+	if err != nil || !d.breakpoints[line] {
+		fmt.Fprintf(d.out, "No breakpoint on line %s.\n", location)
+		return
+	}
+	delete(d.breakpoints, line)
+}
+
+// samePath checks two paths designate the same file,
+// the base name of the file is enough.
+func samePath(a, b string) bool {
+
+	if a == filepath.Base(b) {
+		return true
+	}
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+// start runs the script in a new interpreter, the commands are
+// read from the hook when it stops.
+func (d *debugger) start() {
+
+	d.lox = interp.New(d.out, d.errOut)
+	d.lox.SetScriptName(d.program)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d.lox.SetContext(ctx)
+	d.cancel = cancel
+	d.lox.SetHook(d.hook)
+	d.mode = run
+	d.lastLine, d.lastDepth = 0, 0
+
+	d.running = true
+	d.lox.Run(d.script, false)
+	d.running = false
+	if !d.quit {
+		fmt.Fprintln(d.out, "The script exited.")
+	}
+}
+
+// hook is called before each statement of the script, it reads
+// the commands until the script resumes if it has to stop there.
+func (d *debugger) hook(stmt lang.Stmt) {
+
+	token := lang.StmtStart(stmt)
+	depth := d.lox.Depth()
+
+	stop := false
+	switch {
+	case d.mode == stepIn,
+		d.mode == stepOver && depth <= d.stepDepth,
+		d.mode == stepOut && depth < d.stepDepth:
+		stop = true
+	case d.breakpoints[token.Line] && (token.Line != d.lastLine || depth != d.lastDepth):
+		fmt.Fprintf(d.out, "Breakpoint at %s:%d.\n", d.program, token.Line)
+		stop = true
+	}
+	d.lastLine, d.lastDepth = token.Line, depth
+	if !stop || d.quit {
+		return
+	}
+
+	d.frames = d.lox.Frames()
+	d.showLine(token.Line)
+	for d.prompt() {
+	}
+	d.frames = nil
+}
+
+// resume resumes the stopped script. It returns false
+// if the script is not running.
+func (d *debugger) resume(mode stepMode) bool {
+
+	if d.frames == nil {
+		fmt.Fprintln(d.out, "The script is not running.")
+		return true
+	}
+	d.mode = mode
+	d.stepDepth = len(d.frames)
+	return false
+}
+
+// showLine writes a line of the script with its number.
+func (d *debugger) showLine(line int) {
+
+	lines := strings.Split(d.script, "\n")
+	if line >= 1 && line <= len(lines) {
+		fmt.Fprintf(d.out, "%d\t%s\n", line, strings.TrimRight(lines[line-1], "\r"))
+	}
+}
+
+// print evaluates an expression in the innermost frame.
+func (d *debugger) print(expression string) {
+
+	if d.frames == nil {
+		fmt.Fprintln(d.out, "The script is not running.")
+		return
+	}
+	value, err := d.frames[0].Evaluate(expression)
+	if err != nil {
+		fmt.Fprintln(d.out, err)
+		return
+	}
+	fmt.Fprintln(d.out, value.Value)
+}
+
+// backtrace writes the active calls, the innermost first.
+func (d *debugger) backtrace() {
+
+	if d.frames == nil {
+		fmt.Fprintln(d.out, "The script is not running.")
+		return
+	}
+	for n, frame := range d.frames {
+		fmt.Fprintf(d.out, "#%d %s at %s:%d\n", n, frame.Function, d.program, frame.Token.Line)
+	}
+}
+
+// locals writes the local variables of the innermost frame,
+// sorted by name.
+func (d *debugger) locals() {
+
+	if d.frames == nil {
+		fmt.Fprintln(d.out, "The script is not running.")
+		return
+	}
+	locals := d.frames[0].Locals()
+	sort.SliceStable(locals, func(i, j int) bool { return locals[i].Name < locals[j].Name })
+	for _, local := range locals {
+		fmt.Fprintf(d.out, "%s = %s\n", local.Name, local.Value)
+	}
+}
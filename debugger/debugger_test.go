@@ -0,0 +1,91 @@
+package debugger
+
+import (
+	"strings"
+	"testing"
+)
+
+const script = `fun fact(n) {
+  if (n < 2) return 1;
+  return n * fact(n - 1);
+}
+var x = 3;
+print fact(x);
+print "done";
+`
+
+// session runs the commands on the script and returns
+// the output of the debugger.
+func session(t *testing.T, commands ...string) string {
+
+	var out, errOut strings.Builder
+	Run(strings.NewReader(strings.Join(commands, "\n")+"\n"), &out, &errOut, "test.lox", script)
+	if errOut.Len() > 0 {
+		t.Errorf("unexpected errors: %s", errOut.String())
+	}
+	return strings.ReplaceAll(out.String(), "(glox) ", "")
+}
+
+func TestBreakpoints(t *testing.T) {
+
+	got := session(t, "break test.lox:3", "run", "print n", "continue", "print n", "delete", "continue")
+	want := `Breakpoint at test.lox:3.
+Breakpoint at test.lox:3.
+3	  return n * fact(n - 1);
+3
+Breakpoint at test.lox:3.
+3	  return n * fact(n - 1);
+2
+6
+done
+The script exited.
+
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSteps(t *testing.T) {
+
+	got := session(t, "break 6", "run", "step", "step", "step", "step", "backtrace",
+		"finish", "next", "quit")
+	want := `Breakpoint at test.lox:6.
+Breakpoint at test.lox:6.
+6	print fact(x);
+2	  if (n < 2) return 1;
+3	  return n * fact(n - 1);
+2	  if (n < 2) return 1;
+3	  return n * fact(n - 1);
+#0 fact at test.lox:3
+#1 fact at test.lox:3
+#2 script at test.lox:6
+6
+7	print "done";
+done
+The script exited.
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestPrint(t *testing.T) {
+
+	got := session(t, "print x", "break 3", "break 9", "run", "print n * x", "print fact(n)",
+		"print x = 10", "locals", "print y", "quit")
+	want := `The script is not running.
+Breakpoint at test.lox:3.
+No statement on line 9.
+Breakpoint at test.lox:3.
+3	  return n * fact(n - 1);
+9
+6
+10
+n = 3
+Undefined variable 'y'. Did you mean 'n'?
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/rmonnet/glox/bytecode"
+	"github.com/rmonnet/glox/interp"
+)
+
+// runDisasm runs the "glox disasm" subcommand. It compiles the
+// scripts to bytecode without running them and prints the chunks
+// of each function with the offsets, the opcodes, the constants
+// and the lines of the instructions, like the debug output of clox.
+func runDisasm(args []string) {
+
+	flags := flag.NewFlagSet("disasm", flag.ExitOnError)
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		fmt.Println("Usage glox disasm script...")
+		os.Exit(exUsage)
+	}
+
+	for n, filename := range flags.Args() {
+		script, err := ioutil.ReadFile(filename)
+		if err != nil {
+			fmt.Println("unable to read ", filename)
+			os.Exit(exDataErr)
+		}
+		function, ok := interp.New(os.Stdout, os.Stderr).Compile(string(script))
+		if !ok {
+			os.Exit(exDataErr)
+		}
+		if n > 0 {
+			fmt.Println()
+		}
+		bytecode.Disassemble(os.Stdout, function)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/rmonnet/glox/interp"
+	"github.com/rmonnet/glox/lang"
+)
+
+// runDoc runs the "glox doc" subcommand. It writes the
+// documentation of the functions and classes declared in
+// the scripts, extracted from their "///" comments, as
+// Markdown (or an HTML page with -html) on stdout.
+func runDoc(args []string) {
+
+	flags := flag.NewFlagSet("doc", flag.ExitOnError)
+	asHTML := flags.Bool("html", false, "write an HTML page instead of Markdown")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		fmt.Println("Usage glox doc [-html] script...")
+		os.Exit(exUsage)
+	}
+
+	if *asHTML {
+		fmt.Println("<!DOCTYPE html>\n<html>\n<body>")
+	}
+	for n, filename := range flags.Args() {
+		script, err := ioutil.ReadFile(filename)
+		if err != nil {
+			fmt.Println("unable to read ", filename)
+			os.Exit(exDataErr)
+		}
+		statements, ok := interp.New(os.Stdout, os.Stderr).Parse(string(script))
+		if !ok {
+			os.Exit(exDataErr)
+		}
+		if *asHTML {
+			lang.WriteHTML(os.Stdout, filename, statements)
+		} else {
+			if n > 0 {
+				fmt.Println()
+			}
+			lang.WriteMarkdown(os.Stdout, filename, statements)
+		}
+	}
+	if *asHTML {
+		fmt.Println("</body>\n</html>")
+	}
+}
@@ -0,0 +1,203 @@
+// Package evalserver implements the HTTP server of "glox serve", which
+// runs the scripts it receives and returns their output and their
+// diagnostics as JSON, for the playgrounds and the grading systems.
+//
+// The scripts are posted to /run, either as the body of the request
+// or, with the content type application/json, as the member script
+// of a JSON object:
+//
+//	{"script": "print 1 + 2;"}
+//
+// The response is a JSON object like:
+//
+//	{"output": "3\n", "diagnostics": [], "exitCode": 0}
+//
+// The exit code is the one of glox (65 for compile errors and 70 for
+// runtime errors) and the diagnostics are the errors and the warnings
+// written by "glox -json". Each script runs in a new interpreter,
+// limited by the Config.
+package evalserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/rmonnet/glox/interp"
+)
+
+// Config holds the limits of the interpreters running the scripts.
+// A zero limit is no limit.
+type Config struct {
+	// MaxSteps is the number of statements a script can execute.
+	MaxSteps int
+	// MaxMemory is the approximate number of bytes a script
+	// can allocate.
+	MaxMemory int
+	// MaxCallDepth is the number of nested calls.
+	MaxCallDepth int
+	// MaxStringLength is the length in bytes of the strings
+	// a script can build.
+	MaxStringLength int
+	// MaxFields is the number of fields of an instance.
+	MaxFields int
+	// Timeout is the duration a script can run.
+	Timeout time.Duration
+	// MaxScriptSize is the size of the requests in bytes.
+	MaxScriptSize int64
+	// MaxOutput is the size of the output kept in bytes,
+	// the rest is dropped.
+	MaxOutput int
+	// Backend runs the scripts on the tree-walker or on the VM.
+	Backend interp.Backend
+}
+
+// DefaultConfig are limits suitable for a public playground.
+var DefaultConfig = Config{
+	MaxSteps:        10000000,
+	MaxMemory:       64 << 20,
+	MaxCallDepth:    interp.DefaultMaxCallDepth,
+	MaxStringLength: 1 << 20,
+	MaxFields:       10000,
+	Timeout:         5 * time.Second,
+	MaxScriptSize:   1 << 20,
+	MaxOutput:       1 << 20,
+	Backend:         interp.TreeWalker,
+}
+
+// Diagnostic is an error or a warning reported while running
+// a script, see interp.SetJSONDiagnostics.
+type Diagnostic struct {
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Code     string `json:"code"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// Result is the response to a script.
+type Result struct {
+	Output      string       `json:"output"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+	ExitCode    int          `json:"exitCode"`
+	// Truncated is set if the output exceeded Config.MaxOutput.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// Handler returns the handler of the server: POST /run runs
+// a script and GET /health checks the server is up.
+func Handler(config Config) http.Handler {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/run", func(w http.ResponseWriter, r *http.Request) {
+		serveRun(w, r, config)
+	})
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok\n")
+	})
+	return mux
+}
+
+// serveRun answers a POST /run request.
+func serveRun(w http.ResponseWriter, r *http.Request, config Config) {
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body := io.Reader(r.Body)
+	if config.MaxScriptSize > 0 {
+		body = http.MaxBytesReader(w, r.Body, config.MaxScriptSize)
+	}
+	content, err := ioutil.ReadAll(body)
+	if err != nil {
+		http.Error(w, "script too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	script := string(content)
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		var request struct {
+			Script *string `json:"script"`
+		}
+		if err := json.Unmarshal(content, &request); err != nil || request.Script == nil {
+			http.Error(w, `expected a JSON object with a "script" member`, http.StatusBadRequest)
+			return
+		}
+		script = *request.Script
+	}
+
+	// the script stops if the client goes away.
+	result := Run(r.Context(), script, config)
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(result)
+}
+
+// Run runs a script in a new interpreter limited by the config.
+// The script is stopped when the context is done.
+func Run(ctx context.Context, script string, config Config) Result {
+
+	if config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Timeout)
+		defer cancel()
+	}
+
+	out := &limitedBuffer{max: config.MaxOutput}
+	errOut := &bytes.Buffer{}
+	lox := interp.New(out, errOut)
+	lox.SetJSONDiagnostics(true)
+	lox.SetMaxSteps(config.MaxSteps)
+	lox.SetMaxMemory(config.MaxMemory)
+	lox.SetMaxCallDepth(config.MaxCallDepth)
+	lox.SetMaxStringLength(config.MaxStringLength)
+	lox.SetMaxFields(config.MaxFields)
+	lox.SetBackend(config.Backend)
+	lox.SetContext(ctx)
+	lox.Run(script, false)
+
+	result := Result{Output: out.String(), Diagnostics: []Diagnostic{},
+		Truncated: out.truncated}
+	decoder := json.NewDecoder(errOut)
+	for {
+		var d Diagnostic
+		if err := decoder.Decode(&d); err != nil {
+			break
+		}
+		result.Diagnostics = append(result.Diagnostics, d)
+	}
+	if lox.HadCompileError() {
+		result.ExitCode = 65
+	} else if lox.HadRuntimeError() {
+		result.ExitCode = 70
+	}
+	return result
+}
+
+// limitedBuffer keeps the first max bytes written to it
+// (all of them if max is 0).
+type limitedBuffer struct {
+	bytes.Buffer
+	max       int
+	truncated bool
+}
+
+// Write appends the bytes which fit in the buffer, the write
+// never fails so the script isn't stopped.
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+
+	if b.max > 0 && b.Len()+len(p) > b.max {
+		b.truncated = true
+		b.Buffer.Write(p[:b.max-b.Len()])
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
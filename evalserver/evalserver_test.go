@@ -0,0 +1,90 @@
+package evalserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+
+	config := DefaultConfig
+	config.MaxSteps = 1000
+	config.MaxOutput = 10
+	tests := []struct {
+		script   string
+		expected Result
+	}{
+		{`print "hello";`, Result{"hello\n", []Diagnostic{}, 0, false}},
+		{`print 1 +;`, Result{"", []Diagnostic{
+			{1, 10, "parse/expect-expression", "error", "Expect expression."}}, 65, false}},
+		{`{ var a; } print -"a";`, Result{"", []Diagnostic{
+			{1, 7, "resolve/unused-local", "warning", "Local variable 'a' is never used."},
+			{1, 18, "runtime/operand-not-a-number", "error", "Operand must be a number."}}, 70, false}},
+		{`while (true) {}`, Result{"", []Diagnostic{
+			{1, 1, "runtime/budget-exceeded", "error", "Execution budget exceeded."}}, 70, false}},
+		{`print "0123456789";`, Result{"0123456789", []Diagnostic{}, 0, true}},
+	}
+	for _, test := range tests {
+		result := Run(context.Background(), test.script, config)
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("%s: expected %+v, got %+v", test.script, test.expected, result)
+		}
+	}
+
+	config = DefaultConfig
+	config.MaxSteps = 0
+	config.Timeout = 50 * time.Millisecond
+	result := Run(context.Background(), `while (true) {}`, config)
+	if result.ExitCode != 70 || result.Diagnostics[0].Message != "Execution timed out." {
+		t.Errorf("expected a timeout, got %+v", result)
+	}
+}
+
+func TestHandler(t *testing.T) {
+
+	config := DefaultConfig
+	config.MaxScriptSize = 100
+	server := httptest.NewServer(Handler(config))
+	defer server.Close()
+
+	tests := []struct {
+		method, contentType, body string
+		status                    int
+		output                    string
+	}{
+		{"POST", "text/plain", `print 1 + 2;`, http.StatusOK, "3\n"},
+		{"POST", "application/json; charset=utf-8", `{"script": "print \"json\";"}`,
+			http.StatusOK, "json\n"},
+		{"POST", "application/json", `{"code": "print 1;"}`, http.StatusBadRequest, ""},
+		{"POST", "text/plain", strings.Repeat("print 1;", 20),
+			http.StatusRequestEntityTooLarge, ""},
+		{"GET", "", "", http.StatusMethodNotAllowed, ""},
+	}
+	for _, test := range tests {
+		request, _ := http.NewRequest(test.method, server.URL+"/run", strings.NewReader(test.body))
+		request.Header.Set("Content-Type", test.contentType)
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.StatusCode != test.status {
+			t.Errorf("%s %s: expected status %d, got %d",
+				test.method, test.body, test.status, response.StatusCode)
+		}
+		if test.status == http.StatusOK {
+			var result Result
+			if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+				t.Errorf("%s: invalid response %v", test.body, err)
+			} else if result.Output != test.output {
+				t.Errorf("%s: expected %q, got %q", test.body, test.output, result.Output)
+			}
+		}
+		response.Body.Close()
+	}
+}
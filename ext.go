@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"plugin"
+	"strings"
+
+	"github.com/rmonnet/glox/interp"
+)
+
+// extFlag collects the -ext flags, the paths of the Go plugins
+// adding native functions to the interpreter.
+type extFlag []string
+
+// String returns the paths as passed on the command line.
+func (e *extFlag) String() string {
+
+	return strings.Join(*e, " ")
+}
+
+// Set adds a plugin to the list.
+func (e *extFlag) Set(path string) error {
+
+	*e = append(*e, path)
+	return nil
+}
+
+// loadExtension opens a Go plugin (built with
+// "go build -buildmode=plugin") and calls its Register function,
+// which adds its natives with Interp.RegisterNative:
+//
+//	func Register(lox *interp.Interp) {
+//		lox.RegisterNative("upper", 1, func(args []interface{}) (interface{}, error) {
+//			s, ok := args[0].(string)
+//			if !ok {
+//				return nil, errors.New("upper expects a string.")
+//			}
+//			return strings.ToUpper(s), nil
+//		})
+//	}
+//
+// The plugin must be built with the same version of Go and of glox.
+func loadExtension(lox *interp.Interp, path string) error {
+
+	p, err := plugin.Open(path)
+	if err != nil {
+		return err
+	}
+	symbol, err := p.Lookup("Register")
+	if err != nil {
+		return err
+	}
+	register, ok := symbol.(func(*interp.Interp))
+	if !ok {
+		return fmt.Errorf("%s: Register must be a func(*interp.Interp), not %T", path, symbol)
+	}
+	register(lox)
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/rmonnet/glox/lang"
+)
+
+// exDiffers is the exit code of "glox fmt -d" when a file is
+// not in the canonical style.
+const exDiffers = 1
+
+// runFmt runs the "glox fmt" subcommand, it formats the scripts
+// passed as arguments in the canonical style and:
+//   - prints them on stdout by default
+//   - rewrites the files in place with -w
+//   - prints the differences with the canonical style with -d
+//     and exits with an error code if there are any
+func runFmt(args []string) {
+
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := flags.Bool("w", false, "write the result to the source files")
+	diff := flags.Bool("d", false,
+		"print the differences with the canonical style and fail if there are any")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 || (*write && *diff) {
+		fmt.Println("Usage glox fmt [-w | -d] script...")
+		os.Exit(exUsage)
+	}
+
+	differs := false
+	for _, filename := range flags.Args() {
+		source, err := ioutil.ReadFile(filename)
+		if err != nil {
+			fmt.Println("unable to read ", filename)
+			os.Exit(exDataErr)
+		}
+		formatted, err := lang.FormatSource(string(source))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", filename, err)
+			os.Exit(exDataErr)
+		}
+		switch {
+		case *write:
+			if formatted == string(source) {
+				continue
+			}
+			if err := ioutil.WriteFile(filename, []byte(formatted), 0644); err != nil {
+				fmt.Println("unable to write ", filename)
+				os.Exit(exDataErr)
+			}
+		case *diff:
+			if formatted != string(source) {
+				differs = true
+				printDiff(filename, string(source), formatted)
+			}
+		default:
+			fmt.Print(formatted)
+		}
+	}
+	if differs {
+		os.Exit(exDiffers)
+	}
+}
+
+// printDiff prints the lines removed from the source (prefixed
+// by "-") and added by the formatter (prefixed by "+").
+// The lines are matched using their longest common subsequence.
+func printDiff(filename, source, formatted string) {
+
+	from := strings.SplitAfter(source, "\n")
+	to := strings.SplitAfter(formatted, "\n")
+
+	// common[i][j] is the length of the longest common
+	// subsequence of from[i:] and to[j:].
+	common := make([][]int, len(from)+1)
+	for i := range common {
+		common[i] = make([]int, len(to)+1)
+	}
+	for i := len(from) - 1; i >= 0; i-- {
+		for j := len(to) - 1; j >= 0; j-- {
+			if from[i] == to[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else if common[i+1][j] >= common[i][j+1] {
+				common[i][j] = common[i+1][j]
+			} else {
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+
+	fmt.Printf("--- %s\n+++ %s (formatted)\n", filename, filename)
+	i, j := 0, 0
+	for i < len(from) || j < len(to) {
+		switch {
+		case i < len(from) && j < len(to) && from[i] == to[j]:
+			i++
+			j++
+		case j == len(to) || (i < len(from) && common[i+1][j] >= common[i][j+1]):
+			fmt.Print("-" + withNewLine(from[i]))
+			i++
+		default:
+			fmt.Print("+" + withNewLine(to[j]))
+			j++
+		}
+	}
+}
+
+// withNewLine terminates a line by a new line if it doesn't
+// have one (the last line of a file).
+func withNewLine(line string) string {
+
+	if strings.HasSuffix(line, "\n") {
+		return line
+	}
+	return line + "\n"
+}
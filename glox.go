@@ -1,78 +1,477 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/rmonnet/glox/interp"
+	"github.com/rmonnet/glox/lang"
 )
 
 const (
 	exUsage   = 64
 	exDataErr = 65
 	exSwErr   = 70
+	// exCantCreat is the exit code when an output file
+	// can't be created.
+	exCantCreat = 73
+	// exTimeout is the exit code of the timeout command
+	// when the command times out.
+	exTimeout = 124
+)
+
+// runTimeout is the time limit of each run of a script or of a REPL
+// input, set by -timeout (0 for no limit). timedOut records that a
+// run exceeded it.
+var (
+	runTimeout time.Duration
+	timedOut   bool
 )
 
 // main runs the glox interpreter command line
 // it will:
-//   - interpret the script passed as argument
+//   - interpret the scripts passed as arguments, in order, and
+//     report where they were stopped on SIGINT or SIGTERM
+//   - interpret the code passed with -e
+//   - interpret the script read from stdin if the argument
+//     is "-" or stdin is not a terminal
 //   - run the lox shell if no argument is passed
-//   - error if more than one argument is passed
+//   - format the scripts with the "fmt" subcommand
+//   - run the test scripts with the "test" subcommand
+//   - measure the performance of a script with the "bench" subcommand
+//   - document the scripts with the "doc" subcommand
+//   - report statistics about the AST with the "stats" subcommand
+//   - disassemble the bytecode of the scripts with the "disasm" subcommand
+//   - compile the scripts to .loxc files with the "compile" subcommand
+//   - translate a script to Go or JavaScript with the "transpile" subcommand
+//   - serve the editors with the "lsp" subcommand (Language Server Protocol)
+//   - debug a script from an editor with the "dap" subcommand
+//     (Debug Adapter Protocol)
+//   - run the scripts posted over HTTP with the "serve" subcommand
+//   - check the scripts with the lint rules with the "lint" subcommand
+//   - compact the scripts with the "minify" subcommand
+//   - debug a script interactively with the "debug" subcommand
+//   - load native functions from Go plugins with -ext
+//   - run the scripts in Jupyter notebooks with the "kernel" subcommand
+//   - run the test suite of the book with the "conformance" subcommand
 func main() {
 
-	parseOnly := flag.Bool("parseOnly", false, "parse and dump the AST")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "fmt":
+			runFmt(os.Args[2:])
+			return
+		case "test":
+			runTests(os.Args[2:])
+			return
+		case "bench":
+			runBench(os.Args[2:])
+			return
+		case "doc":
+			runDoc(os.Args[2:])
+			return
+		case "stats":
+			runStats(os.Args[2:])
+			return
+		case "disasm":
+			runDisasm(os.Args[2:])
+			return
+		case "compile":
+			runCompile(os.Args[2:])
+			return
+		case "transpile":
+			runTranspile(os.Args[2:])
+			return
+		case "lsp":
+			runLSP(os.Args[2:])
+			return
+		case "dap":
+			runDAP(os.Args[2:])
+			return
+		case "serve":
+			runServe(os.Args[2:])
+			return
+		case "lint":
+			runLint(os.Args[2:])
+			return
+		case "minify":
+			runMinify(os.Args[2:])
+			return
+		case "debug":
+			runDebug(os.Args[2:])
+			return
+		case "kernel":
+			runKernel(os.Args[2:])
+			return
+		case "conformance":
+			runConformance(os.Args[2:])
+			return
+		}
+	}
+
+	parseOnly := flag.Bool("parseOnly", false, "parse and dump the AST (same as -dump=sexpr)")
+	dump := flag.String("dump", "",
+		"parse and dump the AST in the given format (sexpr, json or dot)")
+	maxErrors := flag.Int("maxErrors", lang.DefaultMaxErrors,
+		"maximum number of errors reported per phase (0 for no limit)")
+	fold := flag.Bool("fold", false, "fold constant expressions before execution (same as the first pass of -O 1)")
+	optLevel := flag.Int("O", 0,
+		"optimization level: 1 folds the constants and removes the dead code, 2 also hoists the loop-invariant literals")
+	warnShadowing := flag.Bool("Wshadow", false,
+		"warn about local declarations shadowing an enclosing variable")
+	warningsAsErrors := flag.Bool("Werror", false, "treat warnings as errors")
+	noWarnings := flag.Bool("no-warn", false, "don't report warnings")
+	strict := flag.Bool("strict", false,
+		"report references to undefined globals as compile errors")
+	maxCallDepth := flag.Int("maxCallDepth", interp.DefaultMaxCallDepth,
+		"maximum number of nested calls (0 for no limit)")
+	maxSteps := flag.Int("maxSteps", 0,
+		"maximum number of statements executed (0 for no limit)")
+	maxMemory := flag.Int("maxMemory", 0,
+		"approximate allocation budget in bytes (0 for no limit)")
+	maxStringLength := flag.Int("maxStringLength", 0,
+		"maximum length in bytes of the strings built by concatenation (0 for no limit)")
+	maxFields := flag.Int("maxFields", 0,
+		"maximum number of fields of an instance (0 for no limit)")
+	allowDivByZero := flag.Bool("allowDivByZero", false,
+		"return +Inf, -Inf or NaN on division by zero instead of an error")
+	verbose := flag.Bool("v", false,
+		"report the time spent scanning, parsing, resolving and executing on stderr")
+	profile := flag.Bool("profile", false,
+		"report the calls and time spent in each function on stderr")
+	strictRedeclare := flag.Bool("strictRedeclare", false,
+		"report declaring a global twice in a script as an error")
+	implicitGlobals := flag.Bool("implicitGlobals", false,
+		"create a global when assigning to an undeclared variable")
+	strictInit := flag.Bool("strictInit", false,
+		"report reading a variable declared without initializer as an error")
+	noCoercion := flag.Bool("noStringCoercion", false,
+		"require two numbers or two strings for '+' like the reference lox")
+	jloxNumbers := flag.Bool("jloxNumbers", false,
+		"print numbers like the reference jlox (1.0E7, NaN, Infinity)")
+	printFields := flag.Bool("printFields", false,
+		"print instances with their fields instead of <instance Class>")
+	looseTruthiness := flag.Bool("looseTruthiness", false,
+		"treat 0 and \"\" as false in conditions")
+	timeout := flag.Duration("timeout", 0,
+		"stop each run (a script or a REPL input) after the given duration, like 5s (0 for no limit)")
+	var defines defineFlag
+	flag.Var(&defines, "D",
+		"define a global variable name=value before execution (can be repeated)")
+	var extensions extFlag
+	flag.Var(&extensions, "ext",
+		"load the native functions of a Go plugin (can be repeated)")
+	jsonDiagnostics := flag.Bool("json", false,
+		"write the errors and warnings as JSON lines on stderr")
+	code := flag.String("e", "", "run the lox code passed as argument instead of a script")
+	scanOnly := flag.Bool("scanOnly", false, "scan and dump the tokens")
+	history := flag.String("history", defaultHistoryFile(),
+		"file keeping the history of the lox shell (empty for none)")
+	cpuProfile := flag.String("cpuprofile", "",
+		"write a pprof CPU profile of the interpreter to the file")
+	memProfile := flag.String("memprofile", "",
+		"write a pprof memory profile of the interpreter to the file")
+	backend := flag.String("backend", "tree",
+		"execute the scripts with the tree-walker (tree) or the bytecode VM (vm)")
 	flag.Parse()
 	args := flag.Args()
+	backendMode, validBackend := parseBackend(*backend)
+	// the compiled scripts only run on the virtual machine,
+	// and all the scripts must run on the same backend.
+	for _, arg := range args {
+		if strings.HasSuffix(arg, ".loxc") {
+			backendMode = interp.VM
+		}
+	}
 
-	if len(args) > 1 {
-		fmt.Println("Usage glox [-parseOnly] [script]")
+	if *parseOnly && *dump == "" {
+		*dump = "sexpr"
+	}
+
+	if (*code != "" && len(args) > 0) ||
+		(*dump != "" && *dump != "sexpr" && *dump != "json" && *dump != "dot") ||
+		(*warningsAsErrors && *noWarnings) ||
+		!validBackend {
+		fmt.Println("Usage glox [options] [script... | - | -e code]")
 		os.Exit(exUsage)
-	} else if len(args) == 1 {
-		runFile(args[0], *parseOnly)
+	}
+
+	if *scanOnly {
+		*dump = "tokens"
+	}
+
+	warningMode := interp.ReportWarnings
+	if *warningsAsErrors {
+		warningMode = interp.WarningsAsErrors
+	} else if *noWarnings {
+		warningMode = interp.IgnoreWarnings
+	}
+
+	interp := interp.New(os.Stdout, os.Stderr)
+	interp.SetMaxErrors(*maxErrors)
+	interp.SetPasses(optimizationPasses(*optLevel, *fold)...)
+	interp.SetWarnShadowing(*warnShadowing)
+	interp.SetWarningMode(warningMode)
+	interp.SetBackend(backendMode)
+	interp.SetStrictGlobals(*strict)
+	interp.SetStrictRedeclaration(*strictRedeclare)
+	interp.SetImplicitGlobals(*implicitGlobals)
+	interp.SetMaxCallDepth(*maxCallDepth)
+	interp.SetMaxSteps(*maxSteps)
+	interp.SetMaxMemory(*maxMemory)
+	interp.SetMaxStringLength(*maxStringLength)
+	interp.SetMaxFields(*maxFields)
+	interp.SetAllowDivisionByZero(*allowDivByZero)
+	interp.SetProfiling(*profile)
+	interp.SetPhaseStats(*verbose)
+	interp.SetStrictInitialization(*strictInit)
+	interp.SetStringCoercion(!*noCoercion)
+	interp.SetJloxNumberFormat(*jloxNumbers)
+	interp.SetPrintFields(*printFields)
+	interp.SetLooseTruthiness(*looseTruthiness)
+	interp.SetColor(isTerminal(os.Stderr) && os.Getenv("NO_COLOR") == "")
+	interp.SetJSONDiagnostics(*jsonDiagnostics)
+	for _, define := range defines {
+		interp.Define(define.name, define.value)
+	}
+	for _, path := range extensions {
+		if err := loadExtension(interp, path); err != nil {
+			fmt.Println("unable to load the extension", err)
+			os.Exit(exUsage)
+		}
+	}
+	runTimeout = *timeout
+
+	startProfiling(*cpuProfile, *memProfile)
+	defer stopProfiling()
+
+	if *code != "" {
+		interp.SetScriptName("<eval>")
+		runScript(interp, *code, *dump)
+	} else if (len(args) == 1 && args[0] == "-") ||
+		(len(args) == 0 && !isTerminal(os.Stdin)) {
+		runStdin(interp, *dump)
+	} else if len(args) > 0 {
+		runFiles(interp, args, *dump)
 	} else {
-		runPrompt(*parseOnly)
+		runPrompt(interp, *dump, *history)
 	}
 }
 
-// runFile runs the lox interpreter on the
-// script in the file
-func runFile(filename string, parseOnly bool) {
+// optimizationPasses returns the passes of the -O level, -fold
+// adding the constant folding to the level 0.
+func optimizationPasses(level int, fold bool) []lang.Pass {
 
-	script, err := ioutil.ReadFile(filename)
-	if err != nil {
-		fmt.Println("unable to read ", filename)
-		os.Exit(exDataErr)
+	passes := lang.OptimizationPasses(level)
+	if fold && len(passes) == 0 {
+		passes = []lang.Pass{lang.ConstantFolding{}}
 	}
-	interp := interp.New(os.Stdout, os.Stderr)
-	interp.Run(string(script), parseOnly)
-	if interp.HadCompileError() {
-		os.Exit(exDataErr)
+	return passes
+}
+
+// parseBackend returns the backend named by the -backend flag,
+// "tree" or "vm", and reports if the name is valid.
+func parseBackend(name string) (interp.Backend, bool) {
+
+	switch name {
+	case "tree":
+		return interp.TreeWalker, true
+	case "vm":
+		return interp.VM, true
+	default:
+		return interp.TreeWalker, false
 	}
-	if interp.HadRuntimeError() {
-		os.Exit(exSwErr)
+}
+
+// define is a global variable defined on the command line.
+type define struct {
+	name  string
+	value interface{}
+}
+
+// defineFlag collects the -D flags. Each flag is name=value where
+// the value is true, false, nil, a number or a string (optionally
+// between double quotes).
+type defineFlag []define
+
+// String returns the definitions as passed on the command line.
+func (d *defineFlag) String() string {
+
+	definitions := make([]string, len(*d))
+	for n, define := range *d {
+		definitions[n] = fmt.Sprintf("%s=%v", define.name, define.value)
 	}
+	return strings.Join(definitions, " ")
 }
 
-// runPrompt runs the lox interpreter interactively
-func runPrompt(parseOnly bool) {
+// Set parses a definition and adds it to the list.
+func (d *defineFlag) Set(text string) error {
 
-	scanner := bufio.NewScanner(os.Stdin)
-	interp := interp.New(os.Stdout, os.Stderr)
-	for {
-		fmt.Print("> ")
-		if !scanner.Scan() {
-			fmt.Println("")
+	eq := strings.Index(text, "=")
+	if eq <= 0 {
+		return errors.New("expected name=value")
+	}
+	name, literal := text[:eq], text[eq+1:]
+
+	var value interface{}
+	switch literal {
+	case "true":
+		value = true
+	case "false":
+		value = false
+	case "nil":
+		value = nil
+	default:
+		if number, err := strconv.ParseFloat(literal, 64); err == nil {
+			value = number
+		} else if len(literal) >= 2 && strings.HasPrefix(literal, "\"") &&
+			strings.HasSuffix(literal, "\"") {
+			value = literal[1 : len(literal)-1]
+		} else {
+			value = literal
+		}
+	}
+	*d = append(*d, define{name, value})
+	return nil
+}
+
+// runFiles runs the lox interpreter on the scripts in the files,
+// in order. The scripts share the same global environment, so the
+// first files can define functions and classes for the next ones.
+// Execution stops at the first file which fails, or on SIGINT
+// or SIGTERM, then the calls active are reported.
+// The scripts compiled with "glox compile" (.loxc files) run on the
+// virtual machine, which also runs the compiled version of a script
+// instead of its source when it is up to date.
+func runFiles(lox *interp.Interp, filenames []string, dump string) {
+
+	interrupted := trapSignals(lox)
+	for _, filename := range filenames {
+		lox.SetScriptName(filename)
+		if function, source, ok := compiledScript(lox, filename, dump); ok {
+			stop := startTimeout(lox)
+			lox.RunCompiled(function)
+			stop()
+		} else {
+			script, err := ioutil.ReadFile(source)
+			if err != nil {
+				fmt.Println("unable to read ", source)
+				os.Exit(exDataErr)
+			}
+			execute(lox, string(script), dump)
+		}
+		if lox.HadCompileError() || lox.HadRuntimeError() {
 			break
 		}
-		interp.Run(scanner.Text(), parseOnly)
 	}
+	if sig := interrupted(); sig != nil {
+		exitOnSignal(lox, sig)
+	}
+	exitOnError(lox)
+}
 
-	if err := scanner.Err(); err != nil {
-		fmt.Println("error while reading ", err)
+// runStdin runs the lox interpreter on the
+// script read from stdin.
+func runStdin(interp *interp.Interp, dump string) {
+
+	script, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Println("unable to read stdin ", err)
 		os.Exit(exDataErr)
 	}
+	interp.SetScriptName("<stdin>")
+	runScript(interp, string(script), dump)
+}
+
+// isTerminal checks if the file is a terminal (and not
+// a pipe or a redirected file).
+func isTerminal(f *os.File) bool {
+
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// runScript runs the lox interpreter on the script
+// and exits with an error code if it failed.
+func runScript(interp *interp.Interp, script string, dump string) {
+
+	execute(interp, script, dump)
+	exitOnError(interp)
+}
+
+// execute runs the script, or only dumps its tokens or its AST
+// in the requested format (sexpr, json or dot).
+func execute(interp *interp.Interp, script string, dump string) {
+
+	defer startTimeout(interp)()
+	switch dump {
+	case "":
+		interp.Run(script, false)
+	case "tokens":
+		tokens, _ := interp.Scan(script)
+		for _, token := range tokens {
+			fmt.Printf("%d:%d\t%-14s %s\n", token.Line, token.Column,
+				token.Type, token.Lexeme)
+		}
+	case "sexpr":
+		interp.Run(script, true)
+	default:
+		statements, ok := interp.Parse(script)
+		if !ok {
+			return
+		}
+		if dump == "json" {
+			lang.WriteJSON(os.Stdout, statements)
+		} else {
+			lang.WriteDot(os.Stdout, statements)
+		}
+	}
+}
+
+// startTimeout starts the time limit of a run, if -timeout is set,
+// and returns the function to call when the run ends. The limit
+// doesn't carry over to the next runs.
+func startTimeout(lox *interp.Interp) func() {
+
+	if runTimeout <= 0 {
+		return func() {}
+	}
+	parent := lox.Context()
+	ctx, cancel := context.WithTimeout(parent, runTimeout)
+	lox.SetContext(ctx)
+	return func() {
+		if ctx.Err() == context.DeadlineExceeded {
+			timedOut = true
+		}
+		cancel()
+		lox.SetContext(parent)
+	}
+}
+
+// exitOnError writes the profile and exits with an error code
+// if the scripts run by the interpreter failed.
+func exitOnError(interp *interp.Interp) {
 
+	interp.WriteProfile(os.Stderr)
+	interp.WritePhaseStats(os.Stderr)
+	stopProfiling()
+	if interp.HadCompileError() {
+		os.Exit(exDataErr)
+	}
+	if interp.HadRuntimeError() {
+		if timedOut {
+			os.Exit(exTimeout)
+		}
+		os.Exit(exSwErr)
+	}
 }
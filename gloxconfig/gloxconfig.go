@@ -0,0 +1,256 @@
+// Package gloxconfig uses lox as a configuration language: the
+// configuration is a script, which can compute its values, and its
+// result is decoded into a Go value.
+//
+//	var name = "api";
+//	var port = 8000 + 80;
+//	var debug = port != 8080;
+//
+// decoded into:
+//
+//	var config struct {
+//		Name  string
+//		Port  int
+//		Debug bool
+//	}
+//	err := gloxconfig.Decode(script, &config)
+package gloxconfig
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rmonnet/glox/interp"
+)
+
+// The limits of the sandboxed interpreter running the scripts.
+const (
+	MaxSteps     = 1000000
+	MaxMemory    = 16 << 20
+	MaxCallDepth = 200
+	Timeout      = 5 * time.Second
+)
+
+// Decode runs a configuration script and decodes its result into
+// out, which must be a non-nil pointer. The result is the value of
+// the last statement if it is an expression statement evaluating to
+// an instance, the script "returns" it:
+//
+//	class Config { init() { this.port = 8080; } }
+//	Config();
+//
+// Otherwise the result is the global variables of the script, which
+// may end with a call or an assignment like setup(); or port = port + 1;.
+//
+// The instances and the globals are decoded into the structs, their
+// fields matching the exported fields named by the `lox` tag if any,
+// or by the field name (port or PORT match Port), and into the maps with
+// string keys. The numbers are decoded into the integers only if
+// they are integers in their range. The functions and the classes
+// can't be decoded.
+//
+// The script runs in a sandboxed interpreter, which doesn't print
+// and is limited in steps, memory, call depth and time. The error
+// is the first compile error, the runtime error of the script or a
+// decoding error.
+func Decode(script string, out interface{}) error {
+
+	target := reflect.ValueOf(out)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return fmt.Errorf("gloxconfig: Decode needs a non-nil pointer, not %T", out)
+	}
+
+	var errOut bytes.Buffer
+	lox := interp.New(ioutil.Discard, &errOut)
+	lox.SetMaxSteps(MaxSteps)
+	lox.SetMaxMemory(MaxMemory)
+	lox.SetMaxCallDepth(MaxCallDepth)
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	lox.SetContext(ctx)
+
+	switch err := lox.SafeRun(script); {
+	case err == interp.ErrCompile:
+		return fmt.Errorf("gloxconfig: %s", lox.CompileErrors()[0])
+	case err != nil:
+		return fmt.Errorf("gloxconfig: %v", err)
+	}
+
+	if value, ok := lox.Result(); ok {
+		if instance, ok := value.(map[string]interface{}); ok {
+			return decode(instance, target.Elem(), "config")
+		}
+	}
+	globals := make(map[string]interface{})
+	for _, name := range lox.GlobalNames() {
+		globals[name], _ = lox.Eval(name)
+	}
+	return decode(globals, target.Elem(), "config")
+}
+
+// decode stores a value converted by the interpreter into the
+// target, path locates the value in the errors.
+func decode(value interface{}, target reflect.Value, path string) error {
+
+	if target.Kind() == reflect.Ptr {
+		if value == nil {
+			target.Set(reflect.Zero(target.Type()))
+			return nil
+		}
+		if target.IsNil() {
+			target.Set(reflect.New(target.Type().Elem()))
+		}
+		return decode(value, target.Elem(), path)
+	}
+	if target.Kind() == reflect.Interface && target.NumMethod() == 0 {
+		if value != nil {
+			target.Set(reflect.ValueOf(value))
+		}
+		return nil
+	}
+	if value == nil {
+		target.Set(reflect.Zero(target.Type()))
+		return nil
+	}
+
+	switch v := value.(type) {
+	case bool:
+		if target.Kind() == reflect.Bool {
+			target.SetBool(v)
+			return nil
+		}
+	case string:
+		if target.Kind() == reflect.String {
+			target.SetString(v)
+			return nil
+		}
+	case float64:
+		return decodeNumber(v, target, path)
+	case map[string]interface{}:
+		return decodeFields(v, target, path)
+	}
+	return mismatch(value, target, path)
+}
+
+// decodeNumber stores a number into a numeric target.
+func decodeNumber(n float64, target reflect.Value, path string) error {
+
+	switch target.Kind() {
+	case reflect.Float32, reflect.Float64:
+		target.SetFloat(n)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n != math.Trunc(n) || n < math.MinInt64 || n >= math.MaxInt64 ||
+			target.OverflowInt(int64(n)) {
+			return fmt.Errorf("gloxconfig: %s: %v doesn't fit in %s", path, n, target.Type())
+		}
+		target.SetInt(int64(n))
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if n != math.Trunc(n) || n < 0 || n >= math.MaxUint64 || target.OverflowUint(uint64(n)) {
+			return fmt.Errorf("gloxconfig: %s: %v doesn't fit in %s", path, n, target.Type())
+		}
+		target.SetUint(uint64(n))
+		return nil
+	}
+	return mismatch(n, target, path)
+}
+
+// decodeFields stores the fields of an instance (or the globals)
+// into a struct or a map.
+func decodeFields(fields map[string]interface{}, target reflect.Value, path string) error {
+
+	switch target.Kind() {
+	case reflect.Map:
+		if target.Type().Key().Kind() != reflect.String {
+			break
+		}
+		if target.IsNil() {
+			target.Set(reflect.MakeMap(target.Type()))
+		}
+		for name, field := range fields {
+			element := reflect.New(target.Type().Elem()).Elem()
+			if err := decode(field, element, path+"."+name); err != nil {
+				return err
+			}
+			target.SetMapIndex(reflect.ValueOf(name).Convert(target.Type().Key()), element)
+		}
+		return nil
+	case reflect.Struct:
+		for n := 0; n < target.NumField(); n++ {
+			field := target.Type().Field(n)
+			if field.PkgPath != "" {
+				continue
+			}
+			name, ok := fieldName(fields, field)
+			if !ok {
+				continue
+			}
+			if err := decode(fields[name], target.Field(n), path+"."+name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return mismatch(fields, target, path)
+}
+
+// fieldName returns the name of the lox field decoded into a
+// struct field, false if there is none.
+func fieldName(fields map[string]interface{}, field reflect.StructField) (string, bool) {
+
+	if tag, ok := field.Tag.Lookup("lox"); ok {
+		if tag == "-" {
+			return "", false
+		}
+		_, found := fields[tag]
+		return tag, found
+	}
+	// the exact name first, then the name starting with a lower
+	// case letter, like port for Port, then any case.
+	lower := strings.ToLower(field.Name[:1]) + field.Name[1:]
+	for _, name := range []string{field.Name, lower} {
+		if _, found := fields[name]; found {
+			return name, true
+		}
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if strings.EqualFold(name, field.Name) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// mismatch returns the error of a value which can't be decoded
+// into the target.
+func mismatch(value interface{}, target reflect.Value, path string) error {
+
+	var kind string
+	switch v := value.(type) {
+	case bool:
+		kind = "a boolean"
+	case float64:
+		kind = "a number"
+	case string:
+		kind = "a string"
+	case fmt.Stringer:
+		// a function or a class.
+		kind = v.String()
+	case map[string]interface{}:
+		kind = "an instance"
+	}
+	return fmt.Errorf("gloxconfig: %s: can't decode %s into %s", path, kind, target.Type())
+}
@@ -0,0 +1,135 @@
+package gloxconfig
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type server struct {
+	Name    string
+	Port    int
+	Debug   bool
+	Ratio   float64 `lox:"load_ratio"`
+	Tags    map[string]string
+	Limits  *limits
+	Ignored string `lox:"-"`
+	Extra   interface{}
+}
+
+type limits struct {
+	Requests uint16
+	Burst    int
+}
+
+func TestDecodeGlobals(t *testing.T) {
+
+	script := `
+		var name = "api";
+		var port = 8000 + 80;
+		var debug = port != 8080;
+		var load_ratio = 3 / 4;
+		class Map {}
+		var tags = Map();
+		tags.env = "prod";
+		tags.zone = "eu-" + 1;
+		class Limit { init(requests) { this.requests = requests; this.burst = requests / 10; } }
+		var limits = Limit(1000);
+		var ignored = "value";
+		var extra = nil;
+		fun unused() {}
+	`
+	var got server
+	if err := Decode(script, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := server{
+		Name:   "api",
+		Port:   8080,
+		Ratio:  0.75,
+		Tags:   map[string]string{"env": "prod", "zone": "eu-1"},
+		Limits: &limits{1000, 100},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestDecodeResult(t *testing.T) {
+
+	script := `
+		fun server(name, port) {
+			class Server {}
+			var s = Server();
+			s.name = name;
+			s.port = port;
+			return s;
+		}
+		var name = "ignored";
+		server("web", 80); // the configuration
+	`
+	var got server
+	if err := Decode(script, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "web" || got.Port != 80 {
+		t.Errorf("expected web:80, got %s:%d", got.Name, got.Port)
+	}
+
+	// the last statements which are not instances are run,
+	// the result is the globals.
+	tests := []string{
+		`var name = "web"; var port = 80; fun setup() { debug = true; } var debug = false; setup();`,
+		`var name = "web"; var port = 79; var debug = true; port = port + 1;`,
+		`var name = "web"; var port = 80; var debug = true; 1 + 2;`,
+	}
+	for _, script := range tests {
+		got := server{Name: "previous"}
+		if err := Decode(script, &got); err != nil {
+			t.Errorf("%s: unexpected error %v", script, err)
+			continue
+		}
+		if got.Name != "web" || got.Port != 80 || !got.Debug {
+			t.Errorf("%s: expected web:80 with debug, got %+v", script, got)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+
+	tests := []struct{ script, want string }{
+		{`var port = "http";`, "config.port: can't decode a string into int"},
+		{`var port = 80.5;`, "config.port: 80.5 doesn't fit in int"},
+		{`var limits = 1;`, "config.limits: can't decode a number into gloxconfig.limits"},
+		{`class L {} var limits = L(); limits.requests = -1; var done = true;`,
+			"config.limits.requests: -1 doesn't fit in uint16"},
+		{`fun name() {}`, "config.name: can't decode <fun name> into string"},
+		{`class Tags {}`, "config.Tags: can't decode <class Tags> into map[string]string"},
+		{`var Port = 1; var port = 2; var PORT = "3";`, ""},
+		{`var port = ;`, "[line 1] Error at ';': Expect expression."},
+		// the warnings are not errors.
+		{"{ var unused = 1; }\nreturn 1;", "[line 2] Error at 'return': Can't return from top-level code."},
+		{`var port = -nil;`, "[line 1] Operand must be a number."},
+		{`while (true) {}`, "Execution budget exceeded."},
+		// the errors of the last statement are located too.
+		{"var port = 1;\n\n\nport = nil + 1;", "[line 4] Operands must be two numbers or at least one string."},
+		{"var port = 1;\nundefinedFn();", "[line 2] Undefined variable 'undefinedFn'."},
+	}
+	for _, test := range tests {
+		var config server
+		err := Decode(test.script, &config)
+		if test.want == "" {
+			if err != nil || config.Port != 1 {
+				t.Errorf("%s: expected the port 1, got %d (%v)", test.script, config.Port, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: expected the error %q, got %v", test.script, test.want, err)
+		}
+	}
+
+	if err := Decode(`var a = 1;`, server{}); err == nil {
+		t.Error("expected an error for a non-pointer")
+	}
+}
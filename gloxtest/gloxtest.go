@@ -0,0 +1,199 @@
+// Package gloxtest tests lox scripts against golden files, so the
+// projects embedding glox can test their script libraries like the
+// rest of their code:
+//
+//	var update = gloxtest.UpdateFlag(flag.CommandLine)
+//
+//	func TestScripts(t *testing.T) {
+//		gloxtest.GoldenDir(t, "testdata", gloxtest.Options{
+//			Update: *update,
+//			Setup: func(lox *interp.Interp, random *rand.Rand) {
+//				lox.RegisterNative("roll", 0, func(args []interface{}) (interface{}, error) {
+//					return float64(random.Intn(6) + 1), nil
+//				})
+//			},
+//		})
+//	}
+//
+// Each script (testdata/dice.lox) runs in a new interpreter and its
+// output is compared with its golden file (testdata/dice.golden).
+// The runs are deterministic: clock() always returns Epoch and the
+// random generator given to Setup is seeded with Seed.
+//
+// The golden files are written, or rewritten after a change of the
+// scripts, with Options.Update, set here by the -update flag the test
+// registers with UpdateFlag:
+//
+//	go test -run TestScripts -update
+package gloxtest
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rmonnet/glox/interp"
+)
+
+// UpdateFlag defines the -update flag on a flag set, usually
+// flag.CommandLine in the test binary, and returns its value, to
+// set Options.Update. The package doesn't define it itself, so the
+// tests already having their own -update flag can import it.
+func UpdateFlag(fs *flag.FlagSet) *bool {
+
+	return fs.Bool("update", false, "update the golden files of the lox scripts")
+}
+
+// Epoch is the time returned by clock() in the scripts under test.
+var Epoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Seed is the seed of the random generator given to Options.Setup.
+const Seed = 1
+
+// stderrHeader separates the output from the errors in the golden files.
+const stderrHeader = "-- stderr --\n"
+
+// Options configure the interpreters running the scripts.
+type Options struct {
+	// Backend is the backend executing the scripts, the
+	// tree-walker by default.
+	Backend interp.Backend
+	// Setup, if not nil, is called before each script runs, to
+	// define its globals and register its natives. The natives
+	// needing random numbers should draw them from random.
+	Setup func(lox *interp.Interp, random *rand.Rand)
+	// Update rewrites the golden files with the output of the
+	// scripts instead of comparing them.
+	Update bool
+}
+
+// Result is the output of a script.
+type Result struct {
+	Stdout string
+	Stderr string
+}
+
+// String returns the result as written in the golden files: the
+// output of the script followed by its errors, if any, after a
+// "-- stderr --" line.
+func (r Result) String() string {
+
+	if r.Stderr == "" {
+		return r.Stdout
+	}
+	stdout := r.Stdout
+	if stdout != "" && !strings.HasSuffix(stdout, "\n") {
+		stdout += "\n"
+	}
+	return stdout + stderrHeader + r.Stderr
+}
+
+// Run runs a script in a new interpreter with a deterministic
+// clock and returns its output.
+func Run(script string, options Options) Result {
+
+	var out, errOut bytes.Buffer
+	lox := interp.New(&out, &errOut)
+	lox.SetBackend(options.Backend)
+	lox.SetClock(func() time.Time { return Epoch })
+	if options.Setup != nil {
+		options.Setup(lox, rand.New(rand.NewSource(Seed)))
+	}
+	lox.Run(script, false)
+	return Result{out.String(), errOut.String()}
+}
+
+// GoldenPath returns the golden file of a script: its path
+// with the .golden extension.
+func GoldenPath(path string) string {
+
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".golden"
+}
+
+// Golden runs the script at path and compares its result with its
+// golden file, the differences are reported as test errors. With
+// Options.Update, the golden file is written instead.
+func Golden(t *testing.T, path string, options Options) {
+
+	t.Helper()
+	if err := check(path, options); err != nil {
+		t.Error(err)
+	}
+}
+
+// GoldenDir runs Golden as a subtest, named after the script, for
+// each .lox script of a directory (its subdirectories excluded).
+func GoldenDir(t *testing.T, dir string, options Options) {
+
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(dir, "*.lox"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatalf("no lox script in %s", dir)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		path := path
+		name := strings.TrimSuffix(filepath.Base(path), ".lox")
+		t.Run(name, func(t *testing.T) { Golden(t, path, options) })
+	}
+}
+
+// check runs a script and compares its result with its golden file,
+// or writes the golden file if options.Update is set.
+func check(path string, options Options) error {
+
+	script, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	got := Run(string(script), options).String()
+	golden := GoldenPath(path)
+	if options.Update {
+		return ioutil.WriteFile(golden, []byte(got), 0644)
+	}
+	expected, err := ioutil.ReadFile(golden)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%s: no golden file %s (run the test with -update to write it)", path, golden)
+	}
+	if err != nil {
+		return err
+	}
+	if got != string(expected) {
+		return fmt.Errorf("%s: the result differs from %s (run the test with -update to rewrite it)\n%s",
+			path, golden, diff(string(expected), got))
+	}
+	return nil
+}
+
+// diff lists the lines which differ between the expected and the
+// actual results.
+func diff(expected, got string) string {
+
+	expectedLines := strings.Split(expected, "\n")
+	gotLines := strings.Split(got, "\n")
+	var b strings.Builder
+	for n := 0; n < len(expectedLines) || n < len(gotLines); n++ {
+		var e, g string
+		if n < len(expectedLines) {
+			e = expectedLines[n]
+		}
+		if n < len(gotLines) {
+			g = gotLines[n]
+		}
+		if e != g || n >= len(expectedLines) || n >= len(gotLines) {
+			fmt.Fprintf(&b, "line %d: expected %q, got %q\n", n+1, e, g)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,85 @@
+package gloxtest
+
+import (
+	"flag"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rmonnet/glox/interp"
+)
+
+var update = UpdateFlag(flag.CommandLine)
+
+// dice registers roll(), a native drawing random numbers.
+var dice = Options{
+	Setup: func(lox *interp.Interp, random *rand.Rand) {
+		lox.RegisterNative("roll", 0, func(args []interface{}) (interface{}, error) {
+			return float64(random.Intn(6) + 1), nil
+		})
+	},
+}
+
+func TestGoldenDir(t *testing.T) {
+
+	dice.Update = *update
+	GoldenDir(t, "testdata", dice)
+	dice.Backend = interp.VM
+	GoldenDir(t, "testdata", dice)
+}
+
+func TestRun(t *testing.T) {
+
+	tests := []struct {
+		script string
+		result Result
+		golden string
+	}{
+		{`print 1 + 2;`, Result{"3\n", ""}, "3\n"},
+		{`print clock() == clock();`, Result{"true\n", ""}, "true\n"},
+		{`print "a"; print nil.x;`,
+			Result{"a\n", "[line 1] Only class instances have fields.\n"},
+			"a\n-- stderr --\n[line 1] Only class instances have fields.\n"},
+		{`print ;`, Result{"", "[line 1] Error at ';': Expect expression.\n"},
+			"-- stderr --\n[line 1] Error at ';': Expect expression.\n"},
+	}
+	for _, test := range tests {
+		result := Run(test.script, Options{})
+		if result != test.result {
+			t.Errorf("%s: expected %q, got %q", test.script, test.result, result)
+		}
+		if got := result.String(); got != test.golden {
+			t.Errorf("%s: expected golden %q, got %q", test.script, test.golden, got)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "count.lox")
+	if err := ioutil.WriteFile(path, []byte("for (var i = 0; i < 3; i = i + 1) print i;"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := check(path, Options{}); err == nil || !strings.Contains(err.Error(), "no golden file") {
+		t.Errorf("expected a missing golden file, got %v", err)
+	}
+	if err := check(path, Options{Update: true}); err != nil {
+		t.Fatal(err)
+	}
+	if golden, _ := ioutil.ReadFile(GoldenPath(path)); string(golden) != "0\n1\n2\n" {
+		t.Errorf("unexpected golden file %q", golden)
+	}
+	if err := check(path, Options{}); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	ioutil.WriteFile(GoldenPath(path), []byte("0\n2\n2\n"), 0644)
+	err := check(path, Options{})
+	if err == nil || !strings.Contains(err.Error(), `line 2: expected "2", got "1"`) {
+		t.Errorf("expected a difference on line 2, got %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package interp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// benchScripts are the scripts of testdata/bench with their output,
+// each one exercises a path of the interpreter: the function calls
+// (fib), the instances and the fields (binary_trees), the method
+// calls (method_call) and the strings (string_equality).
+var benchScripts = []struct {
+	name   string
+	output string
+}{
+	{"fib", "832040\n"},
+	{"binary_trees", "-1\n4\n-2048\n6\n-512\n8\n-128\n10\n-32\n-1\n"},
+	{"method_call", "300000\n"},
+	{"string_equality", "150000\n"},
+}
+
+// readBenchScript reads a script of testdata/bench.
+func readBenchScript(tb testing.TB, name string) string {
+
+	script, err := ioutil.ReadFile(filepath.Join("testdata", "bench", name+".lox"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return string(script)
+}
+
+// TestBenchScripts checks the output of the benchmark scripts on both
+// backends, so the benchmarks measure scripts that run correctly.
+func TestBenchScripts(t *testing.T) {
+
+	if testing.Short() {
+		t.Skip("the benchmark scripts are slow")
+	}
+	for _, test := range benchScripts {
+		script := readBenchScript(t, test.name)
+		for _, backend := range []Backend{TreeWalker, VM} {
+			var out, errOut bytes.Buffer
+			i := New(&out, &errOut)
+			i.SetBackend(backend)
+			i.Run(script, false)
+			if out.String() != test.output || errOut.Len() > 0 {
+				t.Errorf("%s (backend %d): expected %q, got %q (errors %q)",
+					test.name, backend, test.output, out.String(), errOut.String())
+			}
+		}
+	}
+}
+
+// benchmarkScript runs a script of testdata/bench b.N times, each
+// time in a new interpreter with its output discarded.
+func benchmarkScript(b *testing.B, name string, backend Backend) {
+
+	script := readBenchScript(b, name)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		i := New(ioutil.Discard, ioutil.Discard)
+		i.SetBackend(backend)
+		i.Run(script, false)
+		if i.HadCompileError() || i.HadRuntimeError() {
+			b.Fatalf("%s failed", name)
+		}
+	}
+}
+
+func BenchmarkFib(b *testing.B) { benchmarkScript(b, "fib", TreeWalker) }
+
+func BenchmarkFibVM(b *testing.B) { benchmarkScript(b, "fib", VM) }
+
+func BenchmarkBinaryTrees(b *testing.B) { benchmarkScript(b, "binary_trees", TreeWalker) }
+
+func BenchmarkBinaryTreesVM(b *testing.B) { benchmarkScript(b, "binary_trees", VM) }
+
+func BenchmarkMethodCall(b *testing.B) { benchmarkScript(b, "method_call", TreeWalker) }
+
+func BenchmarkMethodCallVM(b *testing.B) { benchmarkScript(b, "method_call", VM) }
+
+func BenchmarkStringEquality(b *testing.B) { benchmarkScript(b, "string_equality", TreeWalker) }
+
+func BenchmarkStringEqualityVM(b *testing.B) { benchmarkScript(b, "string_equality", VM) }
@@ -0,0 +1,64 @@
+package interp
+
+import (
+	"io/ioutil"
+
+	"github.com/rmonnet/glox/lang"
+)
+
+// Check validates a lox script without executing it.
+// The script is scanned, parsed and resolved and the errors and
+// warnings reported along the way are returned. An empty result
+// means the script is ready to run.
+// Resolution is skipped if the script has syntax errors.
+func Check(source string) []lang.Diagnostic {
+
+	_, diagnostics := analyze(source, false)
+	return diagnostics
+}
+
+// analyze scans, parses and resolves a lox script without
+// executing it. It returns the resolver (nil if the script has
+// syntax errors) and the diagnostics reported by all the phases.
+func analyze(source string, recordSymbols bool) (*Resolver, []lang.Diagnostic) {
+
+	scanner := &lang.Scanner{}
+	scanner.RedirectErrors(ioutil.Discard)
+	tokens := scanner.ScanTokens(source)
+
+	parser := &lang.Parser{}
+	parser.RedirectErrors(ioutil.Discard)
+	statements := parser.Parse(tokens)
+
+	diagnostics := append(scanner.Diagnostics(), parser.Diagnostics()...)
+	if scanner.HadError() || parser.HadError() {
+		return nil, diagnostics
+	}
+
+	resolver := resolve(statements, recordSymbols)
+	return resolver, append(diagnostics, resolver.Diagnostics()...)
+}
+
+// CheckDocument validates a parsed document (see lang.Document)
+// like Check, the editors update the document incrementally after
+// each change and check it again.
+func CheckDocument(doc *lang.Document) []lang.Diagnostic {
+
+	diagnostics := doc.Diagnostics()
+	if doc.HadError() {
+		return diagnostics
+	}
+	resolver := resolve(doc.Statements(), false)
+	return append(diagnostics, resolver.Diagnostics()...)
+}
+
+// resolve resolves the statements with a new interpreter,
+// the diagnostics are not written.
+func resolve(statements []lang.Stmt, recordSymbols bool) *Resolver {
+
+	resolver := NewResolver(New(ioutil.Discard, ioutil.Discard))
+	resolver.RedirectErrors(ioutil.Discard)
+	resolver.SetRecordSymbols(recordSymbols)
+	resolver.Resolve(statements)
+	return resolver
+}
@@ -0,0 +1,45 @@
+package interp
+
+import "sort"
+
+// GlobalNames returns the names of the global variables, functions,
+// classes and natives, sorted. It is used by the REPL to complete
+// the identifiers.
+func (i *Interp) GlobalNames() []string {
+
+	names := i.globalEnv.names()
+	sort.Strings(names)
+	return names
+}
+
+// MemberNames returns the names of the properties known to the
+// interpreter, sorted: the methods of the global classes and the
+// fields and methods of the global instances. It is used by the REPL
+// to complete the property names after a dot.
+func (i *Interp) MemberNames() []string {
+
+	members := make(map[string]bool)
+	addMethods := func(class *loxClass) {
+		for _, name := range class.methodNames() {
+			members[name] = true
+		}
+	}
+	for _, value := range i.globalEnv.values {
+		if class, ok := value.asClass(); ok {
+			addMethods(class)
+		}
+		if instance, ok := value.asInstance(); ok {
+			addMethods(instance.class)
+			for name := range instance.fields {
+				members[name] = true
+			}
+		}
+	}
+
+	names := make([]string, 0, len(members))
+	for name := range members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
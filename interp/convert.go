@@ -0,0 +1,227 @@
+package interp
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// toLox converts a Go value to lox:
+//   - nil, the booleans and the strings are unchanged
+//   - the integers and the floats are converted to numbers
+//   - the maps with string keys and the structs are converted to
+//     instances, with a field for each entry of the map or exported
+//     field of the struct (named by its `lox` tag if any)
+//   - the pointers and the interfaces are converted to the value
+//     they point to
+//
+// The values of the other types are converted to nil.
+func toLox(value interface{}) loxValue {
+
+	return (&converter{instances: make(map[uintptr]*loxInstance)}).
+		toLox(reflect.ValueOf(value))
+}
+
+// converter converts the Go values to lox, the maps and the struct
+// pointers are converted once so the cycles are preserved.
+type converter struct {
+	instances map[uintptr]*loxInstance
+}
+
+// toLox converts a Go value like the toLox function. A map or a
+// struct pointer already converted returns the same instance, so a
+// value referencing itself becomes an instance referencing itself.
+func (c *converter) toLox(v reflect.Value) loxValue {
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return boolValue(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return numberValue(float64(v.Int()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return numberValue(float64(v.Uint()))
+	case reflect.Float32, reflect.Float64:
+		return numberValue(v.Float())
+	case reflect.String:
+		return stringValue(v.String())
+	case reflect.Interface:
+		return c.toLox(v.Elem())
+	case reflect.Ptr:
+		if v.IsNil() {
+			return loxValue{}
+		}
+		if instance, ok := c.instances[v.Pointer()]; ok {
+			return objectValue(instance)
+		}
+		if v.Elem().Kind() != reflect.Struct {
+			return c.toLox(v.Elem())
+		}
+		instance := newLoxInstance(newLoxClass(v.Elem().Type().Name(), nil, nil))
+		c.instances[v.Pointer()] = instance
+		c.setFields(instance, v.Elem())
+		return objectValue(instance)
+	case reflect.Struct:
+		instance := newLoxInstance(newLoxClass(v.Type().Name(), nil, nil))
+		c.setFields(instance, v)
+		return objectValue(instance)
+	case reflect.Map:
+		if v.IsNil() || v.Type().Key().Kind() != reflect.String {
+			return loxValue{}
+		}
+		if instance, ok := c.instances[v.Pointer()]; ok {
+			return objectValue(instance)
+		}
+		instance := newLoxInstance(newLoxClass("Map", nil, nil))
+		c.instances[v.Pointer()] = instance
+		c.setFields(instance, v)
+		return objectValue(instance)
+	default:
+		return loxValue{}
+	}
+}
+
+// setFields sets the fields of an instance from a map or a struct.
+func (c *converter) setFields(instance *loxInstance, v reflect.Value) {
+
+	eachField(v, func(name string, field reflect.Value) {
+		instance.fields[name] = c.toLox(field)
+	})
+}
+
+// eachField calls f for each entry of a map with string keys or
+// for each exported field of a struct (named by its `lox` tag if
+// any, the fields tagged "-" are skipped).
+func eachField(v reflect.Value, f func(name string, field reflect.Value)) {
+
+	switch v.Kind() {
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			f(iter.Key().String(), iter.Value())
+		}
+	case reflect.Struct:
+		for n := 0; n < v.NumField(); n++ {
+			field := v.Type().Field(n)
+			if field.PkgPath != "" {
+				continue
+			}
+			name := field.Name
+			if tag, ok := field.Tag.Lookup("lox"); ok {
+				if tag == "-" {
+					continue
+				}
+				name = tag
+			}
+			f(name, v.Field(n))
+		}
+	}
+}
+
+// goValue converts a lox value to Go: nil, a bool, a float64, a
+// string or, for an instance, a map[string]interface{} of its
+// fields. The other values (the functions and the classes) are
+// converted to a fmt.Stringer printing them like lox.
+func (i *Interp) goValue(value loxValue) interface{} {
+
+	return i.toGo(value, make(map[*loxInstance]map[string]interface{}))
+}
+
+// toGo converts a lox value like goValue. converted holds the maps
+// of the instances already converted, an instance met again returns
+// the same map so the cycles end and the shared instances stay shared.
+func (i *Interp) toGo(value loxValue, converted map[*loxInstance]map[string]interface{}) interface{} {
+
+	switch value.kind {
+	case nilKind, unassignedKind:
+		return nil
+	case boolKind:
+		return value.asBool()
+	case numberKind:
+		return value.num
+	case stringKind:
+		return value.asString()
+	}
+	instance, ok := value.asInstance()
+	if !ok {
+		return object{value}
+	}
+	if fields, ok := converted[instance]; ok {
+		return fields
+	}
+	fields := make(map[string]interface{}, len(instance.fields))
+	converted[instance] = fields
+	for name, field := range instance.fields {
+		fields[name] = i.toGo(field, converted)
+	}
+	return fields
+}
+
+// object is a function or a class converted to Go.
+type object struct {
+	value loxValue
+}
+
+// String prints the function or the class like lox.
+func (o object) String() string {
+	return o.value.String()
+}
+
+// DefineFields defines a global variable for each entry of a map
+// with string keys or for each exported field of a struct (or of a
+// pointer to a struct), converted like with Define.
+func (i *Interp) DefineFields(data interface{}) error {
+
+	v := reflect.ValueOf(data)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	switch {
+	case v.Kind() == reflect.Struct,
+		v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String:
+	default:
+		return fmt.Errorf("can't define the fields of %T", data)
+	}
+	c := &converter{instances: make(map[uintptr]*loxInstance)}
+	eachField(v, func(name string, field reflect.Value) {
+		i.globalEnv.define(name, c.toLox(field))
+	})
+	return nil
+}
+
+// Eval evaluates an expression in the global environment and
+// returns its value converted to Go: nil, a bool, a float64, a
+// string, a map[string]interface{} of the fields of an instance or
+// a fmt.Stringer for the functions and the classes. The error is
+// the syntax error or the runtime error of the expression.
+func (i *Interp) Eval(expression string) (interface{}, error) {
+
+	value, err := Frame{interp: i, env: i.globalEnv}.Evaluate(expression)
+	if err != nil {
+		return nil, err
+	}
+	return i.goValue(value.value), nil
+}
+
+// Result returns the value of the last statement of the last script
+// run by the tree-walker, converted like with Eval, if it is an
+// expression statement which completed. The script can "return" a
+// value to the host application this way.
+func (i *Interp) Result() (interface{}, bool) {
+
+	if !i.hasResult {
+		return nil, false
+	}
+	return i.goValue(i.result), true
+}
+
+// TypeOf evaluates an expression in the global environment like
+// Eval and returns the name of the type of its value: "nil",
+// "boolean", "number", "string", "function", "class" or, for an
+// instance, the name of its class.
+func (i *Interp) TypeOf(expression string) (string, error) {
+
+	value, err := Frame{interp: i, env: i.globalEnv}.Evaluate(expression)
+	if err != nil {
+		return "", err
+	}
+	return typeName(value.value), nil
+}
@@ -0,0 +1,236 @@
+package interp
+
+import (
+	"errors"
+	"io/ioutil"
+	"sort"
+	"strconv"
+
+	"github.com/rmonnet/glox/lang"
+)
+
+// frame is an active call tracked for the hook
+// and for the stack traces.
+type frame struct {
+	function string
+	// stmt is the statement executing, env and upvalues are
+	// the environment and the upvalues it executes in.
+	stmt     lang.Stmt
+	env      *env
+	upvalues []*upvalue
+}
+
+// pushFrame tracks a call, the frames popped are reused
+// so the calls don't allocate.
+func (i *Interp) pushFrame(function string) {
+
+	n := len(i.frames)
+	if n < cap(i.frames) && i.frames[:n+1][n] != nil {
+		i.frames = i.frames[:n+1]
+		*i.frames[n] = frame{function: function}
+		return
+	}
+	i.frames = append(i.frames, &frame{function: function})
+}
+
+// SetHook installs a function called by the tree-walker before each
+// statement it executes (blocks excepted). A debugger stops the
+// execution by not returning from the hook and inspects it with
+// Frames and Globals in the meantime.
+// The virtual machine doesn't call the hook. There is no hook
+// by default, nil removes it.
+func (i *Interp) SetHook(hook func(stmt lang.Stmt)) {
+
+	i.hook = hook
+}
+
+// callHook records the statement about to execute
+// in the current frame and calls the hook, if any.
+func (i *Interp) callHook(stmt lang.Stmt) {
+
+	if len(i.frames) == 0 {
+		i.frames = append(i.frames, &frame{function: "script"})
+	}
+	current := i.frames[len(i.frames)-1]
+	current.stmt = stmt
+	current.env = i.env
+	current.upvalues = i.upvalues
+	if i.hook != nil {
+		i.hook(stmt)
+	}
+}
+
+// Frame is an active call, as seen from the hook.
+type Frame struct {
+	// Function is the name of the function called,
+	// "script" for the top level of the script.
+	Function string
+	// Token starts the statement executing.
+	Token    *lang.Token
+	interp   *Interp
+	env      *env
+	upvalues []*upvalue
+}
+
+// Frames returns the active calls from the hook, the innermost
+// first. The frames are only valid until the hook returns.
+func (i *Interp) Frames() []Frame {
+
+	frames := make([]Frame, 0, len(i.frames))
+	for n := len(i.frames) - 1; n >= 0; n-- {
+		f := i.frames[n]
+		frames = append(frames, Frame{f.function, lang.StmtStart(f.stmt),
+			i, f.env, f.upvalues})
+	}
+	return frames
+}
+
+// Depth returns the number of active calls from the hook,
+// including the top level of the script.
+func (i *Interp) Depth() int {
+
+	return len(i.frames)
+}
+
+// Locals returns the local variables visible from the statement of
+// the frame, the innermost scopes first, followed by the variables
+// captured by the function.
+func (f Frame) Locals() []Variable {
+
+	var variables []Variable
+	for e := f.env; e != nil && e != f.interp.globalEnv; e = e.enclosing {
+		for slot := len(e.slotNames) - 1; slot >= 0; slot-- {
+			variables = append(variables,
+				f.interp.variable(e.slotNames[slot], e.slots[slot]))
+		}
+	}
+	for _, u := range f.upvalues {
+		variables = append(variables,
+			f.interp.variable(u.env.slotNames[u.slot], u.env.slots[u.slot]))
+	}
+	return variables
+}
+
+// Evaluate evaluates an expression in the environment of the
+// statement of the frame, like the print command of a debugger.
+// The variables are looked up by name in the scopes of the frame,
+// then in the variables captured by the function and in the globals.
+// The hook is not called while the expression is evaluated, the
+// error is the syntax error or the runtime error of the expression.
+func (f Frame) Evaluate(expression string) (value Variable, err error) {
+
+	scanner := &lang.Scanner{}
+	scanner.RedirectErrors(ioutil.Discard)
+	tokens := scanner.ScanTokens(expression)
+	parser := &lang.Parser{}
+	parser.RedirectErrors(ioutil.Discard)
+	expr := parser.ParseExpression(tokens)
+	if diagnostics := append(scanner.Diagnostics(), parser.Diagnostics()...); len(diagnostics) > 0 {
+		return Variable{}, errors.New(diagnostics[0].Message)
+	}
+
+	lang.Walk([]lang.Stmt{&lang.ExprStmt{Expression: expr}}, func(node lang.Node, depth int) {
+		switch e := node.(type) {
+		case *lang.AssignExpr:
+			e.Binding = f.binding(e.Name.Lexeme)
+		case *lang.VarExpr:
+			e.Binding = f.binding(e.Name.Lexeme)
+		case *lang.ThisExpr:
+			e.Binding = f.binding("this")
+		case *lang.SuperExpr:
+			e.Binding = f.binding("super")
+			e.This = f.binding("this")
+		}
+	})
+
+	i := f.interp
+	env, upvalues, hook, callDepth := i.env, i.upvalues, i.hook, i.callDepth
+	i.env, i.upvalues, i.hook = f.env, f.upvalues, nil
+	defer func() {
+		// a runtime error may unwind calls of the expression.
+		i.env, i.upvalues, i.hook, i.callDepth = env, upvalues, hook, callDepth
+		if e := recover(); e != nil {
+			runtimeError, ok := e.(RuntimeError)
+			if !ok {
+				panic(e)
+			}
+			err = errors.New(runtimeError.Message)
+		}
+	}()
+	return i.variable(expression, i.evaluate(expr)), nil
+}
+
+// binding locates a variable by name from the statement of the
+// frame. A variable which is not found is a global.
+func (f Frame) binding(name string) lang.Binding {
+
+	depth := 0
+	for e := f.env; e != nil && e != f.interp.globalEnv; e = e.enclosing {
+		for slot := len(e.slotNames) - 1; slot >= 0; slot-- {
+			if e.slotNames[slot] == name {
+				return lang.Binding{Local: true, Depth: depth, Slot: slot}
+			}
+		}
+		depth++
+	}
+	for index, u := range f.upvalues {
+		if u.env.slotNames[u.slot] == name {
+			return lang.Binding{Upvalue: true, Slot: index}
+		}
+	}
+	return lang.Binding{}
+}
+
+// Globals returns the global variables sorted by name.
+func (i *Interp) Globals() []Variable {
+
+	return i.sortedVariables(i.globalEnv.values)
+}
+
+// Variable is a variable or a field inspected from the hook.
+type Variable struct {
+	Name string
+	// Value is the value as printed, with the strings quoted.
+	Value  string
+	interp *Interp
+	value  loxValue
+}
+
+// variable creates the Variable inspecting a value.
+func (i *Interp) variable(name string, value loxValue) Variable {
+
+	var text string
+	switch {
+	case value.isString():
+		text = strconv.Quote(value.asString())
+	case value.kind == unassignedKind:
+		text = "<unassigned>"
+	default:
+		text = i.stringify(value)
+	}
+	return Variable{name, text, i, value}
+}
+
+// Fields returns the fields of an instance sorted by name,
+// nil for the other values.
+func (v Variable) Fields() []Variable {
+
+	instance, ok := v.value.asInstance()
+	if !ok {
+		return nil
+	}
+	return v.interp.sortedVariables(instance.fields)
+}
+
+// sortedVariables returns the variables of a map sorted by name.
+func (i *Interp) sortedVariables(values map[string]loxValue) []Variable {
+
+	variables := make([]Variable, 0, len(values))
+	for name, value := range values {
+		variables = append(variables, i.variable(name, value))
+	}
+	sort.Slice(variables, func(a, b int) bool {
+		return variables[a].Name < variables[b].Name
+	})
+	return variables
+}
@@ -0,0 +1,127 @@
+package interp
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/rmonnet/glox/lang"
+)
+
+// The codes of the diagnostics reported by the resolver (see
+// lang.CodeExpectExpression and the following constants for the
+// scanner and the parser, and bytecode.CodeTooManyLocals and the
+// following constants for the compiler).
+const (
+	CodeUnreachableCode        = "resolve/unreachable-code"
+	CodeTopLevelReturn         = "resolve/top-level-return"
+	CodeInitializerReturnValue = "resolve/initializer-return-value"
+	CodeSelfInheritance        = "resolve/self-inheritance"
+	CodeDuplicateMethod        = "resolve/duplicate-method"
+	CodeLoopControlOutsideLoop = "resolve/loop-control-outside-loop"
+	CodeReadInOwnInitializer   = "resolve/read-in-own-initializer"
+	CodeThisOutsideClass       = "resolve/this-outside-class"
+	CodeSuperOutsideClass      = "resolve/super-outside-class"
+	CodeSuperWithoutSuperclass = "resolve/super-without-superclass"
+	CodeUnusedLocal            = "resolve/unused-local"
+	CodeAlreadyDeclared        = "resolve/already-declared"
+	CodeShadowing              = "resolve/shadowing"
+	CodeUndefinedGlobal        = "resolve/undefined-variable"
+)
+
+// The codes of the runtime errors.
+const (
+	CodeUndefinedVariable           = "runtime/undefined-variable"
+	CodeUninitializedVariable       = "runtime/uninitialized-variable"
+	CodeUndefinedProperty           = "runtime/undefined-property"
+	CodeUndefinedMethod             = "runtime/undefined-method"
+	CodeNotInstance                 = "runtime/not-an-instance"
+	CodeNotCallable                 = "runtime/not-callable"
+	CodeArity                       = "runtime/arity"
+	CodeStackOverflow               = "runtime/stack-overflow"
+	CodeOperandNotNumber            = "runtime/operand-not-a-number"
+	CodeOperandsNotNumbersOrStrings = "runtime/operands-not-numbers-or-strings"
+	CodeOperandsNotNumbersOrString  = "runtime/operands-not-numbers-or-a-string"
+	CodeDivisionByZero              = "runtime/division-by-zero"
+	CodeSuperclassNotClass          = "runtime/superclass-not-a-class"
+	CodeRuntimeSelfInheritance      = "runtime/self-inheritance"
+	CodeStringTooLong               = "runtime/string-too-long"
+	CodeTooManyFields               = "runtime/too-many-fields"
+	CodeMemoryQuotaExceeded         = "runtime/memory-quota-exceeded"
+	CodeBudgetExceeded              = "runtime/budget-exceeded"
+	CodeTimedOut                    = "runtime/timed-out"
+	CodeCancelled                   = "runtime/cancelled"
+	CodeNativeError                 = "runtime/native-error"
+	CodeInvalidBytecode             = "runtime/invalid-bytecode"
+)
+
+// jsonDiagnostic is a diagnostic written as a JSON line.
+type jsonDiagnostic struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Code     string `json:"code"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// SetJSONDiagnostics makes the interpreter write the errors and
+// warnings as JSON lines instead of text, so editors and tools
+// can consume them. Each line is an object with the members file,
+// line, column (0 if unknown), code (the kind of diagnostic, like
+// lang.CodeExpectExpression or CodeUndefinedVariable, which doesn't
+// change with the names, the numbers or the wording of the message),
+// severity (error or warning) and message.
+// The diagnostics are written as text by default.
+func (i *Interp) SetJSONDiagnostics(enabled bool) {
+
+	i.jsonDiagnostics = enabled
+}
+
+// SetScriptName sets the name of the file the scripts are read
+// from, it is reported in the JSON diagnostics (glox uses "<stdin>"
+// and "<eval>" for the scripts read from stdin and passed with -e).
+func (i *Interp) SetScriptName(name string) {
+
+	i.scriptName = name
+}
+
+// diagnosticOut returns the output the phases write their
+// diagnostics to. The JSON diagnostics are written by
+// the interpreter once the phase is done.
+func (i *Interp) diagnosticOut() io.Writer {
+
+	if i.jsonDiagnostics {
+		return ioutil.Discard
+	}
+	return i.errOut
+}
+
+// reportDiagnostics records the errors reported by a compile phase
+// (scan, parse, resolve or compile) for CompileErrors and writes its
+// diagnostics as JSON lines, if enabled.
+func (i *Interp) reportDiagnostics(diagnostics []lang.Diagnostic) {
+
+	for _, d := range diagnostics {
+		if d.Severity == lang.ErrorSeverity {
+			i.compileErrors = append(i.compileErrors, d)
+		}
+	}
+	i.writeJSONDiagnostics(diagnostics)
+}
+
+// writeJSONDiagnostics writes the diagnostics reported by a compile
+// phase or the runtime error as JSON lines, if enabled.
+func (i *Interp) writeJSONDiagnostics(diagnostics []lang.Diagnostic) {
+
+	if !i.jsonDiagnostics {
+		return
+	}
+	encoder := json.NewEncoder(i.errOut)
+	encoder.SetEscapeHTML(false)
+	for _, d := range diagnostics {
+		encoder.Encode(jsonDiagnostic{i.scriptName, d.Line, d.Column,
+			d.Code, strings.ToLower(d.Severity.String()), d.Message})
+	}
+}
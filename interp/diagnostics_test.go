@@ -0,0 +1,55 @@
+package interp
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/rmonnet/glox/bytecode"
+	"github.com/rmonnet/glox/lang"
+)
+
+func TestDiagnosticCodes(t *testing.T) {
+
+	var locals strings.Builder
+	for n := 0; n < 300; n++ {
+		locals.WriteString("var v" + strings.Repeat("x", n) + " = 1; print v" + strings.Repeat("x", n) + ";\n")
+	}
+
+	tests := []struct {
+		script  string
+		backend Backend
+		code    string
+	}{
+		{`print "a`, TreeWalker, lang.CodeUnterminatedString},
+		{`print 1 +;`, TreeWalker, lang.CodeExpectExpression},
+		{`fun (a) {}`, TreeWalker, lang.CodeExpectFunctionName},
+		{`class A { (a) {} }`, TreeWalker, lang.CodeExpectFunctionName},
+		{`{ var a = 1; }`, TreeWalker, CodeUnusedLocal},
+		{`fun f() { var a = 1; { var a = 2; print a; } print a; }`, TreeWalker, CodeShadowing},
+		{`class A { m() {} m() {} }`, TreeWalker, CodeDuplicateMethod},
+		{`var count; print cuont;`, TreeWalker, CodeUndefinedVariable},
+		{`print count;`, TreeWalker, CodeUndefinedVariable},
+		{`fun f(a) {} f();`, TreeWalker, CodeArity},
+		{`fun f(a) {} f();`, VM, CodeArity},
+		{`print 1 / 0;`, VM, CodeDivisionByZero},
+		{"{\n" + locals.String() + "}", VM, bytecode.CodeTooManyLocals},
+	}
+	for _, test := range tests {
+		var out strings.Builder
+		i := New(&out, &out)
+		i.SetBackend(test.backend)
+		i.SetJSONDiagnostics(true)
+		i.SetWarnShadowing(true)
+		i.Run(test.script, false)
+		var diagnostic jsonDiagnostic
+		if err := json.NewDecoder(strings.NewReader(out.String())).Decode(&diagnostic); err != nil {
+			t.Errorf("%q: expected a diagnostic but got %q", test.script, out.String())
+			continue
+		}
+		if diagnostic.Code != test.code {
+			t.Errorf("%q: expected code %q but got %q (%s)", test.script, test.code,
+				diagnostic.Code, diagnostic.Message)
+		}
+	}
+}
@@ -10,86 +10,157 @@ import (
 // Env represents the interpreter state.
 // Environment are chained backward to allow lookup
 // in enclosing environment (lexical scoping).
+// The global environment stores its variables in a map since
+// globals are looked up by name. Local environments (blocks and
+// calls) store them in a slice indexed by the slot computed by
+// the resolver, the names are only kept for the error messages.
 type env struct {
-	values    map[string]interface{}
+	values    map[string]loxValue
+	slots     []loxValue
+	slotNames []string
 	enclosing *env
 }
 
-// NewEnv creates a new environment.
+// NewEnv creates a new environment. An environment without
+// enclosing environment is a global environment.
 func newEnv(enclosing *env) *env {
 
-	return &env{
-		values:    make(map[string]interface{}),
-		enclosing: enclosing}
+	if enclosing == nil {
+		return &env{values: make(map[string]loxValue)}
+	}
+	return &env{enclosing: enclosing}
 }
 
 // define binds a variable name and its value for the environment.
 // IfToken the variable was already bound, the name value is bound
 // instead and the old value is discarded.
-func (e *env) define(name string, value interface{}) {
+// In a local environment, variables must be defined in the order of
+// their slots (the order of declaration), which the resolver ensures.
+func (e *env) define(name string, value loxValue) {
 
-	e.values[name] = value
+	if e.values != nil {
+		e.values[name] = value
+		return
+	}
+	e.slots = append(e.slots, value)
+	e.slotNames = append(e.slotNames, name)
 }
 
 // get retrieves the value associated with a variable.
-// It the variable is not bound a runtimeError is triggered.
-func (e *env) get(name *lang.Token) interface{} {
+// It the variable is not bound a RuntimeError is triggered.
+func (e *env) get(name *lang.Token) loxValue {
 
-	if value, ok := e.values[name.Lexeme]; ok {
+	if value, ok := e.lookup(name.Lexeme); ok {
 		return value
 	}
 
-	if e.enclosing != nil {
-		return e.enclosing.get(name)
-	}
+	panic(undefinedVariable(name, e.names()))
+}
 
-	panic(runtimeError{name,
-		"Undefined variable '" + name.Lexeme + "'."})
+// lookup retrieves the value associated with a variable in the
+// environment or its enclosing environments. The boolean result
+// reports if the variable was found.
+func (e *env) lookup(name string) (loxValue, bool) {
+
+	for environment := e; environment != nil; environment = environment.enclosing {
+		if value, ok := environment.values[name]; ok {
+			return value, true
+		}
+		if slot := environment.slotOf(name); slot >= 0 {
+			return environment.slots[slot], true
+		}
+	}
+	return loxValue{}, false
 }
 
 // getAt retrieves the value associated with a variable
-// in a given enclosing environment. The environment where
+// in a given enclosing local environment. The environment where
 // the variable is defined is specified by the distance from
-// the current environment.
-// There is no error handling because resolver ensure the name
+// the current environment and the variable by its slot.
+// There is no error handling because resolver ensure the slot
 // is in the environment at the proper distance.
-func (e *env) getAt(distance int, name string) interface{} {
+func (e *env) getAt(distance, slot int) loxValue {
 
-	return e.ancestor(distance).values[name]
+	return e.ancestor(distance).slots[slot]
 }
 
 // assign binds a new value with an existing variable.
 // It returns a RuntimeError if the variable doesn't exist.
-func (e *env) assign(name *lang.Token, value interface{}) {
+func (e *env) assign(name *lang.Token, value loxValue) {
 
-	if _, ok := e.values[name.Lexeme]; ok {
-		e.values[name.Lexeme] = value
-		return
+	if !e.tryAssign(name.Lexeme, value) {
+		panic(undefinedVariable(name, e.names()))
 	}
+}
 
-	if e.enclosing != nil {
-		e.enclosing.assign(name, value)
-		return
+// tryAssign binds a new value with an existing variable in the
+// environment or its enclosing environments. It reports if the
+// variable was found.
+func (e *env) tryAssign(name string, value loxValue) bool {
+
+	for environment := e; environment != nil; environment = environment.enclosing {
+		if _, ok := environment.values[name]; ok {
+			environment.values[name] = value
+			return true
+		}
+		if slot := environment.slotOf(name); slot >= 0 {
+			environment.slots[slot] = value
+			return true
+		}
 	}
-
-	panic(runtimeError{name,
-		"Undefined variable '" + name.Lexeme + "'."})
+	return false
 }
 
 // assignAt binds a new value with an existing variable,
 // looking for the variable in the enclosing environment
 // "distance" levels up from the current environment.
-// There is no error handling because resolver ensure the name
+// There is no error handling because resolver ensure the slot
 // is in the environment at the proper distance.
-func (e *env) assignAt(distance int, name string, value interface{}) {
+func (e *env) assignAt(distance, slot int, value loxValue) {
 
-	e.ancestor(distance).values[name] = value
+	e.ancestor(distance).slots[slot] = value
 }
 
 // ------------------
 // Helper Functions
 // ------------------
 
+// names returns the names of all the variables visible from
+// the environment, including the enclosing environments.
+func (e *env) names() []string {
+
+	var names []string
+	for environment := e; environment != nil; environment = environment.enclosing {
+		for name := range environment.values {
+			names = append(names, name)
+		}
+		names = append(names, environment.slotNames...)
+	}
+	return names
+}
+
+// slotOf returns the slot of a variable in a local environment
+// or -1 if it is not defined there. It is only used when the
+// variable is looked up by name, which is slow but rare.
+func (e *env) slotOf(name string) int {
+
+	for slot, slotName := range e.slotNames {
+		if slotName == name {
+			return slot
+		}
+	}
+	return -1
+}
+
+// undefinedVariable creates the runtime error reported when
+// a variable is not found. The message suggests the closest
+// visible name when the variable looks like a typo.
+func undefinedVariable(name *lang.Token, visible []string) RuntimeError {
+
+	return RuntimeError{name, CodeUndefinedVariable, "Undefined variable '" + name.Lexeme + "'." +
+		didYouMean(name.Lexeme, visible)}
+}
+
 // ancestor return the enclosing environment "distance"
 // levels up from the current environment.
 func (e *env) ancestor(distance int) *env {
@@ -114,6 +185,9 @@ func (e *env) dump(distance int) string {
 	for k, v := range e.values {
 		fmt.Fprintf(&b, "%d) %s=%v\n", distance, k, v)
 	}
+	for slot, k := range e.slotNames {
+		fmt.Fprintf(&b, "%d) %s=%v\n", distance, k, e.slots[slot])
+	}
 	if e.enclosing != nil {
 		fmt.Fprint(&b, e.enclosing.dump(distance+1))
 	}
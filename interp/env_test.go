@@ -13,17 +13,17 @@ func TestDefineAndGet(t *testing.T) {
 		defer func() {
 			err := recover()
 			if err != nil {
-				rte := err.(runtimeError)
+				rte := err.(RuntimeError)
 				t.Fatalf("get returned an error: %s", rte.Error())
 			}
 		}()
 
-		pi := 3.14
+		pi := numberValue(3.14)
 		env := newEnv(nil)
 		env.define("pi", pi)
 		lookupVal := env.get(newToken("pi"))
 		if pi != lookupVal {
-			t.Errorf("Expected %f but got %f", pi, lookupVal)
+			t.Errorf("Expected %v but got %v", pi, lookupVal)
 		}
 	})
 
@@ -32,18 +32,18 @@ func TestDefineAndGet(t *testing.T) {
 		defer func() {
 			err := recover()
 			if err != nil {
-				rte := err.(runtimeError)
+				rte := err.(RuntimeError)
 				t.Fatalf("get returned an error: %s", rte.Error())
 			}
 		}()
 
-		pi := 3.14
+		pi := numberValue(3.14)
 		env := newEnv(nil)
 		env.define("pi", pi)
 		env = newEnv(env)
 		lookupVal := env.get(newToken("pi"))
 		if pi != lookupVal {
-			t.Errorf("Expected %f but got %f", pi, lookupVal)
+			t.Errorf("Expected %v but got %v", pi, lookupVal)
 		}
 	})
 
@@ -52,11 +52,11 @@ func TestDefineAndGet(t *testing.T) {
 		defer func() {
 			err := recover()
 			if err == nil {
-				t.Fatal("Expected get to raise a runtimeError")
+				t.Fatal("Expected get to raise a RuntimeError")
 			} else {
-				_, ok := err.(runtimeError)
+				_, ok := err.(RuntimeError)
 				if !ok {
-					t.Fatal("Expected get to raise a runtimeError")
+					t.Fatal("Expected get to raise a RuntimeError")
 				}
 			}
 		}()
@@ -74,19 +74,19 @@ func TestAssign(t *testing.T) {
 		defer func() {
 			err := recover()
 			if err != nil {
-				rte := err.(runtimeError)
+				rte := err.(RuntimeError)
 				t.Fatalf("assign returned an error: %s", rte.Error())
 			}
 		}()
 
-		pi := 3.14
-		betterPi := 3.14159
+		pi := numberValue(3.14)
+		betterPi := numberValue(3.14159)
 		env := newEnv(nil)
 		env.define("pi", pi)
 		env.assign(newToken("pi"), betterPi)
 		lookupVal := env.get(newToken("pi"))
 		if betterPi != lookupVal {
-			t.Errorf("Expected %f but got %f", pi, lookupVal)
+			t.Errorf("Expected %v but got %v", pi, lookupVal)
 		}
 	})
 
@@ -95,20 +95,20 @@ func TestAssign(t *testing.T) {
 		defer func() {
 			err := recover()
 			if err != nil {
-				rte := err.(runtimeError)
+				rte := err.(RuntimeError)
 				t.Fatalf("assign returned an error: %s", rte.Error())
 			}
 		}()
 
-		pi := 3.14
-		betterPi := 3.14159
+		pi := numberValue(3.14)
+		betterPi := numberValue(3.14159)
 		env := newEnv(nil)
 		env.define("pi", pi)
 		env = newEnv(env)
 		env.assign(newToken("pi"), betterPi)
 		lookupVal := env.get(newToken("pi"))
 		if betterPi != lookupVal {
-			t.Errorf("Expected %f but got %f", pi, lookupVal)
+			t.Errorf("Expected %v but got %v", pi, lookupVal)
 		}
 	})
 
@@ -117,22 +117,45 @@ func TestAssign(t *testing.T) {
 		defer func() {
 			err := recover()
 			if err == nil {
-				t.Fatal("Expected assign to raise a runtimeError")
+				t.Fatal("Expected assign to raise a RuntimeError")
 			} else {
-				_, ok := err.(runtimeError)
+				_, ok := err.(RuntimeError)
 				if !ok {
-					t.Fatal("Expected assign to raise a runtimeError")
+					t.Fatal("Expected assign to raise a RuntimeError")
 				}
 			}
 		}()
 
-		pi := 3.14
+		pi := numberValue(3.14)
 		env := newEnv(nil)
 		env.assign(newToken("pi"), pi)
 	})
 
 }
 
+func TestGetAndAssignAt(t *testing.T) {
+
+	globals := newEnv(nil)
+	globals.define("pi", numberValue(3.14))
+	outer := newEnv(globals)
+	outer.define("name", stringValue("Bob"))
+	outer.define("level", numberValue(3))
+	inner := newEnv(outer)
+	inner.define("count", numberValue(0))
+
+	if got := inner.getAt(1, 1); got != numberValue(3) {
+		t.Errorf("Expected level to be 3 but got %v", got)
+	}
+	inner.assignAt(1, 0, stringValue("Alice"))
+	if got, _ := inner.lookup("name"); got != stringValue("Alice") {
+		t.Errorf("Expected name to be Alice but got %v", got)
+	}
+	inner.assignAt(0, 0, numberValue(1))
+	if got := inner.getAt(0, 0); got != numberValue(1) {
+		t.Errorf("Expected count to be 1 but got %v", got)
+	}
+}
+
 func TestDepth(t *testing.T) {
 
 	env := newEnv(nil)
@@ -151,11 +174,11 @@ func TestDepth(t *testing.T) {
 func TestDump(t *testing.T) {
 
 	env := newEnv(nil)
-	env.define("pi", 3.14)
+	env.define("pi", numberValue(3.14))
 	env = newEnv(env)
-	env.define("name", "Bob")
+	env.define("name", stringValue("Bob"))
 	env = newEnv(env)
-	env.define("level", 3)
+	env.define("level", numberValue(3))
 
 	expect := "0) level=3\n1) name=Bob\n2) pi=3.14\n"
 	got := env.dump(0)
@@ -0,0 +1,79 @@
+//go:build go1.18
+// +build go1.18
+
+package interp
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/rmonnet/glox/lang"
+)
+
+// FuzzSafeRun checks that SafeRun never panics with an internal
+// error, on both backends.
+func FuzzSafeRun(f *testing.F) {
+
+	files, _ := filepath.Glob("../examples/*.lox")
+	for _, file := range files {
+		script, err := ioutil.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(script), false)
+	}
+	f.Add("fun f(n) { if (n < 2) return n; return f(n - 1) + f(n - 2); } print f(10);", true)
+	f.Add("class A { init() { this.x = 1; } } class B < A {} print B().x;", true)
+
+	f.Fuzz(func(t *testing.T, script string, vm bool) {
+		lox := New(ioutil.Discard, ioutil.Discard)
+		// the generated scripts may loop or recurse forever.
+		lox.SetMaxSteps(10000)
+		lox.SetMaxMemory(1 << 20)
+		lox.SetMaxCallDepth(100)
+		if vm {
+			lox.SetBackend(VM)
+		}
+		var panicked *lang.PanicError
+		if err := lox.SafeRun(script); errors.As(err, &panicked) {
+			t.Fatalf("%v\n%s", panicked, panicked.Stack)
+		}
+	})
+}
+
+// unknownStmt is a statement the interpreter doesn't know.
+type unknownStmt struct{ lang.ExprStmt }
+
+// injectUnknown is a pass adding an unknown statement.
+type injectUnknown struct{}
+
+func (injectUnknown) Run(statements []lang.Stmt) []lang.Stmt {
+	return append(statements, &unknownStmt{})
+}
+
+func TestSafeRun(t *testing.T) {
+
+	lox := New(ioutil.Discard, ioutil.Discard)
+	if err := lox.SafeRun("var a = 1;"); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if err := lox.SafeRun("print a +;"); err != ErrCompile {
+		t.Errorf("expected a compile error, got %v", err)
+	}
+	if err := lox.SafeRun("print -nil;"); err == nil || err.Error() != "[line 1] Operand must be a number." {
+		t.Errorf("expected a runtime error, got %v", err)
+	}
+
+	lox.SetPasses(injectUnknown{})
+	var panicked *lang.PanicError
+	if err := lox.SafeRun("print a;"); !errors.As(err, &panicked) {
+		t.Fatalf("expected an internal error, got %v", err)
+	}
+	// the interpreter is still usable.
+	lox.SetPasses()
+	if err := lox.SafeRun("a = a + 1;"); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
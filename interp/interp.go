@@ -3,9 +3,16 @@
 package interp
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/rmonnet/glox/lang"
 )
@@ -14,21 +21,83 @@ import (
 type Interp struct {
 	hadCompileError bool
 	hadRuntimeError bool
+	lastRunFailed   bool
+	runtimeError    *RuntimeError
+	compileErrors   []lang.Diagnostic
 	globalEnv       *env
+	scanner         *lang.Scanner
 	env             *env
-	locals          map[lang.Expr]int
+	returnValue     loxValue
+	// the last top-level expression statement of the script run
+	// by the tree-walker and its value, see Result.
+	resultStmt      *lang.ExprStmt
+	result          loxValue
+	hasResult       bool
+	upvalues        []*upvalue
+	profiling       bool
+	profile         map[interface{}]*profileRecord
+	stats           *PhaseStats
+	callDepth       int
+	maxCallDepth    int
+	steps           int
+	counters        counters
+	statsClass      *loxClass
+	maxSteps        int
+	ctx             context.Context
+	done            <-chan struct{}
+	allocated       int
+	maxMemory       int
+	maxStringLength int
+	maxFields       int
+	allowDivByZero  bool
+	strictInit      bool
+	noCoercion      bool
+	jloxNumbers     bool
+	printFields     bool
+	looseTruthiness bool
+	maxErrors       int
+	passes          []lang.Pass
+	warnShadowing   bool
+	strictGlobals   bool
+	strictRedeclare bool
+	implicitGlobals bool
+	hotRedefinition bool
+	warningMode     WarningMode
+	backend         Backend
+	vm              *vm
+	color           bool
+	jsonDiagnostics bool
+	scriptName      string
+	formatter       *lang.DiagnosticFormatter
+	hook            func(stmt lang.Stmt)
+	traceCalls      bool
+	trace           []StackFrame
+	now             func() time.Time
+	frames          []*frame
 	out             io.Writer
 	errOut          io.Writer
 }
 
+// DefaultMaxCallDepth is the number of nested calls allowed
+// before the interpreter reports a stack overflow.
+const DefaultMaxCallDepth = 1000
+
 // New creates a new interpreter.
 func New(out, errOut io.Writer) *Interp {
 
 	interp := &Interp{}
 	interp.globalEnv = newEnv(nil)
-	interp.globalEnv.define("clock", clock{})
+	interp.globalEnv.define("clock", objectValue(clock{}))
+	interp.globalEnv.define("isNaN", objectValue(isNaN{}))
+	interp.globalEnv.define("isFinite", objectValue(isFinite{}))
+	interp.globalEnv.define("deepEquals", objectValue(deepEquals{}))
+	interp.globalEnv.define("scopes", objectValue(scopes{}))
+	interp.globalEnv.define("debugEnv", objectValue(debugEnv{}))
+	interp.globalEnv.define("runtimeStats", objectValue(runtimeStats{}))
+	interp.scanner = &lang.Scanner{}
 	interp.env = interp.globalEnv
-	interp.locals = make(map[lang.Expr]int)
+	interp.maxErrors = lang.DefaultMaxErrors
+	interp.maxCallDepth = DefaultMaxCallDepth
 	if out == nil {
 		interp.out = os.Stdout
 	} else {
@@ -42,40 +111,404 @@ func New(out, errOut io.Writer) *Interp {
 	return interp
 }
 
+// SetMaxErrors sets the number of compile errors reported by each
+// phase (scanning, parsing and resolving) before it gives up.
+// A value of zero or less removes the limit.
+// The limit is lang.DefaultMaxErrors by default.
+func (i *Interp) SetMaxErrors(max int) {
+
+	i.maxErrors = max
+}
+
+// SetMaxCallDepth sets the number of nested calls allowed before
+// a "Stack overflow." runtime error is reported.
+// A value of zero or less removes the limit, a runaway recursion
+// then crashes the process.
+// The limit is DefaultMaxCallDepth by default.
+func (i *Interp) SetMaxCallDepth(max int) {
+
+	i.maxCallDepth = max
+}
+
+// SetMaxSteps sets the execution budget of each script run.
+// Every statement executed and every loop iteration costs one step,
+// once the budget is spent an "Execution budget exceeded." runtime
+// error is reported. This protects embedders running untrusted
+// scripts from infinite loops.
+// A value of zero or less (the default) removes the limit.
+func (i *Interp) SetMaxSteps(max int) {
+
+	i.maxSteps = max
+}
+
+// SetContext sets the context controlling the execution of the
+// scripts. Once the context is cancelled, the running script stops
+// with an "Execution cancelled." runtime error, or "Execution timed
+// out." if its deadline passed. Embedders can use it to stop a
+// runaway script from another goroutine or after a timeout.
+// The scripts can't be cancelled by default.
+func (i *Interp) SetContext(ctx context.Context) {
+
+	i.ctx = ctx
+	i.done = ctx.Done()
+}
+
+// Context returns the context controlling the execution of the
+// scripts, context.Background() if none was set.
+func (i *Interp) Context() context.Context {
+
+	if i.ctx == nil {
+		return context.Background()
+	}
+	return i.ctx
+}
+
+// SetMaxMemory sets the allocation budget (in bytes) of each script
+// run. The budget is approximate: it counts the instances and the
+// environments created (for blocks and calls) as well as the bytes
+// of the concatenated strings. Once it is spent, a "Memory quota
+// exceeded." runtime error is reported.
+// A value of zero or less (the default) removes the limit.
+func (i *Interp) SetMaxMemory(max int) {
+
+	i.maxMemory = max
+}
+
+// SetMaxStringLength sets the length (in bytes) of the longest
+// string the scripts can build by concatenation. A longer string
+// is reported as a "String too long." runtime error, before it is
+// built, so a single giant value can't exhaust the memory of the
+// host.
+// A value of zero or less (the default) removes the limit.
+func (i *Interp) SetMaxStringLength(max int) {
+
+	i.maxStringLength = max
+}
+
+// SetMaxFields sets the number of fields an instance can hold.
+// Adding a field beyond it is reported as a "Too many fields."
+// runtime error (the existing fields can still be assigned).
+// A value of zero or less (the default) removes the limit.
+func (i *Interp) SetMaxFields(max int) {
+
+	i.maxFields = max
+}
+
+// SetAllowDivisionByZero selects how a division by zero is handled.
+// By default, it is reported as a runtime error. When allowed,
+// it follows the floating point rules and returns +Inf, -Inf or NaN.
+func (i *Interp) SetAllowDivisionByZero(allowed bool) {
+
+	i.allowDivByZero = allowed
+}
+
+// SetStrictInitialization makes reading a variable declared without
+// initializer (`var x;`) a runtime error until it is assigned,
+// instead of reading nil. It is disabled by default.
+func (i *Interp) SetStrictInitialization(enabled bool) {
+
+	i.strictInit = enabled
+}
+
+// SetStringCoercion selects if "+" converts its other operand to
+// a string when one of them is a string (`"a" + 1` is "a1").
+// The coercion is enabled by default. When disabled, "+" follows the
+// reference lox semantics and requires two numbers or two strings.
+func (i *Interp) SetStringCoercion(enabled bool) {
+
+	i.noCoercion = !enabled
+}
+
+// SetJloxNumberFormat selects if numbers are printed exactly like
+// the reference java implementation (2.5, 1.0E7, NaN, Infinity)
+// so the output can be compared with the official lox test suite.
+// It is disabled by default and numbers are printed with the go
+// formatting (2.5, 1e+07, nan, inf).
+func (i *Interp) SetJloxNumberFormat(enabled bool) {
+
+	i.jloxNumbers = enabled
+}
+
+// SetPrintFields selects if instances are printed with their fields,
+// sorted by name (`Cake{flavor: "apple", slices: 8}`), instead of
+// `<instance Cake>`. It is disabled by default.
+func (i *Interp) SetPrintFields(enabled bool) {
+
+	i.printFields = enabled
+}
+
+// SetLooseTruthiness selects if 0 and "" are false in conditions,
+// like in most scripting languages. By default, lox truthiness
+// applies: only false and nil are false.
+func (i *Interp) SetLooseTruthiness(enabled bool) {
+
+	i.looseTruthiness = enabled
+}
+
+// SetPasses sets the optimization passes run on the programs once
+// they are resolved (see lang.OptimizationPasses for the passes of
+// glox -O). The programs are resolved again after the passes, which
+// may have moved some declarations.
+// There is no pass by default.
+func (i *Interp) SetPasses(passes ...lang.Pass) {
+
+	i.passes = passes
+}
+
+// SetWarnShadowing enables warnings for local declarations
+// shadowing a variable from an enclosing scope.
+// The warning is disabled by default.
+func (i *Interp) SetWarnShadowing(enabled bool) {
+
+	i.warnShadowing = enabled
+}
+
+// SetStrictGlobals makes references to globals which are never
+// declared at the top level (and are not natives) compile errors
+// instead of runtime errors.
+func (i *Interp) SetStrictGlobals(enabled bool) {
+
+	i.strictGlobals = enabled
+}
+
+// SetStrictRedeclaration makes declaring a global already declared
+// in the same script a compile error (see
+// Resolver.SetStrictRedeclaration), which catches the copy-pasted
+// declarations. Redeclaring a global is allowed by default.
+func (i *Interp) SetStrictRedeclaration(enabled bool) {
+
+	i.strictRedeclare = enabled
+}
+
+// SetImplicitGlobals enables a compatibility mode for the users of
+// scripting languages like Lua: assigning to a variable which was
+// never declared creates a global, from anywhere in the script,
+// instead of reporting an "Undefined variable" runtime error.
+// Reading an undeclared variable is still an error.
+// The variables must be declared by default.
+func (i *Interp) SetImplicitGlobals(enabled bool) {
+
+	i.implicitGlobals = enabled
+}
+
+// SetWarningMode selects how warnings are reported.
+// Warnings are reported but don't prevent execution by default.
+func (i *Interp) SetWarningMode(mode WarningMode) {
+
+	i.warningMode = mode
+}
+
+// SetBackend selects how the scripts are executed.
+// The backend should not be changed once scripts ran since
+// the functions and classes they declared only work with the
+// backend which created them.
+// The scripts are run by the tree-walker by default.
+func (i *Interp) SetBackend(backend Backend) {
+
+	i.backend = backend
+}
+
+// Backend returns the backend executing the scripts.
+func (i *Interp) Backend() Backend {
+
+	return i.backend
+}
+
+// SetColor enables styling the errors and the warnings with ANSI
+// colors, followed by the source line where they were detected.
+// It should only be enabled when the error output is a terminal.
+// The errors are written as plain text by default.
+func (i *Interp) SetColor(enabled bool) {
+
+	i.color = enabled
+}
+
+// SetClock sets the function returning the current time for the
+// clock() built-in, so the scripts using it can be tested with a
+// deterministic clock. A nil function restores the default.
+// clock() returns the time of the system by default.
+func (i *Interp) SetClock(now func() time.Time) {
+
+	i.now = now
+}
+
+// Define defines a global variable before running the scripts,
+// so they can be parameterized by the embedder. The value is a Go
+// value converted to lox: nil, a bool, a number, a string or an
+// instance for the maps and the structs (values of other types are
+// defined as nil).
+func (i *Interp) Define(name string, value interface{}) {
+
+	i.globalEnv.define(name, toLox(value))
+}
+
 // Run runs the lox interpreter on the provided program.
 func (i *Interp) Run(script string, parseOnly bool) {
 
-	scanner := &lang.Scanner{}
-	scanner.RedirectErrors(i.errOut)
-	tokens := scanner.ScanTokens(script)
-
-	parser := &lang.Parser{}
-	parser.RedirectErrors(i.errOut)
-	statements := parser.Parse(tokens)
+	i.runtimeError = nil
+	i.trace = nil
 
-	if scanner.HadError() || parser.HadError() {
-		i.hadCompileError = true
+	statements, ok := i.Parse(script)
+	if !ok {
 		return
 	}
 
 	if parseOnly {
-		for _, statement := range statements {
-			fmt.Fprint(i.out, statement.PrettyPrint("\n", "  "))
-		}
-		fmt.Println("")
+		lang.PrettyPrint(i.out, statements, lang.PrettyOptions{})
 		return
 	}
 
+	statements, ok = i.resolve(statements)
+	if !ok {
+		return
+	}
+
+	if i.backend == VM {
+		i.runVM(statements)
+		return
+	}
+
+	start := time.Now()
+	i.interpret(statements)
+	if i.stats != nil {
+		i.stats.Executing += time.Since(start)
+	}
+}
+
+// resolve resolves the statements, reporting the errors like
+// in Run, and runs the optimization passes if enabled. It returns
+// the statements to run and false if there were errors.
+func (i *Interp) resolve(statements []lang.Stmt) ([]lang.Stmt, bool) {
+
+	// the resolver records the variable locations in the AST itself,
+	// nothing is kept by the interpreter once the statements of a run
+	// are unreachable, so a long REPL session doesn't grow with each line.
 	resolver := NewResolver(i)
-	resolver.RedirectErrors(i.errOut)
+	resolver.RedirectErrors(i.diagnosticOut())
+	resolver.SetFormatter(i.formatter)
+	resolver.SetMaxErrors(i.maxErrors)
+	resolver.SetWarnShadowing(i.warnShadowing)
+	resolver.SetWarningMode(i.warningMode)
+	resolver.SetStrictGlobals(i.strictGlobals)
+	resolver.SetStrictRedeclaration(i.strictRedeclare)
+	resolver.SetImplicitGlobals(i.implicitGlobals)
+	start := time.Now()
 	resolver.Resolve(statements)
+	if i.stats != nil {
+		i.stats.Resolving += time.Since(start)
+	}
+	i.reportDiagnostics(resolver.Diagnostics())
 
-	if resolver.hadError {
+	if resolver.HadError() {
 		i.hadCompileError = true
-		return
+		i.lastRunFailed = true
+		return nil, false
 	}
 
-	i.interpret(statements)
+	if len(i.passes) > 0 {
+		statements = lang.NewPassManager(i.optimizationPasses()...).Run(statements)
+		// the diagnostics were reported on the source,
+		// the bindings are updated silently.
+		resolver := NewResolver(i)
+		resolver.RedirectErrors(ioutil.Discard)
+		resolver.SetWarningMode(IgnoreWarnings)
+		resolver.Resolve(statements)
+	}
+	return statements, true
+}
+
+// optimizationPasses returns the passes set with SetPasses, the
+// constant folding leaving the strings longer than the limit
+// to the runtime error.
+func (i *Interp) optimizationPasses() []lang.Pass {
+
+	passes := make([]lang.Pass, len(i.passes))
+	for n, pass := range i.passes {
+		if folding, ok := pass.(lang.ConstantFolding); ok && folding.MaxStringLength == 0 {
+			pass = lang.ConstantFolding{MaxStringLength: i.maxStringLength}
+		}
+		passes[n] = pass
+	}
+	return passes
+}
+
+// Scan scans a script without parsing it. The errors are reported
+// like in Run and the result is false if there were any, the tokens
+// are still returned (the invalid characters are skipped).
+func (i *Interp) Scan(script string) ([]*lang.Token, bool) {
+
+	// the scanner is reused so the names are interned across runs
+	// (for example the lines entered in the REPL).
+	scanner := i.scanner
+	scanner.RedirectErrors(i.diagnosticOut())
+
+	// scanning is the first phase of every run, the formatter
+	// is used by the next ones to show the source of the errors.
+	i.lastRunFailed = false
+	i.compileErrors = nil
+	i.formatter = nil
+	if i.color {
+		i.formatter = lang.NewDiagnosticFormatter(script)
+	}
+	scanner.SetFormatter(i.formatter)
+	scanner.SetMaxErrors(i.maxErrors)
+	start := time.Now()
+	tokens := scanner.ScanTokens(script)
+	if i.stats != nil {
+		i.stats.Scanning += time.Since(start)
+		i.stats.Tokens += len(tokens)
+	}
+	i.reportDiagnostics(scanner.Diagnostics())
+
+	if scanner.HadError() {
+		i.hadCompileError = true
+		i.lastRunFailed = true
+		return tokens, false
+	}
+	return tokens, true
+}
+
+// Parse scans and parses a script without resolving or executing
+// it. The syntax errors are reported like in Run and the result is
+// false if there were any.
+func (i *Interp) Parse(script string) ([]lang.Stmt, bool) {
+
+	// the parser runs even if the scanner failed so all the syntax
+	// errors are reported at once.
+	tokens, scanned := i.Scan(script)
+
+	parser := &lang.Parser{}
+	parser.RedirectErrors(i.diagnosticOut())
+	parser.SetFormatter(i.formatter)
+	parser.SetComments(i.scanner.Comments())
+	parser.SetMaxErrors(i.maxErrors)
+	start := time.Now()
+	statements := parser.Parse(tokens)
+	if i.stats != nil {
+		i.stats.Parsing += time.Since(start)
+		i.stats.Nodes += countNodes(statements)
+	}
+	i.reportDiagnostics(parser.Diagnostics())
+
+	if !scanned || parser.HadError() {
+		i.hadCompileError = true
+		i.lastRunFailed = true
+		return nil, false
+	}
+	return statements, true
+}
+
+// Resolve scans, parses and resolves a script without running it.
+// The errors are reported like in Run and the result is false if
+// there were any. The resolved statements are ready for the tools
+// working on the variable bindings, like the transpilers.
+func (i *Interp) Resolve(script string) ([]lang.Stmt, bool) {
+
+	statements, ok := i.Parse(script)
+	if !ok {
+		return nil, false
+	}
+	return i.resolve(statements)
 }
 
 // HadCompileError indicates if errors occurred during
@@ -92,55 +525,197 @@ func (i *Interp) HadRuntimeError() bool {
 	return i.hadRuntimeError
 }
 
-// runtimeError represents an error encountered during
-// Runtime interpretation.
-type runtimeError struct {
-	token   *lang.Token
-	message string
+// LastRunFailed indicates if errors occurred during the last run
+// (or the last scan or parse). Unlike HadCompileError and
+// HadRuntimeError, it only covers the last script, which lets
+// the REPL tell which inputs ran successfully.
+func (i *Interp) LastRunFailed() bool {
+
+	return i.lastRunFailed
 }
 
-// Error extracts the Error Message out of a runtimeError.
-func (e runtimeError) Error() string {
-	return e.message
+// CompileErrors returns the errors (not the warnings) reported by
+// the scanner, the parser, the resolver or the compiler during the
+// last run, in order.
+func (i *Interp) CompileErrors() []lang.Diagnostic {
+
+	return i.compileErrors
 }
 
-// returnValue represents a return object.
-// ThisToken is used in conjunction with panic to unwind the stack
-// to the point of the function call and return the value.
-type returnValue struct {
-	value interface{}
+// RuntimeError returns the runtime error which stopped the last
+// run or nil if it completed normally.
+func (i *Interp) RuntimeError() *RuntimeError {
+
+	return i.runtimeError
+}
+
+// RuntimeError represents an error encountered during
+// Runtime interpretation. Token is where the error was detected.
+type RuntimeError struct {
+	Token *lang.Token
+	// Code identifies the kind of error, like CodeUndefinedVariable.
+	Code    string
+	Message string
+}
+
+// Line returns the line where the error was detected.
+func (e RuntimeError) Line() int {
+	return e.Token.Line
 }
 
+// Error returns the error as reported by the interpreter,
+// for example "[line 3] Operands must be numbers.".
+func (e RuntimeError) Error() string {
+	return fmt.Sprintf("[line %d] %s", e.Line(), e.Message)
+}
+
+// controlFlow tells the enclosing statements how the execution
+// continues after a statement. It is propagated up to the
+// statement handling it (a loop or a function call), which is
+// much cheaper than unwinding the stack with panic.
+type controlFlow int
+
+const (
+	// normalFlow continues with the next statement.
+	normalFlow controlFlow = iota
+	// returnFlow returns from the current function,
+	// the value is stored in Interp.returnValue.
+	returnFlow
+	// breakFlow exits the innermost loop.
+	breakFlow
+)
+
 // interpret evaluates the expression and display the result.
 func (i *Interp) interpret(statements []lang.Stmt) {
 
 	defer func() {
+		i.counters.end = time.Now()
+		if i.stats != nil {
+			i.stats.Statements += i.counters.statements
+		}
 		if e := recover(); e != nil {
-			rte := e.(runtimeError)
-			fmt.Printf("[line %d] %s\n", rte.token.Line, rte.message)
-			i.hadRuntimeError = true
+			if i.traceCalls {
+				i.trace = i.stackTrace(e)
+			}
+			i.callDepth = 0
+			i.upvalues = nil
+			i.frames = nil
+			rte, ok := e.(RuntimeError)
+			if !ok {
+				// an internal error, see SafeRun.
+				panic(e)
+			}
+			i.reportRuntimeError(rte)
 		}
+		i.frames = nil
 	}()
 
+	i.steps = 0
+	i.allocated = 0
+	i.counters = counters{start: time.Now()}
+	i.resultStmt, i.result, i.hasResult = nil, loxValue{}, false
+	if len(statements) > 0 {
+		i.resultStmt, _ = statements[len(statements)-1].(*lang.ExprStmt)
+	}
 	for _, stmt := range statements {
 		i.execute(stmt)
 	}
 }
 
-// execute executes a statement.
-func (i *Interp) execute(stmt lang.Stmt) {
+// reportRuntimeError reports the runtime error which stopped
+// the execution.
+func (i *Interp) reportRuntimeError(rte RuntimeError) {
+
+	if i.jsonDiagnostics {
+		i.writeJSONDiagnostics([]lang.Diagnostic{
+			lang.NewDiagnostic(lang.ErrorSeverity, rte.Token, rte.Code, rte.Message)})
+	} else {
+		fmt.Fprintln(i.errOut, i.formatter.FormatRuntimeError(rte.Error(), rte.Token))
+	}
+	i.runtimeError = &rte
+	i.hadRuntimeError = true
+	i.lastRunFailed = true
+	i.resetProfile()
+}
+
+// approximate size of the objects counted against the memory quota.
+const (
+	envSize      = 64
+	instanceSize = 64
+)
+
+// allocate charges the size of an allocation to the memory quota
+// and reports a runtime error at the token when it is exceeded.
+func (i *Interp) allocate(token *lang.Token, size int) {
+
+	if i.maxMemory <= 0 {
+		return
+	}
+	i.allocated += size
+	if i.allocated > i.maxMemory {
+		panic(RuntimeError{token, CodeMemoryQuotaExceeded, "Memory quota exceeded."})
+	}
+}
+
+// step charges one step to the execution budget and reports
+// a runtime error at the token when it is exceeded.
+func (i *Interp) step(token *lang.Token) {
+
+	if i.maxSteps <= 0 {
+		return
+	}
+	i.steps++
+	if i.steps > i.maxSteps {
+		panic(RuntimeError{token, CodeBudgetExceeded, "Execution budget exceeded."})
+	}
+}
+
+// checkCancelled reports a runtime error at the token if the
+// context controlling the execution is cancelled.
+func (i *Interp) checkCancelled(token *lang.Token) {
+
+	select {
+	case <-i.done:
+		if i.ctx.Err() == context.DeadlineExceeded {
+			panic(RuntimeError{token, CodeTimedOut, "Execution timed out."})
+		}
+		panic(RuntimeError{token, CodeCancelled, "Execution cancelled."})
+	default:
+	}
+}
+
+// execute executes a statement and reports how the execution
+// continues.
+func (i *Interp) execute(stmt lang.Stmt) controlFlow {
+
+	// blocks don't cost anything by themselves, only the
+	// statements they contain.
+	if _, isBlock := stmt.(*lang.BlockStmt); !isBlock {
+		if i.maxSteps > 0 {
+			i.step(lang.StmtStart(stmt))
+		}
+		if i.done != nil {
+			i.checkCancelled(lang.StmtStart(stmt))
+		}
+		i.counters.statements++
+		if i.hook != nil || i.traceCalls {
+			i.callHook(stmt)
+		}
+	}
 
 	switch actualStmt := stmt.(type) {
 	case *lang.ReturnStmt:
-		i.executeReturnStmt(actualStmt)
+		return i.executeReturnStmt(actualStmt)
+	case *lang.BreakStmt:
+		return breakFlow
 	case *lang.PrintStmt:
 		i.executePrintStmt(actualStmt)
 	case *lang.ExprStmt:
 		i.executeExprStmt(actualStmt)
 	case *lang.IfStmt:
-		i.executeIfStmt(actualStmt)
+		return i.executeIfStmt(actualStmt)
 	case *lang.WhileStmt:
-		i.executeWhileStmt(actualStmt)
+		return i.executeWhileStmt(actualStmt)
 	case *lang.VarDeclStmt:
 		i.executeValDeclStmt(actualStmt)
 	case *lang.ClassDeclStmt:
@@ -148,46 +723,69 @@ func (i *Interp) execute(stmt lang.Stmt) {
 	case *lang.FunDeclStmt:
 		i.executeFunDeclStmt(actualStmt)
 	case *lang.BlockStmt:
-		i.executeBlockStmt(actualStmt.Statements, newEnv(i.env))
+		// empty blocks have no token to report the error at,
+		// they are not charged.
+		if token := lang.StmtStart(actualStmt); token != nil && i.maxMemory > 0 {
+			i.allocate(token, envSize)
+		}
+		return i.executeBlockStmt(actualStmt.Statements, newEnv(i.env))
 	default:
 		panic(fmt.Sprintf("Unknown Statement Type: %T", stmt))
 	}
+	return normalFlow
 }
 
 // executeWhileStmt executes a while statement.
-func (i *Interp) executeWhileStmt(stmt *lang.WhileStmt) {
+// The loop stops on break and return.
+func (i *Interp) executeWhileStmt(stmt *lang.WhileStmt) controlFlow {
 
-	for isTruthy(i.evaluate(stmt.Condition)) {
-		i.execute(stmt.Body)
+	for i.isTruthy(i.evaluate(stmt.Condition)) {
+		i.step(stmt.Keyword)
+		if i.done != nil {
+			i.checkCancelled(stmt.Keyword)
+		}
+		switch i.execute(stmt.Body) {
+		case breakFlow:
+			return normalFlow
+		case returnFlow:
+			return returnFlow
+		}
 	}
+	return normalFlow
 }
 
-func (i *Interp) executeReturnStmt(stmt *lang.ReturnStmt) {
+// executeReturnStmt executes a return statement.
+// The value is kept in the interpreter until the call
+// picks it up.
+func (i *Interp) executeReturnStmt(stmt *lang.ReturnStmt) controlFlow {
 
-	var value interface{}
+	var value loxValue
 	if stmt.Value != nil {
 		value = i.evaluate(stmt.Value)
 	}
 
-	// here panic is used in an exception-like pattern
-	// to unwind the stack up to the call return point.
-	panic(returnValue{value})
+	i.returnValue = value
+	return returnFlow
 }
 
 // executeIfStmt executes an if statement.
-func (i *Interp) executeIfStmt(stmt *lang.IfStmt) {
+func (i *Interp) executeIfStmt(stmt *lang.IfStmt) controlFlow {
 
-	if isTruthy(i.evaluate(stmt.Condition)) {
-		i.execute(stmt.ThenBranch)
+	if i.isTruthy(i.evaluate(stmt.Condition)) {
+		return i.execute(stmt.ThenBranch)
 	} else if stmt.ElseBranch != nil {
-		i.execute(stmt.ElseBranch)
+		return i.execute(stmt.ElseBranch)
 	}
+	return normalFlow
 }
 
 // executeBlockStmt executes a block statement.
 // We are passing the set of statement directly so we
 // can reuse that method to execute a function body during a call.
-func (i *Interp) executeBlockStmt(statements []lang.Stmt, blockEnv *env) {
+// Execution stops at the first statement which doesn't complete
+// normally (return or break) and the control flow is
+// passed to the enclosing statement.
+func (i *Interp) executeBlockStmt(statements []lang.Stmt, blockEnv *env) controlFlow {
 
 	previousEnv := i.env
 
@@ -199,29 +797,37 @@ func (i *Interp) executeBlockStmt(statements []lang.Stmt, blockEnv *env) {
 
 	i.env = blockEnv
 	for _, s := range statements {
-		i.execute(s)
+		if flow := i.execute(s); flow != normalFlow {
+			return flow
+		}
 	}
+	return normalFlow
 }
 
 // executeExprstmt executes an expression statement.
 func (i *Interp) executeExprStmt(stmt *lang.ExprStmt) {
 
-	i.evaluate(stmt.Expression)
+	value := i.evaluate(stmt.Expression)
+	if stmt == i.resultStmt {
+		i.result, i.hasResult = value, true
+	}
 }
 
 // executePrintStmt executes a print statement.
 func (i *Interp) executePrintStmt(stmt *lang.PrintStmt) {
 
 	value := i.evaluate(stmt.Expression)
-	fmt.Fprintln(i.out, stringify(value))
+	fmt.Fprintln(i.out, i.stringify(value))
 }
 
 // executeValDeclStmt executes a variable declaration.
 func (i *Interp) executeValDeclStmt(stmt *lang.VarDeclStmt) {
 
-	var value interface{}
+	var value loxValue
 	if stmt.Initializer != nil {
 		value = i.evaluate(stmt.Initializer)
+	} else if i.strictInit {
+		value = unassigned
 	}
 
 	i.env.define(stmt.Name.Lexeme, value)
@@ -236,48 +842,68 @@ func (i *Interp) executeClassDeclStmt(stmt *lang.ClassDeclStmt) {
 	if stmt.Superclass != nil {
 		sc := i.evaluate(stmt.Superclass)
 		var ok bool
-		if superclass, ok = sc.(*loxClass); !ok {
-			panic(runtimeError{stmt.Superclass.Name,
+		if superclass, ok = sc.asClass(); !ok {
+			panic(RuntimeError{stmt.Superclass.Name, CodeSuperclassNotClass,
 				"Superclass must be a class."})
 		}
 	}
+	var previous *loxClass
+	if i.hotRedefinition && i.env == i.globalEnv {
+		previous = i.previousClass(stmt.Name.Lexeme)
+		if previous != nil && superclass.inherits(previous) {
+			panic(RuntimeError{stmt.Superclass.Name, CodeRuntimeSelfInheritance,
+				"A class can't inherit from itself."})
+		}
+	}
 
 	// separate definition from assignment to allow
 	// reference to the class inside its own methods.
-	i.env.define(stmt.Name.Lexeme, nil)
+	i.env.define(stmt.Name.Lexeme, loxValue{})
 
 	environment := i.env
 	if stmt.Superclass != nil {
 		environment = newEnv(i.env)
-		environment.define("super", superclass)
+		environment.define("super", objectValue(superclass))
 	}
 
 	methods := make(map[string]*loxFunction)
 	for _, method := range stmt.Methods {
 		isInitializer := method.Name.Lexeme == "init"
-		function := &loxFunction{method, environment, isInitializer}
+		function := i.newFunction(method, environment, isInitializer)
 		methods[method.Name.Lexeme] = function
 	}
 
-	class := &loxClass{stmt.Name.Lexeme, superclass, methods}
+	var class *loxClass
+	if previous != nil {
+		previous.redefine(superclass, methods)
+		class = previous
+	} else {
+		class = newLoxClass(stmt.Name.Lexeme, superclass, methods)
+		if i.hotRedefinition {
+			class.setSuperclass(superclass)
+		}
+	}
 
-	i.env.assign(stmt.Name, class)
+	i.env.assign(stmt.Name, objectValue(class))
 }
 
 // executeFunDeclStmt executes a function declaration.
 func (i *Interp) executeFunDeclStmt(stmt *lang.FunDeclStmt) {
 
-	function := &loxFunction{stmt, i.env, false}
-	i.env.define(stmt.Name.Lexeme, function)
+	function := i.newFunction(stmt, i.env, false)
+	if i.hotRedefinition && i.env == i.globalEnv {
+		function = i.redefineFunction(stmt.Name.Lexeme, function)
+	}
+	i.env.define(stmt.Name.Lexeme, objectValue(function))
 }
 
 // evaluate evaluates an expression and returns the result
 // as a literal
-func (i *Interp) evaluate(expr lang.Expr) interface{} {
+func (i *Interp) evaluate(expr lang.Expr) loxValue {
 
 	switch actualExpr := expr.(type) {
 	case *lang.Lit:
-		return actualExpr.Value
+		return literalValue(actualExpr.Value)
 	case *lang.GroupingExpr:
 		return i.evaluate(actualExpr.Expression)
 	case *lang.UnaryExpr:
@@ -306,37 +932,36 @@ func (i *Interp) evaluate(expr lang.Expr) interface{} {
 }
 
 // evaluateVar evaluates a variable and returns its value.
-func (i *Interp) evaluateVar(expr *lang.VarExpr) interface{} {
+func (i *Interp) evaluateVar(expr *lang.VarExpr) loxValue {
 
-	return i.lookupVariable(expr.Name, expr)
+	return i.lookupVariable(expr.Name, expr.Binding)
 }
 
 // evaluateThis evaluates the "this" pseudo-variable and returns
 // the instance it is pointing to.
-func (i *Interp) evaluateThis(expr *lang.ThisExpr) interface{} {
+func (i *Interp) evaluateThis(expr *lang.ThisExpr) loxValue {
 
-	return i.lookupVariable(expr.Keyword, expr)
+	return i.lookupVariable(expr.Keyword, expr.Binding)
 }
 
 // evaluateSuper evaluates the "super" pseudo-variable and returns
 // the method in the super class it is pointing to.
-func (i *Interp) evaluateSuper(expr *lang.SuperExpr) interface{} {
+func (i *Interp) evaluateSuper(expr *lang.SuperExpr) loxValue {
 
-	distance := i.locals[expr]
-	superclass := i.env.getAt(distance, "super").(*loxClass)
+	superclass := i.lookupVariable(expr.Keyword, expr.Binding).obj.(*loxClass)
 
 	// we need to bound the method to 'this' in the 'calling' environment
 	// not in the 'super' environment.
-	// 'this' environment is always directly below 'super' environment.
-	this := i.env.getAt(distance-1, "this").(*loxInstance)
+	this := i.lookupVariable(expr.Keyword, expr.This).obj.(*loxInstance)
 
 	method, ok := superclass.findMethod(expr.Method.Lexeme)
 	if ok {
-		return method.bind(this)
+		return objectValue(method.bind(this))
 	}
 
-	panic(runtimeError{expr.Method,
-		fmt.Sprintf("Undefined method '%s'.", expr.Method.Lexeme)})
+	panic(RuntimeError{expr.Method, CodeUndefinedMethod,
+		fmt.Sprintf("Undefined method '%s'.", expr.Method.Lexeme) +
+			didYouMean(expr.Method.Lexeme, superclass.methodNames())})
 
 }
 
@@ -345,17 +970,17 @@ func (i *Interp) evaluateSuper(expr *lang.SuperExpr) interface{} {
 // Logical operators implements short-circuits (if the result
 // can be determined from the left operand, the right one is not
 // evaluated).
-func (i *Interp) evaluateLogical(expr *lang.LogicalExpr) interface{} {
+func (i *Interp) evaluateLogical(expr *lang.LogicalExpr) loxValue {
 
 	left := i.evaluate(expr.LeftExpression)
 
 	switch expr.Operator.Type {
 	case lang.OrToken:
-		if isTruthy(left) {
+		if i.isTruthy(left) {
 			return left
 		}
 	case lang.AndToken:
-		if !isTruthy(left) {
+		if !i.isTruthy(left) {
 			return left
 		}
 	default:
@@ -367,7 +992,7 @@ func (i *Interp) evaluateLogical(expr *lang.LogicalExpr) interface{} {
 
 // evaluateAssign evaluates an Assignment expression and returns
 // the result as a literal.
-func (i *Interp) evaluateAssign(expr *lang.AssignExpr) interface{} {
+func (i *Interp) evaluateAssign(expr *lang.AssignExpr) loxValue {
 
 	value := i.evaluate(expr.Value)
 	i.assignVariable(expr, value)
@@ -376,24 +1001,24 @@ func (i *Interp) evaluateAssign(expr *lang.AssignExpr) interface{} {
 
 // evaluateUnary evaluates a Unary expression and returns
 // the result as a literal.
-func (i *Interp) evaluateUnary(expr *lang.UnaryExpr) interface{} {
+func (i *Interp) evaluateUnary(expr *lang.UnaryExpr) loxValue {
 
 	right := i.evaluate(expr.Expression)
 
 	switch expr.Operator.Type {
 	case lang.MinusToken:
 		val := toNumber(expr.Operator, right)
-		return -val
+		return numberValue(-val)
 	case lang.BangToken:
-		return !isTruthy(right)
+		return boolValue(!i.isTruthy(right))
 	default:
-		return nil
+		return loxValue{}
 	}
 }
 
 // evaluateBinary evaluates a Binary expression and returns the
 // result as a literal.
-func (i *Interp) evaluateBinary(expr *lang.BinaryExpr) interface{} {
+func (i *Interp) evaluateBinary(expr *lang.BinaryExpr) loxValue {
 
 	left := i.evaluate(expr.LeftExpression)
 	right := i.evaluate(expr.RightExpression)
@@ -401,157 +1026,249 @@ func (i *Interp) evaluateBinary(expr *lang.BinaryExpr) interface{} {
 
 	switch op.Type {
 	case lang.MinusToken:
-		return toNumber(op, left) - toNumber(op, right)
+		return numberValue(toNumber(op, left) - toNumber(op, right))
 	case lang.SlashToken:
-		return toNumber(op, left) / toNumber(op, right)
+		// the operands are checked before the divisor.
+		dividend, divisor := toNumber(op, left), toNumber(op, right)
+		if divisor == 0 && !i.allowDivByZero {
+			panic(RuntimeError{expr.Operator, CodeDivisionByZero, "Division by zero."})
+		}
+		return numberValue(dividend / divisor)
 	case lang.StarToken:
-		return toNumber(op, left) * toNumber(op, right)
+		return numberValue(toNumber(op, left) * toNumber(op, right))
 	case lang.PlusToken:
-		if isNumber(left) && isNumber(right) {
-			return toNumber(op, left) + toNumber(op, right)
+		if left.isNumber() && right.isNumber() {
+			return numberValue(left.num + right.num)
+		}
+		if i.noCoercion && !(left.isString() && right.isString()) {
+			panic(RuntimeError{expr.Operator, CodeOperandsNotNumbersOrStrings,
+				"Operands must be two numbers or two strings."})
 		}
 		// to make it easier to debug,
 		// when used for string concatenation, "+" supports
 		// implicit conversion to string
-		if isString(left) || isString(right) {
-			return toString(left) + toString(right)
+		if left.isString() || right.isString() {
+			return i.concatenate(expr.Operator, left, right)
 		}
-		panic(runtimeError{expr.Operator,
+		panic(RuntimeError{expr.Operator, CodeOperandsNotNumbersOrString,
 			"Operands must be two numbers or at least one string."})
 	case lang.GreaterToken:
-		return toNumber(op, left) > toNumber(op, right)
+		return boolValue(toNumber(op, left) > toNumber(op, right))
 	case lang.GreaterEqualToken:
-		return toNumber(op, left) >= toNumber(op, right)
+		return boolValue(toNumber(op, left) >= toNumber(op, right))
 	case lang.LessToken:
-		return toNumber(op, left) < toNumber(op, right)
+		return boolValue(toNumber(op, left) < toNumber(op, right))
 	case lang.LessEqualToken:
-		return toNumber(op, left) <= toNumber(op, right)
+		return boolValue(toNumber(op, left) <= toNumber(op, right))
 	case lang.BangEqualToken:
-		return !isEqual(left, right)
+		return boolValue(!isEqual(left, right))
 	case lang.EqualEqualToken:
-		return isEqual(left, right)
+		return boolValue(isEqual(left, right))
 	}
-	return nil
+	return loxValue{}
 }
 
 // evaluateCall evaluates a function calls and return the
 // result as a literal.
-func (i *Interp) evaluateCall(c *lang.CallExpr) interface{} {
+func (i *Interp) evaluateCall(c *lang.CallExpr) loxValue {
 
 	callee := i.evaluate(c.Callee)
 
-	var arguments []interface{}
-	for _, arg := range c.Arguments {
-		arguments = append(arguments, i.evaluate(arg))
+	arguments := make([]loxValue, len(c.Arguments))
+	for n, arg := range c.Arguments {
+		arguments[n] = i.evaluate(arg)
 	}
 
-	function, ok := callee.(loxCallable)
+	function, ok := callee.asCallable()
 
 	if !ok {
-		panic(runtimeError{c.Paren, "Can only call functions and classes."})
+		panic(RuntimeError{c.Paren, CodeNotCallable, "Can only call functions and classes."})
 	}
 
 	if len(arguments) != function.arity() {
-		panic(runtimeError{c.Paren, fmt.Sprintf(
+		panic(RuntimeError{c.Paren, CodeArity, fmt.Sprintf(
 			"Expected %d arguments but got %d.", function.arity(), len(arguments))})
 	}
 
-	return function.call(i, arguments)
+	if i.maxCallDepth > 0 && i.callDepth >= i.maxCallDepth {
+		panic(RuntimeError{c.Paren, CodeStackOverflow, "Stack overflow."})
+	}
+
+	// a call creates an environment, instantiating a class also
+	// creates an instance.
+	if _, isClass := function.(*loxClass); isClass {
+		i.allocate(c.Paren, instanceSize)
+		i.counters.instances++
+	}
+	i.allocate(c.Paren, envSize)
+	i.counters.calls++
+
+	// the depth is reset by interpret if a runtime error unwinds the calls.
+	i.callDepth++
+	var result loxValue
+	if f, ok := function.(*loxFunction); ok && i.profiling {
+		result = i.profileCall(f, arguments)
+	} else if _, ok := function.(*goNative); ok {
+		result = i.callNative(function, arguments, c.Paren)
+	} else {
+		result = function.call(i, arguments)
+	}
+	i.callDepth--
+	return result
 }
 
 // evaluateGet evaluates a field reference and return the
 // result as a literal.
-func (i *Interp) evaluateGet(expr *lang.GetExpr) interface{} {
+func (i *Interp) evaluateGet(expr *lang.GetExpr) loxValue {
 
 	object := i.evaluate(expr.Object)
 
-	instance, ok := object.(*loxInstance)
+	instance, ok := object.asInstance()
 
 	if !ok {
-		panic(runtimeError{expr.Name,
+		panic(RuntimeError{expr.Name, CodeNotInstance,
 			"Only class instances have fields."})
 	}
 
-	return instance.get(expr.Name)
+	return instance.getCached(expr)
 }
 
 // evaluateSet assigns a field reference and return the
 // assigned value as a literal.
-func (i *Interp) evaluateSet(expr *lang.SetExpr) interface{} {
+func (i *Interp) evaluateSet(expr *lang.SetExpr) loxValue {
 
 	object := i.evaluate(expr.Object)
 
-	instance, ok := object.(*loxInstance)
+	instance, ok := object.asInstance()
 
 	if !ok {
-		panic(runtimeError{expr.Name,
+		panic(RuntimeError{expr.Name, CodeNotInstance,
 			"Only class instances have fields."})
 	}
 
 	value := i.evaluate(expr.Value)
 
-	instance.set(expr.Name, value)
+	i.setField(instance, expr.Name, expr.Name.Lexeme, value)
 	return value
 }
 
+// concatenate converts the operands of "+" to strings and
+// concatenates them. The length of the result is checked against
+// the limit before it is built.
+func (i *Interp) concatenate(operator *lang.Token, left, right loxValue) loxValue {
+
+	l, r := i.toString(left), i.toString(right)
+	if i.maxStringLength > 0 && len(l)+len(r) > i.maxStringLength {
+		panic(RuntimeError{operator, CodeStringTooLong, "String too long."})
+	}
+	i.allocate(operator, len(l)+len(r))
+	return stringValue(l + r)
+}
+
+// setField assigns a field of an instance, reporting a runtime
+// error at the token if a new field exceeds the limit.
+func (i *Interp) setField(instance *loxInstance, token *lang.Token, name string, value loxValue) {
+
+	if i.maxFields > 0 && len(instance.fields) >= i.maxFields {
+		if _, ok := instance.fields[name]; !ok {
+			panic(RuntimeError{token, CodeTooManyFields, "Too many fields."})
+		}
+	}
+	instance.fields[name] = value
+}
+
 // --------------------------------
 // functions and class structures
 // --------------------------------
 
 // the loxCallable interface represents a lox function or closure.
 type loxCallable interface {
-	call(*Interp, []interface{}) interface{}
+	call(*Interp, []loxValue) loxValue
 	arity() int
 }
 
 // the loxFunction represents non-native lox functions.
+// Instead of keeping the whole chain of environments where it is
+// declared, a function only captures the variables it uses from the
+// enclosing functions and blocks (its upvalues). The environment
+// of a call encloses the globals, or the environment holding 'this'
+// for a bound method.
 type loxFunction struct {
 	decl          *lang.FunDeclStmt
-	closure       *env
+	enclosing     *env
+	upvalues      []*upvalue
 	isInitializer bool
+	// method is the method bound to the instance, nil if
+	// the function is not a bound method.
+	method *loxFunction
 }
 
-// call evaluates the body of a lox function.
-func (f *loxFunction) call(interp *Interp, args []interface{}) (result interface{}) {
+// upvalue references a variable captured by a function. It points
+// to the environment holding the variable so the variable is shared
+// between the function and the code declaring it.
+type upvalue struct {
+	env  *env
+	slot int
+}
 
-	// intercept panic returning a returnValue.
-	// this is used by the return statement to ensure
-	// the stack is properly unwound regardless of how
-	// deeply nested the return statement is.
-	defer func() {
-		if err := recover(); err != nil {
-			if retval, ok := err.(returnValue); ok {
-				// initializer always return class instance.
-				if f.isInitializer {
-					result = f.closure.getAt(0, "this")
-				} else {
-					result = retval.value
-				}
-			} else {
-				panic(err)
-			}
+// newFunction creates a function declared in the environment,
+// capturing the upvalues computed by the resolver.
+func (i *Interp) newFunction(decl *lang.FunDeclStmt, declaredIn *env,
+	isInitializer bool) *loxFunction {
+
+	upvalues := make([]*upvalue, len(decl.Upvalues))
+	for index, u := range decl.Upvalues {
+		if u.Local {
+			upvalues[index] = &upvalue{declaredIn.ancestor(u.Depth), u.Slot}
+		} else {
+			upvalues[index] = i.upvalues[u.Index]
 		}
-	}()
+	}
+	return &loxFunction{decl, i.globalEnv, upvalues, isInitializer, nil}
+}
 
-	env := newEnv(f.closure)
+// call evaluates the body of a lox function.
+func (f *loxFunction) call(interp *Interp, args []loxValue) loxValue {
+
+	f.rebind()
+	env := newEnv(f.enclosing)
 
 	for i := 0; i < len(f.decl.Params); i++ {
 		env.define(f.decl.Params[i].Lexeme, args[i])
 	}
 
-	interp.executeBlockStmt(f.decl.Body, env)
+	// the upvalues are reset by interpret if a runtime error
+	// unwinds the calls.
+	enclosingUpvalues := interp.upvalues
+	interp.upvalues = f.upvalues
+	// the frames are also reset by interpret.
+	tracked := interp.hook != nil || interp.traceCalls
+	if tracked {
+		interp.pushFrame(f.decl.Name.Lexeme)
+	}
+	flow := interp.executeBlockStmt(f.decl.Body, env)
+	if tracked {
+		interp.frames = interp.frames[:len(interp.frames)-1]
+	}
+	interp.upvalues = enclosingUpvalues
 
 	// "init()" always returns a reference to the class instance,
-	// even if called directly.
+	// even if called directly ('this' is alone in its environment).
 	if f.isInitializer {
-		return f.closure.getAt(0, "this")
+		return f.enclosing.getAt(0, 0)
+	}
+	if flow == returnFlow {
+		result := interp.returnValue
+		interp.returnValue = loxValue{}
+		return result
 	}
-	return nil
+	return loxValue{}
 }
 
 // arity returns the number of parameters expected by a lox function.
 func (f *loxFunction) arity() int {
 
+	f.rebind()
 	return len(f.decl.Params)
 }
 
@@ -561,9 +1278,9 @@ func (f *loxFunction) arity() int {
 // it references.
 func (f *loxFunction) bind(instance *loxInstance) *loxFunction {
 
-	env := newEnv(f.closure)
-	env.define("this", instance)
-	return &loxFunction{f.decl, env, f.isInitializer}
+	env := newEnv(f.enclosing)
+	env.define("this", objectValue(instance))
+	return &loxFunction{f.decl, env, f.upvalues, f.isInitializer, f}
 }
 
 // string returns a string representation of a lox function.
@@ -576,10 +1293,50 @@ type loxClass struct {
 	Name       string
 	Superclass *loxClass
 	Methods    map[string]*loxFunction
+	// allMethods includes the inherited methods so a method lookup
+	// doesn't walk the superclass chain. Lox classes can't be modified
+	// once declared, except by a hot redefinition which replaces the
+	// table and changes the version.
+	allMethods map[string]*loxFunction
+	// closures are the methods, including the inherited methods,
+	// of the classes declared by the virtual machine.
+	closures map[string]*closure
+	// version counts the hot redefinitions of the class.
+	version int
+	// subclasses are the classes to update when the class is
+	// declared again, recorded only with the hot redefinition.
+	subclasses []*loxClass
+	// replaced are the methods of the virtual machine class before
+	// it was declared again, updated in place by the new methods.
+	replaced map[string]*closure
+}
+
+// cacheKey identifies the version of a class whose method is
+// stored in a property cache.
+type cacheKey struct {
+	class   *loxClass
+	version int
+}
+
+// newLoxClass creates a new lox class and computes its method table.
+func newLoxClass(name string, superclass *loxClass,
+	methods map[string]*loxFunction) *loxClass {
+
+	allMethods := make(map[string]*loxFunction)
+	if superclass != nil {
+		for methodName, method := range superclass.allMethods {
+			allMethods[methodName] = method
+		}
+	}
+	for methodName, method := range methods {
+		allMethods[methodName] = method
+	}
+	return &loxClass{Name: name, Superclass: superclass, Methods: methods,
+		allMethods: allMethods}
 }
 
 // call creates an instance of a lox class.
-func (c *loxClass) call(interp *Interp, args []interface{}) interface{} {
+func (c *loxClass) call(interp *Interp, args []loxValue) loxValue {
 
 	instance := newLoxInstance(c)
 
@@ -587,7 +1344,7 @@ func (c *loxClass) call(interp *Interp, args []interface{}) interface{} {
 		initializer.bind(instance).call(interp, args)
 	}
 
-	return instance
+	return objectValue(instance)
 }
 
 // arity returns the number of parameters expected by a lox class
@@ -601,19 +1358,26 @@ func (c *loxClass) arity() int {
 	return 0
 }
 
-// findMethod look up the requested method name in the class.
+// findMethod look up the requested method name in the class,
+// including the inherited methods.
 func (c *loxClass) findMethod(name string) (*loxFunction, bool) {
 
-	method, ok := c.Methods[name]
-	if ok {
-		return method, true
-	}
+	method, ok := c.allMethods[name]
+	return method, ok
+}
 
-	if c.Superclass != nil {
-		return c.Superclass.findMethod(name)
-	}
+// methodNames returns the names of all the methods available
+// to the class, including inherited methods.
+func (c *loxClass) methodNames() []string {
 
-	return nil, false
+	var names []string
+	for name := range c.allMethods {
+		names = append(names, name)
+	}
+	for name := range c.closures {
+		names = append(names, name)
+	}
+	return names
 }
 
 // string returns a string representation of a lox class.
@@ -625,7 +1389,7 @@ func (c *loxClass) String() string {
 // loxInstance represents an instance of a lox class.
 type loxInstance struct {
 	class  *loxClass
-	fields map[string]interface{}
+	fields map[string]loxValue
 }
 
 // newLoxInstance creates a new instance of the given class.
@@ -633,14 +1397,14 @@ func newLoxInstance(class *loxClass) *loxInstance {
 
 	instance := &loxInstance{
 		class:  class,
-		fields: make(map[string]interface{}),
+		fields: make(map[string]loxValue),
 	}
 	return instance
 }
 
 // get retrieves the value associated with the instance field
 // or raise an error if the field is undefined.
-func (i *loxInstance) get(name *lang.Token) interface{} {
+func (i *loxInstance) get(name *lang.Token) loxValue {
 
 	// lookup name can be a field or a method
 	value, ok := i.fields[name.Lexeme]
@@ -652,18 +1416,47 @@ func (i *loxInstance) get(name *lang.Token) interface{} {
 	method, ok := i.class.findMethod(name.Lexeme)
 
 	if ok {
-		return method.bind(i)
+		return objectValue(method.bind(i))
 	}
 
-	panic(runtimeError{name,
-		fmt.Sprintf("Undefined field or method '%s'.", name.Lexeme)})
+	panic(i.undefinedProperty(name))
 }
 
-// set assigns a value to an instance field. IfToken this field
-// is undefined, set adds it to the instance.
-func (i *loxInstance) set(name *lang.Token, value interface{}) {
+// undefinedProperty creates the runtime error reported when
+// a property is neither a field nor a method of the instance.
+func (i *loxInstance) undefinedProperty(name *lang.Token) RuntimeError {
 
-	i.fields[name.Lexeme] = value
+	candidates := i.class.methodNames()
+	for field := range i.fields {
+		candidates = append(candidates, field)
+	}
+	return RuntimeError{name, CodeUndefinedProperty,
+		fmt.Sprintf("Undefined field or method '%s'.", name.Lexeme) +
+			didYouMean(name.Lexeme, candidates)}
+}
+
+// getCached retrieves the value of a field or a method like get,
+// using the inline cache of the expression to skip the method
+// lookup when the instance class is the same as the last time.
+// Fields are always checked first since they shadow methods.
+func (i *loxInstance) getCached(expr *lang.GetExpr) loxValue {
+
+	if len(i.fields) > 0 {
+		if value, ok := i.fields[expr.Name.Lexeme]; ok {
+			return value
+		}
+	}
+
+	if key, method := expr.Cache.Load(); key == (cacheKey{i.class, i.class.version}) {
+		return objectValue(method.(*loxFunction).bind(i))
+	}
+
+	if method, ok := i.class.findMethod(expr.Name.Lexeme); ok {
+		expr.Cache.Store(cacheKey{i.class, i.class.version}, method)
+		return objectValue(method.bind(i))
+	}
+
+	return i.get(expr.Name)
 }
 
 // string returns a string representation of a lox instance.
@@ -676,126 +1469,240 @@ func (i *loxInstance) String() string {
 // Helper functions
 // ------------------
 
-// Resolve keep track of which environment the expression
-// is defined in.
-// It is called by the Resolver static analyzer.
-func (i *Interp) Resolve(expr lang.Expr, depth int) {
-
-	i.locals[expr] = depth
-}
-
 // lookupVariable looks up the specific variable in the
 // environment using lexical scoping.
-// The specific environment level to select was specified
-// by the static analyzer using the Resolve method.
-func (i *Interp) lookupVariable(name *lang.Token, expr lang.Expr) interface{} {
+// The location of the variable (local, upvalue or global)
+// was computed by the resolver.
+func (i *Interp) lookupVariable(name *lang.Token, binding lang.Binding) loxValue {
+
+	var value loxValue
+	if binding.Local {
+		value = i.env.getAt(binding.Depth, binding.Slot)
+	} else if binding.Upvalue {
+		u := i.upvalues[binding.Slot]
+		value = u.env.slots[u.slot]
+	} else if global, ok := i.globalEnv.lookup(name.Lexeme); ok {
+		value = global
+	} else {
+		// suggestions include the locals visible at this point
+		// since an unresolved local typo ends up as a global lookup.
+		panic(undefinedVariable(name, i.env.names()))
+	}
 
-	if distance, ok := i.locals[expr]; ok {
-		return i.env.getAt(distance, name.Lexeme)
+	if value.kind == unassignedKind {
+		panic(RuntimeError{name, CodeUninitializedVariable, fmt.Sprintf(
+			"Variable '%s' used before assignment.", name.Lexeme)})
 	}
-	return i.globalEnv.get(name)
+	return value
 }
 
 // assignVariable assign the specified value to the variable
 // in the environment using lexical scoping.
-// The specific environment level to select was specified
-// by the static analyzer using the Resolve method.
-func (i *Interp) assignVariable(expr *lang.AssignExpr, value interface{}) {
-
-	if distance, ok := i.locals[expr]; ok {
-		i.env.assignAt(distance, expr.Name.Lexeme, value)
-	} else {
-		i.globalEnv.assign(expr.Name, value)
+// The location of the variable (local, upvalue or global)
+// was computed by the resolver.
+func (i *Interp) assignVariable(expr *lang.AssignExpr, value loxValue) {
+
+	if expr.Binding.Local {
+		i.env.assignAt(expr.Binding.Depth, expr.Binding.Slot, value)
+	} else if expr.Binding.Upvalue {
+		u := i.upvalues[expr.Binding.Slot]
+		u.env.slots[u.slot] = value
+	} else if !i.globalEnv.tryAssign(expr.Name.Lexeme, value) {
+		if !i.implicitGlobals {
+			panic(undefinedVariable(expr.Name, i.env.names()))
+		}
+		i.globalEnv.define(expr.Name.Lexeme, value)
 	}
 }
 
 // stringify returns a valid lox string representation
 // of the literal.
-func stringify(lit interface{}) string {
+func (i *Interp) stringify(lit loxValue) string {
+
+	if lit.isNumber() {
+		return i.formatNumber(lit.num)
+	}
+	if inst, ok := lit.asInstance(); ok && i.printFields {
+		return i.formatInstance(inst, make(map[*loxInstance]bool))
+	}
+	return lit.String()
+}
+
+// formatInstance converts an instance and its fields to a string.
+// visiting holds the instances being printed so cycles are printed
+// as `Cake{...}` instead of recursing forever.
+func (i *Interp) formatInstance(inst *loxInstance, visiting map[*loxInstance]bool) string {
+
+	if visiting[inst] {
+		return inst.class.Name + "{...}"
+	}
+	visiting[inst] = true
+	defer delete(visiting, inst)
 
-	if lit == nil {
-		return "nil"
+	names := make([]string, 0, len(inst.fields))
+	for name := range inst.fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteString(inst.class.Name)
+	sb.WriteString("{")
+	for n, name := range names {
+		if n > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(name)
+		sb.WriteString(": ")
+		field := inst.fields[name]
+		if field.isString() {
+			sb.WriteString(`"` + field.asString() + `"`)
+		} else if v, ok := field.asInstance(); ok {
+			sb.WriteString(i.formatInstance(v, visiting))
+		} else {
+			sb.WriteString(i.stringify(field))
+		}
+	}
+	sb.WriteString("}")
+	return sb.String()
+}
+
+// formatNumber converts a lox number to a string.
+func (i *Interp) formatNumber(n float64) string {
+
+	if i.jloxNumbers {
+		return jloxNumber(n)
+	}
+	switch {
+	case math.IsNaN(n):
+		return "nan"
+	case math.IsInf(n, 1):
+		return "inf"
+	case math.IsInf(n, -1):
+		return "-inf"
 	}
 	// original code remove ".0" suffix from floats
 	// to show they represent integers. Go '%v'
 	// does this automatically
-	return fmt.Sprintf("%v", lit)
+	return fmt.Sprintf("%v", n)
 }
 
-// isTruthy evaluate if the literal is true.
-// In lox, false and nil are false, everything else is true
-func isTruthy(lit interface{}) bool {
+// jloxNumber formats a number like java Double.toString() followed
+// by the removal of the ".0" suffix done by the reference jlox.
+// Java uses the scientific notation (1.0E7) outside of [1e-3, 1e7).
+func jloxNumber(n float64) string {
+
+	switch {
+	case math.IsNaN(n):
+		return "NaN"
+	case math.IsInf(n, 1):
+		return "Infinity"
+	case math.IsInf(n, -1):
+		return "-Infinity"
+	case n == 0:
+		if math.Signbit(n) {
+			return "-0"
+		}
+		return "0"
+	}
 
-	if lit == nil {
-		return false
+	abs := math.Abs(n)
+	if abs >= 1e-3 && abs < 1e7 {
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	}
+	// go returns 1.5E+07, java expects 1.5E7 and 1.0E7 for 1E+07
+	s := strconv.FormatFloat(n, 'E', -1, 64)
+	e := strings.IndexByte(s, 'E')
+	mantissa, exponent := s[:e], s[e+1:]
+	if !strings.ContainsRune(mantissa, '.') {
+		mantissa += ".0"
 	}
+	exp, _ := strconv.Atoi(exponent)
+	return mantissa + "E" + strconv.Itoa(exp)
+}
+
+// isTruthy evaluate if the literal is true.
+// In lox, false and nil are false, everything else is true.
+// With loose truthiness, 0 and "" are also false.
+func (i *Interp) isTruthy(lit loxValue) bool {
 
-	if val, ok := lit.(bool); ok {
-		return val
+	switch lit.kind {
+	case nilKind:
+		return false
+	case boolKind:
+		return lit.asBool()
+	case numberKind:
+		return !i.looseTruthiness || lit.num != 0
+	case stringKind:
+		return !i.looseTruthiness || lit.asString() != ""
 	}
 
 	return true
 }
 
 // isEqual checks if two lox literals are equal
-func isEqual(left interface{}, right interface{}) bool {
+// Values of different types are never equal.
+func isEqual(left loxValue, right loxValue) bool {
+
+	if left.kind != right.kind {
+		return false
+	}
+
+	switch left.kind {
+	case nilKind:
+		return true
+	case boolKind, numberKind:
+		// NaN is not equal to anything, including itself,
+		// like in IEEE 754.
+		return left.num == right.num
+	case stringKind:
+		return left.obj == right.obj
+	default:
+		return isSameObject(left.obj, right.obj)
+	}
+}
+
+// isSameObject checks if two functions, classes or instances are
+// the same. They are compared by identity, except the bound methods:
+// reading the same method twice from the same instance gives equal
+// values, so 'obj.m == obj.m' is true.
+func isSameObject(left, right interface{}) bool {
 
-	// comparing incomparable types in go may cause a panic
-	// but at this point left and right can only be
-	// lox literals, that is NUMBER, STRING or BOOLEAN
+	switch l := left.(type) {
+	case *loxFunction:
+		r, ok := right.(*loxFunction)
+		if !ok || l.method == nil || l.method != r.method {
+			return l == right
+		}
+		return isEqual(l.enclosing.getAt(0, 0), r.enclosing.getAt(0, 0))
+	case *boundMethod:
+		r, ok := right.(*boundMethod)
+		if !ok || l.method != r.method {
+			return l == right
+		}
+		return isEqual(l.receiver, r.receiver)
+	}
 	return left == right
 }
 
 // toNumber convert the operand to a lox number
 // or panic if the type is incorrect.
 func toNumber(operator *lang.Token,
-	operand interface{}) float64 {
+	operand loxValue) float64 {
 
-	val, ok := operand.(float64)
-	if !ok {
-		panic(runtimeError{operator, "Operand must be a number."})
+	if !operand.isNumber() {
+		panic(RuntimeError{operator, CodeOperandNotNumber, "Operand must be a number."})
 	}
-	return val
+	return operand.num
 }
 
 // toString converts any of the lox primitive types
 // to a string. It is used for implicit conversion to
 // string for the "+" operator.
-func toString(value interface{}) string {
-
-	// TODO: it should be sufficient to just printf("%v", value)
-	if value == nil {
-		return "nil"
-	}
+func (i *Interp) toString(value loxValue) string {
 
-	switch v := value.(type) {
-	case string:
-		return v
-	case float64:
-		return fmt.Sprintf("%v", v)
-	case bool:
-		return fmt.Sprintf("%v", v)
-	case *loxFunction:
-		return v.String()
-	case *loxClass:
-		return v.String()
-	case *loxInstance:
-		return v.String()
-	default:
-		panic(fmt.Sprintf("Unexpected primitive type %T", value))
+	if value.isString() {
+		return value.asString()
 	}
-}
-
-// isNumber checks if a generic interface represents a lox float.
-func isNumber(value interface{}) bool {
-
-	_, ok := value.(float64)
-	return ok
-}
-
-// isString checks if a generic interface represents a lox float.
-func isString(value interface{}) bool {
-
-	_, ok := value.(string)
-	return ok
+	return i.stringify(value)
 }
@@ -1,8 +1,16 @@
 package interp
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"time"
+
+	"github.com/rmonnet/glox/bytecode"
+	"github.com/rmonnet/glox/lang"
 )
 
 // -------------
@@ -123,10 +131,34 @@ func ExampleCallExpr_closure2() {
 		}
 	`)
 	// Output:
+	// [line 8] Warning at 'a': Local variable 'a' is never used.
 	// global
 	// global
 }
 
+func ExampleCallExpr_nestedClosures() {
+
+	runScript(`
+		fun outer() {
+			var shared = "before";
+			fun middle() {
+				fun inner() {
+					return shared;
+				}
+				return inner;
+			}
+			fun update(value) {
+				shared = value;
+			}
+			update("after");
+			return middle();
+		}
+		print outer()();
+	`)
+	// Output:
+	// after
+}
+
 func ExampleCallExpr_firstOrderFun() {
 
 	runScript(`
@@ -235,6 +267,49 @@ func ExampleGetExpr_methodInheritance() {
 	// fake it from level 2
 	// do it from level 1
 }
+func ExampleGetExpr_deepInheritance() {
+
+	runScript(`
+		class A {
+			name() { return "A"; }
+			greet() { return "hello from " + this.name(); }
+		}
+		class B < A {}
+		class C < B {
+			name() { return "C"; }
+		}
+		class D < C {
+			greet() { return super.greet() + "!"; }
+		}
+		print B().greet();
+		print D().greet();
+	`)
+	// Output:
+	// hello from A
+	// hello from C!
+}
+
+func ExampleGetExpr_polymorphicSite() {
+
+	runScript(`
+		class Cat { speak() { return "meow"; } }
+		class Dog { speak() { return "woof"; } }
+		var quiet = Cat();
+		quiet.speak = "...";
+		var animals = Cat();
+		fun speak(animal) { return animal.speak; }
+		print speak(animals)();
+		print speak(Dog())();
+		print speak(quiet);
+		print speak(Cat())();
+	`)
+	// Output:
+	// meow
+	// woof
+	// ...
+	// meow
+}
+
 func ExampleGetExpr_invokeInitDirectly() {
 
 	runScript(`
@@ -354,6 +429,31 @@ func ExampleLogicalExpr_truthy() {
 	// true
 }
 
+func ExampleLogicalExpr_looseTruthiness() {
+
+	i := New(os.Stdout, os.Stderr)
+	i.SetLooseTruthiness(true)
+	i.Run(`
+		print !0;
+		print !"";
+		print !1;
+		print !"0";
+		print 0 or "default";
+		if ("") print "not printed"; else print "empty";
+		var n = 3;
+		while (n) n = n - 1;
+		print n;
+	`, false)
+	// Output:
+	// true
+	// true
+	// false
+	// false
+	// default
+	// empty
+	// 0
+}
+
 func ExampleSetExpr() {
 
 	runScript(`
@@ -629,6 +729,71 @@ func ExampleReturnStmt_initAlwaysReturnThis() {
 	// <instance Boat>
 }
 
+func ExampleBreakStmt() {
+
+	for _, backend := range []Backend{TreeWalker, VM} {
+		i := New(os.Stdout, os.Stdout)
+		i.SetBackend(backend)
+		i.Run(`
+			for (var i = 0; ; i = i + 1) {
+				if (i == 2) break;
+				print i;
+			}
+			var callbacks = nil;
+			while (true) {
+				var n = "captured";
+				fun show() { print n; }
+				callbacks = show;
+				{
+					var inner = 1;
+					for (var j = 0; j < 3; j = j + 1) {
+						if (j == inner) break;
+						print "inner " + j;
+					}
+					break;
+				}
+			}
+			callbacks();
+			fun find(n) {
+				var i = 0;
+				while (true) {
+					if (i * i >= n) break;
+					i = i + 1;
+				}
+				return i;
+			}
+			print find(10);
+		`, false)
+	}
+	// Output:
+	// 0
+	// 1
+	// inner 0
+	// captured
+	// 4
+	// 0
+	// 1
+	// inner 0
+	// captured
+	// 4
+}
+
+func Example_compileErrorBreakOutsideLoop() {
+
+	i := runScript(`
+		break;
+		while (true) {
+			fun stop() { break; }
+			stop();
+		}
+	`)
+	fmt.Println(i.HadCompileError())
+	// Output:
+	// [line 2] Error at 'break': Can't use 'break' outside of a loop.
+	// [line 4] Error at 'break': Can't use 'break' outside of a loop.
+	// true
+}
+
 func ExampleVarDeclStmt() {
 
 	runScript(`
@@ -671,8 +836,8 @@ func ExampleWhileStmt_forLoop() {
 func ExampleWhileStmt_infiniteForLoop() {
 
 	// if we use a for loop with no "condition", it loops forever
-	// since we don't have a "break" statement, testing within
-	// a function to use "return" as "break".
+	// unless it is stopped by a "break" (see ExampleBreakStmt) or,
+	// within a function, by a "return".
 	runScript(`
 		fun printTo(n) {
 			var i = 0;
@@ -691,6 +856,42 @@ func ExampleWhileStmt_infiniteForLoop() {
 	// 3
 }
 
+func Example_foldConstants() {
+
+	i := New(os.Stdout, os.Stdout)
+	i.SetPasses(lang.ConstantFolding{})
+	i.Run(`
+		fun area(r) { return 2 * 3 * r; }
+		print area(2);
+		print "fold" + "ed";
+	`, false)
+	// Output:
+	// 12
+	// folded
+}
+
+func Example_optimizationPasses() {
+
+	i := New(os.Stdout, os.Stdout)
+	i.SetPasses(lang.OptimizationPasses(2)...)
+	i.Run(`
+		fun sum(n) {
+			var total = 0;
+			for (var k = 0; k < n; k = k + 1) {
+				var step = 2;
+				if (false) print "never";
+				total = total + step;
+			}
+			return total;
+			print "dead";
+		}
+		print sum(4);
+	`, false)
+	// Output:
+	// [line 10] Warning at 'print': Unreachable code.
+	// 8
+}
+
 // ------------------
 // Standard Library
 // ------------------
@@ -739,6 +940,24 @@ func Example_compileErrorMultipleLocalVarDecl() {
 	fmt.Println(i.HadRuntimeError())
 	// Output:
 	// [line 4] Error at 'a': Variable already declared in this scope.
+	// [line 4] Warning at 'a': Local variable 'a' is never used.
+	// true
+	// false
+}
+
+func Example_compileErrorDuplicateMethod() {
+
+	i := runScript(`
+		class Cake {
+			bake() { print "first"; }
+			slice() {}
+			bake() { print "second"; }
+		}
+	`)
+	fmt.Println(i.HadCompileError())
+	fmt.Println(i.HadRuntimeError())
+	// Output:
+	// [line 5] Error at 'bake': Method already declared in this class.
 	// true
 	// false
 }
@@ -804,6 +1023,63 @@ func Example_compileErrorSuperWithNoSuperClass() {
 	// false
 }
 
+func Example_compileErrorSuperInNestedClass() {
+
+	i := runScript(`
+		class Cake {}
+		class Pie < Cake {
+			bake() {
+				class Crust {
+					bake() {
+						super.bake();
+					}
+				}
+				fun fill() {
+					super.bake();
+				}
+				fill();
+				return Crust;
+			}
+		}
+	`)
+	fmt.Println(i.HadCompileError())
+	// Output:
+	// [line 7] Error at 'super': Can't use 'super' in a class with no superclass.
+	// true
+}
+
+func ExampleSuperExpr_nested() {
+
+	runScript(`
+		class Cake {
+			bake() {
+				print "bake the cake.";
+			}
+		}
+		class Pie < Cake {
+			bake() {
+				class Tart < Cake {
+					bake() {
+						fun later() {
+							super.bake();
+						}
+						later();
+					}
+				}
+				fun fill() {
+					super.bake();
+				}
+				fill();
+				Tart().bake();
+			}
+		}
+		Pie().bake();
+	`)
+	// Output:
+	// bake the cake.
+	// bake the cake.
+}
+
 func Example_compileErrorThisInMethod() {
 
 	i := runScript(`
@@ -852,160 +1128,1512 @@ func Example_compileErrorTopLevelThis() {
 	// false
 }
 
-// ----------------
-// Runtime Errors
-// ----------------
-
-func Example_runtimeErrorArityMismatch() {
+func Example_compileErrorStrictGlobals() {
 
-	i := runScript(`
-		fun add(a, b, c) {
-			return a + b +c;
+	i := New(os.Stdout, os.Stdout)
+	i.SetStrictGlobals(true)
+	i.Run(`
+		fun isEven(n) {
+			if (n == 0) return true;
+			return isOdd(n - 1);
 		}
-		print add(1,2,3);
-		print add(1,2);
-	`)
+		fun isOdd(n) {
+			if (n == 0) return false;
+			return isEven(n - 1);
+		}
+		var count = clock();
+		print isEvn(4);
+		cont = 1;
+	`, false)
 	fmt.Println(i.HadCompileError())
-	fmt.Println(i.HadRuntimeError())
 	// Output:
-	// 6
-	// [line 6] Expected 3 arguments but got 2.
-	// false
+	// [line 11] Error at 'isEvn': Undefined variable 'isEvn'. Did you mean 'isEven'?
+	// [line 12] Error at 'cont': Undefined variable 'cont'. Did you mean 'count'?
 	// true
 }
 
-func Example_runtimeErrorBadCall() {
-
-	i := runScript(`
-		var m = "hello";
-		print m(2);
-	`)
-	fmt.Println(i.HadCompileError())
-	fmt.Println(i.HadRuntimeError())
+func Example_compileErrorStrictRedeclaration() {
+
+	i := New(os.Stdout, os.Stdout)
+	i.SetStrictRedeclaration(true)
+	i.Run(`
+		var total = 0;
+		fun add(n) { total = total + n; }
+		var total = 10;
+		class add {}
+	`, false)
+	// the globals of the previous scripts can be redeclared.
+	i.Run(`var clock = "now"; print clock;`, false)
+	i.Run(`var clock = "later"; print clock;`, false)
 	// Output:
-	// [line 3] Can only call functions and classes.
-	// false
-	// true
+	// [line 4] Error at 'total': Variable already declared in this scope.
+	// [line 5] Error at 'add': Variable already declared in this scope.
+	// now
+	// later
 }
 
-func Example_runtimeErrorBadFieldLookup() {
+func ExampleInterp_SetImplicitGlobals() {
+
+	for _, backend := range []Backend{TreeWalker, VM} {
+		i := New(os.Stdout, os.Stdout)
+		i.SetBackend(backend)
+		i.SetImplicitGlobals(true)
+		i.SetStrictGlobals(true)
+		i.Run(`
+			count = 1;
+			fun increment() { count = count + 1; total = count * 10; }
+			increment();
+			print count;
+			print total;
+		`, false)
+		i.Run(`print missing;`, false)
+	}
+	// Output:
+	// 2
+	// 20
+	// [line 1] Error at 'missing': Undefined variable 'missing'.
+	// 2
+	// 20
+	// [line 1] Error at 'missing': Undefined variable 'missing'.
+}
 
-	i := runScript(`
-		var m = "hello";
-		print m.name;
-	`)
-	fmt.Println(i.HadCompileError())
-	fmt.Println(i.HadRuntimeError())
+func ExampleInterp_SetHotRedefinition() {
+
+	for _, backend := range []Backend{TreeWalker, VM} {
+		i := New(os.Stdout, os.Stdout)
+		i.SetBackend(backend)
+		i.SetHotRedefinition(true)
+		i.Run(`
+			fun greet(name) { return "Hello " + name; }
+			var callback = greet;
+			class Counter {
+				init() { this.count = 0; }
+				increment() { this.count = this.count + 1; }
+			}
+			var counter = Counter();
+			fun tick() { counter.increment(); }
+			tick();
+		`, false)
+		i.Run(`
+			fun greet(name) { return "Hi " + name; }
+			class Counter {
+				init() { this.count = 0; }
+				increment() { this.count = this.count + 10; }
+				show() { print this.count; }
+			}
+			tick();
+			counter.show();
+			print callback("Ann");
+			print callback == greet;
+			class Sub < Counter {}
+			class Counter < Sub {}
+		`, false)
+	}
 	// Output:
-	// [line 3] Only class instances have fields.
-	// false
+	// 11
+	// Hi Ann
 	// true
+	// [line 13] A class can't inherit from itself.
+	// 11
+	// Hi Ann
+	// true
+	// [line 13] A class can't inherit from itself.
 }
 
-func Example_runtimeErrorBadFieldSet() {
+func Example_hotRedefinitionAliasesAndSubclasses() {
+
+	for _, backend := range []Backend{TreeWalker, VM} {
+		i := New(os.Stdout, os.Stdout)
+		i.SetBackend(backend)
+		i.SetHotRedefinition(true)
+		i.Run(`
+			fun f() { return 1; }
+			var h = f;
+			class A {
+				name() { return "A1"; }
+				kind() { return "old"; }
+			}
+			class B < A {
+				own() { return "B"; }
+			}
+			var b = B();
+			var bound = b.name;
+		`, false)
+		i.Run(`
+			fun h() { return 3; }
+			print f();
+			print h();
+			class A {
+				name() { return "A2"; }
+				added() { return "added"; }
+			}
+			print bound();
+			print b.name();
+			print b.added();
+			print b.own();
+			print b.kind;
+		`, false)
+	}
+	// Output:
+	// 1
+	// 3
+	// A2
+	// A2
+	// added
+	// B
+	// [line 13] Undefined field or method 'kind'.
+	// 1
+	// 3
+	// A2
+	// A2
+	// added
+	// B
+	// [line 13] Undefined field or method 'kind'.
+}
 
-	i := runScript(`
-		var m = "hello";
-		m.name = "Bob";
-	`)
+func Example_compileErrorTooManyErrors() {
+
+	i := New(os.Stdout, os.Stdout)
+	i.SetMaxErrors(2)
+	i.Run(`
+		return 1;
+		return 2;
+		return 3;
+		return 4;
+	`, false)
 	fmt.Println(i.HadCompileError())
-	fmt.Println(i.HadRuntimeError())
 	// Output:
-	// [line 3] Only class instances have fields.
-	// false
+	// [line 2] Error at 'return': Can't return from top-level code.
+	// [line 3] Error at 'return': Can't return from top-level code.
+	// too many errors, aborting
 	// true
 }
 
-func Example_runtimeErrorBadOperandNumber() {
+// -----------------
+// Compiler Warnings
+// -----------------
 
-	i := runScript(`print (1 < "a");`)
+func Example_warningUnusedLocals() {
+
+	i := runScript(`
+		fun outer(unusedParam) {
+			var unused = 1;
+			var assignedOnly;
+			assignedOnly = 2;
+			var used = 3;
+			fun helper() {}
+			class Helper {}
+			print used;
+		}
+		var global = "never read";
+		outer(1);
+	`)
 	fmt.Println(i.HadCompileError())
-	fmt.Println(i.HadRuntimeError())
 	// Output:
-	// [line 1] Operand must be a number.
+	// [line 3] Warning at 'unused': Local variable 'unused' is never used.
+	// [line 4] Warning at 'assignedOnly': Local variable 'assignedOnly' is never used.
+	// [line 7] Warning at 'helper': Local function 'helper' is never used.
+	// [line 8] Warning at 'Helper': Local class 'Helper' is never used.
+	// 3
 	// false
-	// true
-
 }
 
-func Example_runtimeError_BadPlusOperands() {
+func Example_warningUnreachableCode() {
 
-	i := runScript(`true + 1;`)
+	i := runScript(`
+		fun sign(n) {
+			if (n < 0) {
+				return -1;
+				print "negative";
+				print "still negative";
+			}
+			if (n == 0) return 0; else { return 1; }
+			print "unreachable";
+		}
+		fun early() {
+			{
+				return;
+			}
+			sign(1) + 1;
+		}
+		fun loop() {
+			while (true) {
+				break;
+				print "after break";
+			}
+		}
+		print sign(-3);
+	`)
 	fmt.Println(i.HadCompileError())
-	fmt.Println(i.HadRuntimeError())
 	// Output:
-	// [line 1] Operands must be two numbers or at least one string.
+	// [line 5] Warning at 'print': Unreachable code.
+	// [line 9] Warning at 'print': Unreachable code.
+	// [line 15] Warning at 'sign': Unreachable code.
+	// [line 20] Warning at 'print': Unreachable code.
+	// -1
 	// false
-	// true
 }
-func Example_runtimeErrorSuperclassNotAClass() {
+
+func Example_warningUnreachableCodeTopLevel() {
 
 	i := runScript(`
-	var Cake = "Not a Class";
-	class Eclair < Cake {}
+		while (true) {
+			break;
+			print "after break";
+		}
+		for (var n = 0; n < 3; n = n + 1) {
+			if (n == 1) break; else { break; }
+			print n;
+		}
+		print "done";
 	`)
 	fmt.Println(i.HadCompileError())
-	fmt.Println(i.HadRuntimeError())
 	// Output:
-	// [line 3] Superclass must be a class.
+	// [line 4] Warning at 'print': Unreachable code.
+	// [line 8] Warning at 'print': Unreachable code.
+	// done
 	// false
-	// true
-
 }
 
-func Example_runtimeErrorUndefinedAssignment() {
+func Example_warningShadowing() {
 
-	i := runScript(`a = 123;`)
-	fmt.Println(i.HadCompileError())
-	fmt.Println(i.HadRuntimeError())
+	i := New(os.Stdout, os.Stdout)
+	i.SetWarnShadowing(true)
+	i.Run(`
+		var a = "global";
+		fun show(a) {
+			{
+				var a = "block";
+				print a;
+			}
+		}
+		show(1);
+		{
+			var clock = 0;
+			var b = clock;
+			{
+				var c = b;
+				print c;
+			}
+		}
+	`, false)
 	// Output:
-	// [line 1] Undefined variable 'a'.
-	// false
-	// true
+	// [line 3] Warning at 'a': Parameter 'a' shadows a variable from an enclosing scope.
+	// [line 5] Warning at 'a': Local variable 'a' shadows a variable from an enclosing scope.
+	// [line 11] Warning at 'clock': Local variable 'clock' shadows a variable from an enclosing scope.
+	// block
+	// 0
 }
 
-func Example_runtimeErrorUndefinedField() {
+func Example_warningsAsErrors() {
 
-	i := runScript(`
-		class Bar {}
-		print Bar().name;	
-	`)
+	i := New(os.Stdout, os.Stdout)
+	i.SetWarningMode(WarningsAsErrors)
+	i.Run(`
+		fun f() {
+			var unused = 1;
+			return;
+			print "unreachable";
+		}
+		print "not executed";
+	`, false)
 	fmt.Println(i.HadCompileError())
-	fmt.Println(i.HadRuntimeError())
 	// Output:
-	// [line 3] Undefined field or method 'name'.
-	// false
+	// [line 5] Error at 'print': Unreachable code.
+	// [line 3] Error at 'unused': Local variable 'unused' is never used.
 	// true
 }
 
-func Example_runtimeErrorUndefinedMethod() {
+func Example_warningsIgnored() {
 
-	i := runScript(`
-		class Bar {}
-		print Bar().name();	
-	`)
+	i := New(os.Stdout, os.Stdout)
+	i.SetWarningMode(IgnoreWarnings)
+	i.Run(`
+		fun f() {
+			var unused = 1;
+			return;
+			print "unreachable";
+		}
+		print "executed";
+	`, false)
 	fmt.Println(i.HadCompileError())
-	fmt.Println(i.HadRuntimeError())
 	// Output:
-	// [line 3] Undefined field or method 'name'.
+	// executed
 	// false
-	// true
 }
 
-func Example_runtimeErrorUndefinedVariable() {
+func ExampleCheck() {
 
-	i := runScript(`print a;`)
-	fmt.Println(i.HadCompileError())
-	fmt.Println(i.HadRuntimeError())
+	for _, d := range Check(`
+		fun f() {
+			var unused;
+			return this;
+		}
+		print "not executed";
+	`) {
+		fmt.Println(d.Severity, d.Line, d.Message)
+	}
+	for _, d := range Check("print (1;") {
+		fmt.Println(d)
+	}
 	// Output:
-	// [line 1] Undefined variable 'a'.
-	// false
-	// true
+	// Error 4 Can't use 'this' outside of a class.
+	// Warning 3 Local variable 'unused' is never used.
+	// [line 1] Error at ';': Expect ')' after expression.
 }
 
-// ------------------
-// Helper Functions
-// ------------------
+func ExampleSymbols() {
+
+	for _, s := range Symbols(`
+		fun greet(name) {
+			var message = "hello " + name;
+			print message;
+		}
+		var who = "bob";
+		greet(who);
+		who = "alice";
+		print clock();
+	`) {
+		var refs []int
+		for _, ref := range s.References {
+			refs = append(refs, ref.Line)
+		}
+		fmt.Printf("%s %s depth=%d line=%d refs=%v\n", s.Kind, s.Name,
+			s.Depth, s.Definition.Line, refs)
+	}
+	// Output:
+	// function greet depth=0 line=2 refs=[7]
+	// variable who depth=0 line=6 refs=[7 8]
+	// parameter name depth=1 line=2 refs=[3]
+	// variable message depth=1 line=3 refs=[4]
+}
+
+func ExampleMinify() {
+
+	script := `
+		// the names of the locals are shortened
+		var total = 0;
+		fun add(value) {
+			var sum = total + value;
+			{ var a = 1; sum = sum + a; }
+			total = sum;
+		}
+		add(2);
+		print total;
+	`
+	minified, _ := Minify(script, false)
+	fmt.Println(minified)
+	minified, _ = Minify(script, true)
+	fmt.Println(minified)
+	New(os.Stdout, os.Stdout).Run(minified, false)
+	// Output:
+	// var total=0;fun add(value){var sum=total+value;{var a=1;sum=sum+a;}total=sum;}add(2);print total;
+	// var total=0;fun add(b){var c=total+b;{var d=1;c=c+d;}total=c;}add(2);print total;
+	// 3
+}
+
+func ExampleRename() {
+
+	script := `var count = 0;
+fun incr(step) {
+  var total = count + step; // the comment stays
+  count = total;
+}
+incr(count);`
+	renamed, err := Rename(script, Position{2, 11}, "delta")
+	fmt.Println(renamed, err)
+	_, err = Rename(script, Position{3, 7}, "step")
+	fmt.Println(err)
+	_, err = Rename(script, Position{2, 11}, "count")
+	fmt.Println(err)
+	_, err = Rename(script, Position{3, 7}, "count")
+	fmt.Println(err)
+	_, err = Rename(script, Position{2, 5}, "clock")
+	fmt.Println(err)
+	_, err = Rename(script, Position{2, 5}, "class")
+	fmt.Println(err)
+	_, err = Rename("var a; fun f() { var b; print b; }", Position{1, 22}, "a")
+	fmt.Println(err)
+	// Output:
+	// var count = 0;
+	// fun incr(delta) {
+	//   var total = count + delta; // the comment stays
+	//   count = total;
+	// }
+	// incr(count); <nil>
+	// renaming to 'step' would break the script: [line 3] Error at 'step': Variable already declared in this scope.
+	// 'count' would collide with another declaration
+	// renaming to 'count' would break the script: [line 3] Error at 'count': Can't read local variable in its own initializer.
+	// 'clock' would shadow the native function
+	// 'class' is not a valid name
+	// 'a' would shadow another variable
+}
+
+func ExampleInterp_Profile() {
+
+	i := New(os.Stdout, os.Stdout)
+	i.SetProfiling(true)
+	i.Run(`
+		fun fib(n) {
+			if (n < 2) return n;
+			return fib(n - 1) + fib(n - 2);
+		}
+		class Counter {
+			init() { this.count = 0; }
+			incr() { this.count = this.count + 1; }
+		}
+		var counter = Counter();
+		for (var n = 0; n < 3; n = n + 1) counter.incr();
+		print fib(10);
+	`, false)
+	calls := make(map[string]int)
+	for _, entry := range i.Profile() {
+		calls[fmt.Sprintf("%s (line %d)", entry.Name, entry.Line)] = entry.Calls
+	}
+	fmt.Println(calls)
+	// Output:
+	// 55
+	// map[fib (line 2):177 incr (line 8):3]
+}
+
+// ----------------
+// Runtime Errors
+// ----------------
+
+func Example_runtimeErrorArityMismatch() {
+
+	i := runScript(`
+		fun add(a, b, c) {
+			return a + b +c;
+		}
+		print add(1,2,3);
+		print add(1,2);
+	`)
+	fmt.Println(i.HadCompileError())
+	fmt.Println(i.HadRuntimeError())
+	// Output:
+	// 6
+	// [line 6] Expected 3 arguments but got 2.
+	// false
+	// true
+}
+
+func Example_runtimeErrorStackOverflow() {
+
+	i := runScript(`
+		fun recurse(n) {
+			return recurse(n + 1);
+		}
+		recurse(0);
+	`)
+	fmt.Println(i.HadRuntimeError())
+	// the interpreter is still usable after the overflow.
+	i.Run(`
+		fun count(n) {
+			if (n == 0) return 0;
+			return 1 + count(n - 1);
+		}
+		print count(500);
+	`, false)
+	// Output:
+	// [line 3] Stack overflow.
+	// true
+	// 500
+}
+
+func Example_runtimeErrorMaxCallDepth() {
+
+	i := New(os.Stdout, os.Stdout)
+	i.SetMaxCallDepth(10)
+	i.Run(`
+		fun count(n) {
+			if (n == 0) return 0;
+			return 1 + count(n - 1);
+		}
+		print count(9);
+		print count(10);
+	`, false)
+	// Output:
+	// 9
+	// [line 4] Stack overflow.
+}
+
+func Example_runtimeErrorExecutionBudget() {
+
+	i := New(os.Stdout, os.Stdout)
+	i.SetMaxSteps(100)
+	i.Run(`
+		var n = 0;
+		while (n < 10) n = n + 1;
+		print n;
+	`, false)
+	i.Run(`
+		for (;;) {}
+	`, false)
+	// Output:
+	// 10
+	// [line 2] Execution budget exceeded.
+}
+
+func ExampleInterp_SetColor() {
+
+	b := &strings.Builder{}
+	i := New(b, b)
+	i.SetColor(true)
+	i.Run(`print 1 + nil;`, false)
+	i.Run(`print 1 +;`, false)
+	fmt.Printf("%q", b.String())
+	// Output:
+	// "\x1b[31m[line 1] Operands must be two numbers or at least one string.\x1b[0m\n    \x1b[35mprint\x1b[0m \x1b[36m1\x1b[0m \x1b[4m\x1b[31m+\x1b[0m \x1b[35mnil\x1b[0m;\n\x1b[31m[line 1] Error at ';': Expect expression.\x1b[0m\n    \x1b[35mprint\x1b[0m \x1b[36m1\x1b[0m +\x1b[4m\x1b[31m;\x1b[0m\n"
+}
+
+func ExampleInterp_CompileErrors() {
+
+	var errOut bytes.Buffer
+	i := New(os.Stdout, &errOut)
+	i.Run(`
+		{ var unused = 1; }
+		print ;
+		var a = 1 +;`, false)
+	for _, err := range i.CompileErrors() {
+		fmt.Println(err)
+	}
+	// Output:
+	// [line 3] Error at ';': Expect expression.
+	// [line 4] Error at ';': Expect expression.
+}
+
+func ExampleInterp_SetJSONDiagnostics() {
+
+	i := New(os.Stdout, os.Stdout)
+	i.SetJSONDiagnostics(true)
+	i.SetScriptName("test.lox")
+	i.Run(`print 1 +;`, false)
+	i.Run(`{ var a = 1; }`, false)
+	i.Run(`
+		print "a" < 1;`, false)
+	// Output:
+	// {"file":"test.lox","line":1,"column":10,"code":"parse/expect-expression","severity":"error","message":"Expect expression."}
+	// {"file":"test.lox","line":1,"column":7,"code":"resolve/unused-local","severity":"warning","message":"Local variable 'a' is never used."}
+	// {"file":"test.lox","line":2,"column":13,"code":"runtime/operand-not-a-number","severity":"error","message":"Operand must be a number."}
+}
+
+func ExampleInterp_MemberNames() {
+
+	i := New(os.Stdout, os.Stdout)
+	i.Run(`
+		class Pastry { bake() {} }
+		class Cake < Pastry { slice() {} }
+		var cake = Cake();
+		cake.flavor = "chocolate";
+		fun eat(pastry) {}
+	`, false)
+	fmt.Println(i.GlobalNames())
+	fmt.Println(i.MemberNames())
+	// Output:
+	// [Cake Pastry cake clock debugEnv deepEquals eat isFinite isNaN runtimeStats scopes]
+	// [bake flavor slice]
+}
+
+func ExampleInterp_LastRunFailed() {
+
+	i := New(os.Stdout, os.Stdout)
+	i.Run(`print nil + 1;`, false)
+	fmt.Println(i.LastRunFailed(), i.HadRuntimeError())
+	i.Run(`print 1;`, false)
+	fmt.Println(i.LastRunFailed(), i.HadRuntimeError())
+	// Output:
+	// [line 1] Operands must be two numbers or at least one string.
+	// true true
+	// 1
+	// false true
+}
+
+func ExampleInterp_Define() {
+
+	i := New(os.Stdout, os.Stdout)
+	i.Define("debug", true)
+	i.Define("size", 10.0)
+	i.Define("name", "glox")
+	i.Define("missing", nil)
+	i.Run(`
+		if (debug) print name + " " + size;
+		print missing;
+	`, false)
+	// Output:
+	// glox 10
+	// nil
+}
+
+func ExampleInterp_DefineFields() {
+
+	type user struct {
+		Name  string `lox:"name"`
+		Score int    `lox:"score"`
+		token string
+	}
+	i := New(os.Stdout, os.Stdout)
+	i.DefineFields(map[string]interface{}{
+		"user":   &user{"ada", 42, "secret"},
+		"levels": map[string]float64{"gold": 40},
+	})
+	i.Run(`
+		print user;
+		print user.name + " " + (user.score >= levels.gold);
+	`, false)
+	// Output:
+	// <instance user>
+	// ada true
+}
+
+func ExampleInterp_RegisterNative() {
+
+	for _, backend := range []Backend{TreeWalker, VM} {
+		i := New(os.Stdout, os.Stdout)
+		i.SetBackend(backend)
+		i.RegisterNative("repeat", 2, func(args []interface{}) (interface{}, error) {
+			s, ok := args[0].(string)
+			n, isNumber := args[1].(float64)
+			if !ok || !isNumber {
+				return nil, errors.New("repeat expects a string and a number.")
+			}
+			return strings.Repeat(s, int(n)), nil
+		})
+		i.Run(`
+			print repeat("ab", 3);
+			print repeat;
+			print repeat(1, 2);
+		`, false)
+	}
+	// Output:
+	// ababab
+	// <native fun>
+	// [line 4] repeat expects a string and a number.
+	// ababab
+	// <native fun>
+	// [line 4] repeat expects a string and a number.
+}
+
+func ExampleInterp_Eval() {
+
+	i := New(os.Stdout, os.Stdout)
+	i.Run(`
+		class Point { init(x, y) { this.x = x; this.y = y; } }
+		fun double(n) { return 2 * n; }
+	`, false)
+	fmt.Println(i.Eval("double(21)"))
+	fmt.Println(i.Eval(`"lox" + "!"`))
+	fmt.Println(i.Eval("Point(1, 2)"))
+	fmt.Println(i.Eval("double"))
+	fmt.Println(i.Eval("double(nil)"))
+	fmt.Println(i.Eval("1 +"))
+	// Output:
+	// 42 <nil>
+	// lox! <nil>
+	// map[x:1 y:2] <nil>
+	// <fun double> <nil>
+	// <nil> Operand must be a number.
+	// <nil> Expect expression.
+}
+
+func ExampleInterp_Result() {
+
+	i := New(os.Stdout, os.Stdout)
+	i.Run(`var port = 8000; port + 80;`, false)
+	fmt.Println(i.Result())
+	i.Run(`var a = 1;`, false)
+	fmt.Println(i.Result())
+	i.Run(`nil + 1;`, false)
+	fmt.Println(i.Result())
+	// Output:
+	// 8080 true
+	// <nil> false
+	// [line 1] Operands must be two numbers or at least one string.
+	// <nil> false
+}
+
+func ExampleInterp_TypeOf() {
+
+	for _, backend := range []Backend{TreeWalker, VM} {
+		i := New(os.Stdout, os.Stdout)
+		i.SetBackend(backend)
+		i.Run(`
+			class Point {}
+			fun f() {}
+		`, false)
+		for _, expression := range []string{"nil", "true", "1.5", `"s"`, "f", "clock", "Point", "Point()", "type"} {
+			fmt.Println(i.TypeOf(expression))
+		}
+	}
+	// Output:
+	// nil <nil>
+	// boolean <nil>
+	// number <nil>
+	// string <nil>
+	// function <nil>
+	// function <nil>
+	// class <nil>
+	// Point <nil>
+	//  Undefined variable 'type'.
+	// nil <nil>
+	// boolean <nil>
+	// number <nil>
+	// string <nil>
+	// function <nil>
+	// function <nil>
+	// class <nil>
+	// Point <nil>
+	//  Undefined variable 'type'.
+}
+
+func ExampleInterp_SetBackend() {
+
+	i := New(os.Stdout, os.Stdout)
+	i.SetBackend(VM)
+	i.Run(`
+		class Counter {
+			init() { this.count = 0; }
+			increment() { this.count = this.count + 1; return this; }
+		}
+		var counter = Counter();
+		for (var n = 0; n < 3; n = n + 1) counter.increment();
+		print counter.count;
+		print counter.increment;
+		counter.missing();
+	`, false)
+	// Output:
+	// 3
+	// <fun increment>
+	// [line 10] Undefined field or method 'missing'.
+}
+
+func ExampleInterp_PhaseStats() {
+
+	i := New(os.Stdout, os.Stdout)
+	i.SetPhaseStats(true)
+	i.Run(`
+		var n = 0;
+		while (n < 3) n = n + 1;
+	`, false)
+	stats := i.PhaseStats()
+	fmt.Println(stats.Tokens, stats.Nodes, stats.Statements)
+	// Output:
+	// 18 11 5
+}
+
+func Example_runtimeErrorCancelled() {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	i := New(os.Stdout, os.Stdout)
+	i.SetContext(ctx)
+	i.Run(`print "running";`, false)
+	cancel()
+	i.Run(`
+		print "cancelled";
+	`, false)
+	// Output:
+	// running
+	// [line 2] Execution cancelled.
+}
+
+func Example_runtimeErrorTimeout() {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	i := New(os.Stdout, os.Stdout)
+	i.SetContext(ctx)
+	i.Run(`
+		while (true) {}
+	`, false)
+	// Output:
+	// [line 2] Execution timed out.
+}
+
+func ExampleInterp_RunProgram() {
+
+	compiler := New(os.Stdout, os.Stdout)
+	program, _ := compiler.CompileProgram(`
+		var greeting = "hello " + name;
+		print greeting;
+	`)
+	for _, name := range []string{"ada", "grace"} {
+		i := New(os.Stdout, os.Stdout)
+		i.Define("name", name)
+		i.RunProgram(program)
+	}
+	// Output:
+	// hello ada
+	// hello grace
+}
+
+func ExampleInterp_StackTrace() {
+
+	script := `
+		fun inner(n) {
+			return -n;
+		}
+		fun outer() {
+			print "calling";
+			return inner("a");
+		}
+		outer();
+	`
+	for _, backend := range []Backend{TreeWalker, VM} {
+		i := New(os.Stdout, os.Stdout)
+		i.SetBackend(backend)
+		i.SetStackTraces(true)
+		i.Run(script, false)
+		for _, frame := range i.StackTrace() {
+			fmt.Printf("  at %s (line %d)\n", frame.Function, frame.Line)
+		}
+	}
+	// Output:
+	// calling
+	// [line 3] Operand must be a number.
+	//   at inner (line 3)
+	//   at outer (line 7)
+	//   at script (line 9)
+	// calling
+	// [line 3] Operand must be a number.
+	//   at inner (line 3)
+	//   at outer (line 7)
+	//   at script (line 9)
+}
+
+func Example_runtimeErrorSizeLimits() {
+
+	for _, backend := range []Backend{TreeWalker, VM} {
+		i := New(os.Stdout, os.Stdout)
+		i.SetBackend(backend)
+		i.SetMaxStringLength(8)
+		i.SetMaxFields(2)
+		i.Run(`
+			var s = "abcd";
+			s = s + s;
+			print s;
+			s = s + "!";
+		`, false)
+		i.Run(`
+			class Point {}
+			var p = Point();
+			p.x = 1;
+			p.y = 2;
+			p.x = 3;
+			print p.x;
+			p.z = 4;
+		`, false)
+		// the optimizations don't bypass the limit.
+		i.SetPasses(lang.OptimizationPasses(1)...)
+		i.Run(`
+			print "abcde" + "fghij";
+		`, false)
+	}
+	// Output:
+	// abcdabcd
+	// [line 5] String too long.
+	// 3
+	// [line 8] Too many fields.
+	// [line 2] String too long.
+	// abcdabcd
+	// [line 5] String too long.
+	// 3
+	// [line 8] Too many fields.
+	// [line 2] String too long.
+}
+
+func Example_runtimeErrorMemoryQuota() {
+
+	i := New(os.Stdout, os.Stdout)
+	i.SetMaxMemory(1000)
+	i.Run(`
+		var s = "";
+		for (var n = 0; n < 10; n = n + 1) s = s + "ab";
+		print s;
+	`, false)
+	i.Run(`
+		class Node {
+			init(next) { this.next = next; }
+		}
+		var list;
+		while (true) list = Node(list);
+	`, false)
+	// Output:
+	// abababababababababab
+	// [line 6] Memory quota exceeded.
+}
+
+func Example_runtimeErrorDivisionByZero() {
+
+	i := runScript(`
+		var zero = 0;
+		print 1 / 2;
+		print 1 /
+			zero;
+	`)
+	i.SetAllowDivisionByZero(true)
+	i.Run(`
+		print 1 / zero;
+		print -1 / zero;
+	`, false)
+	// Output:
+	// 0.5
+	// [line 4] Division by zero.
+	// inf
+	// -inf
+}
+
+func Example_runtimeErrorDivisionOperands() {
+
+	// the operands are checked before the divisor.
+	for _, backend := range []Backend{TreeWalker, VM} {
+		i := New(os.Stdout, os.Stdout)
+		i.SetBackend(backend)
+		i.Run(`print "a" / 0;`, false)
+		i.Run(`print nil / 0;`, false)
+		i.Run(`print 1 / 0;`, false)
+	}
+	// Output:
+	// [line 1] Operand must be a number.
+	// [line 1] Operand must be a number.
+	// [line 1] Division by zero.
+	// [line 1] Operand must be a number.
+	// [line 1] Operand must be a number.
+	// [line 1] Division by zero.
+}
+
+func Example_nanAndInfinity() {
+
+	i := New(os.Stdout, os.Stderr)
+	i.SetAllowDivisionByZero(true)
+	i.Run(`
+		var nan = 0 / 0;
+		var inf = 1 / 0;
+		print nan;
+		print nan == nan;
+		print nan != nan;
+		print nan < 1;
+		print nan >= 1;
+		print inf > 1000000;
+		print inf == inf;
+		print isNaN(nan);
+		print isNaN(inf);
+		print isNaN("nan");
+		print isFinite(inf);
+		print isFinite(-inf);
+		print isFinite(nan);
+		print isFinite(42);
+	`, false)
+	// Output:
+	// nan
+	// false
+	// true
+	// false
+	// false
+	// true
+	// true
+	// true
+	// false
+	// false
+	// false
+	// false
+	// false
+	// true
+}
+
+func Example_jloxNumberFormat() {
+
+	i := New(os.Stdout, os.Stderr)
+	i.SetJloxNumberFormat(true)
+	i.SetAllowDivisionByZero(true)
+	i.Run(`
+		print 2;
+		print -2.5;
+		print 1 / 3;
+		print 10000000;
+		print 123456789.5;
+		print 0.0001;
+		print 0.001;
+		print -0;
+		print 0 / 0;
+		print -1 / 0;
+		print "n=" + 3;
+	`, false)
+	// Output:
+	// 2
+	// -2.5
+	// 0.3333333333333333
+	// 1.0E7
+	// 1.234567895E8
+	// 1.0E-4
+	// 0.001
+	// -0
+	// NaN
+	// -Infinity
+	// n=3
+}
+
+func Example_printFields() {
+
+	i := New(os.Stdout, os.Stderr)
+	i.SetPrintFields(true)
+	i.Run(`
+		class Empty {}
+		class Cake {}
+		var cake = Cake();
+		cake.slices = 8;
+		cake.flavor = "apple";
+		cake.box = Empty();
+		cake.self = cake;
+		print Empty();
+		print cake;
+		print "cake: " + cake;
+	`, false)
+	// Output:
+	// Empty{}
+	// Cake{box: Empty{}, flavor: "apple", self: Cake{...}, slices: 8}
+	// cake: Cake{box: Empty{}, flavor: "apple", self: Cake{...}, slices: 8}
+}
+
+func Example_deepEquals() {
+
+	runScript(`
+		class Point {
+			init(x, y) {
+				this.x = x;
+				this.y = y;
+			}
+		}
+		class Other {
+			init(x, y) {
+				this.x = x;
+				this.y = y;
+			}
+		}
+		var a = Point(1, Point(2, "z"));
+		var b = Point(1, Point(2, "z"));
+		print a == b;
+		print deepEquals(a, b);
+		print deepEquals(a, Point(1, Point(2, "w")));
+		print deepEquals(a, Other(1, Point(2, "z")));
+		b.extra = true;
+		print deepEquals(a, b);
+		a.self = a;
+		var c = Point(1, a.y);
+		c.self = c;
+		print deepEquals(a, c);
+		print deepEquals(1, 1);
+		print deepEquals("a", a);
+	`)
+	// Output:
+	// false
+	// true
+	// false
+	// false
+	// false
+	// true
+	// true
+	// false
+}
+
+func Example_functionEquality() {
+
+	for _, backend := range []Backend{TreeWalker, VM} {
+		i := New(os.Stdout, os.Stdout)
+		i.SetBackend(backend)
+		i.Run(`
+			class Button {
+				click() { return this; }
+				hover() { return this; }
+			}
+			class Toggle < Button {
+				click() { return super.click; }
+			}
+			var a = Button();
+			var b = Button();
+			var f = a.click;
+			print f == a.click;
+			print a.click == b.click;
+			print a.click == a.hover;
+			var t = Toggle();
+			print t.click() == t.click();
+			print t.click() == t.click;
+			print Button == Button;
+			fun make() { fun g() {} return g; }
+			print make() == make();
+			var g = make();
+			print g == g;
+		`, false)
+	}
+	// Output:
+	// true
+	// false
+	// false
+	// true
+	// false
+	// true
+	// false
+	// true
+	// true
+	// false
+	// false
+	// true
+	// false
+	// true
+	// false
+	// true
+}
+
+func Example_scopes() {
+
+	runScript(`
+		print scopes();
+		fun f() {
+			{
+				print scopes();
+			}
+		}
+		f();
+	`)
+	// Output:
+	// 0
+	// 2
+}
+
+func Example_debugEnv() {
+
+	runScript(`
+		var total = 1;
+		fun makeCounter(step) {
+			var count = 0;
+			fun next() {
+				count = count + step;
+				var label = "next";
+				print debugEnv();
+				return label;
+			}
+			return next;
+		}
+		makeCounter(2)();
+	`)
+	// Output:
+	// 0) label="next"
+	// captured) count=2
+	// captured) step=2
+	// 1) makeCounter=<fun makeCounter>
+	// 1) total=1
+}
+
+func Example_runtimeErrorDebugFunctionsOnVM() {
+
+	i := New(os.Stdout, os.Stdout)
+	i.SetBackend(VM)
+	i.Run(`
+		print scopes();
+	`, false)
+	i.Run(`
+		fun f() { print debugEnv(); }
+		f();
+	`, false)
+	// Output:
+	// [line 2] scopes() is not supported by the vm backend.
+	// [line 2] debugEnv() is not supported by the vm backend.
+}
+
+func Example_runtimeStats() {
+
+	for _, backend := range []Backend{TreeWalker, VM} {
+		i := New(os.Stdout, os.Stdout)
+		i.SetBackend(backend)
+		i.Run(`
+			class Point { init(x) { this.x = x; } }
+			fun f(n) { return Point(n); }
+			for (var n = 0; n < 3; n = n + 1) f(n);
+			var stats = runtimeStats();
+			print stats.calls;
+			print stats.instances;
+			print stats.elapsed >= 0;
+			print stats.statements;
+		`, false)
+		stats := i.RuntimeStats()
+		fmt.Println(stats.Calls, stats.Instances, stats.Statements, stats.Depth)
+	}
+	// Output:
+	// 7
+	// 3
+	// true
+	// 17
+	// 7 3 21 0
+	// 7
+	// 3
+	// true
+	// [line 9] Undefined field or method 'statements'.
+	// 7 3 -1 -1
+}
+
+func Example_runtimeErrorReportedToErrOut() {
+
+	errOut := &strings.Builder{}
+	i := New(os.Stdout, errOut)
+	i.Run(`
+		print "before";
+		print -"oops";
+	`, false)
+	rte := i.RuntimeError()
+	fmt.Println(rte.Line(), rte.Token.Lexeme, rte.Message)
+	fmt.Print(errOut.String())
+	i.Run(`print "after";`, false)
+	fmt.Println(i.RuntimeError() == nil)
+	// Output:
+	// before
+	// 3 - Operand must be a number.
+	// [line 3] Operand must be a number.
+	// after
+	// true
+}
+
+func Example_runtimeErrorUsedBeforeAssignment() {
+
+	i := New(os.Stdout, os.Stdout)
+	i.SetStrictInitialization(true)
+	i.Run(`
+		var total;
+		total = 1;
+		print total;
+		fun f() {
+			var count;
+			fun inner() { return count; }
+			return inner();
+		}
+		f();
+	`, false)
+	// Output:
+	// 1
+	// [line 7] Variable 'count' used before assignment.
+}
+
+func Example_runtimeErrorNoStringCoercion() {
+
+	i := New(os.Stdout, os.Stdout)
+	i.SetStringCoercion(false)
+	i.Run(`
+		print "con" + "cat";
+		print 1 + 2;
+		print "score: " + 10;
+	`, false)
+	// Output:
+	// concat
+	// 3
+	// [line 4] Operands must be two numbers or two strings.
+}
+
+func Example_runtimeErrorBadCall() {
+
+	i := runScript(`
+		var m = "hello";
+		print m(2);
+	`)
+	fmt.Println(i.HadCompileError())
+	fmt.Println(i.HadRuntimeError())
+	// Output:
+	// [line 3] Can only call functions and classes.
+	// false
+	// true
+}
+
+func Example_runtimeErrorBadFieldLookup() {
+
+	i := runScript(`
+		var m = "hello";
+		print m.name;
+	`)
+	fmt.Println(i.HadCompileError())
+	fmt.Println(i.HadRuntimeError())
+	// Output:
+	// [line 3] Only class instances have fields.
+	// false
+	// true
+}
+
+func Example_runtimeErrorBadFieldSet() {
+
+	i := runScript(`
+		var m = "hello";
+		m.name = "Bob";
+	`)
+	fmt.Println(i.HadCompileError())
+	fmt.Println(i.HadRuntimeError())
+	// Output:
+	// [line 3] Only class instances have fields.
+	// false
+	// true
+}
+
+func Example_runtimeErrorBadOperandNumber() {
+
+	i := runScript(`print (1 < "a");`)
+	fmt.Println(i.HadCompileError())
+	fmt.Println(i.HadRuntimeError())
+	// Output:
+	// [line 1] Operand must be a number.
+	// false
+	// true
+
+}
+
+func Example_runtimeError_BadPlusOperands() {
+
+	i := runScript(`true + 1;`)
+	fmt.Println(i.HadCompileError())
+	fmt.Println(i.HadRuntimeError())
+	// Output:
+	// [line 1] Operands must be two numbers or at least one string.
+	// false
+	// true
+}
+func Example_runtimeErrorSuperclassNotAClass() {
+
+	i := runScript(`
+	var Cake = "Not a Class";
+	class Eclair < Cake {}
+	`)
+	fmt.Println(i.HadCompileError())
+	fmt.Println(i.HadRuntimeError())
+	// Output:
+	// [line 3] Superclass must be a class.
+	// false
+	// true
+
+}
+
+func Example_runtimeErrorUndefinedAssignment() {
+
+	i := runScript(`a = 123;`)
+	fmt.Println(i.HadCompileError())
+	fmt.Println(i.HadRuntimeError())
+	// Output:
+	// [line 1] Undefined variable 'a'.
+	// false
+	// true
+}
+
+func Example_runtimeErrorUndefinedAssignmentSuggestion() {
+
+	i := runScript(`
+		fun count() {
+			var counter = 0;
+			coutner = counter + 1;
+		}
+		count();
+	`)
+	fmt.Println(i.HadRuntimeError())
+	// Output:
+	// [line 4] Undefined variable 'coutner'. Did you mean 'counter'?
+	// true
+}
+
+func Example_runtimeErrorUndefinedField() {
+
+	i := runScript(`
+		class Bar {}
+		print Bar().name;	
+	`)
+	fmt.Println(i.HadCompileError())
+	fmt.Println(i.HadRuntimeError())
+	// Output:
+	// [line 3] Undefined field or method 'name'.
+	// false
+	// true
+}
+
+func Example_runtimeErrorUndefinedMethod() {
+
+	i := runScript(`
+		class Bar {}
+		print Bar().name();	
+	`)
+	fmt.Println(i.HadCompileError())
+	fmt.Println(i.HadRuntimeError())
+	// Output:
+	// [line 3] Undefined field or method 'name'.
+	// false
+	// true
+}
+
+func Example_runtimeErrorUndefinedMethodSuggestion() {
+
+	i := runScript(`
+		class Counter {
+			increment() {}
+		}
+		var c = Counter();
+		c.count = 0;
+		c.incremnet();
+	`)
+	fmt.Println(i.HadRuntimeError())
+	// Output:
+	// [line 7] Undefined field or method 'incremnet'. Did you mean 'increment'?
+	// true
+}
+
+func Example_runtimeErrorUndefinedFieldSuggestion() {
+
+	i := runScript(`
+		class Counter {
+			increment() {}
+		}
+		var c = Counter();
+		c.count = 0;
+		print c.cuont;
+	`)
+	fmt.Println(i.HadRuntimeError())
+	// Output:
+	// [line 7] Undefined field or method 'cuont'. Did you mean 'count'?
+	// true
+}
+
+func Example_runtimeErrorUndefinedSuperMethodSuggestion() {
+
+	i := runScript(`
+		class Cake {
+			bake() {}
+		}
+		class Pie < Cake {
+			bake() { super.bkae(); }
+		}
+		Pie().bake();
+	`)
+	fmt.Println(i.HadRuntimeError())
+	// Output:
+	// [line 6] Undefined method 'bkae'. Did you mean 'bake'?
+	// true
+}
+
+func Example_runtimeErrorUndefinedVariable() {
+
+	i := runScript(`print a;`)
+	fmt.Println(i.HadCompileError())
+	fmt.Println(i.HadRuntimeError())
+	// Output:
+	// [line 1] Undefined variable 'a'.
+	// false
+	// true
+}
+
+func Example_runtimeErrorUndefinedVariableSuggestion() {
+
+	i := runScript(`
+		var counter = 0;
+		print coutner;
+	`)
+	fmt.Println(i.HadRuntimeError())
+	// Output:
+	// [line 3] Undefined variable 'coutner'. Did you mean 'counter'?
+	// true
+}
+
+// ------------------
+// Helper Functions
+// ------------------
 
 func runScript(script string) *Interp {
 
@@ -1016,3 +2644,91 @@ func runScript(script string) *Interp {
 	interp.Run(script, false)
 	return interp
 }
+
+func ExampleInterp_RunCompiled() {
+
+	compiler := New(os.Stdout, os.Stdout)
+	function, _ := compiler.Compile(`
+		fun greet(name) { return "Hello, " + name + "!"; }
+		print greet("compiled");
+		greet();
+	`)
+
+	var saved bytes.Buffer
+	bytecode.Save(&saved, function)
+	loaded, _ := bytecode.Load(&saved)
+
+	New(os.Stdout, os.Stdout).RunCompiled(loaded)
+	// Output:
+	// Hello, compiled!
+	// [line 4] Expected 1 arguments but got 0.
+}
+
+func ExampleInterp_CompileOptions() {
+
+	lox := New(os.Stdout, os.Stdout)
+	fmt.Println(lox.CompileOptions())
+	lox.SetStrictGlobals(true)
+	lox.SetWarningMode(WarningsAsErrors)
+	lox.SetPasses(lang.OptimizationPasses(1)...)
+	fmt.Println(lox.CompileOptions())
+	lox.SetMaxStringLength(100)
+	fmt.Println(lox.CompileOptions())
+	// Output:
+	// warnings=0
+	// warnings=2 strict lang.ConstantFolding lang.BranchElimination lang.DeadCodeElimination
+	// warnings=2 strict lang.ConstantFolding maxStringLength=100 lang.BranchElimination lang.DeadCodeElimination
+}
+
+func ExampleInterp_Resolve() {
+
+	i := New(os.Stdout, os.Stdout)
+	statements, _ := i.Resolve(`
+		var global = 1;
+		{
+			var local = global;
+			print local;
+		}
+	`)
+	block := statements[1].(*lang.BlockStmt)
+	print := block.Statements[1].(*lang.PrintStmt)
+	fmt.Println(print.Expression.(*lang.VarExpr).Binding.Local)
+
+	i.Resolve("return 1;")
+	// Output:
+	// true
+	// [line 1] Error at 'return': Can't return from top-level code.
+}
+
+func ExampleInterp_SetHook() {
+
+	i := New(os.Stdout, os.Stdout)
+	i.SetHook(func(stmt lang.Stmt) {
+		if _, ok := stmt.(*lang.ReturnStmt); !ok {
+			return
+		}
+		for _, frame := range i.Frames() {
+			fmt.Printf("%s (line %d):", frame.Function, frame.Token.Line)
+			for _, v := range frame.Locals() {
+				fmt.Printf(" %s=%s", v.Name, v.Value)
+			}
+			fmt.Println()
+		}
+	})
+	i.Run(`
+		class Box {}
+		fun wrap(value) {
+			var box = Box();
+			box.value = value;
+			return box;
+		}
+		{
+			var label = "answer";
+			print label + ": " + wrap(42).value;
+		}
+	`, false)
+	// Output:
+	// wrap (line 6): box=<instance Box> value=42
+	// script (line 10): label="answer"
+	// answer: 42
+}
@@ -1,18 +1,27 @@
 package interp
 
-import "time"
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
 
 // lox interpreter built-in functions.
 // Each function must implement the loxCallable interface
 // (call(), arity()) and the Stringer interface.
 
 // clock represents the built in clock function.
-// clock returns the unix time in seconds.
+// clock returns the unix time in seconds, from the clock set
+// with SetClock if any.
 type clock struct{}
 
 // call implements a call to the clock() function.
-func (c clock) call(i *Interp, args []interface{}) interface{} {
-	return float64(time.Now().Unix())
+func (c clock) call(i *Interp, args []loxValue) loxValue {
+	if i.now != nil {
+		return numberValue(float64(i.now().Unix()))
+	}
+	return numberValue(float64(time.Now().Unix()))
 }
 
 // arity returns the arity of the clock() function.
@@ -24,3 +33,236 @@ func (c clock) arity() int {
 func (c clock) String() string {
 	return "<native fun>"
 }
+
+// isNaN represents the built in isNaN function.
+// isNaN(x) returns true if x is the NaN (not a number) value,
+// for example the result of 0/0 when division by zero is allowed.
+type isNaN struct{}
+
+// call implements a call to the isNaN() function.
+func (f isNaN) call(i *Interp, args []loxValue) loxValue {
+	n := args[0]
+	return boolValue(n.isNumber() && math.IsNaN(n.num))
+}
+
+// arity returns the arity of the isNaN() function.
+func (f isNaN) arity() int {
+	return 1
+}
+
+// string provides a printable representation of the isNaN() function.
+func (f isNaN) String() string {
+	return "<native fun>"
+}
+
+// isFinite represents the built in isFinite function.
+// isFinite(x) returns true if x is a number which is
+// neither infinite nor NaN.
+type isFinite struct{}
+
+// call implements a call to the isFinite() function.
+func (f isFinite) call(i *Interp, args []loxValue) loxValue {
+	n := args[0]
+	return boolValue(n.isNumber() && !math.IsNaN(n.num) && !math.IsInf(n.num, 0))
+}
+
+// arity returns the arity of the isFinite() function.
+func (f isFinite) arity() int {
+	return 1
+}
+
+// string provides a printable representation of the isFinite() function.
+func (f isFinite) String() string {
+	return "<native fun>"
+}
+
+// deepEquals represents the built in deepEquals function.
+// deepEquals(a, b) compares instances field by field (recursively)
+// while "==" only checks they are the same instance. Other values
+// are compared like with "==".
+type deepEquals struct{}
+
+// call implements a call to the deepEquals() function.
+func (f deepEquals) call(i *Interp, args []loxValue) loxValue {
+	return boolValue(isDeepEqual(args[0], args[1], make(map[[2]*loxInstance]bool)))
+}
+
+// arity returns the arity of the deepEquals() function.
+func (f deepEquals) arity() int {
+	return 2
+}
+
+// string provides a printable representation of the deepEquals() function.
+func (f deepEquals) String() string {
+	return "<native fun>"
+}
+
+// typeName returns the name of the type of a lox value: "nil",
+// "boolean", "number", "string", "function", "class" or, for an
+// instance, the name of its class.
+func typeName(v loxValue) string {
+	switch v.kind {
+	case nilKind:
+		return "nil"
+	case boolKind:
+		return "boolean"
+	case numberKind:
+		return "number"
+	case stringKind:
+		return "string"
+	}
+	if instance, ok := v.asInstance(); ok {
+		return instance.class.Name
+	}
+	if _, ok := v.asClass(); ok {
+		return "class"
+	}
+	return "function"
+}
+
+// scopes represents the built in scopes function.
+// scopes() returns the number of scopes enclosing the call, 0 at the
+// top level of the script. The virtual machine doesn't keep the
+// scopes, the call is a runtime error.
+type scopes struct{}
+
+// call implements a call to the scopes() function.
+func (f scopes) call(i *Interp, args []loxValue) loxValue {
+	if i.backend == VM {
+		panic(nativeError{"scopes() is not supported by the vm backend."})
+	}
+	return numberValue(float64(i.env.depth()))
+}
+
+// arity returns the arity of the scopes() function.
+func (f scopes) arity() int {
+	return 0
+}
+
+// string provides a printable representation of the scopes() function.
+func (f scopes) String() string {
+	return "<native fun>"
+}
+
+// debugEnv represents the built in debugEnv function.
+// debugEnv() returns the variables visible from the call, one per
+// line, like env.dump: the local variables as "distance) name=value",
+// the innermost scope first, then the variables captured by the
+// function as "captured) name=value" and the globals, the built-in
+// functions excepted. The strings are quoted. The virtual machine
+// doesn't keep the names of the local variables, the call is a
+// runtime error.
+type debugEnv struct{}
+
+// call implements a call to the debugEnv() function.
+func (f debugEnv) call(i *Interp, args []loxValue) loxValue {
+	if i.backend == VM {
+		panic(nativeError{"debugEnv() is not supported by the vm backend."})
+	}
+	b := strings.Builder{}
+	distance := 0
+	for e := i.env; e != nil && e != i.globalEnv; e = e.enclosing {
+		for slot, name := range e.slotNames {
+			fmt.Fprintf(&b, "%d) %s=%s\n", distance, name, i.variable(name, e.slots[slot]).Value)
+		}
+		distance++
+	}
+	for _, u := range i.upvalues {
+		name := u.env.slotNames[u.slot]
+		fmt.Fprintf(&b, "captured) %s=%s\n", name, i.variable(name, u.env.slots[u.slot]).Value)
+	}
+	for _, global := range i.Globals() {
+		if !isBuiltin(global.value) {
+			fmt.Fprintf(&b, "%d) %s=%s\n", distance, global.Name, global.Value)
+		}
+	}
+	return stringValue(b.String())
+}
+
+// arity returns the arity of the debugEnv() function.
+func (f debugEnv) arity() int {
+	return 0
+}
+
+// string provides a printable representation of the debugEnv() function.
+func (f debugEnv) String() string {
+	return "<native fun>"
+}
+
+// runtimeStats represents the built in runtimeStats function.
+// runtimeStats() returns an instance of RuntimeStats with the
+// counters of the run (see Interp.RuntimeStats): the fields
+// statements, calls, instances, depth and elapsed (in seconds).
+// The virtual machine doesn't count the statements and the depth,
+// their fields are missing.
+type runtimeStats struct{}
+
+// call implements a call to the runtimeStats() function.
+func (f runtimeStats) call(i *Interp, args []loxValue) loxValue {
+	stats := i.RuntimeStats()
+	if i.statsClass == nil {
+		i.statsClass = newLoxClass("RuntimeStats", nil, nil)
+	}
+	instance := newLoxInstance(i.statsClass)
+	if i.backend != VM {
+		instance.fields["statements"] = numberValue(float64(stats.Statements))
+		instance.fields["depth"] = numberValue(float64(stats.Depth))
+	}
+	instance.fields["calls"] = numberValue(float64(stats.Calls))
+	instance.fields["instances"] = numberValue(float64(stats.Instances))
+	instance.fields["elapsed"] = numberValue(stats.Elapsed.Seconds())
+	return objectValue(instance)
+}
+
+// arity returns the arity of the runtimeStats() function.
+func (f runtimeStats) arity() int {
+	return 0
+}
+
+// string provides a printable representation of the runtimeStats() function.
+func (f runtimeStats) String() string {
+	return "<native fun>"
+}
+
+// isBuiltin checks if a value is a built-in function
+// or a native registered by the embedder.
+func isBuiltin(v loxValue) bool {
+
+	if v.kind != objectKind {
+		return false
+	}
+	switch v.obj.(type) {
+	case clock, isNaN, isFinite, deepEquals, scopes, debugEnv, runtimeStats, *goNative:
+		return true
+	}
+	return false
+}
+
+// isDeepEqual checks if two lox values are structurally equal.
+// compared holds the pairs of instances already being compared,
+// they are assumed equal so cyclic structures terminate.
+func isDeepEqual(left, right loxValue, compared map[[2]*loxInstance]bool) bool {
+
+	l, ok := left.asInstance()
+	if !ok {
+		return isEqual(left, right)
+	}
+	r, ok := right.asInstance()
+	if !ok {
+		return false
+	}
+	if l == r || compared[[2]*loxInstance{l, r}] {
+		return true
+	}
+	if l.class != r.class || len(l.fields) != len(r.fields) {
+		return false
+	}
+	compared[[2]*loxInstance{l, r}] = true
+	for name, value := range l.fields {
+		other, ok := r.fields[name]
+		if !ok || !isDeepEqual(value, other, compared) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,84 @@
+package interp
+
+import (
+	"errors"
+	"io/ioutil"
+
+	"github.com/rmonnet/glox/lang"
+)
+
+// Minify returns a lox script as compact single-line source code,
+// without comments and whitespace (see lang.Minify). With
+// shortenLocals, the local variables and parameters are renamed to
+// the shortest names not used anywhere in the script, using the
+// scopes found by the resolver. The globals, the functions and the
+// classes keep their name since it can be seen from outside the
+// script or printed.
+// An error is returned if the script has compile errors.
+func Minify(source string, shortenLocals bool) (string, error) {
+
+	scanner := &lang.Scanner{}
+	scanner.RedirectErrors(ioutil.Discard)
+	tokens := scanner.ScanTokens(source)
+
+	parser := &lang.Parser{}
+	parser.RedirectErrors(ioutil.Discard)
+	statements := parser.Parse(tokens)
+
+	diagnostics := append(scanner.Diagnostics(), parser.Diagnostics()...)
+	if len(diagnostics) > 0 {
+		return "", errors.New(diagnostics[0].String())
+	}
+	if !shortenLocals {
+		return lang.Minify(tokens, nil), nil
+	}
+
+	resolver := NewResolver(New(ioutil.Discard, ioutil.Discard))
+	resolver.RedirectErrors(ioutil.Discard)
+	resolver.SetRecordSymbols(true)
+	resolver.Resolve(statements)
+	for _, d := range resolver.Diagnostics() {
+		if d.Severity == lang.ErrorSeverity {
+			return "", errors.New(d.String())
+		}
+	}
+
+	// the new names can't be any identifier of the script, so
+	// they can't capture a global, a native or a property.
+	used := make(map[string]bool)
+	for _, token := range tokens {
+		if token.Type == lang.IdentifierToken {
+			used[token.Lexeme] = true
+		}
+	}
+	names := make(map[*lang.Token]string)
+	next := 0
+	for _, symbol := range resolver.Symbols() {
+		if symbol.Depth == 0 ||
+			(symbol.Kind != VariableSymbol && symbol.Kind != ParameterSymbol) {
+			continue
+		}
+		name := shortName(next)
+		for next++; used[name] || lang.IsKeyword(name); next++ {
+			name = shortName(next)
+		}
+		names[symbol.Definition] = name
+		for _, ref := range symbol.References {
+			names[ref] = name
+		}
+	}
+	return lang.Minify(tokens, names), nil
+}
+
+// shortName returns the nth name of the sequence a, b, ..., z,
+// A, ..., Z, aa, ab...
+func shortName(n int) string {
+
+	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	name := string(letters[n%len(letters)])
+	for n /= len(letters); n > 0; n /= len(letters) {
+		n--
+		name = string(letters[n%len(letters)]) + name
+	}
+	return name
+}
@@ -0,0 +1,69 @@
+package interp
+
+import "github.com/rmonnet/glox/lang"
+
+// NativeFunc is a native function written in Go, see RegisterNative.
+// The arguments are converted to Go like the result of Eval and the
+// result is converted to lox like the values of Define. An error
+// stops the script with a runtime error reported at the call.
+type NativeFunc func(args []interface{}) (interface{}, error)
+
+// RegisterNative defines a global native function taking arity
+// arguments, so the embedders and the extensions (see "glox -ext")
+// can add their own libraries. A native registered with the name of
+// another global replaces it.
+func (i *Interp) RegisterNative(name string, arity int, fn NativeFunc) {
+
+	i.globalEnv.define(name, objectValue(&goNative{fn, arity}))
+}
+
+// goNative is a native function registered with RegisterNative.
+type goNative struct {
+	fn     NativeFunc
+	params int
+}
+
+// nativeError is the error of a native function, panicked by call
+// and reported as a runtime error at the call by callNative.
+type nativeError struct {
+	message string
+}
+
+// call calls the Go function with the converted arguments.
+func (n *goNative) call(i *Interp, args []loxValue) loxValue {
+
+	goArgs := make([]interface{}, len(args))
+	for a, arg := range args {
+		goArgs[a] = i.goValue(arg)
+	}
+	result, err := n.fn(goArgs)
+	if err != nil {
+		panic(nativeError{err.Error()})
+	}
+	return toLox(result)
+}
+
+// arity returns the number of arguments of the native.
+func (n *goNative) arity() int {
+	return n.params
+}
+
+// String provides a printable representation of the native.
+func (n *goNative) String() string {
+	return "<native fun>"
+}
+
+// callNative calls a native function, the error of a Go function
+// is reported at the parenthesis of the call.
+func (i *Interp) callNative(function loxCallable, args []loxValue, paren *lang.Token) loxValue {
+
+	defer func() {
+		if e := recover(); e != nil {
+			if err, ok := e.(nativeError); ok {
+				panic(RuntimeError{paren, CodeNativeError, err.message})
+			}
+			panic(e)
+		}
+	}()
+	return function.call(i, args)
+}
@@ -0,0 +1,104 @@
+package interp
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// ProfileEntry reports the number of calls and the cumulative time
+// spent in a lox function (including the functions it calls).
+type ProfileEntry struct {
+	Name  string
+	Line  int
+	Calls int
+	Time  time.Duration
+}
+
+// profileRecord accumulates the profile of a function.
+// active counts the calls in progress so the time of recursive
+// calls is only measured once, by the outermost call.
+type profileRecord struct {
+	entry  ProfileEntry
+	active int
+}
+
+// SetProfiling enables the profiler, which records the number
+// of calls and the time spent in each lox function.
+// The profiler is disabled by default.
+func (i *Interp) SetProfiling(enabled bool) {
+
+	i.profiling = enabled
+	if enabled && i.profile == nil {
+		i.profile = make(map[interface{}]*profileRecord)
+	}
+}
+
+// Profile returns the profile recorded since the profiler was
+// enabled, the functions taking the most time first.
+func (i *Interp) Profile() []ProfileEntry {
+
+	var entries []ProfileEntry
+	for _, record := range i.profile {
+		entries = append(entries, record.entry)
+	}
+	sort.Slice(entries, func(a, b int) bool {
+		if entries[a].Time != entries[b].Time {
+			return entries[a].Time > entries[b].Time
+		}
+		return entries[a].Line < entries[b].Line
+	})
+	return entries
+}
+
+// WriteProfile writes the profile as a table to out.
+// Nothing is written if the profiler is disabled.
+func (i *Interp) WriteProfile(out io.Writer) {
+
+	if !i.profiling {
+		return
+	}
+	fmt.Fprintf(out, "%10s %14s  %s\n", "calls", "time", "function")
+	for _, entry := range i.Profile() {
+		fmt.Fprintf(out, "%10d %14s  %s (line %d)\n",
+			entry.Calls, entry.Time, entry.Name, entry.Line)
+	}
+}
+
+// profileCall calls the function, recording its profile.
+func (i *Interp) profileCall(f *loxFunction, args []loxValue) loxValue {
+
+	record := i.profileRecord(f.decl, f.decl.Name.Lexeme, f.decl.Name.Line)
+
+	record.entry.Calls++
+	record.active++
+	start := time.Now()
+	result := f.call(i, args)
+	record.active--
+	if record.active == 0 {
+		record.entry.Time += time.Since(start)
+	}
+	return result
+}
+
+// profileRecord returns the profile of a function, identified by
+// its declaration (or its bytecode for the virtual machine).
+func (i *Interp) profileRecord(key interface{}, name string, line int) *profileRecord {
+
+	record, ok := i.profile[key]
+	if !ok {
+		record = &profileRecord{entry: ProfileEntry{Name: name, Line: line}}
+		i.profile[key] = record
+	}
+	return record
+}
+
+// resetProfile forgets the calls in progress when a runtime
+// error unwinds them.
+func (i *Interp) resetProfile() {
+
+	for _, record := range i.profile {
+		record.active = 0
+	}
+}
@@ -0,0 +1,102 @@
+package interp
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rmonnet/glox/bytecode"
+	"github.com/rmonnet/glox/lang"
+)
+
+// Program is a script scanned, parsed and resolved once, which any
+// number of interpreters can run, even at the same time: a server
+// compiles its scripts at startup and runs them in a pool of
+// interpreters. The AST of a program is never modified once it is
+// compiled (the caches of the interpreters excepted, which are safe
+// for concurrent use), the state of a run (the variables, the
+// instances, the calls) belongs to the interpreter running it.
+type Program struct {
+	statements []lang.Stmt
+	// the bytecode is compiled by the first run on the virtual
+	// machine, then shared by the next ones.
+	compileOnce sync.Once
+	function    *bytecode.Function
+	diagnostics []lang.Diagnostic
+}
+
+// CompileProgram scans, parses and resolves a script, with the
+// settings of the interpreter (the warnings, the optimization
+// passes...), into a program run with RunProgram. The errors are
+// reported like in Run and the result is false if there were any.
+// The programs don't depend on the interpreter compiling them.
+func (i *Interp) CompileProgram(script string) (*Program, bool) {
+
+	statements, ok := i.Resolve(script)
+	if !ok {
+		return nil, false
+	}
+	return &Program{statements: statements}, true
+}
+
+// RunProgram runs a program compiled by CompileProgram, possibly
+// with another interpreter, on the backend of the interpreter.
+// The globals defined by the program are defined in the interpreter
+// only, and the runtime errors are reported like in Run.
+func (i *Interp) RunProgram(program *Program) {
+
+	i.runtimeError = nil
+	i.trace = nil
+	i.lastRunFailed = false
+	i.compileErrors = nil
+
+	if i.backend == VM {
+		function, ok := i.compileProgram(program)
+		if !ok {
+			return
+		}
+		i.runFunction(function)
+		return
+	}
+
+	start := time.Now()
+	i.interpret(program.statements)
+	if i.stats != nil {
+		i.stats.Executing += time.Since(start)
+	}
+}
+
+// compileProgram returns the bytecode of a program, reporting the
+// errors like compile.
+func (i *Interp) compileProgram(program *Program) (*bytecode.Function, bool) {
+
+	program.compileOnce.Do(func() {
+		program.function, program.diagnostics = bytecode.Compile(program.statements)
+		if program.function != nil {
+			// the constants are converted before the bytecode is
+			// shared, the virtual machines only read them.
+			convertConstants(program.function)
+		}
+	})
+	if program.function == nil {
+		for _, diagnostic := range program.diagnostics {
+			fmt.Fprintln(i.diagnosticOut(), i.formatter.Format(diagnostic))
+		}
+		i.hadCompileError = true
+		i.lastRunFailed = true
+		return nil, false
+	}
+	return program.function, true
+}
+
+// convertConstants converts the constants of a function and
+// of the functions it declares, like newClosure does lazily.
+func convertConstants(function *bytecode.Function) {
+
+	constants(function)
+	for _, constant := range function.Chunk.Constants {
+		if nested, ok := constant.(*bytecode.Function); ok {
+			convertConstants(nested)
+		}
+	}
+}
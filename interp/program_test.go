@@ -0,0 +1,76 @@
+package interp
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"testing"
+)
+
+// programScript exercises the caches of the AST (the method calls)
+// and of the bytecode (the constants of the nested functions).
+const programScript = `
+	class Counter {
+		init(start) { this.count = start; }
+		next() { this.count = this.count + 1; return this.count; }
+	}
+	fun run(start) {
+		var counter = Counter(start);
+		for (var n = 0; n < 100; n = n + 1) counter.next();
+		return "count " + counter.next();
+	}
+	print run(start);
+`
+
+func TestRunProgramConcurrently(t *testing.T) {
+
+	compiler := New(ioutil.Discard, ioutil.Discard)
+	program, ok := compiler.CompileProgram(programScript)
+	if !ok {
+		t.Fatal("the program doesn't compile")
+	}
+
+	var wg sync.WaitGroup
+	for n := 0; n < 8; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			var out bytes.Buffer
+			i := New(&out, &out)
+			if n%2 == 1 {
+				i.SetBackend(VM)
+			}
+			i.Define("start", float64(n))
+			for run := 0; run < 10; run++ {
+				out.Reset()
+				i.RunProgram(program)
+				if want := fmt.Sprintf("count %d\n", n+101); out.String() != want {
+					t.Errorf("interpreter %d: expected %q, got %q", n, want, out.String())
+					return
+				}
+			}
+		}(n)
+	}
+	wg.Wait()
+}
+
+func TestRunProgramErrors(t *testing.T) {
+
+	var out bytes.Buffer
+	i := New(&out, &out)
+	if _, ok := i.CompileProgram("print ;"); ok || !i.HadCompileError() {
+		t.Error("expected a compile error")
+	}
+
+	program, _ := New(ioutil.Discard, ioutil.Discard).CompileProgram(`print "a"; print -"b";`)
+	for _, backend := range []Backend{TreeWalker, VM} {
+		out.Reset()
+		i := New(&out, &out)
+		i.SetBackend(backend)
+		i.RunProgram(program)
+		if want := "a\n[line 1] Operand must be a number.\n"; out.String() != want || !i.LastRunFailed() {
+			t.Errorf("backend %d: expected %q, got %q", backend, want, out.String())
+		}
+	}
+}
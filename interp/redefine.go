@@ -0,0 +1,186 @@
+package interp
+
+import "github.com/rmonnet/glox/bytecode"
+
+// SetHotRedefinition enables updating in place the functions and the
+// classes declared again at the top level, instead of binding their
+// name to a new function or class: the variables, the callbacks and
+// the instances referencing the previous declaration use the new one
+// (the instances keep their fields and get the new methods). The
+// methods bound before and the subclasses also run the new methods.
+// It is meant for the REPL, where a function can be fixed without
+// building the state again. Only a function or a class declared
+// with the same name is updated, a variable referencing another
+// function or class is bound to the new declaration.
+// The declarations only shadow the previous ones by default.
+func (i *Interp) SetHotRedefinition(enabled bool) {
+
+	i.hotRedefinition = enabled
+}
+
+// redefineFunction updates the function previously declared with the
+// name, if any, to the function declared again and returns it. It
+// returns function if there is no previous function to update.
+func (i *Interp) redefineFunction(name string, function *loxFunction) *loxFunction {
+
+	previous, ok := i.globalEnv.values[name].obj.(*loxFunction)
+	if !ok || previous.method != nil || previous.decl.Name.Lexeme != name {
+		return function
+	}
+	*previous = *function
+	return previous
+}
+
+// redefineClosure is redefineFunction for the virtual machine.
+func (i *Interp) redefineClosure(name string, c *closure) *closure {
+
+	previous, ok := i.globalEnv.values[name].obj.(*closure)
+	if !ok || previous.function.Name != name {
+		return c
+	}
+	*previous = *c
+	return previous
+}
+
+// previousClass returns the class previously declared with the
+// name, nil if there is none.
+func (i *Interp) previousClass(name string) *loxClass {
+
+	previous, ok := i.globalEnv.values[name].asClass()
+	if !ok || previous.Name != name {
+		return nil
+	}
+	return previous
+}
+
+// rebind binds a bound method again if its method was declared
+// again since it was bound, so it runs the new method.
+func (f *loxFunction) rebind() {
+
+	if f.method != nil && f.decl != f.method.decl {
+		this, _ := f.enclosing.getAt(0, 0).obj.(*loxInstance)
+		*f = *f.method.bind(this)
+	}
+}
+
+// inherits reports if a class is the class or one of its
+// subclasses. A class declared again can't inherit from its previous
+// declaration, it would inherit from itself once updated.
+func (c *loxClass) inherits(class *loxClass) bool {
+
+	for ; c != nil; c = c.Superclass {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// setSuperclass changes the superclass of a class and records the
+// class in the subclasses of its superclass.
+func (c *loxClass) setSuperclass(superclass *loxClass) {
+
+	if c.Superclass != nil {
+		subclasses := c.Superclass.subclasses[:0]
+		for _, subclass := range c.Superclass.subclasses {
+			if subclass != c {
+				subclasses = append(subclasses, subclass)
+			}
+		}
+		c.Superclass.subclasses = subclasses
+	}
+	c.Superclass = superclass
+	if superclass != nil {
+		superclass.subclasses = append(superclass.subclasses, c)
+	}
+}
+
+// redefine updates a class to a new declaration of the class. The
+// methods declared again are updated in place for the bound methods.
+func (c *loxClass) redefine(superclass *loxClass, methods map[string]*loxFunction) {
+
+	c.setSuperclass(superclass)
+	for name, method := range methods {
+		if previous, ok := c.Methods[name]; ok {
+			*previous = *method
+			methods[name] = previous
+		}
+	}
+	c.Methods = methods
+	c.inheritMethods()
+}
+
+// inheritMethods computes again the method table of a class and of
+// its subclasses. The version changes so the inline caches don't
+// return the previous methods.
+func (c *loxClass) inheritMethods() {
+
+	c.allMethods = newLoxClass(c.Name, c.Superclass, c.Methods).allMethods
+	c.version++
+	for _, subclass := range c.subclasses {
+		subclass.inheritMethods()
+	}
+}
+
+// clear removes the methods of a class declared again by the virtual
+// machine, before the new methods are added. Its own methods are kept
+// in replaced to be updated in place for the bound methods.
+func (c *loxClass) clear() {
+
+	c.replaced = make(map[string]*closure)
+	for name, method := range c.closures {
+		if c.Superclass == nil || c.Superclass.closures[name] != method {
+			c.replaced[name] = method
+		}
+	}
+	c.setSuperclass(nil)
+	c.setClosures(nil, nil)
+}
+
+// addMethod adds a method to a class declared again by the virtual
+// machine.
+func (c *loxClass) addMethod(name string, method *closure) {
+
+	if previous, ok := c.replaced[name]; ok {
+		*previous = *method
+		method = previous
+	}
+	c.setClosures(c.closures, map[string]*closure{name: method})
+}
+
+// setClosures replaces the methods of a class declared by the virtual
+// machine by the inherited methods overridden by its own methods, and
+// updates the methods inherited by its subclasses.
+func (c *loxClass) setClosures(inherited, own map[string]*closure) {
+
+	previous := c.closures
+	c.closures = make(map[string]*closure, len(inherited)+len(own))
+	for name, method := range inherited {
+		c.closures[name] = method
+	}
+	for name, method := range own {
+		c.closures[name] = method
+	}
+	for _, subclass := range c.subclasses {
+		subclassOwn := make(map[string]*closure)
+		for name, method := range subclass.closures {
+			if previous[name] != method {
+				subclassOwn[name] = method
+			}
+		}
+		subclass.setClosures(c.closures, subclassOwn)
+	}
+}
+
+// definedGlobal returns the name of the global defined by the next
+// instruction, if it is OpDefineGlobal. The function and the class
+// declarations are the only statements creating a function or a
+// class, followed by OpDefineGlobal when they are at the top level.
+func (vm *vm) definedGlobal(frame *callFrame, code []byte) (string, bool) {
+
+	if bytecode.OpCode(code[frame.ip]) != bytecode.OpDefineGlobal {
+		return "", false
+	}
+	index := int(code[frame.ip+1])<<8 | int(code[frame.ip+2])
+	return frame.closure.constants[index].asString(), true
+}
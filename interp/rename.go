@@ -0,0 +1,198 @@
+package interp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rmonnet/glox/lang"
+)
+
+// Position locates a character of a script, the line and the
+// column (counted in characters) start at 1 like for the tokens.
+type Position struct {
+	Line   int
+	Column int
+}
+
+// Rename renames the variable, parameter, function or class
+// declared or referenced at the position of the script, its
+// declaration and all its references are rewritten. The rest of
+// the script is left untouched (including the comments).
+// An error is returned, and the script is not renamed, if there is
+// no symbol at the position, if the new name is not a valid name,
+// or if the renamed script would not have the same meaning: the
+// new name would collide with another declaration, capture other
+// references or shadow (or be shadowed by) another variable.
+func Rename(source string, pos Position, newName string) (string, error) {
+
+	before, err := analyzeRename(source)
+	if err != nil {
+		return "", err
+	}
+	symbol := before.symbolAt(pos)
+	if symbol == nil || symbol.Kind == PseudoVariableSymbol {
+		return "", fmt.Errorf("no variable, function or class at line %d, column %d",
+			pos.Line, pos.Column)
+	}
+	if !isName(newName) {
+		return "", fmt.Errorf("'%s' is not a valid name", newName)
+	}
+	if newName == symbol.Name {
+		return source, nil
+	}
+	if _, native := New(ioutil.Discard, ioutil.Discard).globalEnv.lookup(newName); native && symbol.Depth == 0 {
+		return "", fmt.Errorf("'%s' would shadow the native function", newName)
+	}
+
+	renamed := rewrite(source, append([]*lang.Token{symbol.Definition}, symbol.References...), newName)
+
+	after, err := analyzeRename(renamed)
+	if err != nil {
+		return "", fmt.Errorf("renaming to '%s' would break the script: %v", newName, err)
+	}
+	if !sameBindings(before, after) {
+		return "", fmt.Errorf("'%s' would collide with another declaration", newName)
+	}
+	for index := range after.shadowing {
+		if !before.shadowing[index] {
+			return "", fmt.Errorf("'%s' would shadow another variable", newName)
+		}
+	}
+	return renamed, nil
+}
+
+// renameAnalysis is the result of the resolution of a script. The
+// tokens are identified by their index, which a rename preserves.
+type renameAnalysis struct {
+	tokens  []*lang.Token
+	index   map[*lang.Token]int
+	symbols []*Symbol
+	// shadowing are the declarations shadowing another variable.
+	shadowing map[int]bool
+}
+
+// analyzeRename resolves a script, the error is the first
+// compile error of the script.
+func analyzeRename(source string) (*renameAnalysis, error) {
+
+	scanner := &lang.Scanner{}
+	scanner.RedirectErrors(ioutil.Discard)
+	tokens := scanner.ScanTokens(source)
+
+	parser := &lang.Parser{}
+	parser.RedirectErrors(ioutil.Discard)
+	statements := parser.Parse(tokens)
+
+	diagnostics := append(scanner.Diagnostics(), parser.Diagnostics()...)
+	if len(diagnostics) > 0 {
+		return nil, fmt.Errorf("%s", diagnostics[0])
+	}
+
+	resolver := NewResolver(New(ioutil.Discard, ioutil.Discard))
+	resolver.RedirectErrors(ioutil.Discard)
+	resolver.SetRecordSymbols(true)
+	resolver.SetWarnShadowing(true)
+	resolver.Resolve(statements)
+
+	analysis := &renameAnalysis{
+		tokens:    tokens,
+		index:     make(map[*lang.Token]int),
+		symbols:   resolver.Symbols(),
+		shadowing: make(map[int]bool),
+	}
+	at := make(map[Position]int)
+	for i, token := range tokens {
+		analysis.index[token] = i
+		at[Position{token.Line, token.Column}] = i
+	}
+	for _, d := range resolver.Diagnostics() {
+		if d.Severity == lang.ErrorSeverity {
+			return nil, fmt.Errorf("%s", d)
+		}
+		if d.Code == CodeShadowing {
+			analysis.shadowing[at[Position{d.Line, d.Column}]] = true
+		}
+	}
+	return analysis, nil
+}
+
+// symbolAt returns the symbol declared or referenced at the
+// position, nil if there is none.
+func (a *renameAnalysis) symbolAt(pos Position) *Symbol {
+
+	for _, symbol := range a.symbols {
+		for _, token := range append([]*lang.Token{symbol.Definition}, symbol.References...) {
+			// the position may also be right after the name.
+			if token.Line == pos.Line && pos.Column >= token.Column &&
+				pos.Column <= token.Column+utf8.RuneCountInString(token.Lexeme) {
+				return symbol
+			}
+		}
+	}
+	return nil
+}
+
+// bindings returns the index of the declaration of each symbol
+// followed by the indexes of its references.
+func (a *renameAnalysis) bindings() [][]int {
+
+	bindings := make([][]int, len(a.symbols))
+	for i, symbol := range a.symbols {
+		binding := []int{a.index[symbol.Definition]}
+		for _, ref := range symbol.References {
+			binding = append(binding, a.index[ref])
+		}
+		bindings[i] = binding
+	}
+	return bindings
+}
+
+// sameBindings returns true if the scripts declare the same
+// symbols, referenced from the same places.
+func sameBindings(a, b *renameAnalysis) bool {
+
+	bindingsA, bindingsB := a.bindings(), b.bindings()
+	if len(bindingsA) != len(bindingsB) {
+		return false
+	}
+	for i := range bindingsA {
+		if fmt.Sprint(bindingsA[i]) != fmt.Sprint(bindingsB[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isName returns true if the name is a valid lox identifier.
+func isName(name string) bool {
+
+	scanner := &lang.Scanner{}
+	scanner.RedirectErrors(ioutil.Discard)
+	tokens := scanner.ScanTokens(name)
+	return len(tokens) == 2 && tokens[0].Type == lang.IdentifierToken &&
+		tokens[0].Lexeme == name
+}
+
+// rewrite replaces the tokens of the source by the new name.
+func rewrite(source string, tokens []*lang.Token, newName string) string {
+
+	lines := strings.Split(source, "\n")
+	// the tokens are replaced from the end of their line,
+	// so the columns of the others don't change.
+	sort.Slice(tokens, func(i, j int) bool {
+		if tokens[i].Line != tokens[j].Line {
+			return tokens[i].Line < tokens[j].Line
+		}
+		return tokens[i].Column > tokens[j].Column
+	})
+	for _, token := range tokens {
+		runes := []rune(lines[token.Line-1])
+		start := token.Column - 1
+		end := start + utf8.RuneCountInString(token.Lexeme)
+		lines[token.Line-1] = string(runes[:start]) + newName + string(runes[end:])
+	}
+	return strings.Join(lines, "\n")
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
 
 	"github.com/rmonnet/glox/lang"
 )
@@ -15,10 +16,30 @@ type Resolver struct {
 	scopes               scopeStack
 	currentFunctionScope functionScope
 	currentClassScope    classScope
+	currentLoopScope     loopScope
+	functions            []*functionFrame
+	globals              map[string]bool
+	warnShadowing        bool
+	strictGlobals        bool
+	strictRedeclaration  bool
+	implicitGlobals      bool
+	declaredGlobals      map[string]bool
+	recordSymbols        bool
+	symbols              []*Symbol
+	globalSymbols        map[string]*Symbol
+	warningMode          WarningMode
 	hadError             bool
+	errorCount           int
+	maxErrors            int
 	errOut               io.Writer
+	formatter            *lang.DiagnosticFormatter
+	diagnostics          []lang.Diagnostic
 }
 
+// errTooManyErrors is a marker used to stop the resolver once
+// the maximum number of errors has been reported.
+var errTooManyErrors = fmt.Errorf("too many errors")
+
 // RedirectErrors switches the file errors are written to.
 // Errors go to stderr by default.
 func (r *Resolver) RedirectErrors(errOut io.Writer) {
@@ -26,11 +47,79 @@ func (r *Resolver) RedirectErrors(errOut io.Writer) {
 	r.errOut = errOut
 }
 
+// SetFormatter sets the formatter styling the errors and warnings
+// written to the error output. They are written as plain text
+// by default.
+func (r *Resolver) SetFormatter(formatter *lang.DiagnosticFormatter) {
+
+	r.formatter = formatter
+}
+
+// SetMaxErrors sets the number of errors reported before the
+// resolver gives up. A value of zero or less removes the limit.
+// The limit is lang.DefaultMaxErrors by default.
+func (r *Resolver) SetMaxErrors(max int) {
+
+	if max <= 0 {
+		max = -1
+	}
+	r.maxErrors = max
+}
+
 // NewResolver creates a new resolver and associate it
 // with an interpreter.
 func NewResolver(i *Interp) *Resolver {
 
-	return &Resolver{interp: i}
+	return &Resolver{interp: i, globals: make(map[string]bool),
+		declaredGlobals: make(map[string]bool), globalSymbols: make(map[string]*Symbol)}
+}
+
+// SetWarnShadowing enables warnings for local declarations
+// shadowing a variable from an enclosing scope (including globals).
+// The warning is disabled by default.
+func (r *Resolver) SetWarnShadowing(enabled bool) {
+
+	r.warnShadowing = enabled
+}
+
+// SetStrictGlobals makes references to globals which are never
+// declared at the top level (and are not natives) compile errors.
+// By default, undefined globals are only reported at runtime.
+func (r *Resolver) SetStrictGlobals(enabled bool) {
+
+	r.strictGlobals = enabled
+}
+
+// SetStrictRedeclaration makes declaring a global already declared
+// at the top level of the script a compile error, like for the
+// local variables. The globals of the previous scripts (or REPL
+// lines) and the natives can still be redeclared.
+// By default, a global declaration replaces the previous one.
+func (r *Resolver) SetStrictRedeclaration(enabled bool) {
+
+	r.strictRedeclaration = enabled
+}
+
+// SetImplicitGlobals makes the assignments to undeclared variables
+// declare them as globals, like Interp.SetImplicitGlobals, so they
+// are not reported in strict mode.
+func (r *Resolver) SetImplicitGlobals(enabled bool) {
+
+	r.implicitGlobals = enabled
+}
+
+// SetRecordSymbols makes the resolver build a symbol table
+// (see Symbols). It is disabled by default.
+func (r *Resolver) SetRecordSymbols(enabled bool) {
+
+	r.recordSymbols = enabled
+}
+
+// SetWarningMode selects how warnings are reported.
+// Warnings are reported but don't prevent execution by default.
+func (r *Resolver) SetWarningMode(mode WarningMode) {
+
+	r.warningMode = mode
 }
 
 // Resolve goes through an AST tree and Resolve variable references.
@@ -39,9 +128,75 @@ func (r *Resolver) Resolve(statements []lang.Stmt) {
 	if r.errOut == nil {
 		r.errOut = os.Stderr
 	}
+	if r.maxErrors == 0 {
+		r.maxErrors = lang.DefaultMaxErrors
+	}
 
+	// stop resolving altogether when too many errors are reported.
+	defer func() {
+		if e := recover(); e != nil {
+			if e != errTooManyErrors {
+				panic(e)
+			}
+			r.scopes = scopeStack{}
+			r.functions = nil
+		}
+	}()
+
+	// globals can be referenced by functions before being declared.
 	for _, statement := range statements {
+		if name := declaredName(statement); name != nil {
+			r.globals[name.Lexeme] = true
+			r.addGlobalSymbol(name, symbolKind(statement))
+		}
+	}
+
+	r.resolveStatements(statements)
+}
+
+// HadError reports if some errors were encountered while
+// resolving the AST.
+func (r *Resolver) HadError() bool {
+
+	return r.hadError
+}
+
+// Symbols returns the symbol table built while resolving the AST,
+// in declaration order. It is only available if the resolver
+// was asked to record symbols.
+func (r *Resolver) Symbols() []*Symbol {
+
+	return r.symbols
+}
+
+// Diagnostics returns the errors and warnings reported while
+// resolving the AST.
+func (r *Resolver) Diagnostics() []lang.Diagnostic {
+
+	return r.diagnostics
+}
+
+// resolveStatements resolves a list of statements.
+// The first statement following an unconditional return or break
+// is reported as unreachable. A return outside of a function or a
+// break outside of a loop is already an error and doesn't count.
+func (r *Resolver) resolveStatements(statements []lang.Stmt) {
+
+	returns := r.currentFunctionScope != outsideFunction
+	breaks := r.currentLoopScope != outsideLoop
+	terminated := false
+	reported := false
+	for _, statement := range statements {
+		if terminated && !reported {
+			if token := lang.StmtStart(statement); token != nil {
+				r.reportWarning(token, CodeUnreachableCode, "Unreachable code.")
+				reported = true
+			}
+		}
 		r.resolveStmt(statement)
+		if alwaysExits(statement, returns, breaks) {
+			terminated = true
+		}
 	}
 }
 
@@ -49,6 +204,8 @@ func (r *Resolver) Resolve(statements []lang.Stmt) {
 func (r *Resolver) resolveStmt(stmt lang.Stmt) {
 
 	switch actualStmt := stmt.(type) {
+	case *lang.BreakStmt:
+		r.checkLoopControl(actualStmt.Keyword)
 	case *lang.ReturnStmt:
 		r.resolveReturnStmt(actualStmt)
 	case *lang.PrintStmt:
@@ -76,7 +233,11 @@ func (r *Resolver) resolveStmt(stmt lang.Stmt) {
 func (r *Resolver) resolveWhileStmt(stmt *lang.WhileStmt) {
 
 	r.resolveExpr(stmt.Condition)
+
+	enclosingLoopScope := r.currentLoopScope
+	r.currentLoopScope = inLoop
 	r.resolveStmt(stmt.Body)
+	r.currentLoopScope = enclosingLoopScope
 }
 
 // resolvePrintStmt resolves variables in a print statement.
@@ -91,14 +252,14 @@ func (r *Resolver) resolveReturnStmt(stmt *lang.ReturnStmt) {
 	// it is an error if returns appears outside of a function
 	// definition.
 	if r.currentFunctionScope == outsideFunction {
-		r.reportError(stmt.Keyword, "Can't return from top-level code.")
+		r.reportError(stmt.Keyword, CodeTopLevelReturn, "Can't return from top-level code.")
 	}
 
 	// it is an error to return a value from inside an
 	// initializer (initializer always return the class instance).
 	if r.currentFunctionScope == inInitializer &&
 		stmt.Value != nil {
-		r.reportError(stmt.Keyword,
+		r.reportError(stmt.Keyword, CodeInitializerReturnValue,
 			"Can't return a value from an initializer.")
 	}
 
@@ -129,7 +290,15 @@ func (r *Resolver) resolveIfStmt(stmt *lang.IfStmt) {
 func (r *Resolver) resolveBlockStmt(stmt *lang.BlockStmt) {
 
 	r.beginScope()
-	r.Resolve(stmt.Statements)
+	if stmt.Desugared {
+		// the increment of a for loop follows its body in the
+		// desugared block, it is not code written after a break.
+		for _, statement := range stmt.Statements {
+			r.resolveStmt(statement)
+		}
+	} else {
+		r.resolveStatements(stmt.Statements)
+	}
 	r.endScope()
 }
 
@@ -137,7 +306,7 @@ func (r *Resolver) resolveBlockStmt(stmt *lang.BlockStmt) {
 // ThisToken method keeps track of the variable declaration and definition.
 func (r *Resolver) resolveVarDeclStmt(stmt *lang.VarDeclStmt) {
 
-	r.declare(stmt.Name)
+	r.declare(stmt.Name, VariableSymbol)
 
 	if stmt.Initializer != nil {
 		r.resolveExpr(stmt.Initializer)
@@ -153,13 +322,13 @@ func (r *Resolver) resolveClassDeclStmt(stmt *lang.ClassDeclStmt) {
 	enclosingClassScope := r.currentClassScope
 	r.currentClassScope = inClass
 
-	r.declare(stmt.Name)
+	r.declare(stmt.Name, ClassSymbol)
 	r.define(stmt.Name)
 
 	// it is an error if the class superclass is the class itself.
 	if stmt.Superclass != nil &&
 		stmt.Name.Lexeme == stmt.Superclass.Name.Lexeme {
-		r.reportError(stmt.Superclass.Name,
+		r.reportError(stmt.Superclass.Name, CodeSelfInheritance,
 			"A class can't inherit from itself.")
 	}
 
@@ -167,11 +336,22 @@ func (r *Resolver) resolveClassDeclStmt(stmt *lang.ClassDeclStmt) {
 		r.currentClassScope = inSubClass
 		r.resolveExpr(stmt.Superclass)
 		r.beginScope()
-		r.scopes.peek()["super"] = true
+		r.scopes.peek()["super"] = &variable{defined: true, kind: PseudoVariableSymbol}
 	}
 
 	r.beginScope()
-	r.scopes.peek()["this"] = true
+	r.scopes.peek()["this"] = &variable{defined: true, kind: PseudoVariableSymbol}
+
+	// it is an error to declare the same method twice in a class
+	// since only the last definition would be kept.
+	methodNames := make(map[string]bool)
+	for _, method := range stmt.Methods {
+		if methodNames[method.Name.Lexeme] {
+			r.reportError(method.Name, CodeDuplicateMethod,
+				"Method already declared in this class.")
+		}
+		methodNames[method.Name.Lexeme] = true
+	}
 
 	for _, method := range stmt.Methods {
 		declaration := inMethod
@@ -194,7 +374,7 @@ func (r *Resolver) resolveClassDeclStmt(stmt *lang.ClassDeclStmt) {
 // ThisToken method keeps track of the function declaration and definition.
 func (r *Resolver) resolveFunDeclStmt(stmt *lang.FunDeclStmt) {
 
-	r.declare(stmt.Name)
+	r.declare(stmt.Name, FunctionSymbol)
 	r.define(stmt.Name)
 
 	r.resolveFunction(stmt, inFunction)
@@ -206,16 +386,42 @@ func (r *Resolver) resolveFunction(stmt *lang.FunDeclStmt, newScope functionScop
 
 	enclosingFunctionScope := r.currentFunctionScope
 	r.currentFunctionScope = newScope
+	// a loop doesn't extend into the functions declared in its body.
+	enclosingLoopScope := r.currentLoopScope
+	r.currentLoopScope = outsideLoop
+
+	// the frame of a method starts with the scope holding 'this'
+	// since it is bound when the method is accessed.
+	frame := &functionFrame{base: r.scopes.size(),
+		captured: make(map[upvalueKey]int)}
+	if newScope == inMethod || newScope == inInitializer {
+		frame.base--
+	}
+	r.functions = append(r.functions, frame)
 
 	r.beginScope()
 	for _, param := range stmt.Params {
-		r.declare(param)
+		r.declare(param, ParameterSymbol)
 		r.define(param)
 	}
-	r.Resolve(stmt.Body)
+	r.resolveStatements(stmt.Body)
 	r.endScope()
 
+	r.functions = r.functions[:len(r.functions)-1]
+	stmt.Upvalues = frame.upvalues
+
 	r.currentFunctionScope = enclosingFunctionScope
+	r.currentLoopScope = enclosingLoopScope
+}
+
+// checkLoopControl reports a 'break' statement used outside
+// of a loop.
+func (r *Resolver) checkLoopControl(keyword *lang.Token) {
+
+	if r.currentLoopScope == outsideLoop {
+		r.reportError(keyword, CodeLoopControlOutsideLoop, "Can't use '"+keyword.Lexeme+
+			"' outside of a loop.")
+	}
 }
 
 // resolveExpr resolves variable references within an expression.
@@ -318,14 +524,20 @@ func (r *Resolver) resolveBinaryExpr(expr *lang.BinaryExpr) {
 func (r *Resolver) resolveVarExpr(expr *lang.VarExpr) {
 
 	if !r.scopes.isEmpty() {
-		isInitialized, isDefined := r.scopes.peek()[expr.Name.Lexeme]
-		if isDefined && !isInitialized {
-			r.reportError(expr.Name,
+		v, isDeclared := r.scopes.peek()[expr.Name.Lexeme]
+		if isDeclared && !v.defined {
+			r.reportError(expr.Name, CodeReadInOwnInitializer,
 				"Can't read local variable in its own initializer.")
 		}
 	}
 
-	r.resolveLocal(expr, expr.Name)
+	if v := r.resolveLocal(expr, expr.Name); v != nil {
+		v.used = true
+		r.addReference(v.symbol, expr.Name)
+	} else {
+		r.checkGlobal(expr.Name)
+		r.addReference(r.globalSymbols[expr.Name.Lexeme], expr.Name)
+	}
 }
 
 // resolveThisExpr resolves 'this' as a pseudo-variable within
@@ -333,25 +545,32 @@ func (r *Resolver) resolveVarExpr(expr *lang.VarExpr) {
 func (r *Resolver) resolveThisExpr(expr *lang.ThisExpr) {
 
 	if r.currentClassScope == outsideClass {
-		r.reportError(expr.Keyword,
+		r.reportError(expr.Keyword, CodeThisOutsideClass,
 			"Can't use 'this' outside of a class.")
 	}
 	r.resolveLocal(expr, expr.Keyword)
 }
 
 // resolveSuperExpr resolves 'super' as a pseudo-variable
-// within methods of a class.
+// within methods of a subclass, including the functions nested
+// in those methods.
 func (r *Resolver) resolveSuperExpr(expr *lang.SuperExpr) {
 
 	if r.currentClassScope == outsideClass {
-		r.reportError(expr.Keyword,
+		r.reportError(expr.Keyword, CodeSuperOutsideClass,
 			"Can't use 'super' outside a class.")
 	} else if r.currentClassScope != inSubClass {
-		r.reportError(expr.Keyword,
+		r.reportError(expr.Keyword, CodeSuperWithoutSuperclass,
 			"Can't use 'super' in a class with no superclass.")
 	}
 
 	r.resolveLocal(expr, expr.Keyword)
+	for i := r.scopes.size() - 1; i >= 0; i-- {
+		if v, ok := r.scopes.get(i)["this"]; ok {
+			expr.This = r.binding(i, v.slot)
+			break
+		}
+	}
 }
 
 // resolveAssignExpr resolves variables in an assignment expression.
@@ -360,7 +579,15 @@ func (r *Resolver) resolveSuperExpr(expr *lang.SuperExpr) {
 func (r *Resolver) resolveAssignExpr(expr *lang.AssignExpr) {
 
 	r.resolveExpr(expr.Value)
-	r.resolveLocal(expr, expr.Name)
+	if v := r.resolveLocal(expr, expr.Name); v != nil {
+		r.addReference(v.symbol, expr.Name)
+	} else if r.implicitGlobals {
+		r.globals[expr.Name.Lexeme] = true
+		r.addReference(r.globalSymbols[expr.Name.Lexeme], expr.Name)
+	} else {
+		r.checkGlobal(expr.Name)
+		r.addReference(r.globalSymbols[expr.Name.Lexeme], expr.Name)
+	}
 }
 
 // ------------------
@@ -374,16 +601,39 @@ func (r *Resolver) beginScope() {
 }
 
 // endScope denotes the end of a scope for variable references.
+// Local declarations that were never read are reported as warnings.
 func (r *Resolver) endScope() {
 
-	r.scopes.pop()
+	var unused []*variable
+	for _, v := range r.scopes.pop() {
+		if !v.used && v.kind != ParameterSymbol && v.kind != PseudoVariableSymbol {
+			unused = append(unused, v)
+		}
+	}
+
+	sort.Slice(unused, func(i, j int) bool {
+		if unused[i].name.Line != unused[j].name.Line {
+			return unused[i].name.Line < unused[j].name.Line
+		}
+		return unused[i].name.Lexeme < unused[j].name.Lexeme
+	})
+	for _, v := range unused {
+		r.reportWarning(v.name, CodeUnusedLocal, fmt.Sprintf("Local %s '%s' is never used.",
+			v.kind, v.name.Lexeme))
+	}
 }
 
 // declare associates the variable declaration with the current scope.
 // The variable is marked as undefined.
-func (r *Resolver) declare(name *lang.Token) {
+func (r *Resolver) declare(name *lang.Token, kind SymbolKind) {
 
 	if r.scopes.isEmpty() {
+		if r.strictRedeclaration && r.declaredGlobals[name.Lexeme] {
+			r.reportError(name, CodeAlreadyDeclared, "Variable already declared in this scope.")
+		}
+		r.declaredGlobals[name.Lexeme] = true
+		r.globals[name.Lexeme] = true
+		r.addGlobalSymbol(name, kind)
 		return
 	}
 
@@ -391,10 +641,46 @@ func (r *Resolver) declare(name *lang.Token) {
 
 	// it is an error to redeclare the same variable in the same scope.
 	if _, ok := sc[name.Lexeme]; ok {
-		r.reportError(name, "Variable already declared in this scope.")
+		r.reportError(name, CodeAlreadyDeclared, "Variable already declared in this scope.")
+	} else if r.warnShadowing && r.isShadowing(name.Lexeme) {
+		what := "Local " + kind.String()
+		if kind == ParameterSymbol {
+			what = "Parameter"
+		}
+		r.reportWarning(name, CodeShadowing, fmt.Sprintf(
+			"%s '%s' shadows a variable from an enclosing scope.",
+			what, name.Lexeme))
+	}
+
+	// slots are allocated in declaration order, which is also the
+	// order the variables are defined at runtime.
+	v := &variable{name: name, kind: kind, slot: len(sc)}
+	if r.recordSymbols {
+		v.symbol = &Symbol{name.Lexeme, kind, r.scopes.size(), name, nil}
+		r.symbols = append(r.symbols, v.symbol)
 	}
+	sc[name.Lexeme] = v
+}
+
+// addGlobalSymbol records a global declaration in the symbol table.
+// Redeclaring a global doesn't introduce a new symbol.
+func (r *Resolver) addGlobalSymbol(name *lang.Token, kind SymbolKind) {
+
+	if !r.recordSymbols || r.globalSymbols[name.Lexeme] != nil {
+		return
+	}
+	symbol := &Symbol{name.Lexeme, kind, 0, name, nil}
+	r.globalSymbols[name.Lexeme] = symbol
+	r.symbols = append(r.symbols, symbol)
+}
+
+// addReference records a reference to a symbol.
+// Unknown symbols (natives or undefined globals) are ignored.
+func (r *Resolver) addReference(symbol *Symbol, name *lang.Token) {
 
-	sc[name.Lexeme] = false
+	if symbol != nil {
+		symbol.References = append(symbol.References, name)
+	}
 }
 
 // define defines the variable in the current scope.
@@ -404,43 +690,221 @@ func (r *Resolver) define(name *lang.Token) {
 		return
 	}
 
-	r.scopes.peek()[name.Lexeme] = true
+	r.scopes.peek()[name.Lexeme].defined = true
+}
+
+// isShadowing checks if a variable with this name is visible from
+// the current scope, either in an enclosing scope or as a global.
+func (r *Resolver) isShadowing(name string) bool {
+
+	for i := r.scopes.size() - 2; i >= 0; i-- {
+		if _, ok := r.scopes.get(i)[name]; ok {
+			return true
+		}
+	}
+	if r.globals[name] {
+		return true
+	}
+	_, ok := r.interp.globalEnv.lookup(name)
+	return ok
+}
+
+// checkGlobal reports references to undefined globals
+// in strict mode.
+func (r *Resolver) checkGlobal(name *lang.Token) {
+
+	if !r.strictGlobals || r.globals[name.Lexeme] {
+		return
+	}
+	if _, ok := r.interp.globalEnv.lookup(name.Lexeme); ok {
+		return
+	}
+
+	candidates := r.interp.globalEnv.names()
+	for global := range r.globals {
+		candidates = append(candidates, global)
+	}
+	r.reportError(name, CodeUndefinedGlobal, "Undefined variable '"+name.Lexeme+"'."+
+		didYouMean(name.Lexeme, candidates))
+}
+
+// declaredName returns the name declared by a statement
+// or nil if the statement is not a declaration.
+func declaredName(stmt lang.Stmt) *lang.Token {
+
+	switch s := stmt.(type) {
+	case *lang.VarDeclStmt:
+		return s.Name
+	case *lang.FunDeclStmt:
+		return s.Name
+	case *lang.ClassDeclStmt:
+		return s.Name
+	}
+	return nil
 }
 
 // resolveLocal search for the variables in the current scope
-// and enclosing scopes and notify the interpreter of the variable
-// location.
-func (r *Resolver) resolveLocal(expr lang.Expr, name *lang.Token) {
+// and enclosing scopes and records the variable location in the
+// expression. It returns the local variable found or nil if the
+// variable is a global.
+func (r *Resolver) resolveLocal(expr lang.Expr, name *lang.Token) *variable {
 
 	for i := r.scopes.size() - 1; i >= 0; i-- {
-		if _, ok := r.scopes.get(i)[name.Lexeme]; ok {
-			r.interp.Resolve(expr, r.scopes.size()-1-i)
-			return
+		if v, ok := r.scopes.get(i)[name.Lexeme]; ok {
+			binding := r.binding(i, v.slot)
+			switch e := expr.(type) {
+			case *lang.VarExpr:
+				e.Binding = binding
+			case *lang.AssignExpr:
+				e.Binding = binding
+			case *lang.ThisExpr:
+				e.Binding = binding
+			case *lang.SuperExpr:
+				e.Binding = binding
+			}
+			return v
 		}
 	}
+	return nil
+}
+
+// binding computes the location of the variable declared in
+// the scope at index at slot, as seen from the current scope.
+// Variables declared outside of the current function are upvalues.
+func (r *Resolver) binding(index, slot int) lang.Binding {
+
+	current := len(r.functions) - 1
+	if current < 0 || index >= r.functions[current].base {
+		return lang.Binding{Local: true, Depth: r.scopes.size() - 1 - index, Slot: slot}
+	}
+	return lang.Binding{Upvalue: true, Slot: r.upvalue(current, index, slot)}
+}
+
+// upvalue returns the index of the upvalue capturing the variable
+// declared in the scope at index at slot in the function frame,
+// adding the upvalue (and the upvalues of the enclosing functions
+// needed to reach it) if it is not captured yet.
+func (r *Resolver) upvalue(function, index, slot int) int {
+
+	frame := r.functions[function]
+	key := upvalueKey{index, slot}
+	if upvalue, ok := frame.captured[key]; ok {
+		return upvalue
+	}
+
+	// the variable is captured when the function is created,
+	// from the scope enclosing the function frame.
+	enclosingBase := 0
+	if function > 0 {
+		enclosingBase = r.functions[function-1].base
+	}
+	var upvalue lang.Upvalue
+	if index >= enclosingBase {
+		upvalue = lang.Upvalue{Local: true, Depth: frame.base - 1 - index, Slot: slot}
+	} else {
+		upvalue = lang.Upvalue{Index: r.upvalue(function-1, index, slot)}
+	}
+
+	frame.upvalues = append(frame.upvalues, upvalue)
+	frame.captured[key] = len(frame.upvalues) - 1
+	return len(frame.upvalues) - 1
 }
 
 // reportError is triggered when a parser errors is encountered.
 // the parser can then continue from that point.
-func (r *Resolver) reportError(token *lang.Token, msg string) {
+func (r *Resolver) reportError(token *lang.Token, code string, msg string) {
 
-	var where string
-	if token.Type == lang.EndToken {
-		where = "at end"
-	} else {
-		where = "at '" + token.Lexeme + "'"
+	r.report(lang.NewDiagnostic(lang.ErrorSeverity, token, code, msg))
+}
+
+// alwaysExits checks if a statement unconditionally returns from
+// the enclosing function or breaks out of the enclosing loop, making
+// the statements following it in the same block unreachable.
+// The returns and the breaks are ignored unless returns and breaks
+// are true.
+func alwaysExits(stmt lang.Stmt, returns, breaks bool) bool {
+
+	switch s := stmt.(type) {
+	case *lang.BreakStmt:
+		return breaks
+	case *lang.ReturnStmt:
+		return returns
+	case *lang.BlockStmt:
+		for _, statement := range s.Statements {
+			if alwaysExits(statement, returns, breaks) {
+				return true
+			}
+		}
+	case *lang.IfStmt:
+		return s.ElseBranch != nil &&
+			alwaysExits(s.ThenBranch, returns, breaks) && alwaysExits(s.ElseBranch, returns, breaks)
+	}
+	return false
+}
+
+// reportWarning reports a problem that doesn't prevent the
+// script from running.
+// How the warning is handled depends on the resolver WarningMode.
+func (r *Resolver) reportWarning(token *lang.Token, code string, msg string) {
+
+	switch r.warningMode {
+	case IgnoreWarnings:
+		return
+	case WarningsAsErrors:
+		r.reportError(token, code, msg)
+	default:
+		r.report(lang.NewDiagnostic(lang.WarningSeverity, token, code, msg))
+	}
+}
+
+// report writes the diagnostic to the error output and keeps
+// track of the number of errors.
+func (r *Resolver) report(diagnostic lang.Diagnostic) {
+
+	if diagnostic.Severity == lang.ErrorSeverity {
+		r.hadError = true
+		r.errorCount++
+		if r.maxErrors > 0 && r.errorCount > r.maxErrors {
+			fmt.Fprintln(r.errOut, lang.TooManyErrorsMessage)
+			panic(errTooManyErrors)
+		}
 	}
-	fmt.Fprintf(r.errOut, "[line %d] Error %s: %s\n",
-		token.Line, where, msg)
-	r.hadError = true
+
+	r.diagnostics = append(r.diagnostics, diagnostic)
+	fmt.Fprintln(r.errOut, r.formatter.Format(diagnostic))
 }
 
 // --------------------------------------
 // Data Structures internal to Resolver
 // --------------------------------------
 
+// variable represents a variable declared in a scope.
+type variable struct {
+	name    *lang.Token
+	kind    SymbolKind
+	defined bool
+	used    bool
+	symbol  *Symbol
+	slot    int
+}
+
+// functionFrame keeps track of the scopes belonging to a function
+// being resolved (starting at base) and of the variables it captures.
+type functionFrame struct {
+	base     int
+	upvalues []lang.Upvalue
+	captured map[upvalueKey]int
+}
+
+// upvalueKey identifies a variable captured by a function by
+// the index of its scope and its slot.
+type upvalueKey struct {
+	scope int
+	slot  int
+}
+
 // scope represents an interpreter scope.
-type scope map[string]bool
+type scope map[string]*variable
 
 // scopeStack represents a stack of scopes.
 type scopeStack struct {
@@ -491,6 +955,19 @@ func (s *scopeStack) get(index int) scope {
 // Enum values used by Resolver
 // ------------------------------
 
+// WarningMode selects how the warnings found by the resolver
+// are handled.
+type WarningMode int
+
+const (
+	// ReportWarnings reports warnings without preventing execution.
+	ReportWarnings WarningMode = iota
+	// IgnoreWarnings doesn't report warnings at all.
+	IgnoreWarnings
+	// WarningsAsErrors reports warnings as errors, preventing execution.
+	WarningsAsErrors
+)
+
 // functionScope keeps track if the current scope is a function or
 // a method.
 type functionScope int
@@ -502,7 +979,10 @@ const (
 	inMethod
 )
 
-// classScope keeps track if the current scope is within a class.
+// classScope keeps track if the current scope is within a class
+// and if that class has a superclass ('super' is only valid in a
+// subclass). The innermost class wins, so a class without superclass
+// declared in a method of a subclass can't use 'super'.
 type classScope int
 
 const (
@@ -510,3 +990,12 @@ const (
 	inSubClass
 	inClass
 )
+
+// loopScope keeps track if the current scope is within a loop
+// body (for or while), where loop control statements are allowed.
+type loopScope int
+
+const (
+	outsideLoop loopScope = iota
+	inLoop
+)
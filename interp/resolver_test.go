@@ -0,0 +1,98 @@
+package interp
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/rmonnet/glox/lang"
+)
+
+func TestResolveBindings(t *testing.T) {
+
+	script := `
+		var global;
+		fun f(a, b) {
+			var c;
+			{
+				var d;
+				print d;
+				print c;
+				b = global;
+			}
+		}`
+
+	tokens := (&lang.Scanner{}).ScanTokens(script)
+	statements := (&lang.Parser{}).Parse(tokens)
+	resolver := NewResolver(New(ioutil.Discard, ioutil.Discard))
+	resolver.RedirectErrors(ioutil.Discard)
+	resolver.Resolve(statements)
+
+	block := statements[1].(*lang.FunDeclStmt).Body[1].(*lang.BlockStmt)
+	printD := block.Statements[1].(*lang.PrintStmt).Expression.(*lang.VarExpr)
+	printC := block.Statements[2].(*lang.PrintStmt).Expression.(*lang.VarExpr)
+	assign := block.Statements[3].(*lang.ExprStmt).Expression.(*lang.AssignExpr)
+	global := assign.Value.(*lang.VarExpr)
+
+	tests := []struct {
+		name   string
+		got    lang.Binding
+		expect lang.Binding
+	}{
+		{"local in current scope", printD.Binding, lang.Binding{Local: true, Depth: 0, Slot: 0}},
+		{"local in enclosing scope", printC.Binding, lang.Binding{Local: true, Depth: 1, Slot: 2}},
+		{"assigned parameter", assign.Binding, lang.Binding{Local: true, Depth: 1, Slot: 1}},
+		{"global", global.Binding, lang.Binding{}},
+	}
+	for _, test := range tests {
+		if test.got != test.expect {
+			t.Errorf("%s: expected %+v but got %+v", test.name, test.expect, test.got)
+		}
+	}
+}
+
+func TestResolveUpvalues(t *testing.T) {
+
+	script := `
+		fun outer(a) {
+			var b;
+			fun middle() {
+				fun inner() {
+					print b;
+					print a;
+				}
+			}
+		}`
+
+	tokens := (&lang.Scanner{}).ScanTokens(script)
+	statements := (&lang.Parser{}).Parse(tokens)
+	resolver := NewResolver(New(ioutil.Discard, ioutil.Discard))
+	resolver.RedirectErrors(ioutil.Discard)
+	resolver.Resolve(statements)
+
+	outer := statements[0].(*lang.FunDeclStmt)
+	middle := outer.Body[1].(*lang.FunDeclStmt)
+	inner := middle.Body[0].(*lang.FunDeclStmt)
+	printB := inner.Body[0].(*lang.PrintStmt).Expression.(*lang.VarExpr)
+	printA := inner.Body[1].(*lang.PrintStmt).Expression.(*lang.VarExpr)
+
+	if len(outer.Upvalues) != 0 {
+		t.Errorf("Expected outer to capture nothing but got %+v", outer.Upvalues)
+	}
+	expectMiddle := []lang.Upvalue{
+		{Local: true, Depth: 0, Slot: 1},
+		{Local: true, Depth: 0, Slot: 0}}
+	if !reflect.DeepEqual(middle.Upvalues, expectMiddle) {
+		t.Errorf("Expected middle upvalues %+v but got %+v", expectMiddle, middle.Upvalues)
+	}
+	expectInner := []lang.Upvalue{{Index: 0}, {Index: 1}}
+	if !reflect.DeepEqual(inner.Upvalues, expectInner) {
+		t.Errorf("Expected inner upvalues %+v but got %+v", expectInner, inner.Upvalues)
+	}
+	if expect := (lang.Binding{Upvalue: true, Slot: 0}); printB.Binding != expect {
+		t.Errorf("Expected b binding %+v but got %+v", expect, printB.Binding)
+	}
+	if expect := (lang.Binding{Upvalue: true, Slot: 1}); printA.Binding != expect {
+		t.Errorf("Expected a binding %+v but got %+v", expect, printA.Binding)
+	}
+}
@@ -0,0 +1,42 @@
+package interp
+
+import (
+	"errors"
+
+	"github.com/rmonnet/glox/lang"
+)
+
+// ErrCompile is returned by SafeRun when the script has compile
+// errors, which are reported on the error output like in Run.
+var ErrCompile = errors.New("the script has compile errors")
+
+// SafeRun runs a script like Run, for the host applications which
+// get their scripts from untrusted sources: a malformed script can
+// never crash the application. The error is:
+//   - ErrCompile if the script has compile errors
+//   - the RuntimeError which stopped the script
+//   - a *lang.PanicError if the interpreter panicked (an internal
+//     error), the interpreter can still run other scripts
+func (i *Interp) SafeRun(script string) (err error) {
+
+	defer func() {
+		if e := recover(); e != nil {
+			// the script may have been stopped anywhere.
+			i.env = i.globalEnv
+			i.callDepth = 0
+			i.upvalues = nil
+			i.frames = nil
+			i.lastRunFailed = true
+			err = lang.NewPanicError(e)
+		}
+	}()
+
+	i.Run(script, false)
+	switch {
+	case i.runtimeError != nil:
+		return *i.runtimeError
+	case i.lastRunFailed:
+		return ErrCompile
+	}
+	return nil
+}
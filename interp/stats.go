@@ -0,0 +1,121 @@
+package interp
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rmonnet/glox/lang"
+)
+
+// PhaseStats reports the time spent in each phase of the runs
+// (scanning, parsing, resolving, compiling to bytecode for the VM
+// backend and executing) and the amount of work done, accumulated
+// since the statistics were enabled.
+// The statements are only counted by the tree-walker.
+type PhaseStats struct {
+	Scanning   time.Duration
+	Parsing    time.Duration
+	Resolving  time.Duration
+	Compiling  time.Duration
+	Executing  time.Duration
+	Tokens     int
+	Nodes      int
+	Statements int
+}
+
+// SetPhaseStats enables the collection of the phase statistics,
+// which tell if a slow script spends its time in the front-end
+// or at runtime. They are disabled by default.
+func (i *Interp) SetPhaseStats(enabled bool) {
+
+	if !enabled {
+		i.stats = nil
+	} else if i.stats == nil {
+		i.stats = &PhaseStats{}
+	}
+}
+
+// PhaseStats returns the statistics collected since they were
+// enabled.
+func (i *Interp) PhaseStats() PhaseStats {
+
+	if i.stats == nil {
+		return PhaseStats{}
+	}
+	return *i.stats
+}
+
+// WritePhaseStats writes the statistics as a table to out.
+// Nothing is written if the statistics are disabled.
+func (i *Interp) WritePhaseStats(out io.Writer) {
+
+	if i.stats == nil {
+		return
+	}
+	fmt.Fprintf(out, "%-10s %14s  %d tokens\n", "scanning", i.stats.Scanning, i.stats.Tokens)
+	fmt.Fprintf(out, "%-10s %14s  %d nodes\n", "parsing", i.stats.Parsing, i.stats.Nodes)
+	fmt.Fprintf(out, "%-10s %14s\n", "resolving", i.stats.Resolving)
+	if i.backend == VM {
+		fmt.Fprintf(out, "%-10s %14s\n", "compiling", i.stats.Compiling)
+		fmt.Fprintf(out, "%-10s %14s\n", "executing", i.stats.Executing)
+		return
+	}
+	fmt.Fprintf(out, "%-10s %14s  %d statements\n", "executing", i.stats.Executing,
+		i.stats.Statements)
+}
+
+// counters count the work done by a run, they are reset at its start.
+type counters struct {
+	statements int
+	calls      int
+	instances  int
+	start      time.Time
+	end        time.Time
+}
+
+// RuntimeStats are the counters of a run, also returned to the
+// scripts by runtimeStats(). The statements are only counted by the
+// tree-walker, and so is the depth of the environment (the number of
+// scopes enclosing the statement executing): they are -1 with the
+// virtual machine. The calls include the natives and the
+// instantiations of the classes.
+type RuntimeStats struct {
+	Statements int
+	Calls      int
+	Instances  int
+	Depth      int
+	Elapsed    time.Duration
+}
+
+// RuntimeStats returns the counters of the current run, or of the
+// last one once it is finished.
+func (i *Interp) RuntimeStats() RuntimeStats {
+
+	elapsed := time.Duration(0)
+	if !i.counters.end.IsZero() {
+		elapsed = i.counters.end.Sub(i.counters.start)
+	} else if !i.counters.start.IsZero() {
+		elapsed = time.Since(i.counters.start)
+	}
+	stats := RuntimeStats{
+		Statements: i.counters.statements,
+		Calls:      i.counters.calls,
+		Instances:  i.counters.instances,
+		Depth:      i.env.depth(),
+		Elapsed:    elapsed,
+	}
+	if i.backend == VM {
+		stats.Statements = -1
+		stats.Depth = -1
+	}
+	return stats
+}
+
+// countNodes returns the number of nodes of the AST.
+func countNodes(statements []lang.Stmt) int {
+
+	count := 0
+	lang.Walk(statements, func(lang.Node, int) { count++ })
+	return count
+}
@@ -0,0 +1,93 @@
+package interp
+
+import (
+	"fmt"
+	"sort"
+)
+
+// didYouMean returns a hint pointing to the candidate closest
+// to the unknown name, in the form " Did you mean 'counter'?".
+// It returns an empty string if no candidate is close enough
+// to be a plausible typo.
+func didYouMean(name string, candidates []string) string {
+
+	if best := closestName(name, candidates); best != "" {
+		return fmt.Sprintf(" Did you mean '%s'?", best)
+	}
+	return ""
+}
+
+// closestName returns the candidate with the smallest edit
+// distance to name. Candidates further away than the allowed
+// number of edits are ignored. Ties are broken alphabetically so
+// the suggestion is deterministic.
+func closestName(name string, candidates []string) string {
+
+	maxDistance := len(name) / 3
+	if maxDistance < 1 {
+		maxDistance = 1
+	}
+
+	sorted := append([]string(nil), candidates...)
+	sort.Strings(sorted)
+
+	best := ""
+	bestDistance := maxDistance + 1
+	for _, candidate := range sorted {
+		if candidate == name {
+			continue
+		}
+		if d := editDistance(name, candidate); d < bestDistance {
+			best = candidate
+			bestDistance = d
+		}
+	}
+	return best
+}
+
+// editDistance computes the optimal string alignment distance
+// between two strings, that is the number of insertions, deletions,
+// substitutions and transpositions of adjacent characters needed
+// to change one string into the other.
+func editDistance(a, b string) int {
+
+	ra, rb := []rune(a), []rune(b)
+
+	// d[i][j] is the distance between the first i characters
+	// of a and the first j characters of b.
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := 0; j <= len(rb); j++ {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = minInt(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+
+	return d[len(ra)][len(rb)]
+}
+
+// minInt returns the smallest of its arguments.
+func minInt(values ...int) int {
+
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
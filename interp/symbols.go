@@ -0,0 +1,78 @@
+package interp
+
+import "github.com/rmonnet/glox/lang"
+
+// Symbol describes a variable, function or class declaration
+// found by the resolver and the places it is referenced from.
+// Methods and fields are not included since they are resolved
+// dynamically at runtime.
+type Symbol struct {
+	Name string
+	Kind SymbolKind
+	// Depth is the number of scopes enclosing the declaration,
+	// 0 for globals.
+	Depth      int
+	Definition *lang.Token
+	References []*lang.Token
+}
+
+// SymbolKind keeps track of the declaration which introduced
+// a symbol in a scope.
+type SymbolKind int
+
+const (
+	// VariableSymbol is introduced by a var declaration.
+	VariableSymbol SymbolKind = iota
+	// FunctionSymbol is introduced by a fun declaration.
+	FunctionSymbol
+	// ClassSymbol is introduced by a class declaration.
+	ClassSymbol
+	// ParameterSymbol is introduced by a function parameter.
+	ParameterSymbol
+	// PseudoVariableSymbol represents 'this' and 'super'.
+	PseudoVariableSymbol
+)
+
+// String returns the name of the kind of symbol as used in
+// the resolver diagnostics.
+func (k SymbolKind) String() string {
+
+	switch k {
+	case FunctionSymbol:
+		return "function"
+	case ClassSymbol:
+		return "class"
+	case ParameterSymbol:
+		return "parameter"
+	case PseudoVariableSymbol:
+		return "pseudo-variable"
+	default:
+		return "variable"
+	}
+}
+
+// Symbols resolves a lox script without executing it and returns
+// its symbol table, in declaration order. The symbols are empty
+// if the script has syntax errors.
+func Symbols(source string) []*Symbol {
+
+	resolver, _ := analyze(source, true)
+	if resolver == nil {
+		return nil
+	}
+	return resolver.Symbols()
+}
+
+// symbolKind returns the kind of symbol introduced by a
+// declaration statement.
+func symbolKind(stmt lang.Stmt) SymbolKind {
+
+	switch stmt.(type) {
+	case *lang.FunDeclStmt:
+		return FunctionSymbol
+	case *lang.ClassDeclStmt:
+		return ClassSymbol
+	default:
+		return VariableSymbol
+	}
+}
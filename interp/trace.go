@@ -0,0 +1,78 @@
+package interp
+
+import "github.com/rmonnet/glox/lang"
+
+// StackFrame is a call active when a runtime error stopped a script.
+type StackFrame struct {
+	// Function is the name of the function called,
+	// "script" for the top level of the script.
+	Function string
+	// Line is the line of the statement executing in the call
+	// (of the instruction with the virtual machine).
+	Line int
+}
+
+// SetStackTraces enables recording the calls of the scripts, so
+// StackTrace can report where a runtime error, or a cancellation,
+// stopped them. It slightly slows down the tree-walker.
+// The calls are not recorded by default.
+func (i *Interp) SetStackTraces(enabled bool) {
+
+	i.traceCalls = enabled
+}
+
+// StackTrace returns the calls active when the runtime error of the
+// last run stopped the script, the innermost first. It returns nil
+// if the run succeeded or if the stack traces are not enabled.
+func (i *Interp) StackTrace() []StackFrame {
+
+	return i.trace
+}
+
+// stackTrace returns the calls tracked by the tree-walker when the
+// execution was stopped by e.
+func (i *Interp) stackTrace(e interface{}) []StackFrame {
+
+	trace := make([]StackFrame, 0, len(i.frames))
+	for n := len(i.frames) - 1; n >= 0; n-- {
+		f := i.frames[n]
+		line := 0
+		if f.stmt != nil {
+			line = lang.StmtStart(f.stmt).Line
+		}
+		trace = append(trace, StackFrame{f.function, line})
+	}
+	return locateError(trace, e)
+}
+
+// stackTrace returns the calls of the virtual machine when the
+// execution was stopped by e.
+func (vm *vm) stackTrace(e interface{}) []StackFrame {
+
+	trace := make([]StackFrame, 0, len(vm.frames))
+	for n := len(vm.frames) - 1; n >= 0; n-- {
+		f := vm.frames[n]
+		function := f.closure.function
+		name := function.Name
+		if name == "" {
+			name = "script"
+		}
+		line := 0
+		if f.ip > 0 {
+			line = function.Chunk.Line(f.ip - 1)
+		}
+		trace = append(trace, StackFrame{name, line})
+	}
+	return locateError(trace, e)
+}
+
+// locateError sets the line of the innermost call to the line of the
+// runtime error, the statement recorded by the tree-walker may be the
+// previous one (the loops are cancelled between their iterations).
+func locateError(trace []StackFrame, e interface{}) []StackFrame {
+
+	if rte, ok := e.(RuntimeError); ok && rte.Token != nil && len(trace) > 0 {
+		trace[0].Line = rte.Token.Line
+	}
+	return trace
+}
@@ -0,0 +1,158 @@
+package interp
+
+import "fmt"
+
+// valueKind tags the type of a lox value.
+type valueKind uint8
+
+const (
+	// nilKind is the kind of nil, the zero value.
+	nilKind valueKind = iota
+	// boolKind is the kind of true and false.
+	boolKind
+	// numberKind is the kind of numbers.
+	numberKind
+	// stringKind is the kind of strings.
+	stringKind
+	// objectKind is the kind of functions, classes, instances
+	// and native functions.
+	objectKind
+	// unassignedKind is the kind of the variables declared without
+	// initializer in strict initialization mode. It never escapes
+	// to lox code since reading it is an error.
+	unassignedKind
+)
+
+// loxValue represents a lox value.
+// Numbers and booleans are stored in num (a boolean is 1 or 0),
+// so arithmetic doesn't allocate like boxing a float64 into an
+// interface{} does. Strings and objects are stored in obj.
+// The zero value is nil.
+type loxValue struct {
+	kind valueKind
+	num  float64
+	obj  interface{}
+}
+
+// unassigned is the value of the variables declared without
+// initializer in strict initialization mode.
+var unassigned = loxValue{kind: unassignedKind}
+
+// numberValue creates a number value.
+func numberValue(n float64) loxValue {
+
+	return loxValue{kind: numberKind, num: n}
+}
+
+// boolValue creates a boolean value.
+func boolValue(b bool) loxValue {
+
+	if b {
+		return loxValue{kind: boolKind, num: 1}
+	}
+	return loxValue{kind: boolKind}
+}
+
+// stringValue creates a string value.
+func stringValue(s string) loxValue {
+
+	return loxValue{kind: stringKind, obj: s}
+}
+
+// objectValue creates a value for a function, a class, an instance
+// or a native function.
+func objectValue(obj interface{}) loxValue {
+
+	return loxValue{kind: objectKind, obj: obj}
+}
+
+// literalValue converts a literal stored in the AST (nil, bool,
+// float64 or string) to a value.
+func literalValue(lit interface{}) loxValue {
+
+	switch l := lit.(type) {
+	case bool:
+		return boolValue(l)
+	case float64:
+		return numberValue(l)
+	case string:
+		// reuse the interface of the AST instead of boxing
+		// the string again.
+		return loxValue{kind: stringKind, obj: lit}
+	default:
+		return loxValue{}
+	}
+}
+
+// isNil checks if the value is nil.
+func (v loxValue) isNil() bool {
+
+	return v.kind == nilKind
+}
+
+// isNumber checks if the value is a number.
+func (v loxValue) isNumber() bool {
+
+	return v.kind == numberKind
+}
+
+// isString checks if the value is a string.
+func (v loxValue) isString() bool {
+
+	return v.kind == stringKind
+}
+
+// asBool returns the boolean stored in the value.
+func (v loxValue) asBool() bool {
+
+	return v.num != 0
+}
+
+// asString returns the string stored in the value.
+func (v loxValue) asString() string {
+
+	return v.obj.(string)
+}
+
+// asInstance returns the instance stored in the value and
+// reports if the value is an instance.
+func (v loxValue) asInstance() (*loxInstance, bool) {
+
+	instance, ok := v.obj.(*loxInstance)
+	return instance, ok
+}
+
+// asClass returns the class stored in the value and reports
+// if the value is a class.
+func (v loxValue) asClass() (*loxClass, bool) {
+
+	class, ok := v.obj.(*loxClass)
+	return class, ok
+}
+
+// asCallable returns the function or class stored in the value
+// and reports if the value can be called.
+func (v loxValue) asCallable() (loxCallable, bool) {
+
+	callable, ok := v.obj.(loxCallable)
+	return callable, ok
+}
+
+// String returns the default representation of the value,
+// the interpreter options (like the number format) are not applied.
+// It is useful for debugging.
+func (v loxValue) String() string {
+
+	switch v.kind {
+	case nilKind:
+		return "nil"
+	case boolKind:
+		return fmt.Sprintf("%v", v.asBool())
+	case numberKind:
+		return fmt.Sprintf("%v", v.num)
+	case unassignedKind:
+		return "<unassigned>"
+	default:
+		return fmt.Sprintf("%v", v.obj)
+	}
+}
@@ -0,0 +1,746 @@
+package interp
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rmonnet/glox/bytecode"
+	"github.com/rmonnet/glox/lang"
+)
+
+// Backend selects how the interpreter executes the scripts.
+type Backend int
+
+const (
+	// TreeWalker evaluates the AST directly. It is the reference
+	// implementation of the language.
+	TreeWalker Backend = iota
+	// VM compiles the scripts to bytecode run by a stack-based
+	// virtual machine, which is much faster.
+	VM
+)
+
+// vm is the virtual machine running the scripts compiled to
+// bytecode. It shares the globals, the natives, the classes and
+// the instances with the tree-walker so both backends support the
+// same options and print the values the same way.
+// The steps and the cancellation are checked at each call and at
+// each iteration of a loop, which is enough to stop any script.
+type vm struct {
+	interp *Interp
+	stack  []loxValue
+	frames []callFrame
+	// openUpvalues are the captured variables still on the stack.
+	openUpvalues []*vmUpvalue
+}
+
+// callFrame is a function call in progress. Its local variables
+// are on the stack from base, where the function (or the instance
+// for a method) is.
+type callFrame struct {
+	closure *closure
+	ip      int
+	base    int
+	// record is the profile of the function, nil if the profiler
+	// is disabled.
+	record *profileRecord
+	start  time.Time
+}
+
+// closure is a function compiled to bytecode with the variables
+// it captured.
+type closure struct {
+	function  *bytecode.Function
+	constants []loxValue
+	upvalues  []*vmUpvalue
+}
+
+// String returns a string representation of a closure.
+func (c *closure) String() string {
+
+	return fmt.Sprintf("<fun %s>", c.function.Name)
+}
+
+// vmUpvalue is a variable captured by a closure. It stays on the
+// stack, at slot, as long as the block declaring it runs and moves
+// to closed when the block ends.
+type vmUpvalue struct {
+	slot   int
+	open   bool
+	closed loxValue
+}
+
+// boundMethod is a method tied to the instance it was read from.
+type boundMethod struct {
+	receiver loxValue
+	method   *closure
+}
+
+// String returns a string representation of a bound method.
+func (b *boundMethod) String() string {
+
+	return b.method.String()
+}
+
+// Compile scans, parses, resolves and compiles a script to bytecode
+// without running it. The errors are reported like in Run and the
+// result is false if there were any.
+func (i *Interp) Compile(script string) (*bytecode.Function, bool) {
+
+	statements, ok := i.Resolve(script)
+	if !ok {
+		return nil, false
+	}
+	return i.compile(statements)
+}
+
+// CompileOptions describes the options changing the diagnostics or
+// the code of Compile: the warnings, the strict modes and the
+// optimization passes, with the string limit of the constant
+// folding. The scripts compiled ahead of time record
+// them and are compiled again when they are run with other options.
+func (i *Interp) CompileOptions() string {
+
+	options := []string{fmt.Sprintf("warnings=%d", i.warningMode)}
+	if i.warnShadowing {
+		options = append(options, "Wshadow")
+	}
+	if i.strictGlobals {
+		options = append(options, "strict")
+	}
+	if i.strictRedeclare {
+		options = append(options, "strictRedeclare")
+	}
+	if i.implicitGlobals {
+		options = append(options, "implicitGlobals")
+	}
+	for _, pass := range i.optimizationPasses() {
+		options = append(options, fmt.Sprintf("%T", pass))
+		if folding, ok := pass.(lang.ConstantFolding); ok && folding.MaxStringLength > 0 {
+			options = append(options, fmt.Sprintf("maxStringLength=%d", folding.MaxStringLength))
+		}
+	}
+	return strings.Join(options, " ")
+}
+
+// compile compiles the resolved statements to bytecode, reporting
+// the errors like in Run.
+func (i *Interp) compile(statements []lang.Stmt) (*bytecode.Function, bool) {
+
+	start := time.Now()
+	function, diagnostics := bytecode.Compile(statements)
+	if i.stats != nil {
+		i.stats.Compiling += time.Since(start)
+	}
+	i.reportDiagnostics(diagnostics)
+	if function == nil {
+		for _, diagnostic := range diagnostics {
+			fmt.Fprintln(i.diagnosticOut(), i.formatter.Format(diagnostic))
+		}
+		i.hadCompileError = true
+		i.lastRunFailed = true
+		return nil, false
+	}
+	return function, true
+}
+
+// runVM compiles the resolved statements and runs them
+// on the virtual machine.
+func (i *Interp) runVM(statements []lang.Stmt) {
+
+	function, ok := i.compile(statements)
+	if !ok {
+		return
+	}
+	i.runFunction(function)
+}
+
+// RunCompiled runs a script compiled by Compile, or loaded from
+// a .loxc file, on the virtual machine whatever the backend.
+// The runtime errors are reported like in Run.
+func (i *Interp) RunCompiled(function *bytecode.Function) {
+
+	i.runtimeError = nil
+	i.trace = nil
+	i.lastRunFailed = false
+	i.compileErrors = nil
+	i.runFunction(function)
+}
+
+// runFunction runs the compiled script on the virtual machine.
+func (i *Interp) runFunction(function *bytecode.Function) {
+
+	if i.vm == nil {
+		i.vm = &vm{interp: i}
+	}
+	start := time.Now()
+	i.vm.interpret(function)
+	if i.stats != nil {
+		i.stats.Executing += time.Since(start)
+	}
+}
+
+// interpret runs the function compiled from a script.
+func (vm *vm) interpret(function *bytecode.Function) {
+
+	i := vm.interp
+	defer func() {
+		i.counters.end = time.Now()
+		if e := recover(); e != nil {
+			if i.traceCalls {
+				i.trace = vm.stackTrace(e)
+			}
+			// the closures which escaped may still reference
+			// variables on the stack.
+			vm.closeUpvalues(0)
+			vm.stack = vm.stack[:0]
+			vm.frames = vm.frames[:0]
+			rte, ok := e.(RuntimeError)
+			if !ok {
+				// an internal error, see SafeRun.
+				panic(e)
+			}
+			i.reportRuntimeError(rte)
+		}
+	}()
+
+	i.steps = 0
+	i.allocated = 0
+	i.counters = counters{start: time.Now()}
+	script := vm.newClosure(function)
+	vm.push(objectValue(script))
+	vm.frames = append(vm.frames, callFrame{closure: script})
+	vm.run()
+}
+
+// run executes the instructions until the script returns.
+func (vm *vm) run() {
+
+	i := vm.interp
+	frame := &vm.frames[len(vm.frames)-1]
+	chunk := &frame.closure.function.Chunk
+	code := chunk.Code
+
+	for {
+		// offset of the instruction, which locates the errors.
+		offset := frame.ip
+		op := bytecode.OpCode(code[offset])
+		frame.ip++
+
+		switch op {
+		case bytecode.OpConstant:
+			vm.push(frame.closure.constants[vm.readShort(frame, code)])
+		case bytecode.OpNil:
+			vm.push(loxValue{})
+		case bytecode.OpTrue:
+			vm.push(boolValue(true))
+		case bytecode.OpFalse:
+			vm.push(boolValue(false))
+		case bytecode.OpUnassigned:
+			if i.strictInit {
+				vm.push(unassigned)
+			} else {
+				vm.push(loxValue{})
+			}
+		case bytecode.OpPop:
+			vm.stack = vm.stack[:len(vm.stack)-1]
+		case bytecode.OpGetLocal:
+			value := vm.stack[frame.base+vm.readByte(frame, code)]
+			vm.push(checkAssigned(chunk.Tokens[offset], value))
+		case bytecode.OpSetLocal:
+			vm.stack[frame.base+vm.readByte(frame, code)] = vm.peek(0)
+		case bytecode.OpGetGlobal:
+			name := frame.closure.constants[vm.readShort(frame, code)].asString()
+			value, ok := i.globalEnv.values[name]
+			if !ok {
+				panic(undefinedVariable(chunk.Tokens[offset], i.globalEnv.names()))
+			}
+			vm.push(checkAssigned(chunk.Tokens[offset], value))
+		case bytecode.OpDefineGlobal:
+			name := frame.closure.constants[vm.readShort(frame, code)].asString()
+			i.globalEnv.define(name, vm.pop())
+		case bytecode.OpSetGlobal:
+			name := frame.closure.constants[vm.readShort(frame, code)].asString()
+			if _, ok := i.globalEnv.values[name]; !ok && !i.implicitGlobals {
+				panic(undefinedVariable(chunk.Tokens[offset], i.globalEnv.names()))
+			}
+			i.globalEnv.values[name] = vm.peek(0)
+		case bytecode.OpGetUpvalue:
+			u := frame.closure.upvalues[vm.readByte(frame, code)]
+			vm.push(checkAssigned(chunk.Tokens[offset], vm.upvalueValue(u)))
+		case bytecode.OpSetUpvalue:
+			u := frame.closure.upvalues[vm.readByte(frame, code)]
+			if u.open {
+				vm.stack[u.slot] = vm.peek(0)
+			} else {
+				u.closed = vm.peek(0)
+			}
+		case bytecode.OpGetProperty:
+			name := frame.closure.constants[vm.readShort(frame, code)].asString()
+			instance := vm.instance(vm.peek(0), chunk.Tokens[offset])
+			vm.stack[len(vm.stack)-1] = vm.property(instance, name, chunk.Tokens[offset])
+		case bytecode.OpSetProperty:
+			name := frame.closure.constants[vm.readShort(frame, code)].asString()
+			instance := vm.instance(vm.peek(1), chunk.Tokens[offset])
+			value := vm.pop()
+			i.setField(instance, chunk.Tokens[offset], name, value)
+			vm.stack[len(vm.stack)-1] = value
+		case bytecode.OpGetSuper:
+			name := frame.closure.constants[vm.readShort(frame, code)].asString()
+			superclass := vm.class(vm.pop(), chunk.Tokens[offset])
+			method := superMethod(superclass, name, chunk.Tokens[offset])
+			vm.stack[len(vm.stack)-1] = objectValue(&boundMethod{vm.peek(0), method})
+		case bytecode.OpEqual:
+			right := vm.pop()
+			vm.stack[len(vm.stack)-1] = boolValue(isEqual(vm.peek(0), right))
+		case bytecode.OpGreater:
+			left, right := vm.numbers(chunk.Tokens[offset])
+			vm.stack[len(vm.stack)-1] = boolValue(left > right)
+		case bytecode.OpGreaterEqual:
+			left, right := vm.numbers(chunk.Tokens[offset])
+			vm.stack[len(vm.stack)-1] = boolValue(left >= right)
+		case bytecode.OpLess:
+			left, right := vm.numbers(chunk.Tokens[offset])
+			vm.stack[len(vm.stack)-1] = boolValue(left < right)
+		case bytecode.OpLessEqual:
+			left, right := vm.numbers(chunk.Tokens[offset])
+			vm.stack[len(vm.stack)-1] = boolValue(left <= right)
+		case bytecode.OpAdd:
+			vm.add(chunk.Tokens[offset])
+		case bytecode.OpSubtract:
+			left, right := vm.numbers(chunk.Tokens[offset])
+			vm.stack[len(vm.stack)-1] = numberValue(left - right)
+		case bytecode.OpMultiply:
+			left, right := vm.numbers(chunk.Tokens[offset])
+			vm.stack[len(vm.stack)-1] = numberValue(left * right)
+		case bytecode.OpDivide:
+			token := chunk.Tokens[offset]
+			left, right := vm.numbers(token)
+			if right == 0 && !i.allowDivByZero {
+				panic(RuntimeError{token, CodeDivisionByZero, "Division by zero."})
+			}
+			vm.stack[len(vm.stack)-1] = numberValue(left / right)
+		case bytecode.OpNot:
+			vm.stack[len(vm.stack)-1] = boolValue(!i.isTruthy(vm.peek(0)))
+		case bytecode.OpNegate:
+			vm.stack[len(vm.stack)-1] = numberValue(-toNumber(chunk.Tokens[offset], vm.peek(0)))
+		case bytecode.OpPrint:
+			fmt.Fprintln(i.out, i.stringify(vm.pop()))
+		case bytecode.OpJump:
+			jump := vm.readShort(frame, code)
+			frame.ip += jump
+		case bytecode.OpJumpIfFalse:
+			jump := vm.readShort(frame, code)
+			if !i.isTruthy(vm.peek(0)) {
+				frame.ip += jump
+			}
+		case bytecode.OpLoop:
+			jump := vm.readShort(frame, code)
+			frame.ip -= jump
+			vm.checkLimits(chunk.Tokens[offset])
+		case bytecode.OpCall:
+			argCount := vm.readByte(frame, code)
+			vm.callValue(vm.peek(argCount), argCount, chunk.Tokens[offset])
+			frame = &vm.frames[len(vm.frames)-1]
+			chunk = &frame.closure.function.Chunk
+			code = chunk.Code
+		case bytecode.OpInvoke:
+			name := frame.closure.constants[vm.readShort(frame, code)].asString()
+			argCount := vm.readByte(frame, code)
+			vm.invoke(name, argCount, chunk.Tokens[offset+1], chunk.Tokens[offset])
+			frame = &vm.frames[len(vm.frames)-1]
+			chunk = &frame.closure.function.Chunk
+			code = chunk.Code
+		case bytecode.OpSuperInvoke:
+			name := frame.closure.constants[vm.readShort(frame, code)].asString()
+			argCount := vm.readByte(frame, code)
+			superclass := vm.class(vm.pop(), chunk.Tokens[offset+1])
+			method := superMethod(superclass, name, chunk.Tokens[offset+1])
+			vm.call(method, argCount, chunk.Tokens[offset])
+			frame = &vm.frames[len(vm.frames)-1]
+			chunk = &frame.closure.function.Chunk
+			code = chunk.Code
+		case bytecode.OpClosure:
+			index := vm.readShort(frame, code)
+			function := chunk.Constants[index].(*bytecode.Function)
+			closure := vm.newClosure(function)
+			for n := range closure.upvalues {
+				isLocal := vm.readByte(frame, code) == 1
+				index := vm.readByte(frame, code)
+				if isLocal {
+					closure.upvalues[n] = vm.captureUpvalue(frame.base + index)
+				} else {
+					closure.upvalues[n] = frame.closure.upvalues[index]
+				}
+			}
+			if i.hotRedefinition {
+				if name, ok := vm.definedGlobal(frame, code); ok {
+					closure = i.redefineClosure(name, closure)
+				}
+			}
+			vm.push(objectValue(closure))
+		case bytecode.OpCloseUpvalue:
+			vm.closeUpvalues(len(vm.stack) - 1)
+			vm.stack = vm.stack[:len(vm.stack)-1]
+		case bytecode.OpReturn:
+			result := vm.pop()
+			vm.closeUpvalues(frame.base)
+			if frame.record != nil {
+				frame.record.active--
+				if frame.record.active == 0 {
+					frame.record.entry.Time += time.Since(frame.start)
+				}
+			}
+			vm.stack = vm.stack[:frame.base]
+			vm.frames = vm.frames[:len(vm.frames)-1]
+			if len(vm.frames) == 0 {
+				return
+			}
+			vm.push(result)
+			frame = &vm.frames[len(vm.frames)-1]
+			chunk = &frame.closure.function.Chunk
+			code = chunk.Code
+		case bytecode.OpClass:
+			name := frame.closure.constants[vm.readShort(frame, code)].asString()
+			class := &loxClass{Name: name, closures: make(map[string]*closure)}
+			if _, ok := vm.definedGlobal(frame, code); ok && i.hotRedefinition {
+				// the methods are added to the previous class.
+				if previous := i.previousClass(name); previous != nil {
+					previous.clear()
+					class = previous
+				}
+			}
+			vm.push(objectValue(class))
+		case bytecode.OpInherit:
+			superclass, ok := vm.peek(1).asClass()
+			if !ok {
+				panic(RuntimeError{chunk.Tokens[offset], CodeSuperclassNotClass, "Superclass must be a class."})
+			}
+			class := vm.class(vm.pop(), chunk.Tokens[offset])
+			if i.hotRedefinition {
+				if superclass.inherits(class) {
+					panic(RuntimeError{chunk.Tokens[offset], CodeRuntimeSelfInheritance, "A class can't inherit from itself."})
+				}
+				class.setSuperclass(superclass)
+			} else {
+				class.Superclass = superclass
+			}
+			if class.replaced != nil {
+				class.setClosures(superclass.closures, class.closures)
+			} else {
+				for name, method := range superclass.closures {
+					class.closures[name] = method
+				}
+			}
+		case bytecode.OpMethod:
+			name := frame.closure.constants[vm.readShort(frame, code)].asString()
+			method := vm.pop().obj.(*closure)
+			class := vm.class(vm.peek(0), chunk.Tokens[offset])
+			if class.replaced != nil {
+				class.addMethod(name, method)
+			} else {
+				class.closures[name] = method
+			}
+		default:
+			panic(fmt.Sprintf("Unknown OpCode %d", op))
+		}
+	}
+}
+
+// ------------------
+// Calls
+// ------------------
+
+// callValue calls the value below the arguments on the stack.
+// Calling a class creates an instance, which replaces the class
+// on the stack and is passed to the initializer as 'this'.
+func (vm *vm) callValue(callee loxValue, argCount int, paren *lang.Token) {
+
+	i := vm.interp
+	switch f := callee.obj.(type) {
+	case *closure:
+		vm.call(f, argCount, paren)
+	case *boundMethod:
+		vm.stack[len(vm.stack)-argCount-1] = f.receiver
+		vm.call(f.method, argCount, paren)
+	case *loxClass:
+		i.allocate(paren, instanceSize)
+		i.counters.instances++
+		vm.stack[len(vm.stack)-argCount-1] = objectValue(newLoxInstance(f))
+		if initializer, ok := f.closures["init"]; ok {
+			vm.call(initializer, argCount, paren)
+		} else if argCount != 0 {
+			panic(RuntimeError{paren, CodeArity, fmt.Sprintf(
+				"Expected 0 arguments but got %d.", argCount)})
+		} else {
+			i.counters.calls++
+		}
+	case loxCallable:
+		if argCount != f.arity() {
+			panic(RuntimeError{paren, CodeArity, fmt.Sprintf(
+				"Expected %d arguments but got %d.", f.arity(), argCount)})
+		}
+		i.allocate(paren, envSize)
+		i.counters.calls++
+		args := vm.stack[len(vm.stack)-argCount:]
+		result := i.callNative(f, args, paren)
+		vm.stack = vm.stack[:len(vm.stack)-argCount-1]
+		vm.push(result)
+	default:
+		panic(RuntimeError{paren, CodeNotCallable, "Can only call functions and classes."})
+	}
+}
+
+// call starts the call of a closure, the function (or the instance)
+// and the arguments are on top of the stack.
+func (vm *vm) call(c *closure, argCount int, paren *lang.Token) {
+
+	i := vm.interp
+	if argCount != c.function.Arity {
+		panic(RuntimeError{paren, CodeArity, fmt.Sprintf(
+			"Expected %d arguments but got %d.", c.function.Arity, argCount)})
+	}
+	// the frame of the script is not a call.
+	if i.maxCallDepth > 0 && len(vm.frames) > i.maxCallDepth {
+		panic(RuntimeError{paren, CodeStackOverflow, "Stack overflow."})
+	}
+	i.allocate(paren, envSize)
+	i.counters.calls++
+	vm.checkLimits(paren)
+
+	frame := callFrame{closure: c, base: len(vm.stack) - argCount - 1}
+	if i.profiling {
+		frame.record = i.profileRecord(c.function, c.function.Name, c.function.Line)
+		frame.record.entry.Calls++
+		frame.record.active++
+		frame.start = time.Now()
+	}
+	vm.frames = append(vm.frames, frame)
+}
+
+// invoke calls a method of the instance below the arguments
+// without creating a bound method. A field holding a function
+// shadows the method.
+func (vm *vm) invoke(name string, argCount int, nameToken, paren *lang.Token) {
+
+	receiver := vm.peek(argCount)
+	instance := vm.instance(receiver, nameToken)
+	if value, ok := instance.fields[name]; ok {
+		vm.stack[len(vm.stack)-argCount-1] = value
+		vm.callValue(value, argCount, paren)
+		return
+	}
+	method, ok := instance.class.closures[name]
+	if !ok {
+		panic(instance.undefinedProperty(nameToken))
+	}
+	vm.call(method, argCount, paren)
+}
+
+// newClosure creates a closure of the function, its upvalues are
+// set by the caller.
+func (vm *vm) newClosure(function *bytecode.Function) *closure {
+
+	return &closure{function, constants(function), make([]*vmUpvalue, function.UpvalueCount)}
+}
+
+// constants returns the constants of the function converted to
+// values, they are converted the first time.
+func constants(function *bytecode.Function) []loxValue {
+
+	constants, ok := function.Cache.([]loxValue)
+	if !ok {
+		constants = make([]loxValue, len(function.Chunk.Constants))
+		for index, constant := range function.Chunk.Constants {
+			constants[index] = literalValue(constant)
+		}
+		function.Cache = constants
+	}
+	return constants
+}
+
+// ------------------
+// Helper functions
+// ------------------
+
+// push pushes a value on the stack.
+func (vm *vm) push(value loxValue) {
+
+	vm.stack = append(vm.stack, value)
+}
+
+// pop removes the value on top of the stack and returns it.
+func (vm *vm) pop() loxValue {
+
+	value := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return value
+}
+
+// peek returns the value distance slots below the top of the stack.
+func (vm *vm) peek(distance int) loxValue {
+
+	return vm.stack[len(vm.stack)-1-distance]
+}
+
+// readByte reads a one byte operand.
+func (vm *vm) readByte(frame *callFrame, code []byte) int {
+
+	frame.ip++
+	return int(code[frame.ip-1])
+}
+
+// readShort reads a two bytes operand.
+func (vm *vm) readShort(frame *callFrame, code []byte) int {
+
+	frame.ip += 2
+	return int(code[frame.ip-2])<<8 | int(code[frame.ip-1])
+}
+
+// numbers pops the right operand of an arithmetic or comparison
+// operator and returns both operands, the left one is replaced
+// by the result.
+func (vm *vm) numbers(operator *lang.Token) (float64, float64) {
+
+	left := toNumber(operator, vm.peek(1))
+	right := toNumber(operator, vm.peek(0))
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return left, right
+}
+
+// add adds the two numbers or concatenates the strings on top
+// of the stack, like the '+' operator of the tree-walker.
+func (vm *vm) add(operator *lang.Token) {
+
+	i := vm.interp
+	left, right := vm.peek(1), vm.peek(0)
+	var result loxValue
+	switch {
+	case left.isNumber() && right.isNumber():
+		result = numberValue(left.num + right.num)
+	case i.noCoercion && !(left.isString() && right.isString()):
+		panic(RuntimeError{operator, CodeOperandsNotNumbersOrStrings,
+			"Operands must be two numbers or two strings."})
+	case left.isString() || right.isString():
+		result = i.concatenate(operator, left, right)
+	default:
+		panic(RuntimeError{operator, CodeOperandsNotNumbersOrString,
+			"Operands must be two numbers or at least one string."})
+	}
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	vm.stack[len(vm.stack)-1] = result
+}
+
+// instance returns the instance whose property is accessed, the
+// token is the name of the property.
+func (vm *vm) instance(value loxValue, name *lang.Token) *loxInstance {
+
+	instance, ok := value.asInstance()
+	if !ok {
+		panic(RuntimeError{name, CodeNotInstance, "Only class instances have fields."})
+	}
+	return instance
+}
+
+// class returns the class of a class declaration. The compiler
+// only emits the class instructions on classes, but the compiled
+// scripts loaded from files may have been tampered with.
+func (vm *vm) class(value loxValue, token *lang.Token) *loxClass {
+
+	class, ok := value.asClass()
+	if !ok {
+		panic(RuntimeError{token, CodeInvalidBytecode, "Invalid compiled script."})
+	}
+	return class
+}
+
+// property returns the field or the method (bound to the instance)
+// named name.
+func (vm *vm) property(instance *loxInstance, name string, token *lang.Token) loxValue {
+
+	if value, ok := instance.fields[name]; ok {
+		return value
+	}
+	if method, ok := instance.class.closures[name]; ok {
+		return objectValue(&boundMethod{objectValue(instance), method})
+	}
+	panic(instance.undefinedProperty(token))
+}
+
+// superMethod returns the method of the superclass called
+// through 'super'.
+func superMethod(superclass *loxClass, name string, token *lang.Token) *closure {
+
+	method, ok := superclass.closures[name]
+	if !ok {
+		panic(RuntimeError{token, CodeUndefinedMethod,
+			fmt.Sprintf("Undefined method '%s'.", name) +
+				didYouMean(name, superclass.methodNames())})
+	}
+	return method
+}
+
+// checkAssigned reports an error if the variable read at the token
+// was declared without initializer and never assigned.
+func checkAssigned(name *lang.Token, value loxValue) loxValue {
+
+	if value.kind == unassignedKind {
+		panic(RuntimeError{name, CodeUninitializedVariable, fmt.Sprintf(
+			"Variable '%s' used before assignment.", name.Lexeme)})
+	}
+	return value
+}
+
+// checkLimits charges a step to the execution budget and checks
+// the context controlling the execution is not cancelled.
+func (vm *vm) checkLimits(token *lang.Token) {
+
+	i := vm.interp
+	if i.maxSteps > 0 {
+		i.step(token)
+	}
+	if i.done != nil {
+		i.checkCancelled(token)
+	}
+}
+
+// upvalueValue returns the value of a captured variable.
+func (vm *vm) upvalueValue(u *vmUpvalue) loxValue {
+
+	if u.open {
+		return vm.stack[u.slot]
+	}
+	return u.closed
+}
+
+// captureUpvalue returns the upvalue capturing the variable at the
+// slot of the stack, the closures capturing the same variable
+// share it.
+func (vm *vm) captureUpvalue(slot int) *vmUpvalue {
+
+	for _, u := range vm.openUpvalues {
+		if u.slot == slot {
+			return u
+		}
+	}
+	u := &vmUpvalue{slot: slot, open: true}
+	vm.openUpvalues = append(vm.openUpvalues, u)
+	return u
+}
+
+// closeUpvalues moves the captured variables from the slot last
+// and above off the stack, since they are about to be popped.
+func (vm *vm) closeUpvalues(last int) {
+
+	open := vm.openUpvalues[:0]
+	for _, u := range vm.openUpvalues {
+		if u.slot >= last {
+			u.closed = vm.stack[u.slot]
+			u.open = false
+		} else {
+			open = append(open, u)
+		}
+	}
+	vm.openUpvalues = open
+}
@@ -0,0 +1,300 @@
+package interp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/rmonnet/glox/bytecode"
+	"github.com/rmonnet/glox/lang"
+)
+
+// vmScripts are run by both backends, which must print the same
+// output and report the same errors.
+var vmScripts = []string{
+	`print 1 + 2 * 3 - 4 / 2;
+	 print "a" + 1;
+	 print 1 + "a" + true + nil;
+	 print -(3);
+	 print !nil;
+	 print 1 < 2; print 2 <= 2; print 1 > 2; print 2 >= 3;
+	 print 1 == 1; print "a" != "a"; print nil == false;
+	 print 0/0 >= 0/0; print 0/0 != 0/0;`,
+	`print nil or "default"; print false and 1; print 1 and 2; print nil or false;`,
+	`var a = 1;
+	 { var a = 2; { var a = 3; print a; } print a; }
+	 print a;
+	 a = 4; print a;
+	 var b; print b;`,
+	`var i = 0;
+	 while (i < 3) { print i; i = i + 1; }
+	 for (var j = 0; j < 3; j = j + 1) { if (j == 1) print "one"; else print j; }`,
+	`fun add(a, b) { return a + b; }
+	 fun noReturn() { }
+	 print add(1, 2);
+	 print noReturn();
+	 print add;
+	 print clock;`,
+	`fun makeCounter() {
+		var count = 0;
+		fun counter() { count = count + 1; return count; }
+		return counter;
+	 }
+	 var c1 = makeCounter(); var c2 = makeCounter();
+	 print c1(); print c1(); print c2();`,
+	`var getters;
+	 var setters;
+	 {
+		var shared = "before";
+		fun get() { return shared; }
+		fun set(v) { shared = v; }
+		getters = get; setters = set;
+	 }
+	 setters("after");
+	 print getters();`,
+	`fun outer() {
+		var x = "outer";
+		fun middle() {
+			fun inner() { return x; }
+			return inner;
+		}
+		return middle;
+	 }
+	 print outer()()();`,
+	`var closures;
+	 for (var i = 0; i < 3; i = i + 1) {
+		var j = i;
+		fun f() { return j; }
+		if (i == 1) closures = f;
+	 }
+	 print closures();`,
+	`class Point {
+		init(x, y) { this.x = x; this.y = y; }
+		sum() { return this.x + this.y; }
+	 }
+	 var p = Point(1, 2);
+	 print p.sum();
+	 print p;
+	 print Point;
+	 var m = p.sum;
+	 p.x = 10;
+	 print m();
+	 print p.init(3, 4);
+	 print p.x;`,
+	`class A {
+		method() { return "A method"; }
+		name() { return "A"; }
+	 }
+	 class B < A {
+		method() { return "B method then " + super.method(); }
+		name() { var s = super.name; return s() + "B"; }
+	 }
+	 class C < B {}
+	 print C().method();
+	 print C().name();`,
+	`class Box { init() { this.f = nil; } call() { return "method"; } }
+	 fun field() { return "field"; }
+	 var b = Box();
+	 print b.call();
+	 b.call = field;
+	 print b.call();`,
+	`class Node {
+		init(value) { this.value = value; this.next = nil; }
+	 }
+	 var list = Node(1);
+	 list.next = Node(2);
+	 print list.next.value;
+	 print deepEquals(Node(1), Node(1));
+	 print list; print Node; print list.init;`,
+	`print undefined;`,
+	`var a = "a"; print -a;`,
+	`print 1 + nil;`,
+	`print 1 / 0;`,
+	`fun f(a) {} f(1, 2);`,
+	`class A {} A(1);`,
+	`var x = 1; x();`,
+	`var x = 1; print x.field;`,
+	`class A {} print A().missing;`,
+	`var NotAClass = 1; class B < NotAClass {}`,
+	`fun recurse() { recurse(); } recurse();`,
+	`class A { method() {} } class B < A { test() { super.nothing(); } } B().test();`,
+	`undefined = 1;`,
+}
+
+func TestVM(t *testing.T) {
+
+	for _, script := range vmScripts {
+		expected := &strings.Builder{}
+		New(expected, expected).Run(script, false)
+
+		actual := &strings.Builder{}
+		i := New(actual, actual)
+		i.SetBackend(VM)
+		i.Run(script, false)
+		if actual.String() != expected.String() {
+			t.Errorf("%s\nexpected:\n%s\ngot:\n%s", script, expected, actual)
+		}
+	}
+}
+
+func TestVMOptions(t *testing.T) {
+
+	scripts := []struct {
+		script string
+		setup  func(*Interp)
+	}{
+		{`var a; print a;`, func(i *Interp) { i.SetStrictInitialization(true) }},
+		{`fun f() { var a; fun g() { return a; } return g; } print f()();`,
+			func(i *Interp) { i.SetStrictInitialization(true) }},
+		{`print 1 / 0; print -1 / 0;`, func(i *Interp) { i.SetAllowDivisionByZero(true) }},
+		{`print "a" + 1;`, func(i *Interp) { i.SetStringCoercion(false) }},
+		{`print 10000000;`, func(i *Interp) { i.SetJloxNumberFormat(true) }},
+		{`class A { init() { this.x = 1; } } print A();`,
+			func(i *Interp) { i.SetPrintFields(true) }},
+		{`if (0) print "true"; else print "false";`,
+			func(i *Interp) { i.SetLooseTruthiness(true) }},
+		{`fun f(n) { return f(n + 1); } f(0);`, func(i *Interp) { i.SetMaxCallDepth(10) }},
+		{`while (true) {}`, func(i *Interp) { i.SetMaxSteps(100) }},
+		{`var s = ""; while (true) { s = s + "abc"; }`, func(i *Interp) { i.SetMaxMemory(1000) }},
+		{`print x;`, func(i *Interp) { i.Define("x", 42.0) }},
+	}
+
+	for _, s := range scripts {
+		expected := &strings.Builder{}
+		tree := New(expected, expected)
+		s.setup(tree)
+		tree.Run(s.script, false)
+
+		actual := &strings.Builder{}
+		vm := New(actual, actual)
+		s.setup(vm)
+		vm.SetBackend(VM)
+		vm.Run(s.script, false)
+		if actual.String() != expected.String() {
+			t.Errorf("%s\nexpected:\n%s\ngot:\n%s", s.script, expected, actual)
+		}
+	}
+}
+
+func TestVMRuns(t *testing.T) {
+
+	// the globals, including the closures which captured a variable
+	// of a block interrupted by an error, survive across runs.
+	out := &strings.Builder{}
+	i := New(out, out)
+	i.SetBackend(VM)
+	i.Run(`
+		var get;
+		{
+			var captured = "captured";
+			fun f() { return captured; }
+			get = f;
+			print nil + 1;
+		}
+	`, false)
+	i.Run(`print "pad" + "ding"; print get();`, false)
+	i.Run(`class A { m() { return "m"; } } var a = A();`, false)
+	i.Run(`print a.m();`, false)
+
+	expected := "[line 7] Operands must be two numbers or at least one string.\n" +
+		"padding\ncaptured\nm\n"
+	if out.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out)
+	}
+}
+
+func TestVMCorruptedScripts(t *testing.T) {
+
+	// the scripts with a changed instruction are either rejected by
+	// bytecode.Load or run without crashing the virtual machine.
+	random := rand.New(rand.NewSource(1))
+	for _, script := range vmScripts {
+		function, ok := New(ioutil.Discard, ioutil.Discard).Compile(script)
+		if !ok {
+			continue
+		}
+		var saved bytes.Buffer
+		bytecode.Save(&saved, function)
+		for n := 0; n < 100; n++ {
+			corrupted, _ := bytecode.Load(bytes.NewReader(saved.Bytes()))
+			code := randomFunction(random, corrupted).Chunk.Code
+			if len(code) == 0 {
+				continue
+			}
+			code[random.Intn(len(code))] = byte(random.Intn(256))
+
+			var buf bytes.Buffer
+			bytecode.Save(&buf, corrupted)
+			loaded, err := bytecode.Load(&buf)
+			if err != nil {
+				continue
+			}
+			runCorrupted(t, script, loaded)
+		}
+	}
+}
+
+// ------------------
+// Helper functions
+// ------------------
+
+// randomFunction returns the function or one of the functions
+// it declares.
+func randomFunction(random *rand.Rand, function *bytecode.Function) *bytecode.Function {
+
+	functions := []*bytecode.Function{function}
+	for n := 0; n < len(functions); n++ {
+		for _, constant := range functions[n].Chunk.Constants {
+			if f, ok := constant.(*bytecode.Function); ok {
+				functions = append(functions, f)
+			}
+		}
+	}
+	return functions[random.Intn(len(functions))]
+}
+
+// runCorrupted runs a corrupted script, failing the test if
+// the virtual machine panics.
+func runCorrupted(t *testing.T, script string, function *bytecode.Function) {
+
+	t.Helper()
+	defer func() {
+		if e := recover(); e != nil {
+			t.Errorf("%s\npanicked: %v", script, e)
+		}
+	}()
+	i := New(ioutil.Discard, ioutil.Discard)
+	// the corrupted scripts may loop or recurse forever.
+	i.SetMaxSteps(10000)
+	i.SetMaxCallDepth(100)
+	i.SetMaxMemory(1 << 20)
+	i.RunCompiled(function)
+}
+
+func TestVMInvalidBytecode(t *testing.T) {
+
+	// super.a on values which are not classes.
+	token := &lang.Token{Type: lang.IdentifierToken, Lexeme: "a", Line: 1}
+	code := []byte{byte(bytecode.OpNil), byte(bytecode.OpNil),
+		byte(bytecode.OpGetSuper), 0, 0, byte(bytecode.OpReturn)}
+	tokens := make([]*lang.Token, len(code))
+	for n := range tokens {
+		tokens[n] = token
+	}
+	function := &bytecode.Function{Chunk: bytecode.Chunk{
+		Code: code, Constants: []interface{}{"a"}, Tokens: tokens}}
+
+	var saved bytes.Buffer
+	bytecode.Save(&saved, function)
+	loaded, err := bytecode.Load(&saved)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	i := New(ioutil.Discard, ioutil.Discard)
+	i.RunCompiled(loaded)
+	if err := i.RuntimeError(); err == nil || err.Code != CodeInvalidBytecode {
+		t.Errorf("expected an invalid bytecode error, got %v", err)
+	}
+}
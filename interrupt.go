@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/rmonnet/glox/interp"
+)
+
+// trapSignals cancels the execution of the scripts when the process
+// receives SIGINT or SIGTERM, so the interpreter can report where
+// they were stopped instead of dying silently. A second signal kills
+// the process as usual. It returns a function reporting the signal
+// received, nil if none.
+func trapSignals(lox *interp.Interp) func() os.Signal {
+
+	ctx, cancel := context.WithCancel(lox.Context())
+	lox.SetContext(ctx)
+	lox.SetStackTraces(true)
+
+	signals := make(chan os.Signal, 1)
+	caught := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		signal.Stop(signals)
+		caught <- sig
+		cancel()
+	}()
+	return func() os.Signal {
+		select {
+		case sig := <-caught:
+			return sig
+		default:
+			return nil
+		}
+	}
+}
+
+// exitOnSignal prints the calls active when a signal stopped the
+// scripts and exits with 128 plus the number of the signal, like
+// the shells do.
+func exitOnSignal(lox *interp.Interp, sig os.Signal) {
+
+	fmt.Fprintf(os.Stderr, "Interrupted (%v), stack trace:\n", sig)
+	for _, frame := range lox.StackTrace() {
+		fmt.Fprintf(os.Stderr, "  at %s (line %d)\n", frame.Function, frame.Line)
+	}
+	lox.WriteProfile(os.Stderr)
+	stopProfiling()
+	code := 128 + int(syscall.SIGINT)
+	if s, ok := sig.(syscall.Signal); ok {
+		code = 128 + int(s)
+	}
+	os.Exit(code)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/rmonnet/glox/kernel"
+)
+
+// runKernel runs the "glox kernel" subcommand, a Jupyter kernel
+// started by Jupyter with its connection file. With -install, it
+// installs the kernel spec of glox for the current user instead.
+func runKernel(args []string) {
+
+	flags := flag.NewFlagSet("kernel", flag.ExitOnError)
+	install := flags.Bool("install", false, "install the kernel spec for the current user")
+	flags.Parse(args)
+
+	if *install {
+		if flags.NArg() != 0 {
+			fmt.Println("Usage glox kernel -install")
+			os.Exit(exUsage)
+		}
+		dir, err := installKernelSpec()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "glox kernel:", err)
+			os.Exit(exSwErr)
+		}
+		fmt.Println("Installed the lox kernel in", dir)
+		return
+	}
+
+	if flags.NArg() != 1 {
+		fmt.Println("Usage glox kernel connection_file")
+		os.Exit(exUsage)
+	}
+	data, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Println("unable to read ", flags.Arg(0))
+		os.Exit(exDataErr)
+	}
+	var info kernel.ConnectionInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		fmt.Println("invalid connection file", err)
+		os.Exit(exDataErr)
+	}
+	if err := kernel.Serve(info); err != nil {
+		fmt.Fprintln(os.Stderr, "glox kernel:", err)
+		os.Exit(exSwErr)
+	}
+}
+
+// installKernelSpec writes the kernel spec of glox in the kernels
+// directory of Jupyter for the current user, it returns the
+// directory of the spec.
+func installKernelSpec() (string, error) {
+
+	executable, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	var dir string
+	switch runtime.GOOS {
+	case "darwin":
+		dir = filepath.Join(home, "Library", "Jupyter", "kernels", "lox")
+	case "windows":
+		dir = filepath.Join(os.Getenv("APPDATA"), "jupyter", "kernels", "lox")
+	default:
+		dir = filepath.Join(home, ".local", "share", "jupyter", "kernels", "lox")
+	}
+	spec, err := json.MarshalIndent(map[string]interface{}{
+		"argv":         []string{executable, "kernel", "{connection_file}"},
+		"display_name": "Lox",
+		"language":     "lox",
+	}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return dir, ioutil.WriteFile(filepath.Join(dir, "kernel.json"), spec, 0644)
+}
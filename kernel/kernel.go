@@ -0,0 +1,407 @@
+// Package kernel implements a Jupyter kernel for lox, so the scripts
+// can be written and run in notebooks: the cells run one after the
+// other in the same interpreter (the globals of a cell are visible
+// from the next ones, like in the REPL), their output is shown under
+// them, and so are the compile and runtime errors with their line.
+//
+// The kernel speaks the Jupyter messaging protocol (version 5.3)
+// over ZMTP, the wire protocol of ZeroMQ, implemented in pure Go
+// (see zmtp.go). It answers the kernel_info, execute, complete,
+// is_complete, comm_info, history, interrupt and shutdown requests.
+// The interrupt requests stop the running cell.
+package kernel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/rmonnet/glox/interp"
+)
+
+// kernel holds the state of a kernel. The mutex serializes the
+// requests, the interrupt requests excepted.
+type kernel struct {
+	mu      sync.Mutex
+	signer  signer
+	lox     *interp.Interp
+	out     bytes.Buffer
+	errOut  bytes.Buffer
+	count   int
+	sockets []net.Listener
+
+	// cancel stops the running cell, it is protected by cancelMu
+	// since the interrupt requests don't wait for the cell.
+	cancelMu sync.Mutex
+	cancel   context.CancelFunc
+
+	// subscribers are the connections to the iopub socket.
+	subscribersMu sync.Mutex
+	subscribers   []*zmtpConn
+
+	// conns are the open connections, closed with the sockets
+	// by stop. connsMu also protects sockets.
+	connsMu sync.Mutex
+	conns   map[net.Conn]bool
+
+	// served waits for the goroutines serving the sockets.
+	served sync.WaitGroup
+
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+// Serve runs a kernel listening on the ports of the connection
+// file. It returns when a shutdown request is received or if a
+// port can't be listened on, once its sockets and connections
+// are closed.
+func Serve(info ConnectionInfo) error {
+
+	if info.Transport != "" && info.Transport != "tcp" {
+		return fmt.Errorf("unsupported transport %s", info.Transport)
+	}
+	if info.SignatureScheme != "" && info.SignatureScheme != "hmac-sha256" {
+		return fmt.Errorf("unsupported signature scheme %s", info.SignatureScheme)
+	}
+	k := &kernel{signer: signer{[]byte(info.Key)}, conns: make(map[net.Conn]bool),
+		done: make(chan struct{})}
+	k.lox = interp.New(&k.out, &k.errOut)
+	defer func() {
+		k.stop()
+		k.served.Wait()
+	}()
+
+	sockets := []struct {
+		port       int
+		socketType string
+		serve      func(z *zmtpConn)
+	}{
+		{info.ShellPort, "ROUTER", k.serveRequests},
+		{info.ControlPort, "ROUTER", k.serveRequests},
+		{info.StdinPort, "ROUTER", k.discard},
+		{info.IOPubPort, "PUB", k.subscribe},
+		{info.HBPort, "REP", k.heartbeat},
+	}
+	for _, socket := range sockets {
+		address := net.JoinHostPort(info.IP, strconv.Itoa(socket.port))
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			return err
+		}
+		k.connsMu.Lock()
+		k.sockets = append(k.sockets, listener)
+		k.connsMu.Unlock()
+		k.served.Add(1)
+		go k.accept(listener, socket.socketType, socket.serve)
+	}
+	<-k.done
+	return nil
+}
+
+// stop closes the sockets and the connections and stops the
+// running cell.
+func (k *kernel) stop() {
+
+	k.doneOnce.Do(func() { close(k.done) })
+	k.connsMu.Lock()
+	for _, listener := range k.sockets {
+		listener.Close()
+	}
+	for conn := range k.conns {
+		conn.Close()
+	}
+	k.connsMu.Unlock()
+	k.interrupt()
+}
+
+// track records an open connection, to close it when the kernel
+// stops. It reports false if the kernel is already stopped.
+func (k *kernel) track(conn net.Conn) bool {
+
+	k.connsMu.Lock()
+	defer k.connsMu.Unlock()
+	select {
+	case <-k.done:
+		return false
+	default:
+	}
+	k.conns[conn] = true
+	return true
+}
+
+// untrack forgets a closed connection.
+func (k *kernel) untrack(conn net.Conn) {
+
+	k.connsMu.Lock()
+	defer k.connsMu.Unlock()
+	delete(k.conns, conn)
+}
+
+// accept serves each connection to a socket in its own goroutine.
+func (k *kernel) accept(listener net.Listener, socketType string, serve func(z *zmtpConn)) {
+
+	defer k.served.Done()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		if !k.track(conn) {
+			conn.Close()
+			return
+		}
+		k.served.Add(1)
+		go func() {
+			defer k.served.Done()
+			defer k.untrack(conn)
+			z, err := handshake(conn, socketType, true)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			defer z.close()
+			serve(z)
+		}()
+	}
+}
+
+// serveRequests answers the requests of a shell or control connection.
+func (k *kernel) serveRequests(z *zmtpConn) {
+
+	for {
+		frames, err := z.readMessage()
+		if err != nil {
+			return
+		}
+		msg, err := k.signer.decode(frames)
+		if err != nil {
+			// the invalid messages are ignored, like with ipykernel.
+			continue
+		}
+		if msg.Header.MsgType == "interrupt_request" {
+			k.interrupt()
+			k.reply(z, msg, "interrupt_reply", map[string]string{"status": "ok"})
+			continue
+		}
+		k.mu.Lock()
+		k.publish(msg, "status", map[string]string{"execution_state": "busy"})
+		shutdown := k.handle(z, msg)
+		k.publish(msg, "status", map[string]string{"execution_state": "idle"})
+		k.mu.Unlock()
+		if shutdown {
+			k.stop()
+			return
+		}
+	}
+}
+
+// handle answers a request, it reports if the kernel must shut down.
+func (k *kernel) handle(z *zmtpConn, msg *message) bool {
+
+	switch msg.Header.MsgType {
+	case "kernel_info_request":
+		k.reply(z, msg, "kernel_info_reply", kernelInfoReply{
+			Status:                "ok",
+			ProtocolVersion:       protocolVersion,
+			Implementation:        "glox",
+			ImplementationVersion: "1.0",
+			LanguageInfo: languageInfo{Name: "lox", Version: "1.0",
+				Mimetype: "text/x-lox", FileExtension: ".lox"},
+			Banner: "glox, the lox interpreter of Crafting Interpreters in Go",
+		})
+	case "execute_request":
+		var request executeRequest
+		json.Unmarshal(msg.Content, &request)
+		k.execute(z, msg, request)
+	case "complete_request":
+		var request completeRequest
+		json.Unmarshal(msg.Content, &request)
+		k.reply(z, msg, "complete_reply", k.complete(request))
+	case "is_complete_request":
+		k.reply(z, msg, "is_complete_reply", map[string]string{"status": "unknown"})
+	case "comm_info_request":
+		k.reply(z, msg, "comm_info_reply", map[string]interface{}{
+			"status": "ok", "comms": map[string]interface{}{}})
+	case "history_request":
+		k.reply(z, msg, "history_reply", map[string]interface{}{
+			"status": "ok", "history": []interface{}{}})
+	case "shutdown_request":
+		var request shutdownRequest
+		json.Unmarshal(msg.Content, &request)
+		k.reply(z, msg, "shutdown_reply", map[string]interface{}{
+			"status": "ok", "restart": request.Restart})
+		return true
+	}
+	return false
+}
+
+// execute runs the code of a cell in the interpreter, publishes its
+// output and its errors and replies with the status of the run.
+func (k *kernel) execute(z *zmtpConn, msg *message, request executeRequest) {
+
+	if !request.Silent {
+		k.count++
+	}
+	k.publish(msg, "execute_input", map[string]interface{}{
+		"code": request.Code, "execution_count": k.count})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	k.cancelMu.Lock()
+	k.cancel = cancel
+	k.cancelMu.Unlock()
+	k.lox.SetContext(ctx)
+	k.out.Reset()
+	k.errOut.Reset()
+	k.lox.Run(request.Code, false)
+	k.interrupt()
+
+	if k.out.Len() > 0 && !request.Silent {
+		k.publish(msg, "stream", map[string]string{"name": "stdout", "text": k.out.String()})
+	}
+	if !k.lox.LastRunFailed() {
+		// the warnings.
+		if k.errOut.Len() > 0 && !request.Silent {
+			k.publish(msg, "stream", map[string]string{"name": "stderr", "text": k.errOut.String()})
+		}
+		k.reply(z, msg, "execute_reply", map[string]interface{}{
+			"status": "ok", "execution_count": k.count,
+			"user_expressions": map[string]interface{}{}, "payload": []interface{}{}})
+		return
+	}
+
+	failure := errorContent{Ename: "CompileError"}
+	if k.lox.RuntimeError() != nil {
+		failure.Ename = "RuntimeError"
+	}
+	failure.Traceback = strings.Split(strings.TrimRight(k.errOut.String(), "\n"), "\n")
+	failure.Evalue = failure.Traceback[0]
+	if !request.Silent {
+		k.publish(msg, "error", failure)
+	}
+	failure.Status = "error"
+	failure.ExecutionCount = k.count
+	k.reply(z, msg, "execute_reply", failure)
+}
+
+// interrupt stops the running cell, if any.
+func (k *kernel) interrupt() {
+
+	k.cancelMu.Lock()
+	defer k.cancelMu.Unlock()
+	if k.cancel != nil {
+		k.cancel()
+		k.cancel = nil
+	}
+}
+
+// complete returns the globals starting with the name before the cursor.
+func (k *kernel) complete(request completeRequest) map[string]interface{} {
+
+	code := []rune(request.Code)
+	end := request.CursorPos
+	if end < 0 || end > len(code) {
+		end = len(code)
+	}
+	start := end
+	for start > 0 && (unicode.IsLetter(code[start-1]) || unicode.IsDigit(code[start-1]) ||
+		code[start-1] == '_') {
+		start--
+	}
+	prefix := string(code[start:end])
+	matches := []string{}
+	if prefix != "" {
+		for _, name := range k.lox.GlobalNames() {
+			if strings.HasPrefix(name, prefix) {
+				matches = append(matches, name)
+			}
+		}
+	}
+	return map[string]interface{}{"status": "ok", "matches": matches,
+		"cursor_start": start, "cursor_end": end, "metadata": map[string]interface{}{}}
+}
+
+// reply sends the reply of a request on its connection.
+func (k *kernel) reply(z *zmtpConn, parent *message, msgType string, content interface{}) {
+
+	msg, err := newMessage(parent, msgType, content)
+	if err != nil {
+		return
+	}
+	frames, err := k.signer.encode(msg)
+	if err != nil {
+		return
+	}
+	z.writeMessage(frames)
+}
+
+// publish sends a message to the iopub subscribers, its topic
+// is its type.
+func (k *kernel) publish(parent *message, msgType string, content interface{}) {
+
+	msg, err := newMessage(parent, msgType, content)
+	if err != nil {
+		return
+	}
+	msg.identities = [][]byte{[]byte(msgType)}
+	frames, err := k.signer.encode(msg)
+	if err != nil {
+		return
+	}
+	k.subscribersMu.Lock()
+	subscribers := append([]*zmtpConn{}, k.subscribers...)
+	k.subscribersMu.Unlock()
+	for _, subscriber := range subscribers {
+		subscriber.writeMessage(frames)
+	}
+}
+
+// subscribe adds an iopub connection to the subscribers until it is
+// closed. The subscriptions are ignored: the clients subscribe to
+// all the messages.
+func (k *kernel) subscribe(z *zmtpConn) {
+
+	k.subscribersMu.Lock()
+	k.subscribers = append(k.subscribers, z)
+	k.subscribersMu.Unlock()
+	k.discard(z)
+	k.subscribersMu.Lock()
+	defer k.subscribersMu.Unlock()
+	for i, subscriber := range k.subscribers {
+		if subscriber == z {
+			k.subscribers = append(k.subscribers[:i], k.subscribers[i+1:]...)
+			break
+		}
+	}
+}
+
+// discard reads the messages of a connection until it is closed,
+// like the input requested on the stdin socket, which the kernel
+// doesn't use.
+func (k *kernel) discard(z *zmtpConn) {
+
+	for {
+		if _, err := z.readMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// heartbeat sends back the messages of the heartbeat connection.
+func (k *kernel) heartbeat(z *zmtpConn) {
+
+	for {
+		frames, err := z.readMessage()
+		if err != nil {
+			return
+		}
+		if err := z.writeMessage(frames); err != nil {
+			return
+		}
+	}
+}
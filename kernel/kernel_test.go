@@ -0,0 +1,345 @@
+package kernel
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// client drives a kernel from a test, like a notebook.
+type client struct {
+	t       *testing.T
+	signer  signer
+	shell   *zmtpConn
+	iopub   *zmtpConn
+	hb      *zmtpConn
+	control *zmtpConn
+	// published receives the messages of the iopub socket.
+	published chan *message
+}
+
+// freePort returns a port available on the local machine.
+func freePort(t *testing.T) int {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// startKernel starts a kernel on free ports and waits until
+// it listens. A port may be taken by another process between
+// freePort and Serve, the kernel is then started again.
+func startKernel(t *testing.T) ConnectionInfo {
+
+	for attempt := 1; ; attempt++ {
+		info := ConnectionInfo{Transport: "tcp", IP: "127.0.0.1", Key: "secret",
+			SignatureScheme: "hmac-sha256", ShellPort: freePort(t), IOPubPort: freePort(t),
+			StdinPort: freePort(t), ControlPort: freePort(t), HBPort: freePort(t)}
+		served := make(chan error, 1)
+		go func() { served <- Serve(info) }()
+
+		// the heartbeat port is the last one listened on.
+		address := net.JoinHostPort("127.0.0.1", strconv.Itoa(info.HBPort))
+		var err error
+		for start := time.Now(); time.Since(start) < 5*time.Second; {
+			var conn net.Conn
+			if conn, err = net.Dial("tcp", address); err == nil {
+				conn.Close()
+			}
+			select {
+			case err = <-served:
+			case <-time.After(20 * time.Millisecond):
+				if err == nil {
+					t.Cleanup(func() { stopKernel(t, info, served) })
+					return info
+				}
+				continue
+			}
+			break
+		}
+		if attempt == 3 {
+			t.Fatal(err)
+		}
+	}
+}
+
+// stopKernel sends a shutdown request to a kernel and waits
+// until Serve returns, once all its goroutines are stopped.
+func stopKernel(t *testing.T, info ConnectionInfo, served chan error) {
+
+	c := &client{t: t, signer: signer{[]byte(info.Key)}}
+	control := c.connect(info.ControlPort, "DEALER")
+	defer control.close()
+	c.requestOn(control, "shutdown_request", shutdownRequest{})
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("the kernel didn't stop")
+	}
+}
+
+// newClient starts a kernel and connects to it.
+func newClient(t *testing.T) *client {
+
+	info := startKernel(t)
+	c := &client{t: t, signer: signer{[]byte(info.Key)}, published: make(chan *message, 100)}
+	c.shell = c.connect(info.ShellPort, "DEALER")
+	c.iopub = c.connect(info.IOPubPort, "SUB")
+	c.iopub.writeMessage([][]byte{{1}})
+	c.hb = c.connect(info.HBPort, "REQ")
+	c.control = c.connect(info.ControlPort, "DEALER")
+	go func() {
+		for {
+			frames, err := c.iopub.readMessage()
+			if err != nil {
+				close(c.published)
+				return
+			}
+			msg, err := c.signer.decode(frames)
+			if err != nil {
+				t.Errorf("invalid message published: %v", err)
+				continue
+			}
+			c.published <- msg
+		}
+	}()
+	t.Cleanup(func() {
+		c.shell.close()
+		c.iopub.close()
+		c.hb.close()
+		c.control.close()
+	})
+
+	// the kernel publishes once the subscription is registered.
+	for {
+		c.request("kernel_info_request", nil)
+		select {
+		case <-c.published:
+			c.drain()
+			return c
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// connect connects to a socket of the kernel, retrying
+// while the kernel starts.
+func (c *client) connect(port int, socketType string) *zmtpConn {
+
+	address := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	for start := time.Now(); ; {
+		conn, err := net.Dial("tcp", address)
+		if err == nil {
+			z, err := handshake(conn, socketType, false)
+			if err != nil {
+				c.t.Fatal(err)
+			}
+			return z
+		}
+		if time.Since(start) > 5*time.Second {
+			c.t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// request sends a request on the shell socket and returns its reply.
+func (c *client) request(msgType string, content interface{}) map[string]interface{} {
+
+	c.t.Helper()
+	return c.requestOn(c.shell, msgType, content)
+}
+
+// requestOn sends a request on a socket and returns its reply.
+func (c *client) requestOn(z *zmtpConn, msgType string, content interface{}) map[string]interface{} {
+
+	c.t.Helper()
+	msg, err := newMessage(&message{Header: header{Session: "test"}}, msgType, content)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	frames, err := c.signer.encode(msg)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if err := z.writeMessage(frames); err != nil {
+		c.t.Fatal(err)
+	}
+	frames, err = z.readMessage()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	reply, err := c.signer.decode(frames)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if want := strings.TrimSuffix(msgType, "_request") + "_reply"; reply.Header.MsgType != want {
+		c.t.Fatalf("expected %s, got %s", want, reply.Header.MsgType)
+	}
+	var parent header
+	json.Unmarshal(reply.ParentHeader, &parent)
+	if parent.MsgID != msg.Header.MsgID {
+		c.t.Errorf("the reply doesn't answer the request")
+	}
+	var body map[string]interface{}
+	json.Unmarshal(reply.Content, &body)
+	return body
+}
+
+// outputs returns the messages published for a request until
+// the kernel is idle, as "type: content".
+func (c *client) outputs() []string {
+
+	c.t.Helper()
+	var outputs []string
+	for {
+		select {
+		case msg := <-c.published:
+			var content map[string]interface{}
+			json.Unmarshal(msg.Content, &content)
+			if msg.Header.MsgType == "status" {
+				if content["execution_state"] == "idle" {
+					return outputs
+				}
+				continue
+			}
+			switch msg.Header.MsgType {
+			case "stream":
+				outputs = append(outputs, "stream: "+content["text"].(string))
+			case "error":
+				outputs = append(outputs, "error: "+content["evalue"].(string))
+			default:
+				outputs = append(outputs, msg.Header.MsgType)
+			}
+		case <-time.After(5 * time.Second):
+			c.t.Fatal("no idle status")
+		}
+	}
+}
+
+// drain discards the messages already published.
+func (c *client) drain() {
+
+	for {
+		select {
+		case <-c.published:
+		case <-time.After(50 * time.Millisecond):
+			return
+		}
+	}
+}
+
+func TestKernelInfo(t *testing.T) {
+
+	c := newClient(t)
+	reply := c.request("kernel_info_request", nil)
+	info := reply["language_info"].(map[string]interface{})
+	if reply["status"] != "ok" || info["name"] != "lox" || info["file_extension"] != ".lox" {
+		t.Errorf("unexpected kernel info %v", reply)
+	}
+}
+
+func TestExecute(t *testing.T) {
+
+	c := newClient(t)
+
+	reply := c.request("execute_request", executeRequest{Code: `var greeting = "hello";`})
+	if reply["status"] != "ok" || reply["execution_count"] != 1.0 {
+		t.Errorf("unexpected reply %v", reply)
+	}
+	if got, want := c.outputs(), []string{"execute_input"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	// the globals persist from a cell to the next.
+	c.request("execute_request", executeRequest{Code: "print greeting + \" world\";\nprint 1 + 2;"})
+	if got, want := c.outputs(), []string{"execute_input", "stream: hello world\n3\n"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	reply = c.request("execute_request", executeRequest{Code: "print \"before\";\nprint -greeting;"})
+	if reply["status"] != "error" || reply["ename"] != "RuntimeError" || reply["execution_count"] != 3.0 {
+		t.Errorf("unexpected reply %v", reply)
+	}
+	want := []string{"execute_input", "stream: before\n", "error: [line 2] Operand must be a number."}
+	if got := c.outputs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	reply = c.request("execute_request", executeRequest{Code: "print ;"})
+	if reply["status"] != "error" || reply["ename"] != "CompileError" {
+		t.Errorf("unexpected reply %v", reply)
+	}
+	want = []string{"execute_input", "error: [line 1] Error at ';': Expect expression."}
+	if got := c.outputs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestComplete(t *testing.T) {
+
+	c := newClient(t)
+	c.request("execute_request", executeRequest{Code: "var counter = 1; fun count() {}"})
+	c.outputs()
+	reply := c.request("complete_request", completeRequest{Code: "print cou", CursorPos: 9})
+	if got := reply["matches"]; !reflect.DeepEqual(got, []interface{}{"count", "counter"}) ||
+		reply["cursor_start"] != 6.0 || reply["cursor_end"] != 9.0 {
+		t.Errorf("unexpected completion %v", reply)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+
+	c := newClient(t)
+	ping := [][]byte{{}, []byte("ping")}
+	if err := c.hb.writeMessage(ping); err != nil {
+		t.Fatal(err)
+	}
+	pong, err := c.hb.readMessage()
+	if err != nil || !reflect.DeepEqual(pong, ping) {
+		t.Errorf("expected %q, got %q (%v)", ping, pong, err)
+	}
+}
+
+func TestInvalidSignature(t *testing.T) {
+
+	c := newClient(t)
+	msg, _ := newMessage(&message{}, "kernel_info_request", nil)
+	frames, _ := signer{[]byte("wrong")}.encode(msg)
+	c.shell.writeMessage(frames)
+	// the forged request is ignored, the next one is answered.
+	if reply := c.request("kernel_info_request", nil); reply["status"] != "ok" {
+		t.Errorf("unexpected reply %v", reply)
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+
+	c := newClient(t)
+	replies := make(chan map[string]interface{})
+	go func() {
+		replies <- c.request("execute_request", executeRequest{Code: "while (true) {}"})
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if reply := c.requestOn(c.control, "interrupt_request", nil); reply["status"] != "ok" {
+		t.Errorf("unexpected reply %v", reply)
+	}
+	select {
+	case reply := <-replies:
+		if reply["status"] != "error" || !strings.Contains(reply["evalue"].(string), "Execution cancelled.") {
+			t.Errorf("unexpected reply %v", reply)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the cell wasn't interrupted")
+	}
+}
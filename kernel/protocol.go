@@ -0,0 +1,194 @@
+package kernel
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// protocolVersion is the version of the Jupyter messaging
+// protocol implemented by the kernel.
+const protocolVersion = "5.3"
+
+// delimiter separates the routing identities from the message.
+const delimiter = "<IDS|MSG>"
+
+// ConnectionInfo is the connection file written by Jupyter
+// when it starts a kernel.
+type ConnectionInfo struct {
+	Transport       string `json:"transport"`
+	IP              string `json:"ip"`
+	ShellPort       int    `json:"shell_port"`
+	IOPubPort       int    `json:"iopub_port"`
+	StdinPort       int    `json:"stdin_port"`
+	ControlPort     int    `json:"control_port"`
+	HBPort          int    `json:"hb_port"`
+	SignatureScheme string `json:"signature_scheme"`
+	Key             string `json:"key"`
+}
+
+// header is the header of a message.
+type header struct {
+	MsgID    string `json:"msg_id"`
+	Session  string `json:"session"`
+	Username string `json:"username"`
+	Date     string `json:"date"`
+	MsgType  string `json:"msg_type"`
+	Version  string `json:"version"`
+}
+
+// message is a message of the protocol. The identities route the
+// replies of a request, the parent header is the header of the
+// request a message answers.
+type message struct {
+	identities   [][]byte
+	Header       header
+	ParentHeader json.RawMessage
+	Metadata     json.RawMessage
+	Content      json.RawMessage
+}
+
+// signer signs and checks the messages with the key of the
+// connection file, there is no signature with an empty key.
+type signer struct {
+	key []byte
+}
+
+// sign returns the hex-encoded HMAC-SHA256 of the parts of a message.
+func (s signer) sign(parts [][]byte) []byte {
+
+	if len(s.key) == 0 {
+		return nil
+	}
+	mac := hmac.New(sha256.New, s.key)
+	for _, part := range parts {
+		mac.Write(part)
+	}
+	return []byte(hex.EncodeToString(mac.Sum(nil)))
+}
+
+// decode decodes the frames of a message and checks its signature.
+func (s signer) decode(frames [][]byte) (*message, error) {
+
+	i := 0
+	for i < len(frames) && string(frames[i]) != delimiter {
+		i++
+	}
+	if len(frames) < i+6 {
+		return nil, errors.New("invalid message")
+	}
+	parts := frames[i+2 : i+6]
+	if !hmac.Equal(s.sign(parts), frames[i+1]) {
+		return nil, errors.New("invalid signature")
+	}
+	msg := &message{identities: frames[:i], ParentHeader: parts[1],
+		Metadata: parts[2], Content: parts[3]}
+	if err := json.Unmarshal(parts[0], &msg.Header); err != nil {
+		return nil, fmt.Errorf("invalid header: %v", err)
+	}
+	return msg, nil
+}
+
+// encode encodes and signs a message.
+func (s signer) encode(msg *message) ([][]byte, error) {
+
+	h, err := json.Marshal(msg.Header)
+	if err != nil {
+		return nil, err
+	}
+	parts := [][]byte{h, orEmpty(msg.ParentHeader), orEmpty(msg.Metadata), orEmpty(msg.Content)}
+	frames := append([][]byte{}, msg.identities...)
+	frames = append(frames, []byte(delimiter), s.sign(parts))
+	return append(frames, parts...), nil
+}
+
+// orEmpty returns the JSON object or {} if it is empty.
+func orEmpty(object json.RawMessage) []byte {
+
+	if len(bytes.TrimSpace(object)) == 0 {
+		return []byte("{}")
+	}
+	return object
+}
+
+// newMessage creates a message answering or published
+// for the parent message.
+func newMessage(parent *message, msgType string, content interface{}) (*message, error) {
+
+	body, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	parentHeader, err := json.Marshal(parent.Header)
+	if err != nil {
+		return nil, err
+	}
+	return &message{
+		identities: parent.identities,
+		Header: header{
+			MsgID:    newID(),
+			Session:  parent.Header.Session,
+			Username: "kernel",
+			Date:     time.Now().UTC().Format(time.RFC3339Nano),
+			MsgType:  msgType,
+			Version:  protocolVersion,
+		},
+		ParentHeader: parentHeader,
+		Content:      body,
+	}, nil
+}
+
+// newID returns a random message id.
+func newID() string {
+
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// The contents used by the kernel, with the members it needs.
+
+type executeRequest struct {
+	Code   string `json:"code"`
+	Silent bool   `json:"silent"`
+}
+
+type completeRequest struct {
+	Code      string `json:"code"`
+	CursorPos int    `json:"cursor_pos"`
+}
+
+type shutdownRequest struct {
+	Restart bool `json:"restart"`
+}
+
+type languageInfo struct {
+	Name          string `json:"name"`
+	Version       string `json:"version"`
+	Mimetype      string `json:"mimetype"`
+	FileExtension string `json:"file_extension"`
+}
+
+type kernelInfoReply struct {
+	Status                string       `json:"status"`
+	ProtocolVersion       string       `json:"protocol_version"`
+	Implementation        string       `json:"implementation"`
+	ImplementationVersion string       `json:"implementation_version"`
+	LanguageInfo          languageInfo `json:"language_info"`
+	Banner                string       `json:"banner"`
+}
+
+type errorContent struct {
+	Status    string   `json:"status,omitempty"`
+	Ename     string   `json:"ename"`
+	Evalue    string   `json:"evalue"`
+	Traceback []string `json:"traceback"`
+	// ExecutionCount is only set in the execute replies.
+	ExecutionCount int `json:"execution_count,omitempty"`
+}
@@ -0,0 +1,193 @@
+package kernel
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// The kernel speaks ZMTP 3.0 (the wire protocol of ZeroMQ) with the
+// NULL security mechanism, the one used by Jupyter on the local
+// machine. Only what the kernel needs is implemented: the greeting,
+// the READY command and the multipart messages. The kernel sockets
+// handle each peer on its own connection, so the ROUTER sockets
+// don't need the identities of their peers: the replies are written
+// on the connection of the request.
+
+// frame flags.
+const (
+	flagMore    = 0x01
+	flagLong    = 0x02
+	flagCommand = 0x04
+)
+
+// maxFrameSize limits the frames accepted from a peer.
+const maxFrameSize = 64 << 20
+
+// zmtpConn is a ZMTP connection with a peer.
+type zmtpConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	// mu serializes the messages written.
+	mu sync.Mutex
+}
+
+// greeting returns the greeting of a ZMTP 3.0 peer
+// with the NULL mechanism.
+func greeting(asServer bool) []byte {
+
+	g := make([]byte, 64)
+	g[0], g[9] = 0xff, 0x7f
+	g[10], g[11] = 3, 0
+	copy(g[12:32], "NULL")
+	if asServer {
+		g[32] = 1
+	}
+	return g
+}
+
+// handshake exchanges the greetings and the READY commands with the
+// peer connected on conn, the socket type is the one of the local
+// socket (like ROUTER or PUB).
+func handshake(conn net.Conn, socketType string, asServer bool) (*zmtpConn, error) {
+
+	z := &zmtpConn{conn: conn, reader: bufio.NewReader(conn)}
+	if _, err := conn.Write(greeting(asServer)); err != nil {
+		return nil, err
+	}
+	peer := make([]byte, 64)
+	if _, err := io.ReadFull(z.reader, peer); err != nil {
+		return nil, err
+	}
+	if peer[0] != 0xff || peer[9] != 0x7f || peer[10] < 3 {
+		return nil, errors.New("zmtp: the peer doesn't speak ZMTP 3")
+	}
+	if mechanism := string(bytes.TrimRight(peer[12:32], "\x00")); mechanism != "NULL" {
+		return nil, fmt.Errorf("zmtp: unsupported mechanism %s", mechanism)
+	}
+
+	ready := []byte{5}
+	ready = append(ready, "READY"...)
+	ready = appendProperty(ready, "Socket-Type", socketType)
+	if err := z.writeFrame(ready, flagCommand); err != nil {
+		return nil, err
+	}
+	for {
+		body, flags, err := z.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		if flags&flagCommand == 0 {
+			return nil, errors.New("zmtp: expected the READY command")
+		}
+		if len(body) > 0 && int(body[0]) < len(body) && string(body[1:1+body[0]]) == "READY" {
+			return z, nil
+		}
+	}
+}
+
+// appendProperty appends a property of the metadata of a command.
+func appendProperty(body []byte, name, value string) []byte {
+
+	body = append(body, byte(len(name)))
+	body = append(body, name...)
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(value)))
+	body = append(body, size[:]...)
+	return append(body, value...)
+}
+
+// readFrame reads a frame and its flags.
+func (z *zmtpConn) readFrame() ([]byte, byte, error) {
+
+	flags, err := z.reader.ReadByte()
+	if err != nil {
+		return nil, 0, err
+	}
+	var size uint64
+	if flags&flagLong != 0 {
+		var long [8]byte
+		if _, err := io.ReadFull(z.reader, long[:]); err != nil {
+			return nil, 0, err
+		}
+		size = binary.BigEndian.Uint64(long[:])
+	} else {
+		short, err := z.reader.ReadByte()
+		if err != nil {
+			return nil, 0, err
+		}
+		size = uint64(short)
+	}
+	if size > maxFrameSize {
+		return nil, 0, fmt.Errorf("zmtp: frame of %d bytes too large", size)
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(z.reader, body); err != nil {
+		return nil, 0, err
+	}
+	return body, flags, nil
+}
+
+// writeFrame writes a frame with the flags, the long
+// flag is added if needed.
+func (z *zmtpConn) writeFrame(body []byte, flags byte) error {
+
+	var header []byte
+	if len(body) > 255 {
+		header = make([]byte, 9)
+		header[0] = flags | flagLong
+		binary.BigEndian.PutUint64(header[1:], uint64(len(body)))
+	} else {
+		header = []byte{flags, byte(len(body))}
+	}
+	_, err := z.conn.Write(append(header, body...))
+	return err
+}
+
+// readMessage reads a multipart message, the commands
+// (like the subscriptions of ZMTP 3.1) are skipped.
+func (z *zmtpConn) readMessage() ([][]byte, error) {
+
+	var frames [][]byte
+	for {
+		body, flags, err := z.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		if flags&flagCommand != 0 {
+			continue
+		}
+		frames = append(frames, body)
+		if flags&flagMore == 0 {
+			return frames, nil
+		}
+	}
+}
+
+// writeMessage writes a multipart message.
+func (z *zmtpConn) writeMessage(frames [][]byte) error {
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	for i, frame := range frames {
+		var flags byte
+		if i < len(frames)-1 {
+			flags = flagMore
+		}
+		if err := z.writeFrame(frame, flags); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close closes the connection.
+func (z *zmtpConn) close() error {
+
+	return z.conn.Close()
+}
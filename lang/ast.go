@@ -3,12 +3,16 @@ package lang
 import (
 	"fmt"
 	"strings"
+	"sync/atomic"
 )
 
 // ------------
 // Statements
 // ------------
 
+// PrettyPrinter prints a statement like PrettyPrint, with inline
+// expressions. Each node starts with pad and the nested nodes are
+// indented with tab, pad usually starts with a new line.
 type PrettyPrinter interface {
 	PrettyPrint(pad, tab string) string
 }
@@ -21,22 +25,21 @@ type Stmt interface {
 }
 
 // BlockStmt represents a block statement in lox AST.
+// Desugared is true for the blocks created by the parser when
+// a for loop is transformed into a while loop, they don't appear
+// in the source (and have no braces).
 type BlockStmt struct {
 	Statements []Stmt
+	Desugared  bool
+	LeftBrace  *Token
+	RightBrace *Token
 }
 
 func (*BlockStmt) stmtNode() {}
 
 func (stmt *BlockStmt) PrettyPrint(pad, tab string) string {
 
-	b := strings.Builder{}
-	fmt.Fprintf(&b, "%s(block", pad)
-	newPad := pad + tab
-	for _, stmt := range stmt.Statements {
-		fmt.Fprintf(&b, "%s", stmt.PrettyPrint(newPad, tab))
-	}
-	fmt.Fprint(&b, ")")
-	return b.String()
+	return prettyPrint(stmt, pad, tab)
 }
 
 func (stmt *BlockStmt) String() string {
@@ -50,30 +53,40 @@ func (stmt *BlockStmt) String() string {
 	return b.String()
 }
 
+// BreakStmt represents a break statement in lox AST.
+type BreakStmt struct {
+	Keyword *Token
+}
+
+func (*BreakStmt) stmtNode() {}
+
+func (stmt *BreakStmt) PrettyPrint(pad, tab string) string {
+
+	return prettyPrint(stmt, pad, tab)
+}
+
+func (stmt *BreakStmt) String() string {
+
+	return "(break)"
+}
+
 // ClassDeclStmt represents a class definition in lox AST.
 type ClassDeclStmt struct {
 	Name       *Token
 	Superclass *VarExpr
 	Methods    []*FunDeclStmt
+	// Doc is the text of the "///" comment lines above
+	// the declaration, without the slashes.
+	Doc string
+	// RightBrace closes the body of the class.
+	RightBrace *Token
 }
 
 func (*ClassDeclStmt) stmtNode() {}
 
 func (stmt *ClassDeclStmt) PrettyPrint(pad, tab string) string {
 
-	b := strings.Builder{}
-	if stmt.Superclass != nil {
-		fmt.Fprintf(&b, "%s(class %s %s", pad, stmt.Name.Lexeme,
-			stmt.Superclass.Name.Lexeme)
-	} else {
-		fmt.Fprintf(&b, "%s(class %s nil", pad, stmt.Name.Lexeme)
-	}
-	newPad := pad + tab
-	for _, method := range stmt.Methods {
-		fmt.Fprintf(&b, "%s", method.PrettyPrint(newPad, tab))
-	}
-	fmt.Fprint(&b, ")")
-	return b.String()
+	return prettyPrint(stmt, pad, tab)
 }
 
 func (stmt *ClassDeclStmt) String() string {
@@ -101,7 +114,7 @@ func (*ExprStmt) stmtNode() {}
 
 func (stmt *ExprStmt) PrettyPrint(pad, tab string) string {
 
-	return fmt.Sprintf("%s%s", pad, stmt.Expression.String())
+	return prettyPrint(stmt, pad, tab)
 }
 
 func (stmt *ExprStmt) String() string {
@@ -115,25 +128,33 @@ type FunDeclStmt struct {
 	Name   *Token
 	Params []*Token
 	Body   []Stmt
+	// Upvalues lists the variables of the enclosing functions and
+	// blocks captured by the function. It is filled by the resolver.
+	Upvalues []Upvalue
+	// Doc is the text of the "///" comment lines above
+	// the declaration, without the slashes.
+	Doc string
+	// RightBrace closes the body of the function.
+	RightBrace *Token
+}
+
+// Upvalue describes how a function captures a variable declared
+// outside of it when the function is created. A Local upvalue is
+// found in the environment where the function is declared, Depth
+// scopes up at Slot. Otherwise, it is captured from the enclosing
+// function upvalues at Index.
+type Upvalue struct {
+	Local bool
+	Depth int
+	Slot  int
+	Index int
 }
 
 func (*FunDeclStmt) stmtNode() {}
 
 func (stmt *FunDeclStmt) PrettyPrint(pad, tab string) string {
 
-	b := strings.Builder{}
-	fmt.Fprintf(&b, "%s(fun %s (params", pad, stmt.Name.Lexeme)
-	for _, param := range stmt.Params {
-		fmt.Fprintf(&b, " %s", param.Lexeme)
-	}
-	fmt.Fprint(&b, ")")
-	newPad := pad + tab
-	for _, statement := range stmt.Body {
-		fmt.Fprintf(&b, "%s", statement.PrettyPrint(newPad, tab))
-	}
-	fmt.Fprint(&b, ")")
-
-	return b.String()
+	return prettyPrint(stmt, pad, tab)
 }
 
 func (stmt *FunDeclStmt) String() string {
@@ -156,22 +177,14 @@ type IfStmt struct {
 	Condition  Expr
 	ThenBranch Stmt
 	ElseBranch Stmt
+	Keyword    *Token
 }
 
 func (*IfStmt) stmtNode() {}
 
 func (stmt *IfStmt) PrettyPrint(pad, tab string) string {
 
-	b := strings.Builder{}
-	newPad := pad + tab
-	fmt.Fprintf(&b, "%s(if %s%s", pad, stmt.Condition.String(),
-		stmt.ThenBranch.PrettyPrint(newPad, tab))
-	if stmt.ElseBranch != nil {
-		fmt.Fprintf(&b, "%s", stmt.ElseBranch.PrettyPrint(newPad, tab))
-	}
-	fmt.Fprint(&b, ")")
-
-	return b.String()
+	return prettyPrint(stmt, pad, tab)
 }
 
 func (stmt *IfStmt) String() string {
@@ -189,13 +202,14 @@ func (stmt *IfStmt) String() string {
 // PrintStmt represents a print statement in lox AST.
 type PrintStmt struct {
 	Expression Expr
+	Keyword    *Token
 }
 
 func (*PrintStmt) stmtNode() {}
 
 func (stmt *PrintStmt) PrettyPrint(pad, tab string) string {
 
-	return fmt.Sprintf("%s(print %s)", pad, stmt.Expression.String())
+	return prettyPrint(stmt, pad, tab)
 }
 
 func (stmt *PrintStmt) String() string {
@@ -213,11 +227,7 @@ func (*ReturnStmt) stmtNode() {}
 
 func (stmt *ReturnStmt) PrettyPrint(pad, tab string) string {
 
-	if stmt.Value != nil {
-		return fmt.Sprintf("%s(return %s)", pad, stmt.Value.String())
-	} else {
-		return fmt.Sprintf("%s(return)", pad)
-	}
+	return prettyPrint(stmt, pad, tab)
 }
 
 func (stmt *ReturnStmt) String() string {
@@ -239,12 +249,7 @@ func (*VarDeclStmt) stmtNode() {}
 
 func (stmt *VarDeclStmt) PrettyPrint(pad, tab string) string {
 
-	if stmt.Initializer != nil {
-		return fmt.Sprintf("%s(var %s %s)", pad, stmt.Name.Lexeme,
-			stmt.Initializer.String())
-	} else {
-		return fmt.Sprintf("%s(var %s)", pad, stmt.Name.Lexeme)
-	}
+	return prettyPrint(stmt, pad, tab)
 }
 
 func (stmt *VarDeclStmt) String() string {
@@ -258,17 +263,19 @@ func (stmt *VarDeclStmt) String() string {
 }
 
 // WhileStmt represents a while statement in lox AST.
+// Keyword is the 'for' token when the statement is the
+// result of a for loop.
 type WhileStmt struct {
 	Condition Expr
 	Body      Stmt
+	Keyword   *Token
 }
 
 func (*WhileStmt) stmtNode() {}
 
 func (stmt *WhileStmt) PrettyPrint(pad, tab string) string {
 
-	return fmt.Sprintf("%s(while %s%s)", pad,
-		stmt.Condition.String(), stmt.Body.PrettyPrint(pad+tab, tab))
+	return prettyPrint(stmt, pad, tab)
 }
 
 func (stmt *WhileStmt) String() string {
@@ -287,10 +294,25 @@ type Expr interface {
 	exprNode()
 }
 
+// Binding locates the variable referenced by an expression.
+// It is filled by the resolver. For a Local variable, Depth is the
+// number of scopes between the reference and the declaration and
+// Slot the index of the variable in the scope where it is declared.
+// For an Upvalue (a variable declared outside of the current
+// function), Slot is the index in the function upvalues.
+// Other variables are looked up in the globals.
+type Binding struct {
+	Local   bool
+	Upvalue bool
+	Depth   int
+	Slot    int
+}
+
 // AssignExpr represents an assignment expression in lox AST.
 type AssignExpr struct {
-	Name  *Token
-	Value Expr
+	Name    *Token
+	Value   Expr
+	Binding Binding
 }
 
 func (*AssignExpr) exprNode() {}
@@ -340,6 +362,38 @@ func (expr *CallExpr) String() string {
 type GetExpr struct {
 	Object Expr
 	Name   *Token
+	Cache  PropertyCache
+}
+
+// PropertyCache is an inline cache used by the interpreter to
+// remember the method found the last time a property was read
+// at a given site. The class and the method are owned by the
+// interpreter. The cache is safe for concurrent use, so the
+// interpreters sharing an AST can run it at the same time.
+type PropertyCache struct {
+	entry atomic.Value
+}
+
+// propertyEntry is the content of a PropertyCache, replaced
+// as a whole.
+type propertyEntry struct {
+	class  interface{}
+	method interface{}
+}
+
+// Load returns the class and the method cached, nil if none.
+func (c *PropertyCache) Load() (class, method interface{}) {
+
+	if entry, ok := c.entry.Load().(*propertyEntry); ok {
+		return entry.class, entry.method
+	}
+	return nil, nil
+}
+
+// Store caches the method found for the class.
+func (c *PropertyCache) Store(class, method interface{}) {
+
+	c.entry.Store(&propertyEntry{class, method})
 }
 
 func (*GetExpr) exprNode() {}
@@ -351,8 +405,12 @@ func (expr *GetExpr) String() string {
 }
 
 // GroupingExpr represents a grouping expression in lox AST.
+// The parentheses written by the author are kept in the AST,
+// even when the precedence of the operators doesn't need them.
 type GroupingExpr struct {
 	Expression Expr
+	LeftParen  *Token
+	RightParen *Token
 }
 
 func (*GroupingExpr) exprNode() {}
@@ -363,8 +421,15 @@ func (expr *GroupingExpr) String() string {
 }
 
 // Lit represents a STRING, NUMBER, BOOLEAN or NIL literal in lox AST.
+// Lexeme is the literal as written in the source (like 1.50 for the
+// number 1.5, with the quotes for a string). Lexeme is empty and
+// Token nil for literals which don't appear in the source (like the
+// implicit condition of a for loop). The constants folded from an
+// expression have no lexeme, their token locates the expression.
 type Lit struct {
-	Value interface{}
+	Value  interface{}
+	Lexeme string
+	Token  *Token
 }
 
 func (*Lit) exprNode() {}
@@ -415,6 +480,9 @@ func (expr *SetExpr) String() string {
 type SuperExpr struct {
 	Keyword *Token
 	Method  *Token
+	Binding Binding
+	// This locates the instance the method is bound to.
+	This Binding
 }
 
 func (*SuperExpr) exprNode() {}
@@ -428,6 +496,7 @@ func (expr *SuperExpr) String() string {
 // a class instance in lox AST.
 type ThisExpr struct {
 	Keyword *Token
+	Binding Binding
 }
 
 func (*ThisExpr) exprNode() {}
@@ -453,7 +522,8 @@ func (expr *UnaryExpr) String() string {
 
 // VarExpr represents a variable expression in lox AST.
 type VarExpr struct {
-	Name *Token
+	Name    *Token
+	Binding Binding
 }
 
 func (*VarExpr) exprNode() {}
@@ -462,3 +532,78 @@ func (expr *VarExpr) String() string {
 
 	return fmt.Sprintf("(%s)", expr.Name.Lexeme)
 }
+
+// -----------
+// Positions
+// -----------
+
+// StmtStart returns the first token of a statement. It is used
+// to locate the statement in the source when reporting diagnostics.
+// It returns nil if the statement doesn't include any token (like
+// an empty block).
+func StmtStart(stmt Stmt) *Token {
+
+	switch s := stmt.(type) {
+	case *BlockStmt:
+		for _, statement := range s.Statements {
+			if token := StmtStart(statement); token != nil {
+				return token
+			}
+		}
+		return nil
+	case *BreakStmt:
+		return s.Keyword
+	case *ClassDeclStmt:
+		return s.Name
+	case *ExprStmt:
+		return ExprStart(s.Expression)
+	case *FunDeclStmt:
+		return s.Name
+	case *IfStmt:
+		return s.Keyword
+	case *PrintStmt:
+		return s.Keyword
+	case *ReturnStmt:
+		return s.Keyword
+	case *VarDeclStmt:
+		return s.Name
+	case *WhileStmt:
+		return s.Keyword
+	default:
+		return nil
+	}
+}
+
+// ExprStart returns the first token of an expression. It returns
+// nil if the expression doesn't appear in the source.
+func ExprStart(expr Expr) *Token {
+
+	switch e := expr.(type) {
+	case *AssignExpr:
+		return e.Name
+	case *BinaryExpr:
+		return ExprStart(e.LeftExpression)
+	case *CallExpr:
+		return ExprStart(e.Callee)
+	case *GetExpr:
+		return ExprStart(e.Object)
+	case *GroupingExpr:
+		return e.LeftParen
+	case *Lit:
+		return e.Token
+	case *LogicalExpr:
+		return ExprStart(e.LeftExpression)
+	case *SetExpr:
+		return ExprStart(e.Object)
+	case *SuperExpr:
+		return e.Keyword
+	case *ThisExpr:
+		return e.Keyword
+	case *UnaryExpr:
+		return e.Operator
+	case *VarExpr:
+		return e.Name
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,196 @@
+package lang
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Severity indicates how serious a diagnostic is.
+type Severity int
+
+const (
+	// ErrorSeverity represents a problem preventing the script from running.
+	ErrorSeverity Severity = iota
+	// WarningSeverity represents a suspicious construct which doesn't
+	// prevent the script from running.
+	WarningSeverity
+)
+
+// String returns the string representation of a Severity.
+func (s Severity) String() string {
+
+	switch s {
+	case WarningSeverity:
+		return "Warning"
+	default:
+		return "Error"
+	}
+}
+
+// Diagnostic represents an error or a warning reported while
+// scanning, parsing or resolving a lox script.
+type Diagnostic struct {
+	Severity Severity
+	Line     int
+	// Where locates the diagnostic within the line
+	// ("at 'name'", "at end"). It is empty for scanner errors.
+	Where   string
+	Message string
+	// Column and Length locate the span of source code the
+	// diagnostic is about within the line. Column is 0 when
+	// the span is unknown.
+	Column int
+	Length int
+	// Code identifies the kind of diagnostic, like
+	// "parse/expect-expression". It doesn't change with the
+	// names and the numbers of the message, or its wording.
+	Code string
+}
+
+// The codes of the diagnostics reported by the scanner and the parser.
+// The codes of a function and of a method declaration are the same.
+const (
+	CodeUnexpectedCharacter          = "scan/unexpected-character"
+	CodeUnterminatedString           = "scan/unterminated-string"
+	CodeExpectEndOfExpression        = "parse/expect-end-of-expression"
+	CodeExpectClassName              = "parse/expect-class-name"
+	CodeExpectSuperclassName         = "parse/expect-superclass-name"
+	CodeExpectClassBody              = "parse/expect-class-body"
+	CodeExpectClassBodyEnd           = "parse/expect-class-body-end"
+	CodeExpectFunctionName           = "parse/expect-function-name"
+	CodeExpectFunctionParameters     = "parse/expect-function-parameters"
+	CodeExpectFunctionBody           = "parse/expect-function-body"
+	CodeExpectParameterName          = "parse/expect-parameter-name"
+	CodeExpectParametersEnd          = "parse/expect-parameters-end"
+	CodeTooManyParameters            = "parse/too-many-parameters"
+	CodeExpectVariableName           = "parse/expect-variable-name"
+	CodeExpectVariableDeclarationEnd = "parse/expect-variable-declaration-end"
+	CodeExpectForClauses             = "parse/expect-for-clauses"
+	CodeExpectLoopConditionEnd       = "parse/expect-loop-condition-end"
+	CodeExpectForClausesEnd          = "parse/expect-for-clauses-end"
+	CodeExpectIfCondition            = "parse/expect-if-condition"
+	CodeExpectIfConditionEnd         = "parse/expect-if-condition-end"
+	CodeExpectPrintEnd               = "parse/expect-print-end"
+	CodeExpectBreakEnd               = "parse/expect-break-end"
+	CodeExpectReturnEnd              = "parse/expect-return-end"
+	CodeExpectWhileCondition         = "parse/expect-while-condition"
+	CodeExpectWhileConditionEnd      = "parse/expect-while-condition-end"
+	CodeExpectBlockEnd               = "parse/expect-block-end"
+	CodeExpectExpressionStatementEnd = "parse/expect-expression-statement-end"
+	CodeExpectExpression             = "parse/expect-expression"
+	CodeInvalidAssignmentTarget      = "parse/invalid-assignment-target"
+	CodeExpectArgumentsEnd           = "parse/expect-arguments-end"
+	CodeTooManyArguments             = "parse/too-many-arguments"
+	CodeExpectPropertyName           = "parse/expect-property-name"
+	CodeExpectGroupingEnd            = "parse/expect-grouping-end"
+	CodeExpectSuperDot               = "parse/expect-super-dot"
+	CodeExpectSuperMethodName        = "parse/expect-super-method-name"
+)
+
+// NewDiagnostic creates a diagnostic of the kind identified by
+// code located at the token.
+func NewDiagnostic(severity Severity, token *Token, code string, msg string) Diagnostic {
+
+	var where string
+	if token.Type == EndToken {
+		where = "at end"
+	} else {
+		where = "at '" + token.Lexeme + "'"
+	}
+	column, length := tokenSpan(token)
+	return Diagnostic{severity, token.Line, where, msg, column, length, code}
+}
+
+// tokenSpan returns the column and the length of a token in the
+// source. The span is unknown for multiline strings, their column
+// is in their first line but they are reported on their last line.
+func tokenSpan(token *Token) (int, int) {
+
+	if strings.Contains(token.Lexeme, "\n") {
+		return 0, 0
+	}
+	return token.Column, utf8.RuneCountInString(token.Lexeme)
+}
+
+// String returns the diagnostic in the format used to report it,
+// for example "[line 1] Error at ';': Expect expression.".
+func (d Diagnostic) String() string {
+
+	if d.Where == "" {
+		return fmt.Sprintf("[line %d] %s: %s", d.Line, d.Severity, d.Message)
+	}
+	return fmt.Sprintf("[line %d] %s %s: %s", d.Line, d.Severity, d.Where, d.Message)
+}
+
+// ANSI escape sequences used to style the diagnostics.
+const (
+	ansiRed       = "\x1b[31m"
+	ansiYellow    = "\x1b[33m"
+	ansiUnderline = "\x1b[4m"
+	ansiReset     = "\x1b[0m"
+)
+
+// DiagnosticFormatter styles the diagnostics of a script for a
+// terminal: they are colored by severity (red for errors and yellow
+// for warnings) and followed by their source line, highlighted,
+// with the span they are about underlined.
+// A nil formatter formats the diagnostics as plain text.
+type DiagnosticFormatter struct {
+	lines []string
+}
+
+// NewDiagnosticFormatter creates a formatter for the diagnostics
+// of a script.
+func NewDiagnosticFormatter(source string) *DiagnosticFormatter {
+
+	return &DiagnosticFormatter{strings.Split(source, "\n")}
+}
+
+// Format returns the diagnostic as it is written to the error output.
+func (f *DiagnosticFormatter) Format(d Diagnostic) string {
+
+	if f == nil {
+		return d.String()
+	}
+	color := ansiRed
+	if d.Severity == WarningSeverity {
+		color = ansiYellow
+	}
+	return f.style(d.String(), color, d.Line, d.Column, d.Length)
+}
+
+// FormatRuntimeError returns the message of a runtime error detected
+// at the token as it is written to the error output.
+func (f *DiagnosticFormatter) FormatRuntimeError(message string, token *Token) string {
+
+	if f == nil {
+		return message
+	}
+	column, length := tokenSpan(token)
+	return f.style(message, ansiRed, token.Line, column, length)
+}
+
+// style colors the text and adds the source line with the span
+// starting at the column underlined, if it is known.
+func (f *DiagnosticFormatter) style(text, color string, line, column, length int) string {
+
+	styled := color + text + ansiReset
+	if column <= 0 || line < 1 || line > len(f.lines) {
+		return styled
+	}
+
+	source := []rune(strings.TrimRight(f.lines[line-1], "\r"))
+	start := column - 1
+	if start > len(source) {
+		start = len(source)
+	}
+	end := start + length
+	if end > len(source) {
+		end = len(source)
+	}
+	colors := highlightColors(string(source))
+	return styled + "\n    " + colorize(source[:start], colors[:start]) +
+		ansiUnderline + color + string(source[start:end]) + ansiReset +
+		colorize(source[end:], colors[end:])
+}
@@ -0,0 +1,57 @@
+package lang
+
+import "testing"
+
+func TestDiagnosticString(t *testing.T) {
+
+	name := &Token{IdentifierToken, "a", 3, 5}
+	end := &Token{EndToken, "", 7, 1}
+	tests := []struct {
+		diagnostic Diagnostic
+		expect     string
+	}{
+		{NewDiagnostic(ErrorSeverity, name, "test/code", "Oops."), "[line 3] Error at 'a': Oops."},
+		{NewDiagnostic(WarningSeverity, name, "test/code", "Hmm."), "[line 3] Warning at 'a': Hmm."},
+		{NewDiagnostic(ErrorSeverity, end, "test/code", "Oops."), "[line 7] Error at end: Oops."},
+		{Diagnostic{ErrorSeverity, 2, "", "Oops.", 0, 0, "test/code"}, "[line 2] Error: Oops."},
+	}
+	for _, test := range tests {
+		if got := test.diagnostic.String(); got != test.expect {
+			t.Errorf("expected %q, got %q", test.expect, got)
+		}
+	}
+}
+
+func TestDiagnosticFormatter(t *testing.T) {
+
+	source := "var a = 1;\nprint a +  b;"
+	b := &Token{IdentifierToken, "b", 2, 12}
+	end := &Token{EndToken, "", 2, 14}
+	f := NewDiagnosticFormatter(source)
+	tests := []struct {
+		got    string
+		expect string
+	}{
+		{f.Format(NewDiagnostic(ErrorSeverity, b, "test/code", "Oops.")),
+			"\x1b[31m[line 2] Error at 'b': Oops.\x1b[0m\n" +
+				"    \x1b[35mprint\x1b[0m a +  \x1b[4m\x1b[31mb\x1b[0m;"},
+		{f.Format(NewDiagnostic(WarningSeverity, end, "test/code", "Hmm.")),
+			"\x1b[33m[line 2] Warning at end: Hmm.\x1b[0m\n" +
+				"    \x1b[35mprint\x1b[0m a +  b;\x1b[4m\x1b[33m\x1b[0m"},
+		{f.Format(Diagnostic{ErrorSeverity, 1, "", "Oops.", 0, 0, "test/code"}),
+			"\x1b[31m[line 1] Error: Oops.\x1b[0m"},
+		{f.FormatRuntimeError("[line 2] Undefined variable 'b'.", b),
+			"\x1b[31m[line 2] Undefined variable 'b'.\x1b[0m\n" +
+				"    \x1b[35mprint\x1b[0m a +  \x1b[4m\x1b[31mb\x1b[0m;"},
+	}
+	for _, test := range tests {
+		if test.got != test.expect {
+			t.Errorf("expected %q, got %q", test.expect, test.got)
+		}
+	}
+
+	var plain *DiagnosticFormatter
+	if got := plain.Format(NewDiagnostic(ErrorSeverity, b, "test/code", "Oops.")); got != "[line 2] Error at 'b': Oops." {
+		t.Errorf("expected plain text, got %q", got)
+	}
+}
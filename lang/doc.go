@@ -0,0 +1,87 @@
+package lang
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"strings"
+)
+
+// docEntry is a documented declaration: a function, a class
+// or a method (level 3, below its class).
+type docEntry struct {
+	level     int
+	signature string
+	doc       string
+}
+
+// WriteMarkdown writes the documentation of the functions and the
+// classes declared at the top level of a script as Markdown, under
+// a title (usually the file name). The doc comments are copied as
+// is, so they can use Markdown themselves.
+func WriteMarkdown(out io.Writer, title string, statements []Stmt) error {
+
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "# %s\n", title)
+	for _, entry := range docEntries(statements) {
+		fmt.Fprintf(b, "\n%s `%s`\n", strings.Repeat("#", entry.level), entry.signature)
+		if entry.doc != "" {
+			fmt.Fprintf(b, "\n%s\n", entry.doc)
+		}
+	}
+	_, err := io.WriteString(out, b.String())
+	return err
+}
+
+// WriteHTML writes the documentation of the functions and the
+// classes declared at the top level of a script as an HTML fragment,
+// under a title (usually the file name). The paragraphs of the doc
+// comments are separated by blank lines.
+func WriteHTML(out io.Writer, title string, statements []Stmt) error {
+
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "<h1>%s</h1>\n", html.EscapeString(title))
+	for _, entry := range docEntries(statements) {
+		fmt.Fprintf(b, "<h%d><code>%s</code></h%d>\n",
+			entry.level, html.EscapeString(entry.signature), entry.level)
+		for _, paragraph := range strings.Split(entry.doc, "\n\n") {
+			if paragraph != "" {
+				fmt.Fprintf(b, "<p>%s</p>\n", html.EscapeString(paragraph))
+			}
+		}
+	}
+	_, err := io.WriteString(out, b.String())
+	return err
+}
+
+// docEntries lists the declarations to document in source order.
+func docEntries(statements []Stmt) []docEntry {
+
+	var entries []docEntry
+	for _, stmt := range statements {
+		switch s := stmt.(type) {
+		case *FunDeclStmt:
+			entries = append(entries, docEntry{2, "fun " + signature(s), s.Doc})
+		case *ClassDeclStmt:
+			declaration := "class " + s.Name.Lexeme
+			if s.Superclass != nil {
+				declaration += " < " + s.Superclass.Name.Lexeme
+			}
+			entries = append(entries, docEntry{2, declaration, s.Doc})
+			for _, method := range s.Methods {
+				entries = append(entries, docEntry{3, signature(method), method.Doc})
+			}
+		}
+	}
+	return entries
+}
+
+// signature returns the name and the parameters of a function.
+func signature(fun *FunDeclStmt) string {
+
+	params := make([]string, len(fun.Params))
+	for i, param := range fun.Params {
+		params[i] = param.Lexeme
+	}
+	return fun.Name.Lexeme + "(" + strings.Join(params, ", ") + ")"
+}
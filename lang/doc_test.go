@@ -0,0 +1,69 @@
+package lang
+
+import (
+	"strings"
+	"testing"
+)
+
+const docScript = `
+/// Adds two numbers.
+fun add(a, b) { return a + b; }
+
+var x = 1; /// not a doc comment
+fun undocumented() {}
+
+/// A cake.
+///
+/// It can be <baked>.
+class Cake < Dessert {
+	/// Bakes the cake for t minutes.
+	bake(t) {}
+}`
+
+func TestWriteMarkdown(t *testing.T) {
+
+	expect := "# cake.lox\n" +
+		"\n## `fun add(a, b)`\n\nAdds two numbers.\n" +
+		"\n## `fun undocumented()`\n" +
+		"\n## `class Cake < Dessert`\n\nA cake.\n\nIt can be <baked>.\n" +
+		"\n### `bake(t)`\n\nBakes the cake for t minutes.\n"
+	b := &strings.Builder{}
+	if err := WriteMarkdown(b, "cake.lox", parseDocScript(t, docScript)); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.String(); got != expect {
+		t.Errorf("Expected\n%s\nbut got\n%s", expect, got)
+	}
+}
+
+func TestWriteHTML(t *testing.T) {
+
+	expect := "<h1>cake.lox</h1>\n" +
+		"<h2><code>fun add(a, b)</code></h2>\n<p>Adds two numbers.</p>\n" +
+		"<h2><code>fun undocumented()</code></h2>\n" +
+		"<h2><code>class Cake &lt; Dessert</code></h2>\n" +
+		"<p>A cake.</p>\n<p>It can be &lt;baked&gt;.</p>\n" +
+		"<h3><code>bake(t)</code></h3>\n<p>Bakes the cake for t minutes.</p>\n"
+	b := &strings.Builder{}
+	if err := WriteHTML(b, "cake.lox", parseDocScript(t, docScript)); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.String(); got != expect {
+		t.Errorf("Expected\n%s\nbut got\n%s", expect, got)
+	}
+}
+
+func parseDocScript(t *testing.T, script string) []Stmt {
+
+	t.Helper()
+
+	scanner := &Scanner{}
+	tokens := scanner.ScanTokens(script)
+	parser := &Parser{}
+	parser.SetComments(scanner.Comments())
+	statements := parser.Parse(tokens)
+	if scanner.HadError() || parser.HadError() {
+		t.Fatal("Error encountered while parsing")
+	}
+	return statements
+}
@@ -0,0 +1,276 @@
+package lang
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// WriteJSON writes the AST of a script to out as JSON.
+// The script is an array of nodes. Each node is an object with
+// a "node" member naming its type (like "Binary" or "While"),
+// a "line" member when it appears in the source, and a member
+// for each of its attributes and children.
+func WriteJSON(out io.Writer, statements []Stmt) error {
+
+	encoder := json.NewEncoder(out)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(dumpStmts(statements))
+}
+
+// WriteDot writes the AST of a script to out as a Graphviz dot
+// graph. Each node is labeled with its type and attributes and
+// each edge with the name of the child.
+func WriteDot(out io.Writer, statements []Stmt) error {
+
+	d := &dotWriter{}
+	fmt.Fprintln(&d.b, "digraph ast {")
+	fmt.Fprintln(&d.b, "  node [shape=box];")
+	root := d.addNode(map[string]interface{}{"node": "Script"})
+	for n, stmt := range dumpStmts(statements) {
+		d.addEdge(root, fmt.Sprintf("%d", n), stmt)
+	}
+	fmt.Fprintln(&d.b, "}")
+	_, err := io.WriteString(out, d.b.String())
+	return err
+}
+
+// dotWriter accumulates the nodes and edges of a dot graph.
+type dotWriter struct {
+	b     strings.Builder
+	count int
+}
+
+// addNode adds a node and its children to the graph and returns
+// the node id.
+func (d *dotWriter) addNode(node map[string]interface{}) string {
+
+	id := fmt.Sprintf("n%d", d.count)
+	d.count++
+
+	label := []string{node["node"].(string)}
+	var children []string
+	for _, key := range sortedKeys(node) {
+		switch v := node[key].(type) {
+		case map[string]interface{}, []interface{}:
+			children = append(children, key)
+		default:
+			if key != "node" && key != "line" {
+				label = append(label, fmt.Sprintf("%s: %v", key, jsonText(v)))
+			}
+		}
+	}
+	fmt.Fprintf(&d.b, "  %s [label=%q];\n", id, strings.Join(label, "\n"))
+
+	for _, key := range children {
+		switch v := node[key].(type) {
+		case map[string]interface{}:
+			d.addEdge(id, key, v)
+		case []interface{}:
+			for n, child := range v {
+				d.addEdge(id, fmt.Sprintf("%s[%d]", key, n), child)
+			}
+		}
+	}
+	return id
+}
+
+// addEdge adds a child node (or a simple value like a parameter
+// name) and the edge from its parent.
+func (d *dotWriter) addEdge(parent, label string, child interface{}) {
+
+	var id string
+	if node, ok := child.(map[string]interface{}); ok {
+		id = d.addNode(node)
+	} else {
+		id = fmt.Sprintf("n%d", d.count)
+		d.count++
+		fmt.Fprintf(&d.b, "  %s [label=%q, shape=plaintext];\n", id, jsonText(child))
+	}
+	fmt.Fprintf(&d.b, "  %s -> %s [label=%q];\n", parent, id, label)
+}
+
+// jsonText returns the JSON representation of a simple value,
+// strings are quoted and nil is null. The HTML characters are not
+// escaped, the labels show them as written.
+func jsonText(value interface{}) string {
+
+	var b strings.Builder
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(value)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// sortedKeys returns the keys of a node in alphabetical order
+// so the dot output is deterministic.
+func sortedKeys(node map[string]interface{}) []string {
+
+	keys := make([]string, 0, len(node))
+	for key := range node {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// dumpStmts converts a list of statements to generic nodes.
+func dumpStmts(statements []Stmt) []interface{} {
+
+	nodes := make([]interface{}, 0, len(statements))
+	for _, stmt := range statements {
+		nodes = append(nodes, dumpStmt(stmt))
+	}
+	return nodes
+}
+
+// dumpExprs converts a list of expressions to generic nodes.
+func dumpExprs(expressions []Expr) []interface{} {
+
+	nodes := make([]interface{}, 0, len(expressions))
+	for _, expr := range expressions {
+		nodes = append(nodes, dumpExpr(expr))
+	}
+	return nodes
+}
+
+// dumpNode creates a generic node of the given type, located
+// at the token if it is known.
+func dumpNode(kind string, token *Token) map[string]interface{} {
+
+	node := map[string]interface{}{"node": kind}
+	if token != nil {
+		node["line"] = token.Line
+	}
+	return node
+}
+
+// dumpStmt converts a statement to a generic node.
+func dumpStmt(stmt Stmt) map[string]interface{} {
+
+	switch s := stmt.(type) {
+	case *BlockStmt:
+		node := dumpNode("Block", StmtStart(s))
+		node["statements"] = dumpStmts(s.Statements)
+		return node
+	case *BreakStmt:
+		return dumpNode("Break", s.Keyword)
+	case *ClassDeclStmt:
+		node := dumpNode("Class", s.Name)
+		node["name"] = s.Name.Lexeme
+		if s.Superclass != nil {
+			node["superclass"] = s.Superclass.Name.Lexeme
+		}
+		methods := make([]interface{}, 0, len(s.Methods))
+		for _, method := range s.Methods {
+			methods = append(methods, dumpStmt(method))
+		}
+		node["methods"] = methods
+		return node
+	case *ExprStmt:
+		node := dumpNode("Expression", StmtStart(s))
+		node["expression"] = dumpExpr(s.Expression)
+		return node
+	case *FunDeclStmt:
+		node := dumpNode("Function", s.Name)
+		node["name"] = s.Name.Lexeme
+		params := make([]interface{}, 0, len(s.Params))
+		for _, param := range s.Params {
+			params = append(params, param.Lexeme)
+		}
+		node["params"] = params
+		node["body"] = dumpStmts(s.Body)
+		return node
+	case *IfStmt:
+		node := dumpNode("If", s.Keyword)
+		node["condition"] = dumpExpr(s.Condition)
+		node["then"] = dumpStmt(s.ThenBranch)
+		if s.ElseBranch != nil {
+			node["else"] = dumpStmt(s.ElseBranch)
+		}
+		return node
+	case *PrintStmt:
+		node := dumpNode("Print", s.Keyword)
+		node["expression"] = dumpExpr(s.Expression)
+		return node
+	case *ReturnStmt:
+		node := dumpNode("Return", s.Keyword)
+		if s.Value != nil {
+			node["value"] = dumpExpr(s.Value)
+		}
+		return node
+	case *VarDeclStmt:
+		node := dumpNode("Var", s.Name)
+		node["name"] = s.Name.Lexeme
+		if s.Initializer != nil {
+			node["initializer"] = dumpExpr(s.Initializer)
+		}
+		return node
+	case *WhileStmt:
+		node := dumpNode("While", s.Keyword)
+		node["condition"] = dumpExpr(s.Condition)
+		node["body"] = dumpStmt(s.Body)
+		return node
+	default:
+		panic(fmt.Sprintf("Unknown Statement Type: %T", stmt))
+	}
+}
+
+// dumpExpr converts an expression to a generic node.
+func dumpExpr(expr Expr) map[string]interface{} {
+
+	node := dumpNode("", ExprStart(expr))
+	switch e := expr.(type) {
+	case *AssignExpr:
+		node["node"] = "Assign"
+		node["name"] = e.Name.Lexeme
+		node["value"] = dumpExpr(e.Value)
+	case *BinaryExpr:
+		node["node"] = "Binary"
+		node["operator"] = e.Operator.Lexeme
+		node["left"] = dumpExpr(e.LeftExpression)
+		node["right"] = dumpExpr(e.RightExpression)
+	case *CallExpr:
+		node["node"] = "Call"
+		node["callee"] = dumpExpr(e.Callee)
+		node["arguments"] = dumpExprs(e.Arguments)
+	case *GetExpr:
+		node["node"] = "Get"
+		node["object"] = dumpExpr(e.Object)
+		node["name"] = e.Name.Lexeme
+	case *GroupingExpr:
+		node["node"] = "Grouping"
+		node["expression"] = dumpExpr(e.Expression)
+	case *Lit:
+		node["node"] = "Literal"
+		node["value"] = e.Value
+	case *LogicalExpr:
+		node["node"] = "Logical"
+		node["operator"] = e.Operator.Lexeme
+		node["left"] = dumpExpr(e.LeftExpression)
+		node["right"] = dumpExpr(e.RightExpression)
+	case *SetExpr:
+		node["node"] = "Set"
+		node["object"] = dumpExpr(e.Object)
+		node["name"] = e.Name.Lexeme
+		node["value"] = dumpExpr(e.Value)
+	case *SuperExpr:
+		node["node"] = "Super"
+		node["method"] = e.Method.Lexeme
+	case *ThisExpr:
+		node["node"] = "This"
+	case *UnaryExpr:
+		node["node"] = "Unary"
+		node["operator"] = e.Operator.Lexeme
+		node["operand"] = dumpExpr(e.Expression)
+	case *VarExpr:
+		node["node"] = "Variable"
+		node["name"] = e.Name.Lexeme
+	default:
+		panic(fmt.Sprintf("Unknown Expression Type: %T", expr))
+	}
+	return node
+}
@@ -0,0 +1,111 @@
+package lang
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteJSON(t *testing.T) {
+
+	script := `
+		var a = 1;
+		print -a + "b";`
+	expect := `[
+  {
+    "initializer": {
+      "line": 2,
+      "node": "Literal",
+      "value": 1
+    },
+    "line": 2,
+    "name": "a",
+    "node": "Var"
+  },
+  {
+    "expression": {
+      "left": {
+        "line": 3,
+        "node": "Unary",
+        "operand": {
+          "line": 3,
+          "name": "a",
+          "node": "Variable"
+        },
+        "operator": "-"
+      },
+      "line": 3,
+      "node": "Binary",
+      "operator": "+",
+      "right": {
+        "line": 3,
+        "node": "Literal",
+        "value": "b"
+      }
+    },
+    "line": 3,
+    "node": "Print"
+  }
+]
+`
+	b := &strings.Builder{}
+	if err := WriteJSON(b, parseScript(t, script)); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.String(); got != expect {
+		t.Errorf("Expected\n%s\nbut got\n%s", expect, got)
+	}
+}
+
+func TestWriteDot(t *testing.T) {
+
+	script := `fun f(x) { return x; }`
+	expect := `digraph ast {
+  node [shape=box];
+  n0 [label="Script"];
+  n1 [label="Function\nname: \"f\""];
+  n2 [label="Return"];
+  n3 [label="Variable\nname: \"x\""];
+  n2 -> n3 [label="value"];
+  n1 -> n2 [label="body[0]"];
+  n4 [label="\"x\"", shape=plaintext];
+  n1 -> n4 [label="params[0]"];
+  n0 -> n1 [label="0"];
+}
+`
+	b := &strings.Builder{}
+	if err := WriteDot(b, parseScript(t, script)); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.String(); got != expect {
+		t.Errorf("Expected\n%s\nbut got\n%s", expect, got)
+	}
+}
+
+func TestWriteDotHTMLCharacters(t *testing.T) {
+
+	b := &strings.Builder{}
+	if err := WriteDot(b, parseScript(t, `print "<b>&";`)); err != nil {
+		t.Fatal(err)
+	}
+	if expect := `n2 [label="Literal\nvalue: \"<b>&\""];`; !strings.Contains(b.String(), expect) {
+		t.Errorf("Expected\n%s\nin\n%s", expect, b.String())
+	}
+}
+
+// ------------------
+// Helper functions
+// ------------------
+
+func parseScript(t *testing.T, script string) []Stmt {
+
+	t.Helper()
+
+	scanner := &Scanner{}
+	tokens := scanner.ScanTokens(script)
+	parser := &Parser{}
+	statements := parser.Parse(tokens)
+	if scanner.HadError() || parser.HadError() {
+		t.Fatal("Error encountered while parsing")
+	}
+	return statements
+}
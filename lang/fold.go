@@ -0,0 +1,183 @@
+package lang
+
+// FoldConstants replaces the constant sub-expressions of the
+// program by their value (`2 * 3 + 1` becomes `7`), shrinking the
+// AST and speeding up the interpretation of hot loops.
+// The statements are updated in place and returned for convenience.
+//
+// Only operations whose result can't depend on the interpreter
+// configuration or raise a runtime error are folded: arithmetic and
+// comparisons between numbers (except division by zero), equality
+// between literals, string concatenation of string literals,
+// negation of numbers and logical not of booleans and nil.
+func FoldConstants(statements []Stmt) []Stmt {
+
+	return FoldConstantsWithLimit(statements, 0)
+}
+
+// FoldConstantsWithLimit folds the constant expressions like
+// FoldConstants, except the concatenations of string literals
+// longer than maxStringLength (if not 0), which are left to raise
+// the runtime error of the interpreter limit.
+func FoldConstantsWithLimit(statements []Stmt, maxStringLength int) []Stmt {
+
+	for _, stmt := range statements {
+		foldStmt(stmt, maxStringLength)
+	}
+	return statements
+}
+
+// foldStmt folds the constant expressions in a statement.
+func foldStmt(stmt Stmt, max int) {
+
+	switch s := stmt.(type) {
+	case *BlockStmt:
+		FoldConstantsWithLimit(s.Statements, max)
+	case *ClassDeclStmt:
+		for _, method := range s.Methods {
+			FoldConstantsWithLimit(method.Body, max)
+		}
+	case *ExprStmt:
+		s.Expression = foldExpr(s.Expression, max)
+	case *FunDeclStmt:
+		FoldConstantsWithLimit(s.Body, max)
+	case *IfStmt:
+		s.Condition = foldExpr(s.Condition, max)
+		foldStmt(s.ThenBranch, max)
+		if s.ElseBranch != nil {
+			foldStmt(s.ElseBranch, max)
+		}
+	case *PrintStmt:
+		s.Expression = foldExpr(s.Expression, max)
+	case *ReturnStmt:
+		if s.Value != nil {
+			s.Value = foldExpr(s.Value, max)
+		}
+	case *VarDeclStmt:
+		if s.Initializer != nil {
+			s.Initializer = foldExpr(s.Initializer, max)
+		}
+	case *WhileStmt:
+		s.Condition = foldExpr(s.Condition, max)
+		foldStmt(s.Body, max)
+	}
+}
+
+// foldExpr folds an expression and returns the folded expression.
+// Sub-expressions are folded in place, the strings are not
+// concatenated beyond max characters.
+func foldExpr(expr Expr, max int) Expr {
+
+	switch e := expr.(type) {
+	case *AssignExpr:
+		e.Value = foldExpr(e.Value, max)
+	case *BinaryExpr:
+		e.LeftExpression = foldExpr(e.LeftExpression, max)
+		e.RightExpression = foldExpr(e.RightExpression, max)
+		left, leftOk := e.LeftExpression.(*Lit)
+		right, rightOk := e.RightExpression.(*Lit)
+		if leftOk && rightOk {
+			if value, ok := foldBinary(e.Operator.Type, left.Value, right.Value, max); ok {
+				return &Lit{value, "", ExprStart(e)}
+			}
+		}
+	case *CallExpr:
+		e.Callee = foldExpr(e.Callee, max)
+		for i, arg := range e.Arguments {
+			e.Arguments[i] = foldExpr(arg, max)
+		}
+	case *GetExpr:
+		e.Object = foldExpr(e.Object, max)
+	case *GroupingExpr:
+		e.Expression = foldExpr(e.Expression, max)
+		if lit, ok := e.Expression.(*Lit); ok {
+			return lit
+		}
+	case *LogicalExpr:
+		e.LeftExpression = foldExpr(e.LeftExpression, max)
+		e.RightExpression = foldExpr(e.RightExpression, max)
+	case *SetExpr:
+		e.Object = foldExpr(e.Object, max)
+		e.Value = foldExpr(e.Value, max)
+	case *UnaryExpr:
+		e.Expression = foldExpr(e.Expression, max)
+		if lit, ok := e.Expression.(*Lit); ok {
+			if value, ok := foldUnary(e.Operator.Type, lit.Value); ok {
+				return &Lit{value, "", e.Operator}
+			}
+		}
+	}
+	return expr
+}
+
+// foldUnary computes the value of a unary operator applied
+// to a literal. It reports false if the operation can't be folded.
+func foldUnary(op TokenType, operand interface{}) (interface{}, bool) {
+
+	switch op {
+	case MinusToken:
+		if n, ok := operand.(float64); ok {
+			return -n, true
+		}
+	case BangToken:
+		if operand == nil {
+			return true, true
+		}
+		if b, ok := operand.(bool); ok {
+			return !b, true
+		}
+	}
+	return nil, false
+}
+
+// foldBinary computes the value of a binary operator applied
+// to two literals. It reports false if the operation can't be folded.
+func foldBinary(op TokenType, left, right interface{}, max int) (interface{}, bool) {
+
+	switch op {
+	case EqualEqualToken:
+		return left == right, true
+	case BangEqualToken:
+		return left != right, true
+	}
+
+	if l, ok := left.(string); ok {
+		if r, ok := right.(string); ok && op == PlusToken &&
+			(max == 0 || len(l)+len(r) <= max) {
+			return l + r, true
+		}
+		return nil, false
+	}
+
+	l, ok := left.(float64)
+	if !ok {
+		return nil, false
+	}
+	r, ok := right.(float64)
+	if !ok {
+		return nil, false
+	}
+
+	switch op {
+	case PlusToken:
+		return l + r, true
+	case MinusToken:
+		return l - r, true
+	case StarToken:
+		return l * r, true
+	case SlashToken:
+		if r == 0 {
+			return nil, false
+		}
+		return l / r, true
+	case GreaterToken:
+		return l > r, true
+	case GreaterEqualToken:
+		return l >= r, true
+	case LessToken:
+		return l < r, true
+	case LessEqualToken:
+		return l <= r, true
+	}
+	return nil, false
+}
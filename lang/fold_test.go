@@ -0,0 +1,94 @@
+package lang
+
+import "testing"
+
+func TestFoldConstants(t *testing.T) {
+
+	t.Run("fold constant expressions", func(t *testing.T) {
+		script := `
+			2 * 3 + 1;
+			-(1 + 2) * 4;
+			"a" + "b" + "c";
+			1 < 2;
+			1 == "1";
+			!nil;
+			(((5)));`
+		expect := []string{"7", "-12", "\"abc\"", "true", "false", "true", "5"}
+		matchFoldedAST(t, expect, script)
+	})
+
+	t.Run("fold nested statements", func(t *testing.T) {
+		script := `
+			fun area(r) { return 3 * 2 * r; }
+			class Cake { slices() { print 4 * 2; } }
+			while (x < 10 * 10) { var y = 1 + 1; }
+			if (x == 2 - 1) print x; else print 0 - 1;`
+		expect := []string{
+			"(fun area (params r) (return (* 6 (r))))",
+			"(class Cake nil (fun slices (params) (print 8)))",
+			"(while (< (x) 100) (block (var y 2)))",
+			"(if (== (x) 1) (print (x)) (print -1))"}
+		matchFoldedAST(t, expect, script)
+	})
+
+	t.Run("keep expressions with runtime semantics", func(t *testing.T) {
+		script := `
+			1 / 0;
+			"a" + 1;
+			-"a";
+			!0;
+			1 < "b";
+			true or false;
+			x + 1 * 2;`
+		expect := []string{
+			"(/ 1 0)",
+			"(+ \"a\" 1)",
+			"(- \"a\")",
+			"(! 0)",
+			"(< 1 \"b\")",
+			"(or true false)",
+			"(+ (x) 2)"}
+		matchFoldedAST(t, expect, script)
+	})
+
+	t.Run("fold strings up to the length limit", func(t *testing.T) {
+		script := `
+			"ab" + "cd";
+			"ab" + "cd" + "e";
+			"abcde" + "f";`
+		expect := []string{"\"abcd\"", "(+ \"abcd\" \"e\")", "(+ \"abcde\" \"f\")"}
+		parser := &Parser{}
+		got := FoldConstantsWithLimit(parser.Parse((&Scanner{}).ScanTokens(script)), 4)
+		for i := range got {
+			if got[i].String() != expect[i] {
+				t.Errorf("Expected statement\n'%s'\nbut got\n'%s'\nin %dth position",
+					expect[i], got[i], i+1)
+			}
+		}
+	})
+}
+
+// ------------------
+// Helper functions
+// ------------------
+
+func matchFoldedAST(t *testing.T, expect []string, script string) {
+
+	t.Helper()
+
+	parser := &Parser{}
+	got := FoldConstants(parser.Parse((&Scanner{}).ScanTokens(script)))
+	if parser.HadError() {
+		t.Fatal("Error encountered while parsing")
+	}
+
+	if len(got) != len(expect) {
+		t.Fatalf("Expected %d statements but got %d", len(expect), len(got))
+	}
+	for i := range got {
+		if got[i].String() != expect[i] {
+			t.Errorf("Expected statement\n'%s'\nbut got\n'%s'\nin %dth position",
+				expect[i], got[i], i+1)
+		}
+	}
+}
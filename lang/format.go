@@ -0,0 +1,552 @@
+package lang
+
+import (
+	"errors"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// indentation is the indentation of a block in the canonical style.
+const indentation = "    "
+
+// FormatOptions control the output of Format.
+type FormatOptions struct {
+	// Indent is the indentation of a block, 4 spaces
+	// (the canonical style) if empty.
+	Indent string
+	// Comments are placed back between the statements using their
+	// lines, they are usually the comments of the source the
+	// statements were parsed from (see Scanner.Comments).
+	Comments []*Comment
+	// Tokens are the tokens of the source, used with the comments
+	// to find its blank lines. Without them, a line holding only
+	// tokens left out of the AST (like a semicolon) looks blank.
+	Tokens []*Token
+}
+
+// Format returns the statements as lox source in the canonical style:
+// statements are indented by 4 spaces, one per line, with opening
+// braces on the line of the statement they belong to, and binary
+// operators are surrounded by spaces. Comments and single blank
+// lines between statements are preserved, using the lines of the
+// tokens.
+// The statements may also be built or modified by a tool: the
+// literals keep the lexeme they were written with when it still
+// matches their value, and parentheses are added where the
+// precedence of the operators requires them, so the source parses
+// back to an equivalent tree.
+func Format(statements []Stmt, opts FormatOptions) string {
+
+	f := newFormatter(statements, opts)
+	f.stmts(statements)
+	f.flush(math.MaxInt32)
+	if f.lineOpen {
+		f.b.WriteString("\n")
+	}
+	return f.b.String()
+}
+
+// FormatSource parses a lox script and returns it in the canonical
+// style (see Format), with its comments.
+// An error is returned if the script has syntax errors.
+func FormatSource(source string) (string, error) {
+
+	scanner := &Scanner{}
+	scanner.RedirectErrors(ioutil.Discard)
+	tokens := scanner.ScanTokens(source)
+
+	parser := &Parser{}
+	parser.RedirectErrors(ioutil.Discard)
+	statements := parser.Parse(tokens)
+
+	if scanner.HadError() || parser.HadError() {
+		diagnostics := append(scanner.Diagnostics(), parser.Diagnostics()...)
+		return "", errors.New(diagnostics[0].String())
+	}
+	return Format(statements, FormatOptions{Comments: scanner.Comments(),
+		Tokens: tokens}), nil
+}
+
+// formatter writes the AST in the canonical style. The AST doesn't
+// include the comments, they are placed back using the lines they
+// appear on in the source.
+type formatter struct {
+	b          strings.Builder
+	indentUnit string
+	indent     int
+	// comments not written yet, in source order.
+	comments []*Comment
+	// trailing comments follow a token on the same line.
+	trailing map[*Comment]bool
+	// occupied lines hold at least one token or comment.
+	occupied map[int]bool
+	// lineOpen is true when the current output line has content.
+	lineOpen bool
+	// justOpened is true when nothing was written since the
+	// last opening brace (or the beginning of the script).
+	justOpened bool
+}
+
+// newFormatter creates a formatter for the statements
+// and the comments of a script.
+func newFormatter(statements []Stmt, opts FormatOptions) *formatter {
+
+	f := &formatter{
+		indentUnit: opts.Indent,
+		comments:   opts.Comments,
+		trailing:   make(map[*Comment]bool),
+		occupied:   make(map[int]bool),
+		justOpened: true,
+	}
+	if f.indentUnit == "" {
+		f.indentUnit = indentation
+	}
+
+	firstColumn := make(map[int]int)
+	occupy := func(token *Token) {
+		if token == nil || token.Line <= 0 || token.Type == EndToken {
+			return
+		}
+		startLine := token.Line - strings.Count(token.Lexeme, "\n")
+		for line := startLine; line <= token.Line; line++ {
+			f.occupied[line] = true
+		}
+		if column, ok := firstColumn[startLine]; !ok || token.Column < column {
+			firstColumn[startLine] = token.Column
+		}
+	}
+	for _, token := range opts.Tokens {
+		occupy(token)
+	}
+	Walk(statements, func(node Node, depth int) {
+		for _, token := range nodeTokens(node) {
+			occupy(token)
+		}
+	})
+
+	for _, comment := range f.comments {
+		f.occupied[comment.Line] = true
+		if column, ok := firstColumn[comment.Line]; ok && column < comment.Column {
+			f.trailing[comment] = true
+		}
+	}
+	return f
+}
+
+// nodeTokens returns the tokens held by a node (some may be nil),
+// not including the tokens of its children.
+func nodeTokens(node Node) []*Token {
+
+	switch n := node.(type) {
+	case *BlockStmt:
+		return []*Token{n.LeftBrace, n.RightBrace}
+	case *BreakStmt:
+		return []*Token{n.Keyword}
+	case *ClassDeclStmt:
+		return []*Token{n.Name, n.RightBrace}
+	case *FunDeclStmt:
+		return append([]*Token{n.Name, n.RightBrace}, n.Params...)
+	case *IfStmt:
+		return []*Token{n.Keyword}
+	case *PrintStmt:
+		return []*Token{n.Keyword}
+	case *ReturnStmt:
+		return []*Token{n.Keyword}
+	case *VarDeclStmt:
+		return []*Token{n.Name}
+	case *WhileStmt:
+		return []*Token{n.Keyword}
+	case *AssignExpr:
+		return []*Token{n.Name}
+	case *BinaryExpr:
+		return []*Token{n.Operator}
+	case *CallExpr:
+		return []*Token{n.Paren}
+	case *GetExpr:
+		return []*Token{n.Name}
+	case *GroupingExpr:
+		return []*Token{n.LeftParen, n.RightParen}
+	case *Lit:
+		return []*Token{n.Token}
+	case *LogicalExpr:
+		return []*Token{n.Operator}
+	case *SetExpr:
+		return []*Token{n.Name}
+	case *SuperExpr:
+		return []*Token{n.Keyword, n.Method}
+	case *ThisExpr:
+		return []*Token{n.Keyword}
+	case *UnaryExpr:
+		return []*Token{n.Operator}
+	case *VarExpr:
+		return []*Token{n.Name}
+	default:
+		return nil
+	}
+}
+
+// line returns the line of a token, 0 if the node
+// was built without token.
+func line(token *Token) int {
+
+	if token == nil {
+		return 0
+	}
+	return token.Line
+}
+
+// write writes text on the current output line.
+func (f *formatter) write(text string) {
+
+	f.b.WriteString(text)
+}
+
+// begin starts a new output line for an item (a statement or
+// a comment) starting at the given source line. The comments
+// found before that line are written first.
+func (f *formatter) begin(line int) {
+
+	f.flush(line)
+	f.newLine(line)
+}
+
+// flush writes the comments found before the given source line.
+// Trailing comments are appended to the current output line.
+func (f *formatter) flush(line int) {
+
+	for len(f.comments) > 0 && f.comments[0].Line < line {
+		comment := f.comments[0]
+		f.comments = f.comments[1:]
+		text := strings.TrimRight(comment.Text, " \t\r")
+		if f.trailing[comment] && f.lineOpen {
+			// the next token can't follow a line comment,
+			// even the closing brace of an empty block.
+			f.write(" " + text)
+			f.justOpened = false
+		} else {
+			f.newLine(comment.Line)
+			f.write(text)
+		}
+	}
+}
+
+// newLine starts a new indented output line for an item starting
+// at the given source line. A blank line is kept if the item
+// followed a blank line in the source, a line without token or
+// comment, and never added otherwise. The items without line
+// (built without tokens) never follow a blank line.
+func (f *formatter) newLine(line int) {
+
+	if f.lineOpen {
+		f.write("\n")
+	}
+	if !f.justOpened && line > 1 && !f.occupied[line-1] {
+		f.write("\n")
+	}
+	f.write(strings.Repeat(f.indentUnit, f.indent))
+	f.lineOpen = true
+	f.justOpened = false
+}
+
+// block writes the content of a block, a function or a class
+// between braces. The comments found before the closing brace
+// are written inside.
+func (f *formatter) block(rightBrace *Token, content func()) {
+
+	f.write("{")
+	f.indent++
+	f.justOpened = true
+	content()
+	if rightBrace != nil {
+		f.flush(rightBrace.Line)
+	}
+	f.indent--
+	if f.justOpened {
+		// empty block
+		f.write("}")
+		f.justOpened = false
+		return
+	}
+	f.justOpened = true
+	f.newLine(line(rightBrace))
+	f.write("}")
+}
+
+// stmts writes a list of statements.
+func (f *formatter) stmts(statements []Stmt) {
+
+	for _, stmt := range statements {
+		f.stmt(stmt)
+	}
+}
+
+// stmt writes a statement on a new line.
+func (f *formatter) stmt(stmt Stmt) {
+
+	switch s := stmt.(type) {
+	case *BlockStmt:
+		if s.Desugared {
+			f.forLoop(s.Statements[0], s.Statements[1].(*WhileStmt))
+			return
+		}
+		f.begin(line(s.LeftBrace))
+		f.block(s.RightBrace, func() { f.stmts(s.Statements) })
+	case *BreakStmt:
+		f.begin(line(s.Keyword))
+		f.write("break;")
+	case *ClassDeclStmt:
+		f.begin(line(s.Name))
+		f.write("class " + s.Name.Lexeme + " ")
+		if s.Superclass != nil {
+			f.write("< " + s.Superclass.Name.Lexeme + " ")
+		}
+		f.block(s.RightBrace, func() {
+			for _, method := range s.Methods {
+				f.begin(line(method.Name))
+				f.function(method)
+			}
+		})
+	case *ExprStmt:
+		f.begin(line(ExprStart(s.Expression)))
+		f.write(f.expr(s.Expression, assignmentPrecedence) + ";")
+	case *FunDeclStmt:
+		f.begin(line(s.Name))
+		f.write("fun ")
+		f.function(s)
+	case *IfStmt:
+		f.begin(line(s.Keyword))
+		f.ifStmt(s)
+	case *PrintStmt:
+		f.begin(line(s.Keyword))
+		f.write("print " + f.expr(s.Expression, assignmentPrecedence) + ";")
+	case *ReturnStmt:
+		f.begin(line(s.Keyword))
+		if s.Value != nil {
+			f.write("return " + f.expr(s.Value, assignmentPrecedence) + ";")
+		} else {
+			f.write("return;")
+		}
+	case *VarDeclStmt:
+		f.begin(line(s.Name))
+		f.write(f.varDecl(s))
+	case *WhileStmt:
+		if s.Keyword != nil && s.Keyword.Type == ForToken {
+			f.forLoop(nil, s)
+			return
+		}
+		f.begin(line(s.Keyword))
+		f.write("while (" + f.expr(s.Condition, assignmentPrecedence) + ")")
+		f.body(s.Body)
+	}
+}
+
+// function writes the name, the parameters and the body
+// of a function or a method.
+func (f *formatter) function(fun *FunDeclStmt) {
+
+	params := make([]string, len(fun.Params))
+	for i, param := range fun.Params {
+		params[i] = param.Lexeme
+	}
+	f.write(fun.Name.Lexeme + "(" + strings.Join(params, ", ") + ") ")
+	f.block(fun.RightBrace, func() { f.stmts(fun.Body) })
+}
+
+// ifStmt writes an if statement, the "else if" are chained
+// on the same line.
+func (f *formatter) ifStmt(stmt *IfStmt) {
+
+	f.write("if (" + f.expr(stmt.Condition, assignmentPrecedence) + ")")
+	f.body(stmt.ThenBranch)
+	if stmt.ElseBranch == nil {
+		return
+	}
+
+	if isBlock(stmt.ThenBranch) {
+		f.write(" else")
+	} else {
+		// "else" follows the body without blank line, after
+		// the trailing comment of the body.
+		f.flush(line(StmtStart(stmt.ElseBranch)))
+		f.justOpened = true
+		f.newLine(0)
+		f.write("else")
+	}
+	if elseIf, ok := stmt.ElseBranch.(*IfStmt); ok {
+		f.write(" ")
+		f.ifStmt(elseIf)
+	} else {
+		f.body(stmt.ElseBranch)
+	}
+}
+
+// forLoop writes a for loop which the parser transformed into
+// a while loop. The initializer is nil if the loop doesn't have one.
+func (f *formatter) forLoop(initializer Stmt, loop *WhileStmt) {
+
+	f.begin(line(loop.Keyword))
+
+	header := "for ("
+	switch init := initializer.(type) {
+	case *VarDeclStmt:
+		header += f.varDecl(init)
+	case *ExprStmt:
+		header += f.expr(init.Expression, assignmentPrecedence) + ";"
+	default:
+		header += ";"
+	}
+
+	// a loop without condition has a literal true condition
+	// which doesn't appear in the source.
+	if lit, ok := loop.Condition.(*Lit); !ok || lit.Token != nil {
+		header += " " + f.expr(loop.Condition, assignmentPrecedence)
+	}
+	header += ";"
+
+	body := loop.Body
+	if block, ok := body.(*BlockStmt); ok && block.Desugared {
+		body = block.Statements[0]
+		increment := block.Statements[1].(*ExprStmt).Expression
+		header += " " + f.expr(increment, assignmentPrecedence)
+	}
+	f.write(header + ")")
+	f.body(body)
+}
+
+// body writes the body of an if, a while or a for statement,
+// on the same line if it is a block.
+func (f *formatter) body(stmt Stmt) {
+
+	if isBlock(stmt) {
+		block := stmt.(*BlockStmt)
+		f.write(" ")
+		f.block(block.RightBrace, func() { f.stmts(block.Statements) })
+		return
+	}
+	f.indent++
+	f.justOpened = true
+	f.stmt(stmt)
+	f.indent--
+}
+
+// isBlock checks if a statement is a block appearing in the source.
+func isBlock(stmt Stmt) bool {
+
+	block, ok := stmt.(*BlockStmt)
+	return ok && !block.Desugared
+}
+
+// varDecl returns a variable declaration as source code.
+func (f *formatter) varDecl(stmt *VarDeclStmt) string {
+
+	if stmt.Initializer != nil {
+		return "var " + stmt.Name.Lexeme + " = " +
+			f.expr(stmt.Initializer, assignmentPrecedence) + ";"
+	}
+	return "var " + stmt.Name.Lexeme + ";"
+}
+
+// expr returns an expression as source code. The expression is
+// parenthesized if its operator binds less tightly than min, the
+// precedence expected where it appears.
+func (f *formatter) expr(expr Expr, min precedence) string {
+
+	var source string
+	prec := callPrecedence + 1
+	switch e := expr.(type) {
+	case *AssignExpr:
+		// assignments are right associative.
+		source = e.Name.Lexeme + " = " + f.expr(e.Value, assignmentPrecedence)
+		prec = assignmentPrecedence
+	case *BinaryExpr:
+		// binary operators are left associative.
+		prec = infixParselets[e.Operator.Type].precedence
+		source = f.expr(e.LeftExpression, prec) + " " + e.Operator.Lexeme + " " +
+			f.expr(e.RightExpression, prec+1)
+	case *CallExpr:
+		args := make([]string, len(e.Arguments))
+		for i, arg := range e.Arguments {
+			args[i] = f.expr(arg, assignmentPrecedence)
+		}
+		source = f.expr(e.Callee, callPrecedence) + "(" + strings.Join(args, ", ") + ")"
+		prec = callPrecedence
+	case *GetExpr:
+		source = f.expr(e.Object, callPrecedence) + "." + e.Name.Lexeme
+		prec = callPrecedence
+	case *GroupingExpr:
+		source = "(" + f.expr(e.Expression, assignmentPrecedence) + ")"
+	case *Lit:
+		source, prec = literal(e)
+	case *LogicalExpr:
+		prec = infixParselets[e.Operator.Type].precedence
+		source = f.expr(e.LeftExpression, prec) + " " + e.Operator.Lexeme + " " +
+			f.expr(e.RightExpression, prec+1)
+	case *SetExpr:
+		source = f.expr(e.Object, callPrecedence) + "." + e.Name.Lexeme + " = " +
+			f.expr(e.Value, assignmentPrecedence)
+		prec = assignmentPrecedence
+	case *SuperExpr:
+		source = "super." + e.Method.Lexeme
+	case *ThisExpr:
+		source = "this"
+	case *UnaryExpr:
+		source = e.Operator.Lexeme + f.expr(e.Expression, unaryPrecedence)
+		prec = unaryPrecedence
+	case *VarExpr:
+		source = e.Name.Lexeme
+	}
+	if prec < min {
+		return "(" + source + ")"
+	}
+	return source
+}
+
+// literal returns a literal as source code and its precedence.
+// Its lexeme is used if it still matches the value (a tool may have
+// changed the value), so the numbers keep the author's spelling.
+func literal(lit *Lit) (string, precedence) {
+
+	if value, ok := literalValue(lit.Lexeme); ok && value == lit.Value {
+		return lit.Lexeme, callPrecedence + 1
+	}
+	switch v := lit.Value.(type) {
+	case float64:
+		source := strconv.FormatFloat(v, 'f', -1, 64)
+		if v < 0 || (v == 0 && math.Signbit(v)) {
+			// a negative number is a negation.
+			return source, unaryPrecedence
+		}
+		return source, callPrecedence + 1
+	case string:
+		return `"` + v + `"`, callPrecedence + 1
+	case bool:
+		return strconv.FormatBool(v), callPrecedence + 1
+	default:
+		return "nil", callPrecedence + 1
+	}
+}
+
+// literalValue returns the value of the lexeme of a literal,
+// ok is false if it is not a literal.
+func literalValue(lexeme string) (value interface{}, ok bool) {
+
+	switch {
+	case lexeme == "":
+		return nil, false
+	case lexeme[0] == '"':
+		return strings.Trim(lexeme, `"`), true
+	case lexeme[0] >= '0' && lexeme[0] <= '9':
+		n, err := strconv.ParseFloat(lexeme, 64)
+		return n, err == nil
+	case lexeme == "true":
+		return true, true
+	case lexeme == "false":
+		return false, true
+	case lexeme == "nil":
+		return nil, true
+	default:
+		return nil, false
+	}
+}
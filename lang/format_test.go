@@ -0,0 +1,123 @@
+package lang
+
+import "testing"
+
+func TestFormat(t *testing.T) {
+
+	tests := []struct {
+		script string
+		expect string
+	}{
+		{"var a=1;   // one\nvar   b = (a+2)*-3;\n",
+			"var a = 1; // one\nvar b = (a + 2) * -3;\n"},
+		{"// header\n\n\nfun add(x,y){return x+y;}\n",
+			"// header\n\nfun add(x, y) {\n    return x + y;\n}\n"},
+		{"class A < B {\n  m(){ print this.x; }\n\n  // n\n  n() {}\n}\n",
+			"class A < B {\n    m() {\n        print this.x;\n    }\n\n    // n\n    n() {}\n}\n"},
+		{"for(var i=0;i<3;i=i+1) print i;\nfor(;;){ }\n",
+			"for (var i = 0; i < 3; i = i + 1)\n    print i;\nfor (;;) {}\n"},
+		{"if (a) print a; else if (b) { print b; } else print c;\n",
+			"if (a)\n    print a;\nelse if (b) {\n    print b;\n} else\n    print c;\n"},
+		{"while (a) {\n  a = a - 1;\n  // last\n} // end\n",
+			"while (a) {\n    a = a - 1;\n    // last\n} // end\n"},
+		{"while(true){if(a)break;}\n",
+			"while (true) {\n    if (a)\n        break;\n}\n"},
+		// the lexemes of the literals and the parentheses are kept.
+		{"var x=(1.50)*((a))+0.0;\n", "var x = (1.50) * ((a)) + 0.0;\n"},
+		// a blank line is only kept, never added.
+		{"var a = f(1,\n  2)\n;\nprint a;\n\nprint b;\n",
+			"var a = f(1, 2);\nprint a;\n\nprint b;\n"},
+	}
+
+	for _, test := range tests {
+		got, err := FormatSource(test.script)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", test.script, err)
+			continue
+		}
+		if got != test.expect {
+			t.Errorf("Expected\n%s\nbut got\n%s", test.expect, got)
+		}
+		// formatting is idempotent
+		if again, _ := FormatSource(got); again != got {
+			t.Errorf("Expected\n%s\nbut got\n%s", got, again)
+		}
+	}
+}
+
+func TestFormatComments(t *testing.T) {
+
+	scripts := []string{
+		// after an opening brace
+		"class A { // cls\n  m() { // meth\n  }\n}\n",
+		"fun f() { // f\n}\nwhile (a) { // w\n  a = a - 1;\n}\n",
+		// after a semicolon
+		"var a = 1; // one\nfun f() {\n  return a; // ret\n}\n",
+		"if (a) print a; // then\nelse print b; // else\n",
+		// before a closing brace
+		"{\n  print 1;\n  // before\n}\nclass B {\n  // none\n}\n",
+	}
+
+	for _, script := range scripts {
+		once, err := FormatSource(script)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", script, err)
+			continue
+		}
+		twice, err := FormatSource(once)
+		if err != nil {
+			t.Errorf("Unexpected error for the formatted %q: %v", once, err)
+			continue
+		}
+		if twice != once {
+			t.Errorf("Expected\n%s\nbut got\n%s", once, twice)
+		}
+	}
+}
+
+func TestFormatError(t *testing.T) {
+
+	if _, err := FormatSource("print 1"); err == nil {
+		t.Error("Expected a syntax error")
+	}
+}
+
+func TestFormatAST(t *testing.T) {
+
+	tests := []struct {
+		script string
+		expect string
+	}{
+		// folded constants are written with their value.
+		{"print 2 * 3 + 1;\n", "print 7;\n"},
+		{"print (1 - 4) * a;\n", "print -3 * a;\n"},
+		{"print \"a\" + \"b\";\n", "print \"ab\";\n"},
+		{"print 1.50 + a;\n", "print 1.50 + a;\n"},
+		{"print 2.0 * 1;\n", "print 2;\n"},
+	}
+
+	for _, test := range tests {
+		scanner := &Scanner{}
+		parser := &Parser{}
+		statements := FoldConstants(parser.Parse(scanner.ScanTokens(test.script)))
+		got := Format(statements, FormatOptions{})
+		if got != test.expect {
+			t.Errorf("Expected\n%s\nbut got\n%s", test.expect, got)
+		}
+	}
+
+	// the parentheses required by the precedence are added
+	// to the trees built without grouping.
+	sum := &BinaryExpr{
+		&VarExpr{Name: &Token{Type: IdentifierToken, Lexeme: "a"}},
+		&Token{Type: PlusToken, Lexeme: "+"},
+		&Lit{Value: 1.0}}
+	product := &BinaryExpr{sum, &Token{Type: StarToken, Lexeme: "*"}, sum}
+	statements := []Stmt{&PrintStmt{Keyword: &Token{Type: PrintToken, Lexeme: "print"},
+		Expression: &UnaryExpr{&Token{Type: MinusToken, Lexeme: "-"}, product}}}
+	got := Format(statements, FormatOptions{Indent: "\t"})
+	expect := "print -((a + 1) * (a + 1));\n"
+	if got != expect {
+		t.Errorf("Expected\n%s\nbut got\n%s", expect, got)
+	}
+}
@@ -0,0 +1,48 @@
+//go:build go1.18
+// +build go1.18
+
+package lang
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// addExamples adds the example scripts to the seed corpus.
+func addExamples(f *testing.F) {
+
+	files, _ := filepath.Glob("../examples/*.lox")
+	for _, file := range files {
+		script, err := ioutil.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(script))
+	}
+	f.Add("print (1 + 2) * -3;")
+	f.Add("class A < B { init() { super.init(); this.x = 1; } }")
+	f.Add("for (var i = 0; i < 10; i = i + 1) { if (i > 5) break; else print i; }")
+}
+
+// FuzzSafeParse checks that SafeParse never panics, nor the tools
+// printing and formatting the AST it returns.
+func FuzzSafeParse(f *testing.F) {
+
+	addExamples(f)
+	f.Fuzz(func(t *testing.T, source string) {
+		statements, err := SafeParse(source)
+		var panicked *PanicError
+		if errors.As(err, &panicked) {
+			t.Fatalf("%v\n%s", panicked, panicked.Stack)
+		}
+		if err == nil {
+			// the tools working on the AST must not panic either.
+			for _, stmt := range statements {
+				stmt.PrettyPrint("\n", "  ")
+			}
+			Format(statements, FormatOptions{})
+		}
+	})
+}
@@ -0,0 +1,93 @@
+package lang
+
+import (
+	"io/ioutil"
+	"strings"
+)
+
+// ANSI colors of the highlighted tokens.
+const (
+	ansiMagenta = "\x1b[35m"
+	ansiGreen   = "\x1b[32m"
+	ansiCyan    = "\x1b[36m"
+	ansiGray    = "\x1b[90m"
+)
+
+// Highlight returns the source code with its keywords, strings,
+// numbers and comments colored with ANSI escape sequences. The
+// source doesn't need to be valid, the parts the scanner doesn't
+// recognize (like an unterminated string) are left as is.
+func Highlight(source string) string {
+
+	runes := []rune(source)
+	return colorize(runes, highlightColors(source))
+}
+
+// highlightColors returns the color of each character of the
+// source, "" for the characters which are not highlighted.
+func highlightColors(source string) []string {
+
+	scanner := &Scanner{}
+	scanner.RedirectErrors(ioutil.Discard)
+	tokens := scanner.ScanTokens(source)
+
+	lineStarts := []int{0}
+	for offset, r := range []rune(source) {
+		if r == '\n' {
+			lineStarts = append(lineStarts, offset+1)
+		}
+	}
+	colors := make([]string, len([]rune(source)))
+	paint := func(line, column int, text string, color string) {
+		start := lineStarts[line-1] + column - 1
+		for offset := range []rune(text) {
+			colors[start+offset] = color
+		}
+	}
+
+	for _, token := range tokens {
+		var color string
+		switch token.Type {
+		case StringToken:
+			color = ansiGreen
+		case NumberToken:
+			color = ansiCyan
+		case EndToken, IdentifierToken:
+			continue
+		default:
+			if _, isKeyword := keywords[token.Lexeme]; !isKeyword {
+				continue
+			}
+			color = ansiMagenta
+		}
+		// a multiline string is reported on its last line
+		// but its column is in its first line.
+		line := token.Line - strings.Count(token.Lexeme, "\n")
+		paint(line, token.Column, token.Lexeme, color)
+	}
+	for _, comment := range scanner.Comments() {
+		paint(comment.Line, comment.Column, comment.Text, ansiGray)
+	}
+	return colors
+}
+
+// colorize returns the characters with their colors.
+func colorize(runes []rune, colors []string) string {
+
+	b := strings.Builder{}
+	current := ""
+	for n, r := range runes {
+		if colors[n] != current {
+			if current != "" {
+				b.WriteString(ansiReset)
+			}
+			b.WriteString(colors[n])
+			current = colors[n]
+		}
+		b.WriteRune(r)
+	}
+	if current != "" {
+		b.WriteString(ansiReset)
+	}
+	return b.String()
+}
@@ -0,0 +1,23 @@
+package lang
+
+import "testing"
+
+func TestHighlight(t *testing.T) {
+
+	tests := []struct {
+		source string
+		expect string
+	}{
+		{`print "a" + 1; // one`,
+			"\x1b[35mprint\x1b[0m \x1b[32m\"a\"\x1b[0m + \x1b[36m1\x1b[0m; \x1b[90m// one\x1b[0m"},
+		{"var s = \"a\nb\";",
+			"\x1b[35mvar\x1b[0m s = \x1b[32m\"a\nb\"\x1b[0m;"},
+		{`if (x) "unterminated`,
+			"\x1b[35mif\x1b[0m (x) \"unterminated"},
+	}
+	for _, test := range tests {
+		if got := Highlight(test.source); got != test.expect {
+			t.Errorf("expected %q, got %q", test.expect, got)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package lang
+
+import "io/ioutil"
+
+// Document is a parsed script which can be parsed again after an
+// edit, reusing the top-level statements the edit didn't touch.
+// The editors reparse the script after each keystroke: only the
+// statements around the edit are parsed again, which keeps large
+// files responsive.
+type Document struct {
+	tokens      []*Token
+	comments    []*Comment
+	statements  []docStmt
+	diagnostics []Diagnostic
+	reused      int
+}
+
+// docStmt is a top-level statement of a document. Its tokens are
+// tokens[start:end] and it is clean if it was parsed without error.
+type docStmt struct {
+	stmt       Stmt
+	start, end int
+	clean      bool
+}
+
+// ParseDocument scans and parses a script.
+func ParseDocument(source string) *Document {
+
+	return (&Document{}).Update(source)
+}
+
+// Update parses the new source of the document and returns the
+// new document. The new tokens are compared with the old ones: the
+// statements made of the same tokens, before and after the edit,
+// are reused (their tokens are moved to their new line and column)
+// and the tokens in between are parsed again. The result is the
+// AST a full parse would produce.
+// The document d must not be used after the update, since its
+// statements may be shared with the new document.
+func (d *Document) Update(source string) *Document {
+
+	scanner := &Scanner{}
+	scanner.RedirectErrors(ioutil.Discard)
+	tokens := scanner.ScanTokens(source)
+
+	// the tokens before the edit are the same, at the same place.
+	prefix := 0
+	for prefix < len(d.tokens) && prefix < len(tokens) &&
+		sameToken(d.tokens[prefix], tokens[prefix]) &&
+		d.tokens[prefix].Line == tokens[prefix].Line &&
+		d.tokens[prefix].Column == tokens[prefix].Column {
+		tokens[prefix] = d.tokens[prefix]
+		prefix++
+	}
+	// the tokens after the edit are the same, maybe moved.
+	suffix := 0
+	shift := len(tokens) - len(d.tokens)
+	for suffix < len(d.tokens)-prefix && suffix < len(tokens)-prefix &&
+		sameToken(d.tokens[len(d.tokens)-1-suffix], tokens[len(tokens)-1-suffix]) {
+		suffix++
+	}
+
+	// the clean statements made of unchanged tokens are reused.
+	var before, after []docStmt
+	for _, stmt := range d.statements {
+		if !stmt.clean {
+			break
+		}
+		// the statement ending at the edit may continue after it
+		// (an else appended to an if).
+		if stmt.end >= prefix {
+			break
+		}
+		before = append(before, stmt)
+	}
+	for i := len(d.statements) - 1; i >= len(before); i-- {
+		stmt := d.statements[i]
+		if !stmt.clean || stmt.start <= len(d.tokens)-suffix {
+			break
+		}
+		for j := stmt.start; j < stmt.end; j++ {
+			old, moved := d.tokens[j], tokens[j+shift]
+			old.Line, old.Column = moved.Line, moved.Column
+			tokens[j+shift] = old
+		}
+		stmt.start += shift
+		stmt.end += shift
+		after = append([]docStmt{stmt}, after...)
+	}
+
+	parser := &Parser{}
+	parser.RedirectErrors(ioutil.Discard)
+	parser.SetComments(scanner.Comments())
+	parser.reset(tokens)
+
+	updated := &Document{
+		tokens:     tokens,
+		comments:   scanner.Comments(),
+		statements: before,
+		reused:     len(before),
+	}
+	if len(before) > 0 {
+		parser.current = before[len(before)-1].end
+	}
+	updated.parse(parser, after)
+
+	// the doc comments are not tokens, they may have changed.
+	for _, stmt := range updated.statements {
+		if stmt.clean {
+			parser.updateDocs(stmt.stmt)
+		}
+	}
+	updated.diagnostics = append(scanner.Diagnostics(), parser.Diagnostics()...)
+	return updated
+}
+
+// parse parses the top-level statements from the current token of
+// the parser until it reaches the first reusable statement following
+// the edit (the statements parsed may extend over some of them).
+func (d *Document) parse(p *Parser, after []docStmt) {
+
+	// stop parsing altogether when too many errors are reported.
+	defer func() {
+		if e := recover(); e != nil {
+			if e != errTooManyErrors {
+				panic(e)
+			}
+		}
+	}()
+
+	for !p.isAtEnd() {
+		for len(after) > 0 && p.current > after[0].start {
+			after = after[1:]
+		}
+		if len(after) > 0 && p.current == after[0].start {
+			d.statements = append(d.statements, after...)
+			d.reused += len(after)
+			return
+		}
+		start, errors := p.current, p.errorCount
+		stmt := p.declaration()
+		d.statements = append(d.statements,
+			docStmt{stmt, start, p.current, p.errorCount == errors})
+	}
+}
+
+// updateDocs sets the doc comments of a declaration
+// and of its methods.
+func (p *Parser) updateDocs(stmt Stmt) {
+
+	switch s := stmt.(type) {
+	case *FunDeclStmt:
+		s.Doc = p.docComment(s.Name)
+	case *ClassDeclStmt:
+		s.Doc = p.docComment(s.Name)
+		for _, method := range s.Methods {
+			method.Doc = p.docComment(method.Name)
+		}
+	}
+}
+
+// sameToken returns true if the tokens have the same type and lexeme.
+func sameToken(a, b *Token) bool {
+
+	return a.Type == b.Type && a.Lexeme == b.Lexeme
+}
+
+// Statements returns the AST of the document.
+func (d *Document) Statements() []Stmt {
+
+	statements := make([]Stmt, len(d.statements))
+	for i, stmt := range d.statements {
+		statements[i] = stmt.stmt
+	}
+	return statements
+}
+
+// Tokens returns the tokens of the document.
+func (d *Document) Tokens() []*Token {
+
+	return d.tokens
+}
+
+// Comments returns the comments of the document.
+func (d *Document) Comments() []*Comment {
+
+	return d.comments
+}
+
+// Diagnostics returns the errors reported by the scanner
+// and the parser.
+func (d *Document) Diagnostics() []Diagnostic {
+
+	return d.diagnostics
+}
+
+// HadError reports if the document has syntax errors.
+func (d *Document) HadError() bool {
+
+	return len(d.diagnostics) > 0
+}
+
+// Reused returns the number of top-level statements reused
+// from the previous version of the document.
+func (d *Document) Reused() int {
+
+	return d.reused
+}
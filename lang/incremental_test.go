@@ -0,0 +1,134 @@
+package lang
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestDocumentUpdate(t *testing.T) {
+
+	script := `var a = 1;
+/// Adds one.
+fun inc(x) {
+  return x + 1;
+}
+print inc(a);
+class A {
+  m() { print "m"; }
+}
+print a;
+`
+	tests := []struct {
+		old, new string
+		reused   int
+	}{
+		// edit inside a statement.
+		{"return x + 1;", "return x + 2;", 4},
+		// the line of the following statements changes, the
+		// statements next to the edit are parsed again.
+		{"var a = 1;", "var a = 1;\n\nvar b = 2;", 3},
+		// the doc comment changes, not the tokens.
+		{"/// Adds one.", "/// Increments.", 5},
+		// syntax error (the class is skipped by the parser
+		// synchronization), then fixed.
+		{"print inc(a);", "print inc(a)", 3},
+		// a statement swallows the following ones.
+		{"return x + 1;\n}", "return x + 1;", 1},
+	}
+
+	for _, test := range tests {
+		doc := ParseDocument(script)
+		edited := strings.Replace(script, test.old, test.new, 1)
+		doc = doc.Update(edited)
+		if doc.Reused() != test.reused {
+			t.Errorf("%q: expected %d statements reused but got %d",
+				test.new, test.reused, doc.Reused())
+		}
+		expectDocument(t, edited, doc)
+
+		// back to the original script.
+		doc = doc.Update(script)
+		expectDocument(t, script, doc)
+	}
+}
+
+func TestDocumentUpdateRandom(t *testing.T) {
+
+	// the statement ending at the edit is parsed again.
+	doc := ParseDocument("if (a) print 1;")
+	expectDocument(t, "if (a) print 1; else print 2;",
+		doc.Update("if (a) print 1; else print 2;"))
+
+	fragments := []string{"print a;", " else print 2;", "if (a) ", "{", "}",
+		"var b = 1;", "fun f(x) { return x; }", "\n", ";", " + 1", "class A {}",
+		"while (b) ", "/// doc\n", "\"s\"", "(", ")"}
+	random := rand.New(rand.NewSource(1))
+	source := "var a = 1;\nprint a;\nfun g() { return a; }\n"
+	doc = ParseDocument(source)
+	for n := 0; n < 500; n++ {
+		start := random.Intn(len(source) + 1)
+		end := start
+		if random.Intn(3) == 0 {
+			end += random.Intn(len(source) - start + 1)
+		}
+		fragment := ""
+		if end == start || random.Intn(2) == 0 {
+			fragment = fragments[random.Intn(len(fragments))]
+		}
+		source = source[:start] + fragment + source[end:]
+		doc = doc.Update(source)
+		if full := ParseDocument(source); full.HadError() {
+			// the statements with errors may hold nil statements.
+			if len(doc.Diagnostics()) != len(full.Diagnostics()) {
+				t.Errorf("Expected %v but got %v", full.Diagnostics(), doc.Diagnostics())
+			}
+		} else {
+			expectDocument(t, source, doc)
+		}
+		if t.Failed() {
+			t.Fatalf("After the edit of %q", source)
+		}
+	}
+}
+
+// expectDocument checks that the document is the same as
+// the one parsed from scratch from the source.
+func expectDocument(t *testing.T, source string, doc *Document) {
+
+	full := ParseDocument(source)
+	var expect, got bytes.Buffer
+	WriteJSON(&expect, withoutErrors(full.Statements()))
+	WriteJSON(&got, withoutErrors(doc.Statements()))
+	if expect.String() != got.String() {
+		t.Errorf("Expected\n%s\nbut got\n%s", expect.String(), got.String())
+	}
+	if len(doc.Diagnostics()) != len(full.Diagnostics()) {
+		t.Errorf("Expected %v but got %v", full.Diagnostics(), doc.Diagnostics())
+	}
+	if len(doc.Statements()) != len(full.Statements()) {
+		t.Fatalf("Expected %d statements but got %d",
+			len(full.Statements()), len(doc.Statements()))
+	}
+	for i, stmt := range doc.Statements() {
+		if fun, ok := stmt.(*FunDeclStmt); ok {
+			expect := full.Statements()[i].(*FunDeclStmt).Doc
+			if fun.Doc != expect {
+				t.Errorf("Expected doc %q but got %q", expect, fun.Doc)
+			}
+		}
+	}
+}
+
+// withoutErrors removes the statements which couldn't be parsed.
+func withoutErrors(statements []Stmt) []Stmt {
+
+	var parsed []Stmt
+	for _, stmt := range statements {
+		if stmt != nil {
+			parsed = append(parsed, stmt)
+		}
+	}
+	return parsed
+}
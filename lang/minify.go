@@ -0,0 +1,43 @@
+package lang
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Minify writes the tokens of a script as compact source code: the
+// comments and the whitespace are dropped, and the tokens are
+// separated by a space only when they would merge otherwise (like
+// two identifiers). The tokens listed in names are written with
+// their new name instead of their lexeme.
+func Minify(tokens []*Token, names map[*Token]string) string {
+
+	var b strings.Builder
+	var previous string
+	for _, token := range tokens {
+		if token.Type == EndToken {
+			continue
+		}
+		lexeme := token.Lexeme
+		if name, ok := names[token]; ok {
+			lexeme = name
+		}
+		if previous != "" {
+			last, _ := utf8.DecodeLastRuneInString(previous)
+			first, _ := utf8.DecodeRuneInString(lexeme)
+			if isAlphaNumeric(last) && isAlphaNumeric(first) {
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString(lexeme)
+		previous = lexeme
+	}
+	return b.String()
+}
+
+// IsKeyword returns true if the name is a reserved lox keyword.
+func IsKeyword(name string) bool {
+
+	_, ok := keywords[name]
+	return ok
+}
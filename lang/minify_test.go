@@ -0,0 +1,13 @@
+package lang
+
+import "testing"
+
+func TestMinify(t *testing.T) {
+
+	script := "// comment\nvar a = 1 ;\nfun f(x, y) {\n  return x.y - -1;  // trailing\n}\nprint \"a  b\" + a;\n"
+	expect := `var a=1;fun f(x,y){return x.y--1;}print"a  b"+a;`
+	scanner := &Scanner{}
+	if got := Minify(scanner.ScanTokens(script), nil); got != expect {
+		t.Errorf("Expected %q but got %q", expect, got)
+	}
+}
@@ -0,0 +1,399 @@
+package lang
+
+// Pass is an optimization pass over the AST. It rewrites the
+// statements of a program (in place or not) and returns the
+// statements to run instead. A pass must not change the behavior
+// of the program.
+// The passes run on a resolved program and may move or remove
+// declarations, the program must be resolved again afterwards.
+type Pass interface {
+	Run(statements []Stmt) []Stmt
+}
+
+// PassManager runs a sequence of passes, in order.
+type PassManager struct {
+	passes []Pass
+}
+
+// NewPassManager creates a pass manager running the passes.
+func NewPassManager(passes ...Pass) *PassManager {
+
+	return &PassManager{passes}
+}
+
+// Add appends a pass to the sequence.
+func (m *PassManager) Add(pass Pass) {
+
+	m.passes = append(m.passes, pass)
+}
+
+// Run runs the passes on the statements and returns the
+// optimized statements.
+func (m *PassManager) Run(statements []Stmt) []Stmt {
+
+	for _, pass := range m.passes {
+		statements = pass.Run(statements)
+	}
+	return statements
+}
+
+// OptimizationPasses returns the passes of an optimization level:
+//   - 0: no pass
+//   - 1: constant folding, removal of the always-false branches
+//     and dead-code elimination
+//   - 2 and more: level 1 and the hoisting of the loop-invariant
+//     literal variables
+func OptimizationPasses(level int) []Pass {
+
+	var passes []Pass
+	if level >= 1 {
+		passes = append(passes, ConstantFolding{}, BranchElimination{}, DeadCodeElimination{})
+	}
+	if level >= 2 {
+		passes = append(passes, LiteralHoisting{})
+	}
+	return passes
+}
+
+// ConstantFolding is the pass folding the constant expressions
+// (see FoldConstantsWithLimit). The concatenations of strings
+// longer than MaxStringLength, if not 0, are not folded.
+type ConstantFolding struct {
+	MaxStringLength int
+}
+
+// Run folds the constant expressions of the statements.
+func (pass ConstantFolding) Run(statements []Stmt) []Stmt {
+
+	return FoldConstantsWithLimit(statements, pass.MaxStringLength)
+}
+
+// BranchElimination is the pass replacing the if statements with
+// a constant condition by the branch always taken, and removing
+// the loops never entered.
+// Only the conditions true, false and nil are constant: the
+// truthiness of the other literals depends on the interpreter
+// configuration.
+type BranchElimination struct{}
+
+// Run removes the branches never taken from the statements.
+func (BranchElimination) Run(statements []Stmt) []Stmt {
+
+	return rewriteStmts(statements, func(stmt Stmt) Stmt {
+		switch s := stmt.(type) {
+		case *IfStmt:
+			if truthy, ok := constantCondition(s.Condition); ok {
+				if truthy {
+					return s.ThenBranch
+				}
+				return s.ElseBranch
+			}
+		case *WhileStmt:
+			if truthy, ok := constantCondition(s.Condition); ok && !truthy {
+				return nil
+			}
+		}
+		return stmt
+	})
+}
+
+// DeadCodeElimination is the pass removing the statements which
+// have no effect: the literal expression statements and the
+// statements following a return or a break. The logical operators
+// with a constant left operand are replaced by their result.
+type DeadCodeElimination struct{}
+
+// Run removes the dead code from the statements.
+func (DeadCodeElimination) Run(statements []Stmt) []Stmt {
+
+	return rewriteStmts(statements, func(stmt Stmt) Stmt {
+		switch s := stmt.(type) {
+		case *BlockStmt:
+			if len(s.Statements) != len(reachable(s.Statements)) {
+				s.Statements = reachable(s.Statements)
+				s.Desugared = false
+			}
+		case *FunDeclStmt:
+			s.Body = reachable(s.Body)
+		case *ClassDeclStmt:
+			for _, method := range s.Methods {
+				method.Body = reachable(method.Body)
+			}
+		}
+		rewriteStmtExprs(stmt, shortCircuit)
+		if s, ok := stmt.(*ExprStmt); ok {
+			if _, ok := s.Expression.(*Lit); ok {
+				return nil
+			}
+		}
+		return stmt
+	})
+}
+
+// reachable removes the statements following a return or a break.
+func reachable(statements []Stmt) []Stmt {
+
+	for i, stmt := range statements {
+		switch stmt.(type) {
+		case *ReturnStmt, *BreakStmt:
+			return statements[:i+1]
+		}
+	}
+	return statements
+}
+
+// shortCircuit replaces a logical expression with a constant
+// left operand by the operand it evaluates to.
+func shortCircuit(expr Expr) Expr {
+
+	logical, ok := expr.(*LogicalExpr)
+	if !ok {
+		return expr
+	}
+	truthy, ok := constantCondition(logical.LeftExpression)
+	if !ok {
+		return expr
+	}
+	if truthy == (logical.Operator.Type == OrToken) {
+		return logical.LeftExpression
+	}
+	return logical.RightExpression
+}
+
+// LiteralHoisting is the pass moving out of the loops the local
+// variables initialized with a literal and never assigned, so they
+// are defined once instead of once per iteration: the loop is
+// wrapped in a block starting with the hoisted declarations.
+// The variables are only hoisted if their name isn't used for
+// anything else in the loop and the loop doesn't declare functions
+// or classes, which could capture them.
+type LiteralHoisting struct{}
+
+// Run hoists the loop-invariant literal variables of the statements.
+func (LiteralHoisting) Run(statements []Stmt) []Stmt {
+
+	return rewriteStmts(statements, func(stmt Stmt) Stmt {
+		loop, ok := stmt.(*WhileStmt)
+		if !ok {
+			return stmt
+		}
+		body := loopBody(loop)
+		if body == nil || declaresFunctions(loop) {
+			return stmt
+		}
+		uses := nameUses(loop)
+		var hoisted, kept []Stmt
+		for i, s := range body.Statements {
+			decl, ok := s.(*VarDeclStmt)
+			if ok && isHoistable(decl, body.Statements[i+1:], uses) {
+				hoisted = append(hoisted, decl)
+				continue
+			}
+			kept = append(kept, s)
+		}
+		if len(hoisted) == 0 {
+			return stmt
+		}
+		body.Statements = kept
+		return &BlockStmt{Statements: append(hoisted, loop)}
+	})
+}
+
+// isHoistable returns true if the declaration has a literal
+// initializer and the only uses of its name in the loop are
+// the reads from the statements following it.
+func isHoistable(decl *VarDeclStmt, following []Stmt, uses map[string]int) bool {
+
+	if _, ok := decl.Initializer.(*Lit); !ok {
+		return false
+	}
+	reads := 0
+	Walk(following, func(node Node, depth int) {
+		if v, ok := node.(*VarExpr); ok && v.Name.Lexeme == decl.Name.Lexeme {
+			reads++
+		}
+	})
+	return uses[decl.Name.Lexeme] == 1+reads
+}
+
+// loopBody returns the block of the body of a loop (without the
+// increment of a for loop), nil if it is not a block.
+func loopBody(loop *WhileStmt) *BlockStmt {
+
+	body := loop.Body
+	if block, ok := body.(*BlockStmt); ok && block.Desugared {
+		body = block.Statements[0]
+	}
+	block, _ := body.(*BlockStmt)
+	return block
+}
+
+// declaresFunctions returns true if the loop declares
+// a function or a class.
+func declaresFunctions(loop *WhileStmt) bool {
+
+	found := false
+	Walk([]Stmt{loop}, func(node Node, depth int) {
+		switch node.(type) {
+		case *FunDeclStmt, *ClassDeclStmt:
+			found = true
+		}
+	})
+	return found
+}
+
+// nameUses counts the declarations, the assignments and the reads
+// of each variable name in the loop.
+func nameUses(loop *WhileStmt) map[string]int {
+
+	uses := make(map[string]int)
+	Walk([]Stmt{loop}, func(node Node, depth int) {
+		switch n := node.(type) {
+		case *VarDeclStmt:
+			uses[n.Name.Lexeme]++
+		case *AssignExpr:
+			uses[n.Name.Lexeme]++
+		case *VarExpr:
+			uses[n.Name.Lexeme]++
+		}
+	})
+	return uses
+}
+
+// constantCondition returns the truthiness of a condition if it
+// is a literal true, false or nil.
+func constantCondition(expr Expr) (truthy bool, ok bool) {
+
+	lit, ok := expr.(*Lit)
+	if !ok {
+		return false, false
+	}
+	switch v := lit.Value.(type) {
+	case bool:
+		return v, true
+	case nil:
+		return false, true
+	default:
+		return false, false
+	}
+}
+
+// rewriteStmts rewrites a list of statements, the children of the
+// statements first. The statements rewritten to nil are removed.
+func rewriteStmts(statements []Stmt, rewrite func(Stmt) Stmt) []Stmt {
+
+	var rewritten []Stmt
+	for _, stmt := range statements {
+		if stmt = rewriteStmt(stmt, rewrite); stmt != nil {
+			rewritten = append(rewritten, stmt)
+		}
+	}
+	return rewritten
+}
+
+// rewriteStmt rewrites a statement, its children first. It returns
+// nil if the statement is removed.
+func rewriteStmt(stmt Stmt, rewrite func(Stmt) Stmt) Stmt {
+
+	switch s := stmt.(type) {
+	case *BlockStmt:
+		statements := rewriteStmts(s.Statements, rewrite)
+		if s.Desugared && !sameStmts(statements, s.Statements) {
+			// it is not a for loop anymore.
+			s.Desugared = false
+		}
+		s.Statements = statements
+	case *ClassDeclStmt:
+		for _, method := range s.Methods {
+			method.Body = rewriteStmts(method.Body, rewrite)
+		}
+	case *FunDeclStmt:
+		s.Body = rewriteStmts(s.Body, rewrite)
+	case *IfStmt:
+		s.ThenBranch = rewriteBranch(s.ThenBranch, rewrite)
+		if s.ElseBranch != nil {
+			s.ElseBranch = rewriteBranch(s.ElseBranch, rewrite)
+		}
+	case *WhileStmt:
+		s.Body = rewriteBranch(s.Body, rewrite)
+	}
+	return rewrite(stmt)
+}
+
+// sameStmts returns true if the lists hold the same statements.
+func sameStmts(a, b []Stmt) bool {
+
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// rewriteBranch rewrites the branch of an if statement or the
+// body of a loop, which can't be removed: an empty block
+// replaces it instead.
+func rewriteBranch(stmt Stmt, rewrite func(Stmt) Stmt) Stmt {
+
+	if stmt = rewriteStmt(stmt, rewrite); stmt == nil {
+		return &BlockStmt{}
+	}
+	return stmt
+}
+
+// rewriteStmtExprs rewrites the expressions held by a statement.
+func rewriteStmtExprs(stmt Stmt, rewrite func(Expr) Expr) {
+
+	switch s := stmt.(type) {
+	case *ExprStmt:
+		s.Expression = rewriteExpr(s.Expression, rewrite)
+	case *IfStmt:
+		s.Condition = rewriteExpr(s.Condition, rewrite)
+	case *PrintStmt:
+		s.Expression = rewriteExpr(s.Expression, rewrite)
+	case *ReturnStmt:
+		if s.Value != nil {
+			s.Value = rewriteExpr(s.Value, rewrite)
+		}
+	case *VarDeclStmt:
+		if s.Initializer != nil {
+			s.Initializer = rewriteExpr(s.Initializer, rewrite)
+		}
+	case *WhileStmt:
+		s.Condition = rewriteExpr(s.Condition, rewrite)
+	}
+}
+
+// rewriteExpr rewrites an expression, its children first.
+func rewriteExpr(expr Expr, rewrite func(Expr) Expr) Expr {
+
+	switch e := expr.(type) {
+	case *AssignExpr:
+		e.Value = rewriteExpr(e.Value, rewrite)
+	case *BinaryExpr:
+		e.LeftExpression = rewriteExpr(e.LeftExpression, rewrite)
+		e.RightExpression = rewriteExpr(e.RightExpression, rewrite)
+	case *CallExpr:
+		e.Callee = rewriteExpr(e.Callee, rewrite)
+		for i, arg := range e.Arguments {
+			e.Arguments[i] = rewriteExpr(arg, rewrite)
+		}
+	case *GetExpr:
+		e.Object = rewriteExpr(e.Object, rewrite)
+	case *GroupingExpr:
+		e.Expression = rewriteExpr(e.Expression, rewrite)
+	case *LogicalExpr:
+		e.LeftExpression = rewriteExpr(e.LeftExpression, rewrite)
+		e.RightExpression = rewriteExpr(e.RightExpression, rewrite)
+	case *SetExpr:
+		e.Object = rewriteExpr(e.Object, rewrite)
+		e.Value = rewriteExpr(e.Value, rewrite)
+	case *UnaryExpr:
+		e.Expression = rewriteExpr(e.Expression, rewrite)
+	}
+	return rewrite(expr)
+}
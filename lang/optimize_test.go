@@ -0,0 +1,41 @@
+package lang
+
+import "testing"
+
+func TestOptimizationPasses(t *testing.T) {
+
+	tests := []struct {
+		level  int
+		script string
+		expect string
+	}{
+		{1, "if (1 > 2) print 1; else print 2;\nwhile (nil) print 3;\n",
+			"print 2;\n"},
+		{1, "fun f() {\n  if (true) return 1;\n  print 2;\n}\n",
+			"fun f() {\n    return 1;\n}\n"},
+		{1, "while (a) {\n  if (b) {\n    break;\n    print 1;\n  }\n  print 2;\n}\n",
+			"while (a) {\n    if (b) {\n        break;\n    }\n    print 2;\n}\n"},
+		{1, "print false or a;\ntrue and b;\n1 + 2;\nprint c and d;\n",
+			"print a;\nb;\n\nprint c and d;\n"},
+		// the truthiness of the numbers depends on the configuration.
+		{1, "if (0) print 1;\n", "if (0)\n    print 1;\n"},
+		{2, "while (a) {\n  var b = \"s\";\n  var c = 1;\n  c = c + 1;\n  print b + c;\n}\n",
+			"{\n    var b = \"s\";\n    while (a) {\n        var c = 1;\n        c = c + 1;\n        print b + c;\n    }\n}\n"},
+		// b is read before its declaration (a global).
+		{2, "while (a) {\n  print b;\n  var b = 1;\n  print b;\n}\n",
+			"while (a) {\n    print b;\n    var b = 1;\n    print b;\n}\n"},
+		// b could be captured by the closure.
+		{2, "while (a) {\n  var b = 1;\n  fun f() {\n    return b;\n  }\n}\n",
+			"while (a) {\n    var b = 1;\n    fun f() {\n        return b;\n    }\n}\n"},
+	}
+
+	for _, test := range tests {
+		scanner := &Scanner{}
+		parser := &Parser{}
+		statements := parser.Parse(scanner.ScanTokens(test.script))
+		statements = NewPassManager(OptimizationPasses(test.level)...).Run(statements)
+		if got := Format(statements, FormatOptions{}); got != test.expect {
+			t.Errorf("Expected\n%s\nbut got\n%s", test.expect, got)
+		}
+	}
+}
@@ -16,12 +16,24 @@ const maxParams = 255
 // parser. It is used to trigger synchronization.
 var errParser = fmt.Errorf("parser error")
 
+// errTooManyErrors is a marker used to stop parsing once the
+// maximum number of errors has been reported.
+var errTooManyErrors = fmt.Errorf("too many errors")
+
 // Parser represents a lox parser.
 type Parser struct {
-	tokens   []*Token
-	current  int
-	hadError bool
-	errOut   io.Writer
+	tokens      []*Token
+	current     int
+	blockDepth  int
+	hadError    bool
+	incomplete  bool
+	errorCount  int
+	maxErrors   int
+	errOut      io.Writer
+	formatter   *DiagnosticFormatter
+	diagnostics []Diagnostic
+	comments    []*Comment
+	docs        map[int]string
 }
 
 // RedirectErrors switches the file errors are written to.
@@ -31,18 +43,44 @@ func (p *Parser) RedirectErrors(errOut io.Writer) {
 	p.errOut = errOut
 }
 
+// SetFormatter sets the formatter styling the errors written to
+// the error output. They are written as plain text by default.
+func (p *Parser) SetFormatter(formatter *DiagnosticFormatter) {
+
+	p.formatter = formatter
+}
+
+// SetComments passes the comments found by the scanner to the
+// parser before parsing. The doc comments ("///" lines directly
+// above a function, a class or a method declaration) are attached
+// to the declaration. The comments are ignored by default.
+func (p *Parser) SetComments(comments []*Comment) {
+
+	p.comments = comments
+}
+
+// SetMaxErrors sets the number of errors reported before the
+// parser gives up. A value of zero or less removes the limit.
+// The limit is DefaultMaxErrors by default.
+func (p *Parser) SetMaxErrors(max int) {
+
+	p.maxErrors = normalizeMaxErrors(max)
+}
+
 // Parse parses the stream of tokens into an AST.
-func (p *Parser) Parse(tokens []*Token) []Stmt {
+func (p *Parser) Parse(tokens []*Token) (statements []Stmt) {
 
-	// reset the Parser in case it is reused.
-	p.tokens = tokens
-	p.current = 0
-	p.hadError = false
-	if p.errOut == nil {
-		p.errOut = os.Stderr
-	}
+	p.reset(tokens)
+
+	// stop parsing altogether when too many errors are reported.
+	defer func() {
+		if e := recover(); e != nil {
+			if e != errTooManyErrors {
+				panic(e)
+			}
+		}
+	}()
 
-	var statements []Stmt
 	for !p.isAtEnd() {
 		statements = append(statements, p.declaration())
 	}
@@ -50,6 +88,49 @@ func (p *Parser) Parse(tokens []*Token) []Stmt {
 
 }
 
+// ParseExpression parses the stream of tokens of a single expression,
+// without semicolon (like the expressions passed to the REPL commands).
+// It returns nil if the tokens are not a valid expression.
+func (p *Parser) ParseExpression(tokens []*Token) (expr Expr) {
+
+	p.reset(tokens)
+
+	defer func() {
+		if e := recover(); e != nil {
+			if e != errParser && e != errTooManyErrors {
+				panic(e)
+			}
+			expr = nil
+		}
+	}()
+
+	expr = p.expression()
+	if !p.isAtEnd() {
+		p.reportError(p.peek(), CodeExpectEndOfExpression, "Expect end of expression.")
+		return nil
+	}
+	return expr
+}
+
+// reset resets the Parser in case it is reused.
+func (p *Parser) reset(tokens []*Token) {
+
+	p.tokens = tokens
+	p.current = 0
+	p.blockDepth = 0
+	p.hadError = false
+	p.incomplete = false
+	p.errorCount = 0
+	p.diagnostics = nil
+	if p.maxErrors == 0 {
+		p.maxErrors = DefaultMaxErrors
+	}
+	if p.errOut == nil {
+		p.errOut = os.Stderr
+	}
+	p.collectDocs()
+}
+
 // HadError reports if some errors were encountered during
 // the parsing phase. It should be checked before the
 // result is used.
@@ -58,6 +139,20 @@ func (p *Parser) HadError() bool {
 	return p.hadError
 }
 
+// Diagnostics returns the errors reported during the last parse.
+func (p *Parser) Diagnostics() []Diagnostic {
+
+	return p.diagnostics
+}
+
+// Incomplete reports if the last parse failed because the tokens
+// ended in the middle of a declaration (like a block not closed),
+// which more input could complete.
+func (p *Parser) Incomplete() bool {
+
+	return p.incomplete
+}
+
 // ---------------
 // Parsing rules
 // ---------------
@@ -96,25 +191,46 @@ func (p *Parser) declaration() (statement Stmt) {
 //     "class" IDENTIFIER ( "<" IDENTIFIER )? "{" function* "}" ;
 func (p *Parser) classDeclaration() *ClassDeclStmt {
 
-	name := p.consume(IdentifierToken, "Expect class name.")
+	doc := p.docComment(p.previous())
+	name := p.consume(IdentifierToken, CodeExpectClassName, "Expect class name.")
 
 	var superclass *VarExpr
 	if p.match(LessToken) {
-		p.consume(IdentifierToken, "Expect superclass name.")
-		superclass = &VarExpr{p.previous()}
+		p.consume(IdentifierToken, CodeExpectSuperclassName, "Expect superclass name.")
+		superclass = &VarExpr{p.previous(), Binding{}}
 	}
 
-	p.consume(LeftBraceToken, "Expect '{' before class body.")
+	p.consume(LeftBraceToken, CodeExpectClassBody, "Expect '{' before class body.")
 
 	var methods []*FunDeclStmt
 	for !p.check(RightBraceToken) && !p.isAtEnd() {
-		method := p.funDeclaration("method")
-		methods = append(methods, method)
+		if method := p.method(); method != nil {
+			methods = append(methods, method)
+		}
 	}
 
-	p.consume(RightBraceToken, "Expect '}' after class body.")
+	rightBrace := p.consume(RightBraceToken, CodeExpectClassBodyEnd, "Expect '}' after class body.")
+
+	return &ClassDeclStmt{name, superclass, methods, doc, rightBrace}
+}
+
+// method parses a method declaration inside a class body.
+// If the method is malformed, the error is reported and the parser
+// skips to the next method boundary so the rest of the class body
+// can still be checked. It returns nil in that case.
+func (p *Parser) method() (method *FunDeclStmt) {
+
+	defer func() {
+		if e := recover(); e != nil {
+			if e != errParser {
+				panic(e)
+			}
+			p.synchronizeMethod()
+			method = nil
+		}
+	}()
 
-	return &ClassDeclStmt{name, superclass, methods}
+	return p.funDeclaration("method")
 }
 
 // funDeclaration implements the rule for a lox function declaration.
@@ -126,15 +242,21 @@ func (p *Parser) classDeclaration() *ClassDeclStmt {
 //     IDENTIFIER ( "," IDENTIFIER )* ;
 func (p *Parser) funDeclaration(kind string) *FunDeclStmt {
 
-	name := p.consume(IdentifierToken, fmt.Sprintf("Expect %s name.", kind))
+	// a method starts with its name, a function with "fun".
+	start := p.peek()
+	if kind == "function" {
+		start = p.previous()
+	}
+	doc := p.docComment(start)
+	name := p.consume(IdentifierToken, CodeExpectFunctionName, fmt.Sprintf("Expect %s name.", kind))
 
-	p.consume(LeftParenToken, fmt.Sprintf("Expect '(' after %s name.", kind))
+	p.consume(LeftParenToken, CodeExpectFunctionParameters, fmt.Sprintf("Expect '(' after %s name.", kind))
 	params := p.parameters()
 
-	p.consume(LeftBraceToken, fmt.Sprintf("Expect '{' before %s body.", kind))
+	p.consume(LeftBraceToken, CodeExpectFunctionBody, fmt.Sprintf("Expect '{' before %s body.", kind))
 	body := p.blockStatement()
 
-	return &FunDeclStmt{name, params, body.Statements}
+	return &FunDeclStmt{name, params, body.Statements, nil, doc, body.RightBrace}
 }
 
 // parameters implements the rule for a function parameters.
@@ -146,13 +268,13 @@ func (p *Parser) parameters() []*Token {
 
 	if !p.check(RightParenToken) {
 		for ok := true; ok; ok = p.match(CommaToken) {
-			p.enforceMaxParameters(len(params), "parameter")
+			p.enforceMaxParameters(len(params), "parameter", CodeTooManyParameters)
 			params = append(params,
-				p.consume(IdentifierToken, "Expect parameter name."))
+				p.consume(IdentifierToken, CodeExpectParameterName, "Expect parameter name."))
 		}
 	}
 
-	p.consume(RightParenToken, "Expect ')' after parameters.")
+	p.consume(RightParenToken, CodeExpectParametersEnd, "Expect ')' after parameters.")
 
 	return params
 }
@@ -162,14 +284,14 @@ func (p *Parser) parameters() []*Token {
 //     "var" IDENTIFIER ( "=" expression )? ";" ;
 func (p *Parser) varDeclaration() *VarDeclStmt {
 
-	name := p.consume(IdentifierToken, "Expect variable name.")
+	name := p.consume(IdentifierToken, CodeExpectVariableName, "Expect variable name.")
 
 	var initializer Expr
 	if p.match(EqualToken) {
 		initializer = p.expression()
 	}
 
-	p.consume(SemicolonToken, "Expect ';' after variable declaration.")
+	p.consume(SemicolonToken, CodeExpectVariableDeclarationEnd, "Expect ';' after variable declaration.")
 
 	return &VarDeclStmt{name, initializer}
 
@@ -177,10 +299,13 @@ func (p *Parser) varDeclaration() *VarDeclStmt {
 
 // statement implements the rule for a lox statement.
 // statement =
-//     exprStmt | forStmt | ifStmt | printStmt | returnStmt
-//     | whileStmt | block ;
+//     breakStmt | exprStmt | forStmt | ifStmt | printStmt
+//     | returnStmt | whileStmt | block ;
 func (p *Parser) statement() Stmt {
 
+	if p.match(BreakToken) {
+		return p.breakStatement()
+	}
 	if p.match(ForToken) {
 		return p.forStatement()
 	}
@@ -209,7 +334,8 @@ func (p *Parser) statement() Stmt {
 //     expression? ";" expression? ")" statement ;
 func (p *Parser) forStatement() Stmt {
 
-	p.consume(LeftParenToken, "Expect '(' after 'for'.")
+	keyword := p.previous()
+	p.consume(LeftParenToken, CodeExpectForClauses, "Expect '(' after 'for'.")
 
 	var initializer Stmt
 	if p.match(SemicolonToken) {
@@ -224,14 +350,14 @@ func (p *Parser) forStatement() Stmt {
 	if !p.check(SemicolonToken) {
 		condition = p.expression()
 	}
-	p.consume(SemicolonToken, "Expect ';' after loop condition.")
+	p.consume(SemicolonToken, CodeExpectLoopConditionEnd, "Expect ';' after loop condition.")
 
 	var increment Expr
 	if !p.check(RightParenToken) {
 		increment = p.expression()
 	}
 
-	p.consume(RightParenToken, "Expect ')' after for clauses.")
+	p.consume(RightParenToken, CodeExpectForClausesEnd, "Expect ')' after for clauses.")
 
 	body := p.statement()
 
@@ -247,9 +373,9 @@ func (p *Parser) forStatement() Stmt {
 		body = newBlockStmt(body, &ExprStmt{increment})
 	}
 	if condition == nil {
-		condition = &Lit{true}
+		condition = &Lit{true, "", nil}
 	}
-	body = &WhileStmt{condition, body}
+	body = &WhileStmt{condition, body, keyword}
 	if initializer != nil {
 		body = newBlockStmt(initializer, body)
 	}
@@ -262,9 +388,10 @@ func (p *Parser) forStatement() Stmt {
 //     "if" "(" expression ")" statement ( "else" statement )? ;
 func (p *Parser) ifStatement() *IfStmt {
 
-	p.consume(LeftParenToken, "Expect '(' after 'if'.")
+	keyword := p.previous()
+	p.consume(LeftParenToken, CodeExpectIfCondition, "Expect '(' after 'if'.")
 	condition := p.expression()
-	p.consume(RightParenToken, "Expect ')' after if condition.")
+	p.consume(RightParenToken, CodeExpectIfConditionEnd, "Expect ')' after if condition.")
 
 	thenBranch := p.statement()
 
@@ -275,7 +402,7 @@ func (p *Parser) ifStatement() *IfStmt {
 		elseBranch = p.statement()
 	}
 
-	return &IfStmt{condition, thenBranch, elseBranch}
+	return &IfStmt{condition, thenBranch, elseBranch, keyword}
 }
 
 // printStatement implements the rule for a lox PrintStmt.
@@ -283,11 +410,22 @@ func (p *Parser) ifStatement() *IfStmt {
 //     "print" expression ";" ;
 func (p *Parser) printStatement() *PrintStmt {
 
+	keyword := p.previous()
 	expr := p.expression()
 
-	p.consume(SemicolonToken, "Expect ';' after value.")
+	p.consume(SemicolonToken, CodeExpectPrintEnd, "Expect ';' after value.")
 
-	return &PrintStmt{expr}
+	return &PrintStmt{expr, keyword}
+}
+
+// breakStatement implements the rule for a lox BreakStmt.
+// breakStmt = "break" ";" ;
+func (p *Parser) breakStatement() *BreakStmt {
+
+	keyword := p.previous()
+	p.consume(SemicolonToken, CodeExpectBreakEnd, "Expect ';' after 'break'.")
+
+	return &BreakStmt{keyword}
 }
 
 // returnStatement implements the rule for a lox ReturnStmt.
@@ -301,7 +439,7 @@ func (p *Parser) returnStatement() *ReturnStmt {
 		value = p.expression()
 	}
 
-	p.consume(SemicolonToken, "Expect ';' after return value.")
+	p.consume(SemicolonToken, CodeExpectReturnEnd, "Expect ';' after return value.")
 
 	return &ReturnStmt{keyword, value}
 }
@@ -311,13 +449,14 @@ func (p *Parser) returnStatement() *ReturnStmt {
 //     "while" "(" expression ")" statement ;
 func (p *Parser) whileStatement() *WhileStmt {
 
-	p.consume(LeftParenToken, "Expect '(' after 'while'.")
+	keyword := p.previous()
+	p.consume(LeftParenToken, CodeExpectWhileCondition, "Expect '(' after 'while'.")
 	condition := p.expression()
-	p.consume(RightParenToken, "Expect ')' after while condition.")
+	p.consume(RightParenToken, CodeExpectWhileConditionEnd, "Expect ')' after while condition.")
 
 	body := p.statement()
 
-	return &WhileStmt{condition, body}
+	return &WhileStmt{condition, body, keyword}
 }
 
 // blockStatement implements the rule for a lox block.
@@ -325,14 +464,21 @@ func (p *Parser) whileStatement() *WhileStmt {
 //     "{" declaration* "}" ;
 func (p *Parser) blockStatement() *BlockStmt {
 
+	p.blockDepth++
+	defer func() {
+		p.blockDepth--
+	}()
+
+	// the opening brace was consumed by the caller.
+	leftBrace := p.previous()
 	var statements []Stmt
 	for !p.check(RightBraceToken) && !p.isAtEnd() {
 		statements = append(statements, p.declaration())
 	}
 
-	p.consume(RightBraceToken, "Expect '}' after block.")
+	rightBrace := p.consume(RightBraceToken, CodeExpectBlockEnd, "Expect '}' after block.")
 
-	return &BlockStmt{statements}
+	return &BlockStmt{statements, false, leftBrace, rightBrace}
 }
 
 // expressionStatement implements the rule for a lox exprStmt
@@ -342,7 +488,7 @@ func (p *Parser) expressionStatement() *ExprStmt {
 
 	expr := p.expression()
 
-	p.consume(SemicolonToken, "Expect ';' after expression.")
+	p.consume(SemicolonToken, CodeExpectExpressionStatementEnd, "Expect ';' after expression.")
 
 	return &ExprStmt{expr}
 }
@@ -352,168 +498,191 @@ func (p *Parser) expressionStatement() *ExprStmt {
 //     assignment ;
 func (p *Parser) expression() Expr {
 
-	return p.assignment()
+	return p.parsePrecedence(assignmentPrecedence)
 }
 
-// assignment implements the rule for a lox assignment expression.
-// assignment =
-//     ( call "." )? IDENTIFIER "=" assignment | logic_or ;
-func (p *Parser) assignment() Expr {
+// parsePrecedence parses an expression whose operators bind at
+// least as tightly as the requested precedence (Pratt parser).
+// The expression starts with a prefix parselet (literal, variable,
+// grouping, unary operator, ...) and is extended by infix parselets
+// (binary operators, calls, ...) as long as they bind tightly enough.
+func (p *Parser) parsePrecedence(prec precedence) Expr {
 
-	// Because we may need an infinite look-ahead to find the "=" token
-	// we treat the left side as any expression and only
-	// check if it is an identifier when we find the "=" token.
+	prefix, ok := prefixParselets[p.peek().Type]
+	if !ok {
+		p.reportError(p.peek(), CodeExpectExpression, "Expect expression.")
+		panic(errParser)
+	}
 
-	expr := p.or()
+	expr := prefix(p, p.advance())
 
-	if p.match(EqualToken) {
-		equals := p.previous()
-		value := p.assignment()
-		if varExpr, ok := expr.(*VarExpr); ok {
-			return &AssignExpr{varExpr.Name, value}
-		} else if getExpr, ok := expr.(*GetExpr); ok {
-			return &SetExpr{getExpr.Object, getExpr.Name, value}
-		} else {
-			p.reportError(equals, "Invalid assignment target.")
+	for {
+		rule, ok := infixParselets[p.peek().Type]
+		if !ok || rule.precedence < prec {
+			return expr
 		}
+		expr = rule.parse(p, expr, p.advance())
 	}
-
-	return expr
 }
 
-// or implements the rule for a lox logical or expression.
-// logic_or =
-//     logic_and ( "or" logic_and )* ;
-func (p *Parser) or() Expr {
+// ------------------------
+// Expression parselets
+// ------------------------
 
-	expr := p.and()
+// precedence represents how tightly an operator binds its operands.
+// Precedence rules (lowest to highest) are listed in grammar.md.
+type precedence int
 
-	for p.match(OrToken) {
-		op := p.previous()
-		right := p.and()
-		expr = &LogicalExpr{expr, op, right}
-	}
+const (
+	assignmentPrecedence precedence = iota + 1
+	orPrecedence
+	andPrecedence
+	equalityPrecedence
+	comparisonPrecedence
+	termPrecedence
+	factorPrecedence
+	unaryPrecedence
+	callPrecedence
+)
 
-	return expr
+// prefixParselet parses an expression starting with the
+// token that was just consumed.
+type prefixParselet func(p *Parser, token *Token) Expr
+
+// infixParselet parses an expression where the token that
+// was just consumed follows the left operand.
+type infixParselet func(p *Parser, left Expr, token *Token) Expr
+
+// infixRule associates an infix parselet with the precedence
+// of its operator.
+type infixRule struct {
+	precedence precedence
+	parse      infixParselet
 }
 
-// and implements the rule for a lox logical and expression.
-// logic_and =
-//     equality ( "and" equality )* ;
-func (p *Parser) and() Expr {
+// prefixParselets and infixParselets are the parselets
+// registered for each token type.
+var (
+	prefixParselets = make(map[TokenType]prefixParselet)
+	infixParselets  = make(map[TokenType]infixRule)
+)
 
-	expr := p.equality()
+// registerPrefix registers a prefix parselet for the token types.
+func registerPrefix(parselet prefixParselet, types ...TokenType) {
 
-	for p.match(AndToken) {
-		op := p.previous()
-		right := p.equality()
-		expr = &LogicalExpr{expr, op, right}
+	for _, tokenType := range types {
+		prefixParselets[tokenType] = parselet
 	}
-
-	return expr
 }
 
-// equality implements the rule for a lox equality expression.
-// equality =
-//     comparison ( ("!=" | "==" ) comparison )* ;
-func (p *Parser) equality() Expr {
-
-	expr := p.comparison()
+// registerInfix registers an infix parselet for the token types.
+func registerInfix(prec precedence, parselet infixParselet, types ...TokenType) {
 
-	for p.match(BangEqualToken, EqualEqualToken) {
-		op := p.previous()
-		right := p.comparison()
-		expr = &BinaryExpr{expr, op, right}
+	for _, tokenType := range types {
+		infixParselets[tokenType] = infixRule{prec, parselet}
 	}
-
-	return expr
 }
 
-// comparison implements the rule for a lox comparison expression.
-// comparison =
-//     term ( (">" | ">=" | "<" | "<=" ) term )* ;
-func (p *Parser) comparison() Expr {
+// the parselets are registered in init to avoid an initialization
+// cycle (parselets call back into parsePrecedence).
+func init() {
 
-	expr := p.term()
+	registerPrefix(numberParselet, NumberToken)
+	registerPrefix(stringParselet, StringToken)
+	registerPrefix(literalParselet, FalseToken, TrueToken, NilToken)
+	registerPrefix(groupingParselet, LeftParenToken)
+	registerPrefix(thisParselet, ThisToken)
+	registerPrefix(superParselet, SuperToken)
+	registerPrefix(variableParselet, IdentifierToken)
+	registerPrefix(unaryParselet, BangToken, MinusToken)
 
-	for p.match(GreaterToken, GreaterEqualToken, LessToken, LessEqualToken) {
-		op := p.previous()
-		right := p.term()
-		expr = &BinaryExpr{expr, op, right}
-	}
-
-	return expr
+	registerInfix(assignmentPrecedence, assignmentParselet, EqualToken)
+	registerInfix(orPrecedence, logicalParselet, OrToken)
+	registerInfix(andPrecedence, logicalParselet, AndToken)
+	registerInfix(equalityPrecedence, binaryParselet,
+		BangEqualToken, EqualEqualToken)
+	registerInfix(comparisonPrecedence, binaryParselet,
+		GreaterToken, GreaterEqualToken, LessToken, LessEqualToken)
+	registerInfix(termPrecedence, binaryParselet, MinusToken, PlusToken)
+	registerInfix(factorPrecedence, binaryParselet, SlashToken, StarToken)
+	registerInfix(callPrecedence, callParselet, LeftParenToken)
+	registerInfix(callPrecedence, getParselet, DotToken)
 }
 
-// term implements the rule for a lox term expression
-// term =
-//     factor ( ( "-" | "+" ) factor )* ;
-func (p *Parser) term() Expr {
+// assignmentParselet implements the rule for a lox assignment expression.
+// assignment =
+//     ( call "." )? IDENTIFIER "=" assignment | logic_or ;
+// Because we may need an infinite look-ahead to find the "=" token
+// we treat the left side as any expression and only
+// check if it is an identifier when we find the "=" token.
+func assignmentParselet(p *Parser, left Expr, equals *Token) Expr {
 
-	expr := p.factor()
+	// assignment is right-associative.
+	value := p.parsePrecedence(assignmentPrecedence)
 
-	for p.match(MinusToken, PlusToken) {
-		op := p.previous()
-		right := p.factor()
-		expr = &BinaryExpr{expr, op, right}
+	if varExpr, ok := left.(*VarExpr); ok {
+		return &AssignExpr{varExpr.Name, value, Binding{}}
+	} else if getExpr, ok := left.(*GetExpr); ok {
+		return &SetExpr{getExpr.Object, getExpr.Name, value}
 	}
 
-	return expr
+	p.reportError(equals, CodeInvalidAssignmentTarget, "Invalid assignment target.")
+	return left
 }
 
-// factor implements the rule for a lox factor expression
-// factor =
-//     unary ( ( "/" | "*" ) unary )* ;
-func (p *Parser) factor() Expr {
+// logicalParselet implements the rules for the lox logical expressions.
+// logic_or =
+//     logic_and ( "or" logic_and )* ;
+// logic_and =
+//     equality ( "and" equality )* ;
+func logicalParselet(p *Parser, left Expr, op *Token) Expr {
 
-	expr := p.unary()
+	right := p.parsePrecedence(infixParselets[op.Type].precedence + 1)
+	return &LogicalExpr{left, op, right}
+}
 
-	for p.match(SlashToken, StarToken) {
-		op := p.previous()
-		right := p.unary()
-		expr = &BinaryExpr{expr, op, right}
-	}
+// binaryParselet implements the rules for the lox binary expressions.
+// equality =
+//     comparison ( ("!=" | "==" ) comparison )* ;
+// comparison =
+//     term ( (">" | ">=" | "<" | "<=" ) term )* ;
+// term =
+//     factor ( ( "-" | "+" ) factor )* ;
+// factor =
+//     unary ( ( "/" | "*" ) unary )* ;
+func binaryParselet(p *Parser, left Expr, op *Token) Expr {
 
-	return expr
+	// binary operators are left-associative so the right operand
+	// only includes operators binding more tightly.
+	right := p.parsePrecedence(infixParselets[op.Type].precedence + 1)
+	return &BinaryExpr{left, op, right}
 }
 
-// unary implements the rule for a lox unary expression.
+// unaryParselet implements the rule for a lox unary expression.
 // unary =
 //     ( "!" | "-" ) unary | call ;
-func (p *Parser) unary() Expr {
-
-	if p.match(BangToken, MinusToken) {
-		op := p.previous()
-		right := p.unary()
-		return &UnaryExpr{op, right}
-	}
+func unaryParselet(p *Parser, op *Token) Expr {
 
-	return p.call()
+	right := p.parsePrecedence(unaryPrecedence)
+	return &UnaryExpr{op, right}
 }
 
-// call implements the rule for a lox call expression.
+// callParselet implements the rule for a lox call expression.
 // call =
 //     primary ( "(" arguments? ")" | "." IDENTIFIER )* ;
-// Note: this rule produces either a *CallExpr (parenthesis)
-// 		or a *GetExpr (dot).
-func (p *Parser) call() Expr {
+func callParselet(p *Parser, callee Expr, _ *Token) Expr {
 
-	expr := p.primary()
+	arguments := p.arguments()
+	paren := p.consume(RightParenToken, CodeExpectArgumentsEnd, "Expect ')' after arguments.")
+	return &CallExpr{callee, paren, arguments}
+}
 
-	for {
-		if p.match(LeftParenToken) {
-			arguments := p.arguments()
-			paren := p.consume(RightParenToken, "Expect ')' after arguments.")
-			expr = &CallExpr{expr, paren, arguments}
-		} else if p.match(DotToken) {
-			name := p.consume(IdentifierToken, "Expect property name after '.'.")
-			expr = &GetExpr{expr, name}
-		} else {
-			break
-		}
-	}
+// getParselet implements the "." part of the rule for a lox call
+// expression. It produces a *GetExpr.
+func getParselet(p *Parser, object Expr, _ *Token) Expr {
 
-	return expr
+	name := p.consume(IdentifierToken, CodeExpectPropertyName, "Expect property name after '.'.")
+	return &GetExpr{object, name, PropertyCache{}}
 }
 
 // arguments implements the rule for a lox call set of arguments.
@@ -525,7 +694,7 @@ func (p *Parser) arguments() []Expr {
 
 	if !p.check(RightParenToken) {
 		for ok := true; ok; ok = p.match(CommaToken) {
-			p.enforceMaxParameters(len(arguments), "argument")
+			p.enforceMaxParameters(len(arguments), "argument", CodeTooManyArguments)
 			arguments = append(arguments, p.expression())
 		}
 	}
@@ -533,56 +702,70 @@ func (p *Parser) arguments() []Expr {
 	return arguments
 }
 
-// primary implements the rule for a lox primary.
+// The primary parselets implement the rule for a lox primary.
 // primary =
 //     NUMBER | STRING | BOOLEAN | NIL | "(" expression ")"
 //     | "this" | "super" | IDENTIFIER ;
-func (p *Parser) primary() Expr {
 
-	if p.match(NumberToken) {
-		n, _ := strconv.ParseFloat(p.previous().Lexeme, 64)
-		// TODO: deal with the error in ParseFloat
-		// theoretically, there should be no error since
-		// we match the token to a float
-		return &Lit{n}
-	}
-	if p.match(StringToken) {
-		// technically we should be careful to remove just a
-		// single quote at the beginning and the end of the
-		// string but the lox grammar guarantees there is only
-		// a single quote at the beginning and end anyway.
-		s := strings.Trim(p.previous().Lexeme, "\"")
-		return &Lit{s}
-	}
-	if p.match(FalseToken) {
-		return &Lit{false}
-	}
-	if p.match(TrueToken) {
-		return &Lit{true}
-	}
-	if p.match(NilToken) {
-		return &Lit{}
-	}
-	if p.match(LeftParenToken) {
-		expr := p.expression()
-		p.consume(RightParenToken, "Expect ')' after expression.")
-		return &GroupingExpr{expr}
-	}
-	if p.match(ThisToken) {
-		return &ThisExpr{p.previous()}
-	}
-	if p.match(SuperToken) {
-		keyword := p.previous()
-		p.consume(DotToken, "Expect '.' after 'super'.")
-		method := p.consume(IdentifierToken, "Expect superclass method name")
-		return &SuperExpr{keyword, method}
-	}
-	if p.match(IdentifierToken) {
-		return &VarExpr{p.previous()}
+// numberParselet parses a NUMBER literal.
+func numberParselet(p *Parser, token *Token) Expr {
+
+	n, _ := strconv.ParseFloat(token.Lexeme, 64)
+	// TODO: deal with the error in ParseFloat
+	// theoretically, there should be no error since
+	// we match the token to a float
+	return &Lit{n, token.Lexeme, token}
+}
+
+// stringParselet parses a STRING literal.
+func stringParselet(p *Parser, token *Token) Expr {
+
+	// technically we should be careful to remove just a
+	// single quote at the beginning and the end of the
+	// string but the lox grammar guarantees there is only
+	// a single quote at the beginning and end anyway.
+	return &Lit{strings.Trim(token.Lexeme, "\""), token.Lexeme, token}
+}
+
+// literalParselet parses the BOOLEAN and NIL literals.
+func literalParselet(p *Parser, token *Token) Expr {
+
+	switch token.Type {
+	case FalseToken:
+		return &Lit{false, token.Lexeme, token}
+	case TrueToken:
+		return &Lit{true, token.Lexeme, token}
+	default:
+		return &Lit{nil, token.Lexeme, token}
 	}
+}
 
-	p.reportError(p.peek(), "Expect expression.")
-	panic(errParser)
+// groupingParselet parses a parenthesized expression.
+func groupingParselet(p *Parser, paren *Token) Expr {
+
+	expr := p.expression()
+	rightParen := p.consume(RightParenToken, CodeExpectGroupingEnd, "Expect ')' after expression.")
+	return &GroupingExpr{expr, paren, rightParen}
+}
+
+// thisParselet parses the "this" pseudo-variable.
+func thisParselet(p *Parser, keyword *Token) Expr {
+
+	return &ThisExpr{keyword, Binding{}}
+}
+
+// superParselet parses a "super" method access.
+func superParselet(p *Parser, keyword *Token) Expr {
+
+	p.consume(DotToken, CodeExpectSuperDot, "Expect '.' after 'super'.")
+	method := p.consume(IdentifierToken, CodeExpectSuperMethodName, "Expect superclass method name")
+	return &SuperExpr{keyword, method, Binding{}, Binding{}}
+}
+
+// variableParselet parses a variable reference.
+func variableParselet(p *Parser, name *Token) Expr {
+
+	return &VarExpr{name, Binding{}}
 }
 
 // ------------------
@@ -606,13 +789,13 @@ func (p *Parser) match(types ...TokenType) bool {
 
 // consume checks and skips the next token. If the token
 // is different from the expected token, an error is raised.
-func (p *Parser) consume(tokenType TokenType, msg string) *Token {
+func (p *Parser) consume(tokenType TokenType, code string, msg string) *Token {
 
 	if p.check(tokenType) {
 		return p.advance()
 	}
 
-	p.reportError(p.peek(), msg)
+	p.reportError(p.peek(), code, msg)
 	panic(errParser)
 }
 
@@ -628,6 +811,18 @@ func (p *Parser) check(tokenType TokenType) bool {
 	return p.peek().Type == tokenType
 }
 
+// checkNext returns true if the token following the current
+// one matches the specified token type.
+// No token is consumed.
+func (p *Parser) checkNext(tokenType TokenType) bool {
+
+	if p.isAtEnd() {
+		return false
+	}
+
+	return p.tokens[p.current+1].Type == tokenType
+}
+
 // advance moves to the next token.
 func (p *Parser) advance() *Token {
 
@@ -659,8 +854,15 @@ func (p *Parser) previous() *Token {
 // synchronize search the parsing stream for the first
 // token after a semicolon. It is used to continue
 // parsing after an error is found and reported.
+// Inside a block, it also stops in front of a '}' so the
+// enclosing block can be closed normally instead of
+// reporting bogus errors for the rest of the script.
 func (p *Parser) synchronize() {
 
+	if p.blockDepth > 0 && p.check(RightBraceToken) {
+		return
+	}
+
 	p.advance()
 	for !p.isAtEnd() {
 
@@ -669,43 +871,118 @@ func (p *Parser) synchronize() {
 		}
 
 		switch p.peek().Type {
-		case ClassToken, FunToken, VarToken, ForToken, IfToken, WhileToken, PrintToken, ReturnToken:
+		case ClassToken, FunToken, VarToken, ForToken, IfToken, WhileToken, PrintToken, ReturnToken,
+			BreakToken:
 			return
+		case RightBraceToken:
+			if p.blockDepth > 0 {
+				return
+			}
 		}
 
 		p.advance()
 	}
 }
 
+// synchronizeMethod skips the remainder of a malformed method
+// declaration. It stops at the next method boundary, that is
+// after the '}' closing the method body, in front of the next
+// method name or in front of the '}' closing the class body.
+func (p *Parser) synchronizeMethod() {
+
+	depth := 0
+	for !p.isAtEnd() {
+
+		if depth == 0 {
+			if p.check(RightBraceToken) {
+				return
+			}
+			if p.check(IdentifierToken) && p.checkNext(LeftParenToken) {
+				return
+			}
+		}
+
+		switch p.advance().Type {
+		case LeftBraceToken:
+			depth++
+		case RightBraceToken:
+			depth--
+			if depth == 0 {
+				return
+			}
+		}
+	}
+}
+
 // enforceMaxParameters enforce the limit on the number of
 // parameters/arguments per function/method.
-func (p *Parser) enforceMaxParameters(size int, itemType string) {
+func (p *Parser) enforceMaxParameters(size int, itemType string, code string) {
 
 	if size >= maxParams {
-		p.reportError(p.peek(),
+		p.reportError(p.peek(), code,
 			fmt.Sprintf("Can't have more than %d %ss.", maxParams, itemType))
 	}
 }
 
 // reportError is triggered when a parser errors is encountered.
 // the parser can then continue from that point.
-func (p *Parser) reportError(token *Token, msg string) {
+func (p *Parser) reportError(token *Token, code string, msg string) {
 
-	var where string
+	p.hadError = true
 	if token.Type == EndToken {
-		where = "at end"
-	} else {
-		where = "at '" + token.Lexeme + "'"
+		p.incomplete = true
+	}
+	p.errorCount++
+	if p.maxErrors > 0 && p.errorCount > p.maxErrors {
+		fmt.Fprintln(p.errOut, TooManyErrorsMessage)
+		panic(errTooManyErrors)
 	}
 
-	fmt.Fprintf(p.errOut, "[line %d] Error %s: %s\n",
-		token.Line, where, msg)
-	p.hadError = true
+	diagnostic := NewDiagnostic(ErrorSeverity, token, code, msg)
+	p.diagnostics = append(p.diagnostics, diagnostic)
+	fmt.Fprintln(p.errOut, p.formatter.Format(diagnostic))
+}
+
+// collectDocs indexes the lines of the doc comments by line.
+// The "///" comments following a token on the same line are
+// not doc comments.
+func (p *Parser) collectDocs() {
+
+	p.docs = nil
+	if len(p.comments) == 0 {
+		return
+	}
+	codeLines := make(map[int]bool)
+	for _, token := range p.tokens {
+		codeLines[token.Line] = true
+	}
+	p.docs = make(map[int]string)
+	for _, comment := range p.comments {
+		if strings.HasPrefix(comment.Text, "///") && !codeLines[comment.Line] {
+			text := strings.TrimPrefix(comment.Text, "///")
+			p.docs[comment.Line] = strings.TrimRight(strings.TrimPrefix(text, " "), " \t\r")
+		}
+	}
+}
+
+// docComment returns the doc comment of the declaration starting
+// at the token, made of the doc comment lines right above it.
+func (p *Parser) docComment(start *Token) string {
+
+	var lines []string
+	for line := start.Line - 1; ; line-- {
+		text, ok := p.docs[line]
+		if !ok {
+			break
+		}
+		lines = append([]string{text}, lines...)
+	}
+	return strings.Join(lines, "\n")
 }
 
-// newBlockStmt creates a block statement out of the
+// newBlockStmt creates a desugared block statement out of the
 // provided set of statements
 func newBlockStmt(statements ...Stmt) *BlockStmt {
 
-	return &BlockStmt{statements}
+	return &BlockStmt{statements, true, nil, nil}
 }
@@ -75,6 +75,26 @@ func TestExpr(t *testing.T) {
 		matchAST(t, expect, script)
 	})
 
+	t.Run("precedence and associativity", func(t *testing.T) {
+		script := `
+			1 + 2 * 3 - 4 / 2;
+			1 - 2 - 3;
+			- - 1 * 2;
+			a or b and c == d < e + f * g;
+			!a.b(c).d;
+			a = b = c or d;
+			a.b.c = d;`
+		expect := []string{
+			"(- (+ 1 (* 2 3)) (/ 4 2))",
+			"(- (- 1 2) 3)",
+			"(* (- (- 1)) 2)",
+			"(or (a) (and (b) (== (c) (< (d) (+ (e) (* (f) (g)))))))",
+			"(! (get (call (get (a) b) (args (c))) d))",
+			"(assign a (assign b (or (c) (d))))",
+			"(set (get (a) b) c (d))"}
+		matchAST(t, expect, script)
+	})
+
 	t.Run("assigment", func(t *testing.T) {
 		script := `
 			myVar = 123.456;
@@ -279,6 +299,65 @@ func TestCompilerErrors(t *testing.T) {
 		expectError(t, errMsg, script)
 	})
 
+	t.Run("recover inside class body", func(t *testing.T) {
+		script := `
+			class Cake {
+				slice( {
+					print "slice";
+				}
+				123 eat() {
+					print "eat";
+				}
+				bake() {
+					print "bake"
+				}
+				serve() {
+					return this;
+				}
+			}
+			print "done";`
+		errMsg := "[line 3] Error at '{': Expect parameter name.\n" +
+			"[line 6] Error at '123': Expect method name.\n" +
+			"[line 11] Error at '}': Expect ';' after value.\n"
+		expectError(t, errMsg, script)
+	})
+
+	t.Run("recover inside block", func(t *testing.T) {
+		script := `
+			fun echo(n) {
+				{
+					print n
+				}
+				print n +;
+			}
+			echo(1);`
+		errMsg := "[line 5] Error at '}': Expect ';' after value.\n" +
+			"[line 6] Error at ';': Expect expression.\n"
+		expectError(t, errMsg, script)
+	})
+
+	t.Run("too many errors", func(t *testing.T) {
+		script := `
+			var 1;
+			var 2;
+			var 3;
+			var 4;`
+		b := &strings.Builder{}
+		parser := &Parser{}
+		parser.RedirectErrors(b)
+		parser.SetMaxErrors(2)
+		parser.Parse((&Scanner{}).ScanTokens(script))
+
+		expect := "[line 2] Error at '1': Expect variable name.\n" +
+			"[line 3] Error at '2': Expect variable name.\n" +
+			"too many errors, aborting\n"
+		if !parser.HadError() {
+			t.Error("Expected errors but got none")
+		}
+		if got := b.String(); got != expect {
+			t.Errorf("Expected Error '%s' but got '%s'", expect, got)
+		}
+	})
 }
 
 func TestAstPrettyPrint(t *testing.T) {
@@ -344,7 +423,7 @@ func TestAstPrettyPrint(t *testing.T) {
 	scanner := &Scanner{}
 	tokens := scanner.ScanTokens(script)
 	parser := &Parser{}
-	program := &BlockStmt{parser.Parse(tokens)}
+	program := &BlockStmt{parser.Parse(tokens), false, nil, nil}
 	got := program.PrettyPrint("\n", "  ")
 	if expect != got {
 		t.Errorf("Expected '%s' but got '%s'", expect, got)
@@ -352,6 +431,58 @@ func TestAstPrettyPrint(t *testing.T) {
 
 }
 
+func TestParseLexemes(t *testing.T) {
+
+	scanner := &Scanner{}
+	parser := &Parser{}
+	statements := parser.Parse(scanner.ScanTokens(`print (1.50 + "a");`))
+	group, ok := statements[0].(*PrintStmt).Expression.(*GroupingExpr)
+	if !ok {
+		t.Fatalf("Expected a grouping but got %s", statements[0])
+	}
+	if group.LeftParen.Column != 7 || group.RightParen.Column != 18 {
+		t.Errorf("Expected the parentheses at columns 7 and 18 but got %d and %d",
+			group.LeftParen.Column, group.RightParen.Column)
+	}
+	sum := group.Expression.(*BinaryExpr)
+	for _, test := range []struct {
+		expr   Expr
+		lexeme string
+	}{
+		{sum.LeftExpression, "1.50"},
+		{sum.RightExpression, `"a"`},
+	} {
+		if lit := test.expr.(*Lit); lit.Lexeme != test.lexeme {
+			t.Errorf("Expected the lexeme %s but got %s", test.lexeme, lit.Lexeme)
+		}
+	}
+}
+
+func TestParseExpression(t *testing.T) {
+
+	tests := []struct {
+		source string
+		expect string
+	}{
+		{"1 + a.b(2)", "(+ 1 (call (get (a) b) (args 2)))"},
+		{"1 + 2;", ""},
+		{"var a = 1", ""},
+	}
+	for _, test := range tests {
+		scanner := &Scanner{}
+		parser := &Parser{}
+		parser.RedirectErrors(&strings.Builder{})
+		expr := parser.ParseExpression(scanner.ScanTokens(test.source))
+		got := ""
+		if expr != nil {
+			got = expr.String()
+		}
+		if got != test.expect || parser.HadError() != (test.expect == "") {
+			t.Errorf("Expected '%s' but got '%s' for '%s'", test.expect, got, test.source)
+		}
+	}
+}
+
 // ------------------
 // Helper functions
 // ------------------
@@ -0,0 +1,183 @@
+package lang
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// PrettyOptions configure PrettyPrint.
+type PrettyOptions struct {
+	// Indent is the indentation of the nested nodes,
+	// 2 spaces if empty.
+	Indent string
+	// Expand prints the operands of the expressions on their own
+	// lines, like the statements, instead of inline. The literals,
+	// the variables, this and super stay on the line of the node
+	// using them.
+	Expand bool
+}
+
+// PrettyPrint writes the AST of a script to out as S-expressions,
+// each statement on its own lines with its nested statements (and
+// expressions with Expand) indented below it.
+func PrettyPrint(out io.Writer, statements []Stmt, options PrettyOptions) error {
+
+	p := &prettyPrinter{tab: options.Indent, expand: options.Expand}
+	if p.tab == "" {
+		p.tab = "  "
+	}
+	for _, stmt := range statements {
+		p.stmt(stmt, "\n")
+	}
+	_, err := io.WriteString(out, strings.TrimPrefix(p.b.String(), "\n")+"\n")
+	return err
+}
+
+// prettyPrint returns a statement printed by PrettyPrinter,
+// with inline expressions.
+func prettyPrint(stmt Stmt, pad, tab string) string {
+
+	p := &prettyPrinter{tab: tab}
+	p.stmt(stmt, pad)
+	return p.b.String()
+}
+
+// prettyPrinter prints the nodes of the AST as S-expressions.
+// Each node starts with a pad, a new line followed by the
+// indentation of the node, and its children are printed with
+// the pad of the node followed by tab.
+type prettyPrinter struct {
+	b      strings.Builder
+	tab    string
+	expand bool
+}
+
+// stmt prints a statement.
+func (p *prettyPrinter) stmt(stmt Stmt, pad string) {
+
+	newPad := pad + p.tab
+	switch s := stmt.(type) {
+	case *BlockStmt:
+		fmt.Fprintf(&p.b, "%s(block", pad)
+		for _, statement := range s.Statements {
+			p.stmt(statement, newPad)
+		}
+	case *BreakStmt:
+		fmt.Fprintf(&p.b, "%s(break", pad)
+	case *ClassDeclStmt:
+		superclass := "nil"
+		if s.Superclass != nil {
+			superclass = s.Superclass.Name.Lexeme
+		}
+		fmt.Fprintf(&p.b, "%s(class %s %s", pad, s.Name.Lexeme, superclass)
+		for _, method := range s.Methods {
+			p.stmt(method, newPad)
+		}
+	case *ExprStmt:
+		// an expression statement is printed as its expression.
+		p.expr(s.Expression, pad)
+		return
+	case *FunDeclStmt:
+		fmt.Fprintf(&p.b, "%s(fun %s (params", pad, s.Name.Lexeme)
+		for _, param := range s.Params {
+			fmt.Fprintf(&p.b, " %s", param.Lexeme)
+		}
+		p.b.WriteString(")")
+		for _, statement := range s.Body {
+			p.stmt(statement, newPad)
+		}
+	case *IfStmt:
+		fmt.Fprintf(&p.b, "%s(if", pad)
+		p.operand(s.Condition, newPad)
+		p.stmt(s.ThenBranch, newPad)
+		if s.ElseBranch != nil {
+			p.stmt(s.ElseBranch, newPad)
+		}
+	case *PrintStmt:
+		fmt.Fprintf(&p.b, "%s(print", pad)
+		p.operand(s.Expression, newPad)
+	case *ReturnStmt:
+		fmt.Fprintf(&p.b, "%s(return", pad)
+		if s.Value != nil {
+			p.operand(s.Value, newPad)
+		}
+	case *VarDeclStmt:
+		fmt.Fprintf(&p.b, "%s(var %s", pad, s.Name.Lexeme)
+		if s.Initializer != nil {
+			p.operand(s.Initializer, newPad)
+		}
+	case *WhileStmt:
+		fmt.Fprintf(&p.b, "%s(while", pad)
+		p.operand(s.Condition, newPad)
+		p.stmt(s.Body, newPad)
+	default:
+		fmt.Fprintf(&p.b, "%s%s", pad, stmt)
+		return
+	}
+	p.b.WriteString(")")
+}
+
+// operand prints an expression used by a node: on the line of
+// the node, or on its own line at pad if the expressions are
+// expanded.
+func (p *prettyPrinter) operand(expr Expr, pad string) {
+
+	if p.expand {
+		p.expr(expr, pad)
+	} else {
+		fmt.Fprintf(&p.b, " %s", expr)
+	}
+}
+
+// expr prints an expression at pad.
+func (p *prettyPrinter) expr(expr Expr, pad string) {
+
+	if !p.expand {
+		fmt.Fprintf(&p.b, "%s%s", pad, expr)
+		return
+	}
+	newPad := pad + p.tab
+	switch e := expr.(type) {
+	case *AssignExpr:
+		fmt.Fprintf(&p.b, "%s(assign %s", pad, e.Name.Lexeme)
+		p.expr(e.Value, newPad)
+	case *BinaryExpr:
+		fmt.Fprintf(&p.b, "%s(%s", pad, e.Operator.Lexeme)
+		p.expr(e.LeftExpression, newPad)
+		p.expr(e.RightExpression, newPad)
+	case *CallExpr:
+		fmt.Fprintf(&p.b, "%s(call", pad)
+		p.expr(e.Callee, newPad)
+		fmt.Fprintf(&p.b, "%s(args", newPad)
+		for _, argument := range e.Arguments {
+			p.expr(argument, newPad+p.tab)
+		}
+		p.b.WriteString(")")
+	case *GetExpr:
+		fmt.Fprintf(&p.b, "%s(get", pad)
+		p.expr(e.Object, newPad)
+		fmt.Fprintf(&p.b, "%s%s", newPad, e.Name.Lexeme)
+	case *GroupingExpr:
+		fmt.Fprintf(&p.b, "%s(group", pad)
+		p.expr(e.Expression, newPad)
+	case *LogicalExpr:
+		fmt.Fprintf(&p.b, "%s(%s", pad, e.Operator.Lexeme)
+		p.expr(e.LeftExpression, newPad)
+		p.expr(e.RightExpression, newPad)
+	case *SetExpr:
+		fmt.Fprintf(&p.b, "%s(set", pad)
+		p.expr(e.Object, newPad)
+		fmt.Fprintf(&p.b, "%s%s", newPad, e.Name.Lexeme)
+		p.expr(e.Value, newPad)
+	case *UnaryExpr:
+		fmt.Fprintf(&p.b, "%s(%s", pad, e.Operator.Lexeme)
+		p.expr(e.Expression, newPad)
+	default:
+		// the literals, the variables, this and super
+		// have no operands.
+		fmt.Fprintf(&p.b, "%s%s", pad, expr)
+		return
+	}
+	p.b.WriteString(")")
+}
@@ -0,0 +1,78 @@
+package lang
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPrettyPrint(t *testing.T) {
+
+	script := `
+		var a = 1;
+		fun f(n) {
+			if (n > 0 and !a) return -(n + 1);
+			a.b = f(n - 1, "x");
+		}
+		while (a) a = nil;`
+
+	tests := []struct {
+		options PrettyOptions
+		expect  string
+	}{
+		{PrettyOptions{}, "(var a 1)\n" +
+			"(fun f (params n)\n" +
+			"  (if (and (> (n) 0) (! (a)))\n" +
+			"    (return (- (group (+ (n) 1)))))\n" +
+			"  (set (a) b (call (f) (args (- (n) 1) \"x\"))))\n" +
+			"(while (a)\n" +
+			"  (assign a nil))\n"},
+		{PrettyOptions{Indent: "\t"}, "(var a 1)\n" +
+			"(fun f (params n)\n" +
+			"\t(if (and (> (n) 0) (! (a)))\n" +
+			"\t\t(return (- (group (+ (n) 1)))))\n" +
+			"\t(set (a) b (call (f) (args (- (n) 1) \"x\"))))\n" +
+			"(while (a)\n" +
+			"\t(assign a nil))\n"},
+		{PrettyOptions{Expand: true}, "(var a\n" +
+			"  1)\n" +
+			"(fun f (params n)\n" +
+			"  (if\n" +
+			"    (and\n" +
+			"      (>\n" +
+			"        (n)\n" +
+			"        0)\n" +
+			"      (!\n" +
+			"        (a)))\n" +
+			"    (return\n" +
+			"      (-\n" +
+			"        (group\n" +
+			"          (+\n" +
+			"            (n)\n" +
+			"            1)))))\n" +
+			"  (set\n" +
+			"    (a)\n" +
+			"    b\n" +
+			"    (call\n" +
+			"      (f)\n" +
+			"      (args\n" +
+			"        (-\n" +
+			"          (n)\n" +
+			"          1)\n" +
+			"        \"x\"))))\n" +
+			"(while\n" +
+			"  (a)\n" +
+			"  (assign a\n" +
+			"    nil))\n"},
+	}
+
+	statements := parseScript(t, script)
+	for _, test := range tests {
+		b := &strings.Builder{}
+		if err := PrettyPrint(b, statements, test.options); err != nil {
+			t.Fatal(err)
+		}
+		if got := b.String(); got != test.expect {
+			t.Errorf("With %+v, expected\n%s\nbut got\n%s", test.options, test.expect, got)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package lang
+
+import (
+	"fmt"
+	"io/ioutil"
+	"runtime/debug"
+)
+
+// PanicError is an internal error of glox (a panic which is not a
+// reported lox error, like the unknown node types of the AST)
+// recovered by the safe entry points, see SafeParse.
+type PanicError struct {
+	// Value is the value passed to panic.
+	Value interface{}
+	// Stack is the stack trace of the goroutine when it panicked.
+	Stack []byte
+}
+
+// Error returns the panic value.
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("internal error: %v", e.Value)
+}
+
+// NewPanicError creates the error of a recovered panic, it must be
+// called from the deferred function which recovered it.
+func NewPanicError(value interface{}) *PanicError {
+
+	return &PanicError{value, debug.Stack()}
+}
+
+// SafeParse scans and parses a script without reporting the errors,
+// for the host applications which get their scripts from untrusted
+// sources. The error is the first syntax error of the script, if
+// any, or a *PanicError if the scanner or the parser panicked: a
+// malformed script can never crash the application.
+func SafeParse(source string) (statements []Stmt, err error) {
+
+	defer func() {
+		if e := recover(); e != nil {
+			statements, err = nil, NewPanicError(e)
+		}
+	}()
+
+	scanner := &Scanner{}
+	scanner.RedirectErrors(ioutil.Discard)
+	tokens := scanner.ScanTokens(source)
+
+	parser := &Parser{}
+	parser.RedirectErrors(ioutil.Discard)
+	parser.SetComments(scanner.Comments())
+	statements = parser.Parse(tokens)
+
+	diagnostics := append(scanner.Diagnostics(), parser.Diagnostics()...)
+	if len(diagnostics) > 0 {
+		return nil, fmt.Errorf("%s", diagnostics[0])
+	}
+	return statements, nil
+}
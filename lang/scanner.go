@@ -8,15 +8,41 @@ import (
 
 // Scanner represents a lox scanner.
 type Scanner struct {
-	source   []rune
-	tokens   []*Token
-	start    int
-	current  int
-	line     int
-	hadError bool
-	errOut   io.Writer
+	source      []rune
+	tokens      []*Token
+	comments    []*Comment
+	start       int
+	current     int
+	line        int
+	lineStart   int
+	column      int
+	hadError    bool
+	incomplete  bool
+	errorCount  int
+	maxErrors   int
+	errOut      io.Writer
+	formatter   *DiagnosticFormatter
+	diagnostics []Diagnostic
+	interned    map[string]string
 }
 
+// maxInternedLength is the length of the longest lexeme interned
+// by the scanner. Longer strings are unlikely to be repeated.
+const maxInternedLength = 64
+
+// maxInterned is the number of lexemes kept by the scanner, the
+// table is emptied when it is full so a long REPL session (or a
+// server evaluating many scripts) doesn't grow it without bound.
+const maxInterned = 4096
+
+// DefaultMaxErrors is the number of errors reported by the
+// scanner, the parser or the resolver before they give up.
+const DefaultMaxErrors = 20
+
+// TooManyErrorsMessage is reported when the maximum number of
+// errors is exceeded.
+const TooManyErrorsMessage = "too many errors, aborting"
+
 // RedirectErrors switches the file errors are written to.
 // Errors go to stderr by default.
 func (s *Scanner) RedirectErrors(errOut io.Writer) {
@@ -24,6 +50,21 @@ func (s *Scanner) RedirectErrors(errOut io.Writer) {
 	s.errOut = errOut
 }
 
+// SetFormatter sets the formatter styling the errors written to
+// the error output. They are written as plain text by default.
+func (s *Scanner) SetFormatter(formatter *DiagnosticFormatter) {
+
+	s.formatter = formatter
+}
+
+// SetMaxErrors sets the number of errors reported before the
+// scanner gives up. A value of zero or less removes the limit.
+// The limit is DefaultMaxErrors by default.
+func (s *Scanner) SetMaxErrors(max int) {
+
+	s.maxErrors = normalizeMaxErrors(max)
+}
+
 // ScanTokens scans the source code and return the list
 // of tokens.
 func (s *Scanner) ScanTokens(source string) []*Token {
@@ -31,23 +72,40 @@ func (s *Scanner) ScanTokens(source string) []*Token {
 	// Reset the scanner state in case it is reused.
 	s.source = []rune(source)
 	s.tokens = nil
+	s.comments = nil
 	s.start = 0
 	s.current = 0
 	s.line = 1
+	s.lineStart = 0
 	s.hadError = false
+	s.incomplete = false
+	s.errorCount = 0
+	s.diagnostics = nil
+	if s.maxErrors == 0 {
+		s.maxErrors = DefaultMaxErrors
+	}
 	if s.errOut == nil {
 		s.errOut = os.Stderr
 	}
 
-	for !s.isAtEnd() {
+	for !s.isAtEnd() && !s.tooManyErrors() {
 		s.start = s.current
+		s.column = s.current - s.lineStart + 1
 		s.scanToken()
 	}
 
-	s.tokens = append(s.tokens, &Token{EndToken, "", s.line})
+	s.column = s.current - s.lineStart + 1
+	s.tokens = append(s.tokens, &Token{EndToken, "", s.line, s.column})
 	return s.tokens
 }
 
+// Comments returns the comments found by the last scan,
+// in source order.
+func (s *Scanner) Comments() []*Comment {
+
+	return s.comments
+}
+
 // HadError reports if some errors were encountered during
 // scanning. It should be called after ScanTokens before using
 // the result.
@@ -56,6 +114,19 @@ func (s *Scanner) HadError() bool {
 	return s.hadError
 }
 
+// Diagnostics returns the errors reported during the last scan.
+func (s *Scanner) Diagnostics() []Diagnostic {
+
+	return s.diagnostics
+}
+
+// Incomplete reports if the last scan ended in a string not
+// terminated, which more input could complete.
+func (s *Scanner) Incomplete() bool {
+
+	return s.incomplete
+}
+
 // scanToken scans the new token in the script.
 func (s *Scanner) scanToken() {
 
@@ -111,13 +182,15 @@ func (s *Scanner) scanToken() {
 			for s.peek() != '\n' && !s.isAtEnd() {
 				s.advance()
 			}
+			text := string(s.source[s.start:s.current])
+			s.comments = append(s.comments, &Comment{text, s.line, s.column})
 		} else {
 			s.addToken(SlashToken)
 		}
 	case ' ', '\r', '\t':
 		// ignore whitespace
 	case '\n':
-		s.line++
+		s.newLine()
 	case '"':
 		s.string()
 	default:
@@ -126,7 +199,7 @@ func (s *Scanner) scanToken() {
 		} else if isAlpha(c) {
 			s.identifier()
 		} else {
-			s.reportError("Unexpected character.")
+			s.reportError(CodeUnexpectedCharacter, "Unexpected character.")
 			// TODO: it would be nicer to coalesce all the consecutive erroneous characters
 			// into a single error message
 		}
@@ -140,13 +213,16 @@ func (s *Scanner) string() {
 
 	for s.peek() != '"' && !s.isAtEnd() {
 		if s.peek() == '\n' {
-			s.line++
+			s.advance()
+			s.newLine()
+			continue
 		}
 		s.advance()
 	}
 
 	if s.isAtEnd() {
-		s.reportError("Unterminated string.")
+		s.incomplete = true
+		s.reportError(CodeUnterminatedString, "Unterminated string.")
 		return
 	}
 
@@ -223,11 +299,42 @@ func isAlphaNumeric(c rune) bool {
 // ------------------
 
 // reportError reports an error during interpretation
-func (s *Scanner) reportError(message string) {
+func (s *Scanner) reportError(code string, message string) {
 
-	fmt.Fprintf(s.errOut, "[line %d] Error: %s\n",
-		s.line, message)
 	s.hadError = true
+	s.errorCount++
+	if s.tooManyErrors() {
+		fmt.Fprintln(s.errOut, TooManyErrorsMessage)
+		return
+	}
+
+	// the span of an unterminated multiline string is unknown,
+	// the error is reported on its last line.
+	column, length := s.column, s.current-s.start
+	if s.lineStart > s.start {
+		column, length = 0, 0
+	}
+	diagnostic := Diagnostic{ErrorSeverity, s.line, "", message, column, length, code}
+	s.diagnostics = append(s.diagnostics, diagnostic)
+	fmt.Fprintln(s.errOut, s.formatter.Format(diagnostic))
+}
+
+// tooManyErrors checks if the scanner reported more errors
+// than allowed.
+func (s *Scanner) tooManyErrors() bool {
+
+	return s.maxErrors > 0 && s.errorCount > s.maxErrors
+}
+
+// normalizeMaxErrors converts a user provided error limit
+// into its internal representation where -1 means no limit
+// and 0 means the default limit.
+func normalizeMaxErrors(max int) int {
+
+	if max <= 0 {
+		return -1
+	}
+	return max
 }
 
 // isAtEnd checks if the scanner has reached the end of the
@@ -244,6 +351,14 @@ func (s *Scanner) advance() rune {
 	return s.source[s.current-1]
 }
 
+// newLine starts a new line after a new line character
+// has been consumed.
+func (s *Scanner) newLine() {
+
+	s.line++
+	s.lineStart = s.current
+}
+
 // match checks the next character in the source
 // is as expected. IfToken the character matches, it is consumed.
 func (s *Scanner) match(expected rune) bool {
@@ -284,13 +399,34 @@ func (s *Scanner) peekNext() rune {
 // addToken adds a token to the Scanner result
 func (s *Scanner) addToken(tokenType TokenType) {
 
-	text := string(s.source[s.start:s.current])
-	s.tokens = append(s.tokens, &Token{tokenType, text, s.line})
+	text := s.intern(string(s.source[s.start:s.current]))
+	s.tokens = append(s.tokens, &Token{tokenType, text, s.line, s.column})
+}
+
+// intern returns the unique copy of a short lexeme so identical
+// identifiers and string literals share the same memory, which makes
+// comparing them and using them as map keys (fields, globals) cheaper.
+// The table is kept when the scanner is reused, until it holds
+// maxInterned lexemes.
+func (s *Scanner) intern(text string) string {
+
+	if len(text) > maxInternedLength {
+		return text
+	}
+	if s.interned == nil || len(s.interned) >= maxInterned {
+		s.interned = make(map[string]string)
+	}
+	if interned, ok := s.interned[text]; ok {
+		return interned
+	}
+	s.interned[text] = text
+	return text
 }
 
 // keywords is a map including all lox reserved keywords
 var keywords = map[string]TokenType{
 	"and":    AndToken,
+	"break":  BreakToken,
 	"class":  ClassToken,
 	"else":   ElseToken,
 	"false":  FalseToken,
@@ -1,8 +1,11 @@
 package lang
 
 import (
+	"fmt"
+	"reflect"
 	"strings"
 	"testing"
+	"unsafe"
 )
 
 func TestScanTokens(t *testing.T) {
@@ -24,6 +27,25 @@ func TestScanTokens(t *testing.T) {
 	matchTokens(t, expect, script)
 }
 
+func TestScanPositions(t *testing.T) {
+
+	script := "var a = 1;\n  print \"two\nlines\" + a;"
+	expect := []string{
+		"1:1 var", "1:5 Identifier(a)", "1:7 =", "1:9 Number(1)", "1:10 ;",
+		"2:3 print", "3:9 String(two\nlines)", "3:8 +", "3:10 Identifier(a)",
+		"3:11 ;", "3:12 end-of-stream"}
+
+	scanner := &Scanner{}
+	tokens := scanner.ScanTokens(script)
+	var got []string
+	for _, token := range tokens {
+		got = append(got, fmt.Sprintf("%d:%d %s", token.Line, token.Column, token))
+	}
+	if !reflect.DeepEqual(expect, got) {
+		t.Errorf("Expected %q but got %q", expect, got)
+	}
+}
+
 func TestScanNumbers(t *testing.T) {
 
 	t.Run("Parse integer", func(t *testing.T) {
@@ -76,6 +98,50 @@ func TestScanStrings(t *testing.T) {
 
 }
 
+func TestScanTooManyErrors(t *testing.T) {
+
+	b := &strings.Builder{}
+	scanner := &Scanner{}
+	scanner.RedirectErrors(b)
+	scanner.SetMaxErrors(2)
+	scanner.ScanTokens("#\n@\n$\n%")
+
+	expect := "[line 1] Error: Unexpected character.\n" +
+		"[line 2] Error: Unexpected character.\n" +
+		"too many errors, aborting\n"
+	if !scanner.HadError() {
+		t.Error("Expected errors but got none")
+	}
+	if got := b.String(); got != expect {
+		t.Errorf("Expected Error '%s' but got '%s'", expect, got)
+	}
+}
+
+func TestScanInternsLexemes(t *testing.T) {
+
+	scanner := &Scanner{}
+	first := scanner.ScanTokens(`counter.count = "abc";`)
+	second := scanner.ScanTokens(`print counter.count + "abc";`)
+
+	for _, pair := range [][2]*Token{{first[0], second[1]}, {first[2], second[3]}, {first[4], second[5]}} {
+		// both occurrences must share the same backing array,
+		// equal strings sliced from each script would not.
+		if stringData(pair[0].Lexeme) != stringData(pair[1].Lexeme) {
+			t.Errorf("Expected %s to be interned", pair[0].Lexeme)
+		}
+	}
+
+	// the table is bounded.
+	var b strings.Builder
+	for n := 0; n < 2*maxInterned; n++ {
+		fmt.Fprintf(&b, "var v%d;\n", n)
+	}
+	scanner.ScanTokens(b.String())
+	if len(scanner.interned) > maxInterned {
+		t.Errorf("Expected at most %d lexemes interned but got %d", maxInterned, len(scanner.interned))
+	}
+}
+
 // ------------------
 // Helper functions
 // ------------------
@@ -140,3 +206,8 @@ func scanInvalidToken(t *testing.T, script string) {
 	}
 
 }
+
+// stringData returns the address of the bytes backing a string.
+func stringData(s string) uintptr {
+	return (*reflect.StringHeader)(unsafe.Pointer(&s)).Data
+}
@@ -0,0 +1,175 @@
+package lang
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Stats describes the shape of the AST of a script.
+type Stats struct {
+	// Nodes counts the nodes by type ("Binary", "While"...).
+	Nodes map[string]int
+	// MaxDepth is the depth of the deepest node, the statements
+	// of the script are at depth 1.
+	MaxDepth  int
+	Functions []FunctionStats
+	Classes   []ClassStats
+}
+
+// FunctionStats describes the size of a function or a method
+// (named Class.method), including the functions nested in it.
+type FunctionStats struct {
+	Name       string
+	Line       int
+	Statements int
+	Nodes      int
+}
+
+// ClassStats describes the place of a class in the class
+// hierarchy. Subclasses is its fan-out, the number of classes
+// of the script directly inheriting from it.
+type ClassStats struct {
+	Name       string
+	Line       int
+	Superclass string
+	Subclasses int
+}
+
+// ComputeStats computes the statistics of the AST of a script.
+func ComputeStats(statements []Stmt) Stats {
+
+	stats := Stats{Nodes: make(map[string]int)}
+	classes := make(map[*FunDeclStmt]string)
+	subclasses := make(map[string]int)
+
+	Walk(statements, func(node Node, depth int) {
+		stats.Nodes[nodeName(node)]++
+		if depth+1 > stats.MaxDepth {
+			stats.MaxDepth = depth + 1
+		}
+		switch n := node.(type) {
+		case *ClassDeclStmt:
+			superclass := ""
+			if n.Superclass != nil {
+				superclass = n.Superclass.Name.Lexeme
+				subclasses[superclass]++
+			}
+			stats.Classes = append(stats.Classes,
+				ClassStats{n.Name.Lexeme, n.Name.Line, superclass, 0})
+			for _, method := range n.Methods {
+				classes[method] = n.Name.Lexeme
+			}
+		case *FunDeclStmt:
+			name := n.Name.Lexeme
+			if class, ok := classes[n]; ok {
+				name = class + "." + name
+			}
+			size := FunctionStats{Name: name, Line: n.Name.Line}
+			Walk(n.Body, func(node Node, depth int) {
+				size.Nodes++
+				if _, ok := node.(Stmt); ok {
+					size.Statements++
+				}
+			})
+			stats.Functions = append(stats.Functions, size)
+		}
+	})
+
+	for n := range stats.Classes {
+		stats.Classes[n].Subclasses = subclasses[stats.Classes[n].Name]
+	}
+	return stats
+}
+
+// WriteStats writes the statistics as tables to out.
+func WriteStats(out io.Writer, stats Stats) error {
+
+	b := &strings.Builder{}
+	names := make([]string, 0, len(stats.Nodes))
+	total := 0
+	for name, count := range stats.Nodes {
+		names = append(names, name)
+		total += count
+	}
+	sort.Strings(names)
+	fmt.Fprintf(b, "%d nodes, maximum depth %d\n", total, stats.MaxDepth)
+	for _, name := range names {
+		fmt.Fprintf(b, "%10d  %s\n", stats.Nodes[name], name)
+	}
+
+	if len(stats.Functions) > 0 {
+		fmt.Fprintf(b, "\n%10s %10s  %s\n", "statements", "nodes", "function")
+		for _, f := range stats.Functions {
+			fmt.Fprintf(b, "%10d %10d  %s (line %d)\n", f.Statements, f.Nodes, f.Name, f.Line)
+		}
+	}
+
+	if len(stats.Classes) > 0 {
+		fmt.Fprintf(b, "\n%10s  %s\n", "subclasses", "class")
+		for _, c := range stats.Classes {
+			if c.Superclass != "" {
+				fmt.Fprintf(b, "%10d  %s < %s (line %d)\n", c.Subclasses, c.Name, c.Superclass, c.Line)
+			} else {
+				fmt.Fprintf(b, "%10d  %s (line %d)\n", c.Subclasses, c.Name, c.Line)
+			}
+		}
+	}
+	_, err := io.WriteString(out, b.String())
+	return err
+}
+
+// nodeName returns the name of the type of a node, as used in
+// the dumps of the AST.
+func nodeName(node Node) string {
+
+	switch node.(type) {
+	case *BlockStmt:
+		return "Block"
+	case *BreakStmt:
+		return "Break"
+	case *ClassDeclStmt:
+		return "Class"
+	case *ExprStmt:
+		return "Expression"
+	case *FunDeclStmt:
+		return "Function"
+	case *IfStmt:
+		return "If"
+	case *PrintStmt:
+		return "Print"
+	case *ReturnStmt:
+		return "Return"
+	case *VarDeclStmt:
+		return "Var"
+	case *WhileStmt:
+		return "While"
+	case *AssignExpr:
+		return "Assign"
+	case *BinaryExpr:
+		return "Binary"
+	case *CallExpr:
+		return "Call"
+	case *GetExpr:
+		return "Get"
+	case *GroupingExpr:
+		return "Grouping"
+	case *Lit:
+		return "Literal"
+	case *LogicalExpr:
+		return "Logical"
+	case *SetExpr:
+		return "Set"
+	case *SuperExpr:
+		return "Super"
+	case *ThisExpr:
+		return "This"
+	case *UnaryExpr:
+		return "Unary"
+	case *VarExpr:
+		return "Variable"
+	default:
+		return fmt.Sprintf("%T", node)
+	}
+}
@@ -0,0 +1,39 @@
+package lang
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestComputeStats(t *testing.T) {
+
+	script := `
+		class A {
+			m() { if (true) return 1; }
+		}
+		class B < A {}
+		class C < A {}
+		fun f(x) {
+			fun g() { print x; }
+			return g;
+		}`
+	stats := ComputeStats(parseScript(t, script))
+
+	nodes := map[string]int{"Class": 3, "Function": 3, "If": 1, "Literal": 2,
+		"Return": 2, "Print": 1, "Variable": 4}
+	if !reflect.DeepEqual(stats.Nodes, nodes) {
+		t.Errorf("Expected %v but got %v", nodes, stats.Nodes)
+	}
+	if stats.MaxDepth != 5 {
+		t.Errorf("Expected maximum depth 5 but got %d", stats.MaxDepth)
+	}
+
+	functions := []FunctionStats{{"A.m", 3, 2, 4}, {"f", 7, 3, 5}, {"g", 8, 1, 2}}
+	if !reflect.DeepEqual(stats.Functions, functions) {
+		t.Errorf("Expected %v but got %v", functions, stats.Functions)
+	}
+	classes := []ClassStats{{"A", 2, "", 2}, {"B", 5, "A", 0}, {"C", 6, "A", 0}}
+	if !reflect.DeepEqual(stats.Classes, classes) {
+		t.Errorf("Expected %v but got %v", classes, stats.Classes)
+	}
+}
@@ -10,10 +10,23 @@ import (
 )
 
 // Token represents a lox token.
+// Column is the position of the first character of the token
+// in its line, starting at 1. Line is the last line of the token
+// (for multiline strings) while Column is in the line where it starts.
 type Token struct {
 	Type   TokenType
 	Lexeme string
 	Line   int
+	Column int
+}
+
+// Comment represents a comment found by the scanner. Comments are
+// not tokens, they are kept separately for the tools which need
+// them (like the formatter). Text includes the leading "//".
+type Comment struct {
+	Text   string
+	Line   int
+	Column int
 }
 
 // TokenType represents the type of a lox token.
@@ -28,6 +41,8 @@ const (
 	BangToken
 	// BangEqualToken represents a '!=' token.
 	BangEqualToken
+	// BreakToken represents a 'break' token.
+	BreakToken
 	// ClassToken represents a 'class' token.
 	ClassToken
 	// CommaToken represents a ',' token.
@@ -112,6 +127,8 @@ func (t TokenType) String() string {
 		return "!"
 	case BangEqualToken:
 		return "!="
+	case BreakToken:
+		return "break"
 	case ClassToken:
 		return "class"
 	case CommaToken:
@@ -0,0 +1,94 @@
+package lang
+
+import "fmt"
+
+// Node is a node of the AST, a statement or an expression.
+type Node interface {
+	fmt.Stringer
+}
+
+// Walk visits the nodes of the statements in depth first order,
+// parents before their children. The depth of the statements of
+// the script is 0, it is incremented for each level of children.
+func Walk(statements []Stmt, visit func(node Node, depth int)) {
+
+	walkStmts(statements, visit, 0)
+}
+
+// walkStmts visits a list of statements at the given depth.
+func walkStmts(statements []Stmt, visit func(Node, int), depth int) {
+
+	for _, stmt := range statements {
+		walkStmt(stmt, visit, depth)
+	}
+}
+
+// walkStmt visits a statement and its children.
+func walkStmt(stmt Stmt, visit func(Node, int), depth int) {
+
+	visit(stmt, depth)
+	switch s := stmt.(type) {
+	case *BlockStmt:
+		walkStmts(s.Statements, visit, depth+1)
+	case *ClassDeclStmt:
+		if s.Superclass != nil {
+			walkExpr(s.Superclass, visit, depth+1)
+		}
+		for _, method := range s.Methods {
+			walkStmt(method, visit, depth+1)
+		}
+	case *ExprStmt:
+		walkExpr(s.Expression, visit, depth+1)
+	case *FunDeclStmt:
+		walkStmts(s.Body, visit, depth+1)
+	case *IfStmt:
+		walkExpr(s.Condition, visit, depth+1)
+		walkStmt(s.ThenBranch, visit, depth+1)
+		if s.ElseBranch != nil {
+			walkStmt(s.ElseBranch, visit, depth+1)
+		}
+	case *PrintStmt:
+		walkExpr(s.Expression, visit, depth+1)
+	case *ReturnStmt:
+		if s.Value != nil {
+			walkExpr(s.Value, visit, depth+1)
+		}
+	case *VarDeclStmt:
+		if s.Initializer != nil {
+			walkExpr(s.Initializer, visit, depth+1)
+		}
+	case *WhileStmt:
+		walkExpr(s.Condition, visit, depth+1)
+		walkStmt(s.Body, visit, depth+1)
+	}
+}
+
+// walkExpr visits an expression and its children.
+func walkExpr(expr Expr, visit func(Node, int), depth int) {
+
+	visit(expr, depth)
+	switch e := expr.(type) {
+	case *AssignExpr:
+		walkExpr(e.Value, visit, depth+1)
+	case *BinaryExpr:
+		walkExpr(e.LeftExpression, visit, depth+1)
+		walkExpr(e.RightExpression, visit, depth+1)
+	case *CallExpr:
+		walkExpr(e.Callee, visit, depth+1)
+		for _, arg := range e.Arguments {
+			walkExpr(arg, visit, depth+1)
+		}
+	case *GetExpr:
+		walkExpr(e.Object, visit, depth+1)
+	case *GroupingExpr:
+		walkExpr(e.Expression, visit, depth+1)
+	case *LogicalExpr:
+		walkExpr(e.LeftExpression, visit, depth+1)
+		walkExpr(e.RightExpression, visit, depth+1)
+	case *SetExpr:
+		walkExpr(e.Object, visit, depth+1)
+		walkExpr(e.Value, visit, depth+1)
+	case *UnaryExpr:
+		walkExpr(e.Expression, visit, depth+1)
+	}
+}
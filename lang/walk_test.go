@@ -0,0 +1,31 @@
+package lang
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+
+	script := `
+		var a = 1;
+		if (a) print -a;`
+	expect := []string{
+		"0 *lang.VarDeclStmt",
+		"1 *lang.Lit",
+		"0 *lang.IfStmt",
+		"1 *lang.VarExpr",
+		"1 *lang.PrintStmt",
+		"2 *lang.UnaryExpr",
+		"3 *lang.VarExpr",
+	}
+	var got []string
+	Walk(parseScript(t, script), func(node Node, depth int) {
+		got = append(got, fmt.Sprintf("%d %T", depth, node))
+	})
+	if strings.Join(got, "\n") != strings.Join(expect, "\n") {
+		t.Errorf("Expected\n%s\nbut got\n%s",
+			strings.Join(expect, "\n"), strings.Join(got, "\n"))
+	}
+}
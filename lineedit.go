@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/rmonnet/glox/lang"
+)
+
+// errInterrupted is returned by readLine when the line is
+// abandoned with Ctrl-C.
+var errInterrupted = errors.New("interrupted")
+
+// lineReader reads the lines entered in the REPL.
+type lineReader interface {
+	// readLine prints the prompt and reads a line. The error is
+	// io.EOF at the end of the input.
+	readLine(prompt string) (string, error)
+	// addHistory records a line in the history.
+	addHistory(line string)
+	// useHistoryFile loads the history from the file and
+	// appends the next lines to it.
+	useHistoryFile(filename string)
+}
+
+// completer returns the candidates to complete an identifier
+// starting with prefix, member is true if it follows a dot.
+type completer func(prefix string, member bool) []string
+
+// newLineReader returns a line editor if the input is a terminal
+// which can be put in raw mode, or a plain reader otherwise.
+// The line editor completes the identifiers with complete and
+// highlights the syntax of the line if highlight is true.
+func newLineReader(in, out *os.File, complete completer, highlight bool) lineReader {
+
+	if isTerminal(in) {
+		if restore, err := makeRaw(in.Fd()); err == nil {
+			restore()
+			return &lineEditor{in: in, out: out, reader: bufio.NewReader(in),
+				complete: complete, highlight: highlight}
+		}
+	}
+	return &plainReader{bufio.NewScanner(in), out}
+}
+
+// plainReader reads the lines without editing.
+type plainReader struct {
+	scanner *bufio.Scanner
+	out     io.Writer
+}
+
+// readLine prints the prompt and reads the next line of the input.
+func (r *plainReader) readLine(prompt string) (string, error) {
+
+	fmt.Fprint(r.out, prompt)
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return r.scanner.Text(), nil
+}
+
+// addHistory does nothing, the plain reader has no history.
+func (r *plainReader) addHistory(line string) {}
+
+// useHistoryFile does nothing, the plain reader has no history.
+func (r *plainReader) useHistoryFile(filename string) {}
+
+// lineEditor reads the lines from a terminal in raw mode with
+// readline-style editing:
+//   - left/right arrows, Ctrl-B/Ctrl-F move the cursor
+//   - Home/End, Ctrl-A/Ctrl-E go to the beginning/end of the line
+//   - Backspace, Delete and Ctrl-D delete a character (Ctrl-D
+//     on an empty line ends the input)
+//   - Ctrl-K and Ctrl-U delete to the end/beginning of the line
+//   - up/down arrows, Ctrl-P/Ctrl-N browse the history
+//   - Tab completes the identifier before the cursor
+//   - Ctrl-C abandons the line
+type lineEditor struct {
+	in          *os.File
+	out         io.Writer
+	reader      *bufio.Reader
+	history     []string
+	historyFile string
+	complete    completer
+	highlight   bool
+}
+
+// maxHistory is the number of lines kept in the history file.
+const maxHistory = 1000
+
+// keys read from the terminal.
+const (
+	keyCtrlA     = 1
+	keyCtrlB     = 2
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyCtrlF     = 6
+	keyBackspace = 8
+	keyTab       = 9
+	keyCtrlK     = 11
+	keyEnter     = 13
+	keyCtrlN     = 14
+	keyCtrlP     = 16
+	keyCtrlU     = 21
+	keyEscape    = 27
+	keyDelete    = 127
+)
+
+// editState is the state of the line being edited.
+type editState struct {
+	prompt string
+	line   []rune
+	pos    int
+	// entry is the history entry shown, len(history) for the
+	// line being entered, which is saved in pending.
+	entry   int
+	pending []rune
+}
+
+// readLine prints the prompt and edits a line in raw mode until
+// Enter is pressed.
+func (e *lineEditor) readLine(prompt string) (string, error) {
+
+	restore, err := makeRaw(e.in.Fd())
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+
+	s := &editState{prompt: prompt, entry: len(e.history)}
+	e.refresh(s)
+	for {
+		key, _, err := e.reader.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch key {
+		case keyEnter, '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return string(s.line), nil
+		case keyCtrlC:
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupted
+		case keyCtrlD:
+			if len(s.line) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			s.deleteAt(s.pos)
+		case keyCtrlA:
+			s.pos = 0
+		case keyCtrlE:
+			s.pos = len(s.line)
+		case keyCtrlB:
+			s.move(-1)
+		case keyCtrlF:
+			s.move(1)
+		case keyCtrlK:
+			s.line = s.line[:s.pos]
+		case keyCtrlU:
+			s.line = s.line[s.pos:]
+			s.pos = 0
+		case keyCtrlP:
+			e.browse(s, -1)
+		case keyCtrlN:
+			e.browse(s, 1)
+		case keyBackspace, keyDelete:
+			if s.pos > 0 {
+				s.pos--
+				s.deleteAt(s.pos)
+			}
+		case keyEscape:
+			e.escape(s)
+		case keyTab:
+			e.completeWord(s)
+		default:
+			if key >= ' ' && key != utf8.RuneError {
+				s.line = append(s.line[:s.pos], append([]rune{key}, s.line[s.pos:]...)...)
+				s.pos++
+			}
+		}
+		e.refresh(s)
+	}
+}
+
+// escape handles the escape sequences sent by the arrows,
+// Home, End and Delete keys.
+func (e *lineEditor) escape(s *editState) {
+
+	if next, _, _ := e.reader.ReadRune(); next != '[' && next != 'O' {
+		return
+	}
+	key, _, _ := e.reader.ReadRune()
+	switch key {
+	case 'A':
+		e.browse(s, -1)
+	case 'B':
+		e.browse(s, 1)
+	case 'C':
+		s.move(1)
+	case 'D':
+		s.move(-1)
+	case 'H':
+		s.pos = 0
+	case 'F':
+		s.pos = len(s.line)
+	case '1', '3', '4', '7', '8':
+		// Home (1 or 7), Delete (3) and End (4 or 8)
+		// are followed by '~'.
+		if tilde, _, _ := e.reader.ReadRune(); tilde != '~' {
+			return
+		}
+		switch key {
+		case '1', '7':
+			s.pos = 0
+		case '3':
+			s.deleteAt(s.pos)
+		default:
+			s.pos = len(s.line)
+		}
+	}
+}
+
+// completeWord completes the identifier before the cursor. The
+// longest prefix shared by the candidates is inserted, when there
+// is nothing more to insert the candidates are listed.
+func (e *lineEditor) completeWord(s *editState) {
+
+	start := s.pos
+	for start > 0 && isIdentifierRune(s.line[start-1]) {
+		start--
+	}
+	if start < s.pos && isDigit(s.line[start]) {
+		return
+	}
+	prefix := string(s.line[start:s.pos])
+	member := start > 0 && s.line[start-1] == '.'
+
+	var candidates []string
+	for _, name := range e.complete(prefix, member) {
+		if strings.HasPrefix(name, prefix) {
+			candidates = append(candidates, name)
+		}
+	}
+	if len(candidates) == 0 {
+		return
+	}
+
+	common := candidates[0]
+	for _, name := range candidates[1:] {
+		for !strings.HasPrefix(name, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	if len(common) > len(prefix) {
+		insert := []rune(common[len(prefix):])
+		s.line = append(s.line[:s.pos], append(insert, s.line[s.pos:]...)...)
+		s.pos += len(insert)
+		return
+	}
+	fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(candidates, "  "))
+}
+
+// isIdentifierRune checks if a character can be part
+// of an identifier.
+func isIdentifierRune(r rune) bool {
+
+	return r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || isDigit(r)
+}
+
+// isDigit checks if the character is a decimal digit.
+func isDigit(r rune) bool {
+
+	return r >= '0' && r <= '9'
+}
+
+// browse replaces the line by the previous (-1) or the next (1)
+// history entry.
+func (e *lineEditor) browse(s *editState, direction int) {
+
+	entry := s.entry + direction
+	if entry < 0 || entry > len(e.history) {
+		return
+	}
+	if s.entry == len(e.history) {
+		s.pending = s.line
+	}
+	s.entry = entry
+	if entry == len(e.history) {
+		s.line = s.pending
+	} else {
+		s.line = []rune(e.history[entry])
+	}
+	s.pos = len(s.line)
+}
+
+// refresh redraws the line and places the cursor.
+func (e *lineEditor) refresh(s *editState) {
+
+	line := string(s.line)
+	if e.highlight {
+		line = lang.Highlight(line)
+	}
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K\r", s.prompt, line)
+	if column := utf8.RuneCountInString(s.prompt) + s.pos; column > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dC", column)
+	}
+}
+
+// addHistory appends the line to the history (and to the history
+// file), unless it is empty or repeats the previous line.
+func (e *lineEditor) addHistory(line string) {
+
+	if line == "" || (len(e.history) > 0 && e.history[len(e.history)-1] == line) {
+		return
+	}
+	e.history = append(e.history, line)
+
+	if e.historyFile == "" {
+		return
+	}
+	file, err := os.OpenFile(e.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(file, line)
+	file.Close()
+}
+
+// useHistoryFile loads the history of the previous sessions. The
+// file is trimmed to the last maxHistory lines. A missing file is
+// created when the first line is added.
+func (e *lineEditor) useHistoryFile(filename string) {
+
+	e.historyFile = filename
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return
+	}
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	if len(lines) > maxHistory {
+		lines = lines[len(lines)-maxHistory:]
+		ioutil.WriteFile(filename, []byte(strings.Join(lines, "\n")+"\n"), 0600)
+	}
+	for _, line := range lines {
+		if line != "" {
+			e.history = append(e.history, line)
+		}
+	}
+}
+
+// move moves the cursor by offset characters within the line.
+func (s *editState) move(offset int) {
+
+	pos := s.pos + offset
+	if pos >= 0 && pos <= len(s.line) {
+		s.pos = pos
+	}
+}
+
+// deleteAt deletes the character at pos, if any.
+func (s *editState) deleteAt(pos int) {
+
+	if pos < len(s.line) {
+		s.line = append(s.line[:pos], s.line[pos+1:]...)
+	}
+}
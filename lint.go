@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/rmonnet/glox/lang"
+	"github.com/rmonnet/glox/lint"
+)
+
+// runLint runs the "glox lint" subcommand. It checks the scripts
+// with the registered lint rules (all of them or those selected
+// with -rules) and writes the problems found on stdout. It exits
+// with an error if a script has errors.
+func runLint(args []string) {
+
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	ruleIDs := flags.String("rules", "", "comma separated IDs of the rules to run (all if empty)")
+	list := flags.Bool("list", false, "list the registered rules")
+	flags.Parse(args)
+
+	if *list {
+		for _, rule := range lint.Rules() {
+			fmt.Printf("%s\t%s\n", rule.ID(), rule.Severity())
+		}
+		return
+	}
+
+	if flags.NArg() == 0 {
+		fmt.Println("Usage glox lint [-rules=id,...] script...")
+		os.Exit(exUsage)
+	}
+
+	rules := lint.Rules()
+	if *ruleIDs != "" {
+		rules = nil
+		for _, id := range strings.Split(*ruleIDs, ",") {
+			rule := lint.Lookup(strings.TrimSpace(id))
+			if rule == nil {
+				fmt.Fprintf(os.Stderr, "unknown lint rule %q\n", id)
+				os.Exit(exUsage)
+			}
+			rules = append(rules, rule)
+		}
+	}
+
+	hadError := false
+	for _, filename := range flags.Args() {
+		script, err := ioutil.ReadFile(filename)
+		if err != nil {
+			fmt.Println("unable to read ", filename)
+			os.Exit(exDataErr)
+		}
+		for _, d := range lint.Lint(string(script), rules) {
+			fmt.Printf("%s: %s\n", filename, d)
+			if d.Severity == lang.ErrorSeverity {
+				hadError = true
+			}
+		}
+	}
+	if hadError {
+		os.Exit(exDataErr)
+	}
+}
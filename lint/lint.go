@@ -0,0 +1,200 @@
+// Package lint runs the lint rules of "glox lint" over the AST and
+// the symbol table of a lox script.
+//
+// A rule inspects each node of the AST in turn and reports the
+// problems it finds through the Context. The rules are registered
+// by name, usually from the init function of the package defining
+// them, so a custom build of glox only has to import a package of
+// rules for "glox lint" to run them:
+//
+//	func init() {
+//		lint.Register(maxParams{})
+//	}
+//
+// The package registers two rules of its own: class-name (the
+// class names start with an uppercase letter) and max-depth (the
+// statements are not nested too deeply).
+package lint
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+
+	"github.com/rmonnet/glox/interp"
+	"github.com/rmonnet/glox/lang"
+)
+
+// Rule is a lint rule.
+type Rule interface {
+	// ID is the unique name of the rule, like "class-name".
+	ID() string
+	// Severity is the severity of the problems reported by the rule.
+	Severity() lang.Severity
+	// Check inspects a node of the AST and reports its problems
+	// to the context. It is called for every node of the script,
+	// parents before their children.
+	Check(node lang.Node, ctx *Context)
+}
+
+// Diagnostic is a problem reported by a rule.
+type Diagnostic struct {
+	lang.Diagnostic
+	// Rule is the ID of the rule which reported the problem,
+	// empty for the syntax and resolution errors of the script.
+	Rule string
+}
+
+// String returns the diagnostic followed by the ID of its rule,
+// for example "[line 1] Warning at 'point': Class names start
+// with an uppercase letter. (class-name)".
+func (d Diagnostic) String() string {
+
+	if d.Rule == "" {
+		return d.Diagnostic.String()
+	}
+	return d.Diagnostic.String() + " (" + d.Rule + ")"
+}
+
+// Context gives the rules access to the script being checked
+// and collects the problems they report.
+type Context struct {
+	// Statements are the statements of the script.
+	Statements []lang.Stmt
+	// Symbols is the symbol table of the script.
+	Symbols []*interp.Symbol
+	// Depth is the depth of the node being checked,
+	// 0 for the statements of the script (see lang.Walk).
+	Depth int
+
+	rule        Rule
+	symbols     map[*lang.Token]*interp.Symbol
+	diagnostics []Diagnostic
+}
+
+// Report reports a problem located at the token.
+func (ctx *Context) Report(token *lang.Token, msg string) {
+
+	ctx.diagnostics = append(ctx.diagnostics, Diagnostic{
+		lang.NewDiagnostic(ctx.rule.Severity(), token, ctx.rule.ID(), msg), ctx.rule.ID()})
+}
+
+// Reportf reports a problem located at the token
+// with a formatted message.
+func (ctx *Context) Reportf(token *lang.Token, format string, args ...interface{}) {
+
+	ctx.Report(token, fmt.Sprintf(format, args...))
+}
+
+// Symbol returns the symbol declared or referenced by the token,
+// nil if the token doesn't name a symbol.
+func (ctx *Context) Symbol(token *lang.Token) *interp.Symbol {
+
+	return ctx.symbols[token]
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = make(map[string]Rule)
+)
+
+// Register makes a rule available to Lint and "glox lint".
+// It panics if a rule with the same ID is already registered.
+func Register(rule Rule) {
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, dup := registry[rule.ID()]; dup {
+		panic("lint: Register called twice for rule " + rule.ID())
+	}
+	registry[rule.ID()] = rule
+}
+
+// Rules returns the registered rules sorted by ID.
+func Rules() []Rule {
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	rules := make([]Rule, 0, len(registry))
+	for _, rule := range registry {
+		rules = append(rules, rule)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID() < rules[j].ID() })
+	return rules
+}
+
+// Lookup returns the registered rule with the given ID,
+// nil if there is none.
+func Lookup(id string) Rule {
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	return registry[id]
+}
+
+// Lint checks a lox script with the rules and returns the problems
+// they report, sorted by line. The rules are not run if the script
+// has syntax or resolution errors, those are returned instead.
+func Lint(source string, rules []Rule) []Diagnostic {
+
+	scanner := &lang.Scanner{}
+	scanner.RedirectErrors(ioutil.Discard)
+	tokens := scanner.ScanTokens(source)
+
+	parser := &lang.Parser{}
+	parser.RedirectErrors(ioutil.Discard)
+	statements := parser.Parse(tokens)
+
+	var errors []lang.Diagnostic
+	if scanner.HadError() || parser.HadError() {
+		errors = append(scanner.Diagnostics(), parser.Diagnostics()...)
+		return wrap(errors)
+	}
+
+	resolver := interp.NewResolver(interp.New(ioutil.Discard, ioutil.Discard))
+	resolver.RedirectErrors(ioutil.Discard)
+	resolver.SetRecordSymbols(true)
+	resolver.Resolve(statements)
+	for _, d := range resolver.Diagnostics() {
+		if d.Severity == lang.ErrorSeverity {
+			errors = append(errors, d)
+		}
+	}
+	if len(errors) > 0 {
+		return wrap(errors)
+	}
+
+	ctx := &Context{
+		Statements: statements,
+		Symbols:    resolver.Symbols(),
+		symbols:    make(map[*lang.Token]*interp.Symbol),
+	}
+	for _, symbol := range ctx.Symbols {
+		ctx.symbols[symbol.Definition] = symbol
+		for _, ref := range symbol.References {
+			ctx.symbols[ref] = symbol
+		}
+	}
+	for _, rule := range rules {
+		ctx.rule = rule
+		lang.Walk(statements, func(node lang.Node, depth int) {
+			ctx.Depth = depth
+			rule.Check(node, ctx)
+		})
+	}
+	sort.SliceStable(ctx.diagnostics, func(i, j int) bool {
+		return ctx.diagnostics[i].Line < ctx.diagnostics[j].Line
+	})
+	return ctx.diagnostics
+}
+
+// wrap converts the errors of the script to lint diagnostics.
+func wrap(errors []lang.Diagnostic) []Diagnostic {
+
+	diagnostics := make([]Diagnostic, len(errors))
+	for i, d := range errors {
+		diagnostics[i] = Diagnostic{Diagnostic: d}
+	}
+	return diagnostics
+}
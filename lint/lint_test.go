@@ -0,0 +1,82 @@
+package lint
+
+import (
+	"testing"
+
+	"github.com/rmonnet/glox/interp"
+	"github.com/rmonnet/glox/lang"
+)
+
+// unusedGlobal reports the global variables never referenced,
+// it exercises the symbol table of the context.
+type unusedGlobal struct{}
+
+func (unusedGlobal) ID() string              { return "unused-global" }
+func (unusedGlobal) Severity() lang.Severity { return lang.WarningSeverity }
+
+func (unusedGlobal) Check(node lang.Node, ctx *Context) {
+
+	decl, ok := node.(*lang.VarDeclStmt)
+	if !ok {
+		return
+	}
+	symbol := ctx.Symbol(decl.Name)
+	if symbol != nil && symbol.Kind == interp.VariableSymbol &&
+		symbol.Depth == 0 && len(symbol.References) == 0 {
+		ctx.Reportf(decl.Name, "Unused global '%s'.", decl.Name.Lexeme)
+	}
+}
+
+func TestLint(t *testing.T) {
+
+	script := `class point {}
+var a = 1;
+var b = 2;
+print a;
+fun f() {
+  if (a) { while (a) { if (a) { { { print a; } } } } }
+}
+`
+	rules := []Rule{unusedGlobal{}, Lookup("class-name"), Lookup("max-depth")}
+	expect := []string{
+		"[line 1] Warning at 'point': Class names start with an uppercase letter. (class-name)",
+		"[line 3] Warning at 'b': Unused global 'b'. (unused-global)",
+		"[line 6] Warning at 'print': Statement nested more than 5 levels deep. (max-depth)",
+	}
+
+	got := Lint(script, rules)
+	if len(got) != len(expect) {
+		t.Fatalf("Expected %d diagnostics but got %v", len(expect), got)
+	}
+	for i, d := range got {
+		if d.String() != expect[i] {
+			t.Errorf("Expected %q but got %q", expect[i], d.String())
+		}
+	}
+}
+
+func TestLintSyntaxError(t *testing.T) {
+
+	got := Lint("print 1", Rules())
+	if len(got) != 1 || got[0].Rule != "" || got[0].Severity != lang.ErrorSeverity {
+		t.Errorf("Expected a syntax error but got %v", got)
+	}
+}
+
+func TestRegister(t *testing.T) {
+
+	ids := []string{}
+	for _, rule := range Rules() {
+		ids = append(ids, rule.ID())
+	}
+	if len(ids) != 2 || ids[0] != "class-name" || ids[1] != "max-depth" {
+		t.Errorf("Expected the built-in rules but got %v", ids)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a panic registering a rule twice")
+		}
+	}()
+	Register(ClassName{})
+}
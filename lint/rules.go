@@ -0,0 +1,110 @@
+package lint
+
+import (
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rmonnet/glox/lang"
+)
+
+// init registers the built-in rules.
+func init() {
+
+	Register(ClassName{})
+	Register(MaxDepth{Limit: 5})
+}
+
+// ClassName reports the class names which don't start with
+// an uppercase letter.
+type ClassName struct{}
+
+// ID returns "class-name".
+func (ClassName) ID() string { return "class-name" }
+
+// Severity returns WarningSeverity.
+func (ClassName) Severity() lang.Severity { return lang.WarningSeverity }
+
+// Check reports the class declarations with a lowercase name.
+func (ClassName) Check(node lang.Node, ctx *Context) {
+
+	class, ok := node.(*lang.ClassDeclStmt)
+	if !ok {
+		return
+	}
+	first, _ := utf8.DecodeRuneInString(class.Name.Lexeme)
+	if !unicode.IsUpper(first) {
+		ctx.Report(class.Name, "Class names start with an uppercase letter.")
+	}
+}
+
+// MaxDepth reports the statements nested in more than Limit
+// blocks, loops, conditionals and functions.
+type MaxDepth struct {
+	Limit int
+}
+
+// ID returns "max-depth".
+func (MaxDepth) ID() string { return "max-depth" }
+
+// Severity returns WarningSeverity.
+func (MaxDepth) Severity() lang.Severity { return lang.WarningSeverity }
+
+// Check measures the nesting of the statements of the script,
+// it is reported once per top-level statement.
+func (r MaxDepth) Check(node lang.Node, ctx *Context) {
+
+	stmt, ok := node.(lang.Stmt)
+	if !ok || ctx.Depth != 0 {
+		return
+	}
+	if deep := r.tooDeep(stmt, 0); deep != nil {
+		ctx.Reportf(deep, "Statement nested more than %d levels deep.", r.Limit)
+	}
+}
+
+// tooDeep returns the token of the first statement nested
+// deeper than the limit, nil if there is none.
+func (r MaxDepth) tooDeep(stmt lang.Stmt, level int) *lang.Token {
+
+	if level > r.Limit {
+		// nil for an empty block, which is not a problem.
+		return lang.StmtStart(stmt)
+	}
+	var children []lang.Stmt
+	switch s := stmt.(type) {
+	case *lang.BlockStmt:
+		if s.Desugared {
+			// a for loop is a single level.
+			return r.tooDeepIn(s.Statements, level)
+		}
+		children = s.Statements
+	case *lang.ClassDeclStmt:
+		for _, method := range s.Methods {
+			if deep := r.tooDeepIn(method.Body, level+1); deep != nil {
+				return deep
+			}
+		}
+	case *lang.FunDeclStmt:
+		children = s.Body
+	case *lang.IfStmt:
+		children = []lang.Stmt{s.ThenBranch}
+		if s.ElseBranch != nil {
+			children = append(children, s.ElseBranch)
+		}
+	case *lang.WhileStmt:
+		children = []lang.Stmt{s.Body}
+	}
+	return r.tooDeepIn(children, level+1)
+}
+
+// tooDeepIn returns the token of the first statement of the list
+// nested deeper than the limit, nil if there is none.
+func (r MaxDepth) tooDeepIn(statements []lang.Stmt, level int) *lang.Token {
+
+	for _, stmt := range statements {
+		if deep := r.tooDeep(stmt, level); deep != nil {
+			return deep
+		}
+	}
+	return nil
+}
@@ -0,0 +1,552 @@
+// Package loxrt is the runtime support of the Go programs generated
+// by "glox transpile -target=go". It implements the lox values and
+// operators with the semantics of the glox interpreter (default
+// options), and reports the runtime errors like it.
+package loxrt
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+// Value is a lox value: nil, a bool, a number (float64), a string,
+// a *Function, a *Class or an *Instance.
+type Value = interface{}
+
+// MaxCallDepth is the number of nested calls allowed before
+// a stack overflow is reported, like in the interpreter.
+var MaxCallDepth = 1000
+
+// Out receives the output of the print statements.
+// It is flushed when the program ends.
+var Out = bufio.NewWriter(os.Stdout)
+
+// Error is a lox runtime error. The functions of the package
+// panic with an *Error, which Run reports.
+type Error struct {
+	Line    int
+	Message string
+}
+
+// Error returns the error as reported by the interpreter,
+// for example "[line 3] Operands must be numbers.".
+func (e *Error) Error() string {
+	return fmt.Sprintf("[line %d] %s", e.Line, e.Message)
+}
+
+// fail reports a runtime error at the line.
+func fail(line int, format string, args ...interface{}) {
+
+	panic(&Error{line, fmt.Sprintf(format, args...)})
+}
+
+// Run executes the script and flushes the output. A runtime error
+// is reported on stderr and the program exits with the status 70
+// like the interpreter.
+func Run(script func()) {
+
+	defer func() {
+		if e := recover(); e != nil {
+			err, ok := e.(*Error)
+			if !ok {
+				panic(e)
+			}
+			Out.Flush()
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(70)
+		}
+	}()
+	script()
+	Out.Flush()
+}
+
+// -----------
+// Variables
+// -----------
+
+// Global is a global variable. The generated code creates the
+// globals it references once, accessing them doesn't look up
+// their names.
+type Global struct {
+	name    string
+	defined bool
+	value   Value
+}
+
+// globals holds the global variables by name, starting with
+// the natives.
+var globals = map[string]*Global{}
+
+// init defines the natives as globals.
+func init() {
+
+	natives := []*Function{
+		{Name: "clock", Arity: 0, Native: true, Code: func(args []Value) Value {
+			return float64(time.Now().Unix())
+		}},
+		{Name: "isNaN", Arity: 1, Native: true, Code: func(args []Value) Value {
+			n, ok := args[0].(float64)
+			return ok && math.IsNaN(n)
+		}},
+		{Name: "isFinite", Arity: 1, Native: true, Code: func(args []Value) Value {
+			n, ok := args[0].(float64)
+			return ok && !math.IsNaN(n) && !math.IsInf(n, 0)
+		}},
+		{Name: "deepEquals", Arity: 2, Native: true, Code: func(args []Value) Value {
+			return deepEqual(args[0], args[1], make(map[[2]*Instance]bool))
+		}},
+	}
+	for _, native := range natives {
+		NewGlobal(native.Name).Define(native)
+	}
+}
+
+// NewGlobal returns the global variable with the name,
+// which is not defined until the script declares it.
+func NewGlobal(name string) *Global {
+
+	global, ok := globals[name]
+	if !ok {
+		global = &Global{name: name}
+		globals[name] = global
+	}
+	return global
+}
+
+// Define defines (or redefines) the global variable.
+func (g *Global) Define(value Value) {
+
+	g.defined = true
+	g.value = value
+}
+
+// Get returns the value of the global variable.
+func (g *Global) Get(line int) Value {
+
+	if !g.defined {
+		fail(line, "Undefined variable '%s'.", g.name)
+	}
+	return g.value
+}
+
+// Set assigns the global variable, which must be defined,
+// and returns the value.
+func (g *Global) Set(value Value, line int) Value {
+
+	if !g.defined {
+		fail(line, "Undefined variable '%s'.", g.name)
+	}
+	g.value = value
+	return value
+}
+
+// Store assigns a local variable and returns the value,
+// for the assignments used as expressions.
+func Store(variable *Value, value Value) Value {
+
+	*variable = value
+	return value
+}
+
+// -----------
+// Operators
+// -----------
+
+// Truthy returns false for nil and false, true otherwise.
+func Truthy(value Value) bool {
+
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	}
+	return true
+}
+
+// Not returns the logical negation of the value.
+func Not(value Value) Value {
+
+	return !Truthy(value)
+}
+
+// And returns the left value if it is false, otherwise
+// it evaluates and returns the right one.
+func And(left Value, right func() Value) Value {
+
+	if !Truthy(left) {
+		return left
+	}
+	return right()
+}
+
+// Or returns the left value if it is true, otherwise
+// it evaluates and returns the right one.
+func Or(left Value, right func() Value) Value {
+
+	if Truthy(left) {
+		return left
+	}
+	return right()
+}
+
+// number returns the number operand of an arithmetic
+// or comparison operator.
+func number(value Value, line int) float64 {
+
+	n, ok := value.(float64)
+	if !ok {
+		fail(line, "Operand must be a number.")
+	}
+	return n
+}
+
+// Negate returns the opposite of a number.
+func Negate(value Value, line int) Value {
+
+	return -number(value, line)
+}
+
+// Add adds two numbers or concatenates two values when one of them
+// is a string (the other one is converted to a string).
+func Add(left, right Value, line int) Value {
+
+	l, lok := left.(float64)
+	r, rok := right.(float64)
+	if lok && rok {
+		return l + r
+	}
+	_, lstring := left.(string)
+	_, rstring := right.(string)
+	if !lstring && !rstring {
+		fail(line, "Operands must be two numbers or at least one string.")
+	}
+	return toString(left) + toString(right)
+}
+
+// Subtract subtracts two numbers.
+func Subtract(left, right Value, line int) Value {
+
+	return number(left, line) - number(right, line)
+}
+
+// Multiply multiplies two numbers.
+func Multiply(left, right Value, line int) Value {
+
+	return number(left, line) * number(right, line)
+}
+
+// Divide divides two numbers, the division by zero is an error.
+func Divide(left, right Value, line int) Value {
+
+	dividend, divisor := number(left, line), number(right, line)
+	if divisor == 0 {
+		fail(line, "Division by zero.")
+	}
+	return dividend / divisor
+}
+
+// Greater compares two numbers.
+func Greater(left, right Value, line int) Value {
+
+	return number(left, line) > number(right, line)
+}
+
+// GreaterEqual compares two numbers.
+func GreaterEqual(left, right Value, line int) Value {
+
+	return number(left, line) >= number(right, line)
+}
+
+// Less compares two numbers.
+func Less(left, right Value, line int) Value {
+
+	return number(left, line) < number(right, line)
+}
+
+// LessEqual compares two numbers.
+func LessEqual(left, right Value, line int) Value {
+
+	return number(left, line) <= number(right, line)
+}
+
+// Equal compares two values: the strings by value and the
+// objects by identity, except the bound methods which are equal
+// if they bind the same method to the same instance, like in the
+// interpreter. NaN is not equal to itself.
+func Equal(left, right Value) Value {
+
+	if l, ok := left.(*Function); ok && l.method != nil {
+		if r, ok := right.(*Function); ok {
+			return l.method == r.method && l.this == r.this
+		}
+	}
+	return left == right
+}
+
+// NotEqual is the negation of Equal.
+func NotEqual(left, right Value) Value {
+
+	return !Equal(left, right).(bool)
+}
+
+// ---------------------
+// Functions and classes
+// ---------------------
+
+// Function is a lox function, a native function or a method
+// bound to an instance.
+type Function struct {
+	Name   string
+	Arity  int
+	Native bool
+	Code   func(args []Value) Value
+	// method and this are set for a bound method.
+	method *Method
+	this   *Instance
+}
+
+// NewFunction creates a lox function.
+func NewFunction(name string, arity int, code func(args []Value) Value) *Function {
+
+	return &Function{Name: name, Arity: arity, Code: code}
+}
+
+// String returns a printable representation of the function.
+func (f *Function) String() string {
+
+	if f.Native {
+		return "<native fun>"
+	}
+	return "<fun " + f.Name + ">"
+}
+
+// Method is a method of a class, which receives the instance
+// it is bound to.
+type Method struct {
+	Name  string
+	Arity int
+	Code  func(this *Instance, args []Value) Value
+}
+
+// bind returns the method bound to the instance.
+func (m *Method) bind(this *Instance) *Function {
+
+	return &Function{Name: m.Name, Arity: m.Arity, Code: func(args []Value) Value {
+		return m.Code(this, args)
+	}, method: m, this: this}
+}
+
+// Class is a lox class. Methods includes the methods
+// inherited from the superclass.
+type Class struct {
+	Name       string
+	Superclass *Class
+	Methods    map[string]*Method
+}
+
+// NewClass creates a class inheriting the methods
+// of the superclass (which may be nil).
+func NewClass(name string, superclass *Class) *Class {
+
+	class := &Class{name, superclass, map[string]*Method{}}
+	if superclass != nil {
+		for name, method := range superclass.Methods {
+			class.Methods[name] = method
+		}
+	}
+	return class
+}
+
+// Superclass checks the superclass of a class declaration
+// is a class.
+func Superclass(value Value, line int) *Class {
+
+	class, ok := value.(*Class)
+	if !ok {
+		fail(line, "Superclass must be a class.")
+	}
+	return class
+}
+
+// Method adds a method to the class.
+func (c *Class) Method(name string, arity int, code func(this *Instance, args []Value) Value) {
+
+	c.Methods[name] = &Method{name, arity, code}
+}
+
+// String returns a printable representation of the class.
+func (c *Class) String() string {
+
+	return "<class " + c.Name + ">"
+}
+
+// Instance is an instance of a lox class.
+type Instance struct {
+	Class  *Class
+	Fields map[string]Value
+}
+
+// String returns a printable representation of the instance.
+func (i *Instance) String() string {
+
+	return "<instance " + i.Class.Name + ">"
+}
+
+// Call calls a function or instantiates a class.
+func Call(callee Value, line int, args ...Value) Value {
+
+	var function *Function
+	switch c := callee.(type) {
+	case *Function:
+		function = c
+	case *Class:
+		instance := &Instance{c, map[string]Value{}}
+		function = &Function{Name: c.Name, Code: func(args []Value) Value {
+			return instance
+		}}
+		if init, ok := c.Methods["init"]; ok {
+			function = init.bind(instance)
+		}
+	default:
+		fail(line, "Can only call functions and classes.")
+	}
+
+	if len(args) != function.Arity {
+		fail(line, "Expected %d arguments but got %d.", function.Arity, len(args))
+	}
+	if MaxCallDepth > 0 && depth >= MaxCallDepth {
+		fail(line, "Stack overflow.")
+	}
+	// the depth is not restored on errors since they end the program.
+	depth++
+	result := function.Code(args)
+	depth--
+	return result
+}
+
+// depth is the number of nested calls.
+var depth int
+
+// instance returns the instance whose property is accessed.
+func instance(object Value, line int) *Instance {
+
+	instance, ok := object.(*Instance)
+	if !ok {
+		fail(line, "Only class instances have fields.")
+	}
+	return instance
+}
+
+// Get returns a field of the instance or one of its methods
+// bound to it. Fields shadow methods.
+func Get(object Value, name string, line int) Value {
+
+	instance := instance(object, line)
+	if value, ok := instance.Fields[name]; ok {
+		return value
+	}
+	if method, ok := instance.Class.Methods[name]; ok {
+		return method.bind(instance)
+	}
+	fail(line, "Undefined field or method '%s'.", name)
+	return nil
+}
+
+// Object checks the object of a field assignment is an instance.
+// It is called before the value is evaluated, like in the interpreter.
+func Object(object Value, line int) *Instance {
+
+	return instance(object, line)
+}
+
+// Set assigns a field of the instance and returns the value.
+func Set(instance *Instance, name string, value Value) Value {
+
+	instance.Fields[name] = value
+	return value
+}
+
+// Super returns the method of the superclass bound to the instance.
+func Super(superclass *Class, this *Instance, name string, line int) Value {
+
+	method, ok := superclass.Methods[name]
+	if !ok {
+		fail(line, "Undefined method '%s'.", name)
+	}
+	return method.bind(this)
+}
+
+// ---------
+// Printing
+// ---------
+
+// Print prints the value followed by a new line.
+func Print(value Value) {
+
+	Out.WriteString(stringify(value))
+	Out.WriteByte('\n')
+}
+
+// stringify returns the printable representation of the value.
+func stringify(value Value) string {
+
+	switch v := value.(type) {
+	case nil:
+		return "nil"
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return "nan"
+		case math.IsInf(v, 1):
+			return "inf"
+		case math.IsInf(v, -1):
+			return "-inf"
+		}
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// toString converts the operand of a string concatenation.
+func toString(value Value) string {
+
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return stringify(value)
+}
+
+// ---------
+// Natives
+// ---------
+
+// deepEqual compares instances field by field (recursively),
+// other values like Equal.
+func deepEqual(left, right Value, compared map[[2]*Instance]bool) bool {
+
+	l, ok := left.(*Instance)
+	if !ok {
+		return left == right
+	}
+	r, ok := right.(*Instance)
+	if !ok {
+		return false
+	}
+	if l == r || compared[[2]*Instance{l, r}] {
+		return true
+	}
+	if l.Class != r.Class || len(l.Fields) != len(r.Fields) {
+		return false
+	}
+	compared[[2]*Instance{l, r}] = true
+	for name, value := range l.Fields {
+		other, ok := r.Fields[name]
+		if !ok || !deepEqual(value, other, compared) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,72 @@
+package loxrt
+
+import (
+	"math"
+	"testing"
+)
+
+func TestOperators(t *testing.T) {
+
+	tests := []struct {
+		value    Value
+		expected string
+	}{
+		{Add(1.0, 2.0, 1), "3"},
+		{Add("a", 1.5, 1), "a1.5"},
+		{Add(nil, "b", 1), "nilb"},
+		{Divide(1.0, 4.0, 1), "0.25"},
+		{Equal(math.NaN(), math.NaN()), "false"},
+		{Equal("a", "a"), "true"},
+		{Not(0.0), "false"},
+		{Or(nil, func() Value { return "right" }), "right"},
+		{And(false, func() Value { return "right" }), "false"},
+		{math.Inf(-1), "-inf"},
+		{NewClass("A", nil), "<class A>"},
+	}
+
+	for _, test := range tests {
+		if actual := stringify(test.value); actual != test.expected {
+			t.Errorf("expected %s, got %s", test.expected, actual)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+
+	class := NewClass("A", nil)
+	class.Method("init", 1, func(this *Instance, args []Value) Value { return this })
+
+	tests := []struct {
+		run      func()
+		expected string
+	}{
+		{func() { Add(1.0, true, 2) }, "[line 2] Operands must be two numbers or at least one string."},
+		{func() { Less(1.0, "a", 3) }, "[line 3] Operand must be a number."},
+		{func() { Divide(1.0, 0.0, 4) }, "[line 4] Division by zero."},
+		{func() { Divide("a", 0.0, 4) }, "[line 4] Operand must be a number."},
+		{func() { Call(class, 5) }, "[line 5] Expected 1 arguments but got 0."},
+		{func() { Call("a", 6) }, "[line 6] Can only call functions and classes."},
+		{func() { Get(Call(class, 7, 1.0), "x", 7) }, "[line 7] Undefined field or method 'x'."},
+		{func() { Object(1.0, 8) }, "[line 8] Only class instances have fields."},
+		{func() { NewGlobal("undefined").Get(9) }, "[line 9] Undefined variable 'undefined'."},
+	}
+
+	for _, test := range tests {
+		if actual := runtimeError(test.run); actual != test.expected {
+			t.Errorf("expected %q, got %q", test.expected, actual)
+		}
+	}
+}
+
+// runtimeError runs the function and returns
+// the runtime error it reported.
+func runtimeError(run func()) (message string) {
+
+	defer func() {
+		if err, ok := recover().(*Error); ok {
+			message = err.Error()
+		}
+	}()
+	run()
+	return ""
+}
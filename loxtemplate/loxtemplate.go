@@ -0,0 +1,62 @@
+// Package loxtemplate evaluates lox expressions in the Go templates
+// (text/template and html/template) with the lox function:
+//
+//	{{lox "user.score * 2" .}}
+//	{{.Price | lox "it * 1.2"}}
+//
+// The expression is evaluated with the data of the template as
+// variables, the result is a Go value (nil, a bool, a float64, a
+// string or a map for the instances) which can be piped to the
+// other functions of the template.
+package loxtemplate
+
+import (
+	"errors"
+	"io/ioutil"
+	"text/template"
+
+	"github.com/rmonnet/glox/interp"
+)
+
+// DefaultMaxSteps is the number of statements and calls an
+// expression may execute before it is stopped, so a template
+// can't loop forever.
+const DefaultMaxSteps = 100000
+
+// FuncMap returns the functions of the templates, only lox:
+//
+//	tmpl := template.New("page").Funcs(loxtemplate.FuncMap())
+//
+// The html templates use the conversion
+// htmltemplate.FuncMap(loxtemplate.FuncMap()).
+func FuncMap() template.FuncMap {
+
+	return template.FuncMap{"lox": Eval}
+}
+
+// Eval evaluates a lox expression in a new interpreter, where
+// the data is injected for each render: the data (usually the
+// dot of the template or the value piped to lox) is the variable
+// it and, if it is a map with string keys or a struct, each of its
+// entries or exported fields is a variable too (the fields are
+// named by their `lox` tag if any). The error, which stops the
+// execution of the template, is the syntax error or the runtime
+// error of the expression.
+func Eval(expression string, data ...interface{}) (interface{}, error) {
+
+	if len(data) > 1 {
+		return nil, errors.New("lox: expected an expression and at most one data value")
+	}
+	lox := interp.New(ioutil.Discard, ioutil.Discard)
+	lox.SetMaxSteps(DefaultMaxSteps)
+	if len(data) == 1 {
+		lox.Define("it", data[0])
+		// a value which is not a map or a struct is only it.
+		lox.DefineFields(data[0])
+	}
+	value, err := lox.Eval(expression)
+	if err != nil {
+		return nil, errors.New("lox: " + err.Error())
+	}
+	return value, nil
+}
@@ -0,0 +1,70 @@
+package loxtemplate
+
+import (
+	htmltemplate "html/template"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+type user struct {
+	Name  string `lox:"name"`
+	Score int    `lox:"score"`
+}
+
+func TestTextTemplate(t *testing.T) {
+
+	tests := []struct {
+		name, template, want string
+		data                 interface{}
+	}{
+		{"fields", `{{lox "user.name + \" has \" + user.score * 2"  .}}`, "ada has 84",
+			map[string]interface{}{"user": user{"ada", 42}}},
+		{"struct", `{{lox "score > 40" .}}`, "true", user{"ada", 42}},
+		{"pipeline", `{{.Score | lox "it / 4" | printf "%.1f"}}`, "10.5", user{"ada", 42}},
+		{"no data", `{{lox "1 + 2"}}`, "3", nil},
+		{"per render", `{{range .}}{{lox "it * it" .}} {{end}}`, "1 4 9 ", []int{1, 2, 3}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tmpl := template.Must(template.New(test.name).Funcs(FuncMap()).Parse(test.template))
+			var out strings.Builder
+			if err := tmpl.Execute(&out, test.data); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != test.want {
+				t.Errorf("expected %q, got %q", test.want, out.String())
+			}
+		})
+	}
+}
+
+func TestHTMLTemplate(t *testing.T) {
+
+	tmpl := htmltemplate.Must(htmltemplate.New("page").
+		Funcs(htmltemplate.FuncMap(FuncMap())).
+		Parse(`<p>{{lox "\"<\" + name + \">\"" .}}</p>`))
+	var out strings.Builder
+	if err := tmpl.Execute(&out, user{"ada", 42}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "<p>&lt;ada&gt;</p>"; out.String() != want {
+		t.Errorf("expected %q, got %q", want, out.String())
+	}
+}
+
+func TestErrors(t *testing.T) {
+
+	tests := []struct{ template, want string }{
+		{`{{lox "1 +"}}`, "lox: Expect expression."},
+		{`{{lox "missing"}}`, "lox: Undefined variable 'missing'."},
+		{`{{lox "fun"}}`, "lox: Expect expression."},
+	}
+	for _, test := range tests {
+		tmpl := template.Must(template.New("error").Funcs(FuncMap()).Parse(test.template))
+		err := tmpl.Execute(&strings.Builder{}, nil)
+		if err == nil || !strings.HasSuffix(err.Error(), test.want) {
+			t.Errorf("expected the error %q, got %v", test.want, err)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/rmonnet/glox/lsp"
+)
+
+// runLSP runs the "glox lsp" subcommand, a Language Server Protocol
+// server speaking to the editor over stdin and stdout.
+func runLSP(args []string) {
+
+	flags := flag.NewFlagSet("lsp", flag.ExitOnError)
+	flags.Parse(args)
+
+	if flags.NArg() != 0 {
+		fmt.Println("Usage glox lsp")
+		os.Exit(exUsage)
+	}
+
+	if err := lsp.Serve(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "glox lsp:", err)
+		// the protocol requires the exit code 1
+		// when exiting without shutdown.
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,478 @@
+// Package lsp implements a Language Server Protocol server for lox,
+// so the editors supporting the protocol can check, navigate and
+// format the scripts. It provides:
+//   - the diagnostics of the scanner, the parser and the resolver
+//     (interp.CheckDocument) when a document is opened or changed,
+//     the document is parsed again incrementally (lang.Document)
+//   - go to definition and hover for the variables, functions and
+//     classes of the symbol table (interp.Symbols)
+//   - the global declarations as document symbols
+//   - formatting in the canonical style of "glox fmt" (lang.FormatSource)
+//   - renaming the variables, functions and classes (interp.Rename)
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/rmonnet/glox/interp"
+	"github.com/rmonnet/glox/lang"
+)
+
+// ErrNoShutdown is returned by Serve when the client asked the server
+// to exit without shutting it down first.
+var ErrNoShutdown = errors.New("exit without shutdown")
+
+// server holds the state of a session: the open documents by URI.
+type server struct {
+	out       io.Writer
+	documents map[string]*document
+	shutdown  bool
+}
+
+// Serve runs a server reading the messages of the client from in and
+// writing its responses and notifications to out, usually the
+// standard input and output. It returns when the client asks the
+// server to exit or closes its input.
+func Serve(in io.Reader, out io.Writer) error {
+
+	s := &server{out: out, documents: map[string]*document{}}
+	reader := bufio.NewReader(in)
+	for {
+		body, err := readMessage(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var msg message
+		if err := json.Unmarshal(body, &msg); err != nil {
+			if err := s.reply(nil, nil, &responseError{parseError, err.Error()}); err != nil {
+				return err
+			}
+			continue
+		}
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return ErrNoShutdown
+			}
+			return nil
+		}
+		if err := s.handle(&msg); err != nil {
+			return err
+		}
+	}
+}
+
+// handle dispatches a request or a notification and answers
+// the requests. It only fails if the output can't be written.
+func (s *server) handle(msg *message) error {
+
+	if s.shutdown && msg.ID != nil {
+		return s.reply(msg.ID, nil, &responseError{invalidRequest, "server is shut down"})
+	}
+
+	// paramsErr reports invalid parameters to the requests,
+	// the invalid notifications are ignored.
+	var paramsErr error
+	decode := func(params interface{}) bool {
+		paramsErr = json.Unmarshal(msg.Params, params)
+		return paramsErr == nil
+	}
+
+	var result interface{}
+	var err error
+	switch msg.Method {
+	case "initialize":
+		result = map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":           textDocumentSyncFull,
+				"definitionProvider":         true,
+				"hoverProvider":              true,
+				"documentSymbolProvider":     true,
+				"documentFormattingProvider": true,
+				"renameProvider":             true,
+			},
+			"serverInfo": map[string]string{"name": "glox"},
+		}
+	case "shutdown":
+		s.shutdown = true
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if decode(&params) {
+			err = s.update(params.TextDocument.URI, params.TextDocument.Text)
+		}
+	case "textDocument/didChange":
+		var params didChangeParams
+		if decode(&params) && len(params.ContentChanges) > 0 {
+			// the whole document is sent (textDocumentSyncFull).
+			changes := params.ContentChanges
+			err = s.update(params.TextDocument.URI, changes[len(changes)-1].Text)
+		}
+	case "textDocument/didClose":
+		var params didCloseParams
+		if decode(&params) {
+			delete(s.documents, params.TextDocument.URI)
+			err = s.notify("textDocument/publishDiagnostics",
+				publishDiagnosticsParams{params.TextDocument.URI, []diagnostic{}})
+		}
+	case "textDocument/definition":
+		var params textDocumentPositionParams
+		if decode(&params) {
+			result = s.definition(params)
+		}
+	case "textDocument/hover":
+		var params textDocumentPositionParams
+		if decode(&params) {
+			result = s.hover(params)
+		}
+	case "textDocument/documentSymbol":
+		var params documentParams
+		if decode(&params) {
+			result = s.documentSymbols(params.TextDocument.URI)
+		}
+	case "textDocument/formatting":
+		var params documentParams
+		if decode(&params) {
+			result = s.format(params.TextDocument.URI)
+		}
+	case "textDocument/rename":
+		var params renameParams
+		if decode(&params) {
+			var failure *responseError
+			if result, failure = s.rename(params); failure != nil {
+				return s.reply(msg.ID, nil, failure)
+			}
+		}
+	default:
+		if msg.ID != nil {
+			return s.reply(msg.ID, nil,
+				&responseError{methodNotFound, "method not found: " + msg.Method})
+		}
+		// the unknown notifications are ignored.
+		return nil
+	}
+
+	if err != nil || msg.ID == nil {
+		return err
+	}
+	if paramsErr != nil {
+		return s.reply(msg.ID, nil, &responseError{invalidParams, paramsErr.Error()})
+	}
+	return s.reply(msg.ID, result, nil)
+}
+
+// reply answers a request. The result null is written explicitly
+// since omitting it would make the response invalid.
+func (s *server) reply(id *json.RawMessage, result interface{}, err *responseError) error {
+
+	if id == nil {
+		null := json.RawMessage("null")
+		id = &null
+	}
+	if result == nil && err == nil {
+		result = json.RawMessage("null")
+	}
+	return writeMessage(s.out, &message{ID: id, Result: result, Error: err})
+}
+
+// notify sends a notification to the client.
+func (s *server) notify(method string, params interface{}) error {
+
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return writeMessage(s.out, &message{Method: method, Params: body})
+}
+
+// update records the new text of a document and publishes
+// its diagnostics.
+func (s *server) update(uri, text string) error {
+
+	doc := newDocument(text, s.documents[uri])
+	s.documents[uri] = doc
+	diagnostics := []diagnostic{}
+	for _, d := range interp.CheckDocument(doc.parsed) {
+		severity := severityError
+		if d.Severity == lang.WarningSeverity {
+			severity = severityWarning
+		}
+		diagnostics = append(diagnostics,
+			diagnostic{doc.span(d.Line, d.Column, d.Length), severity, "glox", d.Message})
+	}
+	return s.notify("textDocument/publishDiagnostics",
+		publishDiagnosticsParams{uri, diagnostics})
+}
+
+// symbolAt returns the symbol declared or referenced at the position
+// of a document and the token found there, or nil.
+func (s *server) symbolAt(params textDocumentPositionParams) (*document, *interp.Symbol, *lang.Token) {
+
+	doc := s.documents[params.TextDocument.URI]
+	if doc == nil {
+		return nil, nil, nil
+	}
+	line, column := doc.column(params.Position)
+	for _, symbol := range doc.symbols() {
+		tokens := append([]*lang.Token{symbol.Definition}, symbol.References...)
+		for _, token := range tokens {
+			// the cursor may also be right after the name.
+			if token.Line == line && column >= token.Column &&
+				column <= token.Column+utf8.RuneCountInString(token.Lexeme) {
+				return doc, symbol, token
+			}
+		}
+	}
+	return doc, nil, nil
+}
+
+// definition returns the location of the declaration of the symbol
+// at the position, or nil.
+func (s *server) definition(params textDocumentPositionParams) interface{} {
+
+	doc, symbol, _ := s.symbolAt(params)
+	if symbol == nil {
+		return nil
+	}
+	return location{params.TextDocument.URI, doc.tokenRange(symbol.Definition)}
+}
+
+// hover describes the symbol at the position: its declaration
+// followed by its doc comment, or nil.
+func (s *server) hover(params textDocumentPositionParams) interface{} {
+
+	doc, symbol, token := s.symbolAt(params)
+	if symbol == nil {
+		return nil
+	}
+	declaration := symbol.Kind.String() + " " + symbol.Name
+	comment := ""
+	lang.Walk(doc.statements(), func(node lang.Node, depth int) {
+		switch n := node.(type) {
+		case *lang.FunDeclStmt:
+			if sameToken(n.Name, symbol.Definition) {
+				declaration = "fun " + signature(n)
+				comment = n.Doc
+			}
+		case *lang.ClassDeclStmt:
+			if sameToken(n.Name, symbol.Definition) {
+				declaration = "class " + n.Name.Lexeme
+				if n.Superclass != nil {
+					declaration += " < " + n.Superclass.Name.Lexeme
+				}
+				comment = n.Doc
+			}
+		}
+	})
+	text := "```lox\n" + declaration + "\n```"
+	if comment != "" {
+		text += "\n\n" + comment
+	}
+	return hover{markupContent{"markdown", text}, doc.tokenRange(token)}
+}
+
+// documentSymbols returns the global variables, functions and classes
+// of a document.
+func (s *server) documentSymbols(uri string) interface{} {
+
+	doc := s.documents[uri]
+	if doc == nil {
+		return nil
+	}
+	symbols := []symbolInformation{}
+	for _, symbol := range doc.symbols() {
+		if symbol.Depth > 0 {
+			continue
+		}
+		kind := symbolVariable
+		switch symbol.Kind {
+		case interp.FunctionSymbol:
+			kind = symbolFunction
+		case interp.ClassSymbol:
+			kind = symbolClass
+		}
+		symbols = append(symbols, symbolInformation{symbol.Name, kind,
+			location{uri, doc.tokenRange(symbol.Definition)}})
+	}
+	return symbols
+}
+
+// format returns the edit replacing a document by its formatted text,
+// no edit if it is already formatted, or nil if it has syntax errors.
+func (s *server) format(uri string) interface{} {
+
+	doc := s.documents[uri]
+	if doc == nil {
+		return nil
+	}
+	formatted, err := lang.FormatSource(doc.text)
+	if err != nil {
+		return nil
+	}
+	edits := []textEdit{}
+	if formatted != doc.text {
+		edits = append(edits, doc.replaceAll(formatted))
+	}
+	return edits
+}
+
+// rename returns the edit renaming the symbol at the position
+// (see interp.Rename), or an error if it can't be renamed.
+func (s *server) rename(params renameParams) (interface{}, *responseError) {
+
+	doc := s.documents[params.TextDocument.URI]
+	if doc == nil {
+		return nil, &responseError{requestFailed, "unknown document"}
+	}
+	line, column := doc.column(params.Position)
+	renamed, err := interp.Rename(doc.text, interp.Position{Line: line, Column: column},
+		params.NewName)
+	if err != nil {
+		return nil, &responseError{requestFailed, err.Error()}
+	}
+	return workspaceEdit{map[string][]textEdit{
+		params.TextDocument.URI: {doc.replaceAll(renamed)}}}, nil
+}
+
+// sameToken checks two tokens from different parses of the
+// same text are the same.
+func sameToken(a, b *lang.Token) bool {
+
+	return a.Line == b.Line && a.Column == b.Column
+}
+
+// signature returns the name and the parameters of a function.
+func signature(fun *lang.FunDeclStmt) string {
+
+	params := make([]string, len(fun.Params))
+	for i, param := range fun.Params {
+		params[i] = param.Lexeme
+	}
+	return fun.Name.Lexeme + "(" + strings.Join(params, ", ") + ")"
+}
+
+// document is an open document. It is parsed incrementally, reusing
+// the statements of its previous version, and its symbol table is
+// computed when needed.
+type document struct {
+	text   string
+	lines  []string
+	parsed *lang.Document
+	table  []*interp.Symbol
+	// analyzed is set once table is computed
+	// (it stays nil for the scripts with syntax errors).
+	analyzed bool
+}
+
+// newDocument creates a document from its text and its previous
+// version (nil when the document is opened).
+func newDocument(text string, previous *document) *document {
+
+	var parsed *lang.Document
+	if previous != nil {
+		parsed = previous.parsed.Update(text)
+	} else {
+		parsed = lang.ParseDocument(text)
+	}
+	return &document{text: text, lines: strings.Split(text, "\n"), parsed: parsed}
+}
+
+// symbols returns the symbol table of the document.
+func (d *document) symbols() []*interp.Symbol {
+
+	if !d.analyzed {
+		d.table = interp.Symbols(d.text)
+		d.analyzed = true
+	}
+	return d.table
+}
+
+// replaceAll returns the edit replacing the whole document
+// by the text.
+func (d *document) replaceAll(text string) textEdit {
+
+	last := len(d.lines) - 1
+	return textEdit{
+		textRange{position{0, 0}, position{last, utf16Length(d.lines[last])}}, text}
+}
+
+// statements returns the AST of the document,
+// nil if it has syntax errors.
+func (d *document) statements() []lang.Stmt {
+
+	if d.parsed.HadError() {
+		return nil
+	}
+	return d.parsed.Statements()
+}
+
+// position converts a line and a column of the scanner (counted
+// in runes from 1) to a position of the protocol (counted from 0,
+// in UTF-16 code units).
+func (d *document) position(line, column int) position {
+
+	if line < 1 || line > len(d.lines) {
+		return position{line - 1, 0}
+	}
+	runes := []rune(d.lines[line-1])
+	if column < 1 {
+		column = 1
+	}
+	if column-1 > len(runes) {
+		column = len(runes) + 1
+	}
+	return position{line - 1, len(utf16.Encode(runes[:column-1]))}
+}
+
+// column converts a position of the protocol to a line
+// and a column of the scanner.
+func (d *document) column(pos position) (int, int) {
+
+	if pos.Line < 0 || pos.Line >= len(d.lines) {
+		return pos.Line + 1, pos.Character + 1
+	}
+	units := 0
+	column := 1
+	for _, r := range d.lines[pos.Line] {
+		if units >= pos.Character {
+			break
+		}
+		units += len(utf16.Encode([]rune{r}))
+		column++
+	}
+	return pos.Line + 1, column
+}
+
+// span returns the range of the diagnostic located at the column of
+// the line (the whole line if the column is unknown).
+func (d *document) span(line, column, length int) textRange {
+
+	if column <= 0 {
+		start := d.position(line, 1)
+		end := start
+		if line >= 1 && line <= len(d.lines) {
+			end.Character = utf16Length(strings.TrimRight(d.lines[line-1], "\r"))
+		}
+		return textRange{start, end}
+	}
+	return textRange{d.position(line, column), d.position(line, column+length)}
+}
+
+// tokenRange returns the range of a token.
+func (d *document) tokenRange(token *lang.Token) textRange {
+
+	return d.span(token.Line, token.Column, utf8.RuneCountInString(token.Lexeme))
+}
+
+// utf16Length returns the length of a string in UTF-16 code units.
+func utf16Length(s string) int {
+
+	return len(utf16.Encode([]rune(s)))
+}
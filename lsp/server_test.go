@@ -0,0 +1,214 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// session runs a server with the messages of the client, in order,
+// followed by shutdown and exit. It returns the messages sent by
+// the server.
+func session(t *testing.T, messages ...string) []map[string]interface{} {
+
+	t.Helper()
+	in := &bytes.Buffer{}
+	messages = append(messages, `{"jsonrpc":"2.0","id":999,"method":"shutdown"}`,
+		`{"jsonrpc":"2.0","method":"exit"}`)
+	for _, msg := range messages {
+		fmt.Fprintf(in, "Content-Length: %d\r\n\r\n%s", len(msg), msg)
+	}
+	out := &bytes.Buffer{}
+	if err := Serve(in, out); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	var sent []map[string]interface{}
+	reader := bufio.NewReader(out)
+	for {
+		body, err := readMessage(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var msg map[string]interface{}
+		if err := json.Unmarshal(body, &msg); err != nil {
+			t.Fatal(err)
+		}
+		sent = append(sent, msg)
+	}
+	// the last message answers shutdown.
+	return sent[:len(sent)-1]
+}
+
+// open returns the didOpen notification of the test document.
+func open(text string) string {
+
+	body, _ := json.Marshal(text)
+	return `{"jsonrpc":"2.0","method":"textDocument/didOpen","params":` +
+		`{"textDocument":{"uri":"file:///test.lox","languageId":"lox","version":1,"text":` +
+		string(body) + `}}}`
+}
+
+// request returns a request about the test document.
+func request(id int, method, params string) string {
+
+	return fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":%q,"params":`+
+		`{"textDocument":{"uri":"file:///test.lox"}%s}}`, id, method, params)
+}
+
+// toJSON returns the JSON encoding of a decoded value,
+// which is compact and sorts the members.
+func toJSON(value interface{}) string {
+
+	body, _ := json.Marshal(value)
+	return string(body)
+}
+
+func TestInitialize(t *testing.T) {
+
+	sent := session(t, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`)
+	capabilities := sent[0]["result"].(map[string]interface{})["capabilities"]
+	expected := `{"definitionProvider":true,"documentFormattingProvider":true,` +
+		`"documentSymbolProvider":true,"hoverProvider":true,"renameProvider":true,` +
+		`"textDocumentSync":1}`
+	if toJSON(capabilities) != expected {
+		t.Errorf("expected %s, got %s", expected, toJSON(capabilities))
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+
+	sent := session(t, open("var a = 1;\nprint \"𝛑\" + ;"))
+	expected := `{"diagnostics":[` +
+		`{"message":"Expect expression.","range":{"end":{"character":14,"line":1},` +
+		`"start":{"character":13,"line":1}},"severity":1,"source":"glox"}],` +
+		`"uri":"file:///test.lox"}`
+	if sent[0]["method"] != "textDocument/publishDiagnostics" || toJSON(sent[0]["params"]) != expected {
+		t.Errorf("expected %s, got %v", expected, toJSON(sent[0]))
+	}
+
+	sent = session(t, open("{ var b; }"))
+	expected = `{"diagnostics":[` +
+		`{"message":"Local variable 'b' is never used.","range":{"end":{"character":7,"line":0},` +
+		`"start":{"character":6,"line":0}},"severity":2,"source":"glox"}],` +
+		`"uri":"file:///test.lox"}`
+	if toJSON(sent[0]["params"]) != expected {
+		t.Errorf("expected %s, got %v", expected, toJSON(sent[0]))
+	}
+}
+
+// script is the document used to test the navigation.
+const script = `/// Adds two numbers.
+fun add(a, b) {
+    return a + b;
+}
+class Point {}
+var pi = "𝛑"; print pi;
+print add(pi, 1) + Point;
+`
+
+func TestNavigation(t *testing.T) {
+
+	sent := session(t, open(script),
+		// the reference to add.
+		request(1, "textDocument/definition", `,"position":{"line":6,"character":7}`),
+		// pi after 𝛑 which counts as 2 UTF-16 code units.
+		request(2, "textDocument/definition", `,"position":{"line":5,"character":21}`),
+		request(3, "textDocument/definition", `,"position":{"line":6,"character":5}`),
+		request(4, "textDocument/hover", `,"position":{"line":6,"character":6}`),
+		request(5, "textDocument/hover", `,"position":{"line":2,"character":11}`),
+		request(6, "textDocument/documentSymbol", ``))
+
+	tests := []struct {
+		result   interface{}
+		expected string
+	}{
+		{sent[1]["result"], `{"range":{"end":{"character":7,"line":1},` +
+			`"start":{"character":4,"line":1}},"uri":"file:///test.lox"}`},
+		{sent[2]["result"], `{"range":{"end":{"character":6,"line":5},` +
+			`"start":{"character":4,"line":5}},"uri":"file:///test.lox"}`},
+		{sent[3]["result"], `null`},
+		{sent[4]["result"], `{"contents":{"kind":"markdown",` +
+			`"value":"` + "```lox\\nfun add(a, b)\\n```\\n\\nAdds two numbers." + `"},` +
+			`"range":{"end":{"character":9,"line":6},"start":{"character":6,"line":6}}}`},
+		{sent[5]["result"], `{"contents":{"kind":"markdown",` +
+			`"value":"` + "```lox\\nparameter a\\n```" + `"},` +
+			`"range":{"end":{"character":12,"line":2},"start":{"character":11,"line":2}}}`},
+		{sent[6]["result"], `[` +
+			`{"kind":12,"location":{"range":{"end":{"character":7,"line":1},"start":{"character":4,"line":1}},"uri":"file:///test.lox"},"name":"add"},` +
+			`{"kind":5,"location":{"range":{"end":{"character":11,"line":4},"start":{"character":6,"line":4}},"uri":"file:///test.lox"},"name":"Point"},` +
+			`{"kind":13,"location":{"range":{"end":{"character":6,"line":5},"start":{"character":4,"line":5}},"uri":"file:///test.lox"},"name":"pi"}]`},
+	}
+	for n, test := range tests {
+		if toJSON(test.result) != test.expected {
+			t.Errorf("request %d: expected %s, got %s", n+1, test.expected, toJSON(test.result))
+		}
+	}
+}
+
+func TestFormatting(t *testing.T) {
+
+	sent := session(t, open("var a=1;\nprint a;"),
+		request(1, "textDocument/formatting", `,"options":{"tabSize":4,"insertSpaces":true}`),
+		`{"jsonrpc":"2.0","method":"textDocument/didChange","params":`+
+			`{"textDocument":{"uri":"file:///test.lox","version":2},"contentChanges":[{"text":"var a = 1;\n"}]}}`,
+		request(2, "textDocument/formatting", ``),
+		request(3, "textDocument/formatting", `,"textDocument":{"uri":"file:///unknown.lox"}`))
+
+	expected := `[{"newText":"var a = 1;\nprint a;\n",` +
+		`"range":{"end":{"character":8,"line":1},"start":{"character":0,"line":0}}}]`
+	if toJSON(sent[1]["result"]) != expected {
+		t.Errorf("expected %s, got %s", expected, toJSON(sent[1]["result"]))
+	}
+	if toJSON(sent[3]["result"]) != `[]` {
+		t.Errorf("expected no edits, got %s", toJSON(sent[3]["result"]))
+	}
+	if toJSON(sent[4]["result"]) != `null` {
+		t.Errorf("expected null, got %s", toJSON(sent[4]["result"]))
+	}
+}
+
+func TestRename(t *testing.T) {
+
+	sent := session(t, open("var a = 1;\nprint a;"),
+		request(1, "textDocument/rename", `,"position":{"line":1,"character":6},"newName":"b"`),
+		request(2, "textDocument/rename", `,"position":{"line":1,"character":6},"newName":"clock"`))
+
+	expected := `{"changes":{"file:///test.lox":[{"newText":"var b = 1;\nprint b;",` +
+		`"range":{"end":{"character":8,"line":1},"start":{"character":0,"line":0}}}]}}`
+	if toJSON(sent[1]["result"]) != expected {
+		t.Errorf("expected %s, got %s", expected, toJSON(sent[1]["result"]))
+	}
+	expected = `{"code":-32803,"message":"'clock' would shadow the native function"}`
+	if toJSON(sent[2]["error"]) != expected {
+		t.Errorf("expected %s, got %s", expected, toJSON(sent[2]["error"]))
+	}
+}
+
+func TestErrors(t *testing.T) {
+
+	sent := session(t, `{"jsonrpc":"2.0","id":1,"method":"unknown"}`,
+		`{"jsonrpc":"2.0","method":"$/unknown"}`,
+		`{"jsonrpc":"2.0","id":2,"method":"textDocument/hover","params":[]}`,
+		`{]`)
+	codes := []float64{}
+	for _, msg := range sent {
+		codes = append(codes, msg["error"].(map[string]interface{})["code"].(float64))
+	}
+	if !reflect.DeepEqual(codes, []float64{methodNotFound, invalidParams, parseError}) {
+		t.Errorf("unexpected errors %v", codes)
+	}
+
+	in := strings.NewReader("Content-Length: 33\r\n\r\n" + `{"jsonrpc":"2.0","method":"exit"}`)
+	if err := Serve(in, &bytes.Buffer{}); err != ErrNoShutdown {
+		t.Errorf("expected ErrNoShutdown, got %v", err)
+	}
+}
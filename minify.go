@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/rmonnet/glox/interp"
+)
+
+// runMinify runs the "glox minify" subcommand. It writes the
+// scripts on stdout without comments and whitespace, on a single
+// line each, and with the local variables renamed to short names
+// with -locals.
+func runMinify(args []string) {
+
+	flags := flag.NewFlagSet("minify", flag.ExitOnError)
+	locals := flags.Bool("locals", false, "shorten the names of the local variables and parameters")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		fmt.Println("Usage glox minify [-locals] script...")
+		os.Exit(exUsage)
+	}
+
+	for _, filename := range flags.Args() {
+		source, err := ioutil.ReadFile(filename)
+		if err != nil {
+			fmt.Println("unable to read ", filename)
+			os.Exit(exDataErr)
+		}
+		minified, err := interp.Minify(string(source), *locals)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", filename, err)
+			os.Exit(exDataErr)
+		}
+		fmt.Println(minified)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// stopProfiling stops the profiling of the interpreter started
+// by startProfiling and writes the profiles. It must be called
+// before exiting since os.Exit doesn't run the deferred calls.
+var stopProfiling = func() {}
+
+// startProfiling starts profiling the interpreter itself (not
+// the lox script) with pprof. The CPU profile is written to
+// cpuFile and the memory profile (including the allocations)
+// to memFile when profiling stops, if they are not empty.
+func startProfiling(cpuFile, memFile string) {
+
+	var cpu *os.File
+	if cpuFile != "" {
+		var err error
+		cpu, err = os.Create(cpuFile)
+		if err != nil {
+			fmt.Println("unable to create ", cpuFile)
+			os.Exit(exCantCreat)
+		}
+		if err := pprof.StartCPUProfile(cpu); err != nil {
+			fmt.Println("unable to start the CPU profile ", err)
+			os.Exit(exCantCreat)
+		}
+	}
+
+	stopProfiling = func() {
+		stopProfiling = func() {}
+		if cpu != nil {
+			pprof.StopCPUProfile()
+			cpu.Close()
+		}
+		if memFile != "" {
+			mem, err := os.Create(memFile)
+			if err != nil {
+				fmt.Println("unable to create ", memFile)
+				os.Exit(exCantCreat)
+			}
+			// collect the garbage so the profile shows the
+			// memory still in use.
+			runtime.GC()
+			if err := pprof.WriteHeapProfile(mem); err != nil {
+				fmt.Println("unable to write the memory profile ", err)
+			}
+			mem.Close()
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package main
+
+import "errors"
+
+// makeRaw is not supported on this platform, the REPL reads
+// the lines without editing.
+func makeRaw(fd uintptr) (func(), error) {
+
+	return nil, errors.New("raw mode not supported")
+}
@@ -0,0 +1,42 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// makeRaw puts the terminal in raw mode: the keys are read one
+// at a time without echo or line editing by the terminal.
+// It returns a function restoring the previous mode.
+func makeRaw(fd uintptr) (func(), error) {
+
+	var saved syscall.Termios
+	if err := ioctlTermios(fd, ioctlGetTermios, &saved); err != nil {
+		return nil, err
+	}
+
+	raw := saved
+	raw.Iflag &^= syscall.ICRNL | syscall.IXON | syscall.BRKINT | syscall.INPCK | syscall.ISTRIP
+	raw.Lflag &^= syscall.ECHO | syscall.ICANON | syscall.IEXTEN | syscall.ISIG
+	raw.Cflag |= syscall.CS8
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := ioctlTermios(fd, ioctlSetTermios, &raw); err != nil {
+		return nil, err
+	}
+	return func() { ioctlTermios(fd, ioctlSetTermios, &saved) }, nil
+}
+
+// ioctlTermios gets or sets the terminal attributes.
+func ioctlTermios(fd uintptr, request uintptr, termios *syscall.Termios) error {
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request,
+		uintptr(unsafe.Pointer(termios)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
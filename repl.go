@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/rmonnet/glox/interp"
+	"github.com/rmonnet/glox/lang"
+)
+
+// runPrompt runs the lox interpreter interactively.
+// The lines can be edited and recalled from the history when
+// the input is a terminal, their syntax is highlighted unless
+// NO_COLOR is set. The history is kept in historyFile (if not empty)
+// across sessions. Ctrl-C interrupts the running statements.
+// The lines starting with ':' are REPL commands, see runCommand.
+// The functions and the classes declared again are updated in place,
+// the values and the instances referencing them use the new code.
+func runPrompt(interp *interp.Interp, dump string, historyFile string) {
+
+	interp.SetHotRedefinition(true)
+	s := &session{interp: interp, dump: dump}
+	reader := newLineReader(os.Stdin, os.Stdout, func(prefix string, member bool) []string {
+		if member {
+			return interp.MemberNames()
+		}
+		return interp.GlobalNames()
+	}, isTerminal(os.Stdout) && os.Getenv("NO_COLOR") == "")
+	if historyFile != "" {
+		reader.useHistoryFile(historyFile)
+	}
+	var input []string
+	for {
+		prompt := "> "
+		if len(input) > 0 {
+			prompt = "... "
+		}
+		line, err := reader.readLine(prompt)
+		if err == errInterrupted {
+			input = nil
+			continue
+		}
+		if err == io.EOF {
+			fmt.Println("")
+			break
+		}
+		if err != nil {
+			fmt.Println("error while reading ", err)
+			os.Exit(exDataErr)
+		}
+
+		reader.addHistory(line)
+		if len(input) == 0 && strings.HasPrefix(line, ":") {
+			s.runCommand(line)
+			continue
+		}
+		if len(input) > 0 && strings.TrimSpace(line) == "" {
+			s.execute(strings.Join(input, "\n"))
+			input = nil
+			continue
+		}
+		input = append(input, line)
+		script := strings.Join(input, "\n")
+		if isComplete(script) {
+			s.execute(script)
+			input = nil
+		}
+	}
+
+	interp.WriteProfile(os.Stderr)
+	interp.WritePhaseStats(os.Stderr)
+
+}
+
+// defaultHistoryFile returns the file keeping the history of the
+// REPL by default, ~/.glox_history or the GLOX_HISTORY environment
+// variable if it is set.
+func defaultHistoryFile() string {
+
+	if filename, ok := os.LookupEnv("GLOX_HISTORY"); ok {
+		return filename
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".glox_history")
+}
+
+// session is the state of a REPL session.
+type session struct {
+	interp *interp.Interp
+	dump   string
+	// executed lists the inputs which ran without errors.
+	executed []string
+	// last is the last input, shown by :ast.
+	last string
+}
+
+// execute executes the input entered in the REPL.
+// Ctrl-C cancels the execution, reporting where it stopped,
+// instead of killing the REPL.
+func (s *session) execute(script string) {
+
+	parent := s.interp.Context()
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	go func() {
+		select {
+		case <-interrupts:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	s.last = script
+	s.interp.SetContext(ctx)
+	defer s.interp.SetContext(parent)
+	execute(s.interp, script, s.dump)
+	if !s.interp.LastRunFailed() && strings.TrimSpace(script) != "" {
+		s.executed = append(s.executed, script)
+	}
+}
+
+// runCommand runs a REPL command:
+//   - ":save file" writes the inputs which ran without errors
+//     to the file, turning the session into a script
+//   - ":type expr" prints the type of the value of the expression
+//   - ":ast [code]" prints the AST of the code, or of the last
+//     input if no code is given, without running it
+func (s *session) runCommand(line string) {
+
+	fields := strings.Fields(line)
+	rest := strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+	switch {
+	case fields[0] == ":save" && len(fields) == 2:
+		script := strings.Join(s.executed, "\n") + "\n"
+		if err := ioutil.WriteFile(fields[1], []byte(script), 0644); err != nil {
+			fmt.Println("unable to write ", fields[1])
+		}
+	case fields[0] == ":type" && rest != "":
+		name, err := s.interp.TypeOf(rest)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return
+		}
+		fmt.Println(name)
+	case fields[0] == ":ast":
+		if rest == "" {
+			rest = s.last
+		}
+		execute(s.interp, rest, "sexpr")
+	default:
+		fmt.Println("Unknown command", line)
+		fmt.Println("Commands: :save file, :type expr, :ast [code]")
+	}
+}
+
+// isComplete checks if the input entered in the REPL can run or
+// if it needs more lines: a string is not terminated, a brace or a
+// parenthesis is not closed, or the parser expects more tokens.
+func isComplete(script string) bool {
+
+	scanner := &lang.Scanner{}
+	scanner.RedirectErrors(ioutil.Discard)
+	tokens := scanner.ScanTokens(script)
+	if scanner.Incomplete() {
+		return false
+	}
+
+	depth := 0
+	for _, token := range tokens {
+		switch token.Type {
+		case lang.LeftBraceToken, lang.LeftParenToken:
+			depth++
+		case lang.RightBraceToken, lang.RightParenToken:
+			depth--
+		}
+	}
+	if depth > 0 {
+		return false
+	}
+
+	parser := &lang.Parser{}
+	parser.RedirectErrors(ioutil.Discard)
+	parser.Parse(tokens)
+	return !parser.Incomplete()
+}
@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestIsComplete(t *testing.T) {
+
+	tests := []struct {
+		script   string
+		complete bool
+	}{
+		// complete lines.
+		{`print 1;`, true},
+		{`var a = "one";`, true},
+		{`fun f() { return 1; }`, true},
+		{``, true},
+		// the errors which more input can't fix run, to be reported.
+		{`print ;`, true},
+		{`print 1 +;`, true},
+		{`}`, true},
+		{`print "a" @;`, true},
+		// unterminated strings.
+		{`print "abc`, false},
+		{"print \"first line\nsecond", false},
+		// open blocks and parentheses.
+		{`fun f() {`, false},
+		{"class A {\n  m() {\n    print 1;\n  }", false},
+		{`if (true) {`, false},
+		{`print (1 +`, false},
+		// the statement is not finished.
+		{`print 1`, false},
+		{`var a =`, false},
+		{`if (a)`, false},
+	}
+
+	for _, test := range tests {
+		if got := isComplete(test.script); got != test.complete {
+			t.Errorf("%q: expected complete %v but got %v", test.script, test.complete, got)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/rmonnet/glox/evalserver"
+)
+
+// runServe runs the "glox serve" subcommand, an HTTP server running
+// the scripts posted to /run with limited resources and returning
+// their output and diagnostics as JSON.
+func runServe(args []string) {
+
+	config := evalserver.DefaultConfig
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", ":8080", "address to listen on")
+	flags.IntVar(&config.MaxSteps, "maxSteps", config.MaxSteps,
+		"maximum number of statements executed by a script (0 for no limit)")
+	flags.IntVar(&config.MaxMemory, "maxMemory", config.MaxMemory,
+		"approximate allocation budget of a script in bytes (0 for no limit)")
+	flags.IntVar(&config.MaxCallDepth, "maxCallDepth", config.MaxCallDepth,
+		"maximum number of nested calls (0 for no limit)")
+	flags.IntVar(&config.MaxStringLength, "maxStringLength", config.MaxStringLength,
+		"maximum length in bytes of the strings built by a script (0 for no limit)")
+	flags.IntVar(&config.MaxFields, "maxFields", config.MaxFields,
+		"maximum number of fields of an instance (0 for no limit)")
+	flags.DurationVar(&config.Timeout, "timeout", config.Timeout,
+		"maximum duration of a script (0 for no limit)")
+	flags.Int64Var(&config.MaxScriptSize, "maxScriptSize", config.MaxScriptSize,
+		"maximum size of a script in bytes (0 for no limit)")
+	flags.IntVar(&config.MaxOutput, "maxOutput", config.MaxOutput,
+		"maximum size of the output of a script in bytes (0 for no limit)")
+	backend := flags.String("backend", "tree",
+		"execute the scripts with the tree-walker (tree) or the bytecode VM (vm)")
+	flags.Parse(args)
+
+	var validBackend bool
+	config.Backend, validBackend = parseBackend(*backend)
+	if flags.NArg() != 0 || !validBackend {
+		fmt.Println("Usage glox serve [-addr address] [options]")
+		os.Exit(exUsage)
+	}
+
+	log.Printf("glox serve listening on %s", *addr)
+	if err := http.ListenAndServe(*addr, evalserver.Handler(config)); err != nil {
+		log.Print(err)
+		os.Exit(exSwErr)
+	}
+}
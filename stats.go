@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/rmonnet/glox/interp"
+	"github.com/rmonnet/glox/lang"
+)
+
+// runStats runs the "glox stats" subcommand. It reports the
+// number of nodes of each type, the maximum nesting depth,
+// the size of the functions and the fan-out of the classes
+// of the scripts.
+func runStats(args []string) {
+
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		fmt.Println("Usage glox stats script...")
+		os.Exit(exUsage)
+	}
+
+	for n, filename := range flags.Args() {
+		script, err := ioutil.ReadFile(filename)
+		if err != nil {
+			fmt.Println("unable to read ", filename)
+			os.Exit(exDataErr)
+		}
+		statements, ok := interp.New(os.Stdout, os.Stderr).Parse(string(script))
+		if !ok {
+			os.Exit(exDataErr)
+		}
+		if n > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%s:\n", filename)
+		lang.WriteStats(os.Stdout, lang.ComputeStats(statements))
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/rmonnet/glox/conformance"
+	"github.com/rmonnet/glox/interp"
+)
+
+// exTestFailed is the exit code of "glox test" when a test fails.
+const exTestFailed = 1
+
+// runTests runs the "glox test" subcommand. It runs the lox scripts
+// passed as arguments (or the *.lox files in the directories passed
+// as arguments) and compares what they print with the comments
+// in the script:
+//   - "// expect: value" for a line printed on stdout
+//   - "// expect runtime error: message" for a runtime error
+//     on this line
+//   - "// Error at 'x': message" for a compile error on this line,
+//     or "// [line 3] Error at 'x': message" for another line
+//
+// (see the conformance package). It reports the result of each file
+// and fails if any test failed.
+func runTests(args []string) {
+
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	verbose := flags.Bool("v", false, "report the passing tests too")
+	backendName := flags.String("backend", "tree",
+		"run the tests with the tree-walker (tree) or the bytecode VM (vm)")
+	flags.Parse(args)
+	backend, validBackend := parseBackend(*backendName)
+
+	if flags.NArg() == 0 || !validBackend {
+		fmt.Println("Usage glox test [-v] [-backend tree|vm] (script | directory)...")
+		os.Exit(exUsage)
+	}
+
+	var filenames []string
+	for _, arg := range flags.Args() {
+		info, err := os.Stat(arg)
+		if err != nil {
+			fmt.Println("unable to read ", arg)
+			os.Exit(exDataErr)
+		}
+		if !info.IsDir() {
+			filenames = append(filenames, arg)
+			continue
+		}
+		err = filepath.Walk(arg, func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() && filepath.Ext(path) == ".lox" {
+				filenames = append(filenames, path)
+			}
+			return err
+		})
+		if err != nil {
+			fmt.Println("unable to read ", arg)
+			os.Exit(exDataErr)
+		}
+	}
+
+	failed := 0
+	for _, filename := range filenames {
+		script, err := ioutil.ReadFile(filename)
+		if err != nil {
+			fmt.Println("unable to read ", filename)
+			os.Exit(exDataErr)
+		}
+		failures := runTest(string(script), backend)
+		if len(failures) > 0 {
+			failed++
+			fmt.Printf("FAIL %s\n", filename)
+			for _, failure := range failures {
+				fmt.Printf("    %s\n", failure)
+			}
+		} else if *verbose {
+			fmt.Printf("PASS %s\n", filename)
+		}
+	}
+
+	fmt.Printf("%d passed, %d failed\n", len(filenames)-failed, failed)
+	if failed > 0 {
+		os.Exit(exTestFailed)
+	}
+}
+
+// runTest runs a test script in a new interpreter with the backend
+// and returns the differences with its expectations.
+func runTest(script string, backend interp.Backend) []string {
+
+	out := &bytes.Buffer{}
+	errOut := &bytes.Buffer{}
+	lox := interp.New(out, errOut)
+	lox.SetBackend(backend)
+	lox.Run(script, false)
+	return conformance.Compare(script, out.String(), errOut.String())
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/rmonnet/glox/interp"
+	"github.com/rmonnet/glox/transpile"
+)
+
+// runTranspile runs the "glox transpile" subcommand. It translates
+// a script to the source code of the target language (Go or
+// JavaScript), written to stdout or to the file given with -o.
+func runTranspile(args []string) {
+
+	flags := flag.NewFlagSet("transpile", flag.ExitOnError)
+	target := flags.String("target", "go", "language of the generated code (go or js)")
+	output := flags.String("o", "", "write the generated code to the file instead of stdout")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 || (*target != "go" && *target != "js") {
+		fmt.Println("Usage glox transpile [-target=go|js] [-o file] script")
+		os.Exit(exUsage)
+	}
+
+	filename := flags.Arg(0)
+	script, err := ioutil.ReadFile(filename)
+	if err != nil {
+		fmt.Println("unable to read ", filename)
+		os.Exit(exDataErr)
+	}
+	lox := interp.New(os.Stdout, os.Stderr)
+	lox.SetScriptName(filename)
+	statements, ok := lox.Resolve(string(script))
+	if !ok {
+		os.Exit(exDataErr)
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		if out, err = os.Create(*output); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to create %s: %v\n", *output, err)
+			os.Exit(exCantCreat)
+		}
+		defer out.Close()
+	}
+	write := transpile.Go
+	if *target == "js" {
+		write = transpile.JS
+	}
+	if err := write(out, filename, statements); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to transpile %s: %v\n", filename, err)
+		os.Exit(exSwErr)
+	}
+}
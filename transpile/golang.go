@@ -0,0 +1,439 @@
+// Package transpile translates lox scripts to the source code of
+// other languages. The scripts must be resolved first, the bindings
+// recorded in the AST tell the local variables from the globals.
+package transpile
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"strconv"
+
+	"github.com/rmonnet/glox/lang"
+)
+
+// Go writes a standalone Go program implementing the script to out.
+// The program uses the github.com/rmonnet/glox/loxrt package for
+// the lox values and operators. The local variables and functions
+// become Go variables and closures while the globals are kept in
+// package variables, since lox allows a function to reference
+// a global declared after it.
+// The program behaves like the interpreter with the default options,
+// except the runtime errors don't suggest names.
+func Go(out io.Writer, name string, statements []lang.Stmt) error {
+
+	g := &goWriter{}
+	fmt.Fprintf(&g.out, "// Code generated by glox transpile from %s. DO NOT EDIT.\n\n", name)
+	g.out.WriteString("package main\n\n")
+	g.out.WriteString("import \"github.com/rmonnet/glox/loxrt\"\n\n")
+	g.out.WriteString("func main() {\nloxrt.Run(func() {\n")
+	for _, stmt := range statements {
+		if err := g.statement(stmt); err != nil {
+			return err
+		}
+	}
+	g.out.WriteString("})\n}\n")
+
+	if len(g.globals) > 0 {
+		g.out.WriteString("\nvar (\n")
+		for _, name := range g.globals {
+			fmt.Fprintf(&g.out, "g_%s = loxrt.NewGlobal(%q)\n", name, name)
+		}
+		g.out.WriteString(")\n")
+	}
+
+	source, err := format.Source(g.out.Bytes())
+	if err != nil {
+		return fmt.Errorf("invalid Go code generated: %v", err)
+	}
+	_, err = out.Write(source)
+	return err
+}
+
+// goWriter accumulates the Go code of a script.
+type goWriter struct {
+	out bytes.Buffer
+	// depth is the number of scopes enclosing the current
+	// statement, the declarations at depth 0 are globals.
+	depth int
+	// initializer is true in an init method,
+	// which always returns the instance.
+	initializer bool
+	// classes holds the number of the enclosing classes,
+	// naming their Go variables (class_1, super_1, ...).
+	classes []int
+	count   int
+	// globals lists the global variables referenced by the script,
+	// in order of appearance.
+	globals []string
+	seen    map[string]bool
+}
+
+// statement writes the Go code of a statement.
+func (g *goWriter) statement(stmt lang.Stmt) error {
+
+	switch s := stmt.(type) {
+	case *lang.BlockStmt:
+		g.out.WriteString("{\n")
+		g.depth++
+		for _, stmt := range s.Statements {
+			if err := g.statement(stmt); err != nil {
+				return err
+			}
+		}
+		g.depth--
+		g.out.WriteString("}\n")
+	case *lang.ClassDeclStmt:
+		return g.class(s)
+	case *lang.ExprStmt:
+		// an assignment of a local variable is the only
+		// expression which is not a function call.
+		if assign, ok := s.Expression.(*lang.AssignExpr); ok && isLocal(assign.Binding) {
+			value, err := g.expression(assign.Value)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&g.out, "%s = %s\n", local(assign.Name), value)
+			return nil
+		}
+		expr, err := g.expression(s.Expression)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&g.out, "_ = %s\n", expr)
+	case *lang.FunDeclStmt:
+		if g.depth == 0 {
+			fmt.Fprintf(&g.out, "%s.Define(", g.global(s.Name))
+			if err := g.function(s, false); err != nil {
+				return err
+			}
+			g.out.WriteString(")\n")
+			return nil
+		}
+		// the variable is declared first so the function can call itself.
+		fmt.Fprintf(&g.out, "var %s loxrt.Value\n%[1]s = ", local(s.Name))
+		if err := g.function(s, false); err != nil {
+			return err
+		}
+		fmt.Fprintf(&g.out, "\n_ = %s\n", local(s.Name))
+	case *lang.IfStmt:
+		condition, err := g.expression(s.Condition)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&g.out, "if loxrt.Truthy(%s) {\n", condition)
+		if err := g.statement(s.ThenBranch); err != nil {
+			return err
+		}
+		if s.ElseBranch != nil {
+			g.out.WriteString("} else {\n")
+			if err := g.statement(s.ElseBranch); err != nil {
+				return err
+			}
+		}
+		g.out.WriteString("}\n")
+	case *lang.BreakStmt:
+		g.out.WriteString("break\n")
+	case *lang.PrintStmt:
+		expr, err := g.expression(s.Expression)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&g.out, "loxrt.Print(%s)\n", expr)
+	case *lang.ReturnStmt:
+		value := "nil"
+		if g.initializer {
+			value = "this"
+		} else if s.Value != nil {
+			var err error
+			if value, err = g.expression(s.Value); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(&g.out, "return %s\n", value)
+	case *lang.VarDeclStmt:
+		value := "nil"
+		if s.Initializer != nil {
+			var err error
+			if value, err = g.expression(s.Initializer); err != nil {
+				return err
+			}
+		}
+		if g.depth == 0 {
+			fmt.Fprintf(&g.out, "%s.Define(%s)\n", g.global(s.Name), value)
+		} else {
+			fmt.Fprintf(&g.out, "var %s loxrt.Value = %s\n_ = %[1]s\n", local(s.Name), value)
+		}
+	case *lang.WhileStmt:
+		condition, err := g.expression(s.Condition)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&g.out, "for loxrt.Truthy(%s) {\n", condition)
+		if err := g.statement(s.Body); err != nil {
+			return err
+		}
+		g.out.WriteString("}\n")
+	default:
+		return fmt.Errorf("unexpected statement %v", stmt)
+	}
+	return nil
+}
+
+// function writes the function literal of a function or a method.
+// The parameters are copied to local variables since they may be
+// captured by closures.
+func (g *goWriter) function(decl *lang.FunDeclStmt, method bool) error {
+
+	if method {
+		fmt.Fprintf(&g.out, "%q, %d, func(this *loxrt.Instance, args []loxrt.Value) loxrt.Value {\n",
+			decl.Name.Lexeme, len(decl.Params))
+	} else {
+		fmt.Fprintf(&g.out, "loxrt.NewFunction(%q, %d, func(args []loxrt.Value) loxrt.Value {\n",
+			decl.Name.Lexeme, len(decl.Params))
+	}
+	for n, param := range decl.Params {
+		fmt.Fprintf(&g.out, "%s := args[%d]\n_ = %[1]s\n", local(param), n)
+	}
+
+	enclosing := g.initializer
+	g.initializer = method && decl.Name.Lexeme == "init"
+	g.depth++
+	for _, stmt := range decl.Body {
+		if err := g.statement(stmt); err != nil {
+			return err
+		}
+	}
+	g.depth--
+	if g.initializer {
+		g.out.WriteString("return this\n")
+	} else {
+		g.out.WriteString("return nil\n")
+	}
+	g.initializer = enclosing
+
+	if method {
+		g.out.WriteString("}")
+	} else {
+		g.out.WriteString("})")
+	}
+	return nil
+}
+
+// class writes the Go code of a class declaration. The class is
+// built in its own block, where the superclass is kept for the
+// super expressions of the methods.
+func (g *goWriter) class(decl *lang.ClassDeclStmt) error {
+
+	g.count++
+	n := g.count
+	if g.depth > 0 {
+		fmt.Fprintf(&g.out, "var %s loxrt.Value\n_ = %[1]s\n", local(decl.Name))
+	}
+	g.out.WriteString("{\n")
+	superclass := "nil"
+	if decl.Superclass != nil {
+		value, err := g.expression(decl.Superclass)
+		if err != nil {
+			return err
+		}
+		superclass = fmt.Sprintf("super_%d", n)
+		fmt.Fprintf(&g.out, "%s := loxrt.Superclass(%s, %d)\n_ = %[1]s\n",
+			superclass, value, decl.Superclass.Name.Line)
+	}
+	if g.depth == 0 {
+		fmt.Fprintf(&g.out, "%s.Define(nil)\n", g.global(decl.Name))
+	}
+	fmt.Fprintf(&g.out, "class_%d := loxrt.NewClass(%q, %s)\n", n, decl.Name.Lexeme, superclass)
+
+	g.classes = append(g.classes, n)
+	for _, method := range decl.Methods {
+		fmt.Fprintf(&g.out, "class_%d.Method(", n)
+		if err := g.function(method, true); err != nil {
+			return err
+		}
+		g.out.WriteString(")\n")
+	}
+	g.classes = g.classes[:len(g.classes)-1]
+
+	if g.depth == 0 {
+		fmt.Fprintf(&g.out, "%s.Define(class_%d)\n", g.global(decl.Name), n)
+	} else {
+		fmt.Fprintf(&g.out, "%s = class_%d\n", local(decl.Name), n)
+	}
+	g.out.WriteString("}\n")
+	return nil
+}
+
+// binaryFunctions are the loxrt functions implementing
+// the binary operators.
+var binaryFunctions = map[lang.TokenType]string{
+	lang.PlusToken:         "Add",
+	lang.MinusToken:        "Subtract",
+	lang.StarToken:         "Multiply",
+	lang.SlashToken:        "Divide",
+	lang.GreaterToken:      "Greater",
+	lang.GreaterEqualToken: "GreaterEqual",
+	lang.LessToken:         "Less",
+	lang.LessEqualToken:    "LessEqual",
+}
+
+// expression returns the Go code of an expression.
+func (g *goWriter) expression(expr lang.Expr) (string, error) {
+
+	switch e := expr.(type) {
+	case *lang.AssignExpr:
+		value, err := g.expression(e.Value)
+		if err != nil {
+			return "", err
+		}
+		if isLocal(e.Binding) {
+			return fmt.Sprintf("loxrt.Store(&%s, %s)", local(e.Name), value), nil
+		}
+		return fmt.Sprintf("%s.Set(%s, %d)", g.global(e.Name), value, e.Name.Line), nil
+	case *lang.BinaryExpr:
+		left, err := g.expression(e.LeftExpression)
+		if err != nil {
+			return "", err
+		}
+		right, err := g.expression(e.RightExpression)
+		if err != nil {
+			return "", err
+		}
+		switch e.Operator.Type {
+		case lang.EqualEqualToken:
+			return fmt.Sprintf("loxrt.Equal(%s, %s)", left, right), nil
+		case lang.BangEqualToken:
+			return fmt.Sprintf("loxrt.NotEqual(%s, %s)", left, right), nil
+		}
+		function, ok := binaryFunctions[e.Operator.Type]
+		if !ok {
+			return "", fmt.Errorf("unexpected operator %s", e.Operator.Lexeme)
+		}
+		return fmt.Sprintf("loxrt.%s(%s, %s, %d)", function, left, right, e.Operator.Line), nil
+	case *lang.CallExpr:
+		callee, err := g.expression(e.Callee)
+		if err != nil {
+			return "", err
+		}
+		var args bytes.Buffer
+		for _, arg := range e.Arguments {
+			value, err := g.expression(arg)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&args, ", %s", value)
+		}
+		return fmt.Sprintf("loxrt.Call(%s, %d%s)", callee, e.Paren.Line, args.String()), nil
+	case *lang.GetExpr:
+		object, err := g.expression(e.Object)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("loxrt.Get(%s, %q, %d)", object, e.Name.Lexeme, e.Name.Line), nil
+	case *lang.GroupingExpr:
+		return g.expression(e.Expression)
+	case *lang.Lit:
+		return goLiteral(e.Value)
+	case *lang.LogicalExpr:
+		left, err := g.expression(e.LeftExpression)
+		if err != nil {
+			return "", err
+		}
+		right, err := g.expression(e.RightExpression)
+		if err != nil {
+			return "", err
+		}
+		function := "And"
+		if e.Operator.Type == lang.OrToken {
+			function = "Or"
+		}
+		return fmt.Sprintf("loxrt.%s(%s, func() loxrt.Value { return %s })",
+			function, left, right), nil
+	case *lang.SetExpr:
+		object, err := g.expression(e.Object)
+		if err != nil {
+			return "", err
+		}
+		value, err := g.expression(e.Value)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("loxrt.Set(loxrt.Object(%s, %d), %q, %s)",
+			object, e.Name.Line, e.Name.Lexeme, value), nil
+	case *lang.SuperExpr:
+		if len(g.classes) == 0 {
+			return "", fmt.Errorf("[line %d] 'super' outside of a class", e.Keyword.Line)
+		}
+		return fmt.Sprintf("loxrt.Super(super_%d, this, %q, %d)",
+			g.classes[len(g.classes)-1], e.Method.Lexeme, e.Method.Line), nil
+	case *lang.ThisExpr:
+		return "this", nil
+	case *lang.UnaryExpr:
+		operand, err := g.expression(e.Expression)
+		if err != nil {
+			return "", err
+		}
+		if e.Operator.Type == lang.BangToken {
+			return fmt.Sprintf("loxrt.Not(%s)", operand), nil
+		}
+		return fmt.Sprintf("loxrt.Negate(%s, %d)", operand, e.Operator.Line), nil
+	case *lang.VarExpr:
+		if isLocal(e.Binding) {
+			return local(e.Name), nil
+		}
+		return fmt.Sprintf("%s.Get(%d)", g.global(e.Name), e.Name.Line), nil
+	default:
+		return "", fmt.Errorf("unexpected expression %v", expr)
+	}
+}
+
+// goLiteral returns the Go code of a literal value. The numbers
+// are converted to float64 explicitly since the untyped integer
+// constants default to int.
+func goLiteral(value interface{}) (string, error) {
+
+	switch v := value.(type) {
+	case nil:
+		return "nil", nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return "float64(" + strconv.FormatFloat(v, 'g', -1, 64) + ")", nil
+	case string:
+		return strconv.Quote(v), nil
+	default:
+		return "", fmt.Errorf("unexpected literal %v", value)
+	}
+}
+
+// isLocal reports if the binding is a local variable of the
+// function or one of the enclosing functions, which are all
+// Go variables.
+func isLocal(binding lang.Binding) bool {
+
+	return binding.Local || binding.Upvalue
+}
+
+// global returns the name of the Go variable of a lox global
+// variable, declared at the end of the program.
+func (g *goWriter) global(name *lang.Token) string {
+
+	if !g.seen[name.Lexeme] {
+		if g.seen == nil {
+			g.seen = map[string]bool{}
+		}
+		g.seen[name.Lexeme] = true
+		g.globals = append(g.globals, name.Lexeme)
+	}
+	return "g_" + name.Lexeme
+}
+
+// local returns the name of the Go variable of a lox local
+// variable. The prefix avoids conflicts with the Go keywords
+// and the variables of the generated code.
+func local(name *lang.Token) string {
+
+	return "v_" + name.Lexeme
+}
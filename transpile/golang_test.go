@@ -0,0 +1,150 @@
+package transpile
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rmonnet/glox/interp"
+	"github.com/rmonnet/glox/lang"
+)
+
+func TestGo(t *testing.T) {
+
+	statements := resolve(t, `var a = 1;
+{
+  var b = a;
+  b = b + 1;
+  print b and "yes";
+}`)
+	expected := `// Code generated by glox transpile from test.lox. DO NOT EDIT.
+
+package main
+
+import "github.com/rmonnet/glox/loxrt"
+
+func main() {
+	loxrt.Run(func() {
+		g_a.Define(float64(1))
+		{
+			var v_b loxrt.Value = g_a.Get(3)
+			_ = v_b
+			v_b = loxrt.Add(v_b, float64(1), 4)
+			loxrt.Print(loxrt.And(v_b, func() loxrt.Value { return "yes" }))
+		}
+	})
+}
+
+var (
+	g_a = loxrt.NewGlobal("a")
+)
+`
+	var out strings.Builder
+	if err := Go(&out, "test.lox", statements); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if out.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+// goScripts are run by the interpreter and as Go programs,
+// which must print the same output and report the same errors.
+var goScripts = []string{
+	`print 1 + 2 * 3 - 4 / 2; print "a" + 1; print 1 + "a" + true + nil;
+	 print -(3); print !nil; print 1 < 2; print 2 >= 3; print 0.1 + 0.2;
+	 print "a" != "a"; print nil == false; print nil or "default"; print false and 1;`,
+	`var a = 1;
+	 { var a = 2; { var a = 3; print a; } print a; }
+	 a = 4; print a; var b; print b;
+	 for (var i = 0; i < 3; i = i + 1) { if (i == 1) print "one"; else print i; }`,
+	`for (var i = 0; ; i = i + 1) { if (i == 2) break; print i; }
+	 while (true) { { var x = "block"; print x; break; } }`,
+	`fun makeCounter() {
+		var count = 0;
+		fun counter() { count = count + 1; return count; }
+		return counter;
+	 }
+	 var c1 = makeCounter(); var c2 = makeCounter();
+	 print c1(); print c1(); print c2(); print c1; print clock;
+	 fun later() { return global; }
+	 var global = "late";
+	 print later();`,
+	`class A {
+		init(name) { this.name = name; }
+		method() { return "A " + this.name; }
+	 }
+	 class B < A {
+		init(name) { super.init(name + "!"); return; }
+		method() { var m = super.method; return "B then " + m(); }
+	 }
+	 var b = B("b");
+	 print b.method(); print b; print B; print b.init("c").name;
+	 print deepEquals(A("x"), A("x"));`,
+	`class A { m() {} n() {} }
+	 class B < A {}
+	 var a = A(); var b = B(); var m = a.m;
+	 print a.m == a.m; print a.m == m; print a.m != a.n; print a.m == A().m;
+	 print b.m == b.m; print b.m == a.m; print a.m == a; print a == a.m; print clock == clock;`,
+	`print undefined;`,
+	`var a = "a"; print -a;`,
+	`print 1 / 0;`,
+	`fun f(a) {} f(1, 2);`,
+	`class A {} print A().missing;`,
+	`fun recurse() { recurse(); } recurse();`,
+}
+
+func TestGoRun(t *testing.T) {
+
+	if testing.Short() {
+		t.Skip("builds Go programs")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not found")
+	}
+
+	// the programs are built inside the module,
+	// where they can import the runtime.
+	dir, err := ioutil.TempDir(".", "testdata-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for n, script := range goScripts {
+		expected := &strings.Builder{}
+		interp.New(expected, expected).Run(script, false)
+
+		program := filepath.Join(dir, "main.go")
+		f, err := os.Create(program)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = Go(f, "test.lox", resolve(t, script))
+		f.Close()
+		if err != nil {
+			t.Fatalf("script %d: unexpected error %v", n, err)
+		}
+		actual, _ := exec.Command("go", "run", "./"+dir).CombinedOutput()
+		// go run reports the exit status of the program.
+		output := strings.TrimSuffix(string(actual), "exit status 70\n")
+		if output != expected.String() {
+			t.Errorf("%s\nexpected:\n%s\ngot:\n%s", script, expected, output)
+		}
+	}
+}
+
+// resolve parses and resolves a script, failing the test on errors.
+func resolve(t *testing.T, script string) []lang.Stmt {
+
+	t.Helper()
+	var errors strings.Builder
+	statements, ok := interp.New(&errors, &errors).Resolve(script)
+	if !ok {
+		t.Fatalf("unexpected errors %s", errors.String())
+	}
+	return statements
+}
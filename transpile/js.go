@@ -0,0 +1,816 @@
+package transpile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/rmonnet/glox/lang"
+)
+
+// JS writes a JavaScript program implementing the script to out.
+// The program runs in the browsers and in node. It keeps the
+// structure of the script: the functions become JS functions, the
+// classes ES classes and the local variables "let" declarations,
+// so the closures work the same way. The operators are implemented
+// by small helper functions (like $add) checking the operands and
+// reporting the runtime errors like the interpreter (with the
+// default options, and without the name suggestions).
+// Reading a global before its declaration is executed raises a JS
+// ReferenceError instead of a lox runtime error.
+func JS(out io.Writer, name string, statements []lang.Stmt) error {
+
+	j := &jsWriter{declared: map[string]bool{}, defined: map[string]bool{},
+		globalRefs: map[string]bool{}}
+	for _, native := range []string{"clock", "isNaN", "isFinite", "deepEquals"} {
+		j.defined[native] = true
+		j.declared[native] = true
+	}
+	// the globals declared anywhere in the script may be referenced
+	// by the functions before their declaration.
+	for _, stmt := range statements {
+		switch s := stmt.(type) {
+		case *lang.VarDeclStmt:
+			j.defined[s.Name.Lexeme] = true
+		case *lang.FunDeclStmt:
+			j.defined[s.Name.Lexeme] = true
+		case *lang.ClassDeclStmt:
+			j.defined[s.Name.Lexeme] = true
+		}
+	}
+	lang.Walk(statements, func(node lang.Node, depth int) {
+		switch n := node.(type) {
+		case *lang.VarExpr:
+			j.globalRefs[n.Name.Lexeme] = j.globalRefs[n.Name.Lexeme] || !isLocal(n.Binding)
+		case *lang.AssignExpr:
+			j.globalRefs[n.Name.Lexeme] = j.globalRefs[n.Name.Lexeme] || !isLocal(n.Binding)
+		}
+	})
+
+	fmt.Fprintf(&j.out, "// Code generated by glox transpile from %s. DO NOT EDIT.\n\n", name)
+	j.out.WriteString("(function () {\n")
+	j.indent++
+	j.line(`"use strict";`)
+	j.out.WriteString("\n")
+	for _, line := range strings.Split(strings.TrimSpace(jsRuntime), "\n") {
+		if line == "" {
+			j.out.WriteString("\n")
+		} else {
+			j.line("%s", line)
+		}
+	}
+	j.out.WriteString("\n")
+	j.line("$run(() => {")
+	j.indent++
+	for _, stmt := range statements {
+		if err := j.statement(stmt); err != nil {
+			return err
+		}
+	}
+	j.indent--
+	j.line("});")
+	j.indent--
+	j.out.WriteString("})();\n")
+
+	_, err := out.Write(j.out.Bytes())
+	return err
+}
+
+// jsWriter accumulates the JavaScript code of a script.
+type jsWriter struct {
+	out    bytes.Buffer
+	indent int
+	// scopes maps the local variables of the enclosing scopes to
+	// their JavaScript names, the declarations outside of any scope
+	// are globals.
+	scopes []map[string]string
+	// defined holds the globals declared by the script and the
+	// natives, declared holds those already declared in the code.
+	// A global declared again is assigned.
+	defined  map[string]bool
+	declared map[string]bool
+	// globalRefs holds the names referenced as globals.
+	globalRefs map[string]bool
+	renamed    int
+	// initializer is true in an init method,
+	// which always returns the instance.
+	initializer bool
+	// classes holds the names of the enclosing classes.
+	classes []string
+}
+
+// line writes an indented line.
+func (j *jsWriter) line(format string, args ...interface{}) {
+
+	j.out.WriteString(strings.Repeat("  ", j.indent))
+	fmt.Fprintf(&j.out, format, args...)
+	j.out.WriteString("\n")
+}
+
+// block writes the statements of a block in a new scope,
+// between braces opened by the caller.
+func (j *jsWriter) block(statements []lang.Stmt, close string) error {
+
+	j.scopes = append(j.scopes, map[string]string{})
+	err := j.statements(statements, close)
+	j.scopes = j.scopes[:len(j.scopes)-1]
+	return err
+}
+
+// statements writes indented statements followed by
+// the closing line.
+func (j *jsWriter) statements(statements []lang.Stmt, close string) error {
+
+	j.indent++
+	for _, stmt := range statements {
+		if err := j.statement(stmt); err != nil {
+			return err
+		}
+	}
+	j.indent--
+	j.line("%s", close)
+	return nil
+}
+
+// body writes the branch of an if or the body of a while
+// in braces.
+func (j *jsWriter) body(stmt lang.Stmt, close string) error {
+
+	if block, ok := stmt.(*lang.BlockStmt); ok {
+		return j.block(block.Statements, close)
+	}
+	return j.block([]lang.Stmt{stmt}, close)
+}
+
+// declareGlobal returns how the declaration of a global starts:
+// with the keyword the first time, nothing when it is declared
+// again and assigned.
+func (j *jsWriter) declareGlobal(name *lang.Token, keyword string) string {
+
+	if j.declared[name.Lexeme] {
+		return ""
+	}
+	j.declared[name.Lexeme] = true
+	return keyword + " "
+}
+
+// declareLocal declares a local variable in the current scope and
+// returns its JavaScript name. The JavaScript variables are visible
+// in their whole block, before their declaration, while the lox
+// variables are visible after it: a variable which may hide a global
+// or a variable of an enclosing scope is renamed (like a$1).
+func (j *jsWriter) declareLocal(name *lang.Token) string {
+
+	js := jsName(name.Lexeme)
+	if _, ok := j.lookup(name.Lexeme); ok || j.globalRefs[name.Lexeme] {
+		j.renamed++
+		js = fmt.Sprintf("%s$%d", name.Lexeme, j.renamed)
+	}
+	j.scopes[len(j.scopes)-1][name.Lexeme] = js
+	return js
+}
+
+// lookup returns the JavaScript name of the innermost local
+// variable declared with the name, like the resolver.
+func (j *jsWriter) lookup(name string) (string, bool) {
+
+	for n := len(j.scopes) - 1; n >= 0; n-- {
+		if js, ok := j.scopes[n][name]; ok {
+			return js, true
+		}
+	}
+	return "", false
+}
+
+// variable returns the JavaScript name of a variable reference,
+// or false for a global which is never declared.
+func (j *jsWriter) variable(name *lang.Token, binding lang.Binding) (string, bool) {
+
+	if isLocal(binding) {
+		if js, ok := j.lookup(name.Lexeme); ok {
+			return js, true
+		}
+	}
+	return jsName(name.Lexeme), j.defined[name.Lexeme]
+}
+
+// statement writes the JavaScript code of a statement.
+func (j *jsWriter) statement(stmt lang.Stmt) error {
+
+	switch s := stmt.(type) {
+	case *lang.BlockStmt:
+		j.line("{")
+		return j.block(s.Statements, "}")
+	case *lang.ClassDeclStmt:
+		return j.class(s)
+	case *lang.ExprStmt:
+		expr, err := j.expression(s.Expression)
+		if err != nil {
+			return err
+		}
+		j.line("%s;", expr)
+	case *lang.FunDeclStmt:
+		return j.function(s)
+	case *lang.IfStmt:
+		condition, err := j.expression(s.Condition)
+		if err != nil {
+			return err
+		}
+		j.line("if ($truthy(%s)) {", condition)
+		if s.ElseBranch == nil {
+			return j.body(s.ThenBranch, "}")
+		}
+		if err := j.body(s.ThenBranch, "} else {"); err != nil {
+			return err
+		}
+		return j.body(s.ElseBranch, "}")
+	case *lang.BreakStmt:
+		j.line("break;")
+	case *lang.PrintStmt:
+		expr, err := j.expression(s.Expression)
+		if err != nil {
+			return err
+		}
+		j.line("$print(%s);", expr)
+	case *lang.ReturnStmt:
+		if j.initializer {
+			j.line("return this;")
+		} else if s.Value == nil {
+			j.line("return;")
+		} else {
+			value, err := j.expression(s.Value)
+			if err != nil {
+				return err
+			}
+			j.line("return %s;", value)
+		}
+	case *lang.VarDeclStmt:
+		value := "null"
+		if s.Initializer != nil {
+			var err error
+			if value, err = j.expression(s.Initializer); err != nil {
+				return err
+			}
+		}
+		if len(j.scopes) == 0 {
+			j.line("%s%s = %s;", j.declareGlobal(s.Name, "let"), jsName(s.Name.Lexeme), value)
+		} else {
+			j.line("let %s = %s;", j.declareLocal(s.Name), value)
+		}
+	case *lang.WhileStmt:
+		condition, err := j.expression(s.Condition)
+		if err != nil {
+			return err
+		}
+		j.line("while ($truthy(%s)) {", condition)
+		return j.body(s.Body, "}")
+	default:
+		return fmt.Errorf("unexpected statement %v", stmt)
+	}
+	return nil
+}
+
+// function writes a function declaration. The local functions are
+// arrow functions, which are not hoisted like the lox functions and
+// keep "this" and "super" in the methods.
+func (j *jsWriter) function(decl *lang.FunDeclStmt) error {
+
+	close := "}"
+	if len(j.scopes) == 0 {
+		name := jsName(decl.Name.Lexeme)
+		if j.declareGlobal(decl.Name, "function") == "" {
+			j.line("%s = function %[1]s(%s) {", name, j.params(decl.Params))
+			close = "};"
+		} else {
+			j.line("function %s(%s) {", name, j.params(decl.Params))
+		}
+	} else {
+		// the function is declared before its body which may call it.
+		name := j.declareLocal(decl.Name)
+		if name == jsName(decl.Name.Lexeme) {
+			j.line("const %s = (%s) => {", name, j.params(decl.Params))
+			close = "};"
+		} else {
+			// the renamed functions keep the lox name when printed.
+			j.line("const %s = $named(%q, (%s) => {", name, decl.Name.Lexeme, j.params(decl.Params))
+			close = "});"
+		}
+	}
+
+	enclosing := j.initializer
+	j.initializer = false
+	err := j.statements(decl.Body, close)
+	j.scopes = j.scopes[:len(j.scopes)-1]
+	j.initializer = enclosing
+	return err
+}
+
+// params opens the scope of a function and declares its parameters.
+// It returns the parameter list.
+func (j *jsWriter) params(params []*lang.Token) string {
+
+	j.scopes = append(j.scopes, map[string]string{})
+	names := make([]string, len(params))
+	for n, param := range params {
+		names[n] = j.declareLocal(param)
+	}
+	return strings.Join(names, ", ")
+}
+
+// class writes a class declaration as an ES class. The lox classes
+// extend $Instance, which lets the helpers tell them from the
+// functions.
+func (j *jsWriter) class(decl *lang.ClassDeclStmt) error {
+
+	superclass := "$Instance"
+	if decl.Superclass != nil {
+		value, err := j.expression(decl.Superclass)
+		if err != nil {
+			return err
+		}
+		superclass = fmt.Sprintf("$superclass(%s, %d)", value, decl.Superclass.Name.Line)
+	}
+	// the ES class is named like the lox class, the name of the
+	// variable holding it may differ.
+	name := jsName(decl.Name.Lexeme)
+	close := "}"
+	if len(j.scopes) == 0 {
+		if j.declareGlobal(decl.Name, "class") == "" {
+			j.line("%s = class %[1]s extends %s {", name, superclass)
+			close = "};"
+		} else {
+			j.line("class %s extends %s {", name, superclass)
+		}
+	} else if variable := j.declareLocal(decl.Name); variable != name {
+		j.line("const %s = class %s extends %s {", variable, name, superclass)
+		close = "};"
+	} else {
+		j.line("class %s extends %s {", name, superclass)
+	}
+
+	j.indent++
+	j.classes = append(j.classes, name)
+	enclosing := j.initializer
+	for n, method := range decl.Methods {
+		if n > 0 {
+			j.out.WriteString("\n")
+		}
+		methodName := method.Name.Lexeme
+		// a method named "constructor" must not become
+		// the constructor of the ES class.
+		if methodName == "constructor" {
+			methodName = `["constructor"]`
+		}
+		j.line("%s(%s) {", methodName, j.params(method.Params))
+		j.initializer = method.Name.Lexeme == "init"
+		body := method.Body
+		if j.initializer {
+			body = append(body[:len(body):len(body)],
+				&lang.ReturnStmt{Keyword: method.Name})
+		}
+		err := j.statements(body, "}")
+		j.scopes = j.scopes[:len(j.scopes)-1]
+		if err != nil {
+			return err
+		}
+	}
+	j.initializer = enclosing
+	j.classes = j.classes[:len(j.classes)-1]
+	j.indent--
+	j.line("%s", close)
+	return nil
+}
+
+// jsBinaryFunctions are the helpers implementing the binary
+// operators.
+var jsBinaryFunctions = map[lang.TokenType]string{
+	lang.PlusToken:         "$add",
+	lang.MinusToken:        "$subtract",
+	lang.StarToken:         "$multiply",
+	lang.SlashToken:        "$divide",
+	lang.GreaterToken:      "$greater",
+	lang.GreaterEqualToken: "$greaterEqual",
+	lang.LessToken:         "$less",
+	lang.LessEqualToken:    "$lessEqual",
+}
+
+// expression returns the JavaScript code of an expression.
+func (j *jsWriter) expression(expr lang.Expr) (string, error) {
+
+	switch e := expr.(type) {
+	case *lang.AssignExpr:
+		value, err := j.expression(e.Value)
+		if err != nil {
+			return "", err
+		}
+		name, ok := j.variable(e.Name, e.Binding)
+		if !ok {
+			// the value is evaluated before the error is reported.
+			return fmt.Sprintf("$undefined(%q, %d, %s)", e.Name.Lexeme, e.Name.Line, value), nil
+		}
+		return fmt.Sprintf("%s = %s", name, value), nil
+	case *lang.BinaryExpr:
+		left, err := j.operand(e.LeftExpression)
+		if err != nil {
+			return "", err
+		}
+		right, err := j.operand(e.RightExpression)
+		if err != nil {
+			return "", err
+		}
+		switch e.Operator.Type {
+		case lang.EqualEqualToken:
+			return fmt.Sprintf("$equal(%s, %s)", left, right), nil
+		case lang.BangEqualToken:
+			return fmt.Sprintf("!$equal(%s, %s)", left, right), nil
+		}
+		function, ok := jsBinaryFunctions[e.Operator.Type]
+		if !ok {
+			return "", fmt.Errorf("unexpected operator %s", e.Operator.Lexeme)
+		}
+		return fmt.Sprintf("%s(%s, %s, %d)", function, left, right, e.Operator.Line), nil
+	case *lang.CallExpr:
+		var args strings.Builder
+		for _, arg := range e.Arguments {
+			value, err := j.expression(arg)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&args, ", %s", value)
+		}
+		if get, ok := e.Callee.(*lang.GetExpr); ok {
+			object, err := j.expression(get.Object)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("$invoke(%s, %q, %d%s)", object, get.Name.Lexeme,
+				e.Paren.Line, args.String()), nil
+		}
+		callee, err := j.expression(e.Callee)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("$call(%s, %d%s)", callee, e.Paren.Line, args.String()), nil
+	case *lang.GetExpr:
+		object, err := j.expression(e.Object)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("$get(%s, %q, %d)", object, e.Name.Lexeme, e.Name.Line), nil
+	case *lang.GroupingExpr:
+		return j.expression(e.Expression)
+	case *lang.Lit:
+		return jsLiteral(e.Value)
+	case *lang.LogicalExpr:
+		left, err := j.expression(e.LeftExpression)
+		if err != nil {
+			return "", err
+		}
+		right, err := j.expression(e.RightExpression)
+		if err != nil {
+			return "", err
+		}
+		function := "$and"
+		if e.Operator.Type == lang.OrToken {
+			function = "$or"
+		}
+		return fmt.Sprintf("%s(%s, () => %s)", function, left, right), nil
+	case *lang.SetExpr:
+		object, err := j.expression(e.Object)
+		if err != nil {
+			return "", err
+		}
+		value, err := j.expression(e.Value)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("$object(%s, %d).%s = %s", object, e.Name.Line,
+			e.Name.Lexeme, value), nil
+	case *lang.SuperExpr:
+		if len(j.classes) == 0 {
+			return "", fmt.Errorf("[line %d] 'super' outside of a class", e.Keyword.Line)
+		}
+		return fmt.Sprintf("$super(%s, this, %q, %d)", j.classes[len(j.classes)-1],
+			e.Method.Lexeme, e.Method.Line), nil
+	case *lang.ThisExpr:
+		return "this", nil
+	case *lang.UnaryExpr:
+		operand, err := j.expression(e.Expression)
+		if err != nil {
+			return "", err
+		}
+		if e.Operator.Type == lang.BangToken {
+			return fmt.Sprintf("!$truthy(%s)", operand), nil
+		}
+		return fmt.Sprintf("$negate(%s, %d)", operand, e.Operator.Line), nil
+	case *lang.VarExpr:
+		name, ok := j.variable(e.Name, e.Binding)
+		if !ok {
+			return fmt.Sprintf("$undefined(%q, %d)", e.Name.Lexeme, e.Name.Line), nil
+		}
+		return name, nil
+	default:
+		return "", fmt.Errorf("unexpected expression %v", expr)
+	}
+}
+
+// operand returns the JavaScript code of the operand of an equality,
+// in parentheses if it is an operation itself.
+func (j *jsWriter) operand(expr lang.Expr) (string, error) {
+
+	code, err := j.expression(expr)
+	if err != nil {
+		return "", err
+	}
+	switch e := expr.(type) {
+	case *lang.AssignExpr, *lang.SetExpr:
+		return "(" + code + ")", nil
+	case *lang.BinaryExpr:
+		if e.Operator.Type == lang.EqualEqualToken || e.Operator.Type == lang.BangEqualToken {
+			return "(" + code + ")", nil
+		}
+	case *lang.UnaryExpr:
+		if e.Operator.Type == lang.BangToken {
+			return "(" + code + ")", nil
+		}
+	}
+	return code, nil
+}
+
+// jsLiteral returns the JavaScript code of a literal value.
+func jsLiteral(value interface{}) (string, error) {
+
+	switch v := value.(type) {
+	case nil:
+		return "null", nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case string:
+		var b bytes.Buffer
+		encoder := json.NewEncoder(&b)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(v); err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(b.String(), "\n"), nil
+	default:
+		return "", fmt.Errorf("unexpected literal %v", value)
+	}
+}
+
+// jsReserved are the JavaScript keywords and the names which can't
+// be declared in strict mode. They are valid lox identifiers.
+var jsReserved = map[string]bool{
+	"arguments": true, "await": true, "break": true, "case": true,
+	"catch": true, "const": true, "continue": true, "debugger": true,
+	"default": true, "delete": true, "do": true, "enum": true,
+	"eval": true, "export": true, "extends": true, "finally": true,
+	"function": true, "implements": true, "import": true, "in": true,
+	"Infinity": true, "instanceof": true, "interface": true, "let": true,
+	"NaN": true, "new": true, "null": true, "package": true,
+	"private": true, "protected": true, "public": true, "static": true,
+	"switch": true, "throw": true, "try": true, "typeof": true,
+	"undefined": true, "void": true, "with": true, "yield": true,
+}
+
+// jsName returns the JavaScript name of a lox variable,
+// followed by "_" if it is reserved in JavaScript.
+func jsName(name string) string {
+
+	if jsReserved[name] {
+		return name + "_"
+	}
+	return name
+}
+
+// jsRuntime implements the lox values and operators,
+// it is copied at the beginning of the programs.
+const jsRuntime = `
+class $LoxError extends Error {}
+
+function $error(line, message) {
+  throw new $LoxError("[line " + line + "] " + message);
+}
+
+function $run(script) {
+  try {
+    script();
+  } catch (e) {
+    if (!(e instanceof $LoxError)) throw e;
+    console.error(e.message);
+    if (typeof process !== "undefined") process.exitCode = 70;
+  }
+}
+
+function $undefined(name, line) {
+  $error(line, "Undefined variable '" + name + "'.");
+}
+
+function $truthy(value) {
+  return value !== null && value !== false;
+}
+
+function $and(left, right) {
+  return $truthy(left) ? right() : left;
+}
+
+function $or(left, right) {
+  return $truthy(left) ? left : right();
+}
+
+function $number(value, line) {
+  if (typeof value !== "number") $error(line, "Operand must be a number.");
+  return value;
+}
+
+function $negate(value, line) {
+  return -$number(value, line);
+}
+
+function $add(left, right, line) {
+  if (typeof left === "number" && typeof right === "number") return left + right;
+  if (typeof left !== "string" && typeof right !== "string") {
+    $error(line, "Operands must be two numbers or at least one string.");
+  }
+  return $string(left) + $string(right);
+}
+
+function $subtract(left, right, line) {
+  return $number(left, line) - $number(right, line);
+}
+
+function $multiply(left, right, line) {
+  return $number(left, line) * $number(right, line);
+}
+
+function $divide(left, right, line) {
+  const dividend = $number(left, line);
+  const divisor = $number(right, line);
+  if (divisor === 0) $error(line, "Division by zero.");
+  return dividend / divisor;
+}
+
+function $greater(left, right, line) {
+  return $number(left, line) > $number(right, line);
+}
+
+function $greaterEqual(left, right, line) {
+  return $number(left, line) >= $number(right, line);
+}
+
+function $less(left, right, line) {
+  return $number(left, line) < $number(right, line);
+}
+
+function $lessEqual(left, right, line) {
+  return $number(left, line) <= $number(right, line);
+}
+
+class $Instance {
+  constructor() {
+    Object.defineProperty(this, "$class", { value: new.target });
+  }
+}
+
+function $isClass(value) {
+  return typeof value === "function" && value.prototype instanceof $Instance;
+}
+
+function $superclass(value, line) {
+  if (!$isClass(value)) $error(line, "Superclass must be a class.");
+  return value;
+}
+
+function $method(prototype, name) {
+  for (; prototype !== $Instance.prototype; prototype = Object.getPrototypeOf(prototype)) {
+    if (Object.prototype.hasOwnProperty.call(prototype, name)) {
+      const method = prototype[name];
+      if (typeof method === "function" && !$isClass(method)) return method;
+    }
+  }
+  return null;
+}
+
+function $bind(instance, method) {
+  const bound = (...args) => method.apply(instance, args);
+  Object.defineProperty(bound, "length", { value: method.length });
+  bound.$name = method.name;
+  bound.$method = method;
+  bound.$this = instance;
+  return bound;
+}
+
+// the values are never undefined and NaN is not equal to itself,
+// like in lox. The bound methods are equal if they bind the same
+// method to the same instance.
+function $equal(left, right) {
+  if (typeof left === "function" && left.$method !== undefined &&
+      typeof right === "function") {
+    return left.$method === right.$method && left.$this === right.$this;
+  }
+  return left === right;
+}
+
+let $depth = 0;
+
+function $call(callee, line, ...args) {
+  let fn = callee;
+  let instance = null;
+  if ($isClass(callee)) {
+    instance = new callee();
+    fn = $method(callee.prototype, "init");
+    fn = fn ? $bind(instance, fn) : () => instance;
+  } else if (typeof callee !== "function") {
+    $error(line, "Can only call functions and classes.");
+  }
+  if (args.length !== fn.length) {
+    $error(line, "Expected " + fn.length + " arguments but got " + args.length + ".");
+  }
+  if ($depth >= 1000) $error(line, "Stack overflow.");
+  $depth++;
+  const result = fn(...args);
+  $depth--;
+  return instance !== null ? instance : result === undefined ? null : result;
+}
+
+function $object(value, line) {
+  if (!(value instanceof $Instance)) $error(line, "Only class instances have fields.");
+  return value;
+}
+
+function $get(object, name, line) {
+  const instance = $object(object, line);
+  if (Object.prototype.hasOwnProperty.call(instance, name)) return instance[name];
+  const method = $method(Object.getPrototypeOf(instance), name);
+  if (method === null) $error(line, "Undefined field or method '" + name + "'.");
+  return $bind(instance, method);
+}
+
+function $invoke(object, name, line, ...args) {
+  return $call($get(object, name, line), line, ...args);
+}
+
+function $super(klass, instance, name, line) {
+  const method = $method(Object.getPrototypeOf(klass.prototype), name);
+  if (method === null) $error(line, "Undefined method '" + name + "'.");
+  return $bind(instance, method);
+}
+
+function $numberString(n) {
+  if (Number.isNaN(n)) return "nan";
+  if (n === Infinity) return "inf";
+  if (n === -Infinity) return "-inf";
+  if (Object.is(n, -0)) return "-0";
+  // like the %v format of Go.
+  const [mantissa, exponent] = n.toExponential().split("e");
+  const exp = Number(exponent);
+  if (exp >= -4 && exp < 6) return String(n);
+  const digits = String(Math.abs(exp)).padStart(2, "0");
+  return mantissa + "e" + (exp < 0 ? "-" : "+") + digits;
+}
+
+function $string(value) {
+  if (value === null || value === undefined) return "nil";
+  if (typeof value === "number") return $numberString(value);
+  if (typeof value === "string" || typeof value === "boolean") return String(value);
+  if (value instanceof $Instance) return "<instance " + value.$class.name + ">";
+  if ($isClass(value)) return "<class " + value.name + ">";
+  if (value.$native) return "<native fun>";
+  return "<fun " + (value.$name || value.name) + ">";
+}
+
+function $print(value) {
+  console.log($string(value));
+}
+
+function $named(name, fn) {
+  fn.$name = name;
+  return fn;
+}
+
+function $native(fn) {
+  fn.$native = true;
+  return fn;
+}
+
+function $deepEqual(left, right, compared) {
+  if (!(left instanceof $Instance)) return left === right;
+  if (!(right instanceof $Instance)) return false;
+  if (left === right || compared.some(([l, r]) => l === left && r === right)) return true;
+  const names = Object.keys(left);
+  if (left.$class !== right.$class || names.length !== Object.keys(right).length) return false;
+  compared.push([left, right]);
+  return names.every((name) => Object.prototype.hasOwnProperty.call(right, name) &&
+    $deepEqual(left[name], right[name], compared));
+}
+
+let clock = $native(() => Math.floor(Date.now() / 1000));
+let isNaN = $native((value) => Number.isNaN(value));
+let isFinite = $native((value) => Number.isFinite(value));
+let deepEquals = $native((left, right) => $deepEqual(left, right, []));
+`
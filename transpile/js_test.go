@@ -0,0 +1,102 @@
+package transpile
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rmonnet/glox/interp"
+)
+
+func TestJS(t *testing.T) {
+
+	statements := resolve(t, `var a = 1;
+{
+  var b = a;
+  fun f(a) { return a == b; }
+  print f(2);
+}`)
+	// the runtime prelude is not compared.
+	expected := `  $run(() => {
+    let a = 1;
+    {
+      let b = a;
+      const f = (a$1) => {
+        return $equal(a$1, b);
+      };
+      $print($call(f, 5, 2));
+    }
+  });
+})();
+`
+	var out strings.Builder
+	if err := JS(&out, "test.lox", statements); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	code := out.String()
+	if !strings.HasPrefix(code, "// Code generated by glox transpile from test.lox. DO NOT EDIT.\n") {
+		t.Errorf("missing header in:\n%s", code)
+	}
+	if start := strings.Index(code, "  $run(() => {\n"); start < 0 || code[start:] != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, code)
+	}
+}
+
+// jsScripts are the scripts checked with node in addition to goScripts.
+var jsScripts = []string{
+	`var a = "global";
+	 {
+		fun showA() { print a; }
+		showA();
+		var a = "block";
+		showA();
+		print a;
+	 }`,
+	`{
+		class A { constructor() { return "method"; } }
+		var B = A;
+		{
+			class A < B {}
+			print A; print A().constructor();
+		}
+	 }`,
+}
+
+func TestJSRun(t *testing.T) {
+
+	if testing.Short() {
+		t.Skip("runs node")
+	}
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("node command not found")
+	}
+
+	dir, err := ioutil.TempDir("", "glox-js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for n, script := range append(goScripts[:len(goScripts):len(goScripts)], jsScripts...) {
+		expected := &strings.Builder{}
+		interp.New(expected, expected).Run(script, false)
+
+		program := filepath.Join(dir, "test.js")
+		f, err := os.Create(program)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = JS(f, "test.lox", resolve(t, script))
+		f.Close()
+		if err != nil {
+			t.Fatalf("script %d: unexpected error %v", n, err)
+		}
+		actual, _ := exec.Command("node", program).CombinedOutput()
+		if string(actual) != expected.String() {
+			t.Errorf("%s\nexpected:\n%s\ngot:\n%s", script, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+// glox.js loads the WebAssembly build of glox and wraps its API.
+// It needs wasm_exec.js, the support script of the Go WebAssembly
+// port, loaded first (it defines the Go class). Copy it from
+// $(go env GOROOT)/lib/wasm (misc/wasm before Go 1.24).
+//
+//   const glox = await loadGlox("glox.wasm");
+//   const result = glox.run('print "Hello, world!";');
+//   console.log(result.output, result.errors, result.exitCode);
+//
+// run() runs a script in a new interpreter, eval() runs code in a
+// session keeping the globals between the calls (like the REPL) and
+// reset() starts a new session. The options (maxSteps and backend)
+// are documented in main.go.
+
+"use strict";
+
+// loadGlox instantiates glox from the URL of glox.wasm, or from
+// its bytes (an ArrayBuffer or a typed array, for node).
+async function loadGlox(wasm = "glox.wasm") {
+  const go = new Go();
+  let result;
+  if (typeof wasm !== "string") {
+    result = await WebAssembly.instantiate(wasm, go.importObject);
+  } else if (WebAssembly.instantiateStreaming) {
+    result = await WebAssembly.instantiateStreaming(fetch(wasm), go.importObject);
+  } else {
+    const response = await fetch(wasm);
+    result = await WebAssembly.instantiate(await response.arrayBuffer(), go.importObject);
+  }
+  // run doesn't return until the Go program exits, which it
+  // never does: main sets __glox and waits for the calls.
+  go.run(result.instance);
+  const api = globalThis.__glox;
+  return {
+    run: (script, options = {}) => api.run(String(script), options),
+    eval: (code) => api.eval(String(code)),
+    reset: (options = {}) => { api.reset(options); },
+  };
+}
+
+if (typeof module !== "undefined") {
+  module.exports = { loadGlox };
+}
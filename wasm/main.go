@@ -0,0 +1,122 @@
+//go:build js && wasm
+// +build js,wasm
+
+// Command wasm is the WebAssembly build of glox, which runs the
+// scripts in a browser (or node) without a server. It exports the
+// object globalThis.__glox, wrapped by glox.js, with:
+//   - run(script, options) runs a script in a new interpreter
+//   - eval(code) runs code in a session keeping its globals
+//     between the calls, like the REPL
+//   - reset(options) starts a new session
+//
+// They return an object holding the output of the print statements
+// (output), the errors reported (errors) and the exit code of glox
+// (exitCode: 0, 65 for compile errors or 70 for runtime errors).
+// The options are maxSteps, the number of statements executed
+// before the script is stopped (the page freezes while a script
+// runs), and backend ("tree" or "vm").
+//
+// Build it with:
+//
+//	GOOS=js GOARCH=wasm go build -o wasm/glox.wasm ./wasm
+package main
+
+import (
+	"bytes"
+	"syscall/js"
+
+	"github.com/rmonnet/glox/interp"
+)
+
+// session is the interpreter of eval, with the buffers
+// capturing its output.
+type session struct {
+	interp      *interp.Interp
+	out, errOut bytes.Buffer
+}
+
+// current is the session of eval.
+var current *session
+
+// main exports the run, eval and reset functions to JavaScript
+// as the __glox object, with a session without options.
+func main() {
+
+	current = newSession(js.Undefined())
+	js.Global().Set("__glox", js.ValueOf(map[string]interface{}{
+		"run":   js.FuncOf(run),
+		"eval":  js.FuncOf(eval),
+		"reset": js.FuncOf(reset),
+	}))
+	// the functions are called after main returns,
+	// the program must keep running.
+	select {}
+}
+
+// newSession creates a session with the options (an object or
+// undefined).
+func newSession(options js.Value) *session {
+
+	s := &session{}
+	s.interp = interp.New(&s.out, &s.errOut)
+	if options.Type() != js.TypeObject {
+		return s
+	}
+	if maxSteps := options.Get("maxSteps"); maxSteps.Type() == js.TypeNumber {
+		s.interp.SetMaxSteps(maxSteps.Int())
+	}
+	if options.Get("backend").Type() == js.TypeString &&
+		options.Get("backend").String() == "vm" {
+		s.interp.SetBackend(interp.VM)
+	}
+	return s
+}
+
+// execute runs code in the session and returns its result
+// to JavaScript.
+func (s *session) execute(code string) interface{} {
+
+	s.out.Reset()
+	s.errOut.Reset()
+	s.interp.Run(code, false)
+	exitCode := 0
+	if s.interp.LastRunFailed() {
+		exitCode = 65
+		if s.interp.RuntimeError() != nil {
+			exitCode = 70
+		}
+	}
+	return map[string]interface{}{
+		"output":   s.out.String(),
+		"errors":   s.errOut.String(),
+		"exitCode": exitCode,
+	}
+}
+
+// argument returns the argument n of a call, undefined if missing.
+func argument(args []js.Value, n int) js.Value {
+
+	if n < len(args) {
+		return args[n]
+	}
+	return js.Undefined()
+}
+
+// run implements __glox.run(script, options).
+func run(this js.Value, args []js.Value) interface{} {
+
+	return newSession(argument(args, 1)).execute(argument(args, 0).String())
+}
+
+// eval implements __glox.eval(code).
+func eval(this js.Value, args []js.Value) interface{} {
+
+	return current.execute(argument(args, 0).String())
+}
+
+// reset implements __glox.reset(options).
+func reset(this js.Value, args []js.Value) interface{} {
+
+	current = newSession(argument(args, 0))
+	return nil
+}